@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/backup"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// runCommand executes a utility subcommand such as hash-password
+// Returns false if name is not a subcommand, so the server starts normally
+func runCommand(name string) (exitCode int, ok bool) {
+	switch name {
+	case "hash-password":
+		return runHashPassword(os.Stdin), true
+	case "generate-jwt-secret":
+		return runGenerateJWTSecret(), true
+	case "generate-age-key":
+		return runGenerateAgeKey(), true
+	case "encrypt-config":
+		return runEncryptConfig(), true
+	case "backup":
+		return runBackup(os.Args[2:]), true
+	case "restore":
+		return runRestore(os.Args[2:]), true
+	default:
+		return 0, false
+	}
+}
+
+// runHashPassword reads a password from stdin and prints its bcrypt hash
+// Interactive use prompts twice; piped input takes the first line, e.g. `echo -n secret | knock-knock hash-password`
+func runHashPassword(input *os.File) int {
+	reader := bufio.NewReader(input)
+	interactive := isTerminal(input)
+
+	password, err := readPassword(reader, interactive, "Password: ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read password: %v\n", err)
+		return 1
+	}
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "password must not be empty")
+		return 1
+	}
+
+	if interactive {
+		confirmation, err := readPassword(reader, interactive, "Confirm password: ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read password: %v\n", err)
+			return 1
+		}
+		if confirmation != password {
+			fmt.Fprintln(os.Stderr, "passwords do not match")
+			return 1
+		}
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to hash password: %v\n", err)
+		return 1
+	}
+	fmt.Println(hash)
+	return 0
+}
+
+// readPassword reads one line, prompting on stderr so stdout only carries the hash
+func readPassword(reader *bufio.Reader, interactive bool, prompt string) (string, error) {
+	if interactive {
+		fmt.Fprint(os.Stderr, prompt)
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// isTerminal reports whether f is attached to a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runGenerateJWTSecret prints a random key for JWT_SIGNING_SECRET_KEY
+func runGenerateJWTSecret() int {
+	key, err := auth.GenerateSigningKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(key)
+	return 0
+}
+
+// runGenerateAgeKey prints a new age identity for CONFIG_AGE_KEY_FILE in the format of age-keygen
+func runGenerateAgeKey() int {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("# created: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Printf("# public key: %s\n", identity.Recipient())
+	fmt.Println(identity)
+	return 0
+}
+
+// runEncryptConfig encrypts the plain text secrets of the config file with the key from CONFIG_AGE_KEY_FILE or CONFIG_AGE_KEY
+func runEncryptConfig() int {
+	configPath := defaultConfigPath()
+	encrypted, err := config.EncryptFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+		return 1
+	}
+	fmt.Printf("%s: %d secrets encrypted\n", configPath, encrypted)
+	return 0
+}
+
+// runBackup writes a backup archive of the config file and data directory to a file, or stdout for "-"
+// Sessions, blocks and the audit trail only live in a running server; use GET /api/admin/backup to include them
+func runBackup(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: knock-knock backup <file|->")
+		return 2
+	}
+
+	configPath := defaultConfigPath()
+	configData, err := os.ReadFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read config: %v\n", err)
+		return 1
+	}
+
+	dataDir := defaultDataDir()
+	dataFiles, err := backup.ReadDataFiles(dataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read data directory: %v\n", err)
+		return 1
+	}
+	// State restored but not yet applied by a server start is carried over
+	state, _, err := backup.LoadPendingState(dataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	archive := &backup.Archive{
+		Manifest:  backup.NewManifest("command"),
+		Config:    configData,
+		State:     state,
+		DataFiles: dataFiles,
+	}
+
+	out := os.Stdout
+	if args[0] != "-" {
+		out, err = os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create backup: %v\n", err)
+			return 1
+		}
+	}
+	err = backup.Write(out, archive)
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write backup: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runRestore restores a backup archive from a file, or stdin for "-", into the config file and data directory
+// Run it with the server stopped: the next start applies the sessions, blocks and audit entries
+func runRestore(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: knock-knock restore <file|->")
+		return 2
+	}
+
+	in := os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open backup: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+	archive, err := backup.Read(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cfg, err := config.ParseImport(archive.Config)
+	if err == nil {
+		err = config.ValidateConfig(cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration in backup: %v\n", err)
+		return 1
+	}
+
+	configPath := defaultConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write config: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(configPath, archive.Config, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write config: %v\n", err)
+		return 1
+	}
+
+	dataDir := defaultDataDir()
+	if err := backup.SavePendingState(dataDir, archive.State); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save restored state: %v\n", err)
+		return 1
+	}
+	for name, data := range archive.DataFiles {
+		if err := os.WriteFile(filepath.Join(dataDir, name), data, 0o600); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", name, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "restored %s and %d data files from a backup of %s; sessions, blocks and audit entries are applied on the next start\n",
+		configPath, len(archive.DataFiles), archive.Manifest.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+	return 0
+}
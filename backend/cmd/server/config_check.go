@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// runConfigCheck loads and validates the configuration exactly like startup does
+// and optionally prints the result; returns the process exit code
+func runConfigCheck(configPath string, printConfig bool) int {
+	cfg, err := config.LoadFile(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+		return 1
+	}
+
+	if !printConfig {
+		fmt.Printf("%s: configuration OK\n", configPath)
+		return 0
+	}
+
+	data, err := redactedConfigYAML(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to render configuration: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
+
+// redactedConfigYAML renders the configuration as YAML with passwords, secrets and tokens replaced
+func redactedConfigYAML(cfg *config.ApplicationConfig) ([]byte, error) {
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return nil, err
+	}
+	audit.RedactYAML(&doc)
+	return yaml.Marshal(&doc)
+}
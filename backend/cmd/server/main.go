@@ -2,20 +2,44 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/alerts"
 	"github.com/davbauer/knock-knock-portal/internal/api"
+	"github.com/davbauer/knock-knock-portal/internal/approval"
+	"github.com/davbauer/knock-knock-portal/internal/audit"
 	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/backup"
+	"github.com/davbauer/knock-knock-portal/internal/bruteforce"
 	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/dyndns"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/firewall"
+	"github.com/davbauer/knock-knock-portal/internal/geoip"
+	"github.com/davbauer/knock-knock-portal/internal/honeypot"
+	"github.com/davbauer/knock-knock-portal/internal/httplimit"
+	"github.com/davbauer/knock-knock-portal/internal/invite"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/kubernetes"
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+	"github.com/davbauer/knock-knock-portal/internal/loginhistory"
+	"github.com/davbauer/knock-knock-portal/internal/portmapping"
 	"github.com/davbauer/knock-knock-portal/internal/proxy"
+	"github.com/davbauer/knock-knock-portal/internal/schedule"
 	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/davbauer/knock-knock-portal/internal/tailscale"
+	"github.com/davbauer/knock-knock-portal/internal/telegram"
+	"github.com/davbauer/knock-knock-portal/internal/upgrade"
+	"github.com/davbauer/knock-knock-portal/internal/utils"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -23,25 +47,42 @@ import (
 
 const Version = "1.0.0"
 
+var (
+	checkConfig          = flag.Bool("check-config", false, "validate the configuration including environment overrides and exit")
+	printEffectiveConfig = flag.Bool("print-effective-config", false, "print the effective configuration with secrets redacted and exit")
+)
+
 func main() {
 	// Load .env file
 	_ = godotenv.Load() // Ignore error if .env doesn't exist
 
+	// Utility subcommands (hash-password, generate-jwt-secret, backup, ...) run instead of the server
+	if len(os.Args) > 1 {
+		if exitCode, ok := runCommand(os.Args[1]); ok {
+			os.Exit(exitCode)
+		}
+	}
+
+	flag.Parse()
+
+	// Load configuration
+	configPath := defaultConfigPath()
+
+	// Lint mode for CI pipelines: no logging setup, no listeners, exit code reports the result
+	if *checkConfig || *printEffectiveConfig {
+		os.Exit(runConfigCheck(configPath, *printEffectiveConfig))
+	}
+
 	// Setup logging
 	setupLogging()
 
 	log.Info().Str("version", Version).Msg("Starting Knock-Knock Portal")
 
-	// Load configuration
-	configPath := os.Getenv("CONFIG_FILE_PATH")
-	if configPath == "" {
-		// Check if running in Docker container
-		if _, err := os.Stat("/.dockerenv"); err == nil {
-			configPath = "/app/config/config.yml"
-		} else {
-			configPath = "./config.yml"
-		}
-	}
+	// Pick up sockets and state handed over by a previous process (binary upgrade)
+	upgraded := upgrade.Init()
+
+	// Directory for runtime state (traffic history, ...)
+	dataDir := defaultDataDir()
 
 	configLoader, err := config.NewLoader(configPath)
 	if err != nil {
@@ -51,6 +92,20 @@ func main() {
 
 	cfg := configLoader.GetConfig()
 
+	// Switch to the configured log target (syslog, ...)
+	closeLogOutput := setupLogOutput(cfg.LoggingConfig)
+	defer closeLogOutput()
+
+	// Per-module log levels
+	if err := logging.SetLevels(defaultLogLevel(), cfg.LoggingConfig.Levels); err != nil {
+		log.Fatal().Err(err).Msg("Failed to apply log levels")
+	}
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		if err := logging.SetLevels(defaultLogLevel(), newCfg.LoggingConfig.Levels); err != nil {
+			log.Error().Err(err).Msg("Failed to apply log levels")
+		}
+	})
+
 	// Initialize JWT manager
 	jwtManager, err := auth.NewJWTManager()
 	if err != nil {
@@ -58,7 +113,7 @@ func main() {
 	}
 
 	// Initialize password verifier
-	passwordVerifier, err := auth.NewPasswordVerifier()
+	passwordVerifier, err := auth.NewPasswordVerifier(len(cfg.AdminAccounts) > 0)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize password verifier")
 	}
@@ -78,6 +133,8 @@ func main() {
 		int32(cfg.SessionConfig.MaxConcurrentSessions),
 	)
 	defer sessionManager.Close()
+	sessionManager.SetGrantPrefixes(cfg.SessionConfig.IPv4GrantPrefix, cfg.SessionConfig.IPv6GrantPrefix)
+	sessionManager.SetServiceGroups(cfg.ServiceGroups)
 
 	// Initialize IP blocklist manager (HIGHEST PRIORITY - blocks before any other checks)
 	blocklistManager := ipblocklist.NewManager(&cfg.NetworkAccessControl)
@@ -86,8 +143,121 @@ func main() {
 	allowlistManager := ipallowlist.NewManager(&cfg.NetworkAccessControl)
 	defer allowlistManager.Close()
 
+	// Grant services to sessions by tag selectors
+	allowlistManager.SetServiceTags(func(serviceID string) []string {
+		if service := utils.GetServiceByID(configLoader.GetConfig(), serviceID); service != nil {
+			return service.Tags
+		}
+		return nil
+	})
+
+	// Authenticate tailnet members by their Tailscale identity
+	tailscaleAuthenticator := tailscale.NewAuthenticator(configLoader, sessionManager, allowlistManager, &cfg.TailscaleConfig)
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		tailscaleAuthenticator.Reload(&newCfg.TailscaleConfig)
+	})
+
+	// Carry portal sessions across binary upgrades
+	upgrade.RegisterState("sessions", func() interface{} {
+		return sessionManager.GetAllActiveSessions()
+	})
+	if upgraded {
+		var inherited []*session.Session
+		if ok, err := upgrade.InheritedState("sessions", &inherited); err != nil {
+			log.Error().Err(err).Msg("Failed to restore sessions from previous process")
+		} else if ok {
+			restored := 0
+			for _, sess := range inherited {
+				if !sessionManager.RestoreSession(sess) {
+					continue
+				}
+				for _, ip := range sess.AuthenticatedIPAddresses {
+					allowlistManager.AddSessionPrefix(sess.SessionID, sess.GrantPrefix(ip), sess.ExpiresAt)
+				}
+				restored++
+			}
+			log.Info().Int("sessions", restored).Msg("Restored sessions from previous process")
+		}
+	}
+
+	// Record per-service and per-IP traffic history
+	statsHistory := stats.NewHistory(&cfg.StatsHistoryConfig, dataDir)
+	statsHistory.Start()
+	defer statsHistory.Stop()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		statsHistory.Reload(&newCfg.StatsHistoryConfig)
+	})
+
+	// Invites for portal self-registration
+	inviteManager := invite.NewManager(configLoader, dataDir)
+
+	// Recent login attempts of each portal user
+	loginHistory := loginhistory.NewHistory(dataDir)
+
+	// Live events for admins, and logins held for admin approval
+	eventBus := events.NewBus()
+	auditLog := audit.NewLog(eventBus, 1000) // Last 1000 admin actions
+
+	// Country, city and network of client IPs for admin views and the audit trail
+	geoResolver := geoip.NewResolver(&cfg.GeoIPConfig)
+	auditLog.SetGeoIP(geoResolver)
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		geoResolver.Reload(&newCfg.GeoIPConfig)
+	})
+
+	// Apply runtime state left by the restore command
+	applyRestoredState(dataDir, backup.Targets{
+		Sessions:  sessionManager,
+		Allowlist: allowlistManager,
+		Blocklist: blocklistManager,
+		Audit:     auditLog,
+	})
+
+	approvalManager := approval.NewManager(&cfg.ApprovalConfig, sessionManager, allowlistManager, eventBus)
+	approvalManager.Start()
+	defer approvalManager.Stop()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		approvalManager.Reload(&newCfg.ApprovalConfig)
+	})
+
+	// Approve or deny pending logins from Telegram
+	telegramBot := telegram.NewBot(configLoader, approvalManager, eventBus)
+	defer telegramBot.Close()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		telegramBot.Reload()
+	})
+
+	// Failed login limits shared by the portal and admin logins
+	bruteForceGuard := bruteforce.NewGuard(&cfg.BruteForceConfig, blocklistManager, allowlistManager, auditLog, eventBus)
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		bruteForceGuard.Reload(&newCfg.BruteForceConfig)
+	})
+
+	// Decoy ports that temporarily block scanners
+	honeypotListener := honeypot.NewHoneypot(configLoader, blocklistManager, allowlistManager, eventBus)
+	defer honeypotListener.Close()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		honeypotListener.Reload()
+	})
+
 	// Initialize proxy manager
-	proxyManager := proxy.NewManager(configLoader, allowlistManager, blocklistManager)
+	proxyManager := proxy.NewManager(configLoader, allowlistManager, blocklistManager, statsHistory)
+	proxyManager.SetEventBus(eventBus)
+	proxyManager.SetIdentityResolver(func(ip netip.Addr) (proxy.ClientIdentity, bool) {
+		sess, ok := sessionManager.FindSessionForIP(ip)
+		if !ok {
+			return proxy.ClientIdentity{}, false
+		}
+		return proxy.ClientIdentity{
+			SessionID: sess.SessionID,
+			UserID:    sess.UserID,
+			Username:  sess.Username,
+			ExpiresAt: sess.ExpiresAt,
+		}, true
+	})
+
+	// Limit session access to the user's allowed services and to user and service schedules
+	proxyManager.SetAccessPolicy(schedule.SessionPolicy(configLoader, sessionManager))
 
 	// Start proxy services
 	if err := proxyManager.Start(); err != nil {
@@ -95,6 +265,67 @@ func main() {
 	}
 	defer proxyManager.Stop()
 
+	// Notify admins when alert rules are exceeded
+	alertManager := alerts.NewManager(configLoader, proxyManager, statsHistory, eventBus)
+	defer alertManager.Close()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		alertManager.Reload()
+	})
+
+	// Program kernel firewall rules for firewall-mode services
+	firewallManager := firewall.NewManager(configLoader, allowlistManager, blocklistManager, sessionManager)
+	if err := firewallManager.Start(); err != nil {
+		log.Error().Err(err).Msg("Failed to start firewall enforcement (continuing anyway)")
+	}
+	defer firewallManager.Stop()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		if err := firewallManager.Reload(); err != nil {
+			log.Error().Err(err).Msg("Failed to reload firewall enforcement")
+		}
+	})
+
+	// Request router port forwards for proxy listeners (UPnP / NAT-PMP)
+	// Gateway discovery can take seconds, so one goroutine starts and reloads in order, off the reload path
+	portMappingManager := portmapping.NewManager(configLoader)
+	portMappingReload := make(chan struct{}, 1)
+	go func() {
+		if err := portMappingManager.Start(); err != nil {
+			log.Error().Err(err).Msg("Failed to start port mapping (continuing anyway)")
+		}
+		for range portMappingReload {
+			if err := portMappingManager.Reload(); err != nil {
+				log.Error().Err(err).Msg("Failed to reload port mapping")
+			}
+		}
+	}()
+	defer portMappingManager.Stop()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		select {
+		case portMappingReload <- struct{}{}:
+		default: // A reload is already pending and will read the newest config
+		}
+	})
+
+	// Keep the portal's own hostname updated
+	dynDNSUpdater := dyndns.NewUpdater(&cfg.DynamicDNSConfig)
+	defer dynDNSUpdater.Close()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		dynDNSUpdater.Reload(&newCfg.DynamicDNSConfig)
+	})
+
+	// Publish annotated cluster Services as protected services
+	kubernetesController := kubernetes.NewController(configLoader, &cfg.KubernetesConfig)
+	defer kubernetesController.Close()
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		kubernetesController.Reload(&newCfg.KubernetesConfig)
+	})
+
+	// Slowloris, slow-read and per-IP concurrency limits for the admin/portal API
+	apiLimiter := httplimit.NewLimiter(&cfg.ProxyServerConfig.HTTPLimits)
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		apiLimiter.Reload(&newCfg.ProxyServerConfig.HTTPLimits)
+	})
+
 	// Setup API router (passes proxy manager for config reload integration)
 	router := api.NewRouter(
 		configLoader,
@@ -104,17 +335,36 @@ func main() {
 		allowlistManager,
 		blocklistManager,
 		proxyManager,
+		statsHistory,
+		inviteManager,
+		approvalManager,
+		eventBus,
+		auditLog,
+		geoResolver,
+		loginHistory,
+		bruteForceGuard,
+		apiLimiter,
+		dataDir,
 	)
 
 	// Start HTTP server
+	// The API serves long-lived event streams, so slow clients are bounded by transfer rate rather than fixed timeouts
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.ProxyServerConfig.AdminAPIPort),
-		Handler: router.GetEngine(),
+		Addr:              fmt.Sprintf(":%d", cfg.ProxyServerConfig.AdminAPIPort),
+		Handler:           router.GetEngine(),
+		ReadHeaderTimeout: apiLimiter.HeaderReadTimeout(),
+		IdleTimeout:       120 * time.Second,
+	}
+
+	listener, err := upgrade.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("HTTP server failed")
 	}
+	listener = apiLimiter.Listener(listener)
 
 	// Graceful shutdown
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("HTTP server failed")
 		}
 	}()
@@ -123,10 +373,33 @@ func main() {
 		Int("port", cfg.ProxyServerConfig.AdminAPIPort).
 		Msg("HTTP API server started")
 
+	// Let the previous process (if any) drain and exit
+	upgrade.Ready()
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+
+	// SIGUSR2 starts the binary on disk and hands over all sockets
+	upgradeChan := make(chan os.Signal, 1)
+	upgrade.NotifySignal(upgradeChan)
+
+waitLoop:
+	for {
+		select {
+		case <-quit:
+			break waitLoop
+		case <-upgradeChan:
+			// The new process loads the history from disk
+			statsHistory.Flush()
+			if err := upgrade.Upgrade(30 * time.Second); err != nil {
+				log.Error().Err(err).Msg("Binary upgrade failed, continuing with current process")
+				continue
+			}
+			handOver(configLoader, kubernetesController, dynDNSUpdater, server, proxyManager)
+			return
+		}
+	}
 
 	log.Info().Msg("Shutting down server...")
 
@@ -140,6 +413,12 @@ func main() {
 		log.Error().Err(err).Msg("Error stopping proxy manager")
 	}
 
+	// Remove kernel firewall rules
+	firewallManager.Stop()
+
+	// Remove router port forwards
+	portMappingManager.Stop()
+
 	// Then stop API server
 	if err := server.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("Server forced to shutdown")
@@ -148,25 +427,147 @@ func main() {
 	log.Info().Msg("Server stopped")
 }
 
+// handOver finishes the old process after a successful binary upgrade
+// Listeners now belong to the new process; active connections are drained, router port
+// forwards and sessions are left untouched because the new process owns them
+func handOver(configLoader *config.Loader, kubernetesController *kubernetes.Controller, dynDNSUpdater *dyndns.Updater, server *http.Server, proxyManager *proxy.Manager) {
+	drainTimeout := time.Duration(configLoader.GetConfig().ProxyServerConfig.UpgradeDrainTimeoutSeconds) * time.Second
+
+	// Stop reacting to config changes so nothing re-binds the handed over ports
+	configLoader.Close()
+	kubernetesController.Close()
+	dynDNSUpdater.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Server forced to shutdown")
+	}
+
+	log.Info().Msg("Draining proxy connections after upgrade...")
+	if err := proxyManager.Drain(drainTimeout); err != nil {
+		log.Error().Err(err).Msg("Error draining proxy manager")
+	}
+
+	log.Info().Msg("Previous process exiting after upgrade")
+	// Skip deferred cleanup: it would tear down state the new process took over
+	os.Exit(0)
+}
+
+// defaultConfigPath returns the config file from CONFIG_FILE_PATH, or the default location
+func defaultConfigPath() string {
+	if configPath := os.Getenv("CONFIG_FILE_PATH"); configPath != "" {
+		return configPath
+	}
+	// Check if running in Docker container
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "/app/config/config.yml"
+	}
+	return "./config.yml"
+}
+
+// defaultDataDir returns the runtime state directory from DATA_DIRECTORY, or the default location
+func defaultDataDir() string {
+	if dataDir := os.Getenv("DATA_DIRECTORY"); dataDir != "" {
+		return dataDir
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "/app/data"
+	}
+	return "./data"
+}
+
+// applyRestoredState applies the sessions, blocks and audit entries saved by the restore command, once
+func applyRestoredState(dataDir string, targets backup.Targets) {
+	state, ok, err := backup.LoadPendingState(dataDir)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load restored state")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	applied := backup.Apply(state, targets)
+	if err := backup.RemovePendingState(dataDir); err != nil {
+		log.Error().Err(err).Msg("Failed to remove restored state")
+	}
+	log.Info().
+		Int("sessions", applied.Sessions).
+		Int("temporary_blocks", applied.TemporaryBlocks).
+		Int("audit_entries", applied.AuditEntries).
+		Msg("Applied state restored from backup")
+}
+
 func setupLogging() {
 	// Configure zerolog
-	logLevel := os.Getenv("LOG_LEVEL")
-	switch logLevel {
+	zerolog.SetGlobalLevel(defaultLogLevel())
+
+	// Configure output format
+	logFormat := os.Getenv("LOG_FORMAT")
+	if logFormat == "text" {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	}
+}
+
+// defaultLogLevel returns the level from LOG_LEVEL; logging_config.levels can override it per module
+func defaultLogLevel() zerolog.Level {
+	switch os.Getenv("LOG_LEVEL") {
 	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		return zerolog.DebugLevel
 	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		return zerolog.InfoLevel
 	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		return zerolog.WarnLevel
 	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+		return zerolog.ErrorLevel
 	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		return zerolog.InfoLevel
 	}
+}
 
-	// Configure output format
-	logFormat := os.Getenv("LOG_FORMAT")
-	if logFormat == "text" {
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+// setupLogOutput redirects logging to the target from logging_config
+// Syslog always receives JSON events; returns a function that flushes and closes the target
+func setupLogOutput(lc config.LoggingConfiguration) func() {
+	switch lc.Output {
+	case "syslog":
+		writer, err := logging.NewSyslogWriter(lc.Syslog.Address, lc.Syslog.Facility, lc.Syslog.AppName, lc.Syslog.TLSCAFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to set up syslog output")
+		}
+
+		target := lc.Syslog.Address
+		if target == "" {
+			target = "local"
+		}
+		log.Info().Str("target", target).Msg("Logging to syslog")
+
+		log.Logger = zerolog.New(writer).With().Timestamp().Logger()
+		return func() {
+			writer.Close()
+		}
+
+	case "file":
+		maxSizeMB := lc.File.MaxSizeMB
+		if maxSizeMB == 0 {
+			maxSizeMB = 100
+		}
+		file, err := logging.NewRotatingFile(lc.File.Path, maxSizeMB, lc.File.MaxBackups, lc.File.MaxAgeDays)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to set up log file output")
+		}
+		log.Info().Str("path", lc.File.Path).Int("max_size_mb", maxSizeMB).Msg("Logging to file")
+
+		if os.Getenv("LOG_FORMAT") == "text" {
+			log.Logger = log.Output(zerolog.ConsoleWriter{Out: file, NoColor: true, TimeFormat: time.RFC3339})
+		} else {
+			log.Logger = zerolog.New(file).With().Timestamp().Logger()
+		}
+		return func() {
+			file.Close()
+		}
+
+	default:
+		return func() {}
 	}
 }
@@ -0,0 +1,328 @@
+// Package alerts evaluates threshold rules and notifies admins when one is exceeded.
+//
+// Rules are checked on an interval against live proxy state (active connections, circuit
+// breakers), the traffic history (bytes per minute) and the failed login counter. A rule that
+// fires is published on the event bus as "alert.fired" and sent to the configured webhook,
+// Telegram chats and email recipients; it stays quiet for its cooldown afterwards.
+package alerts
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/proxy"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+)
+
+const (
+	defaultCheckInterval = 30 * time.Second
+	defaultWindow        = 5 * time.Minute
+	defaultCooldown      = 15 * time.Minute
+	maxWindow            = 24 * time.Hour
+	eventAlertFired      = "alert.fired"
+)
+
+// Alert is a fired rule
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Metric      string    `json:"metric"`
+	ServiceID   string    `json:"service_id,omitempty"`
+	ServiceName string    `json:"service_name,omitempty"`
+	Value       int64     `json:"value"`
+	Threshold   int64     `json:"threshold"`
+	Time        time.Time `json:"time"`
+	Message     string    `json:"message"`
+}
+
+// failureSample is the failed login counter at one check
+type failureSample struct {
+	time  time.Time
+	count int64
+}
+
+// Manager checks alert rules and delivers notifications
+type Manager struct {
+	configLoader *config.Loader
+	proxyManager *proxy.Manager
+	history      *stats.History
+	bus          *events.Bus
+
+	mu            sync.Mutex
+	cfg           config.AlertsConfiguration
+	telegramToken string
+	lastFired     map[string]time.Time // rule|service -> last notification
+	openSince     map[string]time.Time // service -> first check its circuit breaker was not closed
+	failures      []failureSample      // Oldest first
+	fired         int64
+	notifyErrors  int64
+	lastAlert     *Alert
+	stopChan      chan struct{}
+	stoppedChan   chan struct{}
+
+	deliveries sync.WaitGroup
+}
+
+// NewManager creates an alert manager and starts it if enabled
+func NewManager(configLoader *config.Loader, proxyManager *proxy.Manager, history *stats.History, bus *events.Bus) *Manager {
+	m := &Manager{
+		configLoader: configLoader,
+		proxyManager: proxyManager,
+		history:      history,
+		bus:          bus,
+		lastFired:    make(map[string]time.Time),
+		openSince:    make(map[string]time.Time),
+	}
+
+	m.Reload()
+	return m
+}
+
+// Reload applies the current configuration, restarting the check loop
+// Cooldowns are kept, so saving the config does not repeat alerts that were just sent
+func (m *Manager) Reload() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stop()
+
+	cfg := m.configLoader.GetConfig()
+	m.cfg = cfg.AlertsConfig
+	m.telegramToken = m.cfg.Telegram.BotToken
+	if m.telegramToken == "" {
+		m.telegramToken = cfg.ApprovalConfig.Telegram.BotToken
+	}
+
+	if !m.cfg.Enabled || len(m.cfg.Rules) == 0 {
+		return
+	}
+
+	interval := time.Duration(m.cfg.CheckIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	m.stopChan = make(chan struct{})
+	m.stoppedChan = make(chan struct{})
+	go m.run(m.stopChan, m.stoppedChan, interval)
+
+	log.Info().
+		Int("rules", len(m.cfg.Rules)).
+		Dur("interval", interval).
+		Msg("Alerting started")
+}
+
+// Close stops the check loop and waits for notifications in flight
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.stop()
+	m.mu.Unlock()
+
+	m.deliveries.Wait()
+}
+
+// stop halts the check loop; must be called with mu held
+func (m *Manager) stop() {
+	if m.stopChan == nil {
+		return
+	}
+
+	close(m.stopChan)
+	<-m.stoppedChan
+	m.stopChan = nil
+	m.stoppedChan = nil
+}
+
+// run checks the rules on every interval
+func (m *Manager) run(stop, stopped chan struct{}, interval time.Duration) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.check(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check evaluates every rule and fires those above their threshold and out of cooldown
+func (m *Manager) check(now time.Time) {
+	appCfg := m.configLoader.GetConfig()
+	backends := m.proxyManager.BackendHealth()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sampleFailures(now)
+	m.trackCircuits(backends, now)
+
+	for _, rule := range m.cfg.Rules {
+		cooldown := time.Duration(rule.CooldownMinutes) * time.Minute
+		if cooldown <= 0 {
+			cooldown = defaultCooldown
+		}
+
+		for _, alert := range m.evaluate(appCfg, rule, now) {
+			key := rule.Name + "|" + alert.ServiceID
+			if last, ok := m.lastFired[key]; ok && now.Sub(last) < cooldown {
+				continue
+			}
+			m.lastFired[key] = now
+			m.fire(alert)
+		}
+	}
+
+	// Forget cooldowns of renamed or removed rules
+	for key, last := range m.lastFired {
+		if now.Sub(last) > maxWindow {
+			delete(m.lastFired, key)
+		}
+	}
+}
+
+// evaluate returns an alert for each service (or one for the portal) where the rule's metric is above its threshold
+func (m *Manager) evaluate(appCfg *config.ApplicationConfig, rule config.AlertRule, now time.Time) []Alert {
+	newAlert := func(service *config.ProtectedServiceConfig, value int64, message string) Alert {
+		alert := Alert{
+			Rule:      rule.Name,
+			Metric:    rule.Metric,
+			Value:     value,
+			Threshold: rule.Threshold,
+			Time:      now,
+			Message:   message,
+		}
+		if service != nil {
+			alert.ServiceID = service.ServiceID
+			alert.ServiceName = service.ServiceName
+		}
+		return alert
+	}
+
+	if rule.Metric == "failed_logins" {
+		window := time.Duration(rule.WindowMinutes) * time.Minute
+		if window <= 0 {
+			window = defaultWindow
+		}
+		value := m.failuresSince(now.Add(-window))
+		if value <= rule.Threshold {
+			return nil
+		}
+		return []Alert{newAlert(nil, value, fmt.Sprintf("%d failed logins in the last %s (threshold %d)", value, window, rule.Threshold))}
+	}
+
+	var alerts []Alert
+	for i := range appCfg.ProtectedServices {
+		service := &appCfg.ProtectedServices[i]
+		if !service.Enabled || (rule.ServiceID != "" && rule.ServiceID != service.ServiceID) {
+			continue
+		}
+
+		var value int64
+		var message string
+		switch rule.Metric {
+		case "active_connections":
+			_, connections := m.proxyManager.ServiceStatus(service.ServiceID)
+			value = int64(connections)
+			message = fmt.Sprintf("%s has %d active connections (threshold %d)", service.ServiceName, value, rule.Threshold)
+		case "bytes_per_minute":
+			value = m.lastMinuteBytes(service.ServiceID, now)
+			message = fmt.Sprintf("%s transferred %d bytes in the last minute (threshold %d)", service.ServiceName, value, rule.Threshold)
+		case "circuit_open_seconds":
+			if since, ok := m.openSince[service.ServiceID]; ok {
+				value = int64(now.Sub(since).Seconds())
+			}
+			message = fmt.Sprintf("%s circuit breaker has been open for %ds (threshold %ds)", service.ServiceName, value, rule.Threshold)
+		}
+		if value > rule.Threshold {
+			alerts = append(alerts, newAlert(service, value, message))
+		}
+	}
+	return alerts
+}
+
+// lastMinuteBytes returns the traffic of a service in the last sampled minute
+func (m *Manager) lastMinuteBytes(serviceID string, now time.Time) int64 {
+	points := m.history.Query(stats.KindService, serviceID, now.Add(-2*time.Minute), time.Minute)
+	if len(points) < 2 {
+		return 0
+	}
+	// The last point is the current minute, which has not been sampled yet
+	last := points[len(points)-2]
+	return last.BytesIn + last.BytesOut
+}
+
+// sampleFailures records the failed login counter, keeping samples for the longest window; must be called with mu held
+func (m *Manager) sampleFailures(now time.Time) {
+	m.failures = append(m.failures, failureSample{time: now, count: auth.LoginFailures()})
+
+	// Keep one sample older than the longest window as its baseline
+	cutoff := now.Add(-maxWindow)
+	drop := 0
+	for drop+1 < len(m.failures) && !m.failures[drop+1].time.After(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		m.failures = append([]failureSample(nil), m.failures[drop:]...)
+	}
+}
+
+// failuresSince returns the number of failed logins since the given time; must be called with mu held
+// The counter starts at zero, so failures before the first sample happened after startup and count fully
+func (m *Manager) failuresSince(since time.Time) int64 {
+	if len(m.failures) == 0 {
+		return 0
+	}
+	var baseline int64
+	for _, sample := range m.failures {
+		if sample.time.After(since) {
+			break
+		}
+		baseline = sample.count
+	}
+	return m.failures[len(m.failures)-1].count - baseline
+}
+
+// trackCircuits remembers since when each service has had a circuit breaker that is not closed; must be called with mu held
+func (m *Manager) trackCircuits(backends []proxy.BackendHealth, now time.Time) {
+	open := make(map[string]bool)
+	for _, backend := range backends {
+		if state, _ := backend.CircuitBreaker["state"].(string); state != "" && state != proxy.CircuitClosed.String() {
+			open[backend.ServiceID] = true
+		}
+	}
+
+	for serviceID := range m.openSince {
+		if !open[serviceID] {
+			delete(m.openSince, serviceID)
+		}
+	}
+	for serviceID := range open {
+		if _, ok := m.openSince[serviceID]; !ok {
+			m.openSince[serviceID] = now
+		}
+	}
+}
+
+// GetStats returns alerting statistics
+func (m *Manager) GetStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := map[string]interface{}{
+		"enabled":       m.cfg.Enabled,
+		"rules":         len(m.cfg.Rules),
+		"fired":         m.fired,
+		"notify_errors": m.notifyErrors,
+	}
+	if m.lastAlert != nil {
+		result["last_alert"] = *m.lastAlert
+	}
+	return result
+}
@@ -0,0 +1,6 @@
+package alerts
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["alerts"] overrides its level
+var log = logging.Module("alerts")
@@ -0,0 +1,151 @@
+package alerts
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/telegram"
+)
+
+const (
+	deliveryTimeout  = 30 * time.Second
+	defaultSMTPPort  = 587
+	smtpDialTimeout  = 10 * time.Second
+	alertTextHeading = "Knock-Knock Portal alert"
+)
+
+// fire publishes an alert and delivers it to every channel in the background; must be called with mu held
+func (m *Manager) fire(alert Alert) {
+	m.fired++
+	m.lastAlert = &alert
+
+	log.Warn().
+		Str("rule", alert.Rule).
+		Str("metric", alert.Metric).
+		Str("service_id", alert.ServiceID).
+		Int64("value", alert.Value).
+		Int64("threshold", alert.Threshold).
+		Msg("Alert fired")
+
+	event := m.bus.Publish(eventAlertFired, alert)
+
+	cfg := m.cfg
+	token := m.telegramToken
+	m.deliveries.Add(1)
+	go func() {
+		defer m.deliveries.Done()
+		m.deliver(cfg, token, event, alert)
+	}()
+}
+
+// deliver sends an alert to the webhook, Telegram chats and email recipients; a failing channel does not stop the others
+func (m *Manager) deliver(cfg config.AlertsConfiguration, telegramToken string, event events.Event, alert Alert) {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	defer cancel()
+
+	text := alertTextHeading + ": " + alert.Rule + "\n" + alert.Message
+
+	if cfg.WebhookURL != "" {
+		if err := events.SendWebhook(ctx, cfg.WebhookURL, event); err != nil {
+			m.deliveryFailed("webhook", alert, err)
+		}
+	}
+
+	for _, chatID := range cfg.Telegram.ChatIDs {
+		if err := telegram.SendMessage(ctx, telegramToken, chatID, text); err != nil {
+			m.deliveryFailed("telegram", alert, err)
+		}
+	}
+
+	if cfg.Email.SMTPHost != "" {
+		if err := sendEmail(ctx, &cfg.Email, alertTextHeading+": "+alert.Rule, alert.Message); err != nil {
+			m.deliveryFailed("email", alert, err)
+		}
+	}
+}
+
+// deliveryFailed logs and counts a notification that could not be sent
+func (m *Manager) deliveryFailed(channel string, alert Alert, err error) {
+	m.mu.Lock()
+	m.notifyErrors++
+	m.mu.Unlock()
+
+	log.Error().
+		Err(err).
+		Str("channel", channel).
+		Str("rule", alert.Rule).
+		Msg("Failed to deliver alert")
+}
+
+// sendEmail mails a plain text message, upgrading to TLS with STARTTLS when the server offers it
+// net/smtp has no timeouts of its own, so the connection deadline bounds the whole exchange
+func sendEmail(ctx context.Context, cfg *config.AlertEmailConfig, subject, body string) error {
+	port := cfg.SMTPPort
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: cfg.SMTPHost}); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	if cfg.Username != "" {
+		// PlainAuth refuses to send the password without TLS unless the server is localhost
+		if err := client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.SMTPHost)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	var msg strings.Builder
+	msg.WriteString("From: " + cfg.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(cfg.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + strings.NewReplacer("\r", "", "\n", " ").Replace(subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n") + "\r\n")
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
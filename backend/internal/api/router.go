@@ -8,14 +8,24 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/davbauer/knock-knock-portal/internal/approval"
+	"github.com/davbauer/knock-knock-portal/internal/audit"
 	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/bruteforce"
+	"github.com/davbauer/knock-knock-portal/internal/captcha"
 	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/geoip"
 	"github.com/davbauer/knock-knock-portal/internal/handlers"
+	"github.com/davbauer/knock-knock-portal/internal/httplimit"
+	"github.com/davbauer/knock-knock-portal/internal/invite"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/loginhistory"
 	"github.com/davbauer/knock-knock-portal/internal/middleware"
 	"github.com/davbauer/knock-knock-portal/internal/proxy"
 	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -30,7 +40,17 @@ type Router struct {
 	allowlistManager *ipallowlist.Manager
 	blocklistManager *ipblocklist.Manager
 	proxyManager     *proxy.Manager
+	history          *stats.History
+	inviteManager    *invite.Manager
+	approvalManager  *approval.Manager
+	eventBus         *events.Bus
+	auditLog         *audit.Log
+	geoResolver      *geoip.Resolver
+	loginHistory     *loginhistory.History
+	bruteForce       *bruteforce.Guard
+	dataDir          string
 	ipExtractor      *middleware.RealIPExtractor
+	apiRateLimiter   *middleware.APIRateLimiter
 	indexHTMLHash    string // SHA256 hash of index.html for cache busting
 }
 
@@ -43,14 +63,30 @@ func NewRouter(
 	allowlistManager *ipallowlist.Manager,
 	blocklistManager *ipblocklist.Manager,
 	proxyManager *proxy.Manager,
+	history *stats.History,
+	inviteManager *invite.Manager,
+	approvalManager *approval.Manager,
+	eventBus *events.Bus,
+	auditLog *audit.Log,
+	geoResolver *geoip.Resolver,
+	loginHistory *loginhistory.History,
+	bruteForce *bruteforce.Guard,
+	httpLimiter *httplimit.Limiter,
+	dataDir string,
 ) *Router {
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
 	engine := gin.New()
 
+	// Real IP extractor with dynamic config reload
+	cfg := configLoader.GetConfig()
+	ipExtractor, _ := middleware.NewRealIPExtractor(&cfg.TrustedProxyConfig)
+	apiRateLimiter := middleware.NewAPIRateLimiter(&cfg.ProxyServerConfig.APIRateLimit, jwtManager)
+
 	// Global middleware
 	engine.Use(gin.Recovery())
+	engine.Use(ipExtractor.RequestID())
 	engine.Use(middleware.RequestLogger())
 	engine.Use(middleware.RequestSizeLimiter(1 * 1024 * 1024)) // 1MB limit for request bodies
 
@@ -64,10 +100,16 @@ func NewRouter(
 		c.Header("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=(), magnetometer=(), gyroscope=(), accelerometer=()")
 
 		// Content Security Policy - Allow inline styles and scripts for SvelteKit
-		c.Header("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' 'unsafe-eval'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; font-src 'self' data:; connect-src 'self'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'")
+		// The login CAPTCHA widget is loaded from its provider, which also can't satisfy require-corp
+		captchaOrigins := ""
+		if cc := configLoader.GetConfig().CaptchaConfig; cc.Enabled {
+			captchaOrigins = " " + strings.Join(captcha.Origins(cc.Provider), " ")
+		} else {
+			c.Header("Cross-Origin-Embedder-Policy", "require-corp")
+		}
+		c.Header("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' 'unsafe-eval'"+captchaOrigins+"; style-src 'self' 'unsafe-inline'"+captchaOrigins+"; img-src 'self' data:; font-src 'self' data:; connect-src 'self'"+captchaOrigins+"; frame-src 'self'"+captchaOrigins+"; frame-ancestors 'none'; base-uri 'self'; form-action 'self'")
 
 		// Cross-Origin Policies
-		c.Header("Cross-Origin-Embedder-Policy", "require-corp")
 		c.Header("Cross-Origin-Opener-Policy", "same-origin")
 		c.Header("Cross-Origin-Resource-Policy", "same-origin")
 
@@ -87,18 +129,17 @@ func NewRouter(
 	engine.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // Configure as needed
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", middleware.RequestIDHeader},
 		AllowCredentials: true,
 	}))
 
-	// Real IP extractor with dynamic config reload
-	cfg := configLoader.GetConfig()
-	ipExtractor, _ := middleware.NewRealIPExtractor(&cfg.TrustedProxyConfig)
-
 	// Register callback to update IP extractor and proxy manager when config reloads
 	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
 		ipExtractor.Reload(&newCfg.TrustedProxyConfig)
+		apiRateLimiter.Reload(&newCfg.ProxyServerConfig.APIRateLimit)
+		sessionManager.SetGrantPrefixes(newCfg.SessionConfig.IPv4GrantPrefix, newCfg.SessionConfig.IPv6GrantPrefix)
+		sessionManager.SetServiceGroups(newCfg.ServiceGroups)
 		allowlistManager.Reload(&newCfg.NetworkAccessControl)
 		blocklistManager.Reload(&newCfg.NetworkAccessControl)
 
@@ -109,6 +150,7 @@ func NewRouter(
 	})
 
 	engine.Use(ipExtractor.Middleware())
+	engine.Use(middleware.HTTPLimits(httpLimiter))
 
 	router := &Router{
 		engine:           engine,
@@ -119,7 +161,17 @@ func NewRouter(
 		allowlistManager: allowlistManager,
 		blocklistManager: blocklistManager,
 		proxyManager:     proxyManager,
+		history:          history,
+		inviteManager:    inviteManager,
+		approvalManager:  approvalManager,
+		eventBus:         eventBus,
+		auditLog:         auditLog,
+		geoResolver:      geoResolver,
+		loginHistory:     loginHistory,
+		bruteForce:       bruteForce,
+		dataDir:          dataDir,
 		ipExtractor:      ipExtractor,
+		apiRateLimiter:   apiRateLimiter,
 	}
 
 	// Compute index.html hash for cache busting
@@ -134,15 +186,28 @@ func NewRouter(
 func (r *Router) setupRoutes() {
 	// API routes group
 	api := r.engine.Group("/api")
+	api.Use(middleware.Compress())
 	{
 		// Health endpoint (service health only)
-		healthHandler := handlers.NewHealthHandler("1.0.0")
+		healthHandler := handlers.NewHealthHandler("1.0.0", r.proxyManager)
 		api.GET("/health", healthHandler.Handle)
 
+		// Forward-auth endpoint for external reverse proxies (Traefik, nginx, Caddy)
+		forwardAuthHandler := handlers.NewForwardAuthHandler(r.allowlistManager, r.blocklistManager, r.sessionManager, r.configLoader)
+		api.GET("/auth/verify", forwardAuthHandler.HandleVerify)
+
+		// Per-IP request budgets apply to every route registered below; health checks and
+		// forward-auth subrequests of reverse proxies above are exempt
+		api.Use(r.apiRateLimiter.Middleware())
+
 		// Connection info endpoint (public, returns client IP and allowlist status)
-		connectionInfoHandler := handlers.NewConnectionInfoHandler(r.allowlistManager, r.blocklistManager, r.sessionManager, r.configLoader, r.ipExtractor)
+		connectionInfoHandler := handlers.NewConnectionInfoHandler(r.allowlistManager, r.blocklistManager, r.sessionManager, r.configLoader, r.ipExtractor, r.geoResolver)
 		api.GET("/connection-info", connectionInfoHandler.HandleCheck)
 
+		// Status page for services with public_status (public, no ports or client details)
+		publicStatusHandler := handlers.NewPublicStatusHandler(r.configLoader, r.proxyManager)
+		api.GET("/public/status", publicStatusHandler.HandleStatus)
+
 		// Portal API (public/authenticated)
 		portal := api.Group("/portal")
 		{
@@ -154,21 +219,34 @@ func (r *Router) setupRoutes() {
 				r.sessionManager,
 				r.allowlistManager,
 				r.blocklistManager,
+				r.approvalManager,
+				r.loginHistory,
+				r.bruteForce,
 			)
 			portal.POST("/login", loginHandler.Handle)
+			portal.GET("/requests/:id", loginHandler.HandleRequestStatus)
+			portal.GET("/captcha", loginHandler.HandleCaptcha)
+			portal.POST("/account/change-password", loginHandler.HandleChangePassword)
 
 			usernamesHandler := handlers.NewSuggestedUsernamesHandler(r.configLoader)
 			portal.GET("/suggested-usernames", usernamesHandler.Handle)
 
+			// Self-registration with admin-issued invites
+			inviteHandler := handlers.NewPortalInviteHandler(r.inviteManager, r.configLoader, r.blocklistManager)
+			portal.GET("/invites/:code", inviteHandler.HandleGet)
+			portal.POST("/invites/:code/redeem", inviteHandler.HandleRedeem)
+
 			// Authenticated endpoints (require portal JWT)
-			sessionHandler := handlers.NewPortalSessionHandler(r.sessionManager, r.configLoader, r.allowlistManager)
+			sessionHandler := handlers.NewPortalSessionHandler(r.sessionManager, r.configLoader, r.allowlistManager, r.proxyManager)
 			authenticated := portal.Group("")
 			authenticated.Use(middleware.AuthMiddleware(r.jwtManager, auth.TokenTypePortal))
 			{
 				authenticated.GET("/session/status", sessionHandler.HandleStatus)
 				authenticated.POST("/session/logout", sessionHandler.HandleLogout)
 				authenticated.POST("/session/add-ip", sessionHandler.HandleAddIP)
+				authenticated.POST("/session/correlate-ip", sessionHandler.HandleCorrelateIP)
 				authenticated.POST("/session/extend", sessionHandler.HandleExtendSession)
+				authenticated.GET("/account/login-history", loginHandler.HandleLoginHistory)
 			}
 		}
 
@@ -176,31 +254,120 @@ func (r *Router) setupRoutes() {
 		admin := api.Group("/admin")
 		{
 			// Login endpoint (public)
-			adminLoginHandler := handlers.NewAdminLoginHandler(r.passwordVerifier, r.jwtManager, r.blocklistManager)
+			adminLoginHandler := handlers.NewAdminLoginHandler(r.configLoader, r.passwordVerifier, r.jwtManager, r.blocklistManager, r.bruteForce)
 			admin.POST("/login", adminLoginHandler.Handle)
+			admin.POST("/refresh", adminLoginHandler.HandleRefresh)
 
 			// Protected admin endpoints
 			protected := admin.Group("")
 			protected.Use(middleware.AuthMiddleware(r.jwtManager, auth.TokenTypeAdmin))
 			{
+				// Conditional GET for the dashboard's polled lists; a poll with nothing new gets an empty 304
+				etag := middleware.ETag(true)
+
+				protected.POST("/logout", adminLoginHandler.HandleLogout)
+
 				// User/Session management (authenticated portal users only)
-				sessionsHandler := handlers.NewAdminSessionsHandler(r.sessionManager, r.allowlistManager, r.proxyManager)
-				protected.GET("/users", sessionsHandler.HandleList)
+				sessionsHandler := handlers.NewAdminSessionsHandler(r.sessionManager, r.allowlistManager, r.proxyManager, r.auditLog)
+				protected.GET("/users", etag, sessionsHandler.HandleList)
 				protected.DELETE("/users/:session_id", sessionsHandler.HandleDelete)
+				protected.DELETE("/users/by-user-id/:user_id", sessionsHandler.HandleDeleteByUserID)
 
 				// Connection monitoring (shows ALL active connections including anonymous)
-				connectionsHandler := handlers.NewAdminConnectionsHandler(r.proxyManager, r.sessionManager)
-				protected.GET("/connections", connectionsHandler.HandleList)
+				connectionsHandler := handlers.NewAdminConnectionsHandler(r.proxyManager, r.sessionManager, r.blocklistManager, r.geoResolver, r.auditLog, r.jwtManager)
+				admin.GET("/connections/live", connectionsHandler.HandleLive) // WebSocket; authenticates itself since browsers can't send the header
+				protected.GET("/connections", etag, connectionsHandler.HandleList)
+				protected.DELETE("/connections/anonymous", connectionsHandler.HandleTerminateAnonymous)
 				protected.DELETE("/connections/:ip", connectionsHandler.HandleTerminate)
+				protected.GET("/connections/:ip/details", connectionsHandler.HandleDetails)
+				protected.GET("/connections/individual", connectionsHandler.HandleListIndividual)
+				protected.DELETE("/connection/:conn_id", connectionsHandler.HandleTerminateConnection)
+
+				// Dashboard summary in a single request
+				overviewHandler := handlers.NewAdminOverviewHandler(r.configLoader, r.sessionManager, r.proxyManager, r.history, r.allowlistManager, r.blocklistManager)
+				protected.GET("/overview", etag, overviewHandler.HandleOverview)
+
+				// Traffic history for dashboard graphs
+				statsHandler := handlers.NewAdminStatsHandler(r.history, r.configLoader)
+				protected.GET("/stats/history", etag, statsHandler.HandleHistory)
+				protected.GET("/backends/:id/uptime", statsHandler.HandleUptime)
+
+				// Per-service operations
+				servicesHandler := handlers.NewAdminServicesHandler(r.configLoader, r.sessionManager, r.proxyManager, r.history, r.auditLog)
+				protected.GET("/services", etag, servicesHandler.HandleList)
+				protected.GET("/services/activity", etag, servicesHandler.HandleActivity)
+				protected.GET("/services/:id/top", servicesHandler.HandleTopTalkers)
+				protected.POST("/services/:id/circuit-breaker/reset", servicesHandler.HandleResetCircuitBreaker)
+				protected.POST("/services/:id/enable", servicesHandler.HandleEnable)
+				protected.POST("/services/:id/disable", servicesHandler.HandleDisable)
+				protected.DELETE("/services/:id/connections", servicesHandler.HandleTerminateConnections)
+
+				// Portal user accounts
+				accountsHandler := handlers.NewAdminAccountsHandler(r.configLoader, r.loginHistory, r.auditLog)
+				protected.PUT("/accounts/:user_id/must-change-password", accountsHandler.HandleSetMustChangePassword)
+				protected.GET("/portal-users/:id/logins", accountsHandler.HandleLoginHistory)
+
+				// Invites for portal self-registration
+				invitesHandler := handlers.NewAdminInvitesHandler(r.inviteManager, r.auditLog)
+				protected.GET("/invites", etag, invitesHandler.HandleList)
+				protected.POST("/invites", invitesHandler.HandleCreate)
+				protected.DELETE("/invites/:code", invitesHandler.HandleRevoke)
+
+				// Login approval requests and live admin events
+				requestsHandler := handlers.NewAdminRequestsHandler(r.approvalManager, r.eventBus, r.auditLog)
+				protected.GET("/requests", etag, requestsHandler.HandleList)
+				protected.GET("/requests/:id", requestsHandler.HandleGet)
+				protected.POST("/requests/:id", requestsHandler.HandleDecide)
+				protected.GET("/events", requestsHandler.HandleEvents)
+
+				// Allowlist entries with their provenance, dynamic DNS status
+				allowlistHandler := handlers.NewAdminAllowlistHandler(r.allowlistManager, r.sessionManager, r.auditLog)
+				protected.GET("/allowlist", etag, allowlistHandler.HandleList)
+				protected.DELETE("/allowlist", allowlistHandler.HandleDelete)
+				protected.GET("/dns", allowlistHandler.HandleDNSStatus)
+				protected.POST("/dns/refresh", allowlistHandler.HandleDNSRefresh)
+
+				// Blocklist with hit counters and temporary blocks (honeypot), recent failed logins
+				blocklistHandler := handlers.NewAdminBlocklistHandler(r.blocklistManager, r.auditLog)
+				protected.GET("/blocklist", etag, blocklistHandler.HandleList)
+				protected.GET("/blocklist/temporary", etag, blocklistHandler.HandleListTemporary)
+				protected.DELETE("/blocklist/temporary/:ip", blocklistHandler.HandleUnblock)
+				protected.GET("/auth-failures", etag, blocklistHandler.HandleAuthFailures)
+
+				// Backend reachability, circuit breakers and DNS resolution
+				protected.GET("/health/detailed", healthHandler.HandleDetailed)
+
+				// Audit trail of admin actions
+				auditHandler := handlers.NewAdminAuditHandler(r.auditLog)
+				protected.GET("/audit", etag, auditHandler.HandleList)
 
 				// Configuration management
-				configHandler := handlers.NewAdminConfigHandler(r.configLoader)
-				protected.GET("/config", configHandler.HandleGetConfig)
+				configHandler := handlers.NewAdminConfigHandler(r.configLoader, r.passwordVerifier, r.jwtManager, r.auditLog)
+				protected.GET("/config", middleware.ETag(false), configHandler.HandleGetConfig) // Holds secrets, so never stored by browsers
 				protected.PUT("/config", configHandler.HandleUpdateConfig)
+				protected.GET("/config/export", configHandler.HandleExport)
+				protected.POST("/config/import", configHandler.HandleImport)
+
+				// Backup and restore of config and runtime state
+				backupHandler := handlers.NewAdminBackupHandler(r.configLoader, r.sessionManager, r.allowlistManager, r.blocklistManager, r.history, r.auditLog, r.dataDir)
+				protected.GET("/backup", backupHandler.HandleBackup)
+				protected.POST("/restore", backupHandler.HandleRestore)
+
+				// pprof profiles and runtime statistics; disabled unless debug_config.enabled
+				debugHandler := handlers.NewAdminDebugHandler(r.configLoader)
+				debug := protected.Group("/debug", debugHandler.RequireEnabled)
+				debug.GET("/runtime", debugHandler.HandleRuntime)
+				debug.GET("/pprof/", debugHandler.HandlePprof)
+				debug.GET("/pprof/:profile", debugHandler.HandlePprof)
+				debug.POST("/pprof/symbol", debugHandler.HandlePprof)
 			}
 		}
 	}
 
+	// Prometheus scrape endpoint; disabled unless metrics_config.enabled
+	metricsHandler := handlers.NewMetricsHandler(r.configLoader, r.proxyManager)
+	r.engine.GET("/metrics", metricsHandler.Handle)
+
 	// Serve SPA static files
 	r.setupSPAHandler()
 }
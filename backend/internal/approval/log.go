@@ -0,0 +1,6 @@
+package approval
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["approval"] overrides its level
+var log = logging.Module("approval")
@@ -0,0 +1,326 @@
+// Package approval holds portal logins that need an administrator's decision.
+//
+// When approval is required, a successful login creates a pending request instead of a
+// session. Admins are notified through the event bus (and an optional webhook) and approve
+// or deny the request; approval creates the session and its allowlist entry. The client
+// polls its request to learn the outcome.
+package approval
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/google/uuid"
+)
+
+// Event types published on the bus
+const (
+	EventRequested = "approval.requested"
+	EventDecided   = "approval.decided"
+)
+
+const (
+	defaultRequestTimeout = 10 * time.Minute
+	decidedRetention      = time.Hour // Decided requests stay visible to polling clients this long
+)
+
+// Request errors
+var (
+	ErrNotFound   = errors.New("approval request not found")
+	ErrNotPending = errors.New("approval request is no longer pending")
+)
+
+// Status is the state of an approval request
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusDenied   Status = "denied"
+	StatusExpired  Status = "expired"
+)
+
+// Request is a login waiting for (or decided by) an administrator
+type Request struct {
+	ID                string     `json:"id"`
+	UserID            string     `json:"user_id"`
+	Username          string     `json:"username"`
+	ClientIP          string     `json:"client_ip"`
+	AllowedServiceIDs []string   `json:"allowed_service_ids"`
+	Status            Status     `json:"status"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	DecidedAt         *time.Time `json:"decided_at,omitempty"`
+	DecidedBy         string     `json:"decided_by,omitempty"` // Admin name, or telegram:<user> for Telegram decisions
+	SessionID         string     `json:"session_id,omitempty"` // Set once approved
+
+	limits session.Limits // Of the user at login time
+}
+
+// Manager tracks approval requests
+type Manager struct {
+	cfg              config.ApprovalConfiguration
+	sessionManager   *session.Manager
+	allowlistManager *ipallowlist.Manager
+	bus              *events.Bus
+	requests         map[string]*Request
+	mu               sync.Mutex
+	stopChan         chan struct{}
+	wg               sync.WaitGroup
+}
+
+// NewManager creates an approval manager
+func NewManager(cfg *config.ApprovalConfiguration, sessionManager *session.Manager, allowlistManager *ipallowlist.Manager, bus *events.Bus) *Manager {
+	return &Manager{
+		cfg:              *cfg,
+		sessionManager:   sessionManager,
+		allowlistManager: allowlistManager,
+		bus:              bus,
+		requests:         make(map[string]*Request),
+		stopChan:         make(chan struct{}),
+	}
+}
+
+// Start begins expiring stale requests
+func (m *Manager) Start() {
+	m.wg.Add(1)
+	go m.cleanupLoop()
+}
+
+// Stop ends the cleanup loop
+func (m *Manager) Stop() {
+	close(m.stopChan)
+	m.wg.Wait()
+}
+
+// Reload applies a new configuration; existing requests keep their expiry
+func (m *Manager) Reload(cfg *config.ApprovalConfiguration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cfg = *cfg
+}
+
+// Required reports whether a user's logins need approval
+func (m *Manager) Required(user *config.PortalUserAccount) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cfg.Enabled || user.RequireApproval
+}
+
+// Submit creates a pending request for a login, reusing an existing one for the same user and IP
+func (m *Manager) Submit(user *config.PortalUserAccount, clientIP netip.Addr) *Request {
+	m.mu.Lock()
+
+	for _, existing := range m.requests {
+		if existing.Status == StatusPending && existing.UserID == user.UserID && existing.ClientIP == clientIP.String() {
+			copied := *existing
+			m.mu.Unlock()
+			return &copied
+		}
+	}
+
+	timeout := time.Duration(m.cfg.RequestTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	webhookURL := m.cfg.WebhookURL
+
+	now := time.Now()
+	req := &Request{
+		ID:                uuid.New().String(),
+		UserID:            user.UserID,
+		Username:          user.Username,
+		ClientIP:          clientIP.String(),
+		AllowedServiceIDs: user.AllowedServiceIDs,
+		Status:            StatusPending,
+		limits:            session.LimitsFor(user),
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(timeout),
+	}
+	m.requests[req.ID] = req
+	copied := *req
+	m.mu.Unlock()
+
+	log.Info().
+		Str("request_id", req.ID).
+		Str("username", req.Username).
+		Str("client_ip", req.ClientIP).
+		Msg("Login awaiting admin approval")
+
+	event := m.bus.Publish(EventRequested, copied)
+	if webhookURL != "" {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			if err := events.SendWebhook(ctx, webhookURL, event); err != nil {
+				log.Warn().Err(err).Str("request_id", copied.ID).Msg("Failed to deliver approval webhook")
+			}
+		}()
+	}
+
+	return &copied
+}
+
+// Get returns a request by ID
+func (m *Manager) Get(id string) (*Request, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	req, ok := m.requests[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *req
+	return &copied, true
+}
+
+// List returns all known requests, newest first
+func (m *Manager) List() []Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := make([]Request, 0, len(m.requests))
+	for _, req := range m.requests {
+		requests = append(requests, *req)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.After(requests[j].CreatedAt)
+	})
+	return requests
+}
+
+// Approve starts the session for a pending request
+func (m *Manager) Approve(id, decidedBy string) (*Request, error) {
+	m.mu.Lock()
+	req, err := m.pending(id)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	clientIP, err := netip.ParseAddr(req.ClientIP)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	sess, err := m.sessionManager.CreateSession(req.UserID, req.Username, clientIP, req.AllowedServiceIDs, req.limits)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.allowlistManager.AddSessionPrefix(sess.SessionID, sess.GrantPrefix(clientIP), sess.ExpiresAt)
+
+	now := time.Now()
+	req.Status = StatusApproved
+	req.DecidedAt = &now
+	req.DecidedBy = decidedBy
+	req.SessionID = sess.SessionID
+	copied := *req
+	m.mu.Unlock()
+
+	log.Info().
+		Str("request_id", copied.ID).
+		Str("username", copied.Username).
+		Str("client_ip", copied.ClientIP).
+		Str("session_id", copied.SessionID).
+		Str("decided_by", decidedBy).
+		Msg("Login approved by admin")
+
+	m.bus.Publish(EventDecided, copied)
+	return &copied, nil
+}
+
+// Deny rejects a pending request
+func (m *Manager) Deny(id, decidedBy string) (*Request, error) {
+	m.mu.Lock()
+	req, err := m.pending(id)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	now := time.Now()
+	req.Status = StatusDenied
+	req.DecidedAt = &now
+	req.DecidedBy = decidedBy
+	copied := *req
+	m.mu.Unlock()
+
+	log.Info().
+		Str("request_id", copied.ID).
+		Str("username", copied.Username).
+		Str("client_ip", copied.ClientIP).
+		Str("decided_by", decidedBy).
+		Msg("Login denied by admin")
+
+	m.bus.Publish(EventDecided, copied)
+	return &copied, nil
+}
+
+// pending returns a request that can still be decided
+// Caller must hold mu
+func (m *Manager) pending(id string) (*Request, error) {
+	req, ok := m.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if req.Status == StatusPending && time.Now().After(req.ExpiresAt) {
+		m.expire(req)
+	}
+	if req.Status != StatusPending {
+		return nil, ErrNotPending
+	}
+	return req, nil
+}
+
+// expire marks a pending request as expired
+// Caller must hold mu
+func (m *Manager) expire(req *Request) {
+	now := time.Now()
+	req.Status = StatusExpired
+	req.DecidedAt = &now
+	m.bus.Publish(EventDecided, *req)
+}
+
+// cleanupLoop expires pending requests and forgets old decisions
+func (m *Manager) cleanupLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.cleanup()
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// cleanup expires pending requests past their deadline and removes old decided ones
+func (m *Manager) cleanup() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, req := range m.requests {
+		if req.Status == StatusPending && now.After(req.ExpiresAt) {
+			m.expire(req)
+			continue
+		}
+		if req.DecidedAt != nil && now.Sub(*req.DecidedAt) > decidedRetention {
+			delete(m.requests, id)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Package audit keeps a trail of the changes admins make through the admin API.
+//
+// Every admin mutation is recorded with the acting admin, written to the log, published on the
+// event bus as "admin.action" and kept in a bounded in-memory list. Config saves carry a
+// field-level before/after diff in which passwords, secrets and tokens are redacted.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/geoip"
+)
+
+// EventAdminAction is published on the bus for every recorded admin action
+const EventAdminAction = "admin.action"
+
+// Redacted replaces secret values in diffs and config dumps
+const Redacted = "[redacted]"
+
+// Change is one field that differs between two versions of a value
+type Change struct {
+	Field  string      `json:"field"` // e.g. portal_user_accounts[2].notes
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Entry is a single admin action
+type Entry struct {
+	Time     time.Time       `json:"time"`
+	Admin    string          `json:"admin"`
+	ClientIP string          `json:"client_ip"`
+	Location *geoip.Location `json:"location,omitempty"` // Of ClientIP, when GeoIP databases are configured
+	Action   string          `json:"action"`             // e.g. config.update, session.terminate
+	Target   string          `json:"target,omitempty"`   // What the action applied to (session ID, IP, ...)
+	Changes  []Change        `json:"changes,omitempty"`
+}
+
+// Log records admin actions
+type Log struct {
+	bus        *events.Bus
+	maxEntries int
+	geo        *geoip.Resolver // Adds the location of the admin's IP; nil = none
+
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// NewLog creates an audit log that keeps the last maxEntries actions in memory
+func NewLog(bus *events.Bus, maxEntries int) *Log {
+	return &Log{
+		bus:        bus,
+		maxEntries: maxEntries,
+	}
+}
+
+// SetGeoIP makes Record add the location of the admin's IP to each entry
+func (l *Log) SetGeoIP(resolver *geoip.Resolver) {
+	l.geo = resolver
+}
+
+// Record stores an action, logs it and publishes it on the event bus
+func (l *Log) Record(entry Entry) {
+	entry.Time = time.Now()
+	if entry.Location == nil && l.geo != nil {
+		entry.Location = l.geo.LookupString(entry.ClientIP)
+	}
+
+	l.mu.Lock()
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > l.maxEntries {
+		l.entries = append([]Entry(nil), l.entries[len(l.entries)-l.maxEntries:]...)
+	}
+	l.mu.Unlock()
+
+	event := log.Info().
+		Str("admin", entry.Admin).
+		Str("client_ip", entry.ClientIP).
+		Str("action", entry.Action).
+		Str("target", entry.Target)
+	if len(entry.Changes) > 0 {
+		event = event.Interface("changes", entry.Changes)
+	}
+	event.Msg("Admin action")
+
+	l.bus.Publish(EventAdminAction, entry)
+}
+
+// Restore adds entries from a backup (newest first, as List returns them) before the recorded ones
+// Entries are not logged or published again; returns how many were kept
+func (l *Log) Restore(entries []Entry) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	restored := make([]Entry, 0, len(entries)+len(l.entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		restored = append(restored, entries[i])
+	}
+	restored = append(restored, l.entries...)
+	kept := len(entries)
+	if len(restored) > l.maxEntries {
+		dropped := len(restored) - l.maxEntries
+		restored = restored[dropped:]
+		kept = max(0, kept-dropped)
+	}
+	l.entries = restored
+	return kept
+}
+
+// List returns the recorded actions, newest first
+func (l *Log) List() []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := make([]Entry, len(l.entries))
+	for i, entry := range l.entries {
+		entries[len(entries)-1-i] = entry
+	}
+	return entries
+}
+
+// Diff compares two JSON-encodable values field by field
+// Lists are compared by index; secret fields are reported as changed without their values
+func Diff(before, after interface{}) []Change {
+	changes := []Change{}
+	diffValue("", toJSONValue(before), toJSONValue(after), &changes)
+	return changes
+}
+
+// toJSONValue converts a value to the generic form encoding/json decodes into
+func toJSONValue(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil
+	}
+	return generic
+}
+
+func diffValue(path string, before, after interface{}, changes *[]Change) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if beforeIsMap && afterIsMap {
+		keys := make([]string, 0, len(beforeMap)+len(afterMap))
+		for key := range beforeMap {
+			keys = append(keys, key)
+		}
+		for key := range afterMap {
+			if _, ok := beforeMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			diffValue(field, beforeMap[key], afterMap[key], changes)
+		}
+		return
+	}
+
+	beforeList, beforeIsList := before.([]interface{})
+	afterList, afterIsList := after.([]interface{})
+	if beforeIsList && afterIsList {
+		for i := 0; i < len(beforeList) || i < len(afterList); i++ {
+			var beforeItem, afterItem interface{}
+			if i < len(beforeList) {
+				beforeItem = beforeList[i]
+			}
+			if i < len(afterList) {
+				afterItem = afterList[i]
+			}
+			diffValue(fmt.Sprintf("%s[%d]", path, i), beforeItem, afterItem, changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+
+	if config.IsSecretField(path) {
+		*changes = append(*changes, Change{Field: path, Before: redactValue(before), After: redactValue(after)})
+		return
+	}
+	*changes = append(*changes, Change{Field: path, Before: redactSecrets(before), After: redactSecrets(after)})
+}
+
+func redactValue(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return Redacted
+}
+
+// redactSecrets hides secret fields inside added or removed objects (e.g. a new user)
+func redactSecrets(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if config.IsSecretField(key) {
+				copied[key] = redactValue(item)
+			} else {
+				copied[key] = redactSecrets(item)
+			}
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = redactSecrets(item)
+		}
+		return copied
+	default:
+		return v
+	}
+}
@@ -0,0 +1,6 @@
+package audit
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["audit"] overrides its level
+var log = logging.Module("audit")
@@ -0,0 +1,44 @@
+package audit
+
+import (
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// RedactYAML replaces non-empty string values of secret keys, keeping the document order intact
+func RedactYAML(node *yaml.Node) {
+	walkSecrets(node, func(value *yaml.Node) {
+		value.Value = Redacted
+		value.Style = yaml.DoubleQuotedStyle
+	})
+}
+
+// ContainsRedacted reports whether a secret key of a YAML document still holds the Redacted placeholder
+func ContainsRedacted(node *yaml.Node) bool {
+	found := false
+	walkSecrets(node, func(value *yaml.Node) {
+		if value.Value == Redacted {
+			found = true
+		}
+	})
+	return found
+}
+
+// walkSecrets calls fn for every non-empty string value of a secret key
+func walkSecrets(node *yaml.Node, fn func(value *yaml.Node)) {
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			walkSecrets(child, fn)
+		}
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if config.IsSecretField(key.Value) && value.Kind == yaml.ScalarNode && value.Tag == "!!str" && value.Value != "" {
+			fn(value)
+			continue
+		}
+		walkSecrets(value, fn)
+	}
+}
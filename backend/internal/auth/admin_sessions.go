@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidRefreshToken is returned for unknown, expired, revoked or reused refresh tokens
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// adminSession is a signed-in admin; its access tokens are valid only while it exists
+type adminSession struct {
+	adminName   string
+	credential  [sha256.Size]byte // Fingerprint of the admin's password hash at sign-in
+	refreshHash [sha256.Size]byte
+	expiresAt   time.Time // Refresh token expiry, moved forward on every refresh
+}
+
+// CurrentCredentials returns the password hashes an admin can sign in with now; none if the admin no longer exists
+type CurrentCredentials func(adminName string) []string
+
+// AdminSessionStore tracks signed-in admins so their tokens can be refreshed and revoked
+// Refresh tokens are "<session ID>.<secret>" and are rotated on every use
+type AdminSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*adminSession // session ID -> session
+}
+
+// NewAdminSessionStore creates an empty store
+func NewAdminSessionStore() *AdminSessionStore {
+	return &AdminSessionStore{
+		sessions: make(map[string]*adminSession),
+	}
+}
+
+// Create starts an admin session and returns its ID and first refresh token
+// credential is the password hash the admin signed in with; refreshes fail once it changes
+func (s *AdminSessionStore) Create(adminName, credential string, lifetime time.Duration) (string, string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneExpired()
+	s.sessions[sessionID] = &adminSession{
+		adminName:   adminName,
+		credential:  sha256.Sum256([]byte(credential)),
+		refreshHash: sha256.Sum256([]byte(secret)),
+		expiresAt:   time.Now().Add(lifetime),
+	}
+	return sessionID, sessionID + "." + secret, nil
+}
+
+// Refresh exchanges a refresh token for a new one and extends the session by lifetime
+// A token that was already rotated away ends the session, since it may have been stolen, and so does
+// an admin that was removed or whose password changed since signing in
+func (s *AdminSessionStore) Refresh(refreshToken string, lifetime time.Duration, current CurrentCredentials) (sessionID, adminName, newToken string, err error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	newSecret, err := randomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists || time.Now().After(session.expiresAt) {
+		delete(s.sessions, sessionID)
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], session.refreshHash[:]) != 1 {
+		delete(s.sessions, sessionID)
+		log.Warn().
+			Str("admin", session.adminName).
+			Msg("Reused admin refresh token, admin session revoked")
+		return "", "", "", ErrInvalidRefreshToken
+	}
+	if !session.credentialIn(current(session.adminName)) {
+		delete(s.sessions, sessionID)
+		log.Info().
+			Str("admin", session.adminName).
+			Msg("Admin account removed or password changed, admin session ended")
+		return "", "", "", ErrInvalidRefreshToken
+	}
+
+	session.refreshHash = sha256.Sum256([]byte(newSecret))
+	session.expiresAt = time.Now().Add(lifetime)
+	return sessionID, session.adminName, sessionID + "." + newSecret, nil
+}
+
+// Revoke ends an admin session; its access and refresh tokens stop working immediately
+func (s *AdminSessionStore) Revoke(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
+
+// credentialIn reports whether the password hash the session signed in with is one of credentials
+func (session *adminSession) credentialIn(credentials []string) bool {
+	for _, credential := range credentials {
+		fingerprint := sha256.Sum256([]byte(credential))
+		if subtle.ConstantTimeCompare(fingerprint[:], session.credential[:]) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// RevokeCredential ends the sessions an admin signed in with the given password hash and returns how many there were
+func (s *AdminSessionStore) RevokeCredential(adminName, credential string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	for sessionID, session := range s.sessions {
+		if session.adminName == adminName && session.credentialIn([]string{credential}) {
+			delete(s.sessions, sessionID)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// IsActive reports whether an admin session exists and has not expired
+func (s *AdminSessionStore) IsActive(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	return exists && time.Now().Before(session.expiresAt)
+}
+
+// pruneExpired forgets expired sessions; caller must hold mu
+func (s *AdminSessionStore) pruneExpired() {
+	now := time.Now()
+	for sessionID, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, sessionID)
+		}
+	}
+}
+
+// randomToken returns n random bytes, URL-safe encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Logins whose failures are recorded
+const (
+	LoginPortal = "portal"
+	LoginAdmin  = "admin"
+	LoginInvite = "invite" // Guessed invite codes
+)
+
+// maxFailureRecords bounds the failed logins kept in memory, oldest are dropped first
+const maxFailureRecords = 1000
+
+// maxFailureUsernames bounds the distinct usernames reported per client
+const maxFailureUsernames = 10
+
+// failureRecord is one failed login
+type failureRecord struct {
+	time     time.Time
+	kind     string
+	ip       string
+	username string
+	limiter  *RateLimiter // Holds the client's backoff state
+}
+
+// failureLog keeps the most recent failed logins of all rate limiters, oldest first
+var failureLog struct {
+	mu      sync.Mutex
+	records []failureRecord
+}
+
+// AuthFailure summarizes the recent failed logins of one client on one login
+type AuthFailure struct {
+	ClientIP  string       `json:"client_ip"`
+	Kind      string       `json:"kind"`      // portal | admin | invite
+	Usernames []string     `json:"usernames"` // Attempted usernames, most recent first; empty for invites
+	Count     int          `json:"count"`     // Failures among the recorded ones
+	FirstSeen time.Time    `json:"first_seen"`
+	LastSeen  time.Time    `json:"last_seen"`
+	Backoff   BackoffState `json:"backoff"`
+}
+
+// BackoffState is how the rate limiter currently treats a client
+type BackoffState struct {
+	ConsecutiveFailures int    `json:"consecutive_failures"` // Reset by a successful login
+	Level               string `json:"level"`                // none | reduced (default 3+ failures) | severe (default 5+ failures)
+	Limited             bool   `json:"limited"`              // The next attempt would be rejected
+	RetryAfterSeconds   int    `json:"retry_after_seconds"`  // Until the next attempt is allowed; 0 = now
+}
+
+// RecordFailedLogin is RecordFailure for a login, also keeping the attempt for RecentAuthFailures
+// username is the name that was tried; empty when the login has none
+func (rl *RateLimiter) RecordFailedLogin(kind, ip, username string) {
+	rl.RecordFailure(ip)
+
+	failureLog.mu.Lock()
+	defer failureLog.mu.Unlock()
+
+	failureLog.records = append(failureLog.records, failureRecord{
+		time:     time.Now(),
+		kind:     kind,
+		ip:       ip,
+		username: username,
+		limiter:  rl,
+	})
+	if len(failureLog.records) > maxFailureRecords {
+		failureLog.records = append([]failureRecord(nil), failureLog.records[len(failureLog.records)-maxFailureRecords:]...)
+	}
+}
+
+// RecentAuthFailures groups the recorded failed logins by client and login, most recent first
+// At most limit clients are returned
+func RecentAuthFailures(limit int) []AuthFailure {
+	type key struct{ kind, ip string }
+
+	failureLog.mu.Lock()
+	groups := make(map[key]*AuthFailure)
+	limiters := make(map[key]*RateLimiter)
+	order := []key{}
+	for i := len(failureLog.records) - 1; i >= 0; i-- {
+		record := failureLog.records[i]
+		k := key{record.kind, record.ip}
+		failure, ok := groups[k]
+		if !ok {
+			failure = &AuthFailure{
+				ClientIP:  record.ip,
+				Kind:      record.kind,
+				Usernames: []string{},
+				LastSeen:  record.time,
+			}
+			groups[k] = failure
+			limiters[k] = record.limiter
+			order = append(order, k)
+		}
+		failure.Count++
+		failure.FirstSeen = record.time
+		if record.username != "" && len(failure.Usernames) < maxFailureUsernames && !slices.Contains(failure.Usernames, record.username) {
+			failure.Usernames = append(failure.Usernames, record.username)
+		}
+	}
+	failureLog.mu.Unlock()
+
+	if len(order) > limit {
+		order = order[:limit]
+	}
+	failures := make([]AuthFailure, 0, len(order))
+	for _, k := range order {
+		failure := groups[k]
+		failure.Backoff = limiters[k].Backoff(k.ip)
+		failures = append(failures, *failure)
+	}
+	return failures
+}
+
+// Backoff returns how the limiter currently treats an IP
+func (rl *RateLimiter) Backoff(ip string) BackoffState {
+	state := BackoffState{Level: "none"}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	elem, exists := rl.limiters[ip]
+	if !exists {
+		return state
+	}
+	entry := elem.Value.(*lruEntry).entry
+	state.ConsecutiveFailures = entry.failCount
+	switch {
+	case entry.failCount >= rl.severeAfter:
+		state.Level = "severe"
+	case entry.failCount >= rl.reducedAfter:
+		state.Level = "reduced"
+	}
+
+	if tokens := entry.limiter.Tokens(); tokens < 1 {
+		state.Limited = true
+		if limit := float64(entry.limiter.Limit()); limit > 0 {
+			state.RetryAfterSeconds = int(math.Ceil((1 - tokens) / limit))
+		}
+	}
+	return state
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"os"
 	"time"
@@ -19,14 +21,15 @@ const (
 // JWTClaims represents the JWT token claims
 type JWTClaims struct {
 	UserID    string    `json:"user_id"`
-	SessionID string    `json:"session_id,omitempty"` // Empty for admin tokens
+	SessionID string    `json:"session_id,omitempty"` // Portal session, or admin session for admin tokens
 	TokenType TokenType `json:"token_type"`
 	jwt.RegisteredClaims
 }
 
 // JWTManager handles JWT token operations
 type JWTManager struct {
-	signingKey []byte
+	signingKey    []byte
+	adminSessions *AdminSessionStore
 }
 
 // NewJWTManager creates a new JWT manager
@@ -42,10 +45,20 @@ func NewJWTManager() (*JWTManager, error) {
 	}
 
 	return &JWTManager{
-		signingKey: []byte(signingKey),
+		signingKey:    []byte(signingKey),
+		adminSessions: NewAdminSessionStore(),
 	}, nil
 }
 
+// GenerateSigningKey returns a random base64 key suitable for JWT_SIGNING_SECRET_KEY
+func GenerateSigningKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
 // validateJWTKey validates the minimum length of the JWT signing key
 func validateJWTKey(key string) error {
 	// Minimum length check (256 bits = 32 bytes for HS256)
@@ -73,10 +86,11 @@ func (m *JWTManager) GeneratePortalToken(userID, sessionID string, expiresIn tim
 }
 
 // GenerateAdminToken generates a JWT token for admin access
-func (m *JWTManager) GenerateAdminToken(expiresIn time.Duration) (string, error) {
+// adminName identifies the admin in the audit trail; the token is revoked with its admin session
+func (m *JWTManager) GenerateAdminToken(adminName, sessionID string, expiresIn time.Duration) (string, error) {
 	claims := JWTClaims{
-		UserID:    "admin",
-		SessionID: "",
+		UserID:    adminName,
+		SessionID: sessionID,
 		TokenType: TokenTypeAdmin,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
@@ -89,6 +103,11 @@ func (m *JWTManager) GenerateAdminToken(expiresIn time.Duration) (string, error)
 	return token.SignedString(m.signingKey)
 }
 
+// AdminSessions returns the store of signed-in admins
+func (m *JWTManager) AdminSessions() *AdminSessionStore {
+	return m.adminSessions
+}
+
 // ValidateToken validates a JWT token and returns the claims
 func (m *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
@@ -108,5 +127,10 @@ func (m *JWTManager) ValidateToken(tokenString string) (*JWTClaims, error) {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	// Admin tokens die with their session (logout, reused refresh token)
+	if claims.TokenType == TokenTypeAdmin && !m.adminSessions.IsActive(claims.SessionID) {
+		return nil, fmt.Errorf("admin session revoked or expired")
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,6 @@
+package auth
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["auth"] overrides its level
+var log = logging.Module("auth")
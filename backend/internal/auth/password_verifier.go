@@ -13,10 +13,11 @@ type PasswordVerifier struct {
 }
 
 // NewPasswordVerifier creates a new password verifier
-func NewPasswordVerifier() (*PasswordVerifier, error) {
+// ADMIN_PASSWORD_BCRYPT_HASH may be left unset when admin accounts are configured in the config file
+func NewPasswordVerifier(hasConfiguredAdmins bool) (*PasswordVerifier, error) {
 	adminHash := os.Getenv("ADMIN_PASSWORD_BCRYPT_HASH")
-	if adminHash == "" {
-		return nil, fmt.Errorf("ADMIN_PASSWORD_BCRYPT_HASH environment variable is required")
+	if adminHash == "" && !hasConfiguredAdmins {
+		return nil, fmt.Errorf("ADMIN_PASSWORD_BCRYPT_HASH environment variable or admin_accounts in the config file is required")
 	}
 
 	return &PasswordVerifier{
@@ -24,11 +25,24 @@ func NewPasswordVerifier() (*PasswordVerifier, error) {
 	}, nil
 }
 
-// VerifyAdminPassword verifies the admin password
+// VerifyAdminPassword verifies the admin password from ADMIN_PASSWORD_BCRYPT_HASH
 func (v *PasswordVerifier) VerifyAdminPassword(password string) error {
+	if v.adminPasswordHash == "" {
+		return fmt.Errorf("ADMIN_PASSWORD_BCRYPT_HASH is not set")
+	}
 	return bcrypt.CompareHashAndPassword([]byte(v.adminPasswordHash), []byte(password))
 }
 
+// HasAdminPassword reports whether ADMIN_PASSWORD_BCRYPT_HASH is set
+func (v *PasswordVerifier) HasAdminPassword() bool {
+	return v.adminPasswordHash != ""
+}
+
+// AdminPasswordHash returns ADMIN_PASSWORD_BCRYPT_HASH; empty if it is not set
+func (v *PasswordVerifier) AdminPasswordHash() string {
+	return v.adminPasswordHash
+}
+
 // VerifyUserPassword verifies a user's password against a bcrypt hash
 func (v *PasswordVerifier) VerifyUserPassword(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
@@ -3,6 +3,7 @@ package auth
 import (
 	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -17,6 +18,20 @@ type RateLimiter struct {
 	burst      int
 	maxEntries int // Maximum number of limiters to cache
 	maxIdleAge time.Duration
+
+	// Consecutive failures after which an IP is slowed down
+	reducedAfter int // 1 request per 10 seconds
+	severeAfter  int // 1 request per 100 seconds
+}
+
+// LimiterSettings configures a login rate limiter; zero values use the defaults
+type LimiterSettings struct {
+	RequestsPerMinute    int
+	Burst                int
+	MaxEntries           int           // 0 = 10000
+	ReducedAfterFailures int           // 0 = 3
+	SevereAfterFailures  int           // 0 = 5
+	MaxIdleAge           time.Duration // IPs idle this long are forgotten with their failures (0 = 15 minutes)
 }
 
 // lruEntry represents an entry in the LRU cache
@@ -47,9 +62,63 @@ func NewRateLimiter(requestsPerMinute int, burst int, maxEntries int) *RateLimit
 		burst:      burst,
 		maxEntries: maxEntries,
 		maxIdleAge: 15 * time.Minute,
+
+		reducedAfter: 3,
+		severeAfter:  5,
 	}
 }
 
+// NewLoginRateLimiter creates a rate limiter from settings
+func NewLoginRateLimiter(settings LimiterSettings) *RateLimiter {
+	rl := NewRateLimiter(settings.RequestsPerMinute, settings.Burst, settings.MaxEntries)
+	rl.Reload(settings)
+	return rl
+}
+
+// Reload applies new settings; IPs in backoff keep their reduced rate until they go idle
+func (rl *RateLimiter) Reload(settings LimiterSettings) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.rate = rate.Limit(float64(settings.RequestsPerMinute) / 60.0)
+	rl.burst = settings.Burst
+	rl.maxEntries = settings.MaxEntries
+	if rl.maxEntries <= 0 {
+		rl.maxEntries = 10000
+	}
+	rl.reducedAfter = settings.ReducedAfterFailures
+	if rl.reducedAfter <= 0 {
+		rl.reducedAfter = 3
+	}
+	rl.severeAfter = settings.SevereAfterFailures
+	if rl.severeAfter <= 0 {
+		rl.severeAfter = 5
+	}
+	rl.maxIdleAge = settings.MaxIdleAge
+	if rl.maxIdleAge <= 0 {
+		rl.maxIdleAge = 15 * time.Minute
+	}
+
+	for _, elem := range rl.limiters {
+		entry := elem.Value.(*lruEntry).entry
+		if entry.failCount < rl.reducedAfter {
+			entry.limiter.SetLimit(rl.rate)
+			entry.limiter.SetBurst(rl.burst)
+		}
+	}
+	for rl.lruList.Len() > rl.maxEntries {
+		rl.evictOldest()
+	}
+}
+
+// NewWindowRateLimiter creates a rate limiter allowing events per window, all of them at once
+// (burst = events), refilling evenly over the window
+func NewWindowRateLimiter(events int, window time.Duration, maxEntries int) *RateLimiter {
+	rl := NewRateLimiter(0, events, maxEntries)
+	rl.rate = rate.Limit(float64(events) / window.Seconds())
+	return rl
+}
+
 // Allow checks if a request from the given IP is allowed
 func (rl *RateLimiter) Allow(ip string) bool {
 	rl.mu.Lock()
@@ -95,8 +164,58 @@ func (rl *RateLimiter) evictOldest() {
 	}
 }
 
+// loginFailures counts failed authentication attempts of all rate limiters since startup
+var loginFailures atomic.Int64
+
+// recentFailures counts failed authentication attempts per minute over the last hour
+var recentFailures struct {
+	mu      sync.Mutex
+	minutes [60]int64 // Unix minute each slot was last used for
+	counts  [60]int64
+}
+
+// LoginFailures returns the number of failed portal, invite and admin logins since startup
+func LoginFailures() int64 {
+	return loginFailures.Load()
+}
+
+// RecentLoginFailures returns the number of failed logins within the window, at most the last hour
+func RecentLoginFailures(window time.Duration) int64 {
+	now := time.Now().Unix() / 60
+	oldest := now - int64(min(window, time.Hour)/time.Minute)
+
+	recentFailures.mu.Lock()
+	defer recentFailures.mu.Unlock()
+
+	var total int64
+	for i, minute := range recentFailures.minutes {
+		if minute > oldest && minute <= now {
+			total += recentFailures.counts[i]
+		}
+	}
+	return total
+}
+
+// countFailure adds a failed attempt to the totals
+func countFailure() {
+	loginFailures.Add(1)
+
+	minute := time.Now().Unix() / 60
+	slot := minute % int64(len(recentFailures.minutes))
+
+	recentFailures.mu.Lock()
+	if recentFailures.minutes[slot] != minute {
+		recentFailures.minutes[slot] = minute
+		recentFailures.counts[slot] = 0
+	}
+	recentFailures.counts[slot]++
+	recentFailures.mu.Unlock()
+}
+
 // RecordFailure tracks a failed authentication attempt and applies exponential backoff
 func (rl *RateLimiter) RecordFailure(ip string) {
+	countFailure()
+
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
@@ -125,11 +244,11 @@ func (rl *RateLimiter) RecordFailure(ip string) {
 	lru.entry.lastAccessed = time.Now()
 
 	// Exponential backoff: reduce rate after repeated failures
-	if lru.entry.failCount >= 5 {
-		// Severely limit after 5 failures: 1 request per 100 seconds
+	if lru.entry.failCount >= rl.severeAfter {
+		// Severely limit (default after 5 failures): 1 request per 100 seconds
 		lru.entry.limiter = rate.NewLimiter(rate.Limit(0.01), 1)
-	} else if lru.entry.failCount >= 3 {
-		// Reduce after 3 failures: 1 request per 10 seconds
+	} else if lru.entry.failCount >= rl.reducedAfter {
+		// Reduce (default after 3 failures): 1 request per 10 seconds
 		lru.entry.limiter = rate.NewLimiter(rate.Limit(0.1), 2)
 	}
 
@@ -150,6 +269,17 @@ func (rl *RateLimiter) RecordSuccess(ip string) {
 	}
 }
 
+// FailureCount returns the consecutive failed attempts recorded for an IP
+func (rl *RateLimiter) FailureCount(ip string) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if elem, exists := rl.limiters[ip]; exists {
+		return elem.Value.(*lruEntry).entry.failCount
+	}
+	return 0
+}
+
 // Cleanup removes old limiters (should be called periodically)
 func (rl *RateLimiter) Cleanup() {
 	rl.mu.Lock()
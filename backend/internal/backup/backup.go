@@ -0,0 +1,284 @@
+// Package backup writes and reads portal backup archives.
+//
+// An archive is a gzip-compressed tar file with the config file, the runtime state that only lives
+// in memory (portal sessions, temporary blocks, the admin audit trail) and the files of the data
+// directory (traffic and uptime history, invites). Archives made through the admin API contain
+// everything; the backup command runs without a server and only has what is on disk.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+)
+
+const (
+	formatVersion = 1
+
+	manifestName = "manifest.json"
+	configName   = "config.yml"
+	stateName    = "state.json"
+	dataPrefix   = "data/"
+
+	// PendingStateFile holds restored runtime state in the data directory until the next start applies it
+	PendingStateFile = "restored_state.json"
+
+	maxEntrySize = 256 << 20 // Per archive entry; history files are the largest
+)
+
+// Manifest describes an archive
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Source    string    `json:"source"` // api | command
+}
+
+// State is the runtime state kept in memory by a running server
+type State struct {
+	Sessions        []*session.Session           `json:"sessions"`
+	TemporaryBlocks []ipblocklist.TemporaryBlock `json:"temporary_blocks"`
+	Audit           []audit.Entry                `json:"audit"` // Newest first
+}
+
+// Archive is the content of a backup
+type Archive struct {
+	Manifest  Manifest
+	Config    []byte
+	State     State
+	DataFiles map[string][]byte // File name in the data directory -> content
+}
+
+// Write encodes an archive as tar.gz
+func Write(w io.Writer, archive *Archive) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(archive.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	state, err := json.Marshal(archive.State)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{manifestName, manifest},
+		{configName, archive.Config},
+		{stateName, state},
+	}
+	for name, data := range archive.DataFiles {
+		entries = append(entries, struct {
+			name string
+			data []byte
+		}{dataPrefix + name, data})
+	}
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    0o600,
+			Size:    int64(len(entry.data)),
+			ModTime: archive.Manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Read decodes a tar.gz archive written by Write
+func Read(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	archive := &Archive{DataFiles: make(map[string][]byte)}
+	var haveManifest, haveState bool
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("corrupt backup archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Size > maxEntrySize {
+			return nil, fmt.Errorf("backup entry %s is too large", header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxEntrySize))
+		if err != nil {
+			return nil, fmt.Errorf("corrupt backup archive: %w", err)
+		}
+
+		switch name := header.Name; {
+		case name == manifestName:
+			if err := json.Unmarshal(data, &archive.Manifest); err != nil {
+				return nil, fmt.Errorf("invalid backup manifest: %w", err)
+			}
+			haveManifest = true
+		case name == configName:
+			archive.Config = data
+		case name == stateName:
+			if err := json.Unmarshal(data, &archive.State); err != nil {
+				return nil, fmt.Errorf("invalid backup state: %w", err)
+			}
+			haveState = true
+		case strings.HasPrefix(name, dataPrefix):
+			// Only plain file names, so extracting can never leave the data directory
+			file := strings.TrimPrefix(name, dataPrefix)
+			if file == "" || file != path.Base(file) || file == "." || file == ".." {
+				return nil, fmt.Errorf("invalid data file name %q in backup", name)
+			}
+			archive.DataFiles[file] = data
+		}
+	}
+
+	if !haveManifest || !haveState || archive.Config == nil {
+		return nil, errors.New("incomplete backup archive: manifest, config or state missing")
+	}
+	if archive.Manifest.Version > formatVersion {
+		return nil, fmt.Errorf("backup format version %d is newer than supported (%d)", archive.Manifest.Version, formatVersion)
+	}
+	return archive, nil
+}
+
+// NewManifest describes an archive created now
+func NewManifest(source string) Manifest {
+	return Manifest{
+		Version:   formatVersion,
+		CreatedAt: time.Now().UTC(),
+		Source:    source,
+	}
+}
+
+// ReadDataFiles returns the regular files at the top of the data directory
+// A pending restore state is left out; a missing directory has no files
+func ReadDataFiles(dataDir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return files, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || name == PendingStateFile || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dataDir, name))
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// SavePendingState stores runtime state in the data directory for the next start to apply
+func SavePendingState(dataDir string, state State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dataDir, PendingStateFile), data, 0o600)
+}
+
+// LoadPendingState reads runtime state saved by SavePendingState; ok is false if there is none
+func LoadPendingState(dataDir string) (state State, ok bool, err error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, PendingStateFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return State{}, false, nil
+		}
+		return State{}, false, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, false, fmt.Errorf("invalid restored state: %w", err)
+	}
+	return state, true, nil
+}
+
+// RemovePendingState deletes the pending state once it has been applied
+func RemovePendingState(dataDir string) error {
+	err := os.Remove(filepath.Join(dataDir, PendingStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Targets are the managers restored runtime state is applied to
+type Targets struct {
+	Sessions  *session.Manager
+	Allowlist *ipallowlist.Manager
+	Blocklist *ipblocklist.Manager
+	Audit     *audit.Log
+}
+
+// Applied counts what Apply restored
+type Applied struct {
+	Sessions        int `json:"sessions"`
+	TemporaryBlocks int `json:"temporary_blocks"`
+	AuditEntries    int `json:"audit_entries"`
+}
+
+// Apply re-creates sessions with their allowlist entries, temporary blocks and audit entries
+// Expired sessions and blocks and sessions that already exist are skipped
+func Apply(state State, targets Targets) Applied {
+	var applied Applied
+
+	for _, sess := range state.Sessions {
+		if !targets.Sessions.RestoreSession(sess) {
+			continue
+		}
+		for _, ip := range sess.AuthenticatedIPAddresses {
+			targets.Allowlist.AddSessionPrefix(sess.SessionID, sess.GrantPrefix(ip), sess.ExpiresAt)
+		}
+		applied.Sessions++
+	}
+
+	for _, block := range state.TemporaryBlocks {
+		if targets.Blocklist.RestoreTemporaryBlock(block) {
+			applied.TemporaryBlocks++
+		}
+	}
+
+	applied.AuditEntries = targets.Audit.Restore(state.Audit)
+	return applied
+}
@@ -0,0 +1,314 @@
+// Package bruteforce counts failed logins across the portal and admin logins.
+//
+// Each login handler has its own rate limiter, so an attacker alternating between
+// /api/portal/login and /api/admin/login gets both budgets. The guard counts failures
+// per client IP over both logins and per attempted username: an IP over the limit is
+// temporarily blocked, a username over the limit is locked for every IP. Blocks and
+// lockouts are written to the audit trail and published on the event bus.
+package bruteforce
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+)
+
+// Events published on the bus
+const (
+	EventBlocked = "bruteforce.blocked" // An IP was blocked
+	EventLocked  = "bruteforce.locked"  // A username was locked
+)
+
+// auditActor is the admin name of audit entries written by the guard
+const auditActor = "system"
+
+// maxTracked bounds the IPs and usernames tracked; when full, the one with the oldest last failure makes room
+const maxTracked = 10000
+
+// pruneInterval is how often counters without recent failures are dropped
+const pruneInterval = time.Minute
+
+const (
+	defaultWindow                 = 15 * time.Minute
+	defaultMaxFailuresPerIP       = 20
+	defaultMaxFailuresPerUsername = 10
+	defaultBlockDuration          = time.Hour
+	defaultLockout                = 15 * time.Minute
+)
+
+// Block describes an IP blocked or a username locked by the guard
+type Block struct {
+	Login    string    `json:"login"`              // portal | admin
+	ClientIP string    `json:"client_ip"`          // IP of the failure that crossed the limit
+	Username string    `json:"username,omitempty"` // Set for a locked username
+	Failures int       `json:"failures"`           // Within the window
+	Until    time.Time `json:"until"`
+}
+
+// policy is the effective configuration with defaults applied
+type policy struct {
+	enabled                bool
+	window                 time.Duration
+	maxFailuresPerIP       int
+	maxFailuresPerUsername int
+	blockDuration          time.Duration
+	lockout                time.Duration
+}
+
+// counter holds the recent failures of an IP or username
+type counter struct {
+	failures    []time.Time // Oldest first
+	lockedUntil time.Time   // Usernames only
+}
+
+// Guard counts failed logins and blocks IPs and locks usernames over the limits
+type Guard struct {
+	blocklistManager *ipblocklist.Manager
+	allowlistManager *ipallowlist.Manager
+	auditLog         *audit.Log
+	bus              *events.Bus
+
+	mu         sync.Mutex
+	policy     policy
+	byIP       map[string]*counter
+	byUsername map[string]*counter // Keyed by login and username
+	lastPrune  time.Time
+	lastFull   time.Time // Last time a full table was logged
+}
+
+// NewGuard creates a guard with the given configuration
+func NewGuard(cfg *config.BruteForceConfiguration, blocklistManager *ipblocklist.Manager, allowlistManager *ipallowlist.Manager, auditLog *audit.Log, bus *events.Bus) *Guard {
+	g := &Guard{
+		blocklistManager: blocklistManager,
+		allowlistManager: allowlistManager,
+		auditLog:         auditLog,
+		bus:              bus,
+		byIP:             make(map[string]*counter),
+		byUsername:       make(map[string]*counter),
+	}
+	g.Reload(cfg)
+	return g
+}
+
+// Reload applies a new configuration; counted failures are kept
+func (g *Guard) Reload(cfg *config.BruteForceConfiguration) {
+	p := policy{
+		enabled:                cfg.Enabled,
+		window:                 time.Duration(cfg.WindowSeconds) * time.Second,
+		maxFailuresPerIP:       cfg.MaxFailuresPerIP,
+		maxFailuresPerUsername: cfg.MaxFailuresPerUsername,
+		blockDuration:          time.Duration(cfg.BlockDurationSeconds) * time.Second,
+		lockout:                time.Duration(cfg.LockoutSeconds) * time.Second,
+	}
+	if p.window <= 0 {
+		p.window = defaultWindow
+	}
+	if p.maxFailuresPerIP <= 0 {
+		p.maxFailuresPerIP = defaultMaxFailuresPerIP
+	}
+	if p.maxFailuresPerUsername <= 0 {
+		p.maxFailuresPerUsername = defaultMaxFailuresPerUsername
+	}
+	if p.blockDuration <= 0 {
+		p.blockDuration = defaultBlockDuration
+	}
+	if p.lockout <= 0 {
+		p.lockout = defaultLockout
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policy = p
+	if !p.enabled {
+		g.byIP = make(map[string]*counter)
+		g.byUsername = make(map[string]*counter)
+	}
+}
+
+// LockedUntil reports whether logins as username are refused, and until when
+// Consulted before the password is verified, so a locked username can't be guessed either
+func (g *Guard) LockedUntil(login, username string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.policy.enabled {
+		return time.Time{}, false
+	}
+	c, ok := g.byUsername[usernameKey(login, username)]
+	if !ok || !time.Now().Before(c.lockedUntil) {
+		return time.Time{}, false
+	}
+	return c.lockedUntil, true
+}
+
+// RecordFailure counts a failed login, blocking the IP or locking the username when over a limit
+func (g *Guard) RecordFailure(login string, clientIP netip.Addr, username string) {
+	clientIP = clientIP.Unmap()
+	now := time.Now()
+
+	g.mu.Lock()
+	p := g.policy
+	if !p.enabled {
+		g.mu.Unlock()
+		return
+	}
+	if now.Sub(g.lastPrune) >= pruneInterval {
+		g.prune(now)
+	}
+
+	var blocked, locked *Block
+	if ipFailures := g.add(g.byIP, clientIP.String(), now); ipFailures >= p.maxFailuresPerIP {
+		// Start over so the next block needs a full set of new failures
+		delete(g.byIP, clientIP.String())
+		blocked = &Block{Login: login, ClientIP: clientIP.String(), Failures: ipFailures, Until: now.Add(p.blockDuration)}
+	}
+	if username != "" {
+		key := usernameKey(login, username)
+		if userFailures := g.add(g.byUsername, key, now); userFailures >= p.maxFailuresPerUsername {
+			c := g.byUsername[key]
+			c.failures = nil
+			c.lockedUntil = now.Add(p.lockout)
+			locked = &Block{Login: login, ClientIP: clientIP.String(), Username: username, Failures: userFailures, Until: c.lockedUntil}
+		}
+	}
+	g.mu.Unlock()
+
+	if blocked != nil {
+		g.block(clientIP, blocked, p.blockDuration)
+	}
+	if locked != nil {
+		log.Warn().
+			Str("login", locked.Login).
+			Str("username", locked.Username).
+			Str("client_ip", locked.ClientIP).
+			Int("failures", locked.Failures).
+			Time("locked_until", locked.Until).
+			Msg("Too many failed logins, username locked")
+		g.auditLog.Record(audit.Entry{
+			Admin:    auditActor,
+			ClientIP: locked.ClientIP,
+			Action:   "bruteforce.lock",
+			Target:   locked.Login + ":" + locked.Username,
+		})
+		g.bus.Publish(EventLocked, *locked)
+	}
+}
+
+// RecordSuccess forgets the failures of a username after a successful login
+func (g *Guard) RecordSuccess(login, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := usernameKey(login, username)
+	if c, ok := g.byUsername[key]; ok && !time.Now().Before(c.lockedUntil) {
+		delete(g.byUsername, key)
+	}
+}
+
+// block adds an IP to the blocklist, unless it is allowlisted
+func (g *Guard) block(clientIP netip.Addr, blocked *Block, duration time.Duration) {
+	if allowed, reason := g.allowlistManager.IsIPAllowed(clientIP); allowed {
+		log.Warn().
+			Str("client_ip", blocked.ClientIP).
+			Int("failures", blocked.Failures).
+			Str("reason", reason).
+			Msg("Too many failed logins from allowlisted IP, not blocking")
+		return
+	}
+
+	block := g.blocklistManager.BlockTemporarily(net.IP(clientIP.AsSlice()), duration, "brute force: "+strconv.Itoa(blocked.Failures)+" failed logins")
+	blocked.Until = block.ExpiresAt
+
+	log.Warn().
+		Str("login", blocked.Login).
+		Str("client_ip", blocked.ClientIP).
+		Int("failures", blocked.Failures).
+		Time("blocked_until", block.ExpiresAt).
+		Msg("Too many failed logins, IP blocked")
+	g.auditLog.Record(audit.Entry{
+		Admin:    auditActor,
+		ClientIP: blocked.ClientIP,
+		Action:   "bruteforce.block",
+		Target:   blocked.ClientIP,
+	})
+	g.bus.Publish(EventBlocked, *blocked)
+}
+
+// add appends a failure and returns the failures within the window; must be called with mu held
+func (g *Guard) add(counters map[string]*counter, key string, now time.Time) int {
+	c, ok := counters[key]
+	if !ok {
+		if len(counters) >= maxTracked {
+			g.prune(now)
+		}
+		if len(counters) >= maxTracked {
+			// Not counting new keys would let junk usernames or addresses switch the guard off
+			g.evictOldest(counters, now)
+		}
+		c = &counter{}
+		counters[key] = c
+	}
+	c.expire(now.Add(-g.policy.window))
+	c.failures = append(c.failures, now)
+	return len(c.failures)
+}
+
+// evictOldest drops the counter whose last failure is the oldest, keeping locked usernames; must be called with mu held
+func (g *Guard) evictOldest(counters map[string]*counter, now time.Time) {
+	oldestKey := ""
+	var oldest time.Time
+	for key, c := range counters {
+		if now.Before(c.lockedUntil) || len(c.failures) == 0 {
+			continue
+		}
+		if last := c.failures[len(c.failures)-1]; oldestKey == "" || last.Before(oldest) {
+			oldestKey, oldest = key, last
+		}
+	}
+	if oldestKey != "" {
+		delete(counters, oldestKey)
+	}
+
+	if now.Sub(g.lastFull) >= pruneInterval {
+		g.lastFull = now
+		log.Warn().
+			Int("tracked", len(counters)).
+			Msg("Failed login table is full, forgetting the least recent failures")
+	}
+}
+
+// prune drops counters without failures in the window or an active lock; must be called with mu held
+func (g *Guard) prune(now time.Time) {
+	g.lastPrune = now
+	oldest := now.Add(-g.policy.window)
+	for _, counters := range []map[string]*counter{g.byIP, g.byUsername} {
+		for key, c := range counters {
+			c.expire(oldest)
+			if len(c.failures) == 0 && !now.Before(c.lockedUntil) {
+				delete(counters, key)
+			}
+		}
+	}
+}
+
+// expire drops failures before oldest
+func (c *counter) expire(oldest time.Time) {
+	kept := 0
+	for kept < len(c.failures) && c.failures[kept].Before(oldest) {
+		kept++
+	}
+	c.failures = c.failures[kept:]
+}
+
+// usernameKey keeps portal and admin accounts with the same name apart
+func usernameKey(login, username string) string {
+	return login + ":" + username
+}
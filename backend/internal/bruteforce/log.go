@@ -0,0 +1,6 @@
+package bruteforce
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["bruteforce"] overrides its level
+var log = logging.Module("bruteforce")
@@ -11,27 +11,165 @@ func GetDefaultConfig() *ApplicationConfig {
 			MaximumSessionDurationSeconds: &defaultMaxDuration,
 			SessionCleanupIntervalSeconds: 60,
 			MaxConcurrentSessions:         10000, // 0 = unlimited, 10000 = reasonable limit
+			IPv4GrantPrefix:               32,
+			IPv6GrantPrefix:               128,
+			MaxManualExtensions:           0, // 0 = unlimited
+			MinExtensionIntervalSeconds:   0,
+			ExtendBeyondMaximumDuration:   false,
 		},
 		NetworkAccessControl: NetworkAccessControlConfig{
 			BlockedIPAddresses:         []string{},
 			AllowedDynamicDNSHostnames: []string{},
 			PermanentlyAllowedIPRanges: []string{},
+			HairpinLANRanges:           []string{},
 			DNSRefreshIntervalSeconds:  300,
+			DNSIPv6GrantPrefixLength:   0, // 0 = grant only the exact resolved address
 		},
 		ProxyServerConfig: ProxyServerConfiguration{
-			ListenAddress:            "0.0.0.0",
-			AdminAPIPort:             8000,
-			ConnectionTimeoutSeconds: 30,
-			MaxConnectionsPerService: 1000,
-			TCPBufferSizeBytes:       32768,
-			UDPBufferSizeBytes:       65507,
-			UDPSessionTimeoutSeconds: 300,
+			ListenAddress:               "0.0.0.0",
+			AdminAPIPort:                8000,
+			ConnectionTimeoutSeconds:    30,
+			MaxConnectionsPerService:    1000,
+			TCPBufferSizeBytes:          32768,
+			UDPBufferSizeBytes:          65507,
+			UDPSessionTimeoutSeconds:    300,
+			BackendDNSRefreshSeconds:    30,
+			BackendProbeIntervalSeconds: 30,
+			UpgradeDrainTimeoutSeconds:  30,
+			AdminTokenLifetimeSeconds:   900,
+			AdminRefreshLifetimeSeconds: 86400,
+			HTTPLimits: HTTPLimitsConfig{
+				HeaderReadTimeoutSeconds:       10,
+				MinTransferRateBytesPerSecond:  240,
+				TransferRateGracePeriodSeconds: 5,
+				MaxInFlightRequestsPerIP:       64,
+			},
+			ConnectionRateLimit: ConnectionRateLimitConfig{
+				MaxNewConnections: 0,
+				WindowSeconds:     10,
+			},
+			LoginRateLimits: LoginRateLimitsConfig{
+				Portal: LoginRateLimitConfig{
+					RequestsPerMinute:    10,
+					Burst:                5,
+					MaxTrackedIPs:        5000,
+					ReducedAfterFailures: 3,
+					SevereAfterFailures:  5,
+					IdleSeconds:          900,
+				},
+				Admin: LoginRateLimitConfig{
+					RequestsPerMinute:    5,
+					Burst:                3,
+					MaxTrackedIPs:        1000,
+					ReducedAfterFailures: 3,
+					SevereAfterFailures:  5,
+					IdleSeconds:          900,
+				},
+			},
+			APIRateLimit: APIRateLimitConfig{
+				AnonymousRequestsPerMinute:     120,
+				AnonymousBurst:                 60,
+				AuthenticatedRequestsPerMinute: 600,
+				AuthenticatedBurst:             200,
+				MaxTrackedIPs:                  10000,
+			},
 		},
 		TrustedProxyConfig: TrustedProxyConfiguration{
 			Enabled:                false,
 			TrustedProxyIPRanges:   []string{},
 			ClientIPHeaderPriority: []string{"CF-Connecting-IP", "X-Real-IP", "X-Forwarded-For"},
 		},
+		PortMappingConfig: PortMappingConfiguration{
+			Enabled:              false,
+			Protocol:             "auto",
+			GatewayAddress:       "",
+			LeaseDurationSeconds: 3600,
+			Description:          "knock-knock-portal",
+		},
+		DynamicDNSConfig: DynamicDNSConfiguration{
+			Enabled:               false,
+			Provider:              "duckdns",
+			UpdateIntervalSeconds: 300,
+			IPv4DetectionURL:      "https://api.ipify.org",
+			IPv6DetectionURL:      "",
+		},
+		KubernetesConfig: KubernetesConfiguration{
+			Enabled:          false,
+			AnnotationPrefix: "knock-knock.io",
+		},
+		ForwardAuthConfig: ForwardAuthConfiguration{
+			ResponseHeaders: map[string]string{
+				"X-Knock-User":            "{user}",
+				"X-Knock-Session-Expires": "{session_expires}",
+				"X-Knock-Services":        "{services}",
+			},
+		},
+		FirewallConfig: FirewallConfiguration{
+			Backend:             "nftables",
+			TableName:           "knock_knock",
+			Masquerade:          true,
+			SyncIntervalSeconds: 5,
+		},
+		TailscaleConfig: TailscaleConfiguration{
+			Enabled:      false,
+			SocketPath:   "/var/run/tailscale/tailscaled.sock",
+			UserMappings: []TailscaleUserMapping{},
+			CacheSeconds: 60,
+		},
+		TransparentProxyConfig: TransparentProxyConfiguration{
+			Enabled:    false,
+			Mode:       "tproxy",
+			ListenPort: 15001,
+		},
+		StatsHistoryConfig: StatsHistoryConfiguration{
+			Enabled:       true,
+			RetentionDays: 7,
+		},
+		ApprovalConfig: ApprovalConfiguration{
+			Enabled:               false,
+			RequestTimeoutSeconds: 600,
+		},
+		CaptchaConfig: CaptchaConfiguration{
+			Enabled:                    false,
+			Provider:                   "turnstile",
+			RequireAfterFailedAttempts: 3,
+		},
+		HoneypotConfig: HoneypotConfiguration{
+			Enabled:              false,
+			TCPPorts:             []int{},
+			UDPPorts:             []int{},
+			BlockDurationSeconds: 3600,
+		},
+		BruteForceConfig: BruteForceConfiguration{
+			Enabled:                false,
+			WindowSeconds:          900,
+			MaxFailuresPerIP:       20,
+			MaxFailuresPerUsername: 10,
+			BlockDurationSeconds:   3600,
+			LockoutSeconds:         900,
+		},
+		AlertsConfig: AlertsConfiguration{
+			Enabled:              false,
+			CheckIntervalSeconds: 30,
+			Telegram:             AlertTelegramConfig{ChatIDs: []int64{}},
+			Email:                AlertEmailConfig{SMTPPort: 587, To: []string{}},
+			Rules:                []AlertRule{},
+		},
+		LoggingConfig: LoggingConfiguration{
+			Output: "stderr",
+			File: LogFileConfiguration{
+				MaxSizeMB:  100,
+				MaxBackups: 5,
+				MaxAgeDays: 30,
+			},
+		},
+		MetricsConfig: MetricsConfiguration{
+			Enabled: false,
+		},
+		DebugConfig: DebugConfiguration{
+			Enabled: false,
+		},
+		AdminAccounts:      []AdminAccount{},
 		PortalUserAccounts: []PortalUserAccount{},
 		ProtectedServices:  []ProtectedServiceConfig{},
 	}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envTemplate is a config value that referenced environment variables when it was loaded
+type envTemplate struct {
+	Template string     // Value as written in the file
+	Value    string     // Value after expansion
+	Style    yaml.Style // Quoting of the value in the file
+}
+
+// walkScalars calls fn for every scalar value of a YAML document with its path (e.g. admin_accounts[0].username)
+// Mapping keys are not visited; walking stops at the first error
+func walkScalars(node *yaml.Node, path string, fn func(node *yaml.Node, path string) error) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return fn(node, path)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childPath := node.Content[i].Value
+			if path != "" {
+				childPath = path + "." + childPath
+			}
+			if err := walkScalars(node.Content[i+1], childPath, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if err := walkScalars(child, fmt.Sprintf("%s[%d]", path, i), fn); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := walkScalars(child, path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnvironment substitutes ${VAR} and ${VAR:-default} in every scalar value of a YAML document
+// Returns the expanded document and the original form of each value that changed, so saving can write
+// the references back; $${ stands for a literal ${
+func expandEnvironment(data []byte) ([]byte, map[string]envTemplate, error) {
+	if !bytes.Contains(data, []byte("${")) {
+		return data, nil, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+
+	templates := make(map[string]envTemplate)
+	err := walkScalars(&doc, "", func(node *yaml.Node, path string) error {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		expanded, err := expandValue(node.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		templates[path] = envTemplate{Template: node.Value, Value: expanded, Style: node.Style}
+
+		node.Value = expanded
+		if node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			// An unquoted reference takes the type of its value, e.g. port: ${ADMIN_PORT}
+			node.Tag = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, templates, nil
+}
+
+// expandValue substitutes the environment variable references of one value
+// A variable that is not set and has no default is an error rather than an empty string
+func expandValue(value string) (string, error) {
+	var sb strings.Builder
+	for {
+		start := strings.Index(value, "${")
+		if start < 0 {
+			sb.WriteString(value)
+			return sb.String(), nil
+		}
+		if start > 0 && value[start-1] == '$' {
+			sb.WriteString(value[:start-1] + "${")
+			value = value[start+2:]
+			continue
+		}
+		end := strings.IndexByte(value[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", value)
+		}
+		sb.WriteString(value[:start])
+
+		reference := value[start+2 : start+end]
+		name, fallback, hasDefault := strings.Cut(reference, ":-")
+		if !isEnvName(name) {
+			return "", fmt.Errorf("invalid environment variable reference ${%s}", reference)
+		}
+		if env, ok := os.LookupEnv(name); ok && (env != "" || !hasDefault) {
+			sb.WriteString(env)
+		} else if hasDefault {
+			sb.WriteString(fallback)
+		} else {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		value = value[start+end+1:]
+	}
+}
+
+// isEnvName reports whether s is a valid environment variable name
+func isEnvName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreTemplates puts environment variable references back in place of values that are still the expanded result
+func restoreTemplates(doc *yaml.Node, templates map[string]envTemplate) {
+	if len(templates) == 0 {
+		return
+	}
+	walkScalars(doc, "", func(node *yaml.Node, path string) error {
+		if template, ok := templates[path]; ok && node.Value == template.Value {
+			node.Value = template.Template
+			node.Tag = "!!str"
+			node.Style = template.Style
+		}
+		return nil
+	})
+}
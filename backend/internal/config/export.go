@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig returns the part of a configuration that is saved to the config file
+// Runtime-provided services are owned by their source and left out
+func FileConfig(cfg *ApplicationConfig) *ApplicationConfig {
+	fileCfg := *cfg
+	fileCfg.ProtectedServices = make([]ProtectedServiceConfig, 0, len(cfg.ProtectedServices))
+	for _, service := range cfg.ProtectedServices {
+		if service.Source == "" {
+			fileCfg.ProtectedServices = append(fileCfg.ProtectedServices, service)
+		}
+	}
+	return &fileCfg
+}
+
+// Standalone returns a configuration as one self-contained file: included files are already
+// merged in, so the include list is dropped
+func Standalone(cfg *ApplicationConfig) *ApplicationConfig {
+	standalone := *cfg
+	standalone.Include = nil
+	return &standalone
+}
+
+// ParseImport parses an uploaded config on top of the defaults; the caller validates it once passwords are hashed
+// Unlike loading the config file, unknown keys are rejected and environment overrides are not applied,
+// so the result can be saved as it is. Encrypted secrets are decrypted with the server's keys
+func ParseImport(data []byte) (*ApplicationConfig, error) {
+	data, _, err := decodeConfigFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := GetDefaultConfig()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("config file is empty")
+		}
+		return nil, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+	return cfg, nil
+}
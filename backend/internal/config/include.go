@@ -0,0 +1,443 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The config file can list other files in include: (single files, directories whose .yml/.yaml files
+// are all read, or glob patterns), e.g. one file per service in conf.d/. Included files use the same
+// layout as config.yml and are merged into it: mappings are combined, lists are concatenated, and a
+// setting or list entry (service_id, user_id, username, name) defined in two files is a conflict.
+// Saving writes every setting and list entry back to the file it came from; new ones go to config.yml.
+
+// identityKeys are the fields that identify list entries across files, in order of preference
+var identityKeys = []string{"service_id", "user_id", "username", "name"}
+
+// configFile is one file of the configuration as read, kept for writing changes back to it
+type configFile struct {
+	path   string
+	format fileFormat
+	root   *yaml.Node // Top-level mapping after decryption and expansion; nil for an empty file
+}
+
+// loadConfigFiles decodes the config file and the files it includes and merges them
+// Returns the merged YAML, the files (config file first) and the paths to watch for changes
+func loadConfigFiles(configPath string, data []byte) ([]byte, []configFile, []string, error) {
+	main, err := readConfigFile(configPath, data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	files := []configFile{main}
+	watch := []string{configPath}
+
+	entries, err := includeEntries(main.root)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(entries) == 0 {
+		plain, err := marshalRoot(main.root)
+		return plain, files, watch, err
+	}
+
+	paths, dirs, err := resolveIncludes(configPath, entries)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	watch = append(watch, dirs...)
+
+	merged := copyNode(main.root)
+	if merged == nil {
+		merged = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	origins := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read included file: %w", err)
+		}
+		included, err := readConfigFile(path, data)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if included.root == nil {
+			continue
+		}
+		if _, nested := mappingValue(included.root, "include"); nested {
+			return nil, nil, nil, fmt.Errorf("%s: included files cannot include other files", path)
+		}
+		if err := mergeNodes(merged, included.root, "", path, configPath, origins); err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, included)
+		if !containsString(dirs, filepath.Dir(path)) {
+			watch = append(watch, path)
+		}
+	}
+
+	plain, err := marshalRoot(merged)
+	return plain, files, watch, err
+}
+
+// readConfigFile decodes one config file into its top-level mapping
+func readConfigFile(path string, data []byte) (configFile, error) {
+	plain, format, err := decodeConfigFile(data)
+	if err != nil {
+		return configFile{}, err
+	}
+	file := configFile{path: path, format: format}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(plain, &doc); err != nil {
+		return configFile{}, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 {
+		switch root := doc.Content[0]; {
+		case root.Kind == yaml.MappingNode:
+			file.root = root
+		case root.Tag != "!!null":
+			return configFile{}, errors.New("config file must be a YAML mapping")
+		}
+	}
+	return file, nil
+}
+
+// includeEntries returns the include list of the config file
+func includeEntries(root *yaml.Node) ([]string, error) {
+	value, ok := mappingValue(root, "include")
+	if !ok || value.Tag == "!!null" {
+		return nil, nil
+	}
+	var entries []string
+	if err := value.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("include must be a list of paths: %w", err)
+	}
+	return entries, nil
+}
+
+// resolveIncludes expands include entries, relative to the config file, into files in a stable order
+// Also returns the directories read or globbed, which are watched for added and removed files
+func resolveIncludes(configPath string, entries []string) (files, dirs []string, err error) {
+	base := filepath.Dir(configPath)
+	self, _ := filepath.Abs(configPath)
+	seen := make(map[string]bool)
+
+	add := func(path string) {
+		abs, _ := filepath.Abs(path)
+		if abs == self || seen[abs] {
+			return
+		}
+		seen[abs] = true
+		files = append(files, path)
+	}
+
+	for _, entry := range entries {
+		path := entry
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(base, path)
+		}
+
+		if strings.ContainsAny(entry, "*?[") {
+			matches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("include %q: %w", entry, err)
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+					add(match)
+				}
+			}
+			dirs = append(dirs, filepath.Dir(path))
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("include %q: %w", entry, err)
+		}
+		if !info.IsDir() {
+			add(path)
+			continue
+		}
+		dirEntries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("include %q: %w", entry, err)
+		}
+		for _, dirEntry := range dirEntries {
+			if dirEntry.Type().IsRegular() && isYAMLFile(dirEntry.Name()) {
+				add(filepath.Join(path, dirEntry.Name()))
+			}
+		}
+		dirs = append(dirs, path)
+	}
+	return files, dirs, nil
+}
+
+// isYAMLFile reports whether a file name has a YAML extension
+func isYAMLFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// mergeNodes merges an included document into dst, failing on settings and list entries defined twice
+// origins records which included file defined each merged path, for naming both files in conflicts
+func mergeNodes(dst, src *yaml.Node, path, file, configPath string, origins map[string]string) error {
+	// The file that defined a path or its closest parent
+	origin := func(key string) string {
+		for {
+			if o, ok := origins[key]; ok {
+				return o
+			}
+			cut := strings.LastIndexAny(key, ".#")
+			if cut < 0 {
+				return configPath
+			}
+			key = key[:cut]
+		}
+	}
+
+	switch {
+	case src.Tag == "!!null":
+		return nil
+	case dst.Kind == yaml.MappingNode && src.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, value := src.Content[i], src.Content[i+1]
+			childPath := key.Value
+			if path != "" {
+				childPath = path + "." + key.Value
+			}
+
+			existing, ok := mappingValue(dst, key.Value)
+			if !ok || existing.Tag == "!!null" {
+				setMappingValue(dst, key, copyNode(value))
+				origins[childPath] = file
+				continue
+			}
+			if err := mergeNodes(existing, value, childPath, file, configPath, origins); err != nil {
+				return err
+			}
+		}
+		return nil
+	case dst.Kind == yaml.SequenceNode && src.Kind == yaml.SequenceNode:
+		for _, item := range src.Content {
+			if field, id, ok := identity(item); ok {
+				key := path + "#" + field + "=" + id
+				for _, other := range dst.Content {
+					if otherField, otherID, ok := identity(other); ok && otherField == field && otherID == id {
+						return fmt.Errorf("%s: %s %q is defined in both %s and %s", path, field, id, origin(key), file)
+					}
+				}
+				origins[key] = file
+			}
+			dst.Content = append(dst.Content, copyNode(item))
+		}
+		return nil
+	case dst.Kind == yaml.ScalarNode && src.Kind == yaml.ScalarNode:
+		if dst.Value != src.Value {
+			return fmt.Errorf("%s is set to different values in %s and %s", path, origin(path), file)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s has a different type in %s than in %s", path, file, origin(path))
+	}
+}
+
+// identity returns the identifying field and value of a list entry
+func identity(item *yaml.Node) (string, string, bool) {
+	if item.Kind != yaml.MappingNode {
+		return "", "", false
+	}
+	for _, field := range identityKeys {
+		if value, ok := mappingValue(item, field); ok && value.Kind == yaml.ScalarNode && value.Value != "" {
+			return field, value.Value, true
+		}
+	}
+	return "", "", false
+}
+
+// splitConfig divides a configuration between the config file and the included files: settings and
+// list entries read from an included file are moved out of root into that file's new content
+// Returns the new content of each included file, in order
+func splitConfig(root *yaml.Node, mainRoot *yaml.Node, includes []configFile) []*yaml.Node {
+	owned := make([]*yaml.Node, len(includes))
+	moved := make(map[*yaml.Node]bool)
+	for i, file := range includes {
+		if file.root == nil {
+			owned[i] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			continue
+		}
+		owned[i] = extractOwned(file.root, root, moved)
+	}
+	pruneEmpty(root, mainRoot, moved)
+	return owned
+}
+
+// extractOwned builds the new content of an included file from its original content, taking the
+// matching settings and list entries out of cur; moved collects the nodes content was taken from
+func extractOwned(orig, cur *yaml.Node, moved map[*yaml.Node]bool) *yaml.Node {
+	owned := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for i := 0; i+1 < len(orig.Content); i += 2 {
+		key, origValue := orig.Content[i], orig.Content[i+1]
+		index := mappingIndex(cur, key.Value)
+		if index < 0 {
+			continue // Removed
+		}
+		curValue := cur.Content[index+1]
+
+		switch {
+		case origValue.Kind == yaml.MappingNode && curValue.Kind == yaml.MappingNode:
+			owned.Content = append(owned.Content, key, extractOwned(origValue, curValue, moved))
+		case origValue.Kind == yaml.SequenceNode && curValue.Kind == yaml.SequenceNode:
+			items := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: origValue.Style}
+			remaining := curValue.Content[:0:0]
+			for _, item := range curValue.Content {
+				if containsItem(origValue, item) {
+					items.Content = append(items.Content, item)
+				} else {
+					remaining = append(remaining, item)
+				}
+			}
+			if len(remaining) < len(curValue.Content) {
+				moved[curValue] = true
+			}
+			curValue.Content = remaining
+			owned.Content = append(owned.Content, key, items)
+		default:
+			owned.Content = append(owned.Content, key, curValue)
+			cur.Content = append(cur.Content[:index], cur.Content[index+2:]...)
+			moved[cur] = true
+		}
+	}
+	return owned
+}
+
+// containsItem reports whether a list entry was read from the list: by its identity, or by value for
+// entries without one
+func containsItem(list, item *yaml.Node) bool {
+	field, id, hasIdentity := identity(item)
+	for _, candidate := range list.Content {
+		if hasIdentity {
+			if candidateField, candidateID, ok := identity(candidate); ok && candidateField == field && candidateID == id {
+				return true
+			}
+			continue
+		}
+		if sameValue(candidate, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneEmpty removes mappings and lists that were left empty by moving their content to included
+// files, unless the config file had them itself
+func pruneEmpty(cur, orig *yaml.Node, moved map[*yaml.Node]bool) {
+	if cur.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(cur.Content); {
+		key, value := cur.Content[i], cur.Content[i+1]
+		origValue, _ := mappingValue(orig, key.Value)
+		pruneEmpty(value, origValue, moved)
+		if moved[value] && len(value.Content) == 0 && origValue == nil {
+			cur.Content = append(cur.Content[:i], cur.Content[i+2:]...)
+			moved[cur] = true
+			continue
+		}
+		i += 2
+	}
+}
+
+// sameValue reports whether two nodes decode to the same value
+func sameValue(a, b *yaml.Node) bool {
+	var va, vb interface{}
+	if a.Decode(&va) != nil || b.Decode(&vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// sameConfig reports whether two documents configure the same, ignoring formatting, comments and
+// fields that are spelled out with their zero value (or as an empty list) in one of them
+func sameConfig(a, b *yaml.Node) bool {
+	var ca, cb ApplicationConfig
+	if a != nil && a.Decode(&ca) != nil {
+		return false
+	}
+	if b != nil && b.Decode(&cb) != nil {
+		return false
+	}
+	// Compare the encoded form, which does not tell nil and empty lists apart
+	da, errA := yaml.Marshal(&ca)
+	db, errB := yaml.Marshal(&cb)
+	return errA == nil && errB == nil && bytes.Equal(da, db)
+}
+
+// mappingIndex returns the index of a key in a mapping node, or -1
+func mappingIndex(node *yaml.Node, key string) int {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of a key in a mapping node
+func mappingValue(node *yaml.Node, key string) (*yaml.Node, bool) {
+	if i := mappingIndex(node, key); i >= 0 {
+		return node.Content[i+1], true
+	}
+	return nil, false
+}
+
+// setMappingValue sets or adds a key of a mapping node
+func setMappingValue(node, key, value *yaml.Node) {
+	if i := mappingIndex(node, key.Value); i >= 0 {
+		node.Content[i+1] = value
+		return
+	}
+	node.Content = append(node.Content, copyNode(key), value)
+}
+
+// copyNode returns a deep copy of a node
+func copyNode(node *yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = copyNode(child)
+	}
+	return &copied
+}
+
+// marshalRoot renders a top-level mapping as YAML
+func marshalRoot(root *yaml.Node) ([]byte, error) {
+	if root == nil {
+		return nil, nil
+	}
+	return yaml.Marshal(root)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
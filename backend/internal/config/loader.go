@@ -1,35 +1,42 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/joho/godotenv"
-	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
 // Loader handles configuration loading and hot-reload
 type Loader struct {
-	configFilePath  string
-	config          *ApplicationConfig
-	configMutex     sync.RWMutex
-	fileWatcher     *fsnotify.Watcher
-	reloadCallbacks []func(*ApplicationConfig)
-	stopChan        chan struct{}
+	configFilePath   string
+	fileConfig       *ApplicationConfig                  // Config as stored on disk
+	config           *ApplicationConfig                  // fileConfig merged with external services
+	externalServices map[string][]ProtectedServiceConfig // source -> services supplied at runtime
+	files            []configFile                        // The config file, then the files it includes, as last read
+	watchPaths       []string                            // Files and include directories the watcher follows
+	watched          map[string]bool                     // Paths added to fileWatcher
+	configMutex      sync.RWMutex
+	fileWatcher      *fsnotify.Watcher
+	reloadCallbacks  []func(*ApplicationConfig)
+	stopChan         chan struct{}
 }
 
 // NewLoader creates a new configuration loader
 func NewLoader(configPath string) (*Loader, error) {
 	loader := &Loader{
-		configFilePath:  configPath,
-		reloadCallbacks: []func(*ApplicationConfig){},
-		stopChan:        make(chan struct{}),
+		configFilePath:   configPath,
+		externalServices: make(map[string][]ProtectedServiceConfig),
+		reloadCallbacks:  []func(*ApplicationConfig){},
+		stopChan:         make(chan struct{}),
 	}
 
 	// Load .env file (optional)
@@ -50,47 +57,129 @@ func NewLoader(configPath string) (*Loader, error) {
 
 // reload loads configuration from file
 func (l *Loader) reload() error {
-	// Start with defaults
-	cfg := GetDefaultConfig()
-
 	// Load from YAML file if it exists
 	data, err := os.ReadFile(l.configFilePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			log.Warn().Str("path", l.configFilePath).Msg("Config file not found, creating with defaults")
-
-			// Create config file with defaults
-			if err := l.createDefaultConfigFile(cfg); err != nil {
-				log.Warn().Err(err).Msg("Failed to create default config file, continuing with in-memory defaults")
-			} else {
-				log.Info().Str("path", l.configFilePath).Msg("Config file created with default values")
-			}
-		} else {
+		if !os.IsNotExist(err) {
 			return fmt.Errorf("failed to read config file: %w", err)
 		}
-	} else {
-		if err := yaml.Unmarshal(data, cfg); err != nil {
-			return fmt.Errorf("failed to parse YAML config: %w", err)
+		log.Warn().Str("path", l.configFilePath).Msg("Config file not found, creating with defaults")
+
+		// Create config file with defaults
+		if err := l.createDefaultConfigFile(GetDefaultConfig()); err != nil {
+			log.Warn().Err(err).Msg("Failed to create default config file, continuing with in-memory defaults")
+		} else {
+			log.Info().Str("path", l.configFilePath).Msg("Config file created with default values")
 		}
 	}
 
-	// Apply environment variable overrides
-	l.applyEnvironmentOverrides(cfg)
-
-	// Validate configuration
-	if err := ValidateConfig(cfg); err != nil {
-		return fmt.Errorf("config validation failed: %w", err)
+	data, files, watchPaths, err := loadConfigFiles(l.configFilePath, data)
+	if err != nil {
+		return err
+	}
+	cfg, err := parseConfig(data)
+	if err != nil {
+		return err
 	}
 
 	// Store config
 	l.configMutex.Lock()
-	l.config = cfg
+	l.files = files
+	l.watchPaths = watchPaths
+	l.fileConfig = cfg
+	l.config = l.mergeExternalServices(cfg)
 	l.configMutex.Unlock()
 
 	log.Info().Msg("Configuration loaded successfully")
 	return nil
 }
 
+// LoadFile loads and validates a config file the same way the server does at startup,
+// without creating a missing file or watching it for changes
+func LoadFile(configPath string) (*ApplicationConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	data, _, _, err = loadConfigFiles(configPath, data)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data)
+}
+
+// fileFormat is what loading a config file strips from it and saving has to add back
+type fileFormat struct {
+	sops      *sopsMetadata          // Set when the file is encrypted with SOPS
+	templates map[string]envTemplate // path -> value that referenced environment variables
+}
+
+// decodeConfigFile decrypts a SOPS-encrypted file and age-encrypted secret values, then expands
+// ${VAR} references; returns what is needed to write the file back in the same form
+func decodeConfigFile(data []byte) ([]byte, fileFormat, error) {
+	var format fileFormat
+	var err error
+
+	format.sops = readSOPSMetadata(data)
+	if format.sops != nil {
+		if data, err = sopsDecrypt(data); err != nil {
+			return nil, fileFormat{}, err
+		}
+	}
+	if data, err = decryptSecrets(data); err != nil {
+		return nil, fileFormat{}, err
+	}
+	if data, format.templates, err = expandEnvironment(data); err != nil {
+		return nil, fileFormat{}, fmt.Errorf("failed to expand environment variables: %w", err)
+	}
+	return data, format, nil
+}
+
+// encodeConfigFile renders the content of one config file the way it was read: unchanged values that
+// referenced environment variables keep the ${VAR} reference, secret fields are encrypted with age when
+// CONFIG_AGE_KEY_FILE or CONFIG_AGE_KEY is set, and the whole file with SOPS if it was encrypted that way
+func encodeConfigFile(root *yaml.Node, format fileFormat) ([]byte, error) {
+	restoreTemplates(root, format.templates)
+
+	keys, err := loadSecretKeys()
+	if err != nil {
+		return nil, err
+	}
+	if keys != nil {
+		if _, err := encryptSecrets(root, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := yaml.Marshal(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+	if format.sops != nil {
+		return sopsEncrypt(data, format.sops)
+	}
+	return data, nil
+}
+
+// parseConfig applies YAML data and environment variable overrides on top of the defaults and validates the result
+func parseConfig(data []byte) (*ApplicationConfig, error) {
+	// Start with defaults
+	cfg := GetDefaultConfig()
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+
+	// Apply environment variable overrides
+	applyEnvironmentOverrides(cfg)
+
+	// Validate configuration
+	if err := ValidateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	return cfg, nil
+}
+
 // createDefaultConfigFile creates a config file with default values
 func (l *Loader) createDefaultConfigFile(cfg *ApplicationConfig) error {
 	// Ensure the directory exists
@@ -116,7 +205,7 @@ func (l *Loader) createDefaultConfigFile(cfg *ApplicationConfig) error {
 }
 
 // applyEnvironmentOverrides applies environment variable overrides
-func (l *Loader) applyEnvironmentOverrides(cfg *ApplicationConfig) {
+func applyEnvironmentOverrides(cfg *ApplicationConfig) {
 	// HTTP_SERVER_PORT override
 	if port := os.Getenv("HTTP_SERVER_PORT"); port != "" {
 		if p, err := strconv.Atoi(port); err == nil {
@@ -133,6 +222,46 @@ func (l *Loader) applyEnvironmentOverrides(cfg *ApplicationConfig) {
 	if ranges := os.Getenv("TRUSTED_PROXY_IP_RANGES"); ranges != "" {
 		cfg.TrustedProxyConfig.TrustedProxyIPRanges = strings.Split(ranges, ",")
 	}
+
+	// LOG_OUTPUT, LOG_LEVELS, LOG_FILE_PATH and SYSLOG_* overrides
+	if output := os.Getenv("LOG_OUTPUT"); output != "" {
+		cfg.LoggingConfig.Output = strings.ToLower(output)
+	}
+	if overrides := os.Getenv("LOG_LEVELS"); overrides != "" {
+		// LOG_LEVELS=proxy.udp=warn,auth=debug
+		levels := make(map[string]string)
+		for _, pair := range strings.Split(overrides, ",") {
+			if module, level, ok := strings.Cut(strings.TrimSpace(pair), "="); ok {
+				levels[strings.TrimSpace(module)] = strings.TrimSpace(level)
+			}
+		}
+		cfg.LoggingConfig.Levels = levels
+	}
+	if path := os.Getenv("LOG_FILE_PATH"); path != "" {
+		cfg.LoggingConfig.File.Path = path
+	}
+	if address := os.Getenv("SYSLOG_ADDRESS"); address != "" {
+		cfg.LoggingConfig.Syslog.Address = address
+	}
+	if facility := os.Getenv("SYSLOG_FACILITY"); facility != "" {
+		cfg.LoggingConfig.Syslog.Facility = strings.ToLower(facility)
+	}
+	if appName := os.Getenv("SYSLOG_APP_NAME"); appName != "" {
+		cfg.LoggingConfig.Syslog.AppName = appName
+	}
+
+	// METRICS_ENABLED and METRICS_BEARER_TOKEN overrides
+	if enabled := os.Getenv("METRICS_ENABLED"); enabled != "" {
+		cfg.MetricsConfig.Enabled = strings.ToLower(enabled) == "true"
+	}
+	if token := os.Getenv("METRICS_BEARER_TOKEN"); token != "" {
+		cfg.MetricsConfig.BearerToken = token
+	}
+
+	// DEBUG_ENDPOINTS_ENABLED override
+	if enabled := os.Getenv("DEBUG_ENDPOINTS_ENABLED"); enabled != "" {
+		cfg.DebugConfig.Enabled = strings.ToLower(enabled) == "true"
+	}
 }
 
 // GetConfig returns the current configuration (thread-safe)
@@ -163,19 +292,16 @@ func (l *Loader) startFileWatcher() error {
 				if !ok {
 					return
 				}
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					log.Info().Str("file", l.configFilePath).Msg("Config file changed, reloading...")
+				if l.isConfigChange(event) {
+					log.Info().Str("file", event.Name).Msg("Config file changed, reloading...")
 
 					if err := l.reload(); err != nil {
 						log.Error().Err(err).Msg("Failed to reload config")
 						continue
 					}
 
-					// Notify all registered callbacks
-					cfg := l.GetConfig()
-					for _, callback := range l.reloadCallbacks {
-						callback(cfg)
-					}
+					l.syncWatches()
+					l.notifyReload()
 
 					log.Info().Msg("Config reloaded successfully")
 				}
@@ -190,36 +316,244 @@ func (l *Loader) startFileWatcher() error {
 		}
 	}()
 
-	return watcher.Add(l.configFilePath)
+	if err := watcher.Add(l.configFilePath); err != nil {
+		return err
+	}
+	l.watched = map[string]bool{l.configFilePath: true}
+	l.syncWatches()
+	return nil
+}
+
+// isConfigChange reports whether a watcher event changes the configuration: a write to the config file,
+// or a YAML file written, added or removed among the included files
+func (l *Loader) isConfigChange(event fsnotify.Event) bool {
+	if filepath.Clean(event.Name) == filepath.Clean(l.configFilePath) {
+		return event.Op&fsnotify.Write == fsnotify.Write
+	}
+	return isYAMLFile(event.Name) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0
+}
+
+// syncWatches follows the included files and directories of the last loaded configuration
+func (l *Loader) syncWatches() {
+	l.configMutex.RLock()
+	paths := l.watchPaths
+	l.configMutex.RUnlock()
+
+	wanted := map[string]bool{l.configFilePath: true}
+	for _, path := range paths {
+		wanted[path] = true
+		if !l.watched[path] {
+			if err := l.fileWatcher.Add(path); err != nil {
+				log.Warn().Err(err).Str("path", path).Msg("Failed to watch included config path")
+				continue
+			}
+			l.watched[path] = true
+		}
+	}
+	for path := range l.watched {
+		if !wanted[path] {
+			l.fileWatcher.Remove(path)
+			delete(l.watched, path)
+		}
+	}
+}
+
+// notifyReload calls all registered callbacks with the current config
+func (l *Loader) notifyReload() {
+	cfg := l.GetConfig()
+	for _, callback := range l.reloadCallbacks {
+		callback(cfg)
+	}
+}
+
+// SetExternalServices replaces the runtime-provided services of a source (e.g. a cluster controller)
+// External services are merged into GetConfig but never written to the config file. Each service is
+// validated together with the config and the services accepted before it; services that fail are
+// skipped and listed in the returned error, the others are applied and returned.
+func (l *Loader) SetExternalServices(source string, services []ProtectedServiceConfig) ([]ProtectedServiceConfig, error) {
+	l.configMutex.Lock()
+	delete(l.externalServices, source)
+
+	accepted := make([]ProtectedServiceConfig, 0, len(services))
+	var rejected []error
+	for i := range services {
+		service := services[i]
+		service.Source = source
+		accepted = append(accepted, service)
+		l.externalServices[source] = accepted
+
+		// Roll back a bad entry so it cannot break the running config or the other services
+		if err := ValidateConfig(l.mergeExternalServices(l.fileConfig)); err != nil {
+			accepted = accepted[:len(accepted)-1]
+			rejected = append(rejected, fmt.Errorf("service %s: %w", service.ServiceID, err))
+		}
+	}
+	if len(accepted) == 0 {
+		delete(l.externalServices, source)
+	} else {
+		l.externalServices[source] = accepted
+	}
+
+	l.config = l.mergeExternalServices(l.fileConfig)
+	l.configMutex.Unlock()
+
+	log.Info().
+		Str("source", source).
+		Int("services", len(accepted)).
+		Int("rejected", len(rejected)).
+		Msg("External services updated")
+
+	l.notifyReload()
+	if len(rejected) > 0 {
+		return accepted, fmt.Errorf("external services from %s rejected: %w", source, errors.Join(rejected...))
+	}
+	return accepted, nil
+}
+
+// mergeExternalServices returns a copy of cfg with all external services appended
+// Must be called with configMutex held
+func (l *Loader) mergeExternalServices(cfg *ApplicationConfig) *ApplicationConfig {
+	if len(l.externalServices) == 0 {
+		return cfg
+	}
+
+	merged := *cfg
+	merged.ProtectedServices = make([]ProtectedServiceConfig, 0, len(cfg.ProtectedServices))
+	merged.ProtectedServices = append(merged.ProtectedServices, cfg.ProtectedServices...)
+
+	sources := make([]string, 0, len(l.externalServices))
+	for source := range l.externalServices {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		merged.ProtectedServices = append(merged.ProtectedServices, l.externalServices[source]...)
+	}
+	return &merged
 }
 
 // SaveConfig saves the configuration to file
 func (l *Loader) SaveConfig(cfg *ApplicationConfig) error {
+	// External services are owned by their source and never persisted
+	cfg = FileConfig(cfg)
+
 	// Validate before saving
 	if err := ValidateConfig(cfg); err != nil {
 		return fmt.Errorf("config validation failed: %w", err)
 	}
 
-	// Marshal to YAML
-	data, err := yaml.Marshal(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal config to YAML: %w", err)
-	}
-
-	// Write to file
-	if err := os.WriteFile(l.configFilePath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
+	if err := l.writeFiles(cfg); err != nil {
+		return err
 	}
 
 	// Update in-memory config
 	l.configMutex.Lock()
-	l.config = cfg
+	l.fileConfig = cfg
+	l.config = l.mergeExternalServices(cfg)
 	l.configMutex.Unlock()
 
 	log.Info().Msg("Configuration saved successfully")
 	return nil
 }
 
+// writeFiles writes a configuration to the config file, except for settings and list entries that were
+// read from included files: those are written back there, and included files without changes are left alone
+func (l *Loader) writeFiles(cfg *ApplicationConfig) error {
+	l.configMutex.RLock()
+	files := l.files
+	l.configMutex.RUnlock()
+
+	main := configFile{path: l.configFilePath}
+	var includes []configFile
+	if len(files) > 0 {
+		main, includes = files[0], files[1:]
+	}
+
+	var root yaml.Node
+	if err := root.Encode(cfg); err != nil {
+		return fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+	owned := splitConfig(&root, main.root, includes)
+
+	for i, file := range includes {
+		if sameConfig(owned[i], file.root) {
+			continue
+		}
+		data, err := encodeConfigFile(owned[i], file.format)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.path, err)
+		}
+		if err := os.WriteFile(file.path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write included config file: %w", err)
+		}
+	}
+
+	data, err := encodeConfigFile(&root, main.format)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.configFilePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// MarshalFile renders a configuration as one self-contained config file the way SaveConfig writes it:
+// included files are merged in, so the include list is left out; references to environment variables,
+// encrypted secrets and SOPS encryption of the config file are kept
+func (l *Loader) MarshalFile(cfg *ApplicationConfig) ([]byte, error) {
+	l.configMutex.RLock()
+	var format fileFormat
+	if len(l.files) > 0 {
+		format = l.files[0].format
+	}
+	l.configMutex.RUnlock()
+
+	var root yaml.Node
+	if err := root.Encode(Standalone(cfg)); err != nil {
+		return nil, fmt.Errorf("failed to marshal config to YAML: %w", err)
+	}
+	return encodeConfigFile(&root, format)
+}
+
+// ErrPortalUserNotFound is returned when no portal user account has the given ID
+var ErrPortalUserNotFound = errors.New("portal user not found")
+
+// UpdatePortalUser applies update to one portal user account and saves the configuration
+func (l *Loader) UpdatePortalUser(userID string, update func(user *PortalUserAccount)) error {
+	cfg := l.GetConfig()
+
+	newCfg := *cfg
+	newCfg.PortalUserAccounts = append([]PortalUserAccount{}, cfg.PortalUserAccounts...)
+	for i := range newCfg.PortalUserAccounts {
+		if newCfg.PortalUserAccounts[i].UserID == userID {
+			update(&newCfg.PortalUserAccounts[i])
+			return l.SaveConfig(&newCfg)
+		}
+	}
+	return ErrPortalUserNotFound
+}
+
+// ErrServiceNotFound is returned when no file-configured service has the given ID
+var ErrServiceNotFound = errors.New("service not found")
+
+// UpdateProtectedService applies update to one service from the config file and saves the configuration
+// Runtime-provided services are never saved, so they are not found here
+func (l *Loader) UpdateProtectedService(serviceID string, update func(service *ProtectedServiceConfig)) error {
+	cfg := l.GetConfig()
+
+	newCfg := *cfg
+	newCfg.ProtectedServices = append([]ProtectedServiceConfig{}, cfg.ProtectedServices...)
+	for i := range newCfg.ProtectedServices {
+		if newCfg.ProtectedServices[i].ServiceID == serviceID && newCfg.ProtectedServices[i].Source == "" {
+			update(&newCfg.ProtectedServices[i])
+			return l.SaveConfig(&newCfg)
+		}
+	}
+	return ErrServiceNotFound
+}
+
 // Close closes the config loader and file watcher
 func (l *Loader) Close() error {
 	close(l.stopChan)
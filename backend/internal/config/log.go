@@ -0,0 +1,6 @@
+package config
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["config"] overrides its level
+var log = logging.Module("config")
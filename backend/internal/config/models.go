@@ -2,12 +2,33 @@ package config
 
 // ApplicationConfig is the root configuration structure
 type ApplicationConfig struct {
-	SessionConfig        SessionConfiguration       `yaml:"session_config" json:"session_config"`
-	NetworkAccessControl NetworkAccessControlConfig `yaml:"network_access_control" json:"network_access_control"`
-	ProxyServerConfig    ProxyServerConfiguration   `yaml:"proxy_server_config" json:"proxy_server_config"`
-	TrustedProxyConfig   TrustedProxyConfiguration  `yaml:"trusted_proxy_config" json:"trusted_proxy_config"`
-	PortalUserAccounts   []PortalUserAccount        `yaml:"portal_user_accounts" json:"portal_user_accounts"`
-	ProtectedServices    []ProtectedServiceConfig   `yaml:"protected_services" json:"protected_services"`
+	Include                []string                      `yaml:"include,omitempty" json:"include"` // Files, directories (all .yml/.yaml) or glob patterns merged into this file, relative to it
+	SessionConfig          SessionConfiguration          `yaml:"session_config" json:"session_config"`
+	NetworkAccessControl   NetworkAccessControlConfig    `yaml:"network_access_control" json:"network_access_control"`
+	ProxyServerConfig      ProxyServerConfiguration      `yaml:"proxy_server_config" json:"proxy_server_config"`
+	TrustedProxyConfig     TrustedProxyConfiguration     `yaml:"trusted_proxy_config" json:"trusted_proxy_config"`
+	PortMappingConfig      PortMappingConfiguration      `yaml:"port_mapping_config" json:"port_mapping_config"`
+	DynamicDNSConfig       DynamicDNSConfiguration       `yaml:"dynamic_dns_config" json:"dynamic_dns_config"`
+	KubernetesConfig       KubernetesConfiguration       `yaml:"kubernetes_config" json:"kubernetes_config"`
+	ForwardAuthConfig      ForwardAuthConfiguration      `yaml:"forward_auth_config" json:"forward_auth_config"`
+	FirewallConfig         FirewallConfiguration         `yaml:"firewall_config" json:"firewall_config"`
+	TailscaleConfig        TailscaleConfiguration        `yaml:"tailscale_config" json:"tailscale_config"`
+	TransparentProxyConfig TransparentProxyConfiguration `yaml:"transparent_proxy_config" json:"transparent_proxy_config"`
+	StatsHistoryConfig     StatsHistoryConfiguration     `yaml:"stats_history_config" json:"stats_history_config"`
+	ApprovalConfig         ApprovalConfiguration         `yaml:"approval_config" json:"approval_config"`
+	HoneypotConfig         HoneypotConfiguration         `yaml:"honeypot_config" json:"honeypot_config"`
+	BruteForceConfig       BruteForceConfiguration       `yaml:"brute_force_config" json:"brute_force_config"`
+	GeoIPConfig            GeoIPConfiguration            `yaml:"geoip_config" json:"geoip_config"`
+	CaptchaConfig          CaptchaConfiguration          `yaml:"captcha_config" json:"captcha_config"`
+	AlertsConfig           AlertsConfiguration           `yaml:"alerts_config" json:"alerts_config"`
+	LoggingConfig          LoggingConfiguration          `yaml:"logging_config" json:"logging_config"`
+	MetricsConfig          MetricsConfiguration          `yaml:"metrics_config" json:"metrics_config"`
+	DebugConfig            DebugConfiguration            `yaml:"debug_config" json:"debug_config"`
+	AdminAccounts          []AdminAccount                `yaml:"admin_accounts" json:"admin_accounts"` // In addition to ADMIN_PASSWORD_BCRYPT_HASH
+	PortalUserAccounts     []PortalUserAccount           `yaml:"portal_user_accounts" json:"portal_user_accounts"`
+	UserGroups             []UserGroupConfig             `yaml:"user_groups" json:"user_groups"`       // Shared settings referenced by portal users' groups
+	ServiceGroups          map[string][]string           `yaml:"service_groups" json:"service_groups"` // Group name -> service IDs; a group name in allowed_service_ids grants all of them
+	ProtectedServices      []ProtectedServiceConfig      `yaml:"protected_services" json:"protected_services"`
 }
 
 // SessionConfiguration defines session behavior
@@ -17,6 +38,28 @@ type SessionConfiguration struct {
 	MaximumSessionDurationSeconds *int `yaml:"maximum_session_duration_seconds" json:"maximum_session_duration_seconds"` // nil = unlimited
 	SessionCleanupIntervalSeconds int  `yaml:"session_cleanup_interval_seconds" json:"session_cleanup_interval_seconds"`
 	MaxConcurrentSessions         int  `yaml:"max_concurrent_sessions" json:"max_concurrent_sessions"` // 0 = unlimited
+	IPv4GrantPrefix               int  `yaml:"ipv4_grant_prefix" json:"ipv4_grant_prefix"`             // 0 or 32 = exact IP
+	IPv6GrantPrefix               int  `yaml:"ipv6_grant_prefix" json:"ipv6_grant_prefix"`             // 0 or 128 = exact IP, e.g. 64 = whole /64
+
+	// Manual extension policy (POST /api/portal/session/extend)
+	MaxManualExtensions         int  `yaml:"max_manual_extensions" json:"max_manual_extensions"`                   // Per session, 0 = unlimited
+	MinExtensionIntervalSeconds int  `yaml:"min_extension_interval_seconds" json:"min_extension_interval_seconds"` // 0 = no minimum
+	ExtendBeyondMaximumDuration bool `yaml:"extend_beyond_maximum_duration" json:"extend_beyond_maximum_duration"` // Manual extensions may push expiry past maximum_session_duration_seconds
+
+	// Soft expiry: warn HTTP clients before their session ends, and let established flows outlive it briefly
+	ExpiryWarningSeconds     int  `yaml:"expiry_warning_seconds" json:"expiry_warning_seconds"`           // HTTP responses carry X-Session-Expires-In this long before expiry (0 = no warning)
+	ExpiryWarningBanner      bool `yaml:"expiry_warning_banner" json:"expiry_warning_banner"`             // Also inject a banner into HTML pages during the warning period
+	ExpiryGracePeriodSeconds *int `yaml:"expiry_grace_period_seconds" json:"expiry_grace_period_seconds"` // TCP connections and UDP sessions are closed this long after access ends; nil = left open until they end
+
+	DualStackCorrelation DualStackCorrelationConfig `yaml:"dual_stack_correlation" json:"dual_stack_correlation"`
+}
+
+// DualStackCorrelationConfig defines how the portal attaches both address families to one session
+// The endpoint URLs must point at this portal through hostnames that only resolve to one family
+type DualStackCorrelationConfig struct {
+	Enabled         bool   `yaml:"enabled" json:"enabled"`
+	IPv4EndpointURL string `yaml:"ipv4_endpoint_url" json:"ipv4_endpoint_url"` // e.g. https://v4.portal.example.com (A record only)
+	IPv6EndpointURL string `yaml:"ipv6_endpoint_url" json:"ipv6_endpoint_url"` // e.g. https://v6.portal.example.com (AAAA record only)
 }
 
 // NetworkAccessControlConfig defines IP allowlist settings
@@ -24,18 +67,76 @@ type NetworkAccessControlConfig struct {
 	BlockedIPAddresses         []string `yaml:"blocked_ip_addresses" json:"blocked_ip_addresses"` // Highest priority - blocks IPs and CIDR ranges
 	AllowedDynamicDNSHostnames []string `yaml:"allowed_dynamic_dns_hostnames" json:"allowed_dynamic_dns_hostnames"`
 	PermanentlyAllowedIPRanges []string `yaml:"permanently_allowed_ip_ranges" json:"permanently_allowed_ip_ranges"`
+	HairpinLANRanges           []string `yaml:"hairpin_lan_ranges" json:"hairpin_lan_ranges"` // LAN clients reaching the public proxy ports through NAT reflection; allowed like permanent ranges (private ranges only)
 	DNSRefreshIntervalSeconds  int      `yaml:"dns_refresh_interval_seconds" json:"dns_refresh_interval_seconds"`
+	DNSIPv6GrantPrefixLength   int      `yaml:"dns_ipv6_grant_prefix_length" json:"dns_ipv6_grant_prefix_length"` // 0 = exact address, e.g. 64 = whole /64
 }
 
 // ProxyServerConfiguration defines proxy server settings
 type ProxyServerConfiguration struct {
-	ListenAddress            string `yaml:"listen_address" json:"listen_address"`
-	AdminAPIPort             int    `yaml:"admin_api_port" json:"admin_api_port"`
-	ConnectionTimeoutSeconds int    `yaml:"connection_timeout_seconds" json:"connection_timeout_seconds"`
-	MaxConnectionsPerService int    `yaml:"max_connections_per_service" json:"max_connections_per_service"`
-	TCPBufferSizeBytes       int    `yaml:"tcp_buffer_size_bytes" json:"tcp_buffer_size_bytes"`
-	UDPBufferSizeBytes       int    `yaml:"udp_buffer_size_bytes" json:"udp_buffer_size_bytes"`
-	UDPSessionTimeoutSeconds int    `yaml:"udp_session_timeout_seconds" json:"udp_session_timeout_seconds"`
+	ListenAddress               string                    `yaml:"listen_address" json:"listen_address"`
+	AdminAPIPort                int                       `yaml:"admin_api_port" json:"admin_api_port"`
+	ConnectionTimeoutSeconds    int                       `yaml:"connection_timeout_seconds" json:"connection_timeout_seconds"`
+	MaxConnectionsPerService    int                       `yaml:"max_connections_per_service" json:"max_connections_per_service"`
+	MaxConnectionsPerUser       int                       `yaml:"max_connections_per_user" json:"max_connections_per_user"` // Per service, counted across the user's sessions (0 = unlimited)
+	TCPBufferSizeBytes          int                       `yaml:"tcp_buffer_size_bytes" json:"tcp_buffer_size_bytes"`       // Copy buffer per direction and TCP connection (0 = 32768)
+	UDPBufferSizeBytes          int                       `yaml:"udp_buffer_size_bytes" json:"udp_buffer_size_bytes"`       // Largest UDP datagram forwarded in full (0 = 65507)
+	UDPSessionTimeoutSeconds    int                       `yaml:"udp_session_timeout_seconds" json:"udp_session_timeout_seconds"`
+	BackendDNSRefreshSeconds    int                       `yaml:"backend_dns_refresh_seconds" json:"backend_dns_refresh_seconds"`       // How often backend hostnames are resolved again (0 = 30)
+	BackendProbeIntervalSeconds int                       `yaml:"backend_probe_interval_seconds" json:"backend_probe_interval_seconds"` // How often TCP and HTTP backends are checked for /api/health (0 = 30)
+	UpgradeDrainTimeoutSeconds  int                       `yaml:"upgrade_drain_timeout_seconds" json:"upgrade_drain_timeout_seconds"`   // How long the old process keeps serving connections after a binary upgrade; it no longer sees session or blocklist changes, so keep it short (0 = until all close)
+	AdminTokenLifetimeSeconds   int                       `yaml:"admin_token_lifetime_seconds" json:"admin_token_lifetime_seconds"`     // Admin access tokens (0 = 900); the admin UI refreshes them silently
+	AdminRefreshLifetimeSeconds int                       `yaml:"admin_refresh_lifetime_seconds" json:"admin_refresh_lifetime_seconds"` // Admins inactive this long must sign in again (0 = 86400)
+	HTTPLimits                  HTTPLimitsConfig          `yaml:"http_limits" json:"http_limits"`
+	ConnectionRateLimit         ConnectionRateLimitConfig `yaml:"connection_rate_limit" json:"connection_rate_limit"`
+	LoginRateLimits             LoginRateLimitsConfig     `yaml:"login_rate_limits" json:"login_rate_limits"`
+	APIRateLimit                APIRateLimitConfig        `yaml:"api_rate_limit" json:"api_rate_limit"`
+}
+
+// APIRateLimitConfig limits the requests of each client IP to the /api endpoints
+// Callers with a valid portal or admin token draw from a separate, usually larger, budget than anonymous ones
+// Login endpoints are additionally limited by login_rate_limits; /api/health and /api/auth/verify are exempt
+type APIRateLimitConfig struct {
+	AnonymousRequestsPerMinute     int `yaml:"anonymous_requests_per_minute" json:"anonymous_requests_per_minute"`         // 0 = unlimited
+	AnonymousBurst                 int `yaml:"anonymous_burst" json:"anonymous_burst"`                                     // 0 = anonymous_requests_per_minute
+	AuthenticatedRequestsPerMinute int `yaml:"authenticated_requests_per_minute" json:"authenticated_requests_per_minute"` // 0 = unlimited
+	AuthenticatedBurst             int `yaml:"authenticated_burst" json:"authenticated_burst"`                             // 0 = authenticated_requests_per_minute
+	MaxTrackedIPs                  int `yaml:"max_tracked_ips" json:"max_tracked_ips"`                                     // Per budget; least recently seen IPs are forgotten beyond this (0 = 10000)
+}
+
+// LoginRateLimitsConfig limits the attempts of each client IP on the login endpoints
+// Each endpoint has its own buckets; brute_force_config counts failures across them
+type LoginRateLimitsConfig struct {
+	Portal LoginRateLimitConfig `yaml:"portal" json:"portal"` // Portal login and password change, and separately invite codes (defaults 10/min, burst 5, 5000 IPs)
+	Admin  LoginRateLimitConfig `yaml:"admin" json:"admin"`   // Admin login and token refresh (defaults 5/min, burst 3, 1000 IPs)
+}
+
+// LoginRateLimitConfig is a token bucket per client IP that slows down further after consecutive failed logins
+// Zero values use the endpoint's defaults
+type LoginRateLimitConfig struct {
+	RequestsPerMinute    int `yaml:"requests_per_minute" json:"requests_per_minute"`
+	Burst                int `yaml:"burst" json:"burst"`
+	MaxTrackedIPs        int `yaml:"max_tracked_ips" json:"max_tracked_ips"`               // Least recently seen IPs are forgotten beyond this
+	ReducedAfterFailures int `yaml:"reduced_after_failures" json:"reduced_after_failures"` // Then 1 attempt per 10 seconds (0 = 3)
+	SevereAfterFailures  int `yaml:"severe_after_failures" json:"severe_after_failures"`   // Then 1 attempt per 100 seconds (0 = 5)
+	IdleSeconds          int `yaml:"idle_seconds" json:"idle_seconds"`                     // IPs idle this long are forgotten with their failures (0 = 900)
+}
+
+// ConnectionRateLimitConfig limits how fast a single client IP may open new connections, counted across all proxies
+// Works as a token bucket: max_new_connections may be opened at once, refilling evenly over window_seconds
+// TCP and HTTP connections and new UDP sessions all count
+type ConnectionRateLimitConfig struct {
+	MaxNewConnections int `yaml:"max_new_connections" json:"max_new_connections"` // e.g. 20; 0 = unlimited
+	WindowSeconds     int `yaml:"window_seconds" json:"window_seconds"`           // e.g. 10; 0 = 10
+}
+
+// HTTPLimitsConfig protects the admin/portal API and HTTP proxies from slow (Slowloris, slow-read) and flooding clients
+// Each HTTP listener counts in-flight requests separately
+type HTTPLimitsConfig struct {
+	HeaderReadTimeoutSeconds       int `yaml:"header_read_timeout_seconds" json:"header_read_timeout_seconds"`               // Request line and headers must arrive within this (0 = no limit); the admin API applies changes after a restart
+	MinTransferRateBytesPerSecond  int `yaml:"min_transfer_rate_bytes_per_second" json:"min_transfer_rate_bytes_per_second"` // Request bodies and responses slower than this are cut off (0 = off)
+	TransferRateGracePeriodSeconds int `yaml:"transfer_rate_grace_period_seconds" json:"transfer_rate_grace_period_seconds"` // Slack before the minimum rate applies (0 = 5)
+	MaxInFlightRequestsPerIP       int `yaml:"max_in_flight_requests_per_ip" json:"max_in_flight_requests_per_ip"`           // Concurrent requests per client IP (0 = unlimited)
 }
 
 // TrustedProxyConfiguration defines trusted proxy settings for real IP extraction
@@ -45,14 +146,280 @@ type TrustedProxyConfiguration struct {
 	ClientIPHeaderPriority []string `yaml:"client_ip_header_priority" json:"client_ip_header_priority"`
 }
 
+// PortMappingConfiguration defines automatic router port forwarding (UPnP IGD / NAT-PMP)
+type PortMappingConfiguration struct {
+	Enabled              bool   `yaml:"enabled" json:"enabled"`
+	Protocol             string `yaml:"protocol" json:"protocol"`                             // auto | natpmp | upnp
+	GatewayAddress       string `yaml:"gateway_address" json:"gateway_address"`               // Empty = autodetect (NAT-PMP only)
+	LeaseDurationSeconds int    `yaml:"lease_duration_seconds" json:"lease_duration_seconds"` // Mappings are renewed at half the lease
+	Description          string `yaml:"description" json:"description"`                       // Shown in the router's mapping table
+}
+
+// DynamicDNSConfiguration defines the built-in updater that keeps the portal's own hostname pointed at its public IP
+type DynamicDNSConfiguration struct {
+	Enabled               bool   `yaml:"enabled" json:"enabled"`
+	Provider              string `yaml:"provider" json:"provider"` // cloudflare | duckdns | http
+	Hostname              string `yaml:"hostname" json:"hostname"` // Fully qualified record name (DuckDNS: subdomain or full name)
+	UpdateIntervalSeconds int    `yaml:"update_interval_seconds" json:"update_interval_seconds"`
+	IPv4DetectionURL      string `yaml:"ipv4_detection_url" json:"ipv4_detection_url"` // Must return the public IPv4 as plain text; empty = skip IPv4
+	IPv6DetectionURL      string `yaml:"ipv6_detection_url" json:"ipv6_detection_url"` // Must return the public IPv6 as plain text; empty = skip IPv6
+	APIToken              string `yaml:"api_token" json:"api_token"`                   // Cloudflare API token or DuckDNS token
+	CloudflareZoneID      string `yaml:"cloudflare_zone_id" json:"cloudflare_zone_id"`
+	CloudflareProxied     bool   `yaml:"cloudflare_proxied" json:"cloudflare_proxied"`
+	UpdateURL             string `yaml:"update_url" json:"update_url"` // Generic HTTP: {hostname}, {ipv4}, {ipv6} placeholders
+	Username              string `yaml:"username" json:"username"`     // Generic HTTP: optional basic auth
+	Password              string `yaml:"password" json:"password"`
+}
+
+// KubernetesConfiguration defines the controller mode that derives protected services from annotated cluster Services
+type KubernetesConfiguration struct {
+	Enabled          bool   `yaml:"enabled" json:"enabled"`
+	APIServerURL     string `yaml:"api_server_url" json:"api_server_url"`       // Empty = in-cluster (KUBERNETES_SERVICE_HOST)
+	BearerTokenFile  string `yaml:"bearer_token_file" json:"bearer_token_file"` // Empty = service account token
+	CAFile           string `yaml:"ca_file" json:"ca_file"`                     // Empty = service account CA
+	Namespace        string `yaml:"namespace" json:"namespace"`                 // Empty = all namespaces
+	AnnotationPrefix string `yaml:"annotation_prefix" json:"annotation_prefix"`
+	NodeAddress      string `yaml:"node_address" json:"node_address"` // Backend host for services annotated with target "nodeport"
+}
+
+// ForwardAuthConfiguration defines identity headers returned by /api/auth/verify for downstream proxies
+// Values support the placeholders {user}, {user_id}, {session_expires}, {services},
+// {service_names}, {client_ip}, {access_method}, {service_id} and {service_name}
+// Headers whose value renders empty are omitted; the session ID is never exposed to downstream services
+type ForwardAuthConfiguration struct {
+	ResponseHeaders map[string]string `yaml:"response_headers" json:"response_headers"` // header name -> value template
+}
+
+// FirewallConfiguration defines the kernel enforcement backend used by services in "firewall" enforcement mode
+// Instead of proxying, allowed client IPs are programmed into firewall rules that DNAT to the backend
+type FirewallConfiguration struct {
+	Backend             string `yaml:"backend" json:"backend"`                             // nftables | iptables | windows
+	TableName           string `yaml:"table_name" json:"table_name"`                       // nftables table / iptables chain prefix / Windows rule group owned by the portal
+	Masquerade          bool   `yaml:"masquerade" json:"masquerade"`                       // SNAT forwarded traffic so backend replies return through the portal
+	SyncIntervalSeconds int    `yaml:"sync_interval_seconds" json:"sync_interval_seconds"` // Periodic resync to remove expired grants
+}
+
+// TailscaleConfiguration defines identity-based access for clients connecting over a tailnet
+// Tailnet addresses are looked up via the local tailscaled (LocalAPI whois) and mapped to a portal user,
+// whose session is created automatically instead of requiring a password knock
+type TailscaleConfiguration struct {
+	Enabled       bool                   `yaml:"enabled" json:"enabled"`
+	SocketPath    string                 `yaml:"socket_path" json:"socket_path"` // tailscaled LocalAPI unix socket
+	UserMappings  []TailscaleUserMapping `yaml:"user_mappings" json:"user_mappings"`
+	DefaultUserID string                 `yaml:"default_user_id" json:"default_user_id"` // Portal user for unmapped tailnet members; empty = mapping required
+	CacheSeconds  int                    `yaml:"cache_seconds" json:"cache_seconds"`     // How long denied lookups are remembered (at least 5 seconds)
+	// Node tags or logins mapped to user_groups, so tailnet ACL membership decides which services a session unlocks
+	// When any mapping matches, the matched groups replace the portal user's groups and allowed_service_ids
+	GroupMappings []TailscaleGroupMapping `yaml:"group_mappings" json:"group_mappings"`
+}
+
+// TransparentProxyConfiguration defines an inline listener for TCP traffic redirected by the kernel
+// (iptables/nftables TPROXY or REDIRECT). The original destination port selects the protected
+// service, so clients keep connecting to the real service port. UDP is not intercepted
+type TransparentProxyConfiguration struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	Mode          string `yaml:"mode" json:"mode"`                     // tproxy | redirect
+	ListenAddress string `yaml:"listen_address" json:"listen_address"` // Empty = all interfaces
+	ListenPort    int    `yaml:"listen_port" json:"listen_port"`       // Port the redirect rules send traffic to
+}
+
+// StatsHistoryConfiguration defines retention of per-service and per-IP traffic history
+type StatsHistoryConfiguration struct {
+	Enabled       bool `yaml:"enabled" json:"enabled"`
+	RetentionDays int  `yaml:"retention_days" json:"retention_days"` // One-minute buckets are kept this long
+	// Hourly backend health check results and incidents are kept this long (0 = 30)
+	UptimeRetentionDays int `yaml:"uptime_retention_days" json:"uptime_retention_days"`
+}
+
+// ApprovalConfiguration defines admin approval of portal logins
+// A login needing approval creates a pending request; the session starts once an admin approves it
+type ApprovalConfiguration struct {
+	Enabled               bool                   `yaml:"enabled" json:"enabled"`                                 // Every login needs approval (users can also opt in with require_approval)
+	RequestTimeoutSeconds int                    `yaml:"request_timeout_seconds" json:"request_timeout_seconds"` // Pending requests expire after this long (0 = 600)
+	WebhookURL            string                 `yaml:"webhook_url" json:"webhook_url"`                         // Receives each new request as a JSON event; empty = none
+	Telegram              TelegramApprovalConfig `yaml:"telegram" json:"telegram"`
+}
+
+// TelegramApprovalConfig defines a Telegram bot that posts pending login requests with Approve/Deny buttons
+// The bot long-polls the Bot API, so the portal does not need to be reachable from Telegram
+type TelegramApprovalConfig struct {
+	Enabled  bool    `yaml:"enabled" json:"enabled"`
+	BotToken string  `yaml:"bot_token" json:"bot_token"` // Token from @BotFather
+	ChatIDs  []int64 `yaml:"chat_ids" json:"chat_ids"`   // Chats notified of requests; anyone in these chats can decide
+}
+
+// CaptchaConfiguration defines a CAPTCHA challenge on portal login, verified server-side
+type CaptchaConfiguration struct {
+	Enabled                    bool   `yaml:"enabled" json:"enabled"`
+	Provider                   string `yaml:"provider" json:"provider"`                                           // turnstile | hcaptcha
+	SiteKey                    string `yaml:"site_key" json:"site_key"`                                           // Public key rendered into the login page
+	SecretKey                  string `yaml:"secret_key" json:"secret_key"`                                       // Used to verify tokens with the provider
+	RequireAfterFailedAttempts int    `yaml:"require_after_failed_attempts" json:"require_after_failed_attempts"` // Failed logins from an IP before the CAPTCHA is required (0 = always)
+}
+
+// LoggingConfiguration defines where logs are written
+// Applied at startup; LOG_OUTPUT and the SYSLOG_* environment variables override these values
+type LoggingConfiguration struct {
+	Output string               `yaml:"output" json:"output"` // stderr | syslog | file
+	Syslog SyslogConfiguration  `yaml:"syslog" json:"syslog"`
+	File   LogFileConfiguration `yaml:"file" json:"file"`
+	// Level overrides by module (api, auth, proxy, proxy.udp, ipallowlist, ...) or by service ("service.<service_id>")
+	// on top of LOG_LEVEL, e.g. {proxy.udp: warn, auth: debug}; dotted modules inherit from their parent
+	Levels map[string]string `yaml:"levels" json:"levels"`
+}
+
+// MetricsConfiguration defines the Prometheus endpoint at /metrics on the admin API port
+type MetricsConfiguration struct {
+	Enabled     bool   `yaml:"enabled" json:"enabled"`
+	BearerToken string `yaml:"bearer_token" json:"bearer_token"` // Required as "Authorization: Bearer <token>"; empty = no authentication
+}
+
+// DebugConfiguration defines the pprof and runtime statistics endpoints under /api/admin/debug/
+// Profiles reveal internals and a CPU profile costs noticeable CPU, so they are off unless needed
+type DebugConfiguration struct {
+	Enabled bool `yaml:"enabled" json:"enabled"` // Admin JWT is still required
+}
+
+// LogFileConfiguration defines the log file target and its rotation
+type LogFileConfiguration struct {
+	Path       string `yaml:"path" json:"path"`                 // e.g. /var/log/knock-knock/portal.log
+	MaxSizeMB  int    `yaml:"max_size_mb" json:"max_size_mb"`   // Rotate once the file reaches this size (0 = 100)
+	MaxBackups int    `yaml:"max_backups" json:"max_backups"`   // Rotated files to keep (0 = all)
+	MaxAgeDays int    `yaml:"max_age_days" json:"max_age_days"` // Delete rotated files older than this (0 = never)
+}
+
+// SyslogConfiguration defines the RFC 5424 syslog target
+type SyslogConfiguration struct {
+	Address   string `yaml:"address" json:"address"`         // udp://host:514, tcp://host:601 or tls://host:6514; empty = local syslog socket
+	Facility  string `yaml:"facility" json:"facility"`       // kern, user, daemon, auth, local0-local7, ... (empty = daemon)
+	AppName   string `yaml:"app_name" json:"app_name"`       // APP-NAME field (empty = knock-knock)
+	TLSCAFile string `yaml:"tls_ca_file" json:"tls_ca_file"` // CA bundle for tls:// targets; empty = system roots
+}
+
+// HoneypotConfiguration defines decoy ports that temporarily block any IP touching them
+// Allowlisted IPs (active sessions, always-allowed ranges) are never blocked
+type HoneypotConfiguration struct {
+	Enabled              bool   `yaml:"enabled" json:"enabled"`
+	ListenAddress        string `yaml:"listen_address" json:"listen_address"`                 // Empty = proxy_server_config.listen_address
+	TCPPorts             []int  `yaml:"tcp_ports" json:"tcp_ports"`                           // Decoy TCP ports, e.g. 23, 3389
+	UDPPorts             []int  `yaml:"udp_ports" json:"udp_ports"`                           // Decoy UDP ports, e.g. 161, 1900
+	BlockOnUDP           bool   `yaml:"block_on_udp" json:"block_on_udp"`                     // Also block senders of UDP datagrams; their source address can be spoofed, so off = log only
+	BlockDurationSeconds int    `yaml:"block_duration_seconds" json:"block_duration_seconds"` // How long a triggering IP stays blocked (0 = 3600)
+}
+
+// BruteForceConfiguration defines the failed login limits shared by the portal and admin logins
+// Failures are counted per client IP and per attempted username over a sliding window
+type BruteForceConfiguration struct {
+	Enabled                bool `yaml:"enabled" json:"enabled"`
+	WindowSeconds          int  `yaml:"window_seconds" json:"window_seconds"`                       // Failures older than this are forgotten (0 = 900)
+	MaxFailuresPerIP       int  `yaml:"max_failures_per_ip" json:"max_failures_per_ip"`             // Then the IP is temporarily blocked (0 = 20)
+	MaxFailuresPerUsername int  `yaml:"max_failures_per_username" json:"max_failures_per_username"` // Then logins as this username are refused from any IP (0 = 10)
+	BlockDurationSeconds   int  `yaml:"block_duration_seconds" json:"block_duration_seconds"`       // How long a blocked IP stays blocked (0 = 3600)
+	LockoutSeconds         int  `yaml:"lockout_seconds" json:"lockout_seconds"`                     // How long a username stays locked (0 = 900)
+}
+
+// GeoIPConfiguration defines the MaxMind DB (.mmdb) files used to show where clients connect from
+// Works with GeoLite2/GeoIP2 and compatible databases (e.g. DB-IP, IPinfo); empty paths disable the lookup
+type GeoIPConfiguration struct {
+	CityDatabase string `yaml:"city_database" json:"city_database"` // Country or city database, e.g. /app/data/GeoLite2-City.mmdb
+	ASNDatabase  string `yaml:"asn_database" json:"asn_database"`   // e.g. /app/data/GeoLite2-ASN.mmdb
+}
+
+// AlertsConfiguration defines threshold rules that notify admins through webhook, Telegram and email
+type AlertsConfiguration struct {
+	Enabled              bool                `yaml:"enabled" json:"enabled"`
+	CheckIntervalSeconds int                 `yaml:"check_interval_seconds" json:"check_interval_seconds"` // How often rules are evaluated (0 = 30)
+	WebhookURL           string              `yaml:"webhook_url" json:"webhook_url"`                       // Receives every alert as a JSON event
+	Telegram             AlertTelegramConfig `yaml:"telegram" json:"telegram"`
+	Email                AlertEmailConfig    `yaml:"email" json:"email"`
+	Rules                []AlertRule         `yaml:"rules" json:"rules"`
+}
+
+// AlertTelegramConfig posts alerts to Telegram chats
+type AlertTelegramConfig struct {
+	BotToken string  `yaml:"bot_token" json:"bot_token"` // Empty = approval_config.telegram.bot_token
+	ChatIDs  []int64 `yaml:"chat_ids" json:"chat_ids"`   // Empty = no Telegram alerts
+}
+
+// AlertEmailConfig mails alerts through an SMTP server; STARTTLS is used when the server offers it
+type AlertEmailConfig struct {
+	SMTPHost string   `yaml:"smtp_host" json:"smtp_host"` // Empty = no email alerts
+	SMTPPort int      `yaml:"smtp_port" json:"smtp_port"` // 0 = 587
+	Username string   `yaml:"username" json:"username"`   // Empty = no authentication
+	Password string   `yaml:"password" json:"password"`
+	From     string   `yaml:"from" json:"from"`
+	To       []string `yaml:"to" json:"to"`
+}
+
+// AlertRule fires when a metric is above its threshold
+type AlertRule struct {
+	Name            string `yaml:"name" json:"name"`
+	Metric          string `yaml:"metric" json:"metric"`                     // active_connections | bytes_per_minute | failed_logins | circuit_open_seconds
+	ServiceID       string `yaml:"service_id" json:"service_id"`             // Empty = every service; not used by failed_logins
+	Threshold       int64  `yaml:"threshold" json:"threshold"`               // Fires when the value is greater
+	WindowMinutes   int    `yaml:"window_minutes" json:"window_minutes"`     // failed_logins: failures are counted over this window (0 = 5)
+	CooldownMinutes int    `yaml:"cooldown_minutes" json:"cooldown_minutes"` // Quiet time per rule and service after a notification (0 = 15)
+}
+
+// TailscaleUserMapping maps a Tailscale identity to a portal user
+type TailscaleUserMapping struct {
+	LoginName string `yaml:"login_name" json:"login_name"` // Tailscale login (user@example.com) or node tag (tag:server)
+	UserID    string `yaml:"user_id" json:"user_id"`       // Portal user whose allowed services apply
+}
+
+// TailscaleGroupMapping grants the services and session settings of user_groups to matching tailnet members
+type TailscaleGroupMapping struct {
+	LoginName string   `yaml:"login_name" json:"login_name"` // Tailscale login (user@example.com) or node tag (tag:server)
+	Groups    []string `yaml:"groups" json:"groups"`         // user_groups names, merged in order
+}
+
+// AdminAccount is an admin identity kept in the config file so it can be rotated from the admin UI
+type AdminAccount struct {
+	Username             string `yaml:"username" json:"username"`
+	BcryptHashedPassword string `yaml:"bcrypt_hashed_password" json:"bcrypt_hashed_password"` // Plain values saved through the admin API are hashed
+	Notes                string `yaml:"notes" json:"notes"`
+}
+
 // PortalUserAccount defines a user who can login to the portal
 type PortalUserAccount struct {
 	UserID                             string   `yaml:"user_id" json:"user_id"`
 	Username                           string   `yaml:"username" json:"username"`
 	DisplayUsernameInPublicSuggestions bool     `yaml:"display_username_in_public_login_suggestions" json:"display_username_in_public_login_suggestions"`
 	BcryptHashedPassword               string   `yaml:"bcrypt_hashed_password" json:"bcrypt_hashed_password"`
-	AllowedServiceIDs                  []string `yaml:"allowed_service_ids" json:"allowed_service_ids"` // Empty = all
+	AllowedServiceIDs                  []string `yaml:"allowed_service_ids" json:"allowed_service_ids"` // Service IDs, service_groups names or tag:<name> selectors; empty = all
 	Notes                              string   `yaml:"notes" json:"notes"`
+	RequireApproval                    bool     `yaml:"require_approval,omitempty" json:"require_approval,omitempty"`         // Logins wait for admin approval even if approval_config is disabled
+	MustChangePassword                 bool     `yaml:"must_change_password,omitempty" json:"must_change_password,omitempty"` // Set by an admin; login is refused until the user picks a new password
+	// Outside the schedule logins are rejected and existing sessions lose access until the next window
+	Schedule *AccessScheduleConfig `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+	// user_groups names; the user inherits their settings (see EffectiveUser for how they are merged)
+	Groups []string `yaml:"groups,omitempty" json:"groups,omitempty"`
+	// Override the session length and the concurrent session quota of the user's groups (0 = inherit)
+	SessionDurationSeconds int `yaml:"session_duration_seconds,omitempty" json:"session_duration_seconds,omitempty"`
+	MaxSessions            int `yaml:"max_sessions,omitempty" json:"max_sessions,omitempty"`
+}
+
+// UserGroupConfig holds settings shared by the portal users that list the group
+type UserGroupConfig struct {
+	Name                   string                `yaml:"name" json:"name"`
+	AllowedServiceIDs      []string              `yaml:"allowed_service_ids" json:"allowed_service_ids"`               // Same entries as a user's; empty = all
+	SessionDurationSeconds int                   `yaml:"session_duration_seconds" json:"session_duration_seconds"`     // Session length and auto-extension step (0 = session_config default)
+	MaxSessions            int                   `yaml:"max_sessions" json:"max_sessions"`                             // Concurrent portal sessions per user (0 = unlimited)
+	RequireApproval        bool                  `yaml:"require_approval,omitempty" json:"require_approval,omitempty"` // Members' logins wait for admin approval
+	Schedule               *AccessScheduleConfig `yaml:"schedule,omitempty" json:"schedule,omitempty"`                 // Used for members without their own schedule
+}
+
+// AccessScheduleConfig limits access to recurring weekly time windows
+type AccessScheduleConfig struct {
+	Timezone string           `yaml:"timezone" json:"timezone"` // IANA name, e.g. Europe/Vienna; empty = server local time
+	Windows  []ScheduleWindow `yaml:"windows" json:"windows"`   // Access is open while any window matches
+}
+
+// ScheduleWindow is a daily time range on selected days of the week
+type ScheduleWindow struct {
+	Days      []string `yaml:"days" json:"days"`             // mon, tue, wed, thu, fri, sat, sun; empty = every day
+	StartTime string   `yaml:"start_time" json:"start_time"` // HH:MM
+	EndTime   string   `yaml:"end_time" json:"end_time"`     // HH:MM, exclusive; earlier than start_time = ends the next day
 }
 
 // ProtectedServiceConfig defines a service that requires authentication
@@ -61,13 +428,163 @@ type ProtectedServiceConfig struct {
 	ServiceName          string              `yaml:"service_name" json:"service_name"`
 	ProxyListenPortStart int                 `yaml:"proxy_listen_port_start" json:"proxy_listen_port_start"`
 	ProxyListenPortEnd   int                 `yaml:"proxy_listen_port_end" json:"proxy_listen_port_end"`
+	ProxyListenAddress   string              `yaml:"proxy_listen_address,omitempty" json:"proxy_listen_address,omitempty"` // IP the primary port binds to; empty = all addresses (IPv4 and IPv6)
 	BackendTargetHost    string              `yaml:"backend_target_host" json:"backend_target_host"`
 	BackendTargetPort    int                 `yaml:"backend_target_port" json:"backend_target_port"`
-	TransportProtocol    string              `yaml:"transport_protocol" json:"transport_protocol"` // tcp | udp | both
+	TransportProtocol    string              `yaml:"transport_protocol" json:"transport_protocol"` // tcp | udp | both | socks5
 	IsHTTPProtocol       bool                `yaml:"is_http_protocol" json:"is_http_protocol"`
 	Enabled              bool                `yaml:"enabled" json:"enabled"`
 	Description          string              `yaml:"description" json:"description"`
+	Tags                 []string            `yaml:"tags,omitempty" json:"tags,omitempty"`                         // Granted to sessions by "tag:<name>" entries in allowed_service_ids
+	EnforcementMode      string              `yaml:"enforcement_mode,omitempty" json:"enforcement_mode,omitempty"` // proxy (default) | firewall
 	HTTPConfig           *HTTPProtocolConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+	// SOCKS5 CONNECT targets as host:port; host may be a name, *.domain, IP or [CIDR], port may be a range or *
+	// Empty = only backend_target_host:backend_target_port
+	SOCKS5AllowedDestinations []string `yaml:"socks5_allowed_destinations,omitempty" json:"socks5_allowed_destinations,omitempty"`
+	// Overrides proxy_server_config.max_connections_per_user for this service (0 = use global)
+	MaxConnectionsPerUser int `yaml:"max_connections_per_user,omitempty" json:"max_connections_per_user,omitempty"`
+	// Override proxy_server_config.tcp_buffer_size_bytes and udp_buffer_size_bytes, e.g. larger for bulk transfers (0 = use global)
+	TCPBufferSizeBytes int `yaml:"tcp_buffer_size_bytes,omitempty" json:"tcp_buffer_size_bytes,omitempty"`
+	UDPBufferSizeBytes int `yaml:"udp_buffer_size_bytes,omitempty" json:"udp_buffer_size_bytes,omitempty"`
+	// Local address that backend connections originate from, for backends that ACL by source or multi-homed hosts
+	// backend_source_interface uses the interface's address of the backend's family instead; set at most one
+	BackendSourceIP        string `yaml:"backend_source_ip,omitempty" json:"backend_source_ip,omitempty"`
+	BackendSourceInterface string `yaml:"backend_source_interface,omitempty" json:"backend_source_interface,omitempty"`
+	// Caps the size of datagrams forwarded to the backend and sets the don't-fragment bit (udp and both services)
+	UDPDatagramLimit *UDPDatagramLimitConfig `yaml:"udp_datagram_limit,omitempty" json:"udp_datagram_limit,omitempty"`
+	// Reuses a client's backend source port for its next UDP session after an idle timeout, for as long as the
+	// client keeps access, for backends that key state by the proxy's source port (udp and both services)
+	UDPStickySourcePort bool `yaml:"udp_sticky_source_port,omitempty" json:"udp_sticky_source_port,omitempty"`
+	// Re-broadcasts LAN discovery packets on the backend network and relays the replies (udp and both services)
+	UDPRelay *UDPRelayConfig `yaml:"udp_relay,omitempty" json:"udp_relay,omitempty"`
+	// Wakes a sleeping backend host when it cannot be reached; TCP and HTTP clients are held until it answers
+	WakeOnLAN *WakeOnLANConfig `yaml:"wake_on_lan,omitempty" json:"wake_on_lan,omitempty"`
+	// Docker container of the backend, started by the first connection and stopped again when idle
+	LazyContainer *LazyContainerConfig `yaml:"lazy_container,omitempty" json:"lazy_container,omitempty"`
+	// Announces the service going idle so automation can power down its VM or container
+	IdleHook *IdleHookConfig `yaml:"idle_hook,omitempty" json:"idle_hook,omitempty"`
+	// Listed on the unauthenticated GET /api/public/status with its name, online state and connection count
+	PublicStatus bool `yaml:"public_status,omitempty" json:"public_status,omitempty"`
+	// Lets server-list queries through without an allowlist entry, rate limited per IP (udp and both services)
+	PublicQuery *PublicQueryConfig `yaml:"public_query,omitempty" json:"public_query,omitempty"`
+	// Keepalive, TCP_NODELAY and kernel buffer sizes of client and backend sockets (tcp, both and socks5 services)
+	SocketOptions *SocketOptionsConfig `yaml:"socket_options,omitempty" json:"socket_options,omitempty"`
+	// Speaks TLS on one side of the connection only, like stunnel (tcp and both services; UDP is unaffected)
+	TLS *TCPTLSConfig `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// Outside the schedule portal sessions cannot reach the service (permanent and DNS entries are unaffected)
+	Schedule *AccessScheduleConfig `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+	// Application protocol spoken on the service, so denials can be explained in-protocol: "" | minecraft (tcp and both services)
+	// minecraft: denied players see deny_response.motd in the server list and as the kick message
+	ProtocolHint string `yaml:"protocol_hint,omitempty" json:"protocol_hint,omitempty"`
+	// What denied clients see instead of a silent close
+	DenyResponse *DenyResponseConfig `yaml:"deny_response,omitempty" json:"deny_response,omitempty"`
+	// Stops dialing a failing backend for a while; nil = 5 failures, 30s open, 3 half-open successes
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	// Per-service forward-auth headers, merged over forward_auth_config.response_headers (empty value removes a header)
+	ForwardAuthResponseHeaders map[string]string `yaml:"forward_auth_response_headers,omitempty" json:"forward_auth_response_headers,omitempty"`
+	Source                     string            `yaml:"-" json:"source,omitempty"` // Set for runtime-provided services (e.g. "kubernetes"); never saved
+	// More addresses and ports accepting clients for the same backend, run as part of this service
+	AdditionalListeners []ListenerConfig `yaml:"additional_listeners,omitempty" json:"additional_listeners,omitempty"`
+	Listener            string           `yaml:"-" json:"-"` // Set on the copies additional listeners run with, e.g. "[::]:25565"; empty = primary port
+}
+
+// UDPRelayConfig sends matching client packets to a broadcast or multicast address instead of the backend
+// For LAN games whose server browser finds servers by broadcast; every host that answers is relayed back
+type UDPRelayConfig struct {
+	TargetAddress      string   `yaml:"target_address" json:"target_address"`             // Broadcast (e.g. 192.168.1.255) or multicast group (e.g. 239.255.255.250)
+	TargetPort         int      `yaml:"target_port" json:"target_port"`                   // 0 = backend_target_port
+	MatchHexPrefixes   []string `yaml:"match_hex_prefixes" json:"match_hex_prefixes"`     // Packets starting with one of these bytes are relayed; empty = every packet
+	ReplyWindowSeconds int      `yaml:"reply_window_seconds" json:"reply_window_seconds"` // How long replies are relayed back; 0 = 2
+}
+
+// WakeOnLANConfig identifies the backend host to wake and how long clients wait for it
+// The magic packet is a broadcast, so the portal must share a LAN with the backend (network_mode: host)
+type WakeOnLANConfig struct {
+	MACAddress       string `yaml:"mac_address" json:"mac_address"`             // MAC of the backend's network card, e.g. 00:11:22:33:44:55
+	BroadcastAddress string `yaml:"broadcast_address" json:"broadcast_address"` // IPv4 broadcast of the backend's subnet; empty = 255.255.255.255
+	Port             int    `yaml:"port" json:"port"`                           // 0 = 9 (discard); some cards listen on 7
+	WaitSeconds      int    `yaml:"wait_seconds" json:"wait_seconds"`           // How long a client is held while the backend boots; 0 = 60
+}
+
+// LazyContainerConfig names the backend's Docker container and when it is stopped
+// The portal needs the Docker API (DOCKER_HOST, default /var/run/docker.sock)
+type LazyContainerConfig struct {
+	ContainerName       string `yaml:"container_name" json:"container_name"`               // Container name or ID
+	StartTimeoutSeconds int    `yaml:"start_timeout_seconds" json:"start_timeout_seconds"` // How long a client is held until the container is healthy and accepts connections; 0 = 60
+	IdleStopMinutes     int    `yaml:"idle_stop_minutes" json:"idle_stop_minutes"`         // Stop after this long without connections; 0 = never
+}
+
+// IdleHookConfig fires once per idle period, after the service carried no traffic for AfterMinutes
+// The service.idle event always goes to the admin event stream; webhook and command are optional
+type IdleHookConfig struct {
+	AfterMinutes int      `yaml:"after_minutes" json:"after_minutes"` // Minutes without connections, sessions or requests
+	WebhookURL   string   `yaml:"webhook_url" json:"webhook_url"`     // Receives the service.idle event as JSON; empty = none
+	Command      []string `yaml:"command" json:"command"`             // Program and arguments, run without a shell; KNOCK_SERVICE_ID, KNOCK_SERVICE_NAME and KNOCK_IDLE_SECONDS are set; empty = none
+}
+
+// PublicQueryConfig selects the UDP packets that may reach the backend from clients outside the allowlist
+// Game server browsers query the server before anyone logs in; without this the server shows as offline.
+// Typical prefixes: ffffffff54/55/56 (Source A2S info, players, rules), fefd (Minecraft query)
+type PublicQueryConfig struct {
+	MatchHexPrefixes    []string `yaml:"match_hex_prefixes" json:"match_hex_prefixes"`         // Packets starting with one of these bytes are public; empty = every packet (a dedicated query port)
+	MaxPacketsPerMinute int      `yaml:"max_packets_per_minute" json:"max_packets_per_minute"` // Per client IP; 0 = 60
+}
+
+// ListenerConfig is an additional address and port a service accepts clients on, with the service's protocols
+type ListenerConfig struct {
+	Address string `yaml:"address" json:"address"` // IP to bind, e.g. 0.0.0.0 or ::; empty = all addresses (IPv4 and IPv6)
+	Port    int    `yaml:"port" json:"port"`
+}
+
+// UDPDatagramLimitConfig protects backends with strict MTU expectations from large client datagrams
+type UDPDatagramLimitConfig struct {
+	MaxSizeBytes int    `yaml:"max_size_bytes" json:"max_size_bytes"` // Largest payload forwarded to the backend; 0 = no limit
+	Oversize     string `yaml:"oversize" json:"oversize"`             // Larger datagrams: drop (default) | truncate | log (forwarded in full)
+	DontFragment bool   `yaml:"dont_fragment" json:"dont_fragment"`   // Set DF on backend sockets; datagrams over the path MTU are dropped instead of fragmented (Linux only)
+}
+
+// SocketOptionsConfig tunes the client and backend sockets of a TCP service
+// Latency-sensitive traffic (games, SSH) wants no_delay; bulk transfers benefit from larger kernel buffers
+type SocketOptionsConfig struct {
+	KeepAliveSeconds   int   `yaml:"keepalive_seconds" json:"keepalive_seconds"`       // Idle time before keepalive probes; 0 = 30, -1 = keepalive off
+	NoDelay            *bool `yaml:"no_delay" json:"no_delay"`                         // TCP_NODELAY; nil = on (Go default), false lets Nagle batch small writes
+	ReceiveBufferBytes int   `yaml:"receive_buffer_bytes" json:"receive_buffer_bytes"` // SO_RCVBUF; 0 = OS default
+	SendBufferBytes    int   `yaml:"send_buffer_bytes" json:"send_buffer_bytes"`       // SO_SNDBUF; 0 = OS default
+}
+
+// TCPTLSConfig adds TLS to the backend or the client side of a TCP service
+// backend: plaintext clients, e.g. legacy software, reach a TLS-only backend; the portal verifies the backend
+// client: clients connect with TLS, which the portal terminates in front of a plaintext backend
+type TCPTLSConfig struct {
+	Mode               string `yaml:"mode" json:"mode"`                                 // backend | client
+	CAFile             string `yaml:"ca_file" json:"ca_file"`                           // backend: CA bundle verifying the backend's certificate; empty = system roots
+	ServerName         string `yaml:"server_name" json:"server_name"`                   // backend: SNI and name expected in the certificate; empty = backend_target_host
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"` // backend: accept any certificate (self-signed test setups only)
+	CertFile           string `yaml:"cert_file" json:"cert_file"`                       // client: certificate presented to clients (required); backend: optional client certificate for mutual TLS
+	KeyFile            string `yaml:"key_file" json:"key_file"`
+	ClientCAFile       string `yaml:"client_ca_file" json:"client_ca_file"` // client: require client certificates signed by this CA; empty = none
+	MinVersion         string `yaml:"min_version" json:"min_version"`       // 1.2 (default) | 1.3
+}
+
+// DenyResponseConfig defines the response to clients without an allowlist entry
+// {client_ip} and {service} are replaced in pages and banners
+type DenyResponseConfig struct {
+	HTMLPage     string `yaml:"html_page" json:"html_page"`           // HTTP services: page returned with the 403
+	HTMLPageFile string `yaml:"html_page_file" json:"html_page_file"` // HTTP services: read instead of html_page when set
+	TCPBanner    string `yaml:"tcp_banner" json:"tcp_banner"`         // TCP services: text sent before closing, e.g. "knock first at https://portal.example"
+	TCPCloseMode string `yaml:"tcp_close_mode" json:"tcp_close_mode"` // close (default) | reset (send RST, no banner) | blackhole (accept, never answer)
+	UDPMode      string `yaml:"udp_mode" json:"udp_mode"`             // drop (default) | unreachable (ICMP port unreachable, needs CAP_NET_RAW)
+	MOTD         string `yaml:"motd" json:"motd"`                     // protocol_hint minecraft: server list text and kick message (empty = tcp_banner)
+}
+
+// CircuitBreakerConfig tunes when a service's backend is considered down (0 = default)
+type CircuitBreakerConfig struct {
+	MaxFailures        int `yaml:"max_failures" json:"max_failures"`                 // Consecutive failures that open the circuit; 0 = 5
+	OpenTimeoutSeconds int `yaml:"open_timeout_seconds" json:"open_timeout_seconds"` // How long to reject before testing the backend again; 0 = 30
+	HalfOpenSuccesses  int `yaml:"half_open_successes" json:"half_open_successes"`   // Successes needed while testing to close the circuit; 0 = 3
+	// UDP: a new session whose backend sends nothing back within this time counts as a failure
+	// 0 = silence is not a failure (leave off for one-way protocols such as syslog)
+	UDPResponseTimeoutSeconds int `yaml:"udp_response_timeout_seconds" json:"udp_response_timeout_seconds"`
 }
 
 // HTTPProtocolConfig defines HTTP-specific configuration
@@ -76,4 +593,25 @@ type HTTPProtocolConfig struct {
 	OverrideHTTPRequestHeaders map[string]string `yaml:"override_http_request_headers" json:"override_http_request_headers"`
 	RemoveHTTPRequestHeaders   []string          `yaml:"remove_http_request_headers" json:"remove_http_request_headers"`
 	InjectHTTPResponseHeaders  map[string]string `yaml:"inject_http_response_headers" json:"inject_http_response_headers"`
+	EnableH2C                  bool              `yaml:"enable_h2c" json:"enable_h2c"` // HTTP/2 cleartext to clients and backend, required for gRPC
+	// HTTP/3 listener on the same port number over UDP, advertised to clients via Alt-Svc
+	EnableHTTP3 bool   `yaml:"enable_http3" json:"enable_http3"`
+	TLSCertFile string `yaml:"tls_cert_file" json:"tls_cert_file"` // Required for HTTP/3 (QUIC always uses TLS)
+	TLSKeyFile  string `yaml:"tls_key_file" json:"tls_key_file"`
+	// Limits protecting the portal from a single web app
+	MaxRequestBodyBytes    int64 `yaml:"max_request_body_bytes" json:"max_request_body_bytes"`     // 0 = unlimited
+	MaxRequestHeaderBytes  int   `yaml:"max_request_header_bytes" json:"max_request_header_bytes"` // 0 = 1 MB
+	MaxResponseBodyBytes   int64 `yaml:"max_response_body_bytes" json:"max_response_body_bytes"`   // 0 = unlimited
+	HeaderTimeoutSeconds   int   `yaml:"header_timeout_seconds" json:"header_timeout_seconds"`     // Client request headers; 0 = http_limits.header_read_timeout_seconds, or 30
+	ResponseTimeoutSeconds int   `yaml:"response_timeout_seconds" json:"response_timeout_seconds"` // Backend response headers; 0 = no limit
+	// Backend connection pool
+	MaxIdleConns           int  `yaml:"max_idle_conns" json:"max_idle_conns"`                       // 0 = 100
+	MaxIdleConnsPerHost    int  `yaml:"max_idle_conns_per_host" json:"max_idle_conns_per_host"`     // 0 = 2
+	IdleConnTimeoutSeconds int  `yaml:"idle_conn_timeout_seconds" json:"idle_conn_timeout_seconds"` // 0 = 90
+	DisableKeepAlives      bool `yaml:"disable_keep_alives" json:"disable_keep_alives"`             // New backend connection per request
+	PrewarmConnections     int  `yaml:"prewarm_connections" json:"prewarm_connections"`             // Opened with HEAD / requests on start; 0 = none
+	// Per-request access log for log analyzers (GoAccess, AWStats), separate from the application log
+	// Rotated with the limits of logging_config.file; services may share a file
+	AccessLogPath   string `yaml:"access_log_path" json:"access_log_path"`     // Empty = no access log
+	AccessLogFormat string `yaml:"access_log_format" json:"access_log_format"` // combined (default) | common
 }
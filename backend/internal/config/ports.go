@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// listener is a port the server binds for a configuration
+type listener struct {
+	network string // tcp | udp
+	address string // Host to bind; empty = all interfaces
+	port    int
+	owner   string // What listens, for error messages
+}
+
+// listeners returns the ports bound by the admin API, the proxies of enabled services, the transparent proxy and the honeypot
+// Firewall-mode services are enforced in the kernel and bind nothing
+func listeners(cfg *ApplicationConfig) []listener {
+	result := []listener{{network: "tcp", port: cfg.ProxyServerConfig.AdminAPIPort, owner: "proxy_server_config.admin_api_port"}}
+
+	for _, service := range cfg.ProtectedServices {
+		if !service.Enabled || service.EnforcementMode == "firewall" {
+			continue
+		}
+		result = append(result, serviceListeners(service, "service "+service.ServiceID)...)
+		for _, l := range service.AdditionalListeners {
+			listenerService := service.ForListener(l)
+			result = append(result, serviceListeners(listenerService, "service "+service.ServiceID+" (listener "+listenerService.Listener+")")...)
+		}
+	}
+
+	if tp := cfg.TransparentProxyConfig; tp.Enabled {
+		result = append(result, listener{network: "tcp", address: tp.ListenAddress, port: tp.ListenPort, owner: "transparent_proxy_config"})
+	}
+
+	if hp := cfg.HoneypotConfig; hp.Enabled {
+		address := hp.ListenAddress
+		if address == "" {
+			address = cfg.ProxyServerConfig.ListenAddress
+		}
+		for _, port := range hp.TCPPorts {
+			result = append(result, listener{network: "tcp", address: address, port: port, owner: "honeypot_config"})
+		}
+		for _, port := range hp.UDPPorts {
+			result = append(result, listener{network: "udp", address: address, port: port, owner: "honeypot_config"})
+		}
+	}
+	return result
+}
+
+// serviceListeners returns the ports a service binds on its primary address and port
+func serviceListeners(service ProtectedServiceConfig, owner string) []listener {
+	address := service.ProxyListenAddress
+	port := service.ProxyListenPortStart
+	protocol := strings.ToLower(service.TransportProtocol)
+	switch {
+	case service.IsHTTPProtocol:
+		result := []listener{{network: "tcp", address: address, port: port, owner: owner}}
+		if service.HTTPConfig != nil && service.HTTPConfig.EnableHTTP3 {
+			result = append(result, listener{network: "udp", address: address, port: port, owner: owner + " (HTTP/3)"})
+		}
+		return result
+	case protocol == "udp":
+		return []listener{{network: "udp", address: address, port: port, owner: owner}}
+	case protocol == "both":
+		return []listener{
+			{network: "tcp", address: address, port: port, owner: owner},
+			{network: "udp", address: address, port: port, owner: owner},
+		}
+	default:
+		return []listener{{network: "tcp", address: address, port: port, owner: owner}}
+	}
+}
+
+// ForListener returns the copy of a service its proxies for one additional listener run with
+func (s ProtectedServiceConfig) ForListener(l ListenerConfig) ProtectedServiceConfig {
+	s.ProxyListenAddress = l.Address
+	s.ProxyListenPortStart = l.Port
+	s.ProxyListenPortEnd = l.Port
+	s.AdditionalListeners = nil
+	s.Listener = net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+	return s
+}
+
+// ListenNetwork returns the network to bind an address on: IPv4 or IPv6 only for an address of that family,
+// so 0.0.0.0 and :: can be bound on the same port side by side; both families for an empty address
+func ListenNetwork(network, address string) string {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return network
+	}
+	if addr.Is4() {
+		return network + "4"
+	}
+	return network + "6"
+}
+
+// checkAdminPort ensures no enabled service proxies TCP on the admin API port
+func checkAdminPort(cfg *ApplicationConfig) error {
+	adminPort := cfg.ProxyServerConfig.AdminAPIPort
+	for _, l := range listeners(cfg) {
+		if l.network == "tcp" && l.port == adminPort && strings.HasPrefix(l.owner, "service ") {
+			return fmt.Errorf("%s: port %d is the admin API port (proxy_server_config.admin_api_port)", l.owner, l.port)
+		}
+	}
+	return nil
+}
+
+// CheckPortAvailability binds each port a new configuration listens on that the running configuration
+// does not already hold, so a port taken by another process is reported before saving instead of the
+// proxy failing to start after the reload
+func CheckPortAvailability(cfg, running *ApplicationConfig) error {
+	held := make(map[string]bool)
+	for _, l := range listeners(running) {
+		held[l.network+"/"+strconv.Itoa(l.port)] = true
+	}
+
+	for _, l := range listeners(cfg) {
+		if l.port < 1 || held[l.network+"/"+strconv.Itoa(l.port)] {
+			continue
+		}
+		if err := probePort(ListenNetwork(l.network, l.address), net.JoinHostPort(l.address, strconv.Itoa(l.port))); err != nil {
+			return fmt.Errorf("%s: %s port %d is not available (%v); stop the process using it or choose another port", l.owner, l.network, l.port, err)
+		}
+	}
+	return nil
+}
+
+// probePort binds an address and releases it right away
+func probePort(network, address string) error {
+	if strings.HasPrefix(network, "udp") {
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
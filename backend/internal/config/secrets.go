@@ -0,0 +1,263 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// Secret values in the config file can be stored encrypted with age as ENC[age,<base64 age message>].
+// The key comes from the environment so the file itself is safe to sync and back up:
+//   - CONFIG_AGE_KEY_FILE: identity file as written by age-keygen or `knock-knock generate-age-key`
+//   - CONFIG_AGE_KEY: the AGE-SECRET-KEY-1... identity itself
+//   - CONFIG_AGE_RECIPIENTS: comma-separated age1... keys that can decrypt saved secrets as well
+//
+// With a key set, SaveConfig encrypts every secret field; encrypted values are decrypted wherever they appear.
+const (
+	encryptedPrefix = "ENC[age,"
+	encryptedSuffix = "]"
+)
+
+// sealedSecrets maps decrypted values to the ciphertext they were read from, so saving the config
+// keeps unchanged secrets byte for byte instead of re-encrypting them with a new random key
+var sealedSecrets sync.Map
+
+// IsSecretField reports whether the last element of a field path holds a credential
+// Used for encrypting the config file, audit diffs and printing the effective configuration
+func IsSecretField(path string) bool {
+	name := strings.ToLower(path[strings.LastIndex(path, ".")+1:])
+	switch name {
+	case "webhook_url", "update_url": // Usually carry a token in the path, query or userinfo
+		return true
+	}
+	return strings.Contains(name, "password") || strings.Contains(name, "secret") || strings.HasSuffix(name, "token")
+}
+
+// secretKeys holds the age keys from the environment
+type secretKeys struct {
+	identities []*age.X25519Identity
+	recipients []age.Recipient
+}
+
+// loadSecretKeys reads the age keys from the environment; returns nil if none are configured
+func loadSecretKeys() (*secretKeys, error) {
+	keys := &secretKeys{}
+
+	if keyFile := os.Getenv("CONFIG_AGE_KEY_FILE"); keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CONFIG_AGE_KEY_FILE: %w", err)
+		}
+		identities, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_AGE_KEY_FILE: %w", err)
+		}
+		for _, identity := range identities {
+			x25519, ok := identity.(*age.X25519Identity)
+			if !ok {
+				return nil, errors.New("CONFIG_AGE_KEY_FILE: only X25519 identities are supported")
+			}
+			keys.identities = append(keys.identities, x25519)
+		}
+	}
+	if key := os.Getenv("CONFIG_AGE_KEY"); key != "" {
+		identity, err := age.ParseX25519Identity(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_AGE_KEY: %w", err)
+		}
+		keys.identities = append(keys.identities, identity)
+	}
+	if len(keys.identities) == 0 {
+		return nil, nil
+	}
+
+	for _, identity := range keys.identities {
+		keys.recipients = append(keys.recipients, identity.Recipient())
+	}
+	if extra := os.Getenv("CONFIG_AGE_RECIPIENTS"); extra != "" {
+		for _, value := range strings.Split(extra, ",") {
+			if value = strings.TrimSpace(value); value == "" {
+				continue
+			}
+			recipient, err := age.ParseX25519Recipient(value)
+			if err != nil {
+				return nil, fmt.Errorf("CONFIG_AGE_RECIPIENTS: %w", err)
+			}
+			keys.recipients = append(keys.recipients, recipient)
+		}
+	}
+	return keys, nil
+}
+
+// isEncrypted reports whether a value is an encrypted secret
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) && strings.HasSuffix(value, encryptedSuffix)
+}
+
+// decrypt returns the plaintext of an ENC[age,...] value
+func (k *secretKeys) decrypt(value string) (string, error) {
+	encoded := strings.TrimSuffix(strings.TrimPrefix(value, encryptedPrefix), encryptedSuffix)
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	identities := make([]age.Identity, len(k.identities))
+	for i, identity := range k.identities {
+		identities[i] = identity
+	}
+	reader, err := age.Decrypt(bytes.NewReader(ciphertext), identities...)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	sealedSecrets.Store(string(plaintext), value)
+	return string(plaintext), nil
+}
+
+// encrypt returns the ENC[age,...] form of a value, reusing the ciphertext it was read from
+func (k *secretKeys) encrypt(plaintext string) (string, error) {
+	if sealed, ok := sealedSecrets.Load(plaintext); ok {
+		return sealed.(string), nil
+	}
+	var ciphertext bytes.Buffer
+	writer, err := age.Encrypt(&ciphertext, k.recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(writer, plaintext); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	sealed := encryptedPrefix + base64.StdEncoding.EncodeToString(ciphertext.Bytes()) + encryptedSuffix
+	sealedSecrets.Store(plaintext, sealed)
+	return sealed, nil
+}
+
+// decryptSecrets replaces every ENC[age,...] value of a YAML document with its plaintext
+func decryptSecrets(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(encryptedPrefix)) {
+		return data, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+
+	keys, err := loadSecretKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkScalars(&doc, "", func(node *yaml.Node, path string) error {
+		if !isEncrypted(node.Value) {
+			return nil
+		}
+		if keys == nil {
+			return fmt.Errorf("%s is encrypted but neither CONFIG_AGE_KEY_FILE nor CONFIG_AGE_KEY is set", path)
+		}
+		plaintext, err := keys.decrypt(node.Value)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+		node.Value = plaintext
+		node.Tag = "!!str"
+		node.Style = yaml.DoubleQuotedStyle
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&doc)
+}
+
+// encryptSecrets encrypts the non-empty string values of secret fields in a YAML document
+// Returns the number of values encrypted; values that already are encrypted are left alone
+func encryptSecrets(node *yaml.Node, keys *secretKeys) (int, error) {
+	if node.Kind != yaml.MappingNode {
+		total := 0
+		for _, child := range node.Content {
+			n, err := encryptSecrets(child, keys)
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+		return total, nil
+	}
+
+	total := 0
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if IsSecretField(key.Value) && value.Kind == yaml.ScalarNode && value.Tag == "!!str" && value.Value != "" {
+			if isEncrypted(value.Value) {
+				continue
+			}
+			sealed, err := keys.encrypt(value.Value)
+			if err != nil {
+				return total, fmt.Errorf("failed to encrypt %s: %w", key.Value, err)
+			}
+			value.Value = sealed
+			value.Style = 0
+			total++
+			continue
+		}
+		n, err := encryptSecrets(value, keys)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// EncryptFile encrypts the plain text secrets of a config file in place, keeping its layout and comments
+// Returns the number of values encrypted
+func EncryptFile(configPath string) (int, error) {
+	keys, err := loadSecretKeys()
+	if err != nil {
+		return 0, err
+	}
+	if keys == nil {
+		return 0, errors.New("CONFIG_AGE_KEY_FILE or CONFIG_AGE_KEY must be set")
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if isSOPSFile(data) {
+		return 0, errors.New("config file is encrypted with SOPS, which already protects it")
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return 0, fmt.Errorf("failed to parse YAML config: %w", err)
+	}
+	encrypted, err := encryptSecrets(&doc, keys)
+	if err != nil || encrypted == 0 {
+		return 0, err
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(configPath, out, 0644); err != nil {
+		return 0, fmt.Errorf("failed to write config file: %w", err)
+	}
+	return encrypted, nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config file encrypted with SOPS is decrypted and re-encrypted by the sops binary, which finds
+// its keys the usual way (SOPS_AGE_KEY_FILE, GPG agent, cloud KMS credentials). Saving encrypts
+// to the age and PGP keys and with the field selection recorded in the file's sops metadata.
+
+// sopsMetadata is the part of the sops section needed to encrypt the file again
+type sopsMetadata struct {
+	MAC string `yaml:"mac"`
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+	} `yaml:"age"`
+	PGP []struct {
+		Fingerprint string `yaml:"fp"`
+	} `yaml:"pgp"`
+	EncryptedRegex    string `yaml:"encrypted_regex"`
+	UnencryptedRegex  string `yaml:"unencrypted_regex"`
+	EncryptedSuffix   string `yaml:"encrypted_suffix"`
+	UnencryptedSuffix string `yaml:"unencrypted_suffix"`
+}
+
+// readSOPSMetadata returns the sops metadata of a config file, or nil if it is not encrypted with SOPS
+func readSOPSMetadata(data []byte) *sopsMetadata {
+	if !bytes.Contains(data, []byte("sops:")) {
+		return nil
+	}
+	var doc struct {
+		SOPS *sopsMetadata `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil || doc.SOPS == nil || doc.SOPS.MAC == "" {
+		return nil
+	}
+	return doc.SOPS
+}
+
+// isSOPSFile reports whether a config file is encrypted with SOPS
+func isSOPSFile(data []byte) bool {
+	return readSOPSMetadata(data) != nil
+}
+
+// sopsDecrypt decrypts a SOPS-encrypted YAML document
+func sopsDecrypt(data []byte) ([]byte, error) {
+	out, err := runSOPS(data, "--decrypt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SOPS config: %w", err)
+	}
+	return out, nil
+}
+
+// sopsEncrypt encrypts a YAML document with the keys and field selection of existing sops metadata
+func sopsEncrypt(data []byte, meta *sopsMetadata) ([]byte, error) {
+	args := []string{"--encrypt"}
+
+	var ageRecipients, pgpKeys []string
+	for _, key := range meta.Age {
+		ageRecipients = append(ageRecipients, key.Recipient)
+	}
+	for _, key := range meta.PGP {
+		pgpKeys = append(pgpKeys, key.Fingerprint)
+	}
+	if len(ageRecipients) == 0 && len(pgpKeys) == 0 {
+		return nil, errors.New("SOPS config has no age or PGP keys to encrypt to; save it with the sops binary instead")
+	}
+	if len(ageRecipients) > 0 {
+		args = append(args, "--age", strings.Join(ageRecipients, ","))
+	}
+	if len(pgpKeys) > 0 {
+		args = append(args, "--pgp", strings.Join(pgpKeys, ","))
+	}
+
+	switch {
+	case meta.EncryptedRegex != "":
+		args = append(args, "--encrypted-regex", meta.EncryptedRegex)
+	case meta.UnencryptedRegex != "":
+		args = append(args, "--unencrypted-regex", meta.UnencryptedRegex)
+	case meta.EncryptedSuffix != "":
+		args = append(args, "--encrypted-suffix", meta.EncryptedSuffix)
+	case meta.UnencryptedSuffix != "":
+		args = append(args, "--unencrypted-suffix", meta.UnencryptedSuffix)
+	}
+
+	out, err := runSOPS(data, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt config with SOPS: %w", err)
+	}
+	return out, nil
+}
+
+// runSOPS runs the sops binary (SOPS_BINARY, or sops from PATH) on data passed through stdin,
+// so the decrypted config never touches the disk
+func runSOPS(data []byte, args ...string) ([]byte, error) {
+	binary := os.Getenv("SOPS_BINARY")
+	if binary == "" {
+		binary = "sops"
+	}
+
+	args = append(args, "--input-type", "yaml", "--output-type", "yaml", "--filename-override", "config.yml", "/dev/stdin")
+	cmd := exec.Command(binary, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import "fmt"
+
+// EffectiveUser returns a portal user with the settings of its user_groups merged in
+// The stored account is never modified, so saving the config keeps the user's own values. Merge rules:
+//   - allowed_service_ids: the user's entries followed by each group's, in the order of the user's groups,
+//     without duplicates; a group with an empty list grants every service
+//   - session_duration_seconds, max_sessions: the user's value, otherwise the largest one set by a group
+//   - require_approval: required if the user or any group requires it
+//   - schedule: the user's, otherwise that of the first group that has one
+//
+// Users without groups are returned as they are
+func EffectiveUser(cfg *ApplicationConfig, user *PortalUserAccount) *PortalUserAccount {
+	if user == nil || len(user.Groups) == 0 {
+		return user
+	}
+
+	merged := *user
+	services := append([]string(nil), user.AllowedServiceIDs...)
+	allServices := false
+	sessionDuration, maxSessions := 0, 0
+
+	for _, name := range user.Groups {
+		group := findUserGroup(cfg, name)
+		if group == nil {
+			continue // Rejected by the validator; ignored if it slips through a reload
+		}
+		if len(group.AllowedServiceIDs) == 0 {
+			allServices = true
+		}
+		services = append(services, group.AllowedServiceIDs...)
+		sessionDuration = max(sessionDuration, group.SessionDurationSeconds)
+		maxSessions = max(maxSessions, group.MaxSessions)
+		merged.RequireApproval = merged.RequireApproval || group.RequireApproval
+		if merged.Schedule == nil {
+			merged.Schedule = group.Schedule
+		}
+	}
+
+	if allServices {
+		merged.AllowedServiceIDs = nil
+	} else {
+		merged.AllowedServiceIDs = dedupe(services)
+	}
+	if merged.SessionDurationSeconds == 0 {
+		merged.SessionDurationSeconds = sessionDuration
+	}
+	if merged.MaxSessions == 0 {
+		merged.MaxSessions = maxSessions
+	}
+	return &merged
+}
+
+// findUserGroup returns the user group with the given name, or nil
+func findUserGroup(cfg *ApplicationConfig, name string) *UserGroupConfig {
+	for i := range cfg.UserGroups {
+		if cfg.UserGroups[i].Name == name {
+			return &cfg.UserGroups[i]
+		}
+	}
+	return nil
+}
+
+// dedupe drops repeated entries, keeping the first occurrence
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// validateUserGroups checks group names and values and that every portal user only lists existing groups
+func validateUserGroups(cfg *ApplicationConfig) error {
+	names := make(map[string]bool, len(cfg.UserGroups))
+	for i, group := range cfg.UserGroups {
+		if group.Name == "" {
+			return fmt.Errorf("user group %d: name is required", i)
+		}
+		if names[group.Name] {
+			return fmt.Errorf("user group %s: duplicate name", group.Name)
+		}
+		names[group.Name] = true
+		if group.SessionDurationSeconds < 0 {
+			return fmt.Errorf("user group %s: session_duration_seconds must be >= 0", group.Name)
+		}
+		if group.MaxSessions < 0 {
+			return fmt.Errorf("user group %s: max_sessions must be >= 0", group.Name)
+		}
+		if err := validateSchedule(group.Schedule); err != nil {
+			return fmt.Errorf("user group %s: invalid schedule: %w", group.Name, err)
+		}
+	}
+
+	for _, user := range cfg.PortalUserAccounts {
+		if user.SessionDurationSeconds < 0 {
+			return fmt.Errorf("portal user %s: session_duration_seconds must be >= 0", user.Username)
+		}
+		if user.MaxSessions < 0 {
+			return fmt.Errorf("portal user %s: max_sessions must be >= 0", user.Username)
+		}
+		listed := make(map[string]bool, len(user.Groups))
+		for _, name := range user.Groups {
+			if !names[name] {
+				return fmt.Errorf("portal user %s: unknown group %s", user.Username, name)
+			}
+			if listed[name] {
+				return fmt.Errorf("portal user %s: group %s is listed twice", user.Username, name)
+			}
+			listed[name] = true
+		}
+	}
+	return nil
+}
@@ -1,13 +1,29 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
+	"net"
 	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
 )
 
 // ValidateConfig validates the configuration for errors
 func ValidateConfig(cfg *ApplicationConfig) error {
+	// Validate include entries (conflicts between files are found while merging them)
+	for i, entry := range cfg.Include {
+		if strings.TrimSpace(entry) == "" {
+			return fmt.Errorf("include[%d] must not be empty", i)
+		}
+	}
+
 	// Validate session config
 	if cfg.SessionConfig.DefaultSessionDurationSeconds < 1 {
 		return fmt.Errorf("default_session_duration_seconds must be >= 1")
@@ -15,11 +31,48 @@ func ValidateConfig(cfg *ApplicationConfig) error {
 	if cfg.SessionConfig.SessionCleanupIntervalSeconds < 1 {
 		return fmt.Errorf("session_cleanup_interval_seconds must be >= 1")
 	}
+	if cfg.SessionConfig.IPv4GrantPrefix < 0 || cfg.SessionConfig.IPv4GrantPrefix > 32 {
+		return fmt.Errorf("ipv4_grant_prefix must be between 0 and 32")
+	}
+	if cfg.SessionConfig.IPv6GrantPrefix < 0 || cfg.SessionConfig.IPv6GrantPrefix > 128 {
+		return fmt.Errorf("ipv6_grant_prefix must be between 0 and 128")
+	}
+	if cfg.SessionConfig.MaxManualExtensions < 0 {
+		return fmt.Errorf("max_manual_extensions must be >= 0")
+	}
+	if cfg.SessionConfig.MinExtensionIntervalSeconds < 0 {
+		return fmt.Errorf("min_extension_interval_seconds must be >= 0")
+	}
+	if cfg.SessionConfig.ExpiryWarningSeconds < 0 {
+		return fmt.Errorf("expiry_warning_seconds must be >= 0")
+	}
+	if grace := cfg.SessionConfig.ExpiryGracePeriodSeconds; grace != nil && *grace < 0 {
+		return fmt.Errorf("expiry_grace_period_seconds must be >= 0")
+	}
+
+	// Validate dual-stack correlation endpoints
+	if dualStack := cfg.SessionConfig.DualStackCorrelation; dualStack.Enabled {
+		if dualStack.IPv4EndpointURL == "" && dualStack.IPv6EndpointURL == "" {
+			return fmt.Errorf("dual_stack_correlation requires ipv4_endpoint_url and/or ipv6_endpoint_url when enabled")
+		}
+		for _, endpoint := range []string{dualStack.IPv4EndpointURL, dualStack.IPv6EndpointURL} {
+			if endpoint == "" {
+				continue
+			}
+			parsed, err := url.Parse(endpoint)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("dual_stack_correlation endpoint '%s' must be an absolute http(s) URL", endpoint)
+			}
+		}
+	}
 
 	// Validate network access control
 	if cfg.NetworkAccessControl.DNSRefreshIntervalSeconds < 1 {
 		return fmt.Errorf("dns_refresh_interval_seconds must be >= 1")
 	}
+	if cfg.NetworkAccessControl.DNSIPv6GrantPrefixLength < 0 || cfg.NetworkAccessControl.DNSIPv6GrantPrefixLength > 128 {
+		return fmt.Errorf("dns_ipv6_grant_prefix_length must be between 0 and 128")
+	}
 
 	// Validate IP ranges
 	for _, ipRange := range cfg.NetworkAccessControl.PermanentlyAllowedIPRanges {
@@ -30,6 +83,45 @@ func ValidateConfig(cfg *ApplicationConfig) error {
 			}
 		}
 	}
+	for _, ipRange := range cfg.NetworkAccessControl.HairpinLANRanges {
+		if err := validateLANRange(ipRange); err != nil {
+			return fmt.Errorf("hairpin_lan_ranges: %w", err)
+		}
+	}
+
+	// Validate proxy server config
+	if cfg.ProxyServerConfig.UpgradeDrainTimeoutSeconds < 0 {
+		return fmt.Errorf("upgrade_drain_timeout_seconds must be >= 0")
+	}
+	if cfg.ProxyServerConfig.AdminTokenLifetimeSeconds < 0 || cfg.ProxyServerConfig.AdminRefreshLifetimeSeconds < 0 {
+		return fmt.Errorf("admin_token_lifetime_seconds and admin_refresh_lifetime_seconds must be >= 0")
+	}
+	if cfg.ProxyServerConfig.BackendDNSRefreshSeconds < 0 || cfg.ProxyServerConfig.BackendProbeIntervalSeconds < 0 {
+		return fmt.Errorf("backend_dns_refresh_seconds and backend_probe_interval_seconds must be >= 0")
+	}
+	if cfg.ProxyServerConfig.MaxConnectionsPerUser < 0 {
+		return fmt.Errorf("max_connections_per_user must be >= 0")
+	}
+	if err := validateBufferSizes(cfg.ProxyServerConfig.TCPBufferSizeBytes, cfg.ProxyServerConfig.UDPBufferSizeBytes); err != nil {
+		return err
+	}
+	if hl := cfg.ProxyServerConfig.HTTPLimits; hl.HeaderReadTimeoutSeconds < 0 || hl.MinTransferRateBytesPerSecond < 0 ||
+		hl.TransferRateGracePeriodSeconds < 0 || hl.MaxInFlightRequestsPerIP < 0 {
+		return fmt.Errorf("http_limits values must be >= 0")
+	}
+	if rl := cfg.ProxyServerConfig.ConnectionRateLimit; rl.MaxNewConnections < 0 || rl.WindowSeconds < 0 {
+		return fmt.Errorf("connection_rate_limit values must be >= 0")
+	}
+	if err := validateLoginRateLimit("portal", cfg.ProxyServerConfig.LoginRateLimits.Portal); err != nil {
+		return err
+	}
+	if err := validateLoginRateLimit("admin", cfg.ProxyServerConfig.LoginRateLimits.Admin); err != nil {
+		return err
+	}
+	if rl := cfg.ProxyServerConfig.APIRateLimit; rl.AnonymousRequestsPerMinute < 0 || rl.AnonymousBurst < 0 ||
+		rl.AuthenticatedRequestsPerMinute < 0 || rl.AuthenticatedBurst < 0 || rl.MaxTrackedIPs < 0 {
+		return fmt.Errorf("api_rate_limit values must be >= 0")
+	}
 
 	// Validate trusted proxy IP ranges
 	if cfg.TrustedProxyConfig.Enabled {
@@ -42,6 +134,83 @@ func ValidateConfig(cfg *ApplicationConfig) error {
 		}
 	}
 
+	// Validate port mapping
+	if cfg.PortMappingConfig.Enabled {
+		protocol := strings.ToLower(cfg.PortMappingConfig.Protocol)
+		if protocol != "auto" && protocol != "natpmp" && protocol != "upnp" {
+			return fmt.Errorf("port_mapping_config.protocol must be 'auto', 'natpmp', or 'upnp'")
+		}
+		if cfg.PortMappingConfig.LeaseDurationSeconds < 60 {
+			return fmt.Errorf("port_mapping_config.lease_duration_seconds must be >= 60")
+		}
+		if cfg.PortMappingConfig.GatewayAddress != "" {
+			if _, err := netip.ParseAddr(cfg.PortMappingConfig.GatewayAddress); err != nil {
+				return fmt.Errorf("invalid port_mapping_config.gateway_address '%s': %w", cfg.PortMappingConfig.GatewayAddress, err)
+			}
+		}
+	}
+
+	// Validate dynamic DNS
+	if dyn := cfg.DynamicDNSConfig; dyn.Enabled {
+		if dyn.Hostname == "" && dyn.Provider != "http" {
+			return fmt.Errorf("dynamic_dns_config.hostname is required")
+		}
+		if dyn.UpdateIntervalSeconds < 30 {
+			return fmt.Errorf("dynamic_dns_config.update_interval_seconds must be >= 30")
+		}
+		if dyn.IPv4DetectionURL == "" && dyn.IPv6DetectionURL == "" {
+			return fmt.Errorf("dynamic_dns_config requires ipv4_detection_url and/or ipv6_detection_url")
+		}
+		switch dyn.Provider {
+		case "cloudflare":
+			if dyn.APIToken == "" || dyn.CloudflareZoneID == "" {
+				return fmt.Errorf("dynamic_dns_config with provider 'cloudflare' requires api_token and cloudflare_zone_id")
+			}
+		case "duckdns":
+			if dyn.APIToken == "" {
+				return fmt.Errorf("dynamic_dns_config with provider 'duckdns' requires api_token")
+			}
+		case "http":
+			parsed, err := url.Parse(dyn.UpdateURL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				return fmt.Errorf("dynamic_dns_config with provider 'http' requires an absolute http(s) update_url")
+			}
+		default:
+			return fmt.Errorf("dynamic_dns_config.provider must be 'cloudflare', 'duckdns', or 'http'")
+		}
+	}
+
+	// Validate Kubernetes controller
+	if k8s := cfg.KubernetesConfig; k8s.Enabled {
+		if k8s.AnnotationPrefix == "" {
+			return fmt.Errorf("kubernetes_config.annotation_prefix is required")
+		}
+		if k8s.APIServerURL != "" {
+			parsed, err := url.Parse(k8s.APIServerURL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("kubernetes_config.api_server_url must be an absolute http(s) URL")
+			}
+		}
+	}
+
+	// Validate forward-auth response headers
+	if err := validateForwardAuthHeaders("forward_auth_config.response_headers", cfg.ForwardAuthConfig.ResponseHeaders); err != nil {
+		return err
+	}
+
+	// Validate firewall enforcement backend
+	if fw := cfg.FirewallConfig; HasFirewallServices(cfg) {
+		if fw.Backend != "nftables" && fw.Backend != "iptables" && fw.Backend != "windows" {
+			return fmt.Errorf("firewall_config.backend must be 'nftables', 'iptables', or 'windows'")
+		}
+		if fw.TableName == "" || len(fw.TableName) > 20 || strings.Trim(fw.TableName, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") != "" {
+			return fmt.Errorf("firewall_config.table_name must be 1-20 letters, digits or underscores")
+		}
+		if fw.SyncIntervalSeconds < 1 {
+			return fmt.Errorf("firewall_config.sync_interval_seconds must be >= 1")
+		}
+	}
+
 	// Validate portal users
 	for i, user := range cfg.PortalUserAccounts {
 		if user.UserID == "" {
@@ -53,11 +222,107 @@ func ValidateConfig(cfg *ApplicationConfig) error {
 		if user.BcryptHashedPassword == "" {
 			return fmt.Errorf("portal user %s: bcrypt_hashed_password is required", user.Username)
 		}
-		if !strings.HasPrefix(user.BcryptHashedPassword, "$2a$") &&
-			!strings.HasPrefix(user.BcryptHashedPassword, "$2b$") &&
-			!strings.HasPrefix(user.BcryptHashedPassword, "$2y$") {
+		if !isBcryptHash(user.BcryptHashedPassword) {
 			return fmt.Errorf("portal user %s: bcrypt_hashed_password does not appear to be a valid bcrypt hash", user.Username)
 		}
+		if err := validateSchedule(user.Schedule); err != nil {
+			return fmt.Errorf("portal user %s: invalid schedule: %w", user.Username, err)
+		}
+	}
+
+	// Validate admin accounts
+	adminUsernames := make(map[string]bool, len(cfg.AdminAccounts))
+	for i, admin := range cfg.AdminAccounts {
+		if admin.Username == "" {
+			return fmt.Errorf("admin account %d: username is required", i)
+		}
+		if adminUsernames[admin.Username] {
+			return fmt.Errorf("admin account %s: duplicate username", admin.Username)
+		}
+		adminUsernames[admin.Username] = true
+		if !isBcryptHash(admin.BcryptHashedPassword) {
+			return fmt.Errorf("admin account %s: bcrypt_hashed_password does not appear to be a valid bcrypt hash", admin.Username)
+		}
+	}
+
+	// Validate login approval
+	if cfg.ApprovalConfig.RequestTimeoutSeconds < 0 {
+		return fmt.Errorf("approval_config.request_timeout_seconds must be >= 0")
+	}
+	if webhookURL := cfg.ApprovalConfig.WebhookURL; webhookURL != "" {
+		parsed, err := url.Parse(webhookURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("approval_config.webhook_url must be an absolute http(s) URL")
+		}
+	}
+	if tg := cfg.ApprovalConfig.Telegram; tg.Enabled {
+		if tg.BotToken == "" {
+			return fmt.Errorf("approval_config.telegram.bot_token is required")
+		}
+		if len(tg.ChatIDs) == 0 {
+			return fmt.Errorf("approval_config.telegram.chat_ids must contain at least one chat")
+		}
+	}
+
+	// Validate Tailscale identity mappings
+	if ts := cfg.TailscaleConfig; ts.Enabled {
+		if ts.SocketPath == "" {
+			return fmt.Errorf("tailscale_config.socket_path is required")
+		}
+		if ts.CacheSeconds < 0 {
+			return fmt.Errorf("tailscale_config.cache_seconds must be >= 0")
+		}
+		userIDs := make(map[string]bool, len(cfg.PortalUserAccounts))
+		for _, user := range cfg.PortalUserAccounts {
+			userIDs[user.UserID] = true
+		}
+		for i, mapping := range ts.UserMappings {
+			if mapping.LoginName == "" {
+				return fmt.Errorf("tailscale_config.user_mappings %d: login_name is required", i)
+			}
+			if !userIDs[mapping.UserID] {
+				return fmt.Errorf("tailscale_config.user_mappings %s: unknown user_id '%s'", mapping.LoginName, mapping.UserID)
+			}
+		}
+		if ts.DefaultUserID != "" && !userIDs[ts.DefaultUserID] {
+			return fmt.Errorf("tailscale_config.default_user_id: unknown user_id '%s'", ts.DefaultUserID)
+		}
+		for i, mapping := range ts.GroupMappings {
+			if mapping.LoginName == "" {
+				return fmt.Errorf("tailscale_config.group_mappings %d: login_name is required", i)
+			}
+			if len(mapping.Groups) == 0 {
+				return fmt.Errorf("tailscale_config.group_mappings %s: at least one group is required", mapping.LoginName)
+			}
+			for _, group := range mapping.Groups {
+				if findUserGroup(cfg, group) == nil {
+					return fmt.Errorf("tailscale_config.group_mappings %s: unknown user group '%s'", mapping.LoginName, group)
+				}
+			}
+		}
+	}
+
+	// Validate transparent proxy listener
+	if tp := cfg.TransparentProxyConfig; tp.Enabled {
+		if tp.Mode != "tproxy" && tp.Mode != "redirect" {
+			return fmt.Errorf("transparent_proxy_config.mode must be 'tproxy' or 'redirect'")
+		}
+		if tp.ListenPort < 1 || tp.ListenPort > 65535 {
+			return fmt.Errorf("transparent_proxy_config.listen_port must be between 1 and 65535")
+		}
+	}
+
+	// Validate stats history retention
+	if sh := cfg.StatsHistoryConfig; sh.Enabled && (sh.RetentionDays < 1 || sh.RetentionDays > 365) {
+		return fmt.Errorf("stats_history_config.retention_days must be between 1 and 365")
+	}
+	if days := cfg.StatsHistoryConfig.UptimeRetentionDays; days < 0 || days > 365 {
+		return fmt.Errorf("stats_history_config.uptime_retention_days must be between 0 and 365")
+	}
+
+	// Validate log output
+	if err := validateLogging(cfg.LoggingConfig); err != nil {
+		return err
 	}
 
 	// Validate protected services
@@ -79,24 +344,241 @@ func ValidateConfig(cfg *ApplicationConfig) error {
 		if service.ProxyListenPortEnd > 65535 {
 			return fmt.Errorf("service %s: invalid proxy_listen_port_end %d", service.ServiceID, service.ProxyListenPortEnd)
 		}
+		if err := validateListeners(service); err != nil {
+			return fmt.Errorf("service %s: %w", service.ServiceID, err)
+		}
+
+		// Validate protocol
+		protocol := strings.ToLower(service.TransportProtocol)
+		if protocol != "tcp" && protocol != "udp" && protocol != "both" && protocol != "socks5" {
+			return fmt.Errorf("service %s: transport_protocol must be 'tcp', 'udp', 'both', or 'socks5'", service.ServiceID)
+		}
+
+		// SOCKS5 services with explicit destinations don't need a fixed backend
+		fixedBackend := protocol != "socks5" || len(service.SOCKS5AllowedDestinations) == 0
 
 		// Backend port validation
-		if service.BackendTargetHost == "" {
+		if fixedBackend && service.BackendTargetHost == "" {
 			return fmt.Errorf("service %s: backend_target_host is required", service.ServiceID)
 		}
-		if service.BackendTargetPort < 1 || service.BackendTargetPort > 65535 {
+		if fixedBackend && (service.BackendTargetPort < 1 || service.BackendTargetPort > 65535) {
 			return fmt.Errorf("service %s: invalid backend_target_port %d", service.ServiceID, service.BackendTargetPort)
 		}
 
-		// Validate protocol
-		protocol := strings.ToLower(service.TransportProtocol)
-		if protocol != "tcp" && protocol != "udp" && protocol != "both" {
-			return fmt.Errorf("service %s: transport_protocol must be 'tcp', 'udp', or 'both'", service.ServiceID)
+		// Validate SOCKS5 options
+		if protocol == "socks5" {
+			if service.IsHTTPProtocol {
+				return fmt.Errorf("service %s: transport_protocol 'socks5' cannot be used with is_http_protocol", service.ServiceID)
+			}
+			for _, destination := range service.SOCKS5AllowedDestinations {
+				if err := validateSOCKS5Destination(destination); err != nil {
+					return fmt.Errorf("service %s: invalid socks5_allowed_destinations entry '%s': %w", service.ServiceID, destination, err)
+				}
+			}
 		}
 
-		// Validate backend host
-		if service.BackendTargetHost == "" {
-			return fmt.Errorf("service %s: backend_target_host is required", service.ServiceID)
+		// Validate enforcement mode
+		switch service.EnforcementMode {
+		case "", "proxy":
+		case "firewall":
+			if service.IsHTTPProtocol || protocol == "socks5" {
+				return fmt.Errorf("service %s: enforcement_mode 'firewall' requires a plain tcp, udp, or both service", service.ServiceID)
+			}
+			if service.PublicStatus {
+				return fmt.Errorf("service %s: public_status requires enforcement_mode 'proxy'", service.ServiceID)
+			}
+		default:
+			return fmt.Errorf("service %s: enforcement_mode must be 'proxy' or 'firewall'", service.ServiceID)
+		}
+
+		if service.MaxConnectionsPerUser < 0 {
+			return fmt.Errorf("service %s: max_connections_per_user must be >= 0", service.ServiceID)
+		}
+		if err := validateBufferSizes(service.TCPBufferSizeBytes, service.UDPBufferSizeBytes); err != nil {
+			return fmt.Errorf("service %s: %w", service.ServiceID, err)
+		}
+		if service.BackendSourceIP != "" {
+			if service.BackendSourceInterface != "" {
+				return fmt.Errorf("service %s: set backend_source_ip or backend_source_interface, not both", service.ServiceID)
+			}
+			if _, err := netip.ParseAddr(service.BackendSourceIP); err != nil {
+				return fmt.Errorf("service %s: invalid backend_source_ip '%s'", service.ServiceID, service.BackendSourceIP)
+			}
+		}
+		switch service.ProtocolHint {
+		case "":
+		case "minecraft":
+			if service.IsHTTPProtocol || (protocol != "tcp" && protocol != "both") {
+				return fmt.Errorf("service %s: protocol_hint 'minecraft' requires a tcp or both service", service.ServiceID)
+			}
+		default:
+			return fmt.Errorf("service %s: protocol_hint must be empty or 'minecraft'", service.ServiceID)
+		}
+		if relay := service.UDPRelay; relay != nil {
+			if err := validateUDPRelay(relay, service.IsHTTPProtocol, protocol); err != nil {
+				return fmt.Errorf("service %s: %w", service.ServiceID, err)
+			}
+		}
+		if service.UDPStickySourcePort && (service.IsHTTPProtocol || (protocol != "udp" && protocol != "both")) {
+			return fmt.Errorf("service %s: udp_sticky_source_port applies to udp and both services only", service.ServiceID)
+		}
+		if limit := service.UDPDatagramLimit; limit != nil {
+			if service.IsHTTPProtocol || (protocol != "udp" && protocol != "both") {
+				return fmt.Errorf("service %s: udp_datagram_limit applies to udp and both services only", service.ServiceID)
+			}
+			if limit.MaxSizeBytes != 0 && (limit.MaxSizeBytes < minUDPDatagramLimit || limit.MaxSizeBytes > maxUDPDatagramLimit) {
+				return fmt.Errorf("service %s: udp_datagram_limit.max_size_bytes must be 0 or between %d and %d", service.ServiceID, minUDPDatagramLimit, maxUDPDatagramLimit)
+			}
+			switch limit.Oversize {
+			case "", "drop", "truncate", "log":
+			default:
+				return fmt.Errorf("service %s: udp_datagram_limit.oversize must be 'drop', 'truncate' or 'log'", service.ServiceID)
+			}
+		}
+		if wol := service.WakeOnLAN; wol != nil {
+			if protocol == "socks5" {
+				return fmt.Errorf("service %s: wake_on_lan does not apply to socks5 services", service.ServiceID)
+			}
+			if err := validateWakeOnLAN(wol); err != nil {
+				return fmt.Errorf("service %s: %w", service.ServiceID, err)
+			}
+		}
+		if lc := service.LazyContainer; lc != nil {
+			if protocol == "socks5" {
+				return fmt.Errorf("service %s: lazy_container does not apply to socks5 services", service.ServiceID)
+			}
+			if strings.TrimSpace(lc.ContainerName) == "" {
+				return fmt.Errorf("service %s: lazy_container.container_name is required", service.ServiceID)
+			}
+			if lc.StartTimeoutSeconds < 0 || lc.StartTimeoutSeconds > 600 {
+				return fmt.Errorf("service %s: lazy_container.start_timeout_seconds must be between 0 and 600", service.ServiceID)
+			}
+			if lc.IdleStopMinutes < 0 {
+				return fmt.Errorf("service %s: lazy_container.idle_stop_minutes must be >= 0", service.ServiceID)
+			}
+		}
+		if hook := service.IdleHook; hook != nil {
+			if hook.AfterMinutes < 1 {
+				return fmt.Errorf("service %s: idle_hook.after_minutes must be at least 1", service.ServiceID)
+			}
+			if hook.WebhookURL != "" {
+				if parsed, err := url.Parse(hook.WebhookURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+					return fmt.Errorf("service %s: idle_hook.webhook_url must be an absolute http(s) URL", service.ServiceID)
+				}
+			}
+		}
+		if pq := service.PublicQuery; pq != nil {
+			if service.IsHTTPProtocol || (protocol != "udp" && protocol != "both") {
+				return fmt.Errorf("service %s: public_query applies to udp and both services only", service.ServiceID)
+			}
+			if pq.MaxPacketsPerMinute < 0 {
+				return fmt.Errorf("service %s: public_query.max_packets_per_minute must be >= 0", service.ServiceID)
+			}
+			if err := validateHexPrefixes("public_query", pq.MatchHexPrefixes); err != nil {
+				return fmt.Errorf("service %s: %w", service.ServiceID, err)
+			}
+		}
+		if opts := service.SocketOptions; opts != nil {
+			if service.IsHTTPProtocol || protocol == "udp" {
+				return fmt.Errorf("service %s: socket_options apply to tcp, both and socks5 services only", service.ServiceID)
+			}
+			if opts.KeepAliveSeconds < -1 {
+				return fmt.Errorf("service %s: socket_options.keepalive_seconds must be >= -1", service.ServiceID)
+			}
+			if opts.ReceiveBufferBytes < 0 || opts.SendBufferBytes < 0 {
+				return fmt.Errorf("service %s: socket_options buffer sizes must be >= 0", service.ServiceID)
+			}
+		}
+		if t := service.TLS; t != nil {
+			if service.IsHTTPProtocol || (protocol != "tcp" && protocol != "both") {
+				return fmt.Errorf("service %s: tls applies to tcp and both services only", service.ServiceID)
+			}
+			if (t.CertFile == "") != (t.KeyFile == "") {
+				return fmt.Errorf("service %s: tls.cert_file and tls.key_file must be set together", service.ServiceID)
+			}
+			if t.MinVersion != "" && t.MinVersion != "1.2" && t.MinVersion != "1.3" {
+				return fmt.Errorf("service %s: tls.min_version must be '1.2' or '1.3'", service.ServiceID)
+			}
+			switch t.Mode {
+			case "backend":
+				if t.ClientCAFile != "" {
+					return fmt.Errorf("service %s: tls.client_ca_file applies to mode 'client' only", service.ServiceID)
+				}
+			case "client":
+				if t.CertFile == "" {
+					return fmt.Errorf("service %s: tls.cert_file and tls.key_file are required for mode 'client'", service.ServiceID)
+				}
+				if t.CAFile != "" || t.ServerName != "" || t.InsecureSkipVerify {
+					return fmt.Errorf("service %s: tls.ca_file, server_name and insecure_skip_verify apply to mode 'backend' only", service.ServiceID)
+				}
+				if service.ProtocolHint != "" {
+					return fmt.Errorf("service %s: protocol_hint can't be used with tls mode 'client'", service.ServiceID)
+				}
+			default:
+				return fmt.Errorf("service %s: tls.mode must be 'backend' or 'client'", service.ServiceID)
+			}
+		}
+		if err := validateSchedule(service.Schedule); err != nil {
+			return fmt.Errorf("service %s: invalid schedule: %w", service.ServiceID, err)
+		}
+		for _, tag := range service.Tags {
+			if tag == "" || strings.ContainsAny(tag, " ,:") {
+				return fmt.Errorf("service %s: tag '%s' must be non-empty without spaces, commas or colons", service.ServiceID, tag)
+			}
+		}
+
+		// Validate deny response
+		if deny := service.DenyResponse; deny != nil {
+			if deny.TCPCloseMode != "" && deny.TCPCloseMode != "close" && deny.TCPCloseMode != "reset" && deny.TCPCloseMode != "blackhole" {
+				return fmt.Errorf("service %s: deny_response.tcp_close_mode must be 'close', 'reset' or 'blackhole'", service.ServiceID)
+			}
+			if deny.UDPMode != "" && deny.UDPMode != "drop" && deny.UDPMode != "unreachable" {
+				return fmt.Errorf("service %s: deny_response.udp_mode must be 'drop' or 'unreachable'", service.ServiceID)
+			}
+			if deny.HTMLPageFile != "" {
+				if _, err := os.Stat(deny.HTMLPageFile); err != nil {
+					return fmt.Errorf("service %s: deny_response.html_page_file: %w", service.ServiceID, err)
+				}
+			}
+		}
+
+		if cb := service.CircuitBreaker; cb != nil {
+			if cb.MaxFailures < 0 || cb.OpenTimeoutSeconds < 0 || cb.HalfOpenSuccesses < 0 || cb.UDPResponseTimeoutSeconds < 0 {
+				return fmt.Errorf("service %s: circuit_breaker values must be >= 0", service.ServiceID)
+			}
+		}
+
+		// Validate HTTP limits
+		if hc := service.HTTPConfig; hc != nil {
+			if hc.MaxRequestBodyBytes < 0 || hc.MaxResponseBodyBytes < 0 || hc.MaxRequestHeaderBytes < 0 {
+				return fmt.Errorf("service %s: http_config size limits must be >= 0", service.ServiceID)
+			}
+			if hc.HeaderTimeoutSeconds < 0 || hc.ResponseTimeoutSeconds < 0 || hc.IdleConnTimeoutSeconds < 0 {
+				return fmt.Errorf("service %s: http_config timeouts must be >= 0", service.ServiceID)
+			}
+			if hc.MaxIdleConns < 0 || hc.MaxIdleConnsPerHost < 0 || hc.PrewarmConnections < 0 {
+				return fmt.Errorf("service %s: http_config connection pool sizes must be >= 0", service.ServiceID)
+			}
+			if hc.PrewarmConnections > 0 && hc.DisableKeepAlives {
+				return fmt.Errorf("service %s: http_config.prewarm_connections requires keep-alives", service.ServiceID)
+			}
+			if hc.AccessLogFormat != "" && hc.AccessLogFormat != "combined" && hc.AccessLogFormat != "common" {
+				return fmt.Errorf("service %s: http_config.access_log_format must be 'combined' or 'common'", service.ServiceID)
+			}
+		}
+
+		// Validate HTTP/3 listener
+		if service.HTTPConfig != nil && service.HTTPConfig.EnableHTTP3 {
+			if !service.IsHTTPProtocol {
+				return fmt.Errorf("service %s: http_config.enable_http3 requires is_http_protocol", service.ServiceID)
+			}
+			if service.HTTPConfig.TLSCertFile == "" || service.HTTPConfig.TLSKeyFile == "" {
+				return fmt.Errorf("service %s: http_config.tls_cert_file and tls_key_file are required for HTTP/3", service.ServiceID)
+			}
+		}
+
+		if err := validateForwardAuthHeaders("service "+service.ServiceID+": forward_auth_response_headers", service.ForwardAuthResponseHeaders); err != nil {
+			return err
 		}
 	}
 
@@ -104,7 +586,200 @@ func ValidateConfig(cfg *ApplicationConfig) error {
 	if err := checkPortConflicts(cfg.ProtectedServices); err != nil {
 		return err
 	}
+	if err := checkAdminPort(cfg); err != nil {
+		return err
+	}
+
+	// Validate login CAPTCHA
+	if cc := cfg.CaptchaConfig; cc.Enabled {
+		if cc.Provider != "turnstile" && cc.Provider != "hcaptcha" {
+			return fmt.Errorf("captcha_config.provider must be 'turnstile' or 'hcaptcha'")
+		}
+		if cc.SiteKey == "" || cc.SecretKey == "" {
+			return fmt.Errorf("captcha_config.site_key and secret_key are required when enabled")
+		}
+		if cc.RequireAfterFailedAttempts < 0 {
+			return fmt.Errorf("captcha_config.require_after_failed_attempts must be >= 0")
+		}
+	}
+
+	if err := validateServiceGroups(cfg); err != nil {
+		return err
+	}
+	if err := validateUserGroups(cfg); err != nil {
+		return err
+	}
+
+	if err := validateAlerts(cfg); err != nil {
+		return err
+	}
 
+	// Validate honeypot ports
+	if hp := cfg.HoneypotConfig; hp.Enabled {
+		if len(hp.TCPPorts) == 0 && len(hp.UDPPorts) == 0 {
+			return fmt.Errorf("honeypot_config requires at least one tcp_ports or udp_ports entry when enabled")
+		}
+		if hp.BlockDurationSeconds < 0 {
+			return fmt.Errorf("honeypot_config.block_duration_seconds must be >= 0")
+		}
+		if err := checkHoneypotPorts(cfg, "tcp", hp.TCPPorts); err != nil {
+			return err
+		}
+		if err := checkHoneypotPorts(cfg, "udp", hp.UDPPorts); err != nil {
+			return err
+		}
+	}
+
+	if bf := cfg.BruteForceConfig; bf.WindowSeconds < 0 || bf.MaxFailuresPerIP < 0 || bf.MaxFailuresPerUsername < 0 || bf.BlockDurationSeconds < 0 || bf.LockoutSeconds < 0 {
+		return fmt.Errorf("brute_force_config values must be >= 0")
+	}
+
+	return nil
+}
+
+// HasFirewallServices reports whether any enabled service is enforced by the kernel firewall
+func HasFirewallServices(cfg *ApplicationConfig) bool {
+	for _, service := range cfg.ProtectedServices {
+		if service.Enabled && service.EnforcementMode == "firewall" {
+			return true
+		}
+	}
+	return false
+}
+
+// TagSelectorPrefix marks an allowed_service_ids entry that grants every service carrying a tag, e.g. "tag:family"
+const TagSelectorPrefix = "tag:"
+
+// ServiceAllowed reports whether an allowed_service_ids list grants a service (empty = all services)
+// Tag selectors are evaluated against the service's current tags, so tagging a service grants it to existing sessions
+func ServiceAllowed(allowedServiceIDs []string, serviceID string, serviceTags []string) bool {
+	if len(allowedServiceIDs) == 0 {
+		return true
+	}
+	for _, allowed := range allowedServiceIDs {
+		if allowed == serviceID {
+			return true
+		}
+		if tag, ok := strings.CutPrefix(allowed, TagSelectorPrefix); ok && slices.Contains(serviceTags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandServiceGroups replaces service group names in a list of service IDs with their members
+// Order is kept and duplicates are dropped; unknown names stay as they are, so a removed group grants nothing
+// rather than turning the list empty (which would grant every service)
+func ExpandServiceGroups(groups map[string][]string, serviceIDs []string) []string {
+	if len(groups) == 0 || len(serviceIDs) == 0 {
+		return serviceIDs
+	}
+	expanded := make([]string, 0, len(serviceIDs))
+	seen := make(map[string]bool, len(serviceIDs))
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			expanded = append(expanded, id)
+		}
+	}
+	for _, id := range serviceIDs {
+		if members, ok := groups[id]; ok {
+			for _, member := range members {
+				add(member)
+			}
+			continue
+		}
+		add(id)
+	}
+	return expanded
+}
+
+// validateServiceGroups checks that group names don't shadow services and groups aren't empty or nested
+func validateServiceGroups(cfg *ApplicationConfig) error {
+	serviceIDs := make(map[string]bool, len(cfg.ProtectedServices))
+	for _, service := range cfg.ProtectedServices {
+		serviceIDs[service.ServiceID] = true
+	}
+	for name, members := range cfg.ServiceGroups {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("service_groups: group name is required")
+		}
+		if serviceIDs[name] {
+			return fmt.Errorf("service_groups.%s: name is already a service_id", name)
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("service_groups.%s: at least one service_id is required", name)
+		}
+		for _, member := range members {
+			if _, nested := cfg.ServiceGroups[member]; nested {
+				return fmt.Errorf("service_groups.%s: groups cannot contain other groups (%s)", name, member)
+			}
+		}
+	}
+	return nil
+}
+
+// validateAlerts checks alert rules and notification channels
+func validateAlerts(cfg *ApplicationConfig) error {
+	ac := cfg.AlertsConfig
+	if !ac.Enabled {
+		return nil
+	}
+	if ac.CheckIntervalSeconds < 0 {
+		return fmt.Errorf("alerts_config.check_interval_seconds must be >= 0")
+	}
+	if ac.WebhookURL != "" {
+		parsed, err := url.Parse(ac.WebhookURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("alerts_config.webhook_url must be an absolute http(s) URL")
+		}
+	}
+	if len(ac.Telegram.ChatIDs) > 0 && ac.Telegram.BotToken == "" && cfg.ApprovalConfig.Telegram.BotToken == "" {
+		return fmt.Errorf("alerts_config.telegram.bot_token is required (or set approval_config.telegram.bot_token)")
+	}
+	if email := ac.Email; email.SMTPHost != "" {
+		if email.From == "" || len(email.To) == 0 {
+			return fmt.Errorf("alerts_config.email.from and to are required with smtp_host")
+		}
+		if email.SMTPPort < 0 || email.SMTPPort > 65535 {
+			return fmt.Errorf("alerts_config.email.smtp_port must be between 0 and 65535")
+		}
+	}
+	if ac.WebhookURL == "" && len(ac.Telegram.ChatIDs) == 0 && ac.Email.SMTPHost == "" {
+		return fmt.Errorf("alerts_config requires webhook_url, telegram.chat_ids or email.smtp_host when enabled")
+	}
+
+	serviceIDs := make(map[string]bool, len(cfg.ProtectedServices))
+	for _, service := range cfg.ProtectedServices {
+		serviceIDs[service.ServiceID] = true
+	}
+	names := make(map[string]bool, len(ac.Rules))
+	for i, rule := range ac.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("alerts_config.rules %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("alerts_config.rules %s: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+		switch rule.Metric {
+		case "active_connections", "bytes_per_minute", "failed_logins", "circuit_open_seconds":
+		default:
+			return fmt.Errorf("alerts_config.rules %s: metric must be 'active_connections', 'bytes_per_minute', 'failed_logins' or 'circuit_open_seconds'", rule.Name)
+		}
+		if rule.ServiceID != "" && !serviceIDs[rule.ServiceID] {
+			return fmt.Errorf("alerts_config.rules %s: unknown service_id '%s'", rule.Name, rule.ServiceID)
+		}
+		if rule.Threshold < 0 || rule.WindowMinutes < 0 || rule.CooldownMinutes < 0 {
+			return fmt.Errorf("alerts_config.rules %s: threshold, window_minutes and cooldown_minutes must be >= 0", rule.Name)
+		}
+		if rule.Metric == "bytes_per_minute" && !cfg.StatsHistoryConfig.Enabled {
+			return fmt.Errorf("alerts_config.rules %s: bytes_per_minute requires stats_history_config.enabled", rule.Name)
+		}
+		if rule.WindowMinutes > 24*60 {
+			return fmt.Errorf("alerts_config.rules %s: window_minutes must be at most 1440", rule.Name)
+		}
+	}
 	return nil
 }
 
@@ -117,8 +792,17 @@ func checkPortConflicts(services []ProtectedServiceConfig) error {
 			continue
 		}
 
+		ports := []int{}
 		for port := service.ProxyListenPortStart; port <= service.ProxyListenPortEnd; port++ {
-			if existingServiceID, exists := portMap[port]; exists {
+			ports = append(ports, port)
+		}
+		for _, l := range service.AdditionalListeners {
+			ports = append(ports, l.Port)
+		}
+
+		for _, port := range ports {
+			// A service may bind one port on several addresses
+			if existingServiceID, exists := portMap[port]; exists && existingServiceID != service.ServiceID {
 				return fmt.Errorf("port conflict: port %d is used by both service %s and %s",
 					port, existingServiceID, service.ServiceID)
 			}
@@ -128,3 +812,347 @@ func checkPortConflicts(services []ProtectedServiceConfig) error {
 
 	return nil
 }
+
+// validateListeners checks the listen address and the additional listeners of a service
+func validateListeners(service ProtectedServiceConfig) error {
+	if service.ProxyListenAddress != "" {
+		if _, err := netip.ParseAddr(service.ProxyListenAddress); err != nil {
+			return fmt.Errorf("proxy_listen_address must be an IP address such as 0.0.0.0 or ::")
+		}
+	}
+	if len(service.AdditionalListeners) == 0 {
+		return nil
+	}
+	if service.EnforcementMode == "firewall" {
+		return fmt.Errorf("additional_listeners do not apply to firewall enforcement")
+	}
+
+	// Addresses bound per port; an empty address takes the port on all addresses
+	bound := map[int][]string{service.ProxyListenPortStart: {service.ProxyListenAddress}}
+	for i, l := range service.AdditionalListeners {
+		if l.Port < 1 || l.Port > 65535 {
+			return fmt.Errorf("additional_listeners[%d]: invalid port %d", i, l.Port)
+		}
+		if l.Address != "" {
+			if _, err := netip.ParseAddr(l.Address); err != nil {
+				return fmt.Errorf("additional_listeners[%d]: address must be an IP address such as 0.0.0.0 or ::", i)
+			}
+		}
+		for _, address := range bound[l.Port] {
+			if address == l.Address || address == "" || l.Address == "" {
+				return fmt.Errorf("additional_listeners[%d]: port %d is already bound on %s", i, l.Port, listenAddressName(address, l.Address))
+			}
+		}
+		bound[l.Port] = append(bound[l.Port], l.Address)
+	}
+	return nil
+}
+
+// servicePort reports whether a service listens on a port, with its port range or an additional listener
+func servicePort(service ProtectedServiceConfig, port int) bool {
+	if port >= service.ProxyListenPortStart && port <= service.ProxyListenPortEnd {
+		return true
+	}
+	for _, l := range service.AdditionalListeners {
+		if l.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+// listenAddressName names the addresses two listeners on one port share, for error messages
+func listenAddressName(a, b string) string {
+	if a == "" || b == "" {
+		return "all addresses"
+	}
+	return a
+}
+
+// validateLoginRateLimit checks the rate limit of one login endpoint
+func validateLoginRateLimit(name string, rl LoginRateLimitConfig) error {
+	if rl.RequestsPerMinute < 0 || rl.Burst < 0 || rl.MaxTrackedIPs < 0 || rl.ReducedAfterFailures < 0 || rl.SevereAfterFailures < 0 || rl.IdleSeconds < 0 {
+		return fmt.Errorf("login_rate_limits.%s values must be >= 0", name)
+	}
+	if rl.ReducedAfterFailures > 0 && rl.SevereAfterFailures > 0 && rl.SevereAfterFailures < rl.ReducedAfterFailures {
+		return fmt.Errorf("login_rate_limits.%s.severe_after_failures must be >= reduced_after_failures", name)
+	}
+	return nil
+}
+
+// checkHoneypotPorts ensures decoy ports are valid and don't collide with the admin API or a service
+func checkHoneypotPorts(cfg *ApplicationConfig, network string, ports []int) error {
+	seen := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("honeypot_config.%s_ports: invalid port %d", network, port)
+		}
+		if seen[port] {
+			return fmt.Errorf("honeypot_config.%s_ports: duplicate port %d", network, port)
+		}
+		seen[port] = true
+
+		if network == "tcp" && port == cfg.ProxyServerConfig.AdminAPIPort {
+			return fmt.Errorf("honeypot_config.tcp_ports: port %d is the admin API port", port)
+		}
+		for _, service := range cfg.ProtectedServices {
+			if !service.Enabled || !servicePort(service, port) {
+				continue
+			}
+			protocol := strings.ToLower(service.TransportProtocol)
+			if protocol == "both" || (network == "udp") == (protocol == "udp") {
+				return fmt.Errorf("honeypot_config.%s_ports: port %d is used by service %s", network, port, service.ServiceID)
+			}
+		}
+	}
+	return nil
+}
+
+// syslogFacilities lists the facility names accepted in logging_config.syslog.facility
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// validateUDPRelay checks the discovery relay of a service
+func validateUDPRelay(relay *UDPRelayConfig, isHTTP bool, protocol string) error {
+	if isHTTP || (protocol != "udp" && protocol != "both") {
+		return fmt.Errorf("udp_relay applies to udp and both services only")
+	}
+	addr, err := netip.ParseAddr(relay.TargetAddress)
+	if err != nil {
+		return fmt.Errorf("udp_relay.target_address must be a broadcast or multicast IP address")
+	}
+	if addr.Is6() && !addr.IsMulticast() {
+		return fmt.Errorf("udp_relay.target_address must be a multicast group for IPv6 (IPv6 has no broadcast)")
+	}
+	if relay.TargetPort < 0 || relay.TargetPort > 65535 {
+		return fmt.Errorf("udp_relay.target_port must be between 0 and 65535")
+	}
+	if relay.ReplyWindowSeconds < 0 || relay.ReplyWindowSeconds > 30 {
+		return fmt.Errorf("udp_relay.reply_window_seconds must be between 0 and 30")
+	}
+	return validateHexPrefixes("udp_relay", relay.MatchHexPrefixes)
+}
+
+// validateWakeOnLAN checks the Wake-on-LAN settings of a service
+func validateWakeOnLAN(wol *WakeOnLANConfig) error {
+	mac, err := net.ParseMAC(wol.MACAddress)
+	if err != nil || len(mac) != 6 {
+		return fmt.Errorf("wake_on_lan.mac_address must be a 6-byte MAC address like 00:11:22:33:44:55")
+	}
+	if wol.BroadcastAddress != "" {
+		if addr, err := netip.ParseAddr(wol.BroadcastAddress); err != nil || !addr.Is4() {
+			return fmt.Errorf("wake_on_lan.broadcast_address must be an IPv4 address")
+		}
+	}
+	if wol.Port < 0 || wol.Port > 65535 {
+		return fmt.Errorf("wake_on_lan.port must be between 0 and 65535")
+	}
+	if wol.WaitSeconds < 0 || wol.WaitSeconds > 600 {
+		return fmt.Errorf("wake_on_lan.wait_seconds must be between 0 and 600")
+	}
+	return nil
+}
+
+// validateHexPrefixes checks the match_hex_prefixes of a packet filter
+func validateHexPrefixes(field string, prefixes []string) error {
+	for _, prefix := range prefixes {
+		if b, err := hex.DecodeString(prefix); err != nil || len(b) == 0 {
+			return fmt.Errorf("%s.match_hex_prefixes entry '%s' must be non-empty hex", field, prefix)
+		}
+	}
+	return nil
+}
+
+// Bounds of the proxy buffer sizes; 0 selects the default
+const (
+	minTCPBufferSize = 1024
+	maxTCPBufferSize = 4 << 20
+	minUDPBufferSize = 512
+	maxUDPBufferSize = 65535
+
+	// From the minimum IPv4 MTU up to the largest UDP payload over IPv4
+	minUDPDatagramLimit = 68
+	maxUDPDatagramLimit = 65507
+)
+
+// lanBlocks are the address blocks never routed on the internet, which hairpinned LAN clients come from
+var lanBlocks = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// validateLANRange checks that an IP or CIDR range lies within private, link-local or loopback address space
+func validateLANRange(ipRange string) error {
+	prefix, err := netip.ParsePrefix(ipRange)
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(ipRange)
+		if addrErr != nil {
+			return fmt.Errorf("invalid IP range '%s': %w", ipRange, err)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	prefix = prefix.Masked()
+	for _, block := range lanBlocks {
+		if block.Bits() <= prefix.Bits() && block.Contains(prefix.Addr()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is not a private, link-local or loopback range", ipRange)
+}
+
+// validateBufferSizes checks TCP and UDP buffer sizes
+func validateBufferSizes(tcp, udp int) error {
+	if tcp != 0 && (tcp < minTCPBufferSize || tcp > maxTCPBufferSize) {
+		return fmt.Errorf("tcp_buffer_size_bytes must be 0 or between %d and %d", minTCPBufferSize, maxTCPBufferSize)
+	}
+	if udp != 0 && (udp < minUDPBufferSize || udp > maxUDPBufferSize) {
+		return fmt.Errorf("udp_buffer_size_bytes must be 0 or between %d and %d", minUDPBufferSize, maxUDPBufferSize)
+	}
+	return nil
+}
+
+// validateLogging checks the log output target
+func validateLogging(lc LoggingConfiguration) error {
+	for module, level := range lc.Levels {
+		if module == "" {
+			return fmt.Errorf("logging_config.levels: module name must not be empty")
+		}
+		if _, err := zerolog.ParseLevel(strings.ToLower(level)); err != nil || level == "" {
+			return fmt.Errorf("logging_config.levels.%s must be trace, debug, info, warn, error, fatal, panic or disabled", module)
+		}
+	}
+
+	switch lc.Output {
+	case "", "stderr":
+		return nil
+	case "file":
+		if lc.File.Path == "" {
+			return fmt.Errorf("logging_config.file.path is required when output is 'file'")
+		}
+		if lc.File.MaxSizeMB < 0 || lc.File.MaxBackups < 0 || lc.File.MaxAgeDays < 0 {
+			return fmt.Errorf("logging_config.file max_size_mb, max_backups and max_age_days must be >= 0")
+		}
+		return nil
+	case "syslog":
+	default:
+		return fmt.Errorf("logging_config.output must be 'stderr', 'syslog' or 'file'")
+	}
+
+	if lc.Syslog.Address != "" {
+		u, err := url.Parse(lc.Syslog.Address)
+		if err != nil || u.Host == "" || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "tls") {
+			return fmt.Errorf("logging_config.syslog.address must be udp://host:port, tcp://host:port or tls://host:port")
+		}
+		if u.Port() == "" {
+			return fmt.Errorf("logging_config.syslog.address must include a port")
+		}
+	}
+	if lc.Syslog.Facility != "" && !slices.Contains(syslogFacilities, lc.Syslog.Facility) {
+		return fmt.Errorf("logging_config.syslog.facility must be one of %s", strings.Join(syslogFacilities, ", "))
+	}
+	if len(lc.Syslog.AppName) > 48 {
+		return fmt.Errorf("logging_config.syslog.app_name must be at most 48 characters")
+	}
+	return nil
+}
+
+// validateSOCKS5Destination checks the host:port syntax of a SOCKS5 destination rule
+func validateSOCKS5Destination(destination string) error {
+	host, port, err := net.SplitHostPort(destination)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if strings.Contains(host, "/") {
+		if _, err := netip.ParsePrefix(host); err != nil {
+			return err
+		}
+	}
+
+	if port == "*" {
+		return nil
+	}
+	low, high, isRange := strings.Cut(port, "-")
+	if !isRange {
+		high = low
+	}
+	lowPort, errLow := strconv.Atoi(low)
+	highPort, errHigh := strconv.Atoi(high)
+	if errLow != nil || errHigh != nil || lowPort < 1 || highPort > 65535 || lowPort > highPort {
+		return fmt.Errorf("port must be a number, a range like 1000-2000, or *")
+	}
+	return nil
+}
+
+// validateHeaderNames ensures configured header names are valid HTTP field names
+func validateHeaderNames(field string, headers map[string]string) error {
+	for name := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("%s: invalid header name '%s'", field, name)
+		}
+	}
+	return nil
+}
+
+// validateForwardAuthHeaders checks the header names and rejects templates that would leak the session ID
+func validateForwardAuthHeaders(field string, headers map[string]string) error {
+	if err := validateHeaderNames(field, headers); err != nil {
+		return err
+	}
+	for name, value := range headers {
+		if strings.Contains(value, "{session_id}") {
+			return fmt.Errorf("%s: header '%s' uses {session_id}, which is no longer supported; use {user_id} to identify the caller", field, name)
+		}
+	}
+	return nil
+}
+
+// validateSchedule checks the timezone, days and HH:MM times of an access schedule
+func validateSchedule(schedule *AccessScheduleConfig) error {
+	if schedule == nil {
+		return nil
+	}
+	if schedule.Timezone != "" {
+		if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone '%s'", schedule.Timezone)
+		}
+	}
+	if len(schedule.Windows) == 0 {
+		return fmt.Errorf("at least one window is required")
+	}
+
+	validDays := map[string]bool{"mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true, "sun": true}
+	for i, window := range schedule.Windows {
+		for _, day := range window.Days {
+			if !validDays[strings.ToLower(day)] {
+				return fmt.Errorf("window %d: unknown day '%s'", i, day)
+			}
+		}
+		for _, value := range []string{window.StartTime, window.EndTime} {
+			if _, err := time.Parse("15:04", value); err != nil && value != "24:00" {
+				return fmt.Errorf("window %d: time '%s' must be HH:MM", i, value)
+			}
+		}
+		if window.StartTime == window.EndTime {
+			return fmt.Errorf("window %d: start_time and end_time must differ", i)
+		}
+	}
+	return nil
+}
+
+// isBcryptHash reports whether a stored password looks like a bcrypt hash
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
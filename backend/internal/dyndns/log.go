@@ -0,0 +1,6 @@
+package dyndns
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["dyndns"] overrides its level
+var log = logging.Module("dyndns")
@@ -0,0 +1,253 @@
+package dyndns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// provider pushes the current public addresses to a DNS service
+// Empty ipv4/ipv6 values mean the family is not being updated
+type provider interface {
+	Name() string
+	Update(ctx context.Context, ipv4, ipv6 string) error
+}
+
+// newProvider creates the provider selected in configuration
+func newProvider(cfg *config.DynamicDNSConfiguration, client *http.Client) (provider, error) {
+	switch cfg.Provider {
+	case "cloudflare":
+		return &cloudflareProvider{cfg: *cfg, client: client}, nil
+	case "duckdns":
+		return &duckDNSProvider{cfg: *cfg, client: client}, nil
+	case "http":
+		return &httpProvider{cfg: *cfg, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown dynamic DNS provider '%s'", cfg.Provider)
+	}
+}
+
+// duckDNSProvider updates a DuckDNS subdomain
+type duckDNSProvider struct {
+	cfg    config.DynamicDNSConfiguration
+	client *http.Client
+}
+
+func (p *duckDNSProvider) Name() string {
+	return "duckdns"
+}
+
+func (p *duckDNSProvider) Update(ctx context.Context, ipv4, ipv6 string) error {
+	domain := strings.TrimSuffix(p.cfg.Hostname, ".duckdns.org")
+
+	query := url.Values{}
+	query.Set("domains", domain)
+	query.Set("token", p.cfg.APIToken)
+	if ipv4 != "" {
+		query.Set("ip", ipv4)
+	}
+	if ipv6 != "" {
+		query.Set("ipv6", ipv6)
+	}
+
+	body, err := doRequest(ctx, p.client, http.MethodGet, "https://www.duckdns.org/update?"+query.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(strings.TrimSpace(string(body)), "OK") {
+		return fmt.Errorf("DuckDNS rejected update: %s", strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// httpProvider calls a user-supplied update URL (dyndns2-style endpoints and similar)
+type httpProvider struct {
+	cfg    config.DynamicDNSConfiguration
+	client *http.Client
+}
+
+func (p *httpProvider) Name() string {
+	return "http"
+}
+
+func (p *httpProvider) Update(ctx context.Context, ipv4, ipv6 string) error {
+	updateURL := strings.NewReplacer(
+		"{hostname}", url.QueryEscape(p.cfg.Hostname),
+		"{ipv4}", url.QueryEscape(ipv4),
+		"{ipv6}", url.QueryEscape(ipv6),
+	).Replace(p.cfg.UpdateURL)
+
+	headers := map[string]string{}
+	if p.cfg.Username != "" {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+		headers["Authorization"] = req.Header.Get("Authorization")
+	}
+
+	body, err := doRequest(ctx, p.client, http.MethodGet, updateURL, headers, nil)
+	if err != nil {
+		return err
+	}
+
+	// dyndns2 endpoints return 200 with an error keyword in the body
+	response := strings.TrimSpace(string(body))
+	for _, keyword := range []string{"badauth", "notfqdn", "nohost", "numhost", "abuse", "badagent", "dnserr", "911"} {
+		if strings.HasPrefix(response, keyword) {
+			return fmt.Errorf("update endpoint rejected update: %s", response)
+		}
+	}
+	return nil
+}
+
+// cloudflareProvider updates A/AAAA records through the Cloudflare v4 API
+type cloudflareProvider struct {
+	cfg    config.DynamicDNSConfiguration
+	client *http.Client
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+type cloudflareResponse struct {
+	Success bool               `json:"success"`
+	Errors  []json.RawMessage  `json:"errors"`
+	Result  []cloudflareRecord `json:"result"`
+}
+
+func (p *cloudflareProvider) Name() string {
+	return "cloudflare"
+}
+
+func (p *cloudflareProvider) Update(ctx context.Context, ipv4, ipv6 string) error {
+	if ipv4 != "" {
+		if err := p.upsert(ctx, "A", ipv4); err != nil {
+			return err
+		}
+	}
+	if ipv6 != "" {
+		if err := p.upsert(ctx, "AAAA", ipv6); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsert updates the existing record of the given type or creates it
+func (p *cloudflareProvider) upsert(ctx context.Context, recordType, content string) error {
+	baseURL := "https://api.cloudflare.com/client/v4/zones/" + url.PathEscape(p.cfg.CloudflareZoneID) + "/dns_records"
+	headers := map[string]string{
+		"Authorization": "Bearer " + p.cfg.APIToken,
+		"Content-Type":  "application/json",
+	}
+
+	query := url.Values{}
+	query.Set("type", recordType)
+	query.Set("name", p.cfg.Hostname)
+
+	body, err := doRequest(ctx, p.client, http.MethodGet, baseURL+"?"+query.Encode(), headers, nil)
+	if err != nil {
+		return err
+	}
+
+	var existing cloudflareResponse
+	if err := json.Unmarshal(body, &existing); err != nil {
+		return fmt.Errorf("failed to parse Cloudflare response: %w", err)
+	}
+	if !existing.Success {
+		return fmt.Errorf("Cloudflare record lookup failed: %s", joinRaw(existing.Errors))
+	}
+
+	record := cloudflareRecord{
+		Type:    recordType,
+		Name:    p.cfg.Hostname,
+		Content: content,
+		TTL:     1, // automatic
+		Proxied: p.cfg.CloudflareProxied,
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	method, target := http.MethodPost, baseURL
+	if len(existing.Result) > 0 {
+		if existing.Result[0].Content == content && existing.Result[0].Proxied == p.cfg.CloudflareProxied {
+			return nil // Already up to date
+		}
+		method, target = http.MethodPut, baseURL+"/"+url.PathEscape(existing.Result[0].ID)
+	}
+
+	body, err = doRequest(ctx, p.client, method, target, headers, payload)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Success bool              `json:"success"`
+		Errors  []json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("failed to parse Cloudflare response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("Cloudflare record update failed: %s", joinRaw(result.Errors))
+	}
+	return nil
+}
+
+// doRequest performs an HTTP request and returns the body of a 2xx response
+func doRequest(ctx context.Context, client *http.Client, method, target string, headers map[string]string, payload []byte) ([]byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("User-Agent", "knock-knock-portal")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", req.URL.Host, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s returned status %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// joinRaw renders API error objects for log output
+func joinRaw(items []json.RawMessage) string {
+	parts := make([]string, 0, len(items))
+	for _, item := range items {
+		parts = append(parts, string(item))
+	}
+	return strings.Join(parts, ", ")
+}
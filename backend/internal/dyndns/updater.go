@@ -0,0 +1,237 @@
+package dyndns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// Updater keeps the portal's public hostname pointed at its current public IP
+type Updater struct {
+	mu          sync.Mutex
+	cfg         config.DynamicDNSConfiguration
+	provider    provider
+	httpClient  *http.Client
+	lastIPv4    string
+	lastIPv6    string
+	lastUpdate  time.Time
+	lastCheck   time.Time
+	lastError   string
+	stopChan    chan struct{}
+	stoppedChan chan struct{}
+}
+
+// NewUpdater creates a new dynamic DNS updater and starts it if enabled
+func NewUpdater(cfg *config.DynamicDNSConfiguration) *Updater {
+	u := &Updater{
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+
+	u.Reload(cfg)
+	return u
+}
+
+// Reload applies a new configuration, restarting the update loop
+func (u *Updater) Reload(cfg *config.DynamicDNSConfiguration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.stop()
+
+	u.cfg = *cfg
+	u.provider = nil
+	// Force an update with the new settings
+	u.lastIPv4 = ""
+	u.lastIPv6 = ""
+
+	if !cfg.Enabled {
+		return
+	}
+
+	p, err := newProvider(cfg, u.httpClient)
+	if err != nil {
+		u.lastError = err.Error()
+		log.Error().Err(err).Msg("Failed to initialize dynamic DNS provider")
+		return
+	}
+	u.provider = p
+
+	u.stopChan = make(chan struct{})
+	u.stoppedChan = make(chan struct{})
+	go u.run(u.stopChan, u.stoppedChan, time.Duration(cfg.UpdateIntervalSeconds)*time.Second)
+
+	log.Info().
+		Str("provider", p.Name()).
+		Str("hostname", cfg.Hostname).
+		Int("interval_seconds", cfg.UpdateIntervalSeconds).
+		Msg("Dynamic DNS updater started")
+}
+
+// run checks the public IP immediately and then on every interval
+func (u *Updater) run(stop, stopped chan struct{}, interval time.Duration) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		u.check(stop)
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check detects the public addresses and pushes them if they changed
+func (u *Updater) check(stop chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	u.mu.Lock()
+	cfg := u.cfg
+	p := u.provider
+	lastIPv4, lastIPv6 := u.lastIPv4, u.lastIPv6
+	u.mu.Unlock()
+
+	ipv4, ipv6, err := u.detect(ctx, &cfg)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.lastCheck = time.Now()
+	if err != nil {
+		u.lastError = err.Error()
+		log.Warn().Err(err).Msg("Dynamic DNS public IP detection failed")
+		return
+	}
+
+	if ipv4 == lastIPv4 && ipv6 == lastIPv6 {
+		u.lastError = ""
+		return
+	}
+
+	// The lock is held across the provider call so Reload waits for in-flight updates
+	if err := p.Update(ctx, ipv4, ipv6); err != nil {
+		u.lastError = err.Error()
+		log.Error().
+			Err(err).
+			Str("provider", p.Name()).
+			Str("hostname", cfg.Hostname).
+			Msg("Dynamic DNS update failed")
+		return
+	}
+
+	u.lastIPv4 = ipv4
+	u.lastIPv6 = ipv6
+	u.lastUpdate = time.Now()
+	u.lastError = ""
+
+	log.Info().
+		Str("provider", p.Name()).
+		Str("hostname", cfg.Hostname).
+		Str("ipv4", ipv4).
+		Str("ipv6", ipv6).
+		Msg("Dynamic DNS record updated")
+}
+
+// detect looks up the current public addresses for the enabled families
+func (u *Updater) detect(ctx context.Context, cfg *config.DynamicDNSConfiguration) (string, string, error) {
+	var ipv4, ipv6 string
+	var err error
+
+	if cfg.IPv4DetectionURL != "" {
+		if ipv4, err = u.fetchIP(ctx, cfg.IPv4DetectionURL, false); err != nil {
+			return "", "", err
+		}
+	}
+	if cfg.IPv6DetectionURL != "" {
+		if ipv6, err = u.fetchIP(ctx, cfg.IPv6DetectionURL, true); err != nil {
+			return "", "", err
+		}
+	}
+
+	return ipv4, ipv6, nil
+}
+
+// fetchIP queries a plain-text "what is my IP" endpoint
+func (u *Updater) fetchIP(ctx context.Context, detectionURL string, wantIPv6 bool) (string, error) {
+	body, err := doRequest(ctx, u.httpClient, http.MethodGet, detectionURL, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", fmt.Errorf("IP detection endpoint %s returned an invalid address", detectionURL)
+	}
+	if isIPv6 := ip.To4() == nil; isIPv6 != wantIPv6 {
+		return "", fmt.Errorf("IP detection endpoint %s returned an address of the wrong family: %s", detectionURL, ip)
+	}
+
+	return ip.String(), nil
+}
+
+// stop halts the update loop; must be called with mu held
+func (u *Updater) stop() {
+	if u.stopChan == nil {
+		return
+	}
+
+	close(u.stopChan)
+	stopped := u.stoppedChan
+	u.stopChan = nil
+	u.stoppedChan = nil
+
+	// Release the lock so an in-progress check can finish
+	u.mu.Unlock()
+	<-stopped
+	u.mu.Lock()
+}
+
+// Close stops the updater
+func (u *Updater) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.stop()
+}
+
+// GetStats returns the updater status
+func (u *Updater) GetStats() map[string]interface{} {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"enabled":  u.cfg.Enabled,
+		"provider": u.cfg.Provider,
+		"hostname": u.cfg.Hostname,
+		"ipv4":     u.lastIPv4,
+		"ipv6":     u.lastIPv6,
+	}
+	if !u.lastUpdate.IsZero() {
+		stats["last_update"] = u.lastUpdate
+	}
+	if !u.lastCheck.IsZero() {
+		stats["last_check"] = u.lastCheck
+	}
+	if u.lastError != "" {
+		stats["last_error"] = u.lastError
+	}
+	return stats
+}
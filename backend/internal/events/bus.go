@@ -0,0 +1,121 @@
+// Package events fans out portal events to live admin subscribers and webhooks.
+//
+// Publishers call Publish with an event type such as "approval.requested" and a JSON-encodable
+// payload. Subscribers (the admin event stream) receive every event on a buffered channel;
+// a subscriber that falls behind misses events rather than blocking the publisher.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event is a single notification
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Bus delivers published events to all subscribers
+type Bus struct {
+	subscribers map[chan Event]struct{}
+	mu          sync.RWMutex
+	seq         uint64
+	dropped     int64
+}
+
+// NewBus creates an event bus
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish sends an event to all subscribers without blocking
+// Returns the published event so callers can forward it (e.g. to a webhook)
+func (b *Bus) Publish(eventType string, data interface{}) Event {
+	event := Event{
+		ID:   atomic.AddUint64(&b.seq, 1),
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			atomic.AddInt64(&b.dropped, 1)
+		}
+	}
+	return event
+}
+
+// Subscribe registers a subscriber; call the returned function to unsubscribe
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// GetStats returns subscriber statistics
+func (b *Bus) GetStats() map[string]interface{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return map[string]interface{}{
+		"subscribers": len(b.subscribers),
+		"published":   atomic.LoadUint64(&b.seq),
+		"dropped":     atomic.LoadInt64(&b.dropped),
+	}
+}
+
+// webhookClient is shared by all webhook deliveries
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// SendWebhook POSTs an event as JSON to url
+func SendWebhook(ctx context.Context, url string, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "knock-knock-portal")
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// iptablesBackend programs dedicated chains through iptables-restore / ip6tables-restore
+// The chains are rebuilt on every apply; jumps from the built-in chains are added once
+type iptablesBackend struct {
+	prefix string
+}
+
+func (b *iptablesBackend) Name() string {
+	return "iptables"
+}
+
+// chain names owned by the portal
+func (b *iptablesBackend) preChain() string  { return b.prefix + "_PRE" }
+func (b *iptablesBackend) postChain() string { return b.prefix + "_POST" }
+func (b *iptablesBackend) inChain() string   { return b.prefix + "_IN" }
+
+// jumps lists the built-in chains that must jump to the portal's chains
+func (b *iptablesBackend) jumps() [][3]string {
+	return [][3]string{
+		{"nat", "PREROUTING", b.preChain()},
+		{"nat", "POSTROUTING", b.postChain()},
+		{"filter", "INPUT", b.inChain()},
+	}
+}
+
+// Apply rebuilds the portal's chains for both address families
+func (b *iptablesBackend) Apply(ctx context.Context, rs *ruleset) error {
+	for _, family := range []struct {
+		ipv6    bool
+		restore string
+		command string
+	}{
+		{false, "iptables-restore", "iptables"},
+		{true, "ip6tables-restore", "ip6tables"},
+	} {
+		if err := runCommand(ctx, b.render(rs, family.ipv6), family.restore, "--noflush"); err != nil {
+			return err
+		}
+		for _, jump := range b.jumps() {
+			// -C fails when the jump is missing
+			if runCommand(ctx, "", family.command, "-t", jump[0], "-C", jump[1], "-j", jump[2]) == nil {
+				continue
+			}
+			if err := runCommand(ctx, "", family.command, "-t", jump[0], "-I", jump[1], "-j", jump[2]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush removes the jumps and the portal's chains
+// Best effort: chains that do not exist are not an error
+func (b *iptablesBackend) Flush(ctx context.Context) error {
+	for _, command := range []string{"iptables", "ip6tables"} {
+		for _, jump := range b.jumps() {
+			// Remove every jump in case it was inserted more than once
+			for i := 0; i < 10; i++ {
+				if runCommand(ctx, "", command, "-t", jump[0], "-D", jump[1], "-j", jump[2]) != nil {
+					break
+				}
+			}
+			runCommand(ctx, "", command, "-t", jump[0], "-F", jump[2])
+			runCommand(ctx, "", command, "-t", jump[0], "-X", jump[2])
+		}
+	}
+	return nil
+}
+
+// render builds the restore input for one address family
+func (b *iptablesBackend) render(rs *ruleset, ipv6 bool) string {
+	var nat, filter strings.Builder
+
+	for i := range rs.Services {
+		rule := &rs.Services[i]
+		sources := rule.sourcesFor(ipv6)
+
+		for _, proto := range rule.Protocols {
+			for _, source := range sources {
+				switch rule.Action {
+				case actionAccept:
+					fmt.Fprintf(&filter, "-A %s -p %s -s %s --dport %d -j ACCEPT\n", b.inChain(), proto, source, rule.ListenPort)
+				case actionRedirect:
+					fmt.Fprintf(&nat, "-A %s -p %s -s %s --dport %d -j REDIRECT --to-ports %d\n", b.preChain(), proto, source, rule.ListenPort, rule.BackendPort)
+				case actionDNAT:
+					if rule.Backend.Is6() != ipv6 {
+						continue
+					}
+					destination := fmt.Sprintf("%s:%d", rule.Backend, rule.BackendPort)
+					if ipv6 {
+						destination = fmt.Sprintf("[%s]:%d", rule.Backend, rule.BackendPort)
+					}
+					fmt.Fprintf(&nat, "-A %s -p %s -s %s --dport %d -j DNAT --to-destination %s\n", b.preChain(), proto, source, rule.ListenPort, destination)
+				}
+			}
+
+			switch rule.Action {
+			case actionAccept:
+				fmt.Fprintf(&filter, "-A %s -p %s --dport %d -j DROP\n", b.inChain(), proto, rule.ListenPort)
+			case actionDNAT:
+				if rs.Masquerade && rule.Backend.Is6() == ipv6 {
+					fmt.Fprintf(&nat, "-A %s -p %s -d %s --dport %d -m conntrack --ctstate DNAT -j MASQUERADE\n", b.postChain(), proto, rule.Backend, rule.BackendPort)
+				}
+			}
+		}
+	}
+
+	// Declaring a chain in restore input flushes it, even with --noflush
+	var script strings.Builder
+	script.WriteString("*nat\n")
+	fmt.Fprintf(&script, ":%s - [0:0]\n:%s - [0:0]\n", b.preChain(), b.postChain())
+	script.WriteString(nat.String())
+	script.WriteString("COMMIT\n*filter\n")
+	fmt.Fprintf(&script, ":%s - [0:0]\n", b.inChain())
+	script.WriteString(filter.String())
+	script.WriteString("COMMIT\n")
+	return script.String()
+}
@@ -0,0 +1,6 @@
+package firewall
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["firewall"] overrides its level
+var log = logging.Module("firewall")
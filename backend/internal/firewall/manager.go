@@ -0,0 +1,434 @@
+// Package firewall moves the data plane of selected services into the kernel.
+//
+// Services with enforcement_mode "firewall" are not proxied. Instead, the IPs currently
+// allowed by the portal are programmed into nftables sets, iptables chains or Windows
+// Defender Firewall rules that forward (or accept) traffic to the backend, and everyone
+// else is left at the closed port.
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/schedule"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+)
+
+// backend is implemented by each kernel firewall flavour
+type backend interface {
+	Name() string
+	Apply(ctx context.Context, rs *ruleset) error
+	Flush(ctx context.Context) error
+}
+
+// Manager keeps the kernel firewall in sync with the allowlist for firewall-mode services
+type Manager struct {
+	configLoader     *config.Loader
+	allowlistManager *ipallowlist.Manager
+	blocklistManager *ipblocklist.Manager
+	sessionManager   *session.Manager
+	mu               sync.Mutex
+	cfg              config.FirewallConfiguration
+	backend          backend
+	backends         map[string]netip.Addr // backend_target_host -> resolved address
+	applied          *ruleset
+	lastSync         time.Time
+	lastError        string
+	trigger          chan struct{}
+	stopChan         chan struct{}
+	stoppedChan      chan struct{}
+}
+
+// NewManager creates a new firewall manager
+func NewManager(configLoader *config.Loader, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, sessionManager *session.Manager) *Manager {
+	m := &Manager{
+		configLoader:     configLoader,
+		allowlistManager: allowlistManager,
+		blocklistManager: blocklistManager,
+		sessionManager:   sessionManager,
+		trigger:          make(chan struct{}, 1),
+	}
+
+	// Re-sync as soon as grants or blocks change
+	changed := func() {
+		select {
+		case m.trigger <- struct{}{}:
+		default:
+		}
+	}
+	allowlistManager.RegisterChangeHook(changed)
+	blocklistManager.RegisterChangeHook(changed)
+
+	return m
+}
+
+// Start programs the firewall and starts the sync loop if any service uses firewall mode
+func (m *Manager) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.start()
+}
+
+// start must be called with mu held
+func (m *Manager) start() error {
+	cfg := m.configLoader.GetConfig()
+	m.cfg = cfg.FirewallConfig
+
+	if !config.HasFirewallServices(cfg) {
+		log.Debug().Msg("No firewall-mode services, firewall enforcement inactive")
+		return nil
+	}
+
+	switch m.cfg.Backend {
+	case "iptables":
+		m.backend = &iptablesBackend{prefix: m.cfg.TableName}
+	case "windows":
+		m.backend = &windowsBackend{group: m.cfg.TableName}
+	default:
+		m.backend = &nftablesBackend{table: m.cfg.TableName}
+	}
+	m.backends = make(map[string]netip.Addr)
+	m.applied = nil
+
+	m.resolveBackends(cfg)
+	err := m.sync()
+
+	m.stopChan = make(chan struct{})
+	m.stoppedChan = make(chan struct{})
+	go m.run(m.stopChan, m.stoppedChan, time.Duration(m.cfg.SyncIntervalSeconds)*time.Second)
+
+	log.Info().
+		Str("backend", m.backend.Name()).
+		Str("table", m.cfg.TableName).
+		Msg("Firewall enforcement started")
+
+	return err
+}
+
+// run re-syncs on allowlist and blocklist changes, at every minute boundary so schedule
+// windows open and close on time, and periodically to drop expired grants
+func (m *Manager) run(stop, stopped chan struct{}, interval time.Duration) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		nextMinute := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-time.After(time.Until(nextMinute)):
+			m.mu.Lock()
+			m.sync()
+			m.mu.Unlock()
+		case <-ticker.C:
+			m.mu.Lock()
+			m.resolveBackends(m.configLoader.GetConfig())
+			m.sync()
+			m.mu.Unlock()
+		case <-m.trigger:
+			m.mu.Lock()
+			m.sync()
+			m.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// resolveBackends refreshes the addresses of backend hostnames; must be called with mu held
+func (m *Manager) resolveBackends(cfg *config.ApplicationConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, service := range cfg.ProtectedServices {
+		if !service.Enabled || service.EnforcementMode != "firewall" {
+			continue
+		}
+		host := service.BackendTargetHost
+		if addr, err := netip.ParseAddr(host); err == nil {
+			m.backends[host] = addr.Unmap()
+			continue
+		}
+
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil || len(addrs) == 0 {
+			log.Warn().
+				Err(err).
+				Str("service", service.ServiceName).
+				Str("host", host).
+				Msg("Failed to resolve firewall backend, keeping previous address")
+			continue
+		}
+
+		// Prefer IPv4, which most clients use and which avoids NAT66
+		selected := addrs[0].Unmap()
+		for _, addr := range addrs {
+			if addr.Unmap().Is4() {
+				selected = addr.Unmap()
+				break
+			}
+		}
+		m.backends[host] = selected
+	}
+}
+
+// sync builds the desired ruleset and applies it if it changed; must be called with mu held
+func (m *Manager) sync() error {
+	if m.backend == nil {
+		return nil
+	}
+
+	rs := m.buildRuleset(m.configLoader.GetConfig())
+	m.lastSync = time.Now()
+	if reflect.DeepEqual(rs, m.applied) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := m.backend.Apply(ctx, rs); err != nil {
+		m.lastError = err.Error()
+		log.Error().
+			Err(err).
+			Str("backend", m.backend.Name()).
+			Msg("Failed to apply firewall rules")
+		return err
+	}
+
+	m.applied = rs
+	m.lastError = ""
+
+	sources := 0
+	for _, rule := range rs.Services {
+		sources += len(rule.Sources)
+	}
+	log.Info().
+		Str("backend", m.backend.Name()).
+		Int("services", len(rs.Services)).
+		Int("sources", sources).
+		Msg("Firewall rules applied")
+
+	return nil
+}
+
+// buildRuleset computes the allowed sources of every firewall-mode service
+// Blocked addresses are cut out of the sources, and sessions are limited to their allowed
+// services and schedules, as the proxies do
+func (m *Manager) buildRuleset(cfg *config.ApplicationConfig) *ruleset {
+	entries := m.allowlistManager.GetActiveEntries()
+	blocked := normalizePrefixes(m.blocklistManager.BlockedPrefixes())
+	localAddrs := localAddresses()
+	now := time.Now()
+
+	// Sessions are looked up once; a missing one grants nothing
+	sessions := make(map[string]*session.Session)
+	for _, entry := range entries {
+		if entry.SourceType != ipallowlist.EntryTypeSession {
+			continue
+		}
+		if _, seen := sessions[entry.SessionID]; seen {
+			continue
+		}
+		sessions[entry.SessionID], _ = m.sessionManager.GetSessionByID(entry.SessionID)
+	}
+
+	rs := &ruleset{Masquerade: cfg.FirewallConfig.Masquerade}
+	forwarding := false
+
+	for _, service := range cfg.ProtectedServices {
+		if !service.Enabled || service.EnforcementMode != "firewall" {
+			continue
+		}
+		backendAddr, ok := m.backends[service.BackendTargetHost]
+		if !ok {
+			continue
+		}
+
+		rule := serviceRule{
+			ServiceID:   service.ServiceID,
+			ServiceName: service.ServiceName,
+			Protocols:   protocols(service.TransportProtocol),
+			ListenPort:  service.ProxyListenPortStart,
+			BackendPort: service.BackendTargetPort,
+			Action:      chooseAction(backendAddr, service.BackendTargetPort, service.ProxyListenPortStart, localAddrs),
+		}
+		if rule.Action == actionDNAT {
+			rule.Backend = backendAddr
+			forwarding = true
+		}
+
+		var sources []netip.Prefix
+		for _, entry := range entries {
+			if entry.SourceType == ipallowlist.EntryTypeSession {
+				sess := sessions[entry.SessionID]
+				if sess == nil {
+					continue
+				}
+				if _, allowed, _ := schedule.SessionAccess(cfg, []*session.Session{sess}, &service, now); !allowed {
+					continue
+				}
+			}
+			if entry.IPPrefix != nil {
+				sources = append(sources, *entry.IPPrefix)
+			} else {
+				sources = append(sources, netip.PrefixFrom(entry.IPAddress, entry.IPAddress.BitLen()))
+			}
+		}
+		rule.Sources = normalizePrefixes(subtractPrefixes(normalizePrefixes(sources), blocked))
+
+		rs.Services = append(rs.Services, rule)
+	}
+
+	if forwarding {
+		warnIfForwardingDisabled()
+	}
+
+	return rs
+}
+
+// localAddresses returns the addresses assigned to this host's interfaces
+func localAddresses() map[netip.Addr]bool {
+	addrs := make(map[netip.Addr]bool)
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return addrs
+	}
+	for _, ifaceAddr := range ifaceAddrs {
+		if prefix, err := netip.ParsePrefix(ifaceAddr.String()); err == nil {
+			addrs[prefix.Addr().Unmap()] = true
+		}
+	}
+	return addrs
+}
+
+var forwardingWarned sync.Once
+
+// warnIfForwardingDisabled logs once when DNAT to another host cannot work
+func warnIfForwardingDisabled() {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil || strings.TrimSpace(string(data)) != "0" {
+		return
+	}
+	forwardingWarned.Do(func() {
+		log.Warn().Msg("IP forwarding is disabled (net.ipv4.ip_forward=0); firewall-mode services on other hosts will be unreachable")
+	})
+}
+
+// runCommand executes a firewall tool, feeding stdin and returning its output on failure
+func runCommand(ctx context.Context, stdin string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// Reload re-reads the configuration and re-programs the firewall
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Applying replaces the rules atomically, so only flush when the old rules would be orphaned
+	cfg := m.configLoader.GetConfig()
+	flush := !config.HasFirewallServices(cfg) ||
+		cfg.FirewallConfig.Backend != m.cfg.Backend ||
+		cfg.FirewallConfig.TableName != m.cfg.TableName
+
+	m.stop(flush)
+	return m.start()
+}
+
+// stop halts the sync loop and optionally removes all rules; must be called with mu held
+func (m *Manager) stop(flush bool) {
+	if m.stopChan != nil {
+		close(m.stopChan)
+		stopped := m.stoppedChan
+		m.stopChan = nil
+		m.stoppedChan = nil
+
+		// Release the lock so an in-progress sync can finish
+		m.mu.Unlock()
+		<-stopped
+		m.mu.Lock()
+	}
+
+	if flush && m.backend != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := m.backend.Flush(ctx); err != nil {
+			log.Warn().Err(err).Str("backend", m.backend.Name()).Msg("Failed to remove firewall rules")
+		} else {
+			log.Info().Str("backend", m.backend.Name()).Msg("Firewall rules removed")
+		}
+	}
+	m.backend = nil
+	m.applied = nil
+}
+
+// Stop halts the sync loop and removes all rules
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stop(true)
+}
+
+// GetStats returns firewall enforcement status
+func (m *Manager) GetStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"active": m.backend != nil,
+	}
+	if m.backend != nil {
+		stats["backend"] = m.backend.Name()
+		stats["table"] = m.cfg.TableName
+	}
+
+	if m.applied != nil {
+		services := make([]map[string]interface{}, 0, len(m.applied.Services))
+		for _, rule := range m.applied.Services {
+			service := map[string]interface{}{
+				"service_id":  rule.ServiceID,
+				"listen_port": rule.ListenPort,
+				"action":      rule.Action,
+				"sources":     len(rule.Sources),
+			}
+			if rule.Backend.IsValid() {
+				service["backend"] = rule.Backend.String()
+			}
+			services = append(services, service)
+		}
+		stats["services"] = services
+	}
+	if !m.lastSync.IsZero() {
+		stats["last_sync"] = m.lastSync
+	}
+	if m.lastError != "" {
+		stats["last_error"] = m.lastError
+	}
+	return stats
+}
@@ -0,0 +1,119 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// nftablesBackend programs a dedicated inet table through the nft CLI
+// Every apply replaces the whole table in a single atomic transaction
+type nftablesBackend struct {
+	table string
+}
+
+func (b *nftablesBackend) Name() string {
+	return "nftables"
+}
+
+// Apply replaces the portal's table with the given ruleset
+func (b *nftablesBackend) Apply(ctx context.Context, rs *ruleset) error {
+	return runCommand(ctx, b.render(rs), "nft", "-f", "-")
+}
+
+// Flush removes the portal's table
+func (b *nftablesBackend) Flush(ctx context.Context) error {
+	// Declaring the table first makes the delete succeed when it does not exist
+	script := fmt.Sprintf("table inet %s\ndelete table inet %s\n", b.table, b.table)
+	return runCommand(ctx, script, "nft", "-f", "-")
+}
+
+// render builds the nft script for a ruleset
+func (b *nftablesBackend) render(rs *ruleset) string {
+	var sets, prerouting, input, postrouting strings.Builder
+
+	for i := range rs.Services {
+		rule := &rs.Services[i]
+		protos := strings.Join(rule.Protocols, ", ")
+		match := fmt.Sprintf("meta l4proto { %s } th dport %d", protos, rule.ListenPort)
+
+		for _, family := range []struct {
+			ipv6    bool
+			suffix  string
+			setType string
+			saddr   string
+		}{
+			{false, "v4", "ipv4_addr", "ip saddr"},
+			{true, "v6", "ipv6_addr", "ip6 saddr"},
+		} {
+			set := fmt.Sprintf("svc%d_%s", i, family.suffix)
+			writeSet(&sets, set, family.setType, rule.sourcesFor(family.ipv6))
+
+			switch rule.Action {
+			case actionAccept:
+				fmt.Fprintf(&input, "\t\t%s %s @%s accept\n", match, family.saddr, set)
+			case actionRedirect:
+				fmt.Fprintf(&prerouting, "\t\t%s %s @%s redirect to :%d\n", match, family.saddr, set, rule.BackendPort)
+			case actionDNAT:
+				// Destination NAT cannot cross address families
+				if rule.Backend.Is6() != family.ipv6 {
+					continue
+				}
+				if family.ipv6 {
+					fmt.Fprintf(&prerouting, "\t\t%s %s @%s dnat ip6 to [%s]:%d\n", match, family.saddr, set, rule.Backend, rule.BackendPort)
+				} else {
+					fmt.Fprintf(&prerouting, "\t\t%s %s @%s dnat ip to %s:%d\n", match, family.saddr, set, rule.Backend, rule.BackendPort)
+				}
+			}
+		}
+
+		switch rule.Action {
+		case actionAccept:
+			// Everyone else is dropped before reaching the local service
+			fmt.Fprintf(&input, "\t\t%s drop\n", match)
+		case actionDNAT:
+			if rs.Masquerade {
+				daddr := "ip daddr"
+				if rule.Backend.Is6() {
+					daddr = "ip6 daddr"
+				}
+				fmt.Fprintf(&postrouting, "\t\tct status dnat %s %s meta l4proto { %s } th dport %d masquerade\n",
+					daddr, rule.Backend, protos, rule.BackendPort)
+			}
+		}
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "table inet %s\ndelete table inet %s\n", b.table, b.table)
+	fmt.Fprintf(&script, "table inet %s {\n", b.table)
+	script.WriteString(sets.String())
+	script.WriteString("\tchain prerouting {\n\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+	script.WriteString(prerouting.String())
+	script.WriteString("\t}\n")
+	script.WriteString("\tchain input {\n\t\ttype filter hook input priority filter; policy accept;\n")
+	script.WriteString(input.String())
+	script.WriteString("\t}\n")
+	script.WriteString("\tchain postrouting {\n\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	script.WriteString(postrouting.String())
+	script.WriteString("\t}\n")
+	script.WriteString("}\n")
+	return script.String()
+}
+
+// writeSet declares a named address set with its elements
+func writeSet(b *strings.Builder, name, setType string, prefixes []netip.Prefix) {
+	fmt.Fprintf(b, "\tset %s {\n\t\ttype %s\n\t\tflags interval\n\t\tauto-merge\n", name, setType)
+	if len(prefixes) > 0 {
+		elements := make([]string, 0, len(prefixes))
+		for _, prefix := range prefixes {
+			if prefix.IsSingleIP() {
+				elements = append(elements, prefix.Addr().String())
+			} else {
+				elements = append(elements, prefix.String())
+			}
+		}
+		fmt.Fprintf(b, "\t\telements = { %s }\n", strings.Join(elements, ", "))
+	}
+	b.WriteString("\t}\n")
+}
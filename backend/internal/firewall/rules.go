@@ -0,0 +1,136 @@
+package firewall
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Actions taken for allowed sources of a service
+const (
+	actionAccept   = "accept"   // Backend is this host on the listen port: only filter input
+	actionRedirect = "redirect" // Backend is this host on another port: redirect locally
+	actionDNAT     = "dnat"     // Backend is another host: destination NAT
+)
+
+// serviceRule is the kernel enforcement for one firewall-mode service
+type serviceRule struct {
+	ServiceID   string
+	ServiceName string
+	Protocols   []string // tcp | udp
+	ListenPort  int
+	Action      string
+	Backend     netip.Addr // Unset for actionAccept
+	BackendPort int
+	Sources     []netip.Prefix
+}
+
+// ruleset is the complete desired firewall state
+type ruleset struct {
+	Services   []serviceRule
+	Masquerade bool
+}
+
+// sourcesFor returns the service's allowed sources of one address family
+func (r *serviceRule) sourcesFor(ipv6 bool) []netip.Prefix {
+	sources := make([]netip.Prefix, 0, len(r.Sources))
+	for _, prefix := range r.Sources {
+		if prefix.Addr().Is6() == ipv6 {
+			sources = append(sources, prefix)
+		}
+	}
+	return sources
+}
+
+// protocols maps a transport_protocol value to the kernel protocols it covers
+func protocols(transport string) []string {
+	switch strings.ToLower(transport) {
+	case "udp":
+		return []string{"udp"}
+	case "both":
+		return []string{"tcp", "udp"}
+	default:
+		return []string{"tcp"}
+	}
+}
+
+// chooseAction decides how traffic reaches the backend
+func chooseAction(backend netip.Addr, backendPort, listenPort int, localAddrs map[netip.Addr]bool) string {
+	if backend.IsLoopback() || localAddrs[backend] {
+		if backendPort == listenPort {
+			return actionAccept
+		}
+		return actionRedirect
+	}
+	return actionDNAT
+}
+
+// normalizePrefixes unmaps, masks, de-duplicates and sorts prefixes
+func normalizePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	seen := make(map[netip.Prefix]bool, len(prefixes))
+	result := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		addr := prefix.Addr()
+		bits := prefix.Bits()
+		if addr.Is4In6() {
+			addr = addr.Unmap()
+			bits -= 96
+			if bits < 0 {
+				continue
+			}
+		}
+		normalized, err := addr.Prefix(bits)
+		if err != nil || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		result = append(result, normalized)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if c := result[i].Addr().Compare(result[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return result[i].Bits() < result[j].Bits()
+	})
+	return result
+}
+
+// subtractPrefixes removes the blocked networks from the sources
+// A source containing a blocked network is split into the smaller prefixes around it
+func subtractPrefixes(sources, blocked []netip.Prefix) []netip.Prefix {
+	if len(blocked) == 0 {
+		return sources
+	}
+	result := make([]netip.Prefix, 0, len(sources))
+	for _, source := range sources {
+		result = appendWithout(result, source, blocked)
+	}
+	return result
+}
+
+// appendWithout appends the parts of source that no blocked network covers
+func appendWithout(result []netip.Prefix, source netip.Prefix, blocked []netip.Prefix) []netip.Prefix {
+	for _, block := range blocked {
+		if !block.Overlaps(source) {
+			continue
+		}
+		if block.Bits() <= source.Bits() {
+			return result // Fully blocked
+		}
+		lower, upper := splitPrefix(source)
+		result = appendWithout(result, lower, blocked)
+		return appendWithout(result, upper, blocked)
+	}
+	return append(result, source)
+}
+
+// splitPrefix returns the two halves of a prefix; it must be shorter than the address length
+func splitPrefix(prefix netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := prefix.Bits() + 1
+	lower := prefix.Masked().Addr()
+	raw := lower.AsSlice()
+	raw[(bits-1)/8] |= 0x80 >> ((bits - 1) % 8)
+	upper, _ := netip.AddrFromSlice(raw)
+	return netip.PrefixFrom(lower, bits), netip.PrefixFrom(upper, bits)
+}
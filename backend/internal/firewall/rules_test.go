@@ -0,0 +1,42 @@
+package firewall
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func prefixes(values ...string) []netip.Prefix {
+	result := make([]netip.Prefix, len(values))
+	for i, value := range values {
+		result[i] = netip.MustParsePrefix(value)
+	}
+	return result
+}
+
+func TestSubtractPrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []netip.Prefix
+		blocked []netip.Prefix
+		want    []netip.Prefix
+	}{
+		{"nothing blocked", prefixes("10.0.0.0/8"), nil, prefixes("10.0.0.0/8")},
+		{"unrelated block", prefixes("10.0.0.0/8"), prefixes("192.0.2.1/32"), prefixes("10.0.0.0/8")},
+		{"exact address", prefixes("192.0.2.1/32", "192.0.2.2/32"), prefixes("192.0.2.1/32"), prefixes("192.0.2.2/32")},
+		{"source inside a blocked range", prefixes("192.0.2.7/32"), prefixes("192.0.2.0/24"), prefixes()},
+		{"address inside a range", prefixes("192.0.2.0/30"), prefixes("192.0.2.2/32"),
+			prefixes("192.0.2.0/31", "192.0.2.3/32")},
+		{"IPv6 session prefix", prefixes("2001:db8::/126"), prefixes("2001:db8::1/128"),
+			prefixes("2001:db8::/128", "2001:db8::2/127")},
+		{"other family", prefixes("2001:db8::/64"), prefixes("0.0.0.0/0"), prefixes("2001:db8::/64")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizePrefixes(subtractPrefixes(tt.sources, tt.blocked))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
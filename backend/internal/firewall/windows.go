@@ -0,0 +1,118 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// windowsBackend manages Windows Defender Firewall rules through PowerShell
+// Each service protocol gets one inbound allow rule listing the allowed remote addresses;
+// the default inbound block policy turns everyone else away. Backends on another port or
+// host are reached through netsh portproxy (TCP only)
+type windowsBackend struct {
+	group    string
+	forwards map[string]string // portproxy kind/listen port -> delete command, for cleanup
+}
+
+func (b *windowsBackend) Name() string {
+	return "windows"
+}
+
+// Apply creates, updates and removes the portal's firewall rules in one PowerShell run
+func (b *windowsBackend) Apply(ctx context.Context, rs *ruleset) error {
+	var script strings.Builder
+	script.WriteString("$ErrorActionPreference = 'Stop'\n")
+
+	keep := []string{}
+	forwards := make(map[string]string)
+
+	for i := range rs.Services {
+		rule := &rs.Services[i]
+
+		for _, proto := range rule.Protocols {
+			if rule.Action != actionAccept && proto == "udp" {
+				// portproxy cannot forward UDP; the backend must listen on the listen port itself
+				log.Warn().
+					Str("service", rule.ServiceName).
+					Msg("Windows firewall mode cannot forward UDP to another port or host, skipping")
+				continue
+			}
+			if len(rule.Sources) == 0 {
+				continue
+			}
+
+			name := fmt.Sprintf("%s-%s-%s", b.group, rule.ServiceID, proto)
+			keep = append(keep, psQuote(name))
+
+			addresses := make([]string, 0, len(rule.Sources))
+			for _, source := range rule.Sources {
+				addresses = append(addresses, psQuote(source.String()))
+			}
+			remote := "@(" + strings.Join(addresses, ",") + ")"
+			protocol := strings.ToUpper(proto)
+
+			fmt.Fprintf(&script, "if (Get-NetFirewallRule -Name %s -ErrorAction SilentlyContinue) { Set-NetFirewallRule -Name %s -Protocol %s -LocalPort %d -RemoteAddress %s } else { New-NetFirewallRule -Name %s -DisplayName %s -Group %s -Direction Inbound -Action Allow -Protocol %s -LocalPort %d -RemoteAddress %s | Out-Null }\n",
+				psQuote(name), psQuote(name), protocol, rule.ListenPort, remote,
+				psQuote(name), psQuote("Knock-Knock "+rule.ServiceName+" ("+protocol+")"), psQuote(b.group), protocol, rule.ListenPort, remote)
+		}
+
+		if rule.Action == actionAccept {
+			continue
+		}
+
+		// Forward TCP from the listen port to the backend on both address families
+		target := "v4"
+		connectAddress := "127.0.0.1"
+		if rule.Action == actionDNAT {
+			connectAddress = rule.Backend.String()
+			if rule.Backend.Is6() {
+				target = "v6"
+			}
+		}
+		for _, listen := range []struct{ family, address string }{{"v4", "0.0.0.0"}, {"v6", "::"}} {
+			kind := listen.family + "to" + target
+			fmt.Fprintf(&script, "netsh interface portproxy add %s listenport=%d listenaddress=%s connectport=%d connectaddress=%s | Out-Null\n",
+				kind, rule.ListenPort, listen.address, rule.BackendPort, connectAddress)
+			forwards[fmt.Sprintf("%s/%d", kind, rule.ListenPort)] = fmt.Sprintf("netsh interface portproxy delete %s listenport=%d listenaddress=%s | Out-Null\n",
+				kind, rule.ListenPort, listen.address)
+		}
+	}
+
+	// Remove rules for services or protocols that are gone (or have no allowed sources)
+	fmt.Fprintf(&script, "Get-NetFirewallRule -Group %s -ErrorAction SilentlyContinue | Where-Object { $_.Name -notin @(%s) } | Remove-NetFirewallRule\n",
+		psQuote(b.group), strings.Join(keep, ","))
+	for key, remove := range b.forwards {
+		if _, ok := forwards[key]; !ok {
+			script.WriteString(remove)
+		}
+	}
+
+	if err := runCommand(ctx, script.String(), "powershell", "-NoProfile", "-NonInteractive", "-Command", "-"); err != nil {
+		return err
+	}
+
+	b.forwards = forwards
+	return nil
+}
+
+// Flush removes all of the portal's firewall rules and port forwards
+func (b *windowsBackend) Flush(ctx context.Context) error {
+	var script strings.Builder
+	fmt.Fprintf(&script, "Get-NetFirewallRule -Group %s -ErrorAction SilentlyContinue | Remove-NetFirewallRule\n", psQuote(b.group))
+	for _, remove := range b.forwards {
+		script.WriteString(remove)
+	}
+
+	if err := runCommand(ctx, script.String(), "powershell", "-NoProfile", "-NonInteractive", "-Command", "-"); err != nil {
+		return err
+	}
+
+	b.forwards = nil
+	return nil
+}
+
+// psQuote returns a single-quoted PowerShell string literal
+func psQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,159 @@
+// Package geoip looks up the country, city and autonomous system of client IP addresses in MaxMind DB files.
+package geoip
+
+import (
+	"net/netip"
+	"sync"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// cacheSize bounds the lookup cache; it is cleared when full
+const cacheSize = 4096
+
+// Location is where an IP address is registered or located
+type Location struct {
+	CountryCode  string `json:"country_code,omitempty"` // ISO 3166-1 alpha-2, e.g. DE
+	Country      string `json:"country,omitempty"`
+	City         string `json:"city,omitempty"`
+	ASN          uint   `json:"asn,omitempty"`
+	Organization string `json:"organization,omitempty"` // Owner of the autonomous system
+}
+
+// Resolver looks up IP addresses in the configured databases
+type Resolver struct {
+	mu        sync.RWMutex
+	cityPath  string
+	asnPath   string
+	city      *database
+	asn       *database
+	cache     map[netip.Addr]*Location
+	cacheLock sync.Mutex
+}
+
+// NewResolver creates a resolver and opens the configured databases
+func NewResolver(cfg *config.GeoIPConfiguration) *Resolver {
+	r := &Resolver{cache: make(map[netip.Addr]*Location)}
+	r.Reload(cfg)
+	return r
+}
+
+// Reload opens databases whose path or file changed; a database that fails to open is disabled until the next reload
+func (r *Resolver) Reload(cfg *config.GeoIPConfiguration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cfg.CityDatabase != r.cityPath || r.city.replaced() {
+		r.cityPath = cfg.CityDatabase
+		r.city = open(cfg.CityDatabase)
+	}
+	if cfg.ASNDatabase != r.asnPath || r.asn.replaced() {
+		r.asnPath = cfg.ASNDatabase
+		r.asn = open(cfg.ASNDatabase)
+	}
+
+	r.cacheLock.Lock()
+	r.cache = make(map[netip.Addr]*Location)
+	r.cacheLock.Unlock()
+}
+
+// open opens a database, logging failures; returns nil for an empty path
+func open(path string) *database {
+	if path == "" {
+		return nil
+	}
+	db, err := openDatabase(path)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("path", path).
+			Msg("Failed to open GeoIP database")
+		return nil
+	}
+	log.Info().
+		Str("path", path).
+		Str("type", db.databaseType).
+		Msg("Opened GeoIP database")
+	return db
+}
+
+// Enabled reports whether at least one database is open
+func (r *Resolver) Enabled() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.city != nil || r.asn != nil
+}
+
+// Lookup returns the location of an IP address, or nil if it is private or not in the databases
+func (r *Resolver) Lookup(ip netip.Addr) *Location {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return nil
+	}
+
+	r.cacheLock.Lock()
+	location, cached := r.cache[ip]
+	r.cacheLock.Unlock()
+	if cached {
+		return location
+	}
+
+	r.mu.RLock()
+	location = r.lookup(ip)
+	r.mu.RUnlock()
+
+	r.cacheLock.Lock()
+	if len(r.cache) >= cacheSize {
+		r.cache = make(map[netip.Addr]*Location)
+	}
+	r.cache[ip] = location
+	r.cacheLock.Unlock()
+	return location
+}
+
+// LookupString is Lookup for an address in text form; returns nil if it does not parse
+func (r *Resolver) LookupString(ip string) *Location {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	return r.Lookup(addr)
+}
+
+// lookup queries the databases; must be called with mu held for reading
+func (r *Resolver) lookup(ip netip.Addr) *Location {
+	location := &Location{}
+	found := false
+
+	if r.city != nil {
+		var record cityRecord
+		if ok, err := r.city.lookup(ip, &record); err != nil {
+			log.Debug().Err(err).Str("ip", ip.String()).Msg("GeoIP city lookup failed")
+		} else if ok {
+			country := record.Country
+			if country.ISOCode == "" && len(country.Names) == 0 {
+				country = record.RegisteredCountry
+			}
+			location.CountryCode = country.ISOCode
+			location.Country = country.Names["en"]
+			location.City = record.City.Names["en"]
+			found = true
+		}
+	}
+
+	if r.asn != nil {
+		var record asnRecord
+		if ok, err := r.asn.lookup(ip, &record); err != nil {
+			log.Debug().Err(err).Str("ip", ip.String()).Msg("GeoIP ASN lookup failed")
+		} else if ok {
+			location.ASN = record.Number
+			location.Organization = record.Organization
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return location
+}
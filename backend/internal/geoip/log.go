@@ -0,0 +1,6 @@
+package geoip
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["geoip"] overrides its level
+var log = logging.Module("geoip")
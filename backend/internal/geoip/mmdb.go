@@ -0,0 +1,77 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MaxMind DB files (.mmdb) as used by GeoLite2/GeoIP2 and compatible databases such as DB-IP and IPinfo
+// are read with maxminddb-golang. The whole file is read into memory instead of mapped, so a database
+// overwritten in place cannot break lookups before the next reload.
+
+// database is an opened .mmdb file
+type database struct {
+	path         string
+	modTime      time.Time // Of the file when it was read
+	reader       *maxminddb.Reader
+	databaseType string // e.g. GeoLite2-City, GeoLite2-ASN
+}
+
+// cityRecord is the part of a city or country record that is looked up
+type cityRecord struct {
+	Country           namedRecord `maxminddb:"country"`
+	RegisteredCountry namedRecord `maxminddb:"registered_country"`
+	City              namedRecord `maxminddb:"city"`
+}
+
+// namedRecord is a place with an ISO code and localized names
+type namedRecord struct {
+	ISOCode string            `maxminddb:"iso_code"`
+	Names   map[string]string `maxminddb:"names"`
+}
+
+// asnRecord is an autonomous system record
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// openDatabase reads and validates a MaxMind DB file
+func openDatabase(path string) (*database, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := maxminddb.FromBytes(buffer)
+	if err != nil {
+		return nil, err
+	}
+	return &database{
+		path:         path,
+		modTime:      info.ModTime(),
+		reader:       reader,
+		databaseType: reader.Metadata.DatabaseType,
+	}, nil
+}
+
+// replaced reports whether the file was changed since it was read; false for a nil database
+func (db *database) replaced() bool {
+	if db == nil {
+		return false
+	}
+	info, err := os.Stat(db.path)
+	return err == nil && !info.ModTime().Equal(db.modTime)
+}
+
+// lookup decodes the record for an IP address into result; returns false if the database has none
+func (db *database) lookup(ip netip.Addr, result interface{}) (bool, error) {
+	_, found, err := db.reader.LookupNetwork(ip.Unmap().AsSlice(), result)
+	return found, err
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/loginhistory"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// MustChangePasswordRequest is the body of PUT /api/admin/accounts/:user_id/must-change-password
+type MustChangePasswordRequest struct {
+	MustChangePassword *bool `json:"must_change_password" binding:"required"`
+}
+
+// AdminAccountsHandler handles portal user account operations
+type AdminAccountsHandler struct {
+	configLoader *config.Loader
+	loginHistory *loginhistory.History
+	auditLog     *audit.Log
+}
+
+// NewAdminAccountsHandler creates a new handler
+func NewAdminAccountsHandler(configLoader *config.Loader, loginHistory *loginhistory.History, auditLog *audit.Log) *AdminAccountsHandler {
+	return &AdminAccountsHandler{
+		configLoader: configLoader,
+		loginHistory: loginHistory,
+		auditLog:     auditLog,
+	}
+}
+
+// HandleSetMustChangePassword forces (or cancels) a password change at the user's next login
+func (h *AdminAccountsHandler) HandleSetMustChangePassword(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	var req MustChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, models.NewErrorResponse("Invalid request body", "INVALID_REQUEST"))
+		return
+	}
+
+	var (
+		username string
+		previous bool
+	)
+	err := h.configLoader.UpdatePortalUser(userID, func(user *config.PortalUserAccount) {
+		previous = user.MustChangePassword
+		user.MustChangePassword = *req.MustChangePassword
+		username = user.Username
+	})
+	if errors.Is(err, config.ErrPortalUserNotFound) {
+		c.JSON(404, models.NewErrorResponse("User not found", "USER_NOT_FOUND"))
+		return
+	}
+	if err != nil {
+		c.JSON(400, models.NewErrorResponse("Failed to update user: "+err.Error(), "UPDATE_FAILED"))
+		return
+	}
+
+	entry := auditEntry(c, "account.must_change_password", userID+" ("+username+")")
+	entry.Changes = []audit.Change{{Field: "must_change_password", Before: previous, After: *req.MustChangePassword}}
+	h.auditLog.Record(entry)
+
+	c.JSON(200, models.NewAPIResponse("User updated", gin.H{
+		"user_id":              userID,
+		"must_change_password": *req.MustChangePassword,
+	}))
+}
+
+// HandleLoginHistory handles GET /api/admin/portal-users/:id/logins
+// Lists the recent successful and failed login attempts of a portal user
+func (h *AdminAccountsHandler) HandleLoginHistory(c *gin.Context) {
+	userID := c.Param("id")
+
+	found := false
+	for _, user := range h.configLoader.GetConfig().PortalUserAccounts {
+		if user.UserID == userID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(404, models.NewErrorResponse("User not found", "USER_NOT_FOUND"))
+		return
+	}
+
+	attempts := h.loginHistory.List(userID)
+	c.JSON(200, models.NewAPIResponseWithCount("Login history retrieved", attempts, len(attempts)))
+}
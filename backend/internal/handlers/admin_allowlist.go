@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/gin-gonic/gin"
+)
+
+// dnsRefreshTimeout bounds a forced resolution of all dynamic DNS hostnames
+const dnsRefreshTimeout = 15 * time.Second
+
+// AdminAllowlistHandler handles inspection and manual removal of IP allowlist entries and dynamic DNS status
+type AdminAllowlistHandler struct {
+	allowlistManager *ipallowlist.Manager
+	sessionManager   *session.Manager
+	auditLog         *audit.Log
+}
+
+// NewAdminAllowlistHandler creates a new handler
+func NewAdminAllowlistHandler(allowlistManager *ipallowlist.Manager, sessionManager *session.Manager, auditLog *audit.Log) *AdminAllowlistHandler {
+	return &AdminAllowlistHandler{
+		allowlistManager: allowlistManager,
+		sessionManager:   sessionManager,
+		auditLog:         auditLog,
+	}
+}
+
+// HandleList handles GET /api/admin/allowlist?source_type=session
+// Returns the permanent, DNS-resolved and session entries with where each came from
+func (h *AdminAllowlistHandler) HandleList(c *gin.Context) {
+	sourceType := ipallowlist.EntryType(c.Query("source_type"))
+	if !validSourceType(sourceType) {
+		c.JSON(400, models.NewErrorResponse("source_type must be permanent, dns_resolved, session or hairpin", "INVALID_SOURCE_TYPE"))
+		return
+	}
+
+	usernames := make(map[string]string)
+	entries := []ipallowlist.EntryInfo{}
+	for _, entry := range h.allowlistManager.ListEntries() {
+		if sourceType != "" && entry.SourceType != sourceType {
+			continue
+		}
+		if entry.SessionID != "" {
+			username, ok := usernames[entry.SessionID]
+			if !ok {
+				if sess, err := h.sessionManager.GetSessionByID(entry.SessionID); err == nil {
+					username = sess.Username
+				}
+				usernames[entry.SessionID] = username
+			}
+			entry.Username = username
+		}
+		entries = append(entries, entry)
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Allowlist entries retrieved", entries, len(entries)))
+}
+
+// HandleDelete handles DELETE /api/admin/allowlist?entry=203.0.113.5&source_type=session
+// Removes an IP address or CIDR entry; without source_type every entry for it is removed
+func (h *AdminAllowlistHandler) HandleDelete(c *gin.Context) {
+	value := c.Query("entry")
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		if prefix.IsSingleIP() {
+			value = prefix.Addr().Unmap().String()
+		} else {
+			value = prefix.Masked().String()
+		}
+	} else if addr, err := netip.ParseAddr(value); err == nil {
+		value = addr.Unmap().String()
+	} else {
+		c.JSON(400, models.NewErrorResponse("entry must be an IP address or CIDR", "INVALID_ENTRY"))
+		return
+	}
+
+	sourceType := ipallowlist.EntryType(c.Query("source_type"))
+	if !validSourceType(sourceType) {
+		c.JSON(400, models.NewErrorResponse("source_type must be permanent, dns_resolved, session or hairpin", "INVALID_SOURCE_TYPE"))
+		return
+	}
+
+	removed := h.allowlistManager.RemoveEntry(value, sourceType)
+	if len(removed) == 0 {
+		c.JSON(404, models.NewErrorResponse("No matching allowlist entry", "ENTRY_NOT_FOUND"))
+		return
+	}
+	h.auditLog.Record(auditEntry(c, "allowlist.remove", value))
+
+	c.JSON(200, models.NewAPIResponse("Allowlist entry removed", gin.H{
+		"entry":   value,
+		"removed": len(removed),
+	}))
+}
+
+// HandleDNSStatus handles GET /api/admin/dns
+// Returns the resolved IPs, last success and last error of every dynamic DNS hostname
+func (h *AdminAllowlistHandler) HandleDNSStatus(c *gin.Context) {
+	c.JSON(200, models.NewAPIResponse("DNS status retrieved", gin.H{
+		"summary":   h.allowlistManager.DNSStatus(),
+		"hostnames": h.allowlistManager.DNSHostnames(),
+	}))
+}
+
+// HandleDNSRefresh handles POST /api/admin/dns/refresh
+// Resolves all dynamic DNS hostnames immediately instead of waiting for the next refresh
+func (h *AdminAllowlistHandler) HandleDNSRefresh(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), dnsRefreshTimeout)
+	defer cancel()
+
+	hostnames := h.allowlistManager.RefreshDNS(ctx)
+	h.auditLog.Record(auditEntry(c, "dns.refresh", ""))
+
+	c.JSON(200, models.NewAPIResponse("DNS hostnames resolved", gin.H{
+		"summary":   h.allowlistManager.DNSStatus(),
+		"hostnames": hostnames,
+	}))
+}
+
+// validSourceType reports whether a source_type filter is empty or a known entry type
+func validSourceType(sourceType ipallowlist.EntryType) bool {
+	switch sourceType {
+	case "", ipallowlist.EntryTypePermanent, ipallowlist.EntryTypeDNSResolved, ipallowlist.EntryTypeSession, ipallowlist.EntryTypeHairpin:
+		return true
+	}
+	return false
+}
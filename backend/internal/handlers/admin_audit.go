@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/middleware"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuditHandler serves the admin audit trail
+type AdminAuditHandler struct {
+	auditLog *audit.Log
+}
+
+// NewAdminAuditHandler creates a new handler
+func NewAdminAuditHandler(auditLog *audit.Log) *AdminAuditHandler {
+	return &AdminAuditHandler{
+		auditLog: auditLog,
+	}
+}
+
+// HandleList returns recent admin actions, newest first
+func (h *AdminAuditHandler) HandleList(c *gin.Context) {
+	entries := h.auditLog.List()
+	c.JSON(200, models.NewAPIResponseWithCount("Audit trail retrieved", entries, len(entries)))
+}
+
+// auditEntry starts an audit entry for the admin making this request
+func auditEntry(c *gin.Context, action, target string) audit.Entry {
+	entry := audit.Entry{
+		Admin:  middleware.GetAdminName(c),
+		Action: action,
+		Target: target,
+	}
+	if clientIP, ok := middleware.GetClientIP(c); ok {
+		entry.ClientIP = clientIP.String()
+	}
+	return entry
+}
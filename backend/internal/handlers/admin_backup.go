@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/backup"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminBackupHandler handles backup and restore of the config and runtime state
+type AdminBackupHandler struct {
+	configLoader     *config.Loader
+	sessionManager   *session.Manager
+	allowlistManager *ipallowlist.Manager
+	blocklistManager *ipblocklist.Manager
+	history          *stats.History
+	auditLog         *audit.Log
+	dataDir          string
+}
+
+// NewAdminBackupHandler creates a new handler
+func NewAdminBackupHandler(configLoader *config.Loader, sessionManager *session.Manager, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, history *stats.History, auditLog *audit.Log, dataDir string) *AdminBackupHandler {
+	return &AdminBackupHandler{
+		configLoader:     configLoader,
+		sessionManager:   sessionManager,
+		allowlistManager: allowlistManager,
+		blocklistManager: blocklistManager,
+		history:          history,
+		auditLog:         auditLog,
+		dataDir:          dataDir,
+	}
+}
+
+// HandleBackup handles GET /api/admin/backup
+// Returns a tar.gz archive with the config file, active sessions, temporary blocks, the audit trail
+// and the data directory; secrets are included, so the archive must be stored safely
+func (h *AdminBackupHandler) HandleBackup(c *gin.Context) {
+	// Secrets stay encrypted if the config file encrypts them
+	configData, err := h.configLoader.MarshalFile(config.FileConfig(h.configLoader.GetConfig()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.NewErrorResponse("Failed to export configuration: "+err.Error(), "BACKUP_FAILED"))
+		return
+	}
+
+	// Write the live traffic counters to disk so the history files are current
+	h.history.Flush()
+	dataFiles, err := backup.ReadDataFiles(h.dataDir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.NewErrorResponse("Failed to read data directory: "+err.Error(), "BACKUP_FAILED"))
+		return
+	}
+
+	// Record the backup first so the archive contains its own audit entry
+	h.auditLog.Record(auditEntry(c, "backup.create", ""))
+
+	archive := &backup.Archive{
+		Manifest: backup.NewManifest("api"),
+		Config:   configData,
+		State: backup.State{
+			Sessions:        h.sessionManager.GetAllActiveSessions(),
+			TemporaryBlocks: h.blocklistManager.ListTemporaryBlocks(),
+			Audit:           h.auditLog.List(),
+		},
+		DataFiles: dataFiles,
+	}
+
+	var buf bytes.Buffer
+	if err := backup.Write(&buf, archive); err != nil {
+		c.JSON(http.StatusInternalServerError, models.NewErrorResponse("Failed to write backup: "+err.Error(), "BACKUP_FAILED"))
+		return
+	}
+
+	filename := "knock-knock-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
+
+// HandleRestore handles POST /api/admin/restore?dry_run=true
+// Takes a backup archive as the multipart field "file", replaces the configuration (which recreates the
+// proxies) and re-creates sessions with their allowlist entries, temporary blocks and audit entries.
+// Data files are not restored while the server runs; the restore command handles them
+func (h *AdminBackupHandler) HandleRestore(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true" || c.PostForm("dry_run") == "true"
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewErrorResponse("A backup archive is required in the \"file\" field", "INVALID_REQUEST"))
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewErrorResponse("Failed to read upload: "+err.Error(), "INVALID_REQUEST"))
+		return
+	}
+	defer file.Close()
+
+	archive, err := backup.Read(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewErrorResponse(err.Error(), "INVALID_BACKUP"))
+		return
+	}
+
+	newConfig, err := config.ParseImport(archive.Config)
+	if err == nil {
+		err = config.ValidateConfig(newConfig)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.NewErrorResponse("Invalid configuration in backup: "+err.Error(), "INVALID_BACKUP"))
+		return
+	}
+	if err := config.CheckPortAvailability(newConfig, h.configLoader.GetConfig()); err != nil {
+		c.JSON(http.StatusConflict, models.NewErrorResponse("Port check failed: "+err.Error(), "PORT_UNAVAILABLE"))
+		return
+	}
+
+	skippedFiles := make([]string, 0, len(archive.DataFiles))
+	for name := range archive.DataFiles {
+		skippedFiles = append(skippedFiles, name)
+	}
+	sort.Strings(skippedFiles)
+
+	changes := audit.Diff(h.configLoader.GetConfig(), newConfig)
+	result := map[string]interface{}{
+		"manifest":           archive.Manifest,
+		"changes":            changes,
+		"data_files_skipped": skippedFiles,
+	}
+
+	if dryRun {
+		result["contents"] = backup.Applied{
+			Sessions:        len(archive.State.Sessions),
+			TemporaryBlocks: len(archive.State.TemporaryBlocks),
+			AuditEntries:    len(archive.State.Audit),
+		}
+		c.JSON(http.StatusOK, models.NewAPIResponse("Backup is valid (dry run, nothing restored)", result))
+		return
+	}
+
+	if err := h.configLoader.SaveConfig(newConfig); err != nil {
+		c.JSON(http.StatusInternalServerError, models.NewErrorResponse("Failed to save configuration: "+err.Error(), "RESTORE_FAILED"))
+		return
+	}
+
+	result["restored"] = backup.Apply(archive.State, backup.Targets{
+		Sessions:  h.sessionManager,
+		Allowlist: h.allowlistManager,
+		Blocklist: h.blocklistManager,
+		Audit:     h.auditLog,
+	})
+
+	entry := auditEntry(c, "backup.restore", header.Filename)
+	entry.Changes = changes
+	h.auditLog.Record(entry)
+
+	c.JSON(http.StatusOK, models.NewAPIResponse("Backup restored", result))
+}
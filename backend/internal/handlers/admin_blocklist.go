@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminBlocklistHandler handles the blocklist and runtime (temporary) blocks
+type AdminBlocklistHandler struct {
+	blocklistManager *ipblocklist.Manager
+	auditLog         *audit.Log
+}
+
+// NewAdminBlocklistHandler creates a new handler
+func NewAdminBlocklistHandler(blocklistManager *ipblocklist.Manager, auditLog *audit.Log) *AdminBlocklistHandler {
+	return &AdminBlocklistHandler{
+		blocklistManager: blocklistManager,
+		auditLog:         auditLog,
+	}
+}
+
+// HandleList handles GET /api/admin/blocklist?type=cidr
+// Returns the configured and temporary blocks with per-entry hit counters, and the aggregate stats
+func (h *AdminBlocklistHandler) HandleList(c *gin.Context) {
+	entryType := c.Query("type")
+	switch entryType {
+	case "", ipblocklist.EntryTypeIP, ipblocklist.EntryTypeCIDR, ipblocklist.EntryTypeTemporary:
+	default:
+		c.JSON(400, models.NewErrorResponse("type must be ip, cidr or temporary", "INVALID_TYPE"))
+		return
+	}
+
+	entries := []ipblocklist.BlockEntry{}
+	for _, entry := range h.blocklistManager.ListEntries() {
+		if entryType == "" || entry.Type == entryType {
+			entries = append(entries, entry)
+		}
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Blocklist retrieved", gin.H{
+		"entries": entries,
+		"stats":   h.blocklistManager.GetStats(),
+	}, len(entries)))
+}
+
+// HandleListTemporary returns the active temporary blocks
+func (h *AdminBlocklistHandler) HandleListTemporary(c *gin.Context) {
+	blocks := h.blocklistManager.ListTemporaryBlocks()
+	c.JSON(200, models.NewAPIResponseWithCount("Temporary blocks retrieved", blocks, len(blocks)))
+}
+
+// HandleUnblock lifts a temporary block early
+func (h *AdminBlocklistHandler) HandleUnblock(c *gin.Context) {
+	ip := net.ParseIP(c.Param("ip"))
+	if ip == nil {
+		c.JSON(400, models.NewErrorResponse("Invalid IP address", "INVALID_IP"))
+		return
+	}
+
+	if !h.blocklistManager.Unblock(ip) {
+		c.JSON(404, models.NewErrorResponse("IP is not temporarily blocked", "BLOCK_NOT_FOUND"))
+		return
+	}
+	h.auditLog.Record(auditEntry(c, "blocklist.unblock", ip.String()))
+
+	c.JSON(200, models.NewAPIResponse("IP unblocked", nil))
+}
+
+// authFailureInfo is a client's recent failed logins and whether it is blocked already
+type authFailureInfo struct {
+	auth.AuthFailure
+	Blocked bool `json:"blocked"`
+}
+
+// HandleAuthFailures handles GET /api/admin/auth-failures?limit=50
+// Lists the clients with recent failed portal, admin and invite logins and their rate limiter backoff
+func (h *AdminBlocklistHandler) HandleAuthFailures(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(400, models.NewErrorResponse("limit must be between 1 and 1000", "INVALID_REQUEST"))
+		return
+	}
+
+	failures := []authFailureInfo{}
+	for _, failure := range auth.RecentAuthFailures(limit) {
+		blocked, _ := h.blocklistManager.BlockReason(net.ParseIP(failure.ClientIP))
+		failures = append(failures, authFailureInfo{AuthFailure: failure, Blocked: blocked})
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Authentication failures retrieved", failures, len(failures)))
+}
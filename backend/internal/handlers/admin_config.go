@@ -1,20 +1,31 @@
 package handlers
 
 import (
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/auth"
 	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
 )
 
 type AdminConfigHandler struct {
-	configLoader *config.Loader
+	configLoader     *config.Loader
+	passwordVerifier *auth.PasswordVerifier
+	jwtManager       *auth.JWTManager
+	auditLog         *audit.Log
 }
 
-func NewAdminConfigHandler(configLoader *config.Loader) *AdminConfigHandler {
+func NewAdminConfigHandler(configLoader *config.Loader, passwordVerifier *auth.PasswordVerifier, jwtManager *auth.JWTManager, auditLog *audit.Log) *AdminConfigHandler {
 	return &AdminConfigHandler{
-		configLoader: configLoader,
+		configLoader:     configLoader,
+		passwordVerifier: passwordVerifier,
+		jwtManager:       jwtManager,
+		auditLog:         auditLog,
 	}
 }
 
@@ -43,6 +54,217 @@ func (h *AdminConfigHandler) HandleUpdateConfig(c *gin.Context) {
 	// Get the existing config to compare passwords
 	existingConfig := h.configLoader.GetConfig()
 
+	if status, message := h.prepareAccounts(&newConfig, existingConfig); status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   message,
+		})
+		return
+	}
+
+	// Validate the configuration
+	if err := config.ValidateConfig(&newConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Configuration validation failed: " + err.Error(),
+		})
+		return
+	}
+	if err := config.CheckPortAvailability(&newConfig, existingConfig); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "Port check failed: " + err.Error(),
+		})
+		return
+	}
+
+	// Save the configuration
+	if err := h.configLoader.SaveConfig(&newConfig); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to save configuration: " + err.Error(),
+		})
+		return
+	}
+	h.revokeChangedAdmins(existingConfig, &newConfig)
+
+	entry := auditEntry(c, "config.update", "")
+	entry.Changes = audit.Diff(existingConfig, &newConfig)
+	h.auditLog.Record(entry)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Configuration updated successfully",
+		"data":    newConfig,
+	})
+}
+
+// HandleExport handles GET /api/admin/config/export?redact=true
+// Downloads the configuration as YAML; with redact, passwords, hashes and tokens are replaced by a placeholder
+func (h *AdminConfigHandler) HandleExport(c *gin.Context) {
+	redact := c.Query("redact") == "true"
+
+	var doc yaml.Node
+	if err := doc.Encode(config.Standalone(config.FileConfig(h.configLoader.GetConfig()))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to export configuration: " + err.Error(),
+		})
+		return
+	}
+	if redact {
+		audit.RedactYAML(&doc)
+	}
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to export configuration: " + err.Error(),
+		})
+		return
+	}
+
+	entry := auditEntry(c, "config.export", "")
+	if redact {
+		entry.Target = "redacted"
+	}
+	h.auditLog.Record(entry)
+
+	filename := "knock-knock-config-" + time.Now().Format("20060102-150405") + ".yml"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/yaml; charset=utf-8", data)
+}
+
+// HandleImport handles POST /api/admin/config/import?dry_run=true
+// Takes a YAML config as the multipart field "file" and replaces the configuration with it after validation;
+// with dry_run, only validates and returns the config that would be saved
+func (h *AdminConfigHandler) HandleImport(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true" || c.PostForm("dry_run") == "true"
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "A YAML config file is required in the \"file\" field",
+		})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Failed to read upload: " + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Failed to read upload: " + err.Error(),
+		})
+		return
+	}
+
+	// A redacted export would replace every secret with the placeholder
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err == nil && audit.ContainsRedacted(&doc) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Configuration contains redacted secrets; export it again without redaction",
+		})
+		return
+	}
+
+	newConfig, err := config.ParseImport(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Invalid configuration: " + err.Error(),
+		})
+		return
+	}
+
+	existingConfig := h.configLoader.GetConfig()
+	if status, message := h.prepareAccounts(newConfig, existingConfig); status != http.StatusOK {
+		c.JSON(status, gin.H{
+			"success": false,
+			"error":   message,
+		})
+		return
+	}
+
+	if err := config.ValidateConfig(newConfig); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"error":   "Configuration validation failed: " + err.Error(),
+		})
+		return
+	}
+	if err := config.CheckPortAvailability(newConfig, existingConfig); err != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"success": false,
+			"error":   "Port check failed: " + err.Error(),
+		})
+		return
+	}
+
+	changes := audit.Diff(existingConfig, newConfig)
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Configuration is valid (dry run, nothing saved)",
+			"data": gin.H{
+				"config":  newConfig,
+				"changes": changes,
+			},
+		})
+		return
+	}
+
+	if err := h.configLoader.SaveConfig(newConfig); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"error":   "Failed to save configuration: " + err.Error(),
+		})
+		return
+	}
+	h.revokeChangedAdmins(existingConfig, newConfig)
+
+	entry := auditEntry(c, "config.import", header.Filename)
+	entry.Changes = changes
+	h.auditLog.Record(entry)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Configuration imported successfully",
+		"data":    newConfig,
+	})
+}
+
+// revokeChangedAdmins ends the sessions of admin accounts that were removed or got a new password
+func (h *AdminConfigHandler) revokeChangedAdmins(existingConfig, newConfig *config.ApplicationConfig) {
+	current := make(map[string]string, len(newConfig.AdminAccounts))
+	for _, admin := range newConfig.AdminAccounts {
+		current[admin.Username] = admin.BcryptHashedPassword
+	}
+	for _, admin := range existingConfig.AdminAccounts {
+		if hash, ok := current[admin.Username]; ok && hash == admin.BcryptHashedPassword {
+			continue
+		}
+		if revoked := h.jwtManager.AdminSessions().RevokeCredential(admin.Username, admin.BcryptHashedPassword); revoked > 0 {
+			log.Info().
+				Str("admin", admin.Username).
+				Int("sessions", revoked).
+				Msg("Admin account removed or password changed, admin sessions revoked")
+		}
+	}
+}
+
+// prepareAccounts hashes plain text passwords of portal users and admins and keeps the stored hash of accounts
+// sent without one; returns http.StatusOK or the status and message of the first problem
+func (h *AdminConfigHandler) prepareAccounts(newConfig, existingConfig *config.ApplicationConfig) (int, string) {
 	// Hash any new or changed passwords for portal users
 	for i := range newConfig.PortalUserAccounts {
 		user := &newConfig.PortalUserAccounts[i]
@@ -54,11 +276,7 @@ func (h *AdminConfigHandler) HandleUpdateConfig(c *gin.Context) {
 			// This is a plain text password, hash it
 			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.BcryptHashedPassword), bcrypt.DefaultCost)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"success": false,
-					"error":   "Failed to hash password for user " + user.Username + ": " + err.Error(),
-				})
-				return
+				return http.StatusInternalServerError, "Failed to hash password for user " + user.Username + ": " + err.Error()
 			}
 			user.BcryptHashedPassword = string(hashedPassword)
 		} else if user.BcryptHashedPassword == "" {
@@ -72,36 +290,39 @@ func (h *AdminConfigHandler) HandleUpdateConfig(c *gin.Context) {
 
 			// If still empty, this is a new user without a password - reject
 			if user.BcryptHashedPassword == "" {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"success": false,
-					"error":   "Password is required for new user: " + user.Username,
-				})
-				return
+				return http.StatusBadRequest, "Password is required for new user: " + user.Username
 			}
 		}
 	}
 
-	// Validate the configuration
-	if err := config.ValidateConfig(&newConfig); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Configuration validation failed: " + err.Error(),
-		})
-		return
-	}
+	// Same for admin accounts, which are matched by username
+	for i := range newConfig.AdminAccounts {
+		admin := &newConfig.AdminAccounts[i]
 
-	// Save the configuration
-	if err := h.configLoader.SaveConfig(&newConfig); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to save configuration: " + err.Error(),
-		})
-		return
+		if admin.BcryptHashedPassword != "" &&
+			admin.BcryptHashedPassword[0] != '$' {
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(admin.BcryptHashedPassword), bcrypt.DefaultCost)
+			if err != nil {
+				return http.StatusInternalServerError, "Failed to hash password for admin " + admin.Username + ": " + err.Error()
+			}
+			admin.BcryptHashedPassword = string(hashedPassword)
+		} else if admin.BcryptHashedPassword == "" {
+			for _, existingAdmin := range existingConfig.AdminAccounts {
+				if existingAdmin.Username == admin.Username {
+					admin.BcryptHashedPassword = existingAdmin.BcryptHashedPassword
+					break
+				}
+			}
+
+			if admin.BcryptHashedPassword == "" {
+				return http.StatusBadRequest, "Password is required for new admin: " + admin.Username
+			}
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Configuration updated successfully",
-		"data":    newConfig,
-	})
+	// Without ADMIN_PASSWORD_BCRYPT_HASH, removing every admin account would lock everyone out
+	if len(newConfig.AdminAccounts) == 0 && !h.passwordVerifier.HasAdminPassword() {
+		return http.StatusBadRequest, "At least one admin account is required when ADMIN_PASSWORD_BCRYPT_HASH is not set"
+	}
+	return http.StatusOK, ""
 }
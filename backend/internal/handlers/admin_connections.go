@@ -2,25 +2,54 @@ package handlers
 
 import (
 	"net"
+	"net/http"
+	"net/netip"
 	"strings"
+	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/geoip"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
 	"github.com/davbauer/knock-knock-portal/internal/models"
 	"github.com/davbauer/knock-knock-portal/internal/proxy"
 	"github.com/davbauer/knock-knock-portal/internal/session"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	liveAuthTimeout       = 10 * time.Second // For the token message after the WebSocket is opened
+	livePollInterval      = time.Second
+	liveKeepaliveInterval = 25 * time.Second
 )
 
 // AdminConnectionsHandler handles admin connection monitoring
 type AdminConnectionsHandler struct {
-	proxyManager   *proxy.Manager
-	sessionManager *session.Manager
+	proxyManager     *proxy.Manager
+	sessionManager   *session.Manager
+	blocklistManager *ipblocklist.Manager
+	geoResolver      *geoip.Resolver
+	auditLog         *audit.Log
+	jwtManager       *auth.JWTManager
+}
+
+// liveMessage is sent over the live connections WebSocket
+type liveMessage struct {
+	Type        string                   `json:"type"`                  // snapshot | changes | keepalive
+	Connections []proxy.ConnectionInfo   `json:"connections,omitempty"` // snapshot
+	Changes     []proxy.ConnectionChange `json:"changes,omitempty"`     // changes
 }
 
 // NewAdminConnectionsHandler creates a new handler
-func NewAdminConnectionsHandler(proxyManager *proxy.Manager, sessionManager *session.Manager) *AdminConnectionsHandler {
+func NewAdminConnectionsHandler(proxyManager *proxy.Manager, sessionManager *session.Manager, blocklistManager *ipblocklist.Manager, geoResolver *geoip.Resolver, auditLog *audit.Log, jwtManager *auth.JWTManager) *AdminConnectionsHandler {
 	return &AdminConnectionsHandler{
-		proxyManager:   proxyManager,
-		sessionManager: sessionManager,
+		proxyManager:     proxyManager,
+		sessionManager:   sessionManager,
+		blocklistManager: blocklistManager,
+		geoResolver:      geoResolver,
+		auditLog:         auditLog,
+		jwtManager:       jwtManager,
 	}
 }
 
@@ -103,6 +132,7 @@ func (h *AdminConnectionsHandler) HandleList(c *gin.Context) {
 						"total_bytes_tx":   stats["total_bytes_sent"],
 						"total_sessions":   stats["total_sessions"],
 						"services":         stats["services"],
+						"location":         h.geoResolver.LookupString(ip),
 					})
 				}
 			}
@@ -143,6 +173,164 @@ func (h *AdminConnectionsHandler) HandleTerminate(c *gin.Context) {
 		c.JSON(500, models.NewErrorResponse("Failed to terminate connections: "+err.Error(), "TERMINATION_ERROR"))
 		return
 	}
+	h.auditLog.Record(auditEntry(c, "connections.terminate", ip))
 
 	c.JSON(200, models.NewAPIResponse("All connections from "+ip+" have been terminated successfully", nil))
 }
+
+// HandleTerminateAnonymous handles DELETE /api/admin/connections/anonymous
+// Terminates the connections of every client that is not covered by a portal session
+func (h *AdminConnectionsHandler) HandleTerminateAnonymous(c *gin.Context) {
+	terminated := h.proxyManager.TerminateAnonymousConnections()
+	h.auditLog.Record(auditEntry(c, "connections.terminate_anonymous", ""))
+
+	c.JSON(200, models.NewAPIResponse("Anonymous connections terminated", map[string]interface{}{
+		"terminated": terminated,
+	}))
+}
+
+// HandleDetails handles GET /api/admin/connections/:ip/details
+// Returns the individual connections and UDP sessions of an IP address
+func (h *AdminConnectionsHandler) HandleDetails(c *gin.Context) {
+	ip := c.Param("ip")
+
+	if net.ParseIP(ip) == nil {
+		c.JSON(400, models.NewErrorResponse("Invalid IP address", "INVALID_REQUEST"))
+		return
+	}
+
+	connections := h.proxyManager.GetConnectionsByIP(ip)
+	blocked, blockReason := h.blocklistManager.BlockReason(net.ParseIP(ip))
+
+	c.JSON(200, models.NewAPIResponseWithCount("Connection details retrieved", map[string]interface{}{
+		"ip":           ip,
+		"connections":  connections,
+		"blocked":      blocked,
+		"block_reason": blockReason,
+		"location":     h.geoResolver.LookupString(ip),
+	}, len(connections)))
+}
+
+// HandleListIndividual handles GET /api/admin/connections/individual?service_id=minecraft&ip=203.0.113.5
+// Returns every proxied connection and UDP session with its client IP and port, start, duration and traffic
+func (h *AdminConnectionsHandler) HandleListIndividual(c *gin.Context) {
+	serviceID := c.Query("service_id")
+	ip := c.Query("ip")
+	if ip != "" {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			c.JSON(400, models.NewErrorResponse("Invalid IP address", "INVALID_REQUEST"))
+			return
+		}
+		ip = addr.Unmap().String()
+	}
+
+	connections := []proxy.ConnectionInfo{}
+	for _, conn := range h.proxyManager.ListConnections() {
+		if (serviceID == "" || conn.ServiceID == serviceID) && (ip == "" || conn.ClientIP == ip) {
+			connections = append(connections, conn)
+		}
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Connections retrieved", connections, len(connections)))
+}
+
+// HandleTerminateConnection handles DELETE /api/admin/connection/:conn_id
+// Terminates a single connection or UDP session, leaving the IP's other connections alone
+func (h *AdminConnectionsHandler) HandleTerminateConnection(c *gin.Context) {
+	connID := c.Param("conn_id")
+
+	if connID == "" {
+		c.JSON(400, models.NewErrorResponse("Connection ID is required", "INVALID_REQUEST"))
+		return
+	}
+
+	if !h.proxyManager.TerminateConnection(connID) {
+		c.JSON(404, models.NewErrorResponse("Connection not found", "CONNECTION_NOT_FOUND"))
+		return
+	}
+	h.auditLog.Record(auditEntry(c, "connection.terminate", connID))
+
+	c.JSON(200, models.NewAPIResponse("Connection "+connID+" has been terminated successfully", nil))
+}
+
+// HandleLive handles GET /api/admin/connections/live (WebSocket)
+// Browsers can't set headers on a WebSocket, so unless an Authorization header is sent the admin token must
+// arrive as the first message: {"token": "..."}. The server then sends a snapshot of all connections and,
+// every second something changed, the connections opened, updated and closed since. The socket is closed
+// when the token expires or its session is revoked; clients reconnect with a fresh token.
+func (h *AdminConnectionsHandler) HandleLive(c *gin.Context) {
+	server := websocket.Server{
+		// The token authenticates, not the origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			h.serveLive(ws, c.GetHeader("Authorization"))
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// serveLive authenticates a live connections WebSocket and streams changes until it closes or the token expires
+func (h *AdminConnectionsHandler) serveLive(ws *websocket.Conn, authHeader string) {
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok {
+		var hello struct {
+			Token string `json:"token"`
+		}
+		ws.SetReadDeadline(time.Now().Add(liveAuthTimeout))
+		if err := websocket.JSON.Receive(ws, &hello); err != nil {
+			return
+		}
+		ws.SetReadDeadline(time.Time{})
+		token = hello.Token
+	}
+
+	claims, err := h.jwtManager.ValidateToken(token)
+	if err != nil || claims.TokenType != auth.TokenTypeAdmin {
+		websocket.JSON.Send(ws, models.NewErrorResponse("Invalid or expired token", "INVALID_TOKEN"))
+		return
+	}
+
+	watcher, connections := h.proxyManager.WatchConnections()
+	if err := websocket.JSON.Send(ws, liveMessage{Type: "snapshot", Connections: connections}); err != nil {
+		return
+	}
+
+	// Nothing more is expected from the client; reading notices when it goes away
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+	}()
+
+	poll := time.NewTicker(livePollInterval)
+	defer poll.Stop()
+	lastSent := time.Now()
+
+	for {
+		select {
+		case <-poll.C:
+			// Ends the stream once the token expires or the admin session is revoked
+			if _, err := h.jwtManager.ValidateToken(token); err != nil {
+				return
+			}
+			message := liveMessage{Type: "changes", Changes: watcher.Poll()}
+			if len(message.Changes) == 0 {
+				if time.Since(lastSent) < liveKeepaliveInterval {
+					continue
+				}
+				// Keeps idle connections open through proxies
+				message = liveMessage{Type: "keepalive"}
+			}
+			if err := websocket.JSON.Send(ws, message); err != nil {
+				return
+			}
+			lastSent = time.Now()
+		case <-gone:
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/middleware"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminDebugHandler exposes pprof profiles and runtime statistics for diagnosing production issues
+type AdminDebugHandler struct {
+	configLoader *config.Loader
+	startTime    time.Time
+}
+
+// NewAdminDebugHandler creates a new handler
+func NewAdminDebugHandler(configLoader *config.Loader) *AdminDebugHandler {
+	return &AdminDebugHandler{
+		configLoader: configLoader,
+		startTime:    time.Now(),
+	}
+}
+
+// RequireEnabled answers 404 while debug_config is disabled; checked per request so a reload applies at once
+func (h *AdminDebugHandler) RequireEnabled(c *gin.Context) {
+	if !h.configLoader.GetConfig().DebugConfig.Enabled {
+		c.JSON(404, models.NewErrorResponse("Debug endpoints are disabled", "DEBUG_DISABLED"))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// HandleRuntime handles GET /api/admin/debug/runtime
+// Returns goroutine, heap and GC figures without the cost of a full profile
+func (h *AdminDebugHandler) HandleRuntime(c *gin.Context) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	gc := map[string]interface{}{
+		"num_gc":         mem.NumGC,
+		"num_forced_gc":  mem.NumForcedGC,
+		"pause_total_ms": float64(mem.PauseTotalNs) / 1e6,
+		"cpu_fraction":   mem.GCCPUFraction,
+		"next_gc_bytes":  mem.NextGC,
+	}
+	if mem.NumGC > 0 {
+		// PauseNs is a circular buffer; the most recent pause is at (NumGC+255)%256
+		gc["last_pause_ms"] = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+		gc["last_gc_seconds"] = int(time.Since(time.Unix(0, int64(mem.LastGC))).Seconds())
+	}
+
+	c.JSON(200, models.NewAPIResponse("Runtime statistics retrieved", map[string]interface{}{
+		"go_version":     runtime.Version(),
+		"num_cpu":        runtime.NumCPU(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"goroutines":     runtime.NumGoroutine(),
+		"uptime_seconds": int(time.Since(h.startTime).Seconds()),
+		"heap": map[string]interface{}{
+			"alloc_bytes":    mem.HeapAlloc,
+			"inuse_bytes":    mem.HeapInuse,
+			"idle_bytes":     mem.HeapIdle,
+			"released_bytes": mem.HeapReleased,
+			"sys_bytes":      mem.HeapSys,
+			"objects":        mem.HeapObjects,
+		},
+		"stack_inuse_bytes": mem.StackInuse,
+		"sys_bytes":         mem.Sys,
+		"total_alloc_bytes": mem.TotalAlloc,
+		"mallocs":           mem.Mallocs,
+		"frees":             mem.Frees,
+		"gc":                gc,
+	}))
+}
+
+// HandlePprof handles GET /api/admin/debug/pprof/ and /api/admin/debug/pprof/:profile
+// Serves the net/http/pprof index, the CPU profile, execution trace and every named runtime profile
+// (goroutine, heap, allocs, block, mutex, threadcreate)
+func (h *AdminDebugHandler) HandlePprof(c *gin.Context) {
+	profile := c.Param("profile")
+
+	log.Info().
+		Str("admin", middleware.GetAdminName(c)).
+		Str("profile", profile).
+		Str("query", c.Request.URL.RawQuery).
+		Msg("Debug profile requested")
+
+	switch profile {
+	case "":
+		// The index links are relative, so they resolve under this path
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(profile).ServeHTTP(c.Writer, c.Request)
+	}
+}
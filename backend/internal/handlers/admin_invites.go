@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/invite"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateInviteRequest is the body of POST /api/admin/invites
+type CreateInviteRequest struct {
+	AllowedServiceIDs []string `json:"allowed_service_ids"` // Empty = all services
+	ExpiresInHours    int      `json:"expires_in_hours"`    // 0 = 72 hours
+	Note              string   `json:"note"`
+}
+
+// AdminInvitesHandler handles invite management
+type AdminInvitesHandler struct {
+	inviteManager *invite.Manager
+	auditLog      *audit.Log
+}
+
+// NewAdminInvitesHandler creates a new handler
+func NewAdminInvitesHandler(inviteManager *invite.Manager, auditLog *audit.Log) *AdminInvitesHandler {
+	return &AdminInvitesHandler{
+		inviteManager: inviteManager,
+		auditLog:      auditLog,
+	}
+}
+
+// HandleList returns all invites, including used and expired ones
+func (h *AdminInvitesHandler) HandleList(c *gin.Context) {
+	invites := h.inviteManager.List()
+
+	results := make([]map[string]interface{}, 0, len(invites))
+	for _, inv := range invites {
+		status := "pending"
+		if inv.RedeemedAt != nil {
+			status = "redeemed"
+		} else if inv.IsExpired() {
+			status = "expired"
+		}
+		results = append(results, map[string]interface{}{
+			"code":                inv.Code,
+			"invite_url":          inviteURL(c, inv.Code),
+			"allowed_service_ids": inv.AllowedServiceIDs,
+			"note":                inv.Note,
+			"created_at":          inv.CreatedAt,
+			"expires_at":          inv.ExpiresAt,
+			"redeemed_at":         inv.RedeemedAt,
+			"redeemed_by":         inv.RedeemedBy,
+			"status":              status,
+		})
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Invites retrieved", results, len(results)))
+}
+
+// HandleCreate generates a new invite
+func (h *AdminInvitesHandler) HandleCreate(c *gin.Context) {
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, models.NewErrorResponse("Invalid request body", "INVALID_REQUEST"))
+		return
+	}
+	if req.ExpiresInHours < 0 || req.ExpiresInHours > 24*365 {
+		c.JSON(400, models.NewErrorResponse("expires_in_hours must be between 0 and 8760", "INVALID_REQUEST"))
+		return
+	}
+	if req.ExpiresInHours == 0 {
+		req.ExpiresInHours = 72
+	}
+
+	inv, err := h.inviteManager.Create(req.AllowedServiceIDs, time.Duration(req.ExpiresInHours)*time.Hour, req.Note)
+	if err != nil {
+		c.JSON(400, models.NewErrorResponse(err.Error(), "INVALID_REQUEST"))
+		return
+	}
+	h.auditLog.Record(auditEntry(c, "invite.create", inv.Code))
+
+	c.JSON(201, models.NewAPIResponse("Invite created", map[string]interface{}{
+		"code":                inv.Code,
+		"invite_url":          inviteURL(c, inv.Code),
+		"allowed_service_ids": inv.AllowedServiceIDs,
+		"note":                inv.Note,
+		"expires_at":          inv.ExpiresAt,
+	}))
+}
+
+// HandleRevoke deletes an invite so it can no longer be redeemed
+func (h *AdminInvitesHandler) HandleRevoke(c *gin.Context) {
+	if !h.inviteManager.Revoke(c.Param("code")) {
+		c.JSON(404, models.NewErrorResponse("Invite not found", "INVITE_NOT_FOUND"))
+		return
+	}
+	h.auditLog.Record(auditEntry(c, "invite.revoke", c.Param("code")))
+
+	c.JSON(200, models.NewAPIResponse("Invite revoked", nil))
+}
+
+// inviteURL builds the portal registration link for an invite code
+func inviteURL(c *gin.Context, code string) string {
+	scheme := "http"
+	if isSecureRequest(c) {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + "/invite?code=" + code
+}
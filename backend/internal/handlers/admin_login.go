@@ -1,44 +1,71 @@
 package handlers
 
 import (
+	"errors"
 	"net"
+	"net/http"
 	"time"
 
 	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/bruteforce"
+	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
 	"github.com/davbauer/knock-knock-portal/internal/middleware"
 	"github.com/davbauer/knock-knock-portal/internal/models"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
 )
 
 // AdminLoginRequest is the admin login request
 type AdminLoginRequest struct {
+	AdminUsername string `json:"admin_username"` // Empty = the ADMIN_PASSWORD_BCRYPT_HASH password
 	AdminPassword string `json:"admin_password" binding:"required"`
 }
 
+// envAdminName identifies logins with the ADMIN_PASSWORD_BCRYPT_HASH password
+const envAdminName = "admin"
+
+const (
+	defaultAdminTokenLifetime   = 15 * time.Minute
+	defaultAdminRefreshLifetime = 24 * time.Hour
+
+	// The refresh token is only sent to the admin API, and is out of reach of scripts
+	adminRefreshCookie = "admin_refresh_token"
+	adminCookiePath    = "/api/admin"
+)
+
 // AdminLoginHandler handles admin login
 type AdminLoginHandler struct {
+	configLoader     *config.Loader
 	passwordVerifier *auth.PasswordVerifier
 	jwtManager       *auth.JWTManager
 	blocklistManager *ipblocklist.Manager
+	bruteForce       *bruteforce.Guard
 	rateLimiter      *auth.RateLimiter
 }
 
 // NewAdminLoginHandler creates a new admin login handler
 func NewAdminLoginHandler(
+	configLoader *config.Loader,
 	passwordVerifier *auth.PasswordVerifier,
 	jwtManager *auth.JWTManager,
 	blocklistManager *ipblocklist.Manager,
+	bruteForce *bruteforce.Guard,
 ) *AdminLoginHandler {
 	return &AdminLoginHandler{
+		configLoader:     configLoader,
 		passwordVerifier: passwordVerifier,
 		jwtManager:       jwtManager,
 		blocklistManager: blocklistManager,
-		rateLimiter:      auth.NewRateLimiter(5, 3, 1000), // 5/min, burst 3, max 1000 IPs
+		bruteForce:       bruteForce,
+		rateLimiter:      newLoginRateLimiter(configLoader, adminLoginLimit, defaultAdminLoginLimits),
 	}
 }
 
+// adminLoginLimit selects the admin login rate limit of a config
+func adminLoginLimit(cfg *config.ApplicationConfig) config.LoginRateLimitConfig {
+	return cfg.ProxyServerConfig.LoginRateLimits.Admin
+}
+
 // Handle processes the admin login request
 func (h *AdminLoginHandler) Handle(c *gin.Context) {
 	// Get client IP
@@ -71,38 +98,201 @@ func (h *AdminLoginHandler) Handle(c *gin.Context) {
 		return
 	}
 
-	// Verify admin password
-	if err := h.passwordVerifier.VerifyAdminPassword(req.AdminPassword); err != nil {
-		h.rateLimiter.RecordFailure(clientIP.String())
-		c.JSON(401, models.NewErrorResponse("Invalid admin password", "INVALID_CREDENTIALS"))
+	attempted := req.AdminUsername
+	if attempted == "" {
+		attempted = envAdminName
+	}
+
+	// Usernames locked after too many failures are refused before the password is checked
+	if _, locked := h.bruteForce.LockedUntil(auth.LoginAdmin, attempted); locked {
+		c.JSON(429, models.NewErrorResponse("Too many failed logins for this account, please try again later", "ACCOUNT_LOCKED"))
 		log.Warn().
 			Str("client_ip", clientIP.String()).
+			Str("admin", attempted).
+			Msg("Admin login for locked username refused")
+		return
+	}
+
+	// Verify admin credentials
+	adminName, credential, err := h.verifyCredentials(req.AdminUsername, req.AdminPassword)
+	if err != nil {
+		h.rateLimiter.RecordFailedLogin(auth.LoginAdmin, clientIP.String(), attempted)
+		h.bruteForce.RecordFailure(auth.LoginAdmin, clientIP, attempted)
+		c.JSON(401, models.NewErrorResponse("Invalid admin credentials", "INVALID_CREDENTIALS"))
+		log.Warn().
+			Str("client_ip", clientIP.String()).
+			Str("admin", req.AdminUsername).
 			Msg("Failed admin login attempt")
 		return
 	}
 
 	// Record successful authentication to reset rate limit backoff
 	h.rateLimiter.RecordSuccess(clientIP.String())
+	h.bruteForce.RecordSuccess(auth.LoginAdmin, attempted)
 
-	// Generate JWT token (24 hours)
-	tokenDuration := 24 * time.Hour
-	token, err := h.jwtManager.GenerateAdminToken(tokenDuration)
+	// Start an admin session: short-lived access token plus a refresh token in an HttpOnly cookie
+	_, refreshLifetime := h.tokenLifetimes()
+	sessionID, refreshToken, err := h.jwtManager.AdminSessions().Create(adminName, credential, refreshLifetime)
 	if err != nil {
 		c.JSON(500, models.NewErrorResponse("Failed to generate token", "INTERNAL_ERROR"))
-		log.Error().Err(err).Msg("Failed to generate admin JWT token")
+		log.Error().Err(err).Msg("Failed to create admin session")
 		return
 	}
 
-	expiresAt := time.Now().Add(tokenDuration)
-
-	response := map[string]interface{}{
-		"jwt_access_token": token,
-		"token_expires_at": expiresAt,
+	response, err := h.issueTokens(c, adminName, sessionID, refreshToken)
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to generate token", "INTERNAL_ERROR"))
+		log.Error().Err(err).Msg("Failed to generate admin JWT token")
+		return
 	}
 
 	log.Info().
 		Str("client_ip", clientIP.String()).
+		Str("admin", adminName).
 		Msg("Admin logged in successfully")
 
 	c.JSON(200, models.NewAPIResponse("Admin login successful", response))
 }
+
+// HandleRefresh handles POST /api/admin/refresh
+// Exchanges the refresh token cookie for a new access token and rotates the refresh token
+func (h *AdminLoginHandler) HandleRefresh(c *gin.Context) {
+	clientIP, ok := middleware.GetClientIP(c)
+	if !ok || !clientIP.IsValid() {
+		c.JSON(400, models.NewErrorResponse("Could not determine client IP", "INVALID_IP"))
+		return
+	}
+	if !h.rateLimiter.Allow(clientIP.String()) {
+		c.JSON(429, models.NewErrorResponse("Too many requests, please try again later", "RATE_LIMIT_EXCEEDED"))
+		return
+	}
+
+	refreshToken, err := c.Cookie(adminRefreshCookie)
+	if err != nil || refreshToken == "" {
+		c.JSON(401, models.NewErrorResponse("Not signed in", "MISSING_REFRESH_TOKEN"))
+		return
+	}
+
+	_, refreshLifetime := h.tokenLifetimes()
+	sessionID, adminName, newRefreshToken, err := h.jwtManager.AdminSessions().Refresh(refreshToken, refreshLifetime, h.adminCredentials)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		h.clearRefreshCookie(c)
+		c.JSON(401, models.NewErrorResponse("Session expired, please sign in again", "INVALID_REFRESH_TOKEN"))
+		return
+	}
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to refresh token", "INTERNAL_ERROR"))
+		log.Error().Err(err).Msg("Failed to refresh admin session")
+		return
+	}
+
+	response, err := h.issueTokens(c, adminName, sessionID, newRefreshToken)
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to generate token", "INTERNAL_ERROR"))
+		log.Error().Err(err).Msg("Failed to generate admin JWT token")
+		return
+	}
+
+	c.JSON(200, models.NewAPIResponse("Token refreshed", response))
+}
+
+// HandleLogout handles POST /api/admin/logout
+// Revokes the admin session, so both its access and refresh tokens stop working
+func (h *AdminLoginHandler) HandleLogout(c *gin.Context) {
+	if claims, ok := middleware.GetJWTClaims(c); ok {
+		h.jwtManager.AdminSessions().Revoke(claims.SessionID)
+		log.Info().
+			Str("admin", claims.UserID).
+			Msg("Admin logged out")
+	}
+	h.clearRefreshCookie(c)
+
+	c.JSON(200, models.NewAPIResponse("Logged out", nil))
+}
+
+// tokenLifetimes returns the configured admin access and refresh token lifetimes
+func (h *AdminLoginHandler) tokenLifetimes() (time.Duration, time.Duration) {
+	proxyCfg := h.configLoader.GetConfig().ProxyServerConfig
+
+	access := time.Duration(proxyCfg.AdminTokenLifetimeSeconds) * time.Second
+	if access <= 0 {
+		access = defaultAdminTokenLifetime
+	}
+	refresh := time.Duration(proxyCfg.AdminRefreshLifetimeSeconds) * time.Second
+	if refresh <= 0 {
+		refresh = defaultAdminRefreshLifetime
+	}
+	return access, refresh
+}
+
+// issueTokens signs an access token for the admin session and sets the refresh token cookie
+func (h *AdminLoginHandler) issueTokens(c *gin.Context, adminName, sessionID, refreshToken string) (map[string]interface{}, error) {
+	accessLifetime, refreshLifetime := h.tokenLifetimes()
+
+	token, err := h.jwtManager.GenerateAdminToken(adminName, sessionID, accessLifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(adminRefreshCookie, refreshToken, int(refreshLifetime.Seconds()), adminCookiePath, "", isSecureRequest(c), true)
+
+	return map[string]interface{}{
+		"jwt_access_token": token,
+		"token_expires_at": time.Now().Add(accessLifetime),
+	}, nil
+}
+
+// clearRefreshCookie removes the refresh token cookie from the browser
+func (h *AdminLoginHandler) clearRefreshCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(adminRefreshCookie, "", -1, adminCookiePath, "", isSecureRequest(c), true)
+}
+
+// isSecureRequest reports whether the client reached the portal over HTTPS
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// verifyCredentials checks the password of a configured admin account, or the
+// ADMIN_PASSWORD_BCRYPT_HASH password when no username is given; returns the admin's name and password hash
+func (h *AdminLoginHandler) verifyCredentials(username, password string) (string, string, error) {
+	if username == "" {
+		return envAdminName, h.passwordVerifier.AdminPasswordHash(), h.passwordVerifier.VerifyAdminPassword(password)
+	}
+
+	// Unknown usernames are verified against a dummy hash so they take as long as known ones
+	passwordHash := dummyPasswordHash
+	found := false
+	for _, admin := range h.configLoader.GetConfig().AdminAccounts {
+		if admin.Username == username {
+			passwordHash = admin.BcryptHashedPassword
+			found = true
+			break
+		}
+	}
+
+	err := h.passwordVerifier.VerifyUserPassword(password, passwordHash)
+	if !found {
+		return "", "", errors.New("unknown admin username")
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return username, passwordHash, nil
+}
+
+// adminCredentials returns the password hashes an admin can sign in with now
+// An admin account may be named like the ADMIN_PASSWORD_BCRYPT_HASH admin, which then has two
+func (h *AdminLoginHandler) adminCredentials(adminName string) []string {
+	var hashes []string
+	if hash := h.passwordVerifier.AdminPasswordHash(); adminName == envAdminName && hash != "" {
+		hashes = append(hashes, hash)
+	}
+	for _, admin := range h.configLoader.GetConfig().AdminAccounts {
+		if admin.Username == adminName {
+			hashes = append(hashes, admin.BcryptHashedPassword)
+		}
+	}
+	return hashes
+}
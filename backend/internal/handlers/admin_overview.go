@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/proxy"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/gin-gonic/gin"
+)
+
+// overviewTrafficWindow is the period the per-service traffic totals of the overview cover
+const overviewTrafficWindow = 24 * time.Hour
+
+// AdminOverviewHandler serves the dashboard summary in a single response
+type AdminOverviewHandler struct {
+	configLoader     *config.Loader
+	sessionManager   *session.Manager
+	proxyManager     *proxy.Manager
+	history          *stats.History
+	allowlistManager *ipallowlist.Manager
+	blocklistManager *ipblocklist.Manager
+}
+
+// NewAdminOverviewHandler creates a new handler
+func NewAdminOverviewHandler(configLoader *config.Loader, sessionManager *session.Manager, proxyManager *proxy.Manager, history *stats.History, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager) *AdminOverviewHandler {
+	return &AdminOverviewHandler{
+		configLoader:     configLoader,
+		sessionManager:   sessionManager,
+		proxyManager:     proxyManager,
+		history:          history,
+		allowlistManager: allowlistManager,
+		blocklistManager: blocklistManager,
+	}
+}
+
+// HandleOverview handles GET /api/admin/overview
+// Returns sessions, per-service connections and traffic, blocklist hits, recent login failures,
+// DNS refresh state and proxy health, so the dashboard loads with one request
+func (h *AdminOverviewHandler) HandleOverview(c *gin.Context) {
+	cfg := h.configLoader.GetConfig()
+
+	sessions := h.sessionManager.GetAllActiveSessions()
+	users := make(map[string]bool)
+	sessionIPs := 0
+	for _, sess := range sessions {
+		users[sess.UserID] = true
+		sessionIPs += len(sess.AuthenticatedIPAddresses)
+	}
+
+	failures := h.proxyManager.Failures()
+	failedByService := make(map[string]int)
+	for _, failure := range failures {
+		failedByService[failure.ServiceID]++
+	}
+
+	trafficSince := time.Now().Add(-overviewTrafficWindow)
+	services := make([]map[string]interface{}, 0, len(cfg.ProtectedServices))
+	totalConnections := 0
+	for i := range cfg.ProtectedServices {
+		service := &cfg.ProtectedServices[i]
+
+		enabled := service.Enabled
+		if runtimeEnabled, ok := h.proxyManager.ServiceOverride(service.ServiceID); ok {
+			enabled = runtimeEnabled
+		}
+		_, connections := h.proxyManager.ServiceStatus(service.ServiceID)
+		proxies := len(h.proxyManager.GetServiceStats(service.ServiceID))
+		totalConnections += connections
+
+		entry := map[string]interface{}{
+			"service_id":   service.ServiceID,
+			"service_name": service.ServiceName,
+			"status":       serviceStatus(service, enabled, proxies, failedByService[service.ServiceID]),
+			"connections":  connections,
+		}
+		if h.history.Enabled() {
+			var traffic stats.Bucket
+			for _, point := range h.history.Query(stats.KindService, service.ServiceID, trafficSince, time.Hour) {
+				traffic.BytesIn += point.BytesIn
+				traffic.BytesOut += point.BytesOut
+				traffic.Connections += point.Connections
+			}
+			entry["traffic_24h"] = map[string]interface{}{
+				"bytes_in":    traffic.BytesIn,
+				"bytes_out":   traffic.BytesOut,
+				"connections": traffic.Connections,
+			}
+		}
+		services = append(services, entry)
+	}
+
+	backends := h.proxyManager.BackendHealth()
+	unreachable, circuitsOpen := countBackendProblems(backends)
+	healthStatus := "healthy"
+	if len(failures) > 0 || unreachable > 0 || circuitsOpen > 0 {
+		healthStatus = "degraded"
+	}
+
+	c.JSON(200, models.NewAPIResponse("Overview retrieved", map[string]interface{}{
+		"sessions": map[string]interface{}{
+			"active": len(sessions),
+			"users":  len(users),
+			"ips":    sessionIPs,
+		},
+		"services":          services,
+		"total_connections": totalConnections,
+		"blocklist":         h.blocklistManager.GetStats(),
+		"auth_failures": map[string]interface{}{
+			"last_5m":     auth.RecentLoginFailures(5 * time.Minute),
+			"last_hour":   auth.RecentLoginFailures(time.Hour),
+			"since_start": auth.LoginFailures(),
+		},
+		"dns": h.allowlistManager.DNSStatus(),
+		"health": map[string]interface{}{
+			"status":         healthStatus,
+			"proxies_failed": len(failures),
+			"backends": map[string]interface{}{
+				"total":         len(backends),
+				"unreachable":   unreachable,
+				"circuits_open": circuitsOpen,
+			},
+		},
+	}))
+}
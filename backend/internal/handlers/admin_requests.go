@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/approval"
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/middleware"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// DecideRequestRequest is the body of POST /api/admin/requests/:id
+type DecideRequestRequest struct {
+	Decision string `json:"decision" binding:"required"` // "approve" or "deny"
+}
+
+// AdminRequestsHandler handles login approval requests and the admin event stream
+type AdminRequestsHandler struct {
+	approvalManager *approval.Manager
+	bus             *events.Bus
+	auditLog        *audit.Log
+}
+
+// NewAdminRequestsHandler creates a new handler
+func NewAdminRequestsHandler(approvalManager *approval.Manager, bus *events.Bus, auditLog *audit.Log) *AdminRequestsHandler {
+	return &AdminRequestsHandler{
+		approvalManager: approvalManager,
+		bus:             bus,
+		auditLog:        auditLog,
+	}
+}
+
+// HandleList returns all known approval requests, optionally filtered by ?status=
+func (h *AdminRequestsHandler) HandleList(c *gin.Context) {
+	status := c.Query("status")
+
+	requests := []approval.Request{}
+	for _, req := range h.approvalManager.List() {
+		if status != "" && string(req.Status) != status {
+			continue
+		}
+		requests = append(requests, req)
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Approval requests retrieved", requests, len(requests)))
+}
+
+// HandleGet returns a single approval request
+func (h *AdminRequestsHandler) HandleGet(c *gin.Context) {
+	req, ok := h.approvalManager.Get(c.Param("id"))
+	if !ok {
+		c.JSON(404, models.NewErrorResponse("Approval request not found", "REQUEST_NOT_FOUND"))
+		return
+	}
+
+	c.JSON(200, models.NewAPIResponse("Approval request retrieved", req))
+}
+
+// HandleDecide approves or denies a pending request
+func (h *AdminRequestsHandler) HandleDecide(c *gin.Context) {
+	var body DecideRequestRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(400, models.NewErrorResponse("Invalid request body", "INVALID_REQUEST"))
+		return
+	}
+
+	var (
+		req *approval.Request
+		err error
+	)
+	switch body.Decision {
+	case "approve":
+		req, err = h.approvalManager.Approve(c.Param("id"), middleware.GetAdminName(c))
+	case "deny":
+		req, err = h.approvalManager.Deny(c.Param("id"), middleware.GetAdminName(c))
+	default:
+		c.JSON(400, models.NewErrorResponse("decision must be 'approve' or 'deny'", "INVALID_REQUEST"))
+		return
+	}
+
+	switch {
+	case errors.Is(err, approval.ErrNotFound):
+		c.JSON(404, models.NewErrorResponse("Approval request not found", "REQUEST_NOT_FOUND"))
+	case errors.Is(err, approval.ErrNotPending):
+		c.JSON(409, models.NewErrorResponse("Approval request is no longer pending", "REQUEST_NOT_PENDING"))
+	case err != nil:
+		log.Error().Err(err).Str("request_id", c.Param("id")).Msg("Failed to approve login request")
+		c.JSON(500, models.NewErrorResponse("Failed to create session", "INTERNAL_ERROR"))
+	default:
+		h.auditLog.Record(auditEntry(c, "approval."+body.Decision, req.ID+" ("+req.Username+")"))
+		c.JSON(200, models.NewAPIResponse("Approval request "+string(req.Status), req))
+	}
+}
+
+// HandleEvents streams portal events as Server-Sent Events
+// An optional ?types= comma-separated list limits the stream to those event types
+func (h *AdminRequestsHandler) HandleEvents(c *gin.Context) {
+	var types map[string]bool
+	if raw := c.Query("types"); raw != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(raw, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types[t] = true
+			}
+		}
+	}
+
+	ch, unsubscribe := h.bus.Subscribe(32)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepalive := time.NewTicker(25 * time.Second)
+	defer keepalive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return false
+			}
+			if types != nil && !types[event.Type] {
+				return true
+			}
+			c.SSEvent(event.Type, event)
+			return true
+		case <-keepalive.C:
+			// Comment line keeps idle connections open through proxies
+			_, err := io.WriteString(w, ": keepalive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
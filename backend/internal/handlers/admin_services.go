@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"errors"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/audit"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/proxy"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminServicesHandler handles per-service admin operations
+type AdminServicesHandler struct {
+	configLoader   *config.Loader
+	sessionManager *session.Manager
+	proxyManager   *proxy.Manager
+	history        *stats.History
+	auditLog       *audit.Log
+}
+
+// NewAdminServicesHandler creates a new handler
+func NewAdminServicesHandler(configLoader *config.Loader, sessionManager *session.Manager, proxyManager *proxy.Manager, history *stats.History, auditLog *audit.Log) *AdminServicesHandler {
+	return &AdminServicesHandler{
+		configLoader:   configLoader,
+		sessionManager: sessionManager,
+		proxyManager:   proxyManager,
+		history:        history,
+		auditLog:       auditLog,
+	}
+}
+
+// HandleList handles GET /api/admin/services
+// Returns every configured service with the statistics of its running proxies, including circuit breaker state,
+// and the errors of proxies that failed to start
+func (h *AdminServicesHandler) HandleList(c *gin.Context) {
+	services := h.configLoader.GetConfig().ProtectedServices
+
+	failuresByService := make(map[string][]proxy.ProxyFailure)
+	for _, failure := range h.proxyManager.Failures() {
+		failuresByService[failure.ServiceID] = append(failuresByService[failure.ServiceID], failure)
+	}
+
+	results := make([]map[string]interface{}, 0, len(services))
+	for _, service := range services {
+		proxies := h.proxyManager.GetServiceStats(service.ServiceID)
+		if proxies == nil {
+			proxies = []map[string]interface{}{}
+		}
+		failures := failuresByService[service.ServiceID]
+		if failures == nil {
+			failures = []proxy.ProxyFailure{}
+		}
+
+		enabled := service.Enabled
+		entry := map[string]interface{}{
+			"service_id":         service.ServiceID,
+			"service_name":       service.ServiceName,
+			"enabled":            service.Enabled,
+			"transport_protocol": service.TransportProtocol,
+			"is_http_protocol":   service.IsHTTPProtocol,
+			"tags":               service.Tags,
+			"running":            len(proxies) > 0,
+			"proxies":            proxies,
+			"failures":           failures,
+		}
+		// Set while a runtime enable/disable differs from the saved flag
+		if runtimeEnabled, ok := h.proxyManager.ServiceOverride(service.ServiceID); ok {
+			entry["runtime_enabled"] = runtimeEnabled
+			enabled = runtimeEnabled
+		}
+		entry["status"] = serviceStatus(&service, enabled, len(proxies), len(failures))
+		if activity, ok := h.proxyManager.ServiceActivity(service.ServiceID); ok {
+			entry["activity"] = activity
+		}
+		results = append(results, entry)
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Services retrieved", results, len(results)))
+}
+
+// HandleActivity handles GET /api/admin/services/activity
+// Returns when each running service last carried traffic, for automation that powers down idle backends
+// Services are first checked 30s after they start; until then they are not listed
+func (h *AdminServicesHandler) HandleActivity(c *gin.Context) {
+	services := h.configLoader.GetConfig().ProtectedServices
+
+	results := make([]proxy.ServiceActivity, 0, len(services))
+	for _, service := range services {
+		if activity, ok := h.proxyManager.ServiceActivity(service.ServiceID); ok {
+			results = append(results, activity)
+		}
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Service activity retrieved", results, len(results)))
+}
+
+// HandleEnable handles POST /api/admin/services/:id/enable
+func (h *AdminServicesHandler) HandleEnable(c *gin.Context) {
+	h.setEnabled(c, true)
+}
+
+// HandleDisable handles POST /api/admin/services/:id/disable
+func (h *AdminServicesHandler) HandleDisable(c *gin.Context) {
+	h.setEnabled(c, false)
+}
+
+// setEnabled starts or stops one service right away, leaving the other proxies running
+// With ?persist=true the Enabled flag is also saved to the config file, which reloads the proxies like any config save
+func (h *AdminServicesHandler) setEnabled(c *gin.Context, enabled bool) {
+	serviceID := c.Param("id")
+	persist := c.Query("persist") == "true"
+
+	err := h.proxyManager.SetServiceEnabled(serviceID, enabled)
+	switch {
+	case errors.Is(err, proxy.ErrServiceNotFound):
+		c.JSON(404, models.NewErrorResponse("Service not found", "SERVICE_NOT_FOUND"))
+		return
+	case errors.Is(err, proxy.ErrServiceNotProxied):
+		c.JSON(409, models.NewErrorResponse("Service uses firewall enforcement and has no proxy", "SERVICE_NOT_PROXIED"))
+		return
+	case err != nil:
+		c.JSON(500, models.NewErrorResponse("Failed to start service: "+err.Error(), "SERVICE_START_FAILED"))
+		return
+	}
+
+	action := "service.disable"
+	if enabled {
+		action = "service.enable"
+	}
+
+	if persist {
+		err := h.configLoader.UpdateProtectedService(serviceID, func(service *config.ProtectedServiceConfig) {
+			service.Enabled = enabled
+		})
+		if errors.Is(err, config.ErrServiceNotFound) {
+			c.JSON(409, models.NewErrorResponse("Runtime-provided services cannot be saved to the config file", "SERVICE_NOT_PERSISTABLE"))
+			return
+		}
+		if err != nil {
+			c.JSON(500, models.NewErrorResponse("Failed to save configuration: "+err.Error(), "CONFIG_SAVE_FAILED"))
+			return
+		}
+		// The saved flag now matches the running state
+		h.proxyManager.ClearServiceOverride(serviceID)
+		action += ".persist"
+	}
+	h.auditLog.Record(auditEntry(c, action, serviceID))
+
+	c.JSON(200, models.NewAPIResponse("Service updated", map[string]interface{}{
+		"service_id": serviceID,
+		"enabled":    enabled,
+		"persisted":  persist,
+	}))
+}
+
+// HandleResetCircuitBreaker handles POST /api/admin/services/:id/circuit-breaker/reset
+// Closes the service's circuit breakers so connections reach the backend again without waiting for the timeout
+func (h *AdminServicesHandler) HandleResetCircuitBreaker(c *gin.Context) {
+	serviceID := c.Param("id")
+	if !h.serviceExists(serviceID) {
+		c.JSON(404, models.NewErrorResponse("Service not found", "SERVICE_NOT_FOUND"))
+		return
+	}
+
+	if !h.proxyManager.ResetCircuitBreaker(serviceID) {
+		c.JSON(409, models.NewErrorResponse("Service has no running proxy with a circuit breaker", "SERVICE_NOT_RUNNING"))
+		return
+	}
+	h.auditLog.Record(auditEntry(c, "service.circuit_breaker.reset", serviceID))
+
+	c.JSON(200, models.NewAPIResponse("Circuit breaker reset", map[string]interface{}{
+		"service_id": serviceID,
+	}))
+}
+
+// HandleTerminateConnections handles DELETE /api/admin/services/:id/connections
+// Closes every TCP connection and UDP session of the service, e.g. before restarting its backend
+func (h *AdminServicesHandler) HandleTerminateConnections(c *gin.Context) {
+	serviceID := c.Param("id")
+	if !h.serviceExists(serviceID) {
+		c.JSON(404, models.NewErrorResponse("Service not found", "SERVICE_NOT_FOUND"))
+		return
+	}
+
+	terminated, running := h.proxyManager.TerminateServiceConnections(serviceID)
+	if !running {
+		c.JSON(409, models.NewErrorResponse("Service has no running proxy", "SERVICE_NOT_RUNNING"))
+		return
+	}
+	h.auditLog.Record(auditEntry(c, "service.connections.terminate", serviceID))
+
+	c.JSON(200, models.NewAPIResponse("Service connections terminated", map[string]interface{}{
+		"service_id": serviceID,
+		"terminated": terminated,
+	}))
+}
+
+// HandleTopTalkers handles GET /api/admin/services/:id/top?by=bytes&window=1h&limit=10
+// Returns the client IPs with the most traffic on a service, with their session owner if any
+func (h *AdminServicesHandler) HandleTopTalkers(c *gin.Context) {
+	serviceID := c.Param("id")
+	if !h.serviceExists(serviceID) {
+		c.JSON(404, models.NewErrorResponse("Service not found", "SERVICE_NOT_FOUND"))
+		return
+	}
+
+	by := c.DefaultQuery("by", "bytes")
+	if by != "bytes" && by != "bytes_in" && by != "bytes_out" && by != "connections" {
+		c.JSON(400, models.NewErrorResponse("by must be bytes, bytes_in, bytes_out or connections", "INVALID_REQUEST"))
+		return
+	}
+
+	window, err := parseHistoryDuration(c.DefaultQuery("window", "1h"))
+	if err != nil || window <= 0 {
+		c.JSON(400, models.NewErrorResponse("Invalid window (use e.g. 15m, 1h, 7d)", "INVALID_REQUEST"))
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(400, models.NewErrorResponse("limit must be between 1 and 100", "INVALID_REQUEST"))
+		return
+	}
+
+	talkers := h.history.TopTalkers(serviceID, time.Now().Add(-window), by, limit)
+
+	results := make([]map[string]interface{}, 0, len(talkers))
+	for _, talker := range talkers {
+		entry := map[string]interface{}{
+			"ip":          talker.IP,
+			"bytes_in":    talker.BytesIn,
+			"bytes_out":   talker.BytesOut,
+			"bytes":       talker.BytesIn + talker.BytesOut,
+			"connections": talker.Connections,
+		}
+		if addr, err := netip.ParseAddr(talker.IP); err == nil {
+			if sess, ok := h.sessionManager.FindSessionForIP(addr); ok {
+				entry["session_id"] = sess.SessionID
+				entry["username"] = sess.Username
+			}
+		}
+		results = append(results, entry)
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Top talkers retrieved", map[string]interface{}{
+		"service_id": serviceID,
+		"by":         by,
+		"window":     window.String(),
+		"talkers":    results,
+	}, len(results)))
+}
+
+// serviceStatus summarizes a service: running, degraded (one of several proxies failed), failed, stopped, disabled or firewall
+func serviceStatus(service *config.ProtectedServiceConfig, enabled bool, running, failed int) string {
+	switch {
+	case !enabled:
+		return "disabled"
+	case service.EnforcementMode == "firewall":
+		return "firewall"
+	case failed > 0 && running > 0:
+		return "degraded"
+	case failed > 0:
+		return "failed"
+	case running == 0:
+		return "stopped"
+	default:
+		return "running"
+	}
+}
+
+// serviceExists reports whether a service ID is configured
+func (h *AdminServicesHandler) serviceExists(serviceID string) bool {
+	for _, service := range h.configLoader.GetConfig().ProtectedServices {
+		if service.ServiceID == serviceID {
+			return true
+		}
+	}
+	return false
+}
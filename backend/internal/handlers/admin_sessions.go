@@ -1,12 +1,13 @@
 package handlers
 
 import (
+	"github.com/davbauer/knock-knock-portal/internal/audit"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/middleware"
 	"github.com/davbauer/knock-knock-portal/internal/models"
 	"github.com/davbauer/knock-knock-portal/internal/proxy"
 	"github.com/davbauer/knock-knock-portal/internal/session"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
 )
 
 // AdminSessionsHandler handles admin session management
@@ -14,14 +15,16 @@ type AdminSessionsHandler struct {
 	sessionManager   *session.Manager
 	allowlistManager *ipallowlist.Manager
 	proxyManager     *proxy.Manager
+	auditLog         *audit.Log
 }
 
 // NewAdminSessionsHandler creates a new handler
-func NewAdminSessionsHandler(sessionManager *session.Manager, allowlistManager *ipallowlist.Manager, proxyManager *proxy.Manager) *AdminSessionsHandler {
+func NewAdminSessionsHandler(sessionManager *session.Manager, allowlistManager *ipallowlist.Manager, proxyManager *proxy.Manager, auditLog *audit.Log) *AdminSessionsHandler {
 	return &AdminSessionsHandler{
 		sessionManager:   sessionManager,
 		allowlistManager: allowlistManager,
 		proxyManager:     proxyManager,
+		auditLog:         auditLog,
 	}
 }
 
@@ -101,27 +104,74 @@ func (h *AdminSessionsHandler) HandleDelete(c *gin.Context) {
 		return
 	}
 
-	// Terminate session (removes from session manager)
-	if err := h.sessionManager.TerminateSession(sessionID); err != nil {
+	totalTerminated, err := h.terminateSession(session)
+	if err != nil {
 		c.JSON(404, models.NewErrorResponse("Session not found", "SESSION_NOT_FOUND"))
 		return
 	}
 
-	// Remove session IPs from allowlist instantly
-	h.allowlistManager.RemoveSessionIP(sessionID)
+	log.Info().
+		Str("admin", middleware.GetAdminName(c)).
+		Str("session_id", sessionID).
+		Str("username", session.Username).
+		Int("proxy_sessions_terminated", totalTerminated).
+		Msg("Admin terminated session")
+	h.auditLog.Record(auditEntry(c, "session.terminate", sessionID+" ("+session.Username+")"))
 
-	// Terminate all active proxy sessions for these IPs instantly
+	c.JSON(200, models.NewAPIResponse("Session terminated", nil))
+}
+
+// HandleDeleteByUserID handles DELETE /api/admin/users/by-user-id/:user_id
+// Terminates every session of a portal user at once
+func (h *AdminSessionsHandler) HandleDeleteByUserID(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	sessions := h.sessionManager.GetSessionsByUserID(userID)
+	if len(sessions) == 0 {
+		c.JSON(404, models.NewErrorResponse("User has no active sessions", "SESSION_NOT_FOUND"))
+		return
+	}
+
+	terminatedSessions := 0
 	totalTerminated := 0
-	for _, ip := range session.AuthenticatedIPAddresses {
-		terminated := h.proxyManager.TerminateSessionsByIP(ip.String())
+	for _, sess := range sessions {
+		terminated, err := h.terminateSession(sess)
+		if err != nil {
+			continue // Ended concurrently (logout or expiry)
+		}
+		terminatedSessions++
 		totalTerminated += terminated
 	}
 
 	log.Info().
-		Str("session_id", sessionID).
-		Str("username", session.Username).
+		Str("admin", middleware.GetAdminName(c)).
+		Str("user_id", userID).
+		Int("sessions_terminated", terminatedSessions).
 		Int("proxy_sessions_terminated", totalTerminated).
-		Msg("Admin terminated session")
+		Msg("Admin terminated all sessions of user")
+	h.auditLog.Record(auditEntry(c, "user.sessions.terminate", userID))
 
-	c.JSON(200, models.NewAPIResponse("Session terminated", nil))
+	c.JSON(200, models.NewAPIResponse("User sessions terminated", map[string]interface{}{
+		"sessions_terminated":       terminatedSessions,
+		"proxy_sessions_terminated": totalTerminated,
+	}))
+}
+
+// terminateSession ends a session, removes its IPs from the allowlist and closes its proxy connections
+// Returns the number of proxy connections and UDP sessions closed
+func (h *AdminSessionsHandler) terminateSession(sess *session.Session) (int, error) {
+	// Terminate session (removes from session manager)
+	if err := h.sessionManager.TerminateSession(sess.SessionID); err != nil {
+		return 0, err
+	}
+
+	// Remove session IPs from allowlist instantly
+	h.allowlistManager.RemoveSessionIP(sess.SessionID)
+
+	// Terminate all active proxy sessions for these IPs instantly
+	totalTerminated := 0
+	for _, ip := range sess.AuthenticatedIPAddresses {
+		totalTerminated += h.proxyManager.TerminateSessionsByIP(ip.String())
+	}
+	return totalTerminated, nil
 }
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/davbauer/knock-knock-portal/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxHistoryPoints bounds the number of points returned when no step is given
+const maxHistoryPoints = 720
+
+// AdminStatsHandler serves traffic history for the dashboard graphs
+type AdminStatsHandler struct {
+	history      *stats.History
+	configLoader *config.Loader
+}
+
+// NewAdminStatsHandler creates a new handler
+func NewAdminStatsHandler(history *stats.History, configLoader *config.Loader) *AdminStatsHandler {
+	return &AdminStatsHandler{
+		history:      history,
+		configLoader: configLoader,
+	}
+}
+
+// HandleHistory handles GET /api/admin/stats/history?service=...|ip=...&range=24h&step=5m
+// Without service or ip, lists the services and IPs that have history
+func (h *AdminStatsHandler) HandleHistory(c *gin.Context) {
+	serviceID := c.Query("service")
+	ip := c.Query("ip")
+
+	if serviceID == "" && ip == "" {
+		c.JSON(200, models.NewAPIResponse("Stats history series retrieved", map[string]interface{}{
+			"enabled":        h.history.Enabled(),
+			"retention_days": int(h.history.Retention().Hours() / 24),
+			"services":       h.history.Keys(stats.KindService),
+			"ips":            h.history.Keys(stats.KindIP),
+		}))
+		return
+	}
+	if serviceID != "" && ip != "" {
+		c.JSON(400, models.NewErrorResponse("Specify either service or ip, not both", "INVALID_REQUEST"))
+		return
+	}
+
+	kind, key := stats.KindService, serviceID
+	if ip != "" {
+		if net.ParseIP(ip) == nil {
+			c.JSON(400, models.NewErrorResponse("Invalid IP address", "INVALID_REQUEST"))
+			return
+		}
+		kind, key = stats.KindIP, ip
+	}
+
+	window, err := parseHistoryDuration(c.DefaultQuery("range", "24h"))
+	if err != nil || window <= 0 {
+		c.JSON(400, models.NewErrorResponse("Invalid range (use e.g. 1h, 24h, 7d)", "INVALID_REQUEST"))
+		return
+	}
+	if retention := h.history.Retention(); retention > 0 && window > retention {
+		window = retention
+	}
+
+	step := window / maxHistoryPoints
+	if raw := c.Query("step"); raw != "" {
+		step, err = parseHistoryDuration(raw)
+		if err != nil || step <= 0 || window/step > 10*maxHistoryPoints {
+			c.JSON(400, models.NewErrorResponse("Invalid step", "INVALID_REQUEST"))
+			return
+		}
+	}
+	if step < time.Minute {
+		step = time.Minute
+	}
+
+	points := h.history.Query(kind, key, time.Now().Add(-window), step)
+
+	c.JSON(200, models.NewAPIResponse("Stats history retrieved", map[string]interface{}{
+		kind:     key,
+		"range":  window.String(),
+		"step":   step.Truncate(time.Minute).String(),
+		"points": points,
+	}))
+}
+
+// HandleUptime handles GET /api/admin/backends/:id/uptime?range=7d&step=1h
+// Returns the share of passed health checks, incidents and the latency trend of a service's backend
+func (h *AdminStatsHandler) HandleUptime(c *gin.Context) {
+	serviceID := c.Param("id")
+	if utils.GetServiceByID(h.configLoader.GetConfig(), serviceID) == nil {
+		c.JSON(404, models.NewErrorResponse("Service not found", "SERVICE_NOT_FOUND"))
+		return
+	}
+
+	window, err := parseHistoryDuration(c.DefaultQuery("range", "7d"))
+	if err != nil || window <= 0 {
+		c.JSON(400, models.NewErrorResponse("Invalid range (use e.g. 24h, 7d, 30d)", "INVALID_REQUEST"))
+		return
+	}
+	if retention := h.history.UptimeRetention(); window > retention {
+		window = retention
+	}
+
+	// Hourly points up to a week, daily beyond
+	step := time.Hour
+	if window > 7*24*time.Hour {
+		step = 24 * time.Hour
+	}
+	if raw := c.Query("step"); raw != "" {
+		step, err = parseHistoryDuration(raw)
+		if err != nil || step < time.Hour || window/step > maxHistoryPoints {
+			c.JSON(400, models.NewErrorResponse("Invalid step (at least 1h)", "INVALID_REQUEST"))
+			return
+		}
+	}
+
+	report := h.history.Uptime(serviceID, time.Now().Add(-window), step)
+
+	c.JSON(200, models.NewAPIResponse("Uptime retrieved", map[string]interface{}{
+		"service_id":     serviceID,
+		"range":          window.String(),
+		"step":           step.Truncate(time.Hour).String(),
+		"checks":         report.Checks,
+		"uptime_percent": report.UptimePercent,
+		"avg_latency_ms": report.AvgLatencyMs,
+		"incidents":      report.Incidents,
+		"trend":          report.Trend,
+	}))
+}
+
+// parseHistoryDuration parses a Go duration, additionally accepting whole days ("7d")
+func parseHistoryDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
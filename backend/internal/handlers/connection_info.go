@@ -4,6 +4,7 @@ import (
 	"net"
 
 	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/geoip"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
 	"github.com/davbauer/knock-knock-portal/internal/middleware"
@@ -19,16 +20,18 @@ type ConnectionInfoHandler struct {
 	sessionManager     *session.Manager
 	configLoader       *config.Loader
 	ipExtractor        *middleware.RealIPExtractor
+	geoResolver        *geoip.Resolver
 }
 
 // NewConnectionInfoHandler creates a new connection info handler
-func NewConnectionInfoHandler(ipAllowListManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, sessionManager *session.Manager, configLoader *config.Loader, ipExtractor *middleware.RealIPExtractor) *ConnectionInfoHandler {
+func NewConnectionInfoHandler(ipAllowListManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, sessionManager *session.Manager, configLoader *config.Loader, ipExtractor *middleware.RealIPExtractor, geoResolver *geoip.Resolver) *ConnectionInfoHandler {
 	return &ConnectionInfoHandler{
 		ipAllowListManager: ipAllowListManager,
 		blocklistManager:   blocklistManager,
 		sessionManager:     sessionManager,
 		configLoader:       configLoader,
 		ipExtractor:        ipExtractor,
+		geoResolver:        geoResolver,
 	}
 }
 
@@ -46,14 +49,14 @@ func (h *ConnectionInfoHandler) HandleCheck(c *gin.Context) {
 	clientIPStr := clientIP.String()
 
 	// HIGHEST PRIORITY: Check if IP is blocked
-	if blocked, blockReason := h.blocklistManager.IsIPBlocked(net.ParseIP(clientIPStr)); blocked {
-		// IP is blocked - return immediate denial
-		c.JSON(403, models.NewAPIResponse("Connection info retrieved", map[string]interface{}{
+	// The reason names the matching entry (e.g. the honeypot or a CIDR range), so only admin views show it
+	if blocked, _ := h.blocklistManager.IsIPBlocked(net.ParseIP(clientIPStr)); blocked {
+		c.JSON(200, models.NewAPIResponse("Connection info retrieved", map[string]interface{}{
 			"client_ip":          clientIPStr,
 			"allowed":            false,
 			"blocked":            true,
 			"access_method":      "blocked",
-			"access_description": "Your IP is blocked: " + blockReason,
+			"access_description": "Access from your IP address is blocked. Logging in is not possible from this network; contact the administrator if you think this is a mistake.",
 			"services":           []interface{}{},
 			"total_services":     0,
 			"session_active":     false,
@@ -71,6 +74,12 @@ func (h *ConnectionInfoHandler) HandleCheck(c *gin.Context) {
 	response := map[string]interface{}{
 		"client_ip": clientIPStr,
 		"allowed":   allowed,
+		"blocked":   false,
+	}
+
+	// Where the client connects from, when GeoIP databases are configured
+	if location := h.geoResolver.Lookup(clientIP); location != nil {
+		response["location"] = location
 	}
 
 	// Add proxy warning if present
@@ -104,6 +113,9 @@ func (h *ConnectionInfoHandler) HandleCheck(c *gin.Context) {
 		case "dns_resolved":
 			response["access_method"] = "dynamic_dns_hostname"
 			response["access_description"] = "Your IP matches an allowed dynamic DNS hostname"
+		case "hairpin":
+			response["access_method"] = "hairpin_lan_range"
+			response["access_description"] = "Your IP is in a LAN range reaching the portal through NAT reflection"
 		case "session":
 			response["access_method"] = "authenticated_session"
 			response["access_description"] = "Access granted via authenticated session"
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/middleware"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/schedule"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/davbauer/knock-knock-portal/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// ForwardAuthHandler answers access checks delegated by external reverse proxies
+// (Traefik forwardAuth, nginx auth_request, Caddy forward_auth)
+type ForwardAuthHandler struct {
+	ipAllowListManager *ipallowlist.Manager
+	blocklistManager   *ipblocklist.Manager
+	sessionManager     *session.Manager
+	configLoader       *config.Loader
+}
+
+// NewForwardAuthHandler creates a new forward-auth handler
+func NewForwardAuthHandler(ipAllowListManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, sessionManager *session.Manager, configLoader *config.Loader) *ForwardAuthHandler {
+	return &ForwardAuthHandler{
+		ipAllowListManager: ipAllowListManager,
+		blocklistManager:   blocklistManager,
+		sessionManager:     sessionManager,
+		configLoader:       configLoader,
+	}
+}
+
+// HandleVerify processes GET /api/auth/verify?service_id=<id>
+// Returns 200 if the caller's real IP may access the service (or any service when no id is given), 401 otherwise
+// The calling proxy must be listed in trusted_proxy_config so the forwarded client IP is used
+func (h *ForwardAuthHandler) HandleVerify(c *gin.Context) {
+	clientIP, ok := middleware.GetClientIP(c)
+	if !ok || !clientIP.IsValid() {
+		c.JSON(400, models.NewErrorResponse("Could not determine client IP", "INVALID_IP"))
+		return
+	}
+
+	cfg := h.configLoader.GetConfig()
+
+	serviceID := c.Query("service_id")
+	var service *config.ProtectedServiceConfig
+	if serviceID != "" {
+		service = utils.GetServiceByID(cfg, serviceID)
+		if service == nil || !service.Enabled {
+			c.JSON(400, models.NewErrorResponse("Unknown or disabled service", "UNKNOWN_SERVICE"))
+			return
+		}
+	}
+
+	// HIGHEST PRIORITY: Check if IP is blocked
+	if blocked, _ := h.blocklistManager.IsIPBlocked(net.ParseIP(clientIP.String())); blocked {
+		h.deny(c, clientIP.String(), serviceID, "blocked")
+		return
+	}
+
+	allowed, reason := h.ipAllowListManager.IsIPAllowed(clientIP)
+	if !allowed {
+		h.deny(c, clientIP.String(), serviceID, reason)
+		return
+	}
+
+	// Session grants may be limited to specific services and schedules; any session covering the IP may grant access
+	var sess *session.Session
+	if reason == string(ipallowlist.EntryTypeSession) {
+		granted, allowed, denyReason := schedule.SessionAccess(cfg, h.sessionManager.FindSessionsForIP(clientIP), service, time.Now())
+		if !allowed {
+			h.deny(c, clientIP.String(), serviceID, denyReason)
+			return
+		}
+		sess = granted
+	}
+
+	// Pass identity to the downstream proxy
+	for name, value := range buildForwardAuthHeaders(cfg, service, sess, clientIP.String(), reason) {
+		c.Header(name, value)
+	}
+
+	c.JSON(200, models.NewAPIResponse("Access granted", map[string]interface{}{
+		"client_ip":  clientIP.String(),
+		"service_id": serviceID,
+		"reason":     reason,
+	}))
+}
+
+// deny writes the 401 response expected by forward-auth integrations
+func (h *ForwardAuthHandler) deny(c *gin.Context, clientIP, serviceID, reason string) {
+	log.Debug().
+		Str("client_ip", clientIP).
+		Str("service_id", serviceID).
+		Str("reason", reason).
+		Msg("Forward-auth request denied")
+
+	c.JSON(401, models.NewErrorResponse("Access denied", "ACCESS_DENIED"))
+}
+
+// buildForwardAuthHeaders renders the configured identity headers for an allowed request
+func buildForwardAuthHeaders(cfg *config.ApplicationConfig, service *config.ProtectedServiceConfig, sess *session.Session, clientIP, accessMethod string) map[string]string {
+	templates := make(map[string]string, len(cfg.ForwardAuthConfig.ResponseHeaders))
+	for name, value := range cfg.ForwardAuthConfig.ResponseHeaders {
+		templates[name] = value
+	}
+	if service != nil {
+		for name, value := range service.ForwardAuthResponseHeaders {
+			templates[name] = value
+		}
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+
+	// Services the caller may reach: the session's allowed list, or every enabled service
+	var allowedServiceIDs []string
+	if sess != nil {
+		allowedServiceIDs = sess.AllowedServiceIDs
+	}
+	allowedServiceIDs = utils.GetAllowedServiceIDs(cfg, allowedServiceIDs)
+
+	values := []string{
+		"{client_ip}", clientIP,
+		"{access_method}", accessMethod,
+		"{services}", strings.Join(allowedServiceIDs, ","),
+		"{service_names}", strings.Join(utils.GetServiceNames(cfg, allowedServiceIDs), ","),
+	}
+	if sess != nil {
+		values = append(values,
+			"{user}", sess.Username,
+			"{user_id}", sess.UserID,
+			"{session_expires}", sess.ExpiresAt.UTC().Format(time.RFC3339),
+		)
+	} else {
+		values = append(values, "{user}", "", "{user_id}", "", "{session_expires}", "")
+	}
+	if service != nil {
+		values = append(values, "{service_id}", service.ServiceID, "{service_name}", service.ServiceName)
+	} else {
+		values = append(values, "{service_id}", "", "{service_name}", "")
+	}
+	replacer := strings.NewReplacer(values...)
+
+	headers := make(map[string]string, len(templates))
+	for name, template := range templates {
+		if value := strings.TrimSpace(replacer.Replace(template)); value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
@@ -4,20 +4,23 @@ import (
 	"time"
 
 	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/proxy"
 	"github.com/gin-gonic/gin"
 )
 
 // HealthHandler handles health checks
 type HealthHandler struct {
-	startTime time.Time
-	version   string
+	startTime    time.Time
+	version      string
+	proxyManager *proxy.Manager
 }
 
 // NewHealthHandler creates a new health handler
-func NewHealthHandler(version string) *HealthHandler {
+func NewHealthHandler(version string, proxyManager *proxy.Manager) *HealthHandler {
 	return &HealthHandler{
-		startTime: time.Now(),
-		version:   version,
+		startTime:    time.Now(),
+		version:      version,
+		proxyManager: proxyManager,
 	}
 }
 
@@ -25,11 +28,64 @@ func NewHealthHandler(version string) *HealthHandler {
 func (h *HealthHandler) Handle(c *gin.Context) {
 	uptime := time.Since(h.startTime).Seconds()
 
+	// Only counts here: the endpoint is public, details are in /api/admin/health/detailed
+	failures := h.proxyManager.Failures()
+	backends := h.proxyManager.BackendHealth()
+	unreachable, circuitsOpen := countBackendProblems(backends)
+
+	status, message := "healthy", "Service healthy"
+	if len(failures) > 0 || unreachable > 0 || circuitsOpen > 0 {
+		status, message = "degraded", "Some proxies or backends are down"
+	}
+
 	response := map[string]interface{}{
-		"status":         "healthy",
+		"status":         status,
 		"version":        h.version,
 		"uptime_seconds": int(uptime),
+		"proxies": map[string]interface{}{
+			"running": h.proxyManager.ActiveProxyCount(),
+			"failed":  len(failures),
+		},
+		"backends": map[string]interface{}{
+			"total":         len(backends),
+			"unreachable":   unreachable,
+			"circuits_open": circuitsOpen,
+		},
+	}
+
+	c.JSON(200, models.NewAPIResponse(message, response))
+}
+
+// HandleDetailed handles GET /api/admin/health/detailed
+// Returns the last probe of every backend with its circuit breaker and DNS resolution, and the proxies that failed to start
+func (h *HealthHandler) HandleDetailed(c *gin.Context) {
+	failures := h.proxyManager.Failures()
+	backends := h.proxyManager.BackendHealth()
+	unreachable, circuitsOpen := countBackendProblems(backends)
+
+	status := "healthy"
+	if len(failures) > 0 || unreachable > 0 || circuitsOpen > 0 {
+		status = "degraded"
 	}
 
-	c.JSON(200, models.NewAPIResponse("Service healthy", response))
+	c.JSON(200, models.NewAPIResponse("Detailed health retrieved", map[string]interface{}{
+		"status":         status,
+		"version":        h.version,
+		"uptime_seconds": int(time.Since(h.startTime).Seconds()),
+		"failures":       failures,
+		"backends":       backends,
+	}))
+}
+
+// countBackendProblems counts backends whose last probe failed and backends with a circuit breaker that is not closed
+func countBackendProblems(backends []proxy.BackendHealth) (unreachable, circuitsOpen int) {
+	for _, backend := range backends {
+		if backend.Probe != nil && !backend.Probe.Reachable {
+			unreachable++
+		}
+		if state, _ := backend.CircuitBreaker["state"].(string); state != "" && state != "closed" {
+			circuitsOpen++
+		}
+	}
+	return unreachable, circuitsOpen
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/proxy"
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsHandler serves the proxy latency histograms to Prometheus
+type MetricsHandler struct {
+	configLoader *config.Loader
+	proxyManager *proxy.Manager
+}
+
+// NewMetricsHandler creates a new metrics handler
+func NewMetricsHandler(configLoader *config.Loader, proxyManager *proxy.Manager) *MetricsHandler {
+	return &MetricsHandler{
+		configLoader: configLoader,
+		proxyManager: proxyManager,
+	}
+}
+
+// Handle handles GET /metrics
+// Answers 404 while metrics_config is disabled so the endpoint does not reveal itself
+func (h *MetricsHandler) Handle(c *gin.Context) {
+	cfg := h.configLoader.GetConfig().MetricsConfig
+	if !cfg.Enabled {
+		c.JSON(404, models.NewErrorResponse("Not found", "NOT_FOUND"))
+		return
+	}
+
+	if cfg.BearerToken != "" {
+		expected := []byte("Bearer " + cfg.BearerToken)
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.JSON(401, models.NewErrorResponse("Invalid or missing metrics token", "INVALID_METRICS_TOKEN"))
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := h.proxyManager.WriteMetrics(&buf); err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to collect metrics", "METRICS_FAILED"))
+		return
+	}
+	c.Data(200, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
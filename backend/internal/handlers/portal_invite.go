@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/invite"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/middleware"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// minPortalPasswordLength is the shortest password accepted at self-registration and password changes
+const minPortalPasswordLength = 8
+
+// RedeemInviteRequest is the body of POST /api/portal/invites/:code/redeem
+type RedeemInviteRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// PortalInviteHandler handles self-registration with invite codes
+type PortalInviteHandler struct {
+	inviteManager    *invite.Manager
+	configLoader     *config.Loader
+	blocklistManager *ipblocklist.Manager
+	rateLimiter      *auth.RateLimiter
+}
+
+// NewPortalInviteHandler creates a new portal invite handler
+func NewPortalInviteHandler(inviteManager *invite.Manager, configLoader *config.Loader, blocklistManager *ipblocklist.Manager) *PortalInviteHandler {
+	return &PortalInviteHandler{
+		inviteManager:    inviteManager,
+		configLoader:     configLoader,
+		blocklistManager: blocklistManager,
+		rateLimiter:      newLoginRateLimiter(configLoader, portalLoginLimit, defaultPortalLoginLimits),
+	}
+}
+
+// HandleGet returns what a valid invite grants, so the registration page can show it
+func (h *PortalInviteHandler) HandleGet(c *gin.Context) {
+	clientIP, ok := h.admit(c)
+	if !ok {
+		return
+	}
+
+	inv, err := h.inviteManager.Get(c.Param("code"))
+	if err != nil {
+		// Guessing codes counts as failed attempts
+		if errors.Is(err, invite.ErrNotFound) {
+			h.rateLimiter.RecordFailedLogin(auth.LoginInvite, clientIP, "")
+		}
+		h.writeInviteError(c, err)
+		return
+	}
+
+	cfg := h.configLoader.GetConfig()
+	c.JSON(200, models.NewAPIResponse("Invite is valid", map[string]interface{}{
+		"allowed_services": utils.GetServiceNames(cfg, inv.AllowedServiceIDs),
+		"expires_at":       inv.ExpiresAt,
+	}))
+}
+
+// HandleRedeem creates the invitee's portal account
+func (h *PortalInviteHandler) HandleRedeem(c *gin.Context) {
+	clientIP, ok := h.admit(c)
+	if !ok {
+		return
+	}
+
+	var req RedeemInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, models.NewErrorResponse("Invalid request body", "INVALID_REQUEST"))
+		return
+	}
+
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" || len(req.Username) > 64 {
+		c.JSON(400, models.NewErrorResponse("Username must be between 1 and 64 characters", "INVALID_USERNAME"))
+		return
+	}
+	if len(req.Password) < minPortalPasswordLength || len(req.Password) > 72 {
+		c.JSON(400, models.NewErrorResponse("Password must be between 8 and 72 characters", "INVALID_PASSWORD"))
+		return
+	}
+
+	account, err := h.inviteManager.Redeem(c.Param("code"), req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, invite.ErrNotFound) {
+			h.rateLimiter.RecordFailedLogin(auth.LoginInvite, clientIP, "")
+		}
+		h.writeInviteError(c, err)
+		return
+	}
+
+	c.JSON(201, models.NewAPIResponse("Registration successful, you can now log in", map[string]interface{}{
+		"username": account.Username,
+	}))
+}
+
+// admit rejects blocked and rate-limited clients and returns the client IP
+func (h *PortalInviteHandler) admit(c *gin.Context) (string, bool) {
+	clientIP, ok := middleware.GetClientIP(c)
+	if !ok || !clientIP.IsValid() {
+		c.JSON(400, models.NewErrorResponse("Could not determine client IP", "INVALID_IP"))
+		return "", false
+	}
+
+	if blocked, _ := h.blocklistManager.IsIPBlocked(net.ParseIP(clientIP.String())); blocked {
+		c.JSON(403, models.NewErrorResponse("Access denied", "IP_BLOCKED"))
+		return "", false
+	}
+
+	if !h.rateLimiter.Allow(clientIP.String()) {
+		c.JSON(429, models.NewErrorResponse("Too many attempts, please try again later", "RATE_LIMIT_EXCEEDED"))
+		return "", false
+	}
+	return clientIP.String(), true
+}
+
+// writeInviteError maps invite errors to responses
+func (h *PortalInviteHandler) writeInviteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, invite.ErrNotFound):
+		c.JSON(404, models.NewErrorResponse("Invite not found", "INVITE_NOT_FOUND"))
+	case errors.Is(err, invite.ErrExpired):
+		c.JSON(410, models.NewErrorResponse("Invite has expired", "INVITE_EXPIRED"))
+	case errors.Is(err, invite.ErrRedeemed):
+		c.JSON(410, models.NewErrorResponse("Invite has already been used", "INVITE_REDEEMED"))
+	case errors.Is(err, invite.ErrUsernameTaken):
+		c.JSON(409, models.NewErrorResponse("Username is already taken", "USERNAME_TAKEN"))
+	default:
+		log.Error().Err(err).Msg("Failed to redeem invite")
+		c.JSON(500, models.NewErrorResponse("Failed to complete registration", "INTERNAL_ERROR"))
+	}
+}
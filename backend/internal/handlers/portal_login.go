@@ -1,25 +1,50 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net"
+	"net/netip"
 	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/approval"
 	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/bruteforce"
+	"github.com/davbauer/knock-knock-portal/internal/captcha"
 	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/loginhistory"
 	"github.com/davbauer/knock-knock-portal/internal/middleware"
 	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/schedule"
 	"github.com/davbauer/knock-knock-portal/internal/session"
 	"github.com/davbauer/knock-knock-portal/internal/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
 )
 
 // PortalLoginRequest is the login request body
 type PortalLoginRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Username     string `json:"username" binding:"required"`
+	Password     string `json:"password" binding:"required"`
+	CaptchaToken string `json:"captcha_token"` // Required when GET /api/portal/captcha reports required
+}
+
+// Login rate limits for values not set in proxy_server_config.login_rate_limits
+var (
+	defaultPortalLoginLimits = auth.LimiterSettings{RequestsPerMinute: 10, Burst: 5, MaxEntries: 5000}
+	defaultAdminLoginLimits  = auth.LimiterSettings{RequestsPerMinute: 5, Burst: 3, MaxEntries: 1000}
+)
+
+// dummyPasswordHash is verified against when a username is unknown, so lookups take the same time
+const dummyPasswordHash = "$2a$10$AAAAAAAAAAAAAAAAAAAAAO1234567890123456789012345678"
+
+// ChangePasswordRequest is the body of POST /api/portal/account/change-password
+type ChangePasswordRequest struct {
+	Username        string `json:"username" binding:"required"`
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+	CaptchaToken    string `json:"captcha_token"`
 }
 
 // PortalLoginHandler handles portal user login
@@ -30,6 +55,9 @@ type PortalLoginHandler struct {
 	sessionManager   *session.Manager
 	allowlistManager *ipallowlist.Manager
 	blocklistManager *ipblocklist.Manager
+	approvalManager  *approval.Manager
+	loginHistory     *loginhistory.History
+	bruteForce       *bruteforce.Guard
 	rateLimiter      *auth.RateLimiter
 }
 
@@ -41,6 +69,9 @@ func NewPortalLoginHandler(
 	sessionManager *session.Manager,
 	allowlistManager *ipallowlist.Manager,
 	blocklistManager *ipblocklist.Manager,
+	approvalManager *approval.Manager,
+	loginHistory *loginhistory.History,
+	bruteForce *bruteforce.Guard,
 ) *PortalLoginHandler {
 	return &PortalLoginHandler{
 		configLoader:     configLoader,
@@ -49,7 +80,10 @@ func NewPortalLoginHandler(
 		sessionManager:   sessionManager,
 		allowlistManager: allowlistManager,
 		blocklistManager: blocklistManager,
-		rateLimiter:      auth.NewRateLimiter(10, 5, 5000), // 10/min, burst 5, max 5000 IPs
+		approvalManager:  approvalManager,
+		loginHistory:     loginHistory,
+		bruteForce:       bruteForce,
+		rateLimiter:      newLoginRateLimiter(configLoader, portalLoginLimit, defaultPortalLoginLimits),
 	}
 }
 
@@ -85,11 +119,256 @@ func (h *PortalLoginHandler) Handle(c *gin.Context) {
 		return
 	}
 
-	// Find user in config
 	cfg := h.configLoader.GetConfig()
+
+	// CAPTCHA is checked before the password so it can't be bypassed by guessing
+	if !h.verifyCaptcha(c, cfg, clientIP, req.CaptchaToken, req.Username) {
+		return
+	}
+
+	user, ok := h.authenticate(c, cfg, clientIP, req.Username, req.Password)
+	if !ok {
+		return
+	}
+	// Settings of the user's groups apply from here on
+	user = config.EffectiveUser(cfg, user)
+
+	// An admin asked this user to pick a new password (POST /api/portal/account/change-password)
+	if user.MustChangePassword {
+		h.recordAttempt(c, user.UserID, clientIP, loginhistory.ResultFailed, "password_change_required")
+		c.JSON(403, models.NewErrorResponse("You must change your password before signing in", "PASSWORD_CHANGE_REQUIRED"))
+		log.Info().
+			Str("username", user.Username).
+			Str("client_ip", clientIP.String()).
+			Msg("Login refused until password is changed")
+		return
+	}
+
+	// Users with a schedule can only log in during their access windows
+	if !schedule.IsOpen(user.Schedule, time.Now()) {
+		h.recordAttempt(c, user.UserID, clientIP, loginhistory.ResultFailed, "outside_schedule")
+		c.JSON(403, models.NewErrorResponse("Access is not available at this time", "OUTSIDE_SCHEDULE"))
+		log.Warn().
+			Str("username", user.Username).
+			Str("client_ip", clientIP.String()).
+			Msg("Login rejected: outside user schedule")
+		return
+	}
+
+	// When approval is required the session is only created once an admin approves
+	if h.approvalManager.Required(user) {
+		req := h.approvalManager.Submit(user, clientIP)
+		h.recordAttempt(c, user.UserID, clientIP, loginhistory.ResultPendingApproval, "")
+		c.JSON(202, models.NewAPIResponse("Login is awaiting admin approval", map[string]interface{}{
+			"request_id": req.ID,
+			"status":     req.Status,
+			"expires_at": req.ExpiresAt,
+		}))
+		return
+	}
+
+	// Create session
+	sess, err := h.sessionManager.CreateSession(
+		user.UserID,
+		user.Username,
+		clientIP,
+		user.AllowedServiceIDs,
+		session.LimitsFor(user),
+	)
+	if errors.Is(err, session.ErrUserSessionLimit) {
+		h.recordAttempt(c, user.UserID, clientIP, loginhistory.ResultFailed, "session_limit_reached")
+		c.JSON(429, models.NewErrorResponse("Too many active sessions for this account", "SESSION_LIMIT_REACHED"))
+		log.Warn().
+			Str("username", user.Username).
+			Str("client_ip", clientIP.String()).
+			Int("max_sessions", user.MaxSessions).
+			Msg("Login rejected: user session limit reached")
+		return
+	}
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to create session", "INTERNAL_ERROR"))
+		log.Error().Err(err).Msg("Failed to create session")
+		return
+	}
+
+	// Add IP (or its configured grant prefix) to allowlist
+	h.allowlistManager.AddSessionPrefix(sess.SessionID, sess.GrantPrefix(clientIP), sess.ExpiresAt)
+
+	response, err := h.buildSessionResponse(cfg, sess, clientIP)
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to generate token", "INTERNAL_ERROR"))
+		log.Error().Err(err).Msg("Failed to generate JWT token")
+		return
+	}
+
+	h.recordAttempt(c, user.UserID, clientIP, loginhistory.ResultSuccess, "")
+
+	log.Info().
+		Str("username", user.Username).
+		Str("user_id", user.UserID).
+		Str("client_ip", clientIP.String()).
+		Msg("User logged in successfully")
+
+	c.JSON(200, models.NewAPIResponse("Login successful", response))
+}
+
+// HandleRequestStatus reports the state of a pending approval request
+// Once approved, the response carries the same session payload as a direct login
+func (h *PortalLoginHandler) HandleRequestStatus(c *gin.Context) {
+	clientIP, ok := middleware.GetClientIP(c)
+	if !ok || !clientIP.IsValid() {
+		c.JSON(400, models.NewErrorResponse("Could not determine client IP", "INVALID_IP"))
+		return
+	}
+
+	// Only the client that logged in may poll its request
+	req, found := h.approvalManager.Get(c.Param("id"))
+	if !found || req.ClientIP != clientIP.String() {
+		c.JSON(404, models.NewErrorResponse("Approval request not found", "REQUEST_NOT_FOUND"))
+		return
+	}
+
+	if req.Status != approval.StatusApproved {
+		c.JSON(200, models.NewAPIResponse("Approval request status", map[string]interface{}{
+			"request_id": req.ID,
+			"status":     req.Status,
+			"expires_at": req.ExpiresAt,
+		}))
+		return
+	}
+
+	sess, err := h.sessionManager.GetSessionByID(req.SessionID)
+	if err != nil {
+		c.JSON(410, models.NewErrorResponse("Session has ended", "SESSION_ENDED"))
+		return
+	}
+
+	response, err := h.buildSessionResponse(h.configLoader.GetConfig(), sess, clientIP)
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to generate token", "INTERNAL_ERROR"))
+		log.Error().Err(err).Msg("Failed to generate JWT token")
+		return
+	}
+	response["request_id"] = req.ID
+	response["status"] = req.Status
+
+	c.JSON(200, models.NewAPIResponse("Login approved", response))
+}
+
+// HandleChangePassword handles POST /api/portal/account/change-password
+// The current password is required, so this also serves users locked out by an admin-forced reset
+func (h *PortalLoginHandler) HandleChangePassword(c *gin.Context) {
+	clientIP, ok := middleware.GetClientIP(c)
+	if !ok || !clientIP.IsValid() {
+		c.JSON(400, models.NewErrorResponse("Could not determine client IP", "INVALID_IP"))
+		return
+	}
+
+	// Same protections as login: this endpoint also confirms a password
+	if blocked, blockReason := h.blocklistManager.IsIPBlocked(net.ParseIP(clientIP.String())); blocked {
+		c.JSON(403, models.NewErrorResponse("Access denied", "IP_BLOCKED"))
+		log.Warn().
+			Str("client_ip", clientIP.String()).
+			Str("reason", blockReason).
+			Msg("Password change from blocked IP denied")
+		return
+	}
+	if !h.rateLimiter.Allow(clientIP.String()) {
+		c.JSON(429, models.NewErrorResponse("Too many attempts, please try again later", "RATE_LIMIT_EXCEEDED"))
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, models.NewErrorResponse("Invalid request body", "INVALID_REQUEST"))
+		return
+	}
+	if len(req.NewPassword) < minPortalPasswordLength || len(req.NewPassword) > 72 {
+		c.JSON(400, models.NewErrorResponse("Password must be between 8 and 72 characters", "INVALID_PASSWORD"))
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(400, models.NewErrorResponse("New password must differ from the current one", "PASSWORD_UNCHANGED"))
+		return
+	}
+
+	cfg := h.configLoader.GetConfig()
+	if !h.verifyCaptcha(c, cfg, clientIP, req.CaptchaToken, req.Username) {
+		return
+	}
+
+	user, ok := h.authenticate(c, cfg, clientIP, req.Username, req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to change password", "INTERNAL_ERROR"))
+		log.Error().Err(err).Msg("Failed to hash password")
+		return
+	}
+
+	err = h.configLoader.UpdatePortalUser(user.UserID, func(account *config.PortalUserAccount) {
+		account.BcryptHashedPassword = hash
+		account.MustChangePassword = false
+	})
+	if err != nil {
+		c.JSON(500, models.NewErrorResponse("Failed to change password", "INTERNAL_ERROR"))
+		log.Error().Err(err).Str("username", user.Username).Msg("Failed to save changed password")
+		return
+	}
+
+	log.Info().
+		Str("username", user.Username).
+		Str("client_ip", clientIP.String()).
+		Bool("was_forced", user.MustChangePassword).
+		Msg("Portal user changed password")
+
+	c.JSON(200, models.NewAPIResponse("Password changed", nil))
+}
+
+// HandleLoginHistory handles GET /api/portal/account/login-history
+// Lists the recent login attempts on the signed-in user's account
+func (h *PortalLoginHandler) HandleLoginHistory(c *gin.Context) {
+	claims, ok := middleware.GetJWTClaims(c)
+	if !ok {
+		c.JSON(401, models.NewErrorResponse("Unauthorized", "UNAUTHORIZED"))
+		return
+	}
+
+	attempts := h.loginHistory.List(claims.UserID)
+	c.JSON(200, models.NewAPIResponseWithCount("Login history retrieved", attempts, len(attempts)))
+}
+
+// recordAttempt adds a login attempt to the user's login history
+func (h *PortalLoginHandler) recordAttempt(c *gin.Context, userID string, clientIP netip.Addr, result, reason string) {
+	h.loginHistory.Record(userID, loginhistory.Attempt{
+		ClientIP:  clientIP.String(),
+		UserAgent: c.Request.UserAgent(),
+		Result:    result,
+		Reason:    reason,
+	})
+}
+
+// authenticate verifies a username and password in constant time
+// On failure the 401 response is written, the failure counts against the client IP and
+// username and, for an existing user, it is added to their login history
+func (h *PortalLoginHandler) authenticate(c *gin.Context, cfg *config.ApplicationConfig, clientIP netip.Addr, username, password string) (*config.PortalUserAccount, bool) {
+	// Usernames locked after too many failures are refused before the password is checked
+	if _, locked := h.bruteForce.LockedUntil(auth.LoginPortal, username); locked {
+		c.JSON(429, models.NewErrorResponse("Too many failed logins for this account, please try again later", "ACCOUNT_LOCKED"))
+		log.Warn().
+			Str("username", username).
+			Str("client_ip", clientIP.String()).
+			Msg("Login attempt for locked username refused")
+		return nil, false
+	}
+
+	// Find user in config
 	var user *config.PortalUserAccount
 	for i := range cfg.PortalUserAccounts {
-		if cfg.PortalUserAccounts[i].Username == req.Username {
+		if cfg.PortalUserAccounts[i].Username == username {
 			user = &cfg.PortalUserAccounts[i]
 			break
 		}
@@ -102,74 +381,156 @@ func (h *PortalLoginHandler) Handle(c *gin.Context) {
 		passwordHash = user.BcryptHashedPassword
 	} else {
 		// Use a dummy hash with same computational cost as real bcrypt
-		passwordHash = "$2a$10$AAAAAAAAAAAAAAAAAAAAAO1234567890123456789012345678"
+		passwordHash = dummyPasswordHash
 	}
 
 	// Verify password (always performed)
-	passwordErr := h.passwordVerifier.VerifyUserPassword(req.Password, passwordHash)
+	passwordErr := h.passwordVerifier.VerifyUserPassword(password, passwordHash)
 
 	// Check if user exists and password is valid
 	if user == nil || passwordErr != nil {
-		h.rateLimiter.RecordFailure(clientIP.String())
+		h.rateLimiter.RecordFailedLogin(auth.LoginPortal, clientIP.String(), username)
+		h.bruteForce.RecordFailure(auth.LoginPortal, clientIP, username)
+		if user != nil {
+			h.recordAttempt(c, user.UserID, clientIP, loginhistory.ResultFailed, "invalid_password")
+		}
 		c.JSON(401, models.NewErrorResponse("Invalid username or password", "INVALID_CREDENTIALS"))
 		log.Warn().
-			Str("username", req.Username).
+			Str("username", username).
 			Str("client_ip", clientIP.String()).
 			Bool("user_found", user != nil).
 			Msg("Login attempt failed")
-		return
+		return nil, false
 	}
 
 	// Record successful authentication to reset rate limit backoff
 	h.rateLimiter.RecordSuccess(clientIP.String())
+	h.bruteForce.RecordSuccess(auth.LoginPortal, username)
+	return user, true
+}
 
-	// Create session
-	sess, err := h.sessionManager.CreateSession(
-		user.UserID,
-		user.Username,
-		clientIP,
-		user.AllowedServiceIDs,
-	)
+// verifyCaptcha checks the CAPTCHA token when this client needs one
+// Returns false after writing the error response
+func (h *PortalLoginHandler) verifyCaptcha(c *gin.Context, cfg *config.ApplicationConfig, clientIP netip.Addr, token, username string) bool {
+	if !h.captchaRequired(&cfg.CaptchaConfig, clientIP.String()) {
+		return true
+	}
+	if token == "" {
+		c.JSON(403, models.NewErrorResponse("CAPTCHA verification required", "CAPTCHA_REQUIRED"))
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	err := captcha.Verify(ctx, cfg.CaptchaConfig.Provider, cfg.CaptchaConfig.SecretKey, token, clientIP.String())
+	if errors.Is(err, captcha.ErrRejected) {
+		log.Warn().
+			Err(err).
+			Str("username", username).
+			Str("client_ip", clientIP.String()).
+			Msg("Login attempt failed CAPTCHA verification")
+		c.JSON(403, models.NewErrorResponse("CAPTCHA verification failed, please try again", "CAPTCHA_INVALID"))
+		return false
+	}
 	if err != nil {
-		c.JSON(500, models.NewErrorResponse("Failed to create session", "INTERNAL_ERROR"))
-		log.Error().Err(err).Msg("Failed to create session")
+		log.Error().Err(err).Str("client_ip", clientIP.String()).Msg("Could not verify CAPTCHA")
+		c.JSON(503, models.NewErrorResponse("CAPTCHA verification is unavailable, please try again later", "CAPTCHA_UNAVAILABLE"))
+		return false
+	}
+	return true
+}
+
+// HandleCaptcha tells the login page whether this client must solve a CAPTCHA
+func (h *PortalLoginHandler) HandleCaptcha(c *gin.Context) {
+	clientIP, ok := middleware.GetClientIP(c)
+	if !ok || !clientIP.IsValid() {
+		c.JSON(400, models.NewErrorResponse("Could not determine client IP", "INVALID_IP"))
 		return
 	}
 
-	// Add IP to allowlist
-	h.allowlistManager.AddSessionIP(sess.SessionID, clientIP, sess.ExpiresAt)
+	cc := h.configLoader.GetConfig().CaptchaConfig
+	if !cc.Enabled {
+		c.JSON(200, models.NewAPIResponse("CAPTCHA settings", map[string]interface{}{
+			"enabled":  false,
+			"required": false,
+		}))
+		return
+	}
+
+	c.JSON(200, models.NewAPIResponse("CAPTCHA settings", map[string]interface{}{
+		"enabled":  true,
+		"required": h.captchaRequired(&cc, clientIP.String()),
+		"provider": cc.Provider,
+		"site_key": cc.SiteKey,
+	}))
+}
+
+// captchaRequired reports whether a login from clientIP must carry a CAPTCHA token
+func (h *PortalLoginHandler) captchaRequired(cc *config.CaptchaConfiguration, clientIP string) bool {
+	if !cc.Enabled {
+		return false
+	}
+	return cc.RequireAfterFailedAttempts == 0 || h.rateLimiter.FailureCount(clientIP) >= cc.RequireAfterFailedAttempts
+}
 
+// buildSessionResponse issues a token for a session and describes it for the portal
+func (h *PortalLoginHandler) buildSessionResponse(cfg *config.ApplicationConfig, sess *session.Session, clientIP netip.Addr) (map[string]interface{}, error) {
 	// Generate JWT token
 	tokenDuration := time.Until(sess.ExpiresAt)
-	token, err := h.jwtManager.GeneratePortalToken(user.UserID, sess.SessionID, tokenDuration)
+	token, err := h.jwtManager.GeneratePortalToken(sess.UserID, sess.SessionID, tokenDuration)
 	if err != nil {
-		c.JSON(500, models.NewErrorResponse("Failed to generate token", "INTERNAL_ERROR"))
-		log.Error().Err(err).Msg("Failed to generate JWT token")
-		return
+		return nil, err
 	}
 
 	// Get allowed service names
-	allowedServices := utils.GetServiceNames(cfg, user.AllowedServiceIDs)
+	allowedServices := utils.GetServiceNames(cfg, sess.AllowedServiceIDs)
 
-	// Build response
-	response := map[string]interface{}{
+	return map[string]interface{}{
 		"session_id":       sess.SessionID,
 		"jwt_access_token": token,
 		"token_expires_at": sess.ExpiresAt,
 		"session_info": map[string]interface{}{
-			"username":            user.Username,
+			"username":            sess.Username,
 			"authenticated_ip":    clientIP.String(),
+			"granted_network":     sess.GrantPrefix(clientIP).String(),
 			"expires_at":          sess.ExpiresAt,
 			"auto_extend_enabled": sess.AutoExtendEnabled,
 			"allowed_services":    allowedServices,
 		},
-	}
+	}, nil
+}
 
-	log.Info().
-		Str("username", user.Username).
-		Str("user_id", user.UserID).
-		Str("client_ip", clientIP.String()).
-		Msg("User logged in successfully")
+// portalLoginLimit selects the portal login rate limit of a config
+func portalLoginLimit(cfg *config.ApplicationConfig) config.LoginRateLimitConfig {
+	return cfg.ProxyServerConfig.LoginRateLimits.Portal
+}
 
-	c.JSON(200, models.NewAPIResponse("Login successful", response))
+// newLoginRateLimiter creates the rate limiter of a login endpoint, following config reloads
+func newLoginRateLimiter(configLoader *config.Loader, limit func(*config.ApplicationConfig) config.LoginRateLimitConfig, defaults auth.LimiterSettings) *auth.RateLimiter {
+	rl := auth.NewLoginRateLimiter(loginLimiterSettings(limit(configLoader.GetConfig()), defaults))
+	configLoader.RegisterReloadCallback(func(newCfg *config.ApplicationConfig) {
+		rl.Reload(loginLimiterSettings(limit(newCfg), defaults))
+	})
+	// Forget idle IPs; the handlers live as long as the process
+	rl.StartCleanup(time.Minute, nil)
+	return rl
+}
+
+// loginLimiterSettings converts a configured login rate limit, taking unset values from defaults
+func loginLimiterSettings(rl config.LoginRateLimitConfig, defaults auth.LimiterSettings) auth.LimiterSettings {
+	settings := defaults
+	if rl.RequestsPerMinute > 0 {
+		settings.RequestsPerMinute = rl.RequestsPerMinute
+	}
+	if rl.Burst > 0 {
+		settings.Burst = rl.Burst
+	}
+	if rl.MaxTrackedIPs > 0 {
+		settings.MaxEntries = rl.MaxTrackedIPs
+	}
+	settings.ReducedAfterFailures = rl.ReducedAfterFailures
+	settings.SevereAfterFailures = rl.SevereAfterFailures
+	settings.MaxIdleAge = time.Duration(rl.IdleSeconds) * time.Second
+	return settings
 }
@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"errors"
+	"net/netip"
+	"sort"
 	"time"
 
 	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/middleware"
 	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/proxy"
 	"github.com/davbauer/knock-knock-portal/internal/session"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
 )
 
 // PortalSessionHandler handles session operations
@@ -17,14 +20,16 @@ type PortalSessionHandler struct {
 	sessionManager     *session.Manager
 	configLoader       *config.Loader
 	ipAllowListManager *ipallowlist.Manager
+	proxyManager       *proxy.Manager
 }
 
 // NewPortalSessionHandler creates a new handler
-func NewPortalSessionHandler(sessionManager *session.Manager, configLoader *config.Loader, ipAllowListManager *ipallowlist.Manager) *PortalSessionHandler {
+func NewPortalSessionHandler(sessionManager *session.Manager, configLoader *config.Loader, ipAllowListManager *ipallowlist.Manager, proxyManager *proxy.Manager) *PortalSessionHandler {
 	return &PortalSessionHandler{
 		sessionManager:     sessionManager,
 		configLoader:       configLoader,
 		ipAllowListManager: ipAllowListManager,
+		proxyManager:       proxyManager,
 	}
 }
 
@@ -86,6 +91,9 @@ func (h *PortalSessionHandler) HandleStatus(c *gin.Context) {
 			"services":                serviceAccessList,
 			"total_services":          len(serviceAccessList),
 			"active":                  !sess.IsExpired(),
+			"dual_stack_correlation":  buildDualStackStatus(cfg, sess),
+			"extension":               buildExtensionStatus(sess, extensionPolicy(cfg)),
+			"traffic":                 h.buildTrafficUsage(cfg, sess),
 		},
 	}
 
@@ -132,6 +140,11 @@ func (h *PortalSessionHandler) HandleAddIP(c *gin.Context) {
 		return
 	}
 
+	// Add only this IP to the allowlist; prefix grants are left to login and dual-stack correlation
+	if sess, err := h.sessionManager.GetSessionByID(claims.SessionID); err == nil {
+		h.ipAllowListManager.AddSessionIP(sess.SessionID, clientIP, sess.ExpiresAt)
+	}
+
 	log.Info().
 		Str("session_id", claims.SessionID).
 		Str("user_id", claims.UserID).
@@ -143,6 +156,168 @@ func (h *PortalSessionHandler) HandleAddIP(c *gin.Context) {
 	}))
 }
 
+// HandleCorrelateIP handles POST /api/portal/session/correlate-ip
+// The portal frontend calls this through IPv4-only and IPv6-only hostnames so
+// both address families of a dual-stack client are attached to the same session
+func (h *PortalSessionHandler) HandleCorrelateIP(c *gin.Context) {
+	cfg := h.configLoader.GetConfig()
+	if !cfg.SessionConfig.DualStackCorrelation.Enabled {
+		c.JSON(403, models.NewErrorResponse("Dual-stack correlation is disabled", "DUAL_STACK_DISABLED"))
+		return
+	}
+
+	claims, ok := middleware.GetJWTClaims(c)
+	if !ok {
+		c.JSON(401, models.NewErrorResponse("Unauthorized", "UNAUTHORIZED"))
+		return
+	}
+
+	// Get client IP
+	clientIP, ok := middleware.GetClientIP(c)
+	if !ok || !clientIP.IsValid() {
+		c.JSON(400, models.NewErrorResponse("Could not determine client IP", "INVALID_IP"))
+		return
+	}
+	clientIP = clientIP.Unmap()
+
+	sess, err := h.sessionManager.GetSessionByID(claims.SessionID)
+	if err != nil {
+		c.JSON(404, models.NewErrorResponse("Session not found or expired", "SESSION_NOT_FOUND"))
+		return
+	}
+
+	alreadyAttached := sess.IsIPAllowed(clientIP)
+	if !alreadyAttached {
+		if err := h.sessionManager.AddIPToSession(sess.SessionID, clientIP); err != nil {
+			c.JSON(400, models.NewErrorResponse(err.Error(), "CORRELATE_IP_FAILED"))
+			return
+		}
+		h.ipAllowListManager.AddSessionPrefix(sess.SessionID, sess.GrantPrefix(clientIP), sess.ExpiresAt)
+
+		log.Info().
+			Str("session_id", sess.SessionID).
+			Str("user_id", sess.UserID).
+			Str("new_ip", clientIP.String()).
+			Str("address_family", addressFamily(clientIP)).
+			Msg("Correlated dual-stack address with session")
+	}
+
+	c.JSON(200, models.NewAPIResponse("Address correlated with session", map[string]interface{}{
+		"attached_ip":      clientIP.String(),
+		"address_family":   addressFamily(clientIP),
+		"already_attached": alreadyAttached,
+	}))
+}
+
+// buildTrafficUsage sums the proxy traffic of the session's IPs per service
+// Only services the session may access are listed; counters cover the currently open connections
+func (h *PortalSessionHandler) buildTrafficUsage(cfg *config.ApplicationConfig, sess *session.Session) map[string]interface{} {
+	serviceNames := make(map[string]string)
+	for _, service := range cfg.ProtectedServices {
+		if config.ServiceAllowed(sess.AllowedServiceIDs, service.ServiceID, service.Tags) {
+			serviceNames[service.ServiceID] = service.ServiceName
+		}
+	}
+
+	var totalBytesRx, totalBytesTx, totalPacketsRx, totalPacketsTx int64
+	var totalConnections int
+	usage := make(map[string]map[string]interface{})
+	serviceIDs := []string{}
+
+	for _, ip := range sess.AuthenticatedIPAddresses {
+		stats := h.proxyManager.GetStatsByIP(ip.String())
+		services, _ := stats["services"].([]map[string]interface{})
+		for _, stats := range services {
+			serviceID, _ := stats["service_id"].(string)
+			serviceName, allowed := serviceNames[serviceID]
+			if !allowed {
+				continue
+			}
+
+			service, ok := usage[serviceID]
+			if !ok {
+				service = map[string]interface{}{
+					"service_id":         serviceID,
+					"service_name":       serviceName,
+					"protocol":           stats["protocol"],
+					"bytes_received":     int64(0),
+					"bytes_sent":         int64(0),
+					"packets_received":   int64(0),
+					"packets_sent":       int64(0),
+					"active_connections": 0,
+				}
+				usage[serviceID] = service
+				serviceIDs = append(serviceIDs, serviceID)
+			}
+
+			rx, _ := stats["bytes_received"].(int64)
+			tx, _ := stats["bytes_sent"].(int64)
+			pktsRx, _ := stats["packets_received"].(int64)
+			pktsTx, _ := stats["packets_sent"].(int64)
+			connections, _ := stats["active_sessions"].(int)
+
+			service["bytes_received"] = service["bytes_received"].(int64) + rx
+			service["bytes_sent"] = service["bytes_sent"].(int64) + tx
+			service["packets_received"] = service["packets_received"].(int64) + pktsRx
+			service["packets_sent"] = service["packets_sent"].(int64) + pktsTx
+			service["active_connections"] = service["active_connections"].(int) + connections
+
+			totalBytesRx += rx
+			totalBytesTx += tx
+			totalPacketsRx += pktsRx
+			totalPacketsTx += pktsTx
+			totalConnections += connections
+		}
+	}
+
+	sort.Strings(serviceIDs)
+	services := make([]map[string]interface{}, 0, len(serviceIDs))
+	for _, serviceID := range serviceIDs {
+		services = append(services, usage[serviceID])
+	}
+
+	return map[string]interface{}{
+		"total_bytes_received":     totalBytesRx,
+		"total_bytes_sent":         totalBytesTx,
+		"total_packets_received":   totalPacketsRx,
+		"total_packets_sent":       totalPacketsTx,
+		"total_active_connections": totalConnections,
+		"services":                 services,
+	}
+}
+
+// buildDualStackStatus describes which address families are attached to the session
+// and where the frontend should send correlation requests for the missing ones
+func buildDualStackStatus(cfg *config.ApplicationConfig, sess *session.Session) map[string]interface{} {
+	dualStack := cfg.SessionConfig.DualStackCorrelation
+
+	ipv4Attached := false
+	ipv6Attached := false
+	for _, ip := range sess.AuthenticatedIPAddresses {
+		if ip.Unmap().Is4() {
+			ipv4Attached = true
+		} else {
+			ipv6Attached = true
+		}
+	}
+
+	return map[string]interface{}{
+		"enabled":           dualStack.Enabled,
+		"ipv4_endpoint_url": dualStack.IPv4EndpointURL,
+		"ipv6_endpoint_url": dualStack.IPv6EndpointURL,
+		"ipv4_attached":     ipv4Attached,
+		"ipv6_attached":     ipv6Attached,
+	}
+}
+
+// addressFamily returns "ipv4" or "ipv6" for an address
+func addressFamily(ip netip.Addr) string {
+	if ip.Unmap().Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
 // HandleExtendSession handles POST /api/portal/session/extend
 func (h *PortalSessionHandler) HandleExtendSession(c *gin.Context) {
 	claims, ok := middleware.GetJWTClaims(c)
@@ -157,12 +332,28 @@ func (h *PortalSessionHandler) HandleExtendSession(c *gin.Context) {
 		return
 	}
 
-	// Get the default session duration from config
+	// Get the default session duration from config, unless the user has their own
 	cfg := h.configLoader.GetConfig()
 	extendDuration := time.Duration(cfg.SessionConfig.DefaultSessionDurationSeconds) * time.Second
+	if sess.Duration > 0 {
+		extendDuration = sess.Duration
+	}
 
-	// Extend the session
-	sess.ExtendSession(extendDuration)
+	// Extend the session within the manual extension policy
+	policy := extensionPolicy(cfg)
+	if err := sess.ExtendManually(extendDuration, policy); err != nil {
+		switch {
+		case errors.Is(err, session.ErrExtensionLimitReached):
+			c.JSON(403, models.NewErrorResponse("No session extensions remaining", "EXTENSION_LIMIT_REACHED"))
+		case errors.Is(err, session.ErrExtensionTooSoon):
+			c.JSON(429, models.NewErrorResponse("Session was extended too recently, please try again later", "EXTENSION_TOO_SOON"))
+		case errors.Is(err, session.ErrMaximumDurationReached):
+			c.JSON(403, models.NewErrorResponse("Session has reached its maximum duration", "MAXIMUM_DURATION_REACHED"))
+		default:
+			c.JSON(500, models.NewErrorResponse("Failed to extend session", "INTERNAL_ERROR"))
+		}
+		return
+	}
 
 	// Calculate new expiry time
 	expiresIn := time.Until(sess.ExpiresAt).Seconds()
@@ -179,5 +370,45 @@ func (h *PortalSessionHandler) HandleExtendSession(c *gin.Context) {
 	c.JSON(200, models.NewAPIResponse("Session extended successfully", map[string]interface{}{
 		"expires_at":         sess.ExpiresAt,
 		"expires_in_seconds": int(expiresIn),
+		"extension":          buildExtensionStatus(sess, policy),
 	}))
 }
+
+// extensionPolicy returns the manual extension policy from the session config
+func extensionPolicy(cfg *config.ApplicationConfig) session.ExtensionPolicy {
+	return session.ExtensionPolicy{
+		MaxExtensions:      cfg.SessionConfig.MaxManualExtensions,
+		MinInterval:        time.Duration(cfg.SessionConfig.MinExtensionIntervalSeconds) * time.Second,
+		AllowBeyondMaximum: cfg.SessionConfig.ExtendBeyondMaximumDuration,
+	}
+}
+
+// buildExtensionStatus describes the remaining manual extension budget so the portal can disable the button
+func buildExtensionStatus(sess *session.Session, policy session.ExtensionPolicy) map[string]interface{} {
+	var remaining, nextAt, blockedReason interface{}
+	if r := sess.RemainingManualExtensions(policy); r >= 0 {
+		remaining = r
+	}
+	if t := sess.NextManualExtensionAt(policy); t.After(time.Now()) {
+		nextAt = t
+	}
+
+	err := sess.CheckManualExtension(policy)
+	switch {
+	case errors.Is(err, session.ErrExtensionLimitReached):
+		blockedReason = "limit_reached"
+	case errors.Is(err, session.ErrExtensionTooSoon):
+		blockedReason = "too_soon"
+	case errors.Is(err, session.ErrMaximumDurationReached):
+		blockedReason = "maximum_duration_reached"
+	}
+
+	return map[string]interface{}{
+		"can_extend":           err == nil,
+		"blocked_reason":       blockedReason, // nil when an extension is allowed
+		"extensions_used":      sess.ManualExtensions,
+		"max_extensions":       policy.MaxExtensions, // 0 = unlimited
+		"remaining_extensions": remaining,            // nil = unlimited
+		"next_extension_at":    nextAt,               // nil = no interval pending
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/davbauer/knock-knock-portal/internal/proxy"
+	"github.com/gin-gonic/gin"
+)
+
+// PublicStatusHandler serves the status page of services that opted in with public_status
+type PublicStatusHandler struct {
+	configLoader *config.Loader
+	proxyManager *proxy.Manager
+}
+
+// NewPublicStatusHandler creates a new public status handler
+func NewPublicStatusHandler(configLoader *config.Loader, proxyManager *proxy.Manager) *PublicStatusHandler {
+	return &PublicStatusHandler{
+		configLoader: configLoader,
+		proxyManager: proxyManager,
+	}
+}
+
+// HandleStatus handles GET /api/public/status
+// Public: only names, online state and connection counts, never ports, backends or clients
+func (h *PublicStatusHandler) HandleStatus(c *gin.Context) {
+	cfg := h.configLoader.GetConfig()
+	backends := h.proxyManager.BackendHealth()
+
+	services := make([]map[string]interface{}, 0)
+	for i := range cfg.ProtectedServices {
+		service := &cfg.ProtectedServices[i]
+		if !service.Enabled || !service.PublicStatus {
+			continue
+		}
+
+		running, connections := h.proxyManager.ServiceStatus(service.ServiceID)
+		var serviceBackends []proxy.BackendHealth
+		for _, backend := range backends {
+			if backend.ServiceID == service.ServiceID {
+				serviceBackends = append(serviceBackends, backend)
+			}
+		}
+		unreachable, circuitsOpen := countBackendProblems(serviceBackends)
+
+		services = append(services, map[string]interface{}{
+			"name":        service.ServiceName,
+			"description": service.Description,
+			"online":      running && unreachable == 0 && circuitsOpen == 0,
+			"connections": connections,
+		})
+	}
+
+	c.JSON(200, models.NewAPIResponseWithCount("Service status retrieved", services, len(services)))
+}
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"net/netip"
+	"time"
 
 	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/schedule"
 	"github.com/davbauer/knock-knock-portal/internal/session"
 )
 
@@ -12,6 +14,7 @@ import (
 // Returns a list of services with their access status, reasons, and port information
 func BuildServiceAccessList(cfg *config.ApplicationConfig, clientIP netip.Addr, userSession *session.Session, ipAllowlistReason string) []map[string]interface{} {
 	serviceAccessList := make([]map[string]interface{}, 0, len(cfg.ProtectedServices))
+	now := time.Now()
 
 	for _, service := range cfg.ProtectedServices {
 		if !service.Enabled {
@@ -25,10 +28,13 @@ func BuildServiceAccessList(cfg *config.ApplicationConfig, clientIP netip.Addr,
 			"description":             service.Description,
 			"proxy_listen_port_start": service.ProxyListenPortStart,
 			"proxy_listen_port_end":   service.ProxyListenPortEnd,
+			"additional_listeners":    service.AdditionalListeners,
 			"transport_protocol":      service.TransportProtocol,
+			"tags":                    service.Tags,
 		}
 
 		accessGranted := false
+		outsideSchedule := false
 		accessReasons := make([]map[string]string, 0, 3)
 
 		// Priority 1: Check permanent IP range access
@@ -40,6 +46,15 @@ func BuildServiceAccessList(cfg *config.ApplicationConfig, clientIP netip.Addr,
 			})
 		}
 
+		// Priority 1b: LAN clients reaching the public ports through NAT reflection
+		if ipAllowlistReason == "hairpin" {
+			accessGranted = true
+			accessReasons = append(accessReasons, map[string]string{
+				"method":      "hairpin_lan_range",
+				"description": "Your IP is in a LAN range reaching the services through NAT reflection (unrestricted access)",
+			})
+		}
+
 		// Priority 2: Check dynamic DNS hostname access
 		if ipAllowlistReason == "dns_resolved" {
 			accessGranted = true
@@ -51,7 +66,13 @@ func BuildServiceAccessList(cfg *config.ApplicationConfig, clientIP netip.Addr,
 
 		// Priority 3: Check session-based access
 		if userSession != nil && userSession.IsIPAllowed(clientIP) {
-			hasServiceAccess := isServiceAllowedForSession(userSession, service.ServiceID)
+			hasServiceAccess := isServiceAllowedForSession(userSession, &service)
+
+			// Schedules suspend session access outside their windows
+			if hasServiceAccess && !isSessionWithinSchedule(cfg, userSession, &service, now) {
+				hasServiceAccess = false
+				outsideSchedule = true
+			}
 
 			if hasServiceAccess {
 				accessGranted = true
@@ -71,6 +92,9 @@ func BuildServiceAccessList(cfg *config.ApplicationConfig, clientIP netip.Addr,
 
 		if !accessGranted {
 			serviceInfo["access_denied_reason"] = "No access method grants permission to this service"
+			if outsideSchedule {
+				serviceInfo["access_denied_reason"] = "Session access is outside the allowed schedule"
+			}
 		}
 
 		serviceAccessList = append(serviceAccessList, serviceInfo)
@@ -80,21 +104,23 @@ func BuildServiceAccessList(cfg *config.ApplicationConfig, clientIP netip.Addr,
 }
 
 // isServiceAllowedForSession checks if a session has access to a specific service
-// Returns true if the session has unrestricted access (empty AllowedServiceIDs) or explicitly includes the service
-func isServiceAllowedForSession(userSession *session.Session, serviceID string) bool {
-	// Empty AllowedServiceIDs means unrestricted access to all services
-	if len(userSession.AllowedServiceIDs) == 0 {
-		return true
-	}
+// Returns true if the session has unrestricted access (empty AllowedServiceIDs), includes the service
+// or selects one of its tags
+func isServiceAllowedForSession(userSession *session.Session, service *config.ProtectedServiceConfig) bool {
+	return config.ServiceAllowed(userSession.AllowedServiceIDs, service.ServiceID, service.Tags)
+}
 
-	// Check if service is in the allowed list
-	for _, allowedID := range userSession.AllowedServiceIDs {
-		if allowedID == serviceID {
-			return true
+// isSessionWithinSchedule checks the schedules of the session's user and of the service
+func isSessionWithinSchedule(cfg *config.ApplicationConfig, userSession *session.Session, service *config.ProtectedServiceConfig, now time.Time) bool {
+	if !schedule.IsOpen(service.Schedule, now) {
+		return false
+	}
+	for i := range cfg.PortalUserAccounts {
+		if cfg.PortalUserAccounts[i].UserID == userSession.UserID {
+			return schedule.IsOpen(config.EffectiveUser(cfg, &cfg.PortalUserAccounts[i]).Schedule, now)
 		}
 	}
-
-	return false
+	return true
 }
 
 // ExtractAllowedServiceDetails filters service access list to only include services the user can access
@@ -102,24 +128,12 @@ func isServiceAllowedForSession(userSession *session.Session, serviceID string)
 func ExtractAllowedServiceDetails(serviceAccessList []map[string]interface{}, allowedServiceIDs []string) []map[string]interface{} {
 	details := make([]map[string]interface{}, 0, len(serviceAccessList))
 
-	// Empty allowedServiceIDs means user has access to all services
-	allServicesAllowed := len(allowedServiceIDs) == 0
-
 	for _, service := range serviceAccessList {
 		serviceID, _ := service["service_id"].(string)
+		tags, _ := service["tags"].([]string)
 
-		// Check if user has access to this service
-		hasAccess := allServicesAllowed
-		if !hasAccess {
-			for _, allowedID := range allowedServiceIDs {
-				if allowedID == serviceID {
-					hasAccess = true
-					break
-				}
-			}
-		}
-
-		if hasAccess {
+		// Check if user has access to this service (empty allowedServiceIDs = all services)
+		if config.ServiceAllowed(allowedServiceIDs, serviceID, tags) {
 			// Extract only essential fields for simplified service list
 			details = append(details, map[string]interface{}{
 				"service_id":              service["service_id"],
@@ -0,0 +1,242 @@
+// Package honeypot listens on decoy ports that no legitimate client has a reason to touch.
+//
+// Any IP that completes a TCP handshake with a decoy port is added to the blocklist for a while,
+// unless it is allowlisted. Datagrams to decoy UDP ports are only logged by default: their source
+// address can be spoofed, which would let anyone get any IP blocked. The decoys never answer: TCP
+// connections are closed right after accept and UDP datagrams are discarded.
+package honeypot
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/upgrade"
+)
+
+// EventTriggered is published on the bus when a decoy port blocks an IP
+const EventTriggered = "honeypot.triggered"
+
+const defaultBlockDuration = time.Hour
+
+// Trigger describes a decoy port hit that led to a block
+type Trigger struct {
+	ClientIP     string    `json:"client_ip"`
+	Protocol     string    `json:"protocol"`
+	Port         int       `json:"port"`
+	BlockedUntil time.Time `json:"blocked_until"`
+}
+
+// Honeypot owns the decoy listeners
+type Honeypot struct {
+	configLoader     *config.Loader
+	blocklistManager *ipblocklist.Manager
+	allowlistManager *ipallowlist.Manager
+	bus              *events.Bus
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	udpConns  []*net.UDPConn
+	wg        sync.WaitGroup
+
+	// Read by the listener loops, which closeListeners waits for while holding mu
+	blockDuration atomic.Int64
+	blockOnUDP    atomic.Bool
+}
+
+// NewHoneypot creates the honeypot and opens its decoy ports if enabled
+func NewHoneypot(configLoader *config.Loader, blocklistManager *ipblocklist.Manager, allowlistManager *ipallowlist.Manager, bus *events.Bus) *Honeypot {
+	h := &Honeypot{
+		configLoader:     configLoader,
+		blocklistManager: blocklistManager,
+		allowlistManager: allowlistManager,
+		bus:              bus,
+	}
+
+	h.Reload()
+	return h
+}
+
+// Reload closes all decoy ports and reopens them from the current configuration
+func (h *Honeypot) Reload() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closeListeners()
+
+	cfg := h.configLoader.GetConfig()
+	hp := cfg.HoneypotConfig
+	if !hp.Enabled {
+		return
+	}
+
+	blockDuration := time.Duration(hp.BlockDurationSeconds) * time.Second
+	if blockDuration <= 0 {
+		blockDuration = defaultBlockDuration
+	}
+	h.blockDuration.Store(int64(blockDuration))
+	h.blockOnUDP.Store(hp.BlockOnUDP)
+
+	listenAddress := hp.ListenAddress
+	if listenAddress == "" {
+		listenAddress = cfg.ProxyServerConfig.ListenAddress
+	}
+
+	for _, port := range hp.TCPPorts {
+		addr := net.JoinHostPort(listenAddress, strconv.Itoa(port))
+		listener, err := upgrade.Listen("tcp", addr)
+		if err != nil {
+			log.Error().Err(err).Str("address", addr).Msg("Failed to open honeypot TCP port")
+			continue
+		}
+		h.listeners = append(h.listeners, listener)
+		h.wg.Add(1)
+		go h.acceptLoop(listener, port)
+	}
+
+	for _, port := range hp.UDPPorts {
+		addr := net.JoinHostPort(listenAddress, strconv.Itoa(port))
+		conn, err := upgrade.ListenUDP("udp", addr)
+		if err != nil {
+			log.Error().Err(err).Str("address", addr).Msg("Failed to open honeypot UDP port")
+			continue
+		}
+		h.udpConns = append(h.udpConns, conn)
+		h.wg.Add(1)
+		go h.readLoop(conn, port)
+	}
+
+	log.Info().
+		Ints("tcp_ports", hp.TCPPorts).
+		Ints("udp_ports", hp.UDPPorts).
+		Dur("block_duration", blockDuration).
+		Bool("block_on_udp", hp.BlockOnUDP).
+		Msg("Honeypot ports opened")
+}
+
+// Close stops listening on all decoy ports
+func (h *Honeypot) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closeListeners()
+}
+
+// closeListeners closes every decoy socket and waits for their loops; must be called with mu held
+func (h *Honeypot) closeListeners() {
+	for _, listener := range h.listeners {
+		listener.Close()
+	}
+	for _, conn := range h.udpConns {
+		conn.Close()
+	}
+	h.wg.Wait()
+
+	h.listeners = nil
+	h.udpConns = nil
+}
+
+// acceptLoop closes every accepted connection and records its source
+func (h *Honeypot) acceptLoop(listener net.Listener, port int) {
+	defer h.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Debug().Err(err).Int("port", port).Msg("Honeypot accept failed")
+			continue
+		}
+
+		remote, _ := conn.RemoteAddr().(*net.TCPAddr)
+		conn.Close()
+		if remote != nil {
+			h.trigger(remote.AddrPort().Addr(), "tcp", port)
+		}
+	}
+}
+
+// readLoop discards every datagram and blocks its source if block_on_udp is set, otherwise logs it
+func (h *Honeypot) readLoop(conn *net.UDPConn, port int) {
+	defer h.wg.Done()
+
+	buf := make([]byte, 2048)
+	var lastLog time.Time
+	suppressed := 0
+	for {
+		_, remote, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Debug().Err(err).Int("port", port).Msg("Honeypot read failed")
+			continue
+		}
+
+		if h.blockOnUDP.Load() {
+			h.trigger(remote.Addr(), "udp", port)
+			continue
+		}
+
+		// A spoofed flood must not flood the log either
+		if now := time.Now(); now.Sub(lastLog) >= time.Second {
+			log.Warn().
+				Str("client_ip", remote.Addr().Unmap().String()).
+				Int("port", port).
+				Int("suppressed", suppressed).
+				Msg("Honeypot UDP port touched, not blocking (block_on_udp is off)")
+			lastLog = now
+			suppressed = 0
+		} else {
+			suppressed++
+		}
+	}
+}
+
+// trigger blocks a source that touched a decoy port, unless it is allowlisted or already blocked
+func (h *Honeypot) trigger(addr netip.Addr, protocol string, port int) {
+	addr = addr.Unmap()
+	clientIP := addr.String()
+
+	// Scanners touch many decoys in a row; only the first hit blocks and emits an event
+	if blocked, _ := h.blocklistManager.IsIPBlocked(net.IP(addr.AsSlice())); blocked {
+		return
+	}
+
+	if allowed, reason := h.allowlistManager.IsIPAllowed(addr); allowed {
+		log.Debug().
+			Str("client_ip", clientIP).
+			Str("protocol", protocol).
+			Int("port", port).
+			Str("reason", reason).
+			Msg("Honeypot touched by allowlisted IP, not blocking")
+		return
+	}
+
+	duration := time.Duration(h.blockDuration.Load())
+	block := h.blocklistManager.BlockTemporarily(net.IP(addr.AsSlice()), duration, "honeypot "+protocol+"/"+strconv.Itoa(port))
+
+	log.Warn().
+		Str("client_ip", clientIP).
+		Str("protocol", protocol).
+		Int("port", port).
+		Time("blocked_until", block.ExpiresAt).
+		Msg("Honeypot triggered, IP blocked")
+
+	h.bus.Publish(EventTriggered, Trigger{
+		ClientIP:     clientIP,
+		Protocol:     protocol,
+		Port:         port,
+		BlockedUntil: block.ExpiresAt,
+	})
+}
@@ -0,0 +1,6 @@
+package honeypot
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["honeypot"] overrides its level
+var log = logging.Module("honeypot")
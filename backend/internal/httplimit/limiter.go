@@ -0,0 +1,219 @@
+// Package httplimit protects HTTP listeners from clients that hold resources without making progress.
+//
+// Three limits are enforced: how long a client may take to send its request headers (applied through
+// http.Server.ReadHeaderTimeout by the caller), a minimum transfer rate for request bodies and responses
+// (Slowloris slow POSTs and slow reads), and a cap on concurrent in-flight requests per client IP.
+package httplimit
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const defaultGracePeriod = 5 * time.Second
+
+// Limiter enforces the HTTP limits of one listener
+type Limiter struct {
+	mu  sync.RWMutex
+	cfg config.HTTPLimitsConfig
+
+	inFlightMu sync.Mutex
+	inFlight   map[string]int // client IP -> requests being served
+}
+
+// NewLimiter creates a limiter from configuration
+func NewLimiter(cfg *config.HTTPLimitsConfig) *Limiter {
+	l := &Limiter{
+		inFlight: make(map[string]int),
+	}
+	l.Reload(cfg)
+	return l
+}
+
+// Reload applies new limits; the header timeout only affects servers created afterwards
+func (l *Limiter) Reload(cfg *config.HTTPLimitsConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = *cfg
+}
+
+// HeaderReadTimeout returns the configured request header timeout (0 = no limit)
+func (l *Limiter) HeaderReadTimeout() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return time.Duration(l.cfg.HeaderReadTimeoutSeconds) * time.Second
+}
+
+// transferRate returns the minimum bytes per second and the grace period (rate 0 = off)
+func (l *Limiter) transferRate() (int, time.Duration) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	grace := time.Duration(l.cfg.TransferRateGracePeriodSeconds) * time.Second
+	if grace <= 0 {
+		grace = defaultGracePeriod
+	}
+	return l.cfg.MinTransferRateBytesPerSecond, grace
+}
+
+// Acquire reserves an in-flight request slot for a client IP
+// Returns false if the client is at its limit; otherwise release must be called when the request ends
+func (l *Limiter) Acquire(clientIP string) (release func(), ok bool) {
+	l.mu.RLock()
+	limit := l.cfg.MaxInFlightRequestsPerIP
+	l.mu.RUnlock()
+
+	l.inFlightMu.Lock()
+	defer l.inFlightMu.Unlock()
+
+	if limit > 0 && l.inFlight[clientIP] >= limit {
+		return nil, false
+	}
+	l.inFlight[clientIP]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.inFlightMu.Lock()
+			defer l.inFlightMu.Unlock()
+			if l.inFlight[clientIP]--; l.inFlight[clientIP] <= 0 {
+				delete(l.inFlight, clientIP)
+			}
+		})
+	}, true
+}
+
+// LimitBody cuts off request bodies that arrive slower than the minimum transfer rate
+// notAfter caps the read deadline (e.g. the server's ReadTimeout); zero = no cap
+func (l *Limiter) LimitBody(w http.ResponseWriter, r *http.Request, notAfter time.Time) {
+	rate, grace := l.transferRate()
+	if rate <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+
+	r.Body = &rateLimitedBody{
+		body:       r.Body,
+		controller: http.NewResponseController(w),
+		start:      time.Now(),
+		rate:       rate,
+		grace:      grace,
+		notAfter:   notAfter,
+	}
+}
+
+// rateLimitedBody moves the read deadline along with the bytes received so far:
+// after the grace period, the client must have sent at least rate bytes per elapsed second
+type rateLimitedBody struct {
+	body       io.ReadCloser
+	controller *http.ResponseController
+	start      time.Time
+	rate       int
+	grace      time.Duration
+	notAfter   time.Time
+	received   int64
+}
+
+func (b *rateLimitedBody) Read(p []byte) (int, error) {
+	deadline := b.start.Add(b.grace + time.Duration(b.received+1)*time.Second/time.Duration(b.rate))
+	if !b.notAfter.IsZero() && b.notAfter.Before(deadline) {
+		deadline = b.notAfter
+	}
+	b.controller.SetReadDeadline(deadline) // Not every connection supports deadlines; the body is then unbounded
+
+	n, err := b.body.Read(p)
+	b.received += int64(n)
+	if err != nil {
+		// The server keeps reading in the background to notice disconnects; don't let the
+		// body deadline cancel a request that is still waiting for its response
+		b.controller.SetReadDeadline(b.notAfter)
+	}
+	return n, err
+}
+
+func (b *rateLimitedBody) Close() error {
+	return b.body.Close()
+}
+
+// Listener wraps a listener so responses written slower than the minimum transfer rate are cut off
+func (l *Limiter) Listener(listener net.Listener) net.Listener {
+	return &rateLimitedListener{Listener: listener, limiter: l}
+}
+
+type rateLimitedListener struct {
+	net.Listener
+	limiter *Limiter
+}
+
+func (ln *rateLimitedListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitedConn{Conn: conn, limiter: ln.limiter}, nil
+}
+
+// rateLimitedConn cuts off writes to clients that accept fewer than rate bytes per grace period
+// Deadlines set by the HTTP server (WriteTimeout) are remembered and still apply
+type rateLimitedConn struct {
+	net.Conn
+	limiter *Limiter
+
+	mu            sync.Mutex
+	writeDeadline time.Time
+}
+
+func (c *rateLimitedConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *rateLimitedConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	rate, grace := c.limiter.transferRate()
+
+	c.mu.Lock()
+	serverDeadline := c.writeDeadline
+	c.mu.Unlock()
+
+	if rate <= 0 {
+		// Also clears a window deadline left over from before the limit was switched off
+		c.Conn.SetWriteDeadline(serverDeadline)
+		return c.Conn.Write(p)
+	}
+
+	// Write in windows of one grace period; each window must move at least rate*grace bytes
+	minProgress := int(float64(rate) * grace.Seconds())
+	written := 0
+	for {
+		deadline := time.Now().Add(grace)
+		if !serverDeadline.IsZero() && serverDeadline.Before(deadline) {
+			deadline = serverDeadline
+		}
+		c.Conn.SetWriteDeadline(deadline)
+
+		n, err := c.Conn.Write(p[written:])
+		written += n
+
+		var netErr net.Error
+		if err == nil || !errors.As(err, &netErr) || !netErr.Timeout() {
+			return written, err
+		}
+		if n < minProgress || deadline.Equal(serverDeadline) {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,6 @@
+package invite
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["invite"] overrides its level
+var log = logging.Module("invite")
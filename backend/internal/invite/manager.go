@@ -0,0 +1,281 @@
+// Package invite lets admins hand out one-time codes for portal self-registration.
+//
+// An invite carries the services the new account may access and an expiry. The invitee
+// redeems it by choosing a username and password; the account is appended to
+// portal_user_accounts through the config loader. Invites are kept in the data directory.
+package invite
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/utils"
+	"github.com/google/uuid"
+)
+
+const invitesFile = "invites.json"
+
+// Redemption errors
+var (
+	ErrNotFound      = errors.New("invite not found")
+	ErrExpired       = errors.New("invite has expired")
+	ErrRedeemed      = errors.New("invite has already been used")
+	ErrUsernameTaken = errors.New("username is already taken")
+)
+
+// Invite is a one-time registration code
+type Invite struct {
+	Code              string     `json:"code"`
+	AllowedServiceIDs []string   `json:"allowed_service_ids"` // Empty = all
+	Note              string     `json:"note"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	RedeemedAt        *time.Time `json:"redeemed_at,omitempty"`
+	RedeemedBy        string     `json:"redeemed_by,omitempty"` // Username chosen by the invitee
+}
+
+// IsExpired checks if the invite can no longer be redeemed because of its age
+func (i *Invite) IsExpired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+// Manager stores invites and creates accounts from them
+type Manager struct {
+	configLoader *config.Loader
+	path         string
+	invites      map[string]*Invite
+	mu           sync.Mutex
+}
+
+// NewManager creates an invite manager persisting to dataDir (empty = in memory only)
+func NewManager(configLoader *config.Loader, dataDir string) *Manager {
+	m := &Manager{
+		configLoader: configLoader,
+		invites:      make(map[string]*Invite),
+	}
+	if dataDir != "" {
+		m.path = filepath.Join(dataDir, invitesFile)
+	}
+	m.load()
+	return m
+}
+
+// Create generates a new invite valid for ttl
+func (m *Manager) Create(allowedServiceIDs []string, ttl time.Duration, note string) (*Invite, error) {
+	cfg := m.configLoader.GetConfig()
+	for _, serviceID := range allowedServiceIDs {
+		if utils.GetServiceByID(cfg, serviceID) == nil {
+			return nil, fmt.Errorf("unknown service_id '%s'", serviceID)
+		}
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invite code: %w", err)
+	}
+
+	now := time.Now()
+	inv := &Invite{
+		Code:              code,
+		AllowedServiceIDs: allowedServiceIDs,
+		Note:              note,
+		CreatedAt:         now,
+		ExpiresAt:         now.Add(ttl),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invites[code] = inv
+	m.save()
+
+	log.Info().
+		Strs("allowed_service_ids", allowedServiceIDs).
+		Time("expires_at", inv.ExpiresAt).
+		Msg("Invite created")
+
+	copied := *inv
+	return &copied, nil
+}
+
+// List returns all invites, newest first
+func (m *Manager) List() []Invite {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invites := make([]Invite, 0, len(m.invites))
+	for _, inv := range m.invites {
+		invites = append(invites, *inv)
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].CreatedAt.After(invites[j].CreatedAt)
+	})
+	return invites
+}
+
+// Get returns a redeemable invite
+func (m *Manager) Get(code string) (*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inv, err := m.redeemable(code)
+	if err != nil {
+		return nil, err
+	}
+	copied := *inv
+	return &copied, nil
+}
+
+// Revoke deletes an invite; returns false if it does not exist
+func (m *Manager) Revoke(code string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.invites[code]; !ok {
+		return false
+	}
+	delete(m.invites, code)
+	m.save()
+	return true
+}
+
+// Redeem creates the portal user account for an invite and marks it used
+func (m *Manager) Redeem(code, username, password string) (*config.PortalUserAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inv, err := m.redeemable(code)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := m.configLoader.GetConfig()
+	for _, user := range cfg.PortalUserAccounts {
+		if user.Username == username {
+			return nil, ErrUsernameTaken
+		}
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	account := config.PortalUserAccount{
+		UserID:               uuid.New().String(),
+		Username:             username,
+		BcryptHashedPassword: hash,
+		AllowedServiceIDs:    inv.AllowedServiceIDs,
+		Notes:                "Registered via invite",
+	}
+	if inv.Note != "" {
+		account.Notes += ": " + inv.Note
+	}
+
+	newCfg := *cfg
+	newCfg.PortalUserAccounts = append(append([]config.PortalUserAccount{}, cfg.PortalUserAccounts...), account)
+	if err := m.configLoader.SaveConfig(&newCfg); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	inv.RedeemedAt = &now
+	inv.RedeemedBy = username
+	m.save()
+
+	log.Info().
+		Str("username", username).
+		Str("user_id", account.UserID).
+		Msg("Portal user registered via invite")
+
+	return &account, nil
+}
+
+// redeemable looks up an invite that is neither used nor expired
+// Caller must hold mu
+func (m *Manager) redeemable(code string) (*Invite, error) {
+	inv, ok := m.invites[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if inv.RedeemedAt != nil {
+		return nil, ErrRedeemed
+	}
+	if inv.IsExpired() {
+		return nil, ErrExpired
+	}
+	return inv, nil
+}
+
+// load reads persisted invites from disk
+func (m *Manager) load() {
+	if m.path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", m.path).Msg("Failed to read invites")
+		}
+		return
+	}
+
+	var invites []*Invite
+	if err := json.Unmarshal(data, &invites); err != nil {
+		log.Warn().Err(err).Str("path", m.path).Msg("Failed to parse invites, starting empty")
+		return
+	}
+	for _, inv := range invites {
+		m.invites[inv.Code] = inv
+	}
+}
+
+// save writes all invites to disk
+// Caller must hold mu
+func (m *Manager) save() {
+	if m.path == "" {
+		return
+	}
+
+	invites := make([]*Invite, 0, len(m.invites))
+	for _, inv := range m.invites {
+		invites = append(invites, inv)
+	}
+	data, err := json.MarshalIndent(invites, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode invites")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		log.Error().Err(err).Str("path", m.path).Msg("Failed to save invites")
+		return
+	}
+	tmp := m.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, m.path)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", m.path).Msg("Failed to save invites")
+	}
+}
+
+// generateCode returns a random URL-safe invite code
+func generateCode() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -4,20 +4,32 @@ import (
 	"context"
 	"net"
 	"net/netip"
+	"sync"
 	"time"
-
-	"github.com/rs/zerolog/log"
 )
 
 // DNSResolver resolves DNS hostnames to IPs
 type DNSResolver struct {
 	resolver *net.Resolver
+	statusMu sync.Mutex
+	status   map[string]*HostnameStatus // Outcome of the last resolution per hostname
+}
+
+// HostnameStatus is the outcome of resolving one dynamic DNS hostname
+type HostnameStatus struct {
+	Hostname            string       `json:"hostname"`
+	IPs                 []netip.Addr `json:"ips"`          // From the last successful resolution
+	LastSuccess         *time.Time   `json:"last_success"` // nil until the hostname resolved once
+	LastAttempt         *time.Time   `json:"last_attempt"` // nil before the first resolution
+	LastError           string       `json:"last_error,omitempty"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
 }
 
 // NewDNSResolver creates a new DNS resolver
 func NewDNSResolver() *DNSResolver {
 	return &DNSResolver{
 		resolver: &net.Resolver{},
+		status:   make(map[string]*HostnameStatus),
 	}
 }
 
@@ -62,6 +74,7 @@ func (r *DNSResolver) ResolveHostnames(ctx context.Context, hostnames []string)
 
 	for _, hostname := range hostnames {
 		addrs, err := r.ResolveHostname(ctx, hostname)
+		r.recordStatus(hostname, addrs, err)
 		if err != nil {
 			log.Warn().
 				Err(err).
@@ -75,6 +88,47 @@ func (r *DNSResolver) ResolveHostnames(ctx context.Context, hostnames []string)
 	return results
 }
 
+// recordStatus stores the outcome of resolving a hostname
+func (r *DNSResolver) recordStatus(hostname string, addrs []netip.Addr, err error) {
+	now := time.Now()
+
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	status, ok := r.status[hostname]
+	if !ok {
+		status = &HostnameStatus{Hostname: hostname}
+		r.status[hostname] = status
+	}
+	status.LastAttempt = &now
+	if err != nil {
+		status.LastError = err.Error()
+		status.ConsecutiveFailures++
+		return
+	}
+	status.IPs = addrs
+	status.LastSuccess = &now
+	status.LastError = ""
+	status.ConsecutiveFailures = 0
+}
+
+// Status returns the last resolution outcome of each hostname, in the given order
+// Hostnames not resolved yet are returned with empty times
+func (r *DNSResolver) Status(hostnames []string) []HostnameStatus {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+
+	result := make([]HostnameStatus, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		status := HostnameStatus{Hostname: hostname, IPs: []netip.Addr{}}
+		if existing, ok := r.status[hostname]; ok {
+			status = *existing
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
 // StartPeriodicRefresh starts periodic DNS resolution
 func (r *DNSResolver) StartPeriodicRefresh(
 	ctx context.Context,
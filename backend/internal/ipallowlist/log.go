@@ -0,0 +1,6 @@
+package ipallowlist
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["ipallowlist"] overrides its level
+var log = logging.Module("ipallowlist")
@@ -3,11 +3,14 @@ package ipallowlist
 import (
 	"context"
 	"net/netip"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/davbauer/knock-knock-portal/internal/config"
-	"github.com/rs/zerolog/log"
+	"github.com/davbauer/knock-knock-portal/internal/logsample"
+	"github.com/rs/zerolog"
 )
 
 // Manager manages the IP allowlist
@@ -20,10 +23,18 @@ type Manager struct {
 	dnsResolver    *DNSResolver
 	config         *config.NetworkAccessControlConfig
 	configMutex    sync.RWMutex
-	sessionIPIndex sync.Map // map[sessionID]string (sessionID -> IP) for O(1) removal
+	sessionIPIndex sync.Map // map[sessionID]*sync.Map (sessionID -> set of IP strings) for O(1) removal
 	ctx            context.Context
 	cancel         context.CancelFunc
 	dnsCancel      context.CancelFunc // Separate cancel for DNS refresh
+	changeMutex    sync.RWMutex
+	changeHooks    []func()           // Called after entries were added or removed; must not block
+	resolver       atomic.Value       // func(netip.Addr) bool - grants access for IPs without an entry
+	serviceTags    atomic.Value       // func(string) []string - current tags of a service, for tag selectors
+	deniedLogs     *logsample.Sampler // Rejected IPs are checked on every packet; log each once per minute
+	dnsRefreshedAt atomic.Int64       // Unix nanoseconds of the last DNS refresh; 0 before the first
+	dnsResolved    atomic.Int32       // Hostnames that resolved in the last DNS refresh
+	dnsUpdateMutex sync.Mutex         // Serializes periodic and forced DNS updates
 }
 
 // NewManager creates a new IP allowlist manager
@@ -34,6 +45,7 @@ func NewManager(cfg *config.NetworkAccessControlConfig) *Manager {
 		matcher:     NewMatcher(),
 		dnsResolver: NewDNSResolver(),
 		config:      cfg,
+		deniedLogs:  logsample.New("not-allowed", time.Minute, zerolog.DebugLevel, log),
 		ctx:         ctx,
 		cancel:      cancel,
 	}
@@ -49,18 +61,25 @@ func NewManager(cfg *config.NetworkAccessControlConfig) *Manager {
 	return m
 }
 
-// loadPermanentIPRanges loads permanently allowed IP ranges from config
+// loadPermanentIPRanges loads permanently allowed IP ranges and hairpin LAN ranges from config
 func (m *Manager) loadPermanentIPRanges() {
 	m.configMutex.RLock()
 	cfg := m.config
 	m.configMutex.RUnlock()
 
-	for _, ipRange := range cfg.PermanentlyAllowedIPRanges {
+	m.loadIPRanges(cfg.PermanentlyAllowedIPRanges, EntryTypePermanent)
+	m.loadIPRanges(cfg.HairpinLANRanges, EntryTypeHairpin)
+}
+
+// loadIPRanges adds configured IP addresses and CIDR ranges as entries of a source type
+func (m *Manager) loadIPRanges(ipRanges []string, sourceType EntryType) {
+	for _, ipRange := range ipRanges {
 		addr, prefix, err := ParseIPOrPrefix(ipRange)
 		if err != nil {
 			log.Error().
 				Err(err).
 				Str("ip_range", ipRange).
+				Str("source_type", string(sourceType)).
 				Msg("Failed to parse permanent IP range")
 			continue
 		}
@@ -68,7 +87,7 @@ func (m *Manager) loadPermanentIPRanges() {
 		entry := &Entry{
 			IPAddress:  addr,
 			IPPrefix:   prefix,
-			SourceType: EntryTypePermanent,
+			SourceType: sourceType,
 			AddedAt:    time.Now(),
 		}
 
@@ -84,6 +103,7 @@ func (m *Manager) loadPermanentIPRanges() {
 
 		log.Info().
 			Str("ip_range", ipRange).
+			Str("source_type", string(sourceType)).
 			Msg("Added permanent IP allowlist entry")
 	}
 }
@@ -117,12 +137,24 @@ func (m *Manager) startDNSRefresh() {
 
 // updateDNSEntries updates DNS-resolved IP entries
 func (m *Manager) updateDNSEntries(results map[string][]netip.Addr) {
+	m.dnsUpdateMutex.Lock()
+	defer m.dnsUpdateMutex.Unlock()
+
 	now := time.Now()
 
+	m.configMutex.RLock()
+	ipv6PrefixLength := m.config.DNSIPv6GrantPrefixLength
+	m.configMutex.RUnlock()
+
 	// O(1) operation: Clear the entire DNS map by replacing it
 	// This is much faster than removeEntriesByType() which scans all entries
 	m.dnsIPEntries = sync.Map{}
 
+	// IPv6 hosts using privacy extensions rotate their interface identifier,
+	// so optionally grant the whole prefix instead of the single address
+	dnsCIDREntries := []*Entry{}
+	seenPrefixes := make(map[netip.Prefix]bool)
+
 	// Add new DNS entries to dedicated DNS map
 	totalIPs := 0
 	for hostname, addrs := range results {
@@ -136,6 +168,34 @@ func (m *Manager) updateDNSEntries(results map[string][]netip.Addr) {
 				OriginalHostname: hostname,
 			}
 
+			if addr.Is6() && !addr.Is4In6() && ipv6PrefixLength > 0 && ipv6PrefixLength < 128 {
+				prefix, err := addr.Prefix(ipv6PrefixLength)
+				if err != nil {
+					log.Warn().
+						Err(err).
+						Str("hostname", hostname).
+						Str("ip", addr.String()).
+						Msg("Failed to expand DNS-resolved IPv6 address to prefix")
+					continue
+				}
+				if seenPrefixes[prefix] {
+					continue
+				}
+				seenPrefixes[prefix] = true
+
+				entry.IPAddress = prefix.Addr()
+				entry.IPPrefix = &prefix
+				dnsCIDREntries = append(dnsCIDREntries, entry)
+				totalIPs++
+
+				log.Info().
+					Str("hostname", hostname).
+					Str("ip", addr.String()).
+					Str("cidr", prefix.String()).
+					Msg("Added DNS-resolved IPv6 prefix to allowlist")
+				continue
+			}
+
 			m.dnsIPEntries.Store(addr.String(), entry)
 			totalIPs++
 
@@ -148,12 +208,32 @@ func (m *Manager) updateDNSEntries(results map[string][]netip.Addr) {
 		}
 	}
 
+	m.replaceDNSCIDREntries(dnsCIDREntries)
+	m.notifyChange()
+	m.dnsResolved.Store(int32(len(results)))
+	m.dnsRefreshedAt.Store(now.UnixNano())
+
 	log.Info().
 		Int("hostnames", len(results)).
 		Int("total_ips", totalIPs).
+		Int("ipv6_prefixes", len(dnsCIDREntries)).
 		Msg("Updated DNS-resolved IP entries")
 }
 
+// replaceDNSCIDREntries swaps all DNS-resolved CIDR entries for the given set
+func (m *Manager) replaceDNSCIDREntries(entries []*Entry) {
+	m.cidrMutex.Lock()
+	defer m.cidrMutex.Unlock()
+
+	newCIDREntries := make([]*Entry, 0, len(m.cidrEntries)+len(entries))
+	for _, entry := range m.cidrEntries {
+		if entry.SourceType != EntryTypeDNSResolved {
+			newCIDREntries = append(newCIDREntries, entry)
+		}
+	}
+	m.cidrEntries = append(newCIDREntries, entries...)
+}
+
 // AddSessionIP adds a session-based IP to the allowlist
 func (m *Manager) AddSessionIP(sessionID string, ip netip.Addr, expiresAt time.Time) {
 	entry := &Entry{
@@ -167,26 +247,93 @@ func (m *Manager) AddSessionIP(sessionID string, ip netip.Addr, expiresAt time.T
 
 	ipStr := ip.String()
 	m.exactIPEntries.Store(ipStr, entry)
-	m.sessionIPIndex.Store(sessionID, ipStr) // Add to index for O(1) removal
+
+	// Add to index for O(1) removal (a session can own several IPs)
+	value, _ := m.sessionIPIndex.LoadOrStore(sessionID, &sync.Map{})
+	value.(*sync.Map).Store(ipStr, true)
 
 	log.Info().
 		Str("session_id", sessionID).
 		Str("ip", ipStr).
 		Time("expires_at", expiresAt).
 		Msg("Added session IP to allowlist")
+
+	m.notifyChange()
+}
+
+// AddSessionPrefix adds a session-based network grant to the allowlist
+// Single-address prefixes are stored as exact entries for O(1) lookups
+func (m *Manager) AddSessionPrefix(sessionID string, prefix netip.Prefix, expiresAt time.Time) {
+	if prefix.IsSingleIP() {
+		m.AddSessionIP(sessionID, prefix.Addr(), expiresAt)
+		return
+	}
+
+	prefix = prefix.Masked()
+	entry := &Entry{
+		IPAddress:  prefix.Addr(),
+		IPPrefix:   &prefix,
+		SourceType: EntryTypeSession,
+		SessionID:  sessionID,
+		AddedAt:    time.Now(),
+		ExpiresAt:  &expiresAt,
+	}
+
+	m.cidrMutex.Lock()
+	m.cidrEntries = append(m.cidrEntries, entry)
+	m.cidrMutex.Unlock()
+
+	log.Info().
+		Str("session_id", sessionID).
+		Str("cidr", prefix.String()).
+		Time("expires_at", expiresAt).
+		Msg("Added session prefix to allowlist")
+
+	m.notifyChange()
+}
+
+// removeSessionCIDREntries removes all prefix grants belonging to a session
+func (m *Manager) removeSessionCIDREntries(sessionID string) {
+	m.cidrMutex.Lock()
+	defer m.cidrMutex.Unlock()
+
+	newCIDREntries := make([]*Entry, 0, len(m.cidrEntries))
+	for _, entry := range m.cidrEntries {
+		if entry.SourceType == EntryTypeSession && entry.SessionID == sessionID {
+			log.Debug().
+				Str("session_id", sessionID).
+				Str("cidr", entry.IPPrefix.String()).
+				Msg("Removed session prefix from allowlist")
+			continue
+		}
+		newCIDREntries = append(newCIDREntries, entry)
+	}
+	m.cidrEntries = newCIDREntries
 }
 
 // RemoveSessionIP removes a session-based IP from the allowlist
 func (m *Manager) RemoveSessionIP(sessionID string) {
+	defer m.notifyChange()
+
+	// Prefix grants live in the CIDR list
+	m.removeSessionCIDREntries(sessionID)
+
 	// O(1) lookup using index instead of O(n) iteration
-	if ipValue, ok := m.sessionIPIndex.Load(sessionID); ok {
-		ipStr := ipValue.(string)
-		m.exactIPEntries.Delete(ipStr)
-		m.sessionIPIndex.Delete(sessionID)
-		log.Debug().
-			Str("session_id", sessionID).
-			Str("ip", ipStr).
-			Msg("Removed session IP from allowlist")
+	if value, ok := m.sessionIPIndex.LoadAndDelete(sessionID); ok {
+		value.(*sync.Map).Range(func(key, _ interface{}) bool {
+			ipStr := key.(string)
+			// Only delete the entry if it still belongs to this session
+			if existing, ok := m.exactIPEntries.Load(ipStr); ok {
+				if entry := existing.(*Entry); entry.SourceType == EntryTypeSession && entry.SessionID == sessionID {
+					m.exactIPEntries.Delete(ipStr)
+				}
+			}
+			log.Debug().
+				Str("session_id", sessionID).
+				Str("ip", ipStr).
+				Msg("Removed session IP from allowlist")
+			return true
+		})
 		return
 	}
 
@@ -244,10 +391,9 @@ func (m *Manager) IsIPAllowed(ip netip.Addr) (allowed bool, reason string) {
 
 	// Slow path: CIDR range matching
 	m.cidrMutex.RLock()
-	defer m.cidrMutex.RUnlock()
-
 	for _, entry := range m.cidrEntries {
 		if !entry.IsExpired() && m.matcher.MatchesIP(ip, entry) {
+			m.cidrMutex.RUnlock()
 			log.Debug().
 				Str("ip", ipStr).
 				Str("source_type", string(entry.SourceType)).
@@ -256,10 +402,21 @@ func (m *Manager) IsIPAllowed(ip netip.Addr) (allowed bool, reason string) {
 			return true, string(entry.SourceType)
 		}
 	}
+	m.cidrMutex.RUnlock()
+
+	// Last resort: an identity provider may create a session for this IP
+	if resolver, ok := m.resolver.Load().(func(netip.Addr) bool); ok && resolver(ip) {
+		log.Debug().
+			Str("ip", ipStr).
+			Msg("IP allowed - granted by access resolver")
+		return true, string(EntryTypeSession)
+	}
 
-	log.Debug().
-		Str("ip", ipStr).
-		Msg("IP not allowed - no match found")
+	if m.deniedLogs.Allow(ipStr, "", "not_allowed") {
+		log.Debug().
+			Str("ip", ipStr).
+			Msg("IP not allowed - no match found")
+	}
 	return false, "not_allowed"
 }
 
@@ -271,24 +428,25 @@ func (m *Manager) IsIPAllowedForService(ip netip.Addr, serviceID string, allowed
 		return false, ipReason
 	}
 
-	// If permanent or DNS-resolved, always allow
-	if ipReason == string(EntryTypePermanent) || ipReason == string(EntryTypeDNSResolved) {
+	// If permanent, hairpin or DNS-resolved, always allow
+	if ipReason == string(EntryTypePermanent) || ipReason == string(EntryTypeHairpin) || ipReason == string(EntryTypeDNSResolved) {
 		return true, ipReason
 	}
 
 	// For session-based access, check service restrictions
-	if len(allowedServiceIDs) == 0 {
-		// Empty list = all services allowed
-		return true, "session_all_services"
-	}
-
-	for _, allowedID := range allowedServiceIDs {
-		if allowedID == serviceID {
-			return true, "session_service_allowed"
+	reason = "session_all_services" // Empty list = all services allowed
+	if len(allowedServiceIDs) > 0 {
+		var tags []string
+		if lookup, ok := m.serviceTags.Load().(func(string) []string); ok {
+			tags = lookup(serviceID)
+		}
+		if !config.ServiceAllowed(allowedServiceIDs, serviceID, tags) {
+			return false, "service_not_allowed"
 		}
+		reason = "session_service_allowed"
 	}
 
-	return false, "service_not_allowed"
+	return true, reason
 }
 
 // removeEntriesByType removes all entries of a specific type
@@ -341,6 +499,179 @@ func (m *Manager) GetAllowlistStats() map[string]interface{} {
 	}
 }
 
+// DNSStatus returns the state of the dynamic DNS hostname refresh
+func (m *Manager) DNSStatus() map[string]interface{} {
+	m.configMutex.RLock()
+	hostnames := len(m.config.AllowedDynamicDNSHostnames)
+	interval := m.config.DNSRefreshIntervalSeconds
+	m.configMutex.RUnlock()
+
+	dnsIPs := 0
+	m.dnsIPEntries.Range(func(key, value interface{}) bool {
+		dnsIPs++
+		return true
+	})
+
+	status := map[string]interface{}{
+		"hostnames":        hostnames,
+		"resolved":         m.dnsResolved.Load(),
+		"ip_count":         dnsIPs,
+		"interval_seconds": interval,
+		"last_refresh":     nil,
+	}
+	if refreshed := m.dnsRefreshedAt.Load(); refreshed != 0 {
+		status["last_refresh"] = time.Unix(0, refreshed)
+	}
+	return status
+}
+
+// DNSHostnames returns the resolution status of every configured dynamic DNS hostname
+func (m *Manager) DNSHostnames() []HostnameStatus {
+	m.configMutex.RLock()
+	hostnames := m.config.AllowedDynamicDNSHostnames
+	m.configMutex.RUnlock()
+
+	return m.dnsResolver.Status(hostnames)
+}
+
+// RefreshDNS resolves all dynamic DNS hostnames right away and replaces the DNS-resolved entries
+// The periodic refresh keeps its schedule
+func (m *Manager) RefreshDNS(ctx context.Context) []HostnameStatus {
+	m.configMutex.RLock()
+	hostnames := m.config.AllowedDynamicDNSHostnames
+	m.configMutex.RUnlock()
+
+	if len(hostnames) > 0 {
+		m.updateDNSEntries(m.dnsResolver.ResolveHostnames(ctx, hostnames))
+	}
+	return m.dnsResolver.Status(hostnames)
+}
+
+// GetActiveEntries returns a snapshot of all non-expired entries
+func (m *Manager) GetActiveEntries() []Entry {
+	entries := []Entry{}
+	collect := func(key, value interface{}) bool {
+		if entry := value.(*Entry); !entry.IsExpired() {
+			entries = append(entries, *entry)
+		}
+		return true
+	}
+	m.exactIPEntries.Range(collect)
+	m.dnsIPEntries.Range(collect)
+
+	m.cidrMutex.RLock()
+	for _, entry := range m.cidrEntries {
+		if !entry.IsExpired() {
+			entries = append(entries, *entry)
+		}
+	}
+	m.cidrMutex.RUnlock()
+
+	return entries
+}
+
+// ListEntries returns all non-expired entries with their provenance, ordered by source type and address
+func (m *Manager) ListEntries() []EntryInfo {
+	entries := m.GetActiveEntries()
+	infos := make([]EntryInfo, 0, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		info := EntryInfo{
+			Entry:      entry.Value(),
+			IsCIDR:     entry.IPPrefix != nil,
+			SourceType: entry.SourceType,
+			Hostname:   entry.OriginalHostname,
+			SessionID:  entry.SessionID,
+			AddedAt:    entry.AddedAt,
+			ExpiresAt:  entry.ExpiresAt,
+		}
+		if !entry.LastVerifiedAt.IsZero() {
+			verified := entry.LastVerifiedAt
+			info.LastVerifiedAt = &verified
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].SourceType != infos[j].SourceType {
+			return infos[i].SourceType < infos[j].SourceType
+		}
+		return infos[i].Entry < infos[j].Entry
+	})
+	return infos
+}
+
+// RemoveEntry removes the entries for an IP address or CIDR, optionally only those of one source type
+// Returns the removed entries. Permanent entries return on the next config reload and DNS entries on
+// the next refresh; a session entry returns if the session authenticates the address again
+func (m *Manager) RemoveEntry(value string, sourceType EntryType) []Entry {
+	matches := func(entry *Entry) bool {
+		return entry.Value() == value && (sourceType == "" || entry.SourceType == sourceType)
+	}
+
+	removed := []Entry{}
+	collect := func(entries *sync.Map) {
+		entries.Range(func(key, v interface{}) bool {
+			if entry := v.(*Entry); matches(entry) {
+				entries.Delete(key)
+				removed = append(removed, *entry)
+			}
+			return true
+		})
+	}
+	collect(&m.exactIPEntries)
+	collect(&m.dnsIPEntries)
+
+	m.cidrMutex.Lock()
+	newCIDREntries := make([]*Entry, 0, len(m.cidrEntries))
+	for _, entry := range m.cidrEntries {
+		if matches(entry) {
+			removed = append(removed, *entry)
+			continue
+		}
+		newCIDREntries = append(newCIDREntries, entry)
+	}
+	m.cidrEntries = newCIDREntries
+	m.cidrMutex.Unlock()
+
+	if len(removed) > 0 {
+		log.Info().
+			Str("entry", value).
+			Int("count", len(removed)).
+			Msg("Removed allowlist entry manually")
+		m.notifyChange()
+	}
+	return removed
+}
+
+// RegisterChangeHook registers a function called whenever entries are added or removed
+// Hooks run synchronously and must not block
+func (m *Manager) RegisterChangeHook(hook func()) {
+	m.changeMutex.Lock()
+	defer m.changeMutex.Unlock()
+	m.changeHooks = append(m.changeHooks, hook)
+}
+
+// SetAccessResolver installs a function consulted for IPs without an allowlist entry
+// It must add a session grant for the IP and return true to allow the request
+func (m *Manager) SetAccessResolver(resolver func(ip netip.Addr) bool) {
+	m.resolver.Store(resolver)
+}
+
+// SetServiceTags installs the lookup of a service's tags, used to evaluate tag selectors in IsIPAllowedForService
+func (m *Manager) SetServiceTags(lookup func(serviceID string) []string) {
+	m.serviceTags.Store(lookup)
+}
+
+// notifyChange calls all registered change hooks
+func (m *Manager) notifyChange() {
+	m.changeMutex.RLock()
+	defer m.changeMutex.RUnlock()
+	for _, hook := range m.changeHooks {
+		hook()
+	}
+}
+
 // Close stops the allowlist manager
 func (m *Manager) Close() {
 	m.cancel()
@@ -355,10 +686,10 @@ func (m *Manager) Reload(newCfg *config.NetworkAccessControlConfig) {
 
 	log.Info().Msg("Reloading IP allowlist configuration...")
 
-	// Step 1: Clear all permanent IP entries (both exact and CIDR)
+	// Step 1: Clear all permanent and hairpin IP entries (both exact and CIDR)
 	m.exactIPEntries.Range(func(key, value interface{}) bool {
 		entry := value.(*Entry)
-		if entry.SourceType == EntryTypePermanent {
+		if entry.SourceType == EntryTypePermanent || entry.SourceType == EntryTypeHairpin {
 			m.exactIPEntries.Delete(key)
 		}
 		return true
@@ -367,7 +698,7 @@ func (m *Manager) Reload(newCfg *config.NetworkAccessControlConfig) {
 	m.cidrMutex.Lock()
 	newCIDREntries := []*Entry{}
 	for _, entry := range m.cidrEntries {
-		if entry.SourceType != EntryTypePermanent {
+		if entry.SourceType != EntryTypePermanent && entry.SourceType != EntryTypeHairpin {
 			newCIDREntries = append(newCIDREntries, entry)
 		}
 	}
@@ -395,7 +726,10 @@ func (m *Manager) Reload(newCfg *config.NetworkAccessControlConfig) {
 		}
 	}
 
-	if hostnamesChanged {
+	// A new IPv6 grant prefix length requires re-expanding all resolved addresses
+	prefixChanged := oldCfg.DNSIPv6GrantPrefixLength != newCfg.DNSIPv6GrantPrefixLength
+
+	if hostnamesChanged || prefixChanged {
 		// Stop old DNS refresh
 		if m.dnsCancel != nil {
 			m.dnsCancel()
@@ -403,6 +737,7 @@ func (m *Manager) Reload(newCfg *config.NetworkAccessControlConfig) {
 
 		// Clear DNS entries
 		m.dnsIPEntries = sync.Map{}
+		m.replaceDNSCIDREntries(nil)
 
 		// Start new DNS refresh
 		if len(newHostnames) > 0 {
@@ -412,11 +747,15 @@ func (m *Manager) Reload(newCfg *config.NetworkAccessControlConfig) {
 		log.Info().
 			Int("old_count", len(oldHostnames)).
 			Int("new_count", len(newHostnames)).
+			Int("ipv6_grant_prefix_length", newCfg.DNSIPv6GrantPrefixLength).
 			Msg("DNS hostnames changed - restarted DNS refresh")
 	}
 
+	m.notifyChange()
+
 	log.Info().
 		Int("permanent_ip_ranges", len(newCfg.PermanentlyAllowedIPRanges)).
+		Int("hairpin_lan_ranges", len(newCfg.HairpinLANRanges)).
 		Int("dns_hostnames", len(newCfg.AllowedDynamicDNSHostnames)).
 		Msg("IP allowlist configuration reloaded successfully")
 }
@@ -12,6 +12,7 @@ const (
 	EntryTypePermanent   EntryType = "permanent"
 	EntryTypeDNSResolved EntryType = "dns_resolved"
 	EntryTypeSession     EntryType = "session"
+	EntryTypeHairpin     EntryType = "hairpin" // LAN range of clients reaching the proxy through NAT reflection
 )
 
 // Entry represents an IP allowlist entry
@@ -33,3 +34,24 @@ func (e *Entry) IsExpired() bool {
 	}
 	return time.Now().After(*e.ExpiresAt)
 }
+
+// Value returns the IP address or CIDR the entry allows; IPv4-mapped addresses are shown as IPv4
+func (e *Entry) Value() string {
+	if e.IPPrefix != nil {
+		return e.IPPrefix.String()
+	}
+	return e.IPAddress.Unmap().String()
+}
+
+// EntryInfo describes an allowlist entry and where it came from, for the admin API
+type EntryInfo struct {
+	Entry          string     `json:"entry"` // IP address or CIDR
+	IsCIDR         bool       `json:"is_cidr"`
+	SourceType     EntryType  `json:"source_type"`
+	Hostname       string     `json:"hostname,omitempty"`   // DNS entries: the hostname that resolved to it
+	SessionID      string     `json:"session_id,omitempty"` // Session entries: the session that was granted it
+	Username       string     `json:"username,omitempty"`   // Session entries: filled in by the caller
+	AddedAt        time.Time  `json:"added_at"`
+	ExpiresAt      *time.Time `json:"expires_at"` // nil for permanent and DNS entries
+	LastVerifiedAt *time.Time `json:"last_verified_at,omitempty"`
+}
@@ -0,0 +1,6 @@
+package ipblocklist
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["ipblocklist"] overrides its level
+var log = logging.Module("ipblocklist")
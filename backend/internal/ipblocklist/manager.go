@@ -2,10 +2,15 @@ package ipblocklist
 
 import (
 	"net"
+	"net/netip"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/davbauer/knock-knock-portal/internal/config"
-	"github.com/rs/zerolog/log"
+	"github.com/davbauer/knock-knock-portal/internal/logsample"
+	"github.com/rs/zerolog"
 )
 
 // Manager handles IP blocklist checking with HIGHEST priority
@@ -14,13 +19,70 @@ type Manager struct {
 	mu           sync.RWMutex
 	blockedIPs   map[string]bool // Specific IPs that are blocked
 	blockedCIDRs []*net.IPNet    // CIDR ranges that are blocked
+
+	// Runtime blocks (e.g. from the honeypot); kept across configuration reloads
+	temporaryBlocks map[string]TemporaryBlock
+
+	blockedLogs *logsample.Sampler     // Every packet from a blocked IP is checked; log each IP once per minute
+	hits        atomic.Int64           // Checks that found the IP blocked, since startup
+	lastHit     atomic.Int64           // Unix nanoseconds of the last blocked check; 0 before the first
+	entryHits   map[string]*hitCounter // Per entry, keyed by hitKey; static entries keep theirs across reloads
+
+	changeMutex sync.RWMutex
+	changeHooks []func() // Called after blocks were added or removed; must not block
+}
+
+// hitCounter counts the checks an entry blocked; updated under the read lock
+type hitCounter struct {
+	count   atomic.Int64
+	lastHit atomic.Int64 // Unix nanoseconds; 0 before the first hit
+}
+
+// record counts a blocked check
+func (h *hitCounter) record(now time.Time) {
+	h.count.Add(1)
+	h.lastHit.Store(now.UnixNano())
+}
+
+// Entry types reported by ListEntries
+const (
+	EntryTypeIP        = "ip"
+	EntryTypeCIDR      = "cidr"
+	EntryTypeTemporary = "temporary"
+)
+
+// BlockEntry is a blocked IP address or range with how often it blocked a check
+type BlockEntry struct {
+	Entry     string     `json:"entry"` // IP address or CIDR
+	Type      string     `json:"type"`  // ip | cidr | temporary
+	Reason    string     `json:"reason,omitempty"`
+	BlockedAt *time.Time `json:"blocked_at,omitempty"` // Temporary blocks only
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Temporary blocks only
+	Hits      int64      `json:"hits"`                 // Since startup, or since the block was created
+	LastHit   *time.Time `json:"last_hit"`
+}
+
+// hitKey identifies the counter of an entry
+func hitKey(entryType, entry string) string {
+	return entryType + "/" + entry
+}
+
+// TemporaryBlock is an IP blocked at runtime until ExpiresAt
+type TemporaryBlock struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	BlockedAt time.Time `json:"blocked_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // NewManager creates a new blocklist manager
 func NewManager(cfg *config.NetworkAccessControlConfig) *Manager {
 	m := &Manager{
-		blockedIPs:   make(map[string]bool),
-		blockedCIDRs: make([]*net.IPNet, 0),
+		blockedIPs:      make(map[string]bool),
+		blockedCIDRs:    make([]*net.IPNet, 0),
+		temporaryBlocks: make(map[string]TemporaryBlock),
+		entryHits:       make(map[string]*hitCounter),
+		blockedLogs:     logsample.New("blocked IP", time.Minute, zerolog.WarnLevel, log),
 	}
 
 	m.Reload(cfg)
@@ -29,6 +91,7 @@ func NewManager(cfg *config.NetworkAccessControlConfig) *Manager {
 
 // Reload updates the blocklist from configuration
 func (m *Manager) Reload(cfg *config.NetworkAccessControlConfig) {
+	defer m.notifyChange()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -64,6 +127,26 @@ func (m *Manager) Reload(cfg *config.NetworkAccessControlConfig) {
 			Msg("Added IP to blocklist")
 	}
 
+	// Keep the counters of entries that are still blocked
+	counters := make(map[string]*hitCounter, len(m.blockedIPs)+len(m.blockedCIDRs)+len(m.temporaryBlocks))
+	keep := func(key string) {
+		if counter, ok := m.entryHits[key]; ok {
+			counters[key] = counter
+		} else {
+			counters[key] = &hitCounter{}
+		}
+	}
+	for ip := range m.blockedIPs {
+		keep(hitKey(EntryTypeIP, ip))
+	}
+	for _, cidr := range m.blockedCIDRs {
+		keep(hitKey(EntryTypeCIDR, cidr.String()))
+	}
+	for ip := range m.temporaryBlocks {
+		keep(hitKey(EntryTypeTemporary, ip))
+	}
+	m.entryHits = counters
+
 	log.Info().
 		Int("blocked_ips", len(m.blockedIPs)).
 		Int("blocked_cidrs", len(m.blockedCIDRs)).
@@ -81,27 +164,78 @@ func (m *Manager) IsIPBlocked(ip net.IP) (bool, string) {
 		return true, "invalid IP address"
 	}
 
+	entryType, entry, reason := m.match(ip)
+	if entryType == "" {
+		// Not blocked
+		return false, ""
+	}
+
+	if m.blockedLogs.Allow(ip.String(), "", entryType) {
+		switch entryType {
+		case EntryTypeIP:
+			log.Warn().
+				Str("ip", ip.String()).
+				Msg("IP blocked: matches blocklist")
+		case EntryTypeCIDR:
+			log.Warn().
+				Str("ip", ip.String()).
+				Str("cidr", entry).
+				Msg("IP blocked: matches blocked CIDR range")
+		default:
+			log.Warn().
+				Str("ip", ip.String()).
+				Str("reason", m.temporaryBlocks[entry].Reason).
+				Msg("IP blocked: temporarily blocked")
+		}
+	}
+	m.recordHit(entryType, entry)
+	return true, reason
+}
+
+// BlockReason reports whether an IP is blocked and why, without logging or counting a hit
+// Meant for admin views; the reason names the matching entry and must not be shown to the blocked client
+func (m *Manager) BlockReason(ip net.IP) (bool, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if ip == nil {
+		return true, "invalid IP address"
+	}
+	entryType, _, reason := m.match(ip)
+	return entryType != "", reason
+}
+
+// match finds the entry blocking an IP; returns an empty entry type if none does
+// Must be called with mu held for reading
+func (m *Manager) match(ip net.IP) (entryType, entry, reason string) {
 	// Check specific blocked IPs first
-	if m.blockedIPs[ip.String()] {
-		log.Warn().
-			Str("ip", ip.String()).
-			Msg("IP blocked: matches blocklist")
-		return true, "IP is on blocklist"
+	key := ip.String()
+	if m.blockedIPs[key] {
+		return EntryTypeIP, key, "IP is on blocklist"
 	}
 
 	// Check blocked CIDR ranges
 	for _, cidr := range m.blockedCIDRs {
 		if cidr.Contains(ip) {
-			log.Warn().
-				Str("ip", ip.String()).
-				Str("cidr", cidr.String()).
-				Msg("IP blocked: matches blocked CIDR range")
-			return true, "IP is in blocked CIDR range: " + cidr.String()
+			return EntryTypeCIDR, cidr.String(), "IP is in blocked CIDR range: " + cidr.String()
 		}
 	}
 
-	// Not blocked
-	return false, ""
+	// Check runtime blocks
+	if block, ok := m.temporaryBlocks[key]; ok && time.Now().Before(block.ExpiresAt) {
+		return EntryTypeTemporary, key, "IP is temporarily blocked: " + block.Reason
+	}
+	return "", "", ""
+}
+
+// recordHit counts a blocked check for the total and the matching entry; called with mu held for reading
+func (m *Manager) recordHit(entryType, entry string) {
+	now := time.Now()
+	m.hits.Add(1)
+	m.lastHit.Store(now.UnixNano())
+	if counter, ok := m.entryHits[hitKey(entryType, entry)]; ok {
+		counter.record(now)
+	}
 }
 
 // GetStats returns blocklist statistics
@@ -109,8 +243,222 @@ func (m *Manager) GetStats() map[string]interface{} {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return map[string]interface{}{
-		"blocked_ips_count":   len(m.blockedIPs),
-		"blocked_cidrs_count": len(m.blockedCIDRs),
+	temporary := 0
+	now := time.Now()
+	for _, block := range m.temporaryBlocks {
+		if now.Before(block.ExpiresAt) {
+			temporary++
+		}
+	}
+
+	entriesHit := 0
+	for _, counter := range m.entryHits {
+		if counter.count.Load() > 0 {
+			entriesHit++
+		}
+	}
+
+	stats := map[string]interface{}{
+		"blocked_ips_count":      len(m.blockedIPs),
+		"blocked_cidrs_count":    len(m.blockedCIDRs),
+		"temporary_blocks_count": temporary,
+		"hits":                   m.hits.Load(),
+		"entries_with_hits":      entriesHit,
+		"last_hit":               nil,
+	}
+	if last := m.lastHit.Load(); last != 0 {
+		stats["last_hit"] = time.Unix(0, last)
+	}
+	return stats
+}
+
+// ListEntries returns the configured IPs and CIDR ranges, then the active temporary blocks (soonest
+// expiry first), each with its hit counter
+func (m *Manager) ListEntries() []BlockEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneTemporaryBlocks(time.Now())
+
+	entries := make([]BlockEntry, 0, len(m.blockedIPs)+len(m.blockedCIDRs)+len(m.temporaryBlocks))
+	for ip := range m.blockedIPs {
+		entries = append(entries, m.blockEntry(EntryTypeIP, ip))
+	}
+	for _, cidr := range m.blockedCIDRs {
+		entries = append(entries, m.blockEntry(EntryTypeCIDR, cidr.String()))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type == EntryTypeIP
+		}
+		return entries[i].Entry < entries[j].Entry
+	})
+
+	for _, block := range m.sortedTemporaryBlocks() {
+		entry := m.blockEntry(EntryTypeTemporary, block.IP)
+		entry.Reason = block.Reason
+		entry.BlockedAt = &block.BlockedAt
+		entry.ExpiresAt = &block.ExpiresAt
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// blockEntry returns an entry with its hit counter; must be called with mu held
+func (m *Manager) blockEntry(entryType, entry string) BlockEntry {
+	result := BlockEntry{Entry: entry, Type: entryType}
+	if counter, ok := m.entryHits[hitKey(entryType, entry)]; ok {
+		result.Hits = counter.count.Load()
+		if last := counter.lastHit.Load(); last != 0 {
+			lastHit := time.Unix(0, last)
+			result.LastHit = &lastHit
+		}
+	}
+	return result
+}
+
+// BlockTemporarily blocks an IP for the given duration, extending an existing block
+// Returns the block as stored
+func (m *Manager) BlockTemporarily(ip net.IP, duration time.Duration, reason string) TemporaryBlock {
+	defer m.notifyChange()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.pruneTemporaryBlocks(now)
+
+	key := ip.String()
+	block, exists := m.temporaryBlocks[key]
+	if !exists {
+		block = TemporaryBlock{IP: key, BlockedAt: now}
+		m.entryHits[hitKey(EntryTypeTemporary, key)] = &hitCounter{}
+	}
+	block.Reason = reason
+	if expiresAt := now.Add(duration); expiresAt.After(block.ExpiresAt) {
+		block.ExpiresAt = expiresAt
+	}
+	m.temporaryBlocks[key] = block
+
+	log.Info().
+		Str("ip", key).
+		Str("reason", reason).
+		Time("expires_at", block.ExpiresAt).
+		Msg("IP temporarily blocked")
+	return block
+}
+
+// RestoreTemporaryBlock re-creates a block from a backup, keeping its times
+// Returns false if the block has expired or the IP is already blocked until later
+func (m *Manager) RestoreTemporaryBlock(block TemporaryBlock) bool {
+	ip := net.ParseIP(block.IP)
+	if ip == nil || !time.Now().Before(block.ExpiresAt) {
+		return false
+	}
+
+	defer m.notifyChange()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := ip.String()
+	if existing, exists := m.temporaryBlocks[key]; exists && !existing.ExpiresAt.Before(block.ExpiresAt) {
+		return false
+	}
+	block.IP = key
+	m.temporaryBlocks[key] = block
+	if _, ok := m.entryHits[hitKey(EntryTypeTemporary, key)]; !ok {
+		m.entryHits[hitKey(EntryTypeTemporary, key)] = &hitCounter{}
+	}
+	return true
+}
+
+// Unblock removes a temporary block
+// Returns false if the IP was not temporarily blocked
+func (m *Manager) Unblock(ip net.IP) bool {
+	defer m.notifyChange()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := ip.String()
+	block, exists := m.temporaryBlocks[key]
+	delete(m.temporaryBlocks, key)
+	delete(m.entryHits, hitKey(EntryTypeTemporary, key))
+	return exists && time.Now().Before(block.ExpiresAt)
+}
+
+// BlockedPrefixes returns every blocked IP, CIDR range and active temporary block as a prefix
+// Used by enforcement outside the proxies, such as kernel firewall rules
+func (m *Manager) BlockedPrefixes() []netip.Prefix {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	prefixes := make([]netip.Prefix, 0, len(m.blockedIPs)+len(m.blockedCIDRs)+len(m.temporaryBlocks))
+	addIP := func(value string) {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	for ip := range m.blockedIPs {
+		addIP(ip)
+	}
+	for _, cidr := range m.blockedCIDRs {
+		if prefix, err := netip.ParsePrefix(cidr.String()); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	for ip, block := range m.temporaryBlocks {
+		if now.Before(block.ExpiresAt) {
+			addIP(ip)
+		}
+	}
+	return prefixes
+}
+
+// RegisterChangeHook registers a function called whenever blocks are added or removed
+// Hooks run synchronously and must not block
+func (m *Manager) RegisterChangeHook(hook func()) {
+	m.changeMutex.Lock()
+	defer m.changeMutex.Unlock()
+	m.changeHooks = append(m.changeHooks, hook)
+}
+
+// notifyChange calls all registered change hooks
+func (m *Manager) notifyChange() {
+	m.changeMutex.RLock()
+	defer m.changeMutex.RUnlock()
+	for _, hook := range m.changeHooks {
+		hook()
+	}
+}
+
+// ListTemporaryBlocks returns the active temporary blocks, soonest expiry first
+func (m *Manager) ListTemporaryBlocks() []TemporaryBlock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruneTemporaryBlocks(time.Now())
+	return m.sortedTemporaryBlocks()
+}
+
+// sortedTemporaryBlocks returns the temporary blocks, soonest expiry first; must be called with mu held
+func (m *Manager) sortedTemporaryBlocks() []TemporaryBlock {
+	blocks := make([]TemporaryBlock, 0, len(m.temporaryBlocks))
+	for _, block := range m.temporaryBlocks {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].ExpiresAt.Before(blocks[j].ExpiresAt)
+	})
+	return blocks
+}
+
+// pruneTemporaryBlocks drops expired temporary blocks; must be called with mu held
+func (m *Manager) pruneTemporaryBlocks(now time.Time) {
+	for key, block := range m.temporaryBlocks {
+		if !now.Before(block.ExpiresAt) {
+			delete(m.temporaryBlocks, key)
+			delete(m.entryHits, hitKey(EntryTypeTemporary, key))
+		}
 	}
 }
@@ -0,0 +1,204 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const (
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAFile    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// client is a minimal Kubernetes REST client for listing and watching Services
+type client struct {
+	baseURL    string
+	tokenFile  string
+	namespace  string
+	httpClient *http.Client
+}
+
+// service mirrors the fields of a core/v1 Service the controller needs
+type service struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		ClusterIP string        `json:"clusterIP"`
+		Ports     []servicePort `json:"ports"`
+	} `json:"spec"`
+}
+
+type servicePort struct {
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	NodePort int    `json:"nodePort"`
+}
+
+type serviceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []service `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"` // ADDED | MODIFIED | DELETED | BOOKMARK | ERROR
+	Object json.RawMessage `json:"object"`
+}
+
+// errResourceExpired signals that the watch must restart from a fresh list
+var errResourceExpired = fmt.Errorf("watch resource version expired")
+
+// newClient builds a client from configuration, defaulting to in-cluster service account credentials
+func newClient(cfg *config.KubernetesConfiguration) (*client, error) {
+	baseURL := cfg.APIServerURL
+	if baseURL == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("not running in a cluster and no api_server_url configured")
+		}
+		baseURL = "https://" + net.JoinHostPort(host, port)
+	}
+
+	tokenFile := cfg.BearerTokenFile
+	if tokenFile == "" {
+		tokenFile = serviceAccountTokenFile
+	}
+
+	caFile := cfg.CAFile
+	if caFile == "" && cfg.APIServerURL == "" {
+		caFile = serviceAccountCAFile
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		caData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		tokenFile:  tokenFile,
+		namespace:  cfg.Namespace,
+		httpClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+// servicesPath returns the Services collection path for the configured namespace
+func (c *client) servicesPath() string {
+	if c.namespace == "" {
+		return "/api/v1/services"
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(c.namespace) + "/services"
+}
+
+// get performs an authenticated GET request against the API server
+func (c *client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Projected service account tokens rotate, so read the file on every request
+	if token, err := os.ReadFile(c.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes API request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusGone {
+			return nil, errResourceExpired
+		}
+		return nil, fmt.Errorf("kubernetes API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+// listServices returns all Services and the collection resource version
+func (c *client) listServices(ctx context.Context) ([]service, string, error) {
+	resp, err := c.get(ctx, c.servicesPath(), url.Values{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var list serviceList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("failed to decode service list: %w", err)
+	}
+
+	return list.Items, list.Metadata.ResourceVersion, nil
+}
+
+// watchServices streams Service changes after resourceVersion until the server closes the watch
+func (c *client) watchServices(ctx context.Context, resourceVersion string, onEvent func(eventType string, svc *service)) error {
+	query := url.Values{}
+	query.Set("watch", "1")
+	query.Set("resourceVersion", resourceVersion)
+	query.Set("timeoutSeconds", "300")
+
+	resp, err := c.get(ctx, c.servicesPath(), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("watch stream failed: %w", err)
+		}
+
+		switch event.Type {
+		case "ERROR":
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				return errResourceExpired
+			}
+			return fmt.Errorf("watch error: %s", status.Message)
+		case "ADDED", "MODIFIED", "DELETED":
+			var svc service
+			if err := json.Unmarshal(event.Object, &svc); err != nil {
+				return fmt.Errorf("failed to decode watch event: %w", err)
+			}
+			onEvent(event.Type, &svc)
+		}
+	}
+}
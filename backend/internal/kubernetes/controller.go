@@ -0,0 +1,319 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// Source identifies services managed by the controller in the config loader
+const Source = "kubernetes"
+
+const retryInterval = 10 * time.Second
+
+// Controller watches annotated cluster Services and publishes them as protected services
+//
+// Supported annotations (with the default "knock-knock.io" prefix):
+//
+//	knock-knock.io/enabled          "true" to gate this Service (required)
+//	knock-knock.io/listen-port      proxy listen port on the portal (required)
+//	knock-knock.io/listen-port-end  optional end of the listen port range
+//	knock-knock.io/port             Service port name or number (default: first port)
+//	knock-knock.io/target           clusterip (default) | dns | nodeport
+//	knock-knock.io/protocol         tcp | udp | both (default: from the Service port)
+//	knock-knock.io/http             "true" to proxy as HTTP
+//	knock-knock.io/name             display name (default: namespace/name)
+type Controller struct {
+	configLoader *config.Loader
+	mu           sync.Mutex
+	cfg          config.KubernetesConfiguration
+	cancel       context.CancelFunc
+	submitted    []config.ProtectedServiceConfig // Converted from the annotated Services
+	published    []config.ProtectedServiceConfig // Accepted by the config loader
+	lastSync     time.Time
+	lastError    string
+}
+
+// NewController creates a new Kubernetes controller and starts it if enabled
+func NewController(configLoader *config.Loader, cfg *config.KubernetesConfiguration) *Controller {
+	c := &Controller{
+		configLoader: configLoader,
+	}
+
+	c.Reload(cfg)
+	return c
+}
+
+// Reload restarts the controller when its configuration changed
+func (c *Controller) Reload(cfg *config.KubernetesConfiguration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Publishing services triggers a config reload, so ignore unchanged settings
+	if c.cancel != nil && c.cfg == *cfg {
+		return
+	}
+
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	c.cfg = *cfg
+
+	if !cfg.Enabled {
+		if c.submitted != nil {
+			c.submitted = nil
+			c.published = nil
+			go c.configLoader.SetExternalServices(Source, nil)
+			log.Info().Msg("Kubernetes controller stopped")
+		}
+		return
+	}
+
+	cl, err := newClient(cfg)
+	if err != nil {
+		c.lastError = err.Error()
+		log.Error().Err(err).Msg("Failed to initialize Kubernetes client")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go c.run(ctx, cl, *cfg)
+
+	log.Info().
+		Str("namespace", cfg.Namespace).
+		Str("annotation_prefix", cfg.AnnotationPrefix).
+		Msg("Kubernetes controller started")
+}
+
+// run lists Services, then follows the watch stream, re-listing whenever the watch ends
+func (c *Controller) run(ctx context.Context, cl *client, cfg config.KubernetesConfiguration) {
+	for ctx.Err() == nil {
+		err := c.syncOnce(ctx, cl, cfg)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil && err != errResourceExpired {
+			c.mu.Lock()
+			c.lastError = err.Error()
+			c.mu.Unlock()
+			log.Warn().Err(err).Msg("Kubernetes sync failed, retrying")
+
+			select {
+			case <-time.After(retryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// syncOnce performs one list followed by a watch until it ends
+func (c *Controller) syncOnce(ctx context.Context, cl *client, cfg config.KubernetesConfiguration) error {
+	items, resourceVersion, err := cl.listServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]service, len(items))
+	for _, svc := range items {
+		known[svc.Metadata.Namespace+"/"+svc.Metadata.Name] = svc
+	}
+	c.publish(ctx, known, cfg)
+
+	return cl.watchServices(ctx, resourceVersion, func(eventType string, svc *service) {
+		key := svc.Metadata.Namespace + "/" + svc.Metadata.Name
+		if eventType == "DELETED" {
+			delete(known, key)
+		} else {
+			known[key] = *svc
+		}
+		c.publish(ctx, known, cfg)
+	})
+}
+
+// publish converts annotated Services and hands them to the config loader if anything changed
+// Services the loader rejects are skipped and reported; the others are still published
+func (c *Controller) publish(ctx context.Context, known map[string]service, cfg config.KubernetesConfiguration) {
+	keys := make([]string, 0, len(known))
+	for key := range known {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	services := make([]config.ProtectedServiceConfig, 0)
+	for _, key := range keys {
+		svc := known[key]
+		protected, ok, err := toProtectedService(&svc, &cfg)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("service", key).
+				Msg("Ignoring Kubernetes service with invalid annotations")
+			continue
+		}
+		if ok {
+			services = append(services, protected)
+		}
+	}
+
+	c.mu.Lock()
+	c.lastSync = time.Now()
+	unchanged := reflect.DeepEqual(services, c.submitted) || (len(services) == 0 && len(c.submitted) == 0)
+	if ctx.Err() != nil || unchanged {
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	published, err := c.configLoader.SetExternalServices(Source, services)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Int("published", len(published)).
+			Msg("Skipped Kubernetes services that conflict with the config")
+	}
+
+	c.mu.Lock()
+	c.submitted = services
+	c.published = published
+	c.lastError = ""
+	if err != nil {
+		c.lastError = err.Error()
+	}
+	c.mu.Unlock()
+}
+
+// toProtectedService maps an annotated Service; ok is false when the Service is not opted in
+func toProtectedService(svc *service, cfg *config.KubernetesConfiguration) (config.ProtectedServiceConfig, bool, error) {
+	annotation := func(name string) string {
+		return strings.TrimSpace(svc.Metadata.Annotations[cfg.AnnotationPrefix+"/"+name])
+	}
+
+	if !strings.EqualFold(annotation("enabled"), "true") {
+		return config.ProtectedServiceConfig{}, false, nil
+	}
+
+	listenPort, err := strconv.Atoi(annotation("listen-port"))
+	if err != nil {
+		return config.ProtectedServiceConfig{}, false, fmt.Errorf("invalid listen-port annotation: %w", err)
+	}
+	listenPortEnd := listenPort
+	if value := annotation("listen-port-end"); value != "" {
+		if listenPortEnd, err = strconv.Atoi(value); err != nil {
+			return config.ProtectedServiceConfig{}, false, fmt.Errorf("invalid listen-port-end annotation: %w", err)
+		}
+	}
+
+	port, err := selectPort(svc, annotation("port"))
+	if err != nil {
+		return config.ProtectedServiceConfig{}, false, err
+	}
+
+	var backendHost string
+	backendPort := port.Port
+	switch target := strings.ToLower(annotation("target")); target {
+	case "", "clusterip":
+		if svc.Spec.ClusterIP == "" || strings.EqualFold(svc.Spec.ClusterIP, "None") {
+			return config.ProtectedServiceConfig{}, false, fmt.Errorf("service has no cluster IP; use target 'dns' for headless services")
+		}
+		backendHost = svc.Spec.ClusterIP
+	case "dns":
+		backendHost = svc.Metadata.Name + "." + svc.Metadata.Namespace + ".svc"
+	case "nodeport":
+		if cfg.NodeAddress == "" || port.NodePort == 0 {
+			return config.ProtectedServiceConfig{}, false, fmt.Errorf("target 'nodeport' requires kubernetes_config.node_address and a NodePort service")
+		}
+		backendHost = cfg.NodeAddress
+		backendPort = port.NodePort
+	default:
+		return config.ProtectedServiceConfig{}, false, fmt.Errorf("unknown target '%s'", target)
+	}
+
+	protocol := strings.ToLower(annotation("protocol"))
+	if protocol == "" {
+		protocol = strings.ToLower(port.Protocol)
+		if protocol == "" {
+			protocol = "tcp"
+		}
+	}
+
+	name := annotation("name")
+	if name == "" {
+		name = svc.Metadata.Namespace + "/" + svc.Metadata.Name
+	}
+
+	return config.ProtectedServiceConfig{
+		ServiceID:            "k8s-" + svc.Metadata.Namespace + "-" + svc.Metadata.Name,
+		ServiceName:          name,
+		ProxyListenPortStart: listenPort,
+		ProxyListenPortEnd:   listenPortEnd,
+		BackendTargetHost:    backendHost,
+		BackendTargetPort:    backendPort,
+		TransportProtocol:    protocol,
+		IsHTTPProtocol:       strings.EqualFold(annotation("http"), "true"),
+		Enabled:              true,
+		Description:          "Managed by Kubernetes service " + svc.Metadata.Namespace + "/" + svc.Metadata.Name,
+	}, true, nil
+}
+
+// selectPort picks the Service port referenced by name or number, or the first port
+func selectPort(svc *service, ref string) (servicePort, error) {
+	if len(svc.Spec.Ports) == 0 {
+		return servicePort{}, fmt.Errorf("service has no ports")
+	}
+	if ref == "" {
+		return svc.Spec.Ports[0], nil
+	}
+
+	for _, port := range svc.Spec.Ports {
+		if port.Name == ref || strconv.Itoa(port.Port) == ref {
+			return port, nil
+		}
+	}
+	return servicePort{}, fmt.Errorf("service has no port '%s'", ref)
+}
+
+// Close stops the controller
+func (c *Controller) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+}
+
+// GetStats returns controller status
+func (c *Controller) GetStats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	serviceIDs := make([]string, 0, len(c.published))
+	for _, svc := range c.published {
+		serviceIDs = append(serviceIDs, svc.ServiceID)
+	}
+
+	stats := map[string]interface{}{
+		"enabled":     c.cfg.Enabled,
+		"running":     c.cancel != nil,
+		"service_ids": serviceIDs,
+	}
+	if !c.lastSync.IsZero() {
+		stats["last_sync"] = c.lastSync
+	}
+	if c.lastError != "" {
+		stats["last_error"] = c.lastError
+	}
+	return stats
+}
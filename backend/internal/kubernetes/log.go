@@ -0,0 +1,6 @@
+package kubernetes
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["kubernetes"] overrides its level
+var log = logging.Module("kubernetes")
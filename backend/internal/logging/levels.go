@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// levelTable is the default level and the overrides from logging_config.levels
+type levelTable struct {
+	defaultLevel zerolog.Level
+	overrides    map[string]zerolog.Level // Module ("proxy.udp") or "service.<service_id>" -> level
+}
+
+// levels is nil until SetLevels; the global zerolog level applies until then
+var levels atomic.Pointer[levelTable]
+
+// SetLevels installs per-module level overrides on top of defaultLevel
+// The global zerolog level is lowered to the most verbose override so module loggers can emit
+// below the default; module loggers filter everything else back to the default level
+func SetLevels(defaultLevel zerolog.Level, overrides map[string]string) error {
+	table := &levelTable{
+		defaultLevel: defaultLevel,
+		overrides:    make(map[string]zerolog.Level, len(overrides)),
+	}
+	minLevel := defaultLevel
+	for module, name := range overrides {
+		level, err := zerolog.ParseLevel(strings.ToLower(name))
+		if err != nil || name == "" {
+			return fmt.Errorf("invalid log level %q for %s", name, module)
+		}
+		table.overrides[module] = level
+		minLevel = min(minLevel, level)
+	}
+
+	levels.Store(table)
+	zerolog.SetGlobalLevel(minLevel)
+	return nil
+}
+
+// Logger creates log events for one module (and optionally one service), applying its level override
+// Packages declare it as their `log` variable, so call sites read like the zerolog global logger
+type Logger struct {
+	module  string
+	service string
+}
+
+// Module returns the logger of a module; dotted names inherit overrides from their parents,
+// e.g. "proxy.udp" falls back to "proxy"
+func Module(name string) Logger {
+	return Logger{module: name}
+}
+
+// Service returns a copy of the logger that also honors a "service.<service_id>" override
+func (l Logger) Service(serviceID string) Logger {
+	l.service = serviceID
+	return l
+}
+
+// level returns the effective level of the logger
+func (l Logger) level() zerolog.Level {
+	table := levels.Load()
+	if table == nil {
+		return zerolog.GlobalLevel()
+	}
+
+	if l.service != "" {
+		if level, ok := table.overrides["service."+l.service]; ok {
+			return level
+		}
+	}
+	for name := l.module; name != ""; {
+		if level, ok := table.overrides[name]; ok {
+			return level
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return table.defaultLevel
+}
+
+// Enabled reports whether events of level would be written
+func (l Logger) Enabled(level zerolog.Level) bool {
+	return level >= l.level() && level >= zerolog.GlobalLevel()
+}
+
+// WithLevel starts an event of the given level; nil (a no-op event) if the level is filtered
+func (l Logger) WithLevel(level zerolog.Level) *zerolog.Event {
+	if !l.Enabled(level) {
+		return nil
+	}
+	return log.WithLevel(level).Str("module", l.module)
+}
+
+// Debug starts a debug event
+func (l Logger) Debug() *zerolog.Event {
+	return l.WithLevel(zerolog.DebugLevel)
+}
+
+// Info starts an info event
+func (l Logger) Info() *zerolog.Event {
+	return l.WithLevel(zerolog.InfoLevel)
+}
+
+// Warn starts a warn event
+func (l Logger) Warn() *zerolog.Event {
+	return l.WithLevel(zerolog.WarnLevel)
+}
+
+// Error starts an error event
+func (l Logger) Error() *zerolog.Event {
+	return l.WithLevel(zerolog.ErrorLevel)
+}
+
+// Fatal starts a fatal event; the process exits after Msg regardless of overrides
+func (l Logger) Fatal() *zerolog.Event {
+	return log.Fatal().Str("module", l.module)
+}
@@ -0,0 +1,274 @@
+// Package logging provides log output targets other than stderr.
+package logging
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	syslogQueueSize    = 4096
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	syslogCloseTimeout = 2 * time.Second
+)
+
+// facilityCodes maps facility names to their RFC 5424 codes
+var facilityCodes = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// localSyslogSockets are tried in order when no address is configured
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter sends zerolog events to a syslog server as RFC 5424 messages
+// Events are queued and sent by a background goroutine so a slow or unreachable collector never
+// blocks the proxies; when the queue is full events are dropped and the count is reported later
+type SyslogWriter struct {
+	network   string // udp, tcp, tls or unixgram/unix for the local socket
+	address   string
+	tlsConfig *tls.Config
+	stream    bool // Stream transports need framing (RFC 6587 octet counting)
+
+	facility int
+	hostname string
+	appName  string
+	pid      int
+
+	mu      sync.RWMutex // Guards closed against sends on the closed queue
+	closed  bool
+	queue   chan []byte
+	done    chan struct{}
+	dropped atomic.Uint64
+	conn    net.Conn
+	failing bool // Last send failed; further failures are not reported to stderr again
+}
+
+// NewSyslogWriter creates a writer for address (udp://, tcp:// or tls://host:port; empty = local socket)
+func NewSyslogWriter(address, facility, appName, caFile string) (*SyslogWriter, error) {
+	if facility == "" {
+		facility = "daemon"
+	}
+	code, ok := facilityCodes[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	if appName == "" {
+		appName = "knock-knock"
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	w := &SyslogWriter{
+		facility: code,
+		hostname: hostname,
+		appName:  appName,
+		pid:      os.Getpid(),
+		queue:    make(chan []byte, syslogQueueSize),
+		done:     make(chan struct{}),
+	}
+
+	if address == "" {
+		if err := w.findLocalSocket(); err != nil {
+			return nil, err
+		}
+	} else {
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %q", address)
+		}
+		w.network, w.address = u.Scheme, u.Host
+		switch u.Scheme {
+		case "udp":
+		case "tcp":
+			w.stream = true
+		case "tls":
+			w.stream = true
+			w.tlsConfig = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+			if caFile != "" {
+				caData, err := os.ReadFile(caFile)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read syslog CA file: %w", err)
+				}
+				pool := x509.NewCertPool()
+				if !pool.AppendCertsFromPEM(caData) {
+					return nil, fmt.Errorf("no certificates found in %s", caFile)
+				}
+				w.tlsConfig.RootCAs = pool
+			}
+		default:
+			return nil, fmt.Errorf("unsupported syslog scheme %q (use udp, tcp or tls)", u.Scheme)
+		}
+
+		// Fail at startup on a bad target instead of silently queueing into the void
+		if err := w.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// findLocalSocket connects to the first local syslog socket that accepts connections
+func (w *SyslogWriter) findLocalSocket() error {
+	for _, path := range localSyslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.DialTimeout(network, path, syslogDialTimeout)
+			if err != nil {
+				continue
+			}
+			w.network, w.address, w.conn = network, path, conn
+			return nil
+		}
+	}
+	return fmt.Errorf("no local syslog socket found (tried %v); set a syslog address", localSyslogSockets)
+}
+
+// Write implements io.Writer for events without a level
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel implements zerolog.LevelWriter; the level selects the syslog severity
+func (w *SyslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	// Events logged during shutdown still go somewhere
+	if w.closed {
+		return os.Stderr.Write(p)
+	}
+
+	select {
+	case w.queue <- w.format(level, p):
+	default:
+		w.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// format builds an RFC 5424 message: <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (w *SyslogWriter) format(level zerolog.Level, p []byte) []byte {
+	msg := bytes.TrimRight(p, "\n")
+	return fmt.Appendf(nil, "<%d>1 %s %s %s %d - - %s",
+		w.facility*8+severity(level),
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, w.pid, msg)
+}
+
+// severity maps zerolog levels to syslog severities
+func severity(level zerolog.Level) int {
+	switch level {
+	case zerolog.PanicLevel:
+		return 1 // alert
+	case zerolog.FatalLevel:
+		return 2 // crit
+	case zerolog.ErrorLevel:
+		return 3 // err
+	case zerolog.WarnLevel:
+		return 4 // warning
+	case zerolog.DebugLevel, zerolog.TraceLevel:
+		return 7 // debug
+	default:
+		return 6 // info
+	}
+}
+
+// run sends queued messages until Close
+func (w *SyslogWriter) run() {
+	defer close(w.done)
+	for msg := range w.queue {
+		if dropped := w.dropped.Swap(0); dropped > 0 {
+			notice := w.format(zerolog.WarnLevel, fmt.Appendf(nil, `{"level":"warn","message":"Dropped %d log events while the syslog queue was full"}`, dropped))
+			w.send(notice)
+		}
+		w.send(msg)
+	}
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+// send writes one message, reconnecting once if the connection broke
+func (w *SyslogWriter) send(msg []byte) {
+	if w.stream {
+		msg = append(strconv.AppendInt(nil, int64(len(msg)), 10), append([]byte{' '}, msg...)...)
+	} else if w.network == "unix" {
+		msg = append(msg, '\n')
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if err = w.connect(); err != nil {
+				continue
+			}
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err = w.conn.Write(msg); err == nil {
+			w.failing = false
+			return
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	// Logging about logging would loop back here; report the first failure of a streak on stderr
+	if !w.failing {
+		w.failing = true
+		fmt.Fprintf(os.Stderr, "syslog: failed to send to %s://%s: %v\n", w.network, w.address, err)
+	}
+}
+
+// connect dials the syslog target
+func (w *SyslogWriter) connect() error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	if w.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.address, w.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(w.network, w.address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog %s://%s: %w", w.network, w.address, err)
+	}
+	w.conn = conn
+	return nil
+}
+
+// Close flushes queued messages (bounded by a short timeout) and closes the connection
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-time.After(syslogCloseTimeout):
+	}
+	return nil
+}
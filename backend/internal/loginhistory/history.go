@@ -0,0 +1,136 @@
+// Package loginhistory keeps the recent login attempts of each portal user.
+//
+// Successful and failed attempts are stored per user ID with the client IP and user agent,
+// so users and admins can spot logins they don't recognize. The newest attempts of each
+// user are kept in the data directory.
+package loginhistory
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	historyFile = "login_history.json"
+
+	// maxAttemptsPerUser bounds the stored attempts of one user; older ones are dropped
+	maxAttemptsPerUser = 100
+
+	// maxUserAgentLength truncates user agents so clients can't grow the file
+	maxUserAgentLength = 256
+)
+
+// Attempt results
+const (
+	ResultSuccess         = "success"
+	ResultFailed          = "failed"
+	ResultPendingApproval = "pending_approval"
+)
+
+// Attempt is a single login attempt
+type Attempt struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent"`
+	Result    string    `json:"result"`           // success | failed | pending_approval
+	Reason    string    `json:"reason,omitempty"` // Why a failed attempt was refused, e.g. invalid_password
+}
+
+// History stores login attempts per user ID
+type History struct {
+	path     string
+	attempts map[string][]Attempt // Oldest first
+	mu       sync.Mutex
+}
+
+// NewHistory creates a login history persisted in dataDir (empty = in memory only)
+func NewHistory(dataDir string) *History {
+	h := &History{attempts: make(map[string][]Attempt)}
+	if dataDir != "" {
+		h.path = filepath.Join(dataDir, historyFile)
+	}
+	h.load()
+	return h
+}
+
+// Record stores an attempt for a user
+func (h *History) Record(userID string, attempt Attempt) {
+	attempt.Time = time.Now()
+	if len(attempt.UserAgent) > maxUserAgentLength {
+		attempt.UserAgent = attempt.UserAgent[:maxUserAgentLength]
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	attempts := append(h.attempts[userID], attempt)
+	if len(attempts) > maxAttemptsPerUser {
+		attempts = append([]Attempt(nil), attempts[len(attempts)-maxAttemptsPerUser:]...)
+	}
+	h.attempts[userID] = attempts
+	h.save()
+}
+
+// List returns the attempts of a user, newest first
+func (h *History) List(userID string) []Attempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stored := h.attempts[userID]
+	attempts := make([]Attempt, len(stored))
+	for i, attempt := range stored {
+		attempts[len(attempts)-1-i] = attempt
+	}
+	return attempts
+}
+
+// load reads the persisted history from disk
+func (h *History) load() {
+	if h.path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", h.path).Msg("Failed to read login history")
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &h.attempts); err != nil {
+		log.Warn().Err(err).Str("path", h.path).Msg("Failed to parse login history, starting empty")
+		h.attempts = make(map[string][]Attempt)
+	}
+}
+
+// save writes the history to disk
+// Caller must hold mu
+func (h *History) save() {
+	if h.path == "" {
+		return
+	}
+
+	data, err := json.Marshal(h.attempts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode login history")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.path), 0o755); err != nil {
+		log.Error().Err(err).Str("path", h.path).Msg("Failed to save login history")
+		return
+	}
+	tmp := h.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, h.path)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", h.path).Msg("Failed to save login history")
+	}
+}
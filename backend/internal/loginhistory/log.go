@@ -0,0 +1,6 @@
+package loginhistory
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["loginhistory"] overrides its level
+var log = logging.Module("loginhistory")
@@ -0,0 +1,132 @@
+// Package logsample keeps repetitive log lines from flooding the log.
+//
+// A port scanner or a misbehaving client hitting a protected UDP port can trigger a deny log per packet,
+// which turns logging itself into a disk and CPU DoS. A Sampler logs the first event per client IP,
+// service and kind in each window and reports everything after that as a single summary line
+// ("suppressed 5234 repeated ... from 1.2.3.4 in the last 1m0s") once the window ends.
+package logsample
+
+import (
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+	"github.com/rs/zerolog"
+)
+
+// maxKeys bounds the tracked sources, e.g. under a flood with spoofed UDP source addresses
+const maxKeys = 10000
+
+type key struct {
+	clientIP string
+	service  string
+	kind     string
+}
+
+type window struct {
+	start      time.Time
+	suppressed int
+}
+
+// Sampler deduplicates log events per client IP, service and kind
+type Sampler struct {
+	name   string
+	window time.Duration
+	level  zerolog.Level
+	log    logging.Logger // Logger of the owning module, used for summaries
+
+	mu         sync.Mutex
+	windows    map[key]*window
+	overflow   int // Events dropped because maxKeys sources were already tracked
+	flushStart sync.Once
+}
+
+// New creates a sampler; name describes the events in summary lines (e.g. "deny")
+func New(name string, interval time.Duration, level zerolog.Level, logger logging.Logger) *Sampler {
+	return &Sampler{
+		name:    name,
+		window:  interval,
+		level:   level,
+		log:     logger,
+		windows: make(map[key]*window),
+	}
+}
+
+// Allow reports whether the caller should log this event
+// Returns true for the first event of a key in the current window; later events are only counted
+func (s *Sampler) Allow(clientIP, service, kind string) bool {
+	// Don't count events that would not be logged anyway
+	if !s.log.Enabled(s.level) {
+		return false
+	}
+	s.flushStart.Do(func() { go s.flushLoop() })
+
+	k := key{clientIP: clientIP, service: service, kind: kind}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w, ok := s.windows[k]; ok {
+		w.suppressed++
+		return false
+	}
+	if len(s.windows) >= maxKeys {
+		s.overflow++
+		return false
+	}
+	s.windows[k] = &window{start: time.Now()}
+	return true
+}
+
+// flushLoop reports and resets windows that have ended
+// Samplers live for the lifetime of the process, so the loop never stops
+func (s *Sampler) flushLoop() {
+	ticker := time.NewTicker(s.window / 4)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.flush()
+	}
+}
+
+func (s *Sampler) flush() {
+	type summary struct {
+		key
+		suppressed int
+	}
+
+	now := time.Now()
+	var summaries []summary
+
+	s.mu.Lock()
+	for k, w := range s.windows {
+		if now.Sub(w.start) < s.window {
+			continue
+		}
+		delete(s.windows, k)
+		if w.suppressed > 0 {
+			summaries = append(summaries, summary{key: k, suppressed: w.suppressed})
+		}
+	}
+	overflow := s.overflow
+	s.overflow = 0
+	s.mu.Unlock()
+
+	for _, sum := range summaries {
+		event := s.log.WithLevel(s.level).
+			Str("client_ip", sum.clientIP).
+			Str("kind", sum.kind).
+			Int("suppressed", sum.suppressed).
+			Dur("window", s.window)
+		if sum.service != "" {
+			event = event.Str("service", sum.service)
+		}
+		event.Msgf("Suppressed %d repeated %s logs from %s in the last %s", sum.suppressed, s.name, sum.clientIP, s.window)
+	}
+	if overflow > 0 {
+		s.log.WithLevel(s.level).
+			Int("suppressed", overflow).
+			Int("tracked_sources", maxKeys).
+			Msgf("Suppressed %d %s logs from untracked sources (too many distinct sources)", overflow, s.name)
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// APIRateLimiter limits API requests per client IP, with separate budgets for callers with a valid token and anonymous ones
+type APIRateLimiter struct {
+	mu                   sync.RWMutex
+	anonymousEnabled     bool
+	authenticatedEnabled bool
+
+	jwtManager    *auth.JWTManager
+	anonymous     *auth.RateLimiter
+	authenticated *auth.RateLimiter
+}
+
+// NewAPIRateLimiter creates an API rate limiter from configuration
+func NewAPIRateLimiter(cfg *config.APIRateLimitConfig, jwtManager *auth.JWTManager) *APIRateLimiter {
+	l := &APIRateLimiter{
+		jwtManager:    jwtManager,
+		anonymous:     auth.NewRateLimiter(0, 0, 0),
+		authenticated: auth.NewRateLimiter(0, 0, 0),
+	}
+	l.Reload(cfg)
+	l.anonymous.StartCleanup(time.Minute, nil)
+	l.authenticated.StartCleanup(time.Minute, nil)
+	return l
+}
+
+// Reload applies new budgets; a budget with 0 requests per minute is unlimited
+func (l *APIRateLimiter) Reload(cfg *config.APIRateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.anonymousEnabled = cfg.AnonymousRequestsPerMinute > 0
+	l.authenticatedEnabled = cfg.AuthenticatedRequestsPerMinute > 0
+	l.anonymous.Reload(apiLimiterSettings(cfg.AnonymousRequestsPerMinute, cfg.AnonymousBurst, cfg.MaxTrackedIPs))
+	l.authenticated.Reload(apiLimiterSettings(cfg.AuthenticatedRequestsPerMinute, cfg.AuthenticatedBurst, cfg.MaxTrackedIPs))
+}
+
+// apiLimiterSettings returns the settings of one budget; the burst defaults to a minute's worth of requests
+func apiLimiterSettings(requestsPerMinute, burst, maxTrackedIPs int) auth.LimiterSettings {
+	if burst <= 0 {
+		burst = requestsPerMinute
+	}
+	return auth.LimiterSettings{
+		RequestsPerMinute: requestsPerMinute,
+		Burst:             burst,
+		MaxEntries:        maxTrackedIPs,
+	}
+}
+
+// Middleware rejects requests of client IPs that exceeded their budget with 429
+// Must run after the real IP extractor so clients behind a trusted proxy are counted separately
+func (l *APIRateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authenticated := l.hasValidToken(c)
+
+		l.mu.RLock()
+		limiter, enabled := l.anonymous, l.anonymousEnabled
+		if authenticated {
+			limiter, enabled = l.authenticated, l.authenticatedEnabled
+		}
+		l.mu.RUnlock()
+		if !enabled {
+			c.Next()
+			return
+		}
+
+		clientIP := "unknown"
+		if addr, ok := GetClientIP(c); ok {
+			clientIP = addr.String()
+		}
+		if !limiter.Allow(clientIP) {
+			log.Warn().
+				Str("client_ip", clientIP).
+				Str("path", c.Request.URL.Path).
+				Bool("authenticated", authenticated).
+				Msg("Request denied: API rate limit exceeded")
+			c.JSON(429, models.NewErrorResponse("Too many requests. Please try again later.", "RATE_LIMIT_EXCEEDED"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// hasValidToken reports whether the request carries a valid portal or admin token
+func (l *APIRateLimiter) hasValidToken(c *gin.Context) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	_, err := l.jwtManager.ValidateToken(token)
+	return err == nil
+}
@@ -53,6 +53,14 @@ func AuthMiddleware(jwtManager *auth.JWTManager, requiredType auth.TokenType) gi
 	}
 }
 
+// GetAdminName returns the admin identity of the request's admin token
+func GetAdminName(c *gin.Context) string {
+	if claims, ok := GetJWTClaims(c); ok && claims.TokenType == auth.TokenTypeAdmin {
+		return claims.UserID
+	}
+	return ""
+}
+
 // GetJWTClaims retrieves JWT claims from context
 func GetJWTClaims(c *gin.Context) (*auth.JWTClaims, bool) {
 	if claims, exists := c.Get("jwt_claims"); exists {
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressMinSize is the smallest response body worth compressing
+const compressMinSize = 1024
+
+// Compressors are reused across responses; each holds several hundred KB of state
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}}
+	zlibWriters = sync.Pool{New: func() any {
+		return zlib.NewWriter(io.Discard)
+	}}
+)
+
+// Compress compresses JSON and text responses with gzip or deflate, whichever the client accepts (gzip preferred)
+// HTTP deflate is the zlib format (RFC 9110), not a raw deflate stream
+// Small bodies, event streams and already compressed downloads are sent as they are
+func Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if encoding == "" || c.Request.Method == "HEAD" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.close()
+
+		c.Next()
+	}
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header; empty if neither is accepted
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		weight := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				weight = q
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = weight > 0
+	}
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressible reports whether a response of this content type benefits from compression
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		return false // Streamed; compressing would hold events back
+	case strings.HasPrefix(contentType, "application/json"), strings.HasPrefix(contentType, "text/"),
+		strings.Contains(contentType, "yaml"):
+		return true
+	}
+	return false
+}
+
+// compressWriter decides on the first write whether to compress, based on the content type and size
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	decided  bool
+	writer   interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		header := w.Header()
+		if len(data) >= compressMinSize && header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) {
+			header.Set("Content-Encoding", w.encoding)
+			header.Del("Content-Length")
+			if w.encoding == "gzip" {
+				gz := gzipWriters.Get().(*gzip.Writer)
+				gz.Reset(w.ResponseWriter)
+				w.writer = gz
+			} else {
+				zw := zlibWriters.Get().(*zlib.Writer)
+				zw.Reset(w.ResponseWriter)
+				w.writer = zw
+			}
+		}
+	}
+	if w.writer == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.writer.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what was compressed so far
+func (w *compressWriter) Flush() {
+	if w.writer != nil {
+		w.writer.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close finishes the compressed stream and returns the compressor to its pool
+func (w *compressWriter) close() {
+	if w.writer == nil {
+		return
+	}
+	w.writer.Close()
+	switch writer := w.writer.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(writer)
+	case *zlib.Writer:
+		zlibWriters.Put(writer)
+	}
+	w.writer = nil
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag tags successful GET responses with a hash of their body and answers a matching If-None-Match with 304
+// With browserCache the response may be kept by the browser and is revalidated on every request; without it
+// the response stays no-store (for bodies with secrets) and only clients sending If-None-Match themselves benefit
+func ETag(browserCache bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != "GET" {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		w := &bufferedWriter{ResponseWriter: original}
+		c.Writer = w
+		c.Next()
+		c.Writer = original
+
+		if w.Status() != 200 {
+			original.Write(w.body.Bytes())
+			return
+		}
+
+		sum := sha256.Sum256(w.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:])[:16] + `"`
+		c.Header("ETag", etag)
+		if browserCache {
+			c.Header("Cache-Control", "private, no-cache")
+			c.Writer.Header().Del("Pragma")
+			c.Writer.Header().Del("Expires")
+		}
+
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Writer.Header().Del("Content-Type")
+			c.Status(304)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		original.Write(w.body.Bytes())
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists the ETag (weak comparison) or is *
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds back the response body until the handler has finished
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
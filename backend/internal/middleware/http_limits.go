@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/httplimit"
+	"github.com/davbauer/knock-knock-portal/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPLimits caps in-flight requests per client IP and cuts off slowly sent request bodies
+// Must run after the real IP extractor so clients behind a trusted proxy are counted separately
+func HTTPLimits(limiter *httplimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientIP := "unknown"
+		if addr, ok := GetClientIP(c); ok {
+			clientIP = addr.String()
+		}
+
+		release, ok := limiter.Acquire(clientIP)
+		if !ok {
+			log.Warn().
+				Str("client_ip", clientIP).
+				Str("path", c.Request.URL.Path).
+				Msg("Request denied: too many in-flight requests from IP")
+			c.JSON(429, models.NewErrorResponse("Too many concurrent requests", "TOO_MANY_REQUESTS"))
+			c.Abort()
+			return
+		}
+		defer release()
+
+		limiter.LimitBody(c.Writer, c.Request, time.Time{})
+
+		c.Next()
+	}
+}
@@ -0,0 +1,6 @@
+package middleware
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["api.middleware"] overrides its level
+var log = logging.Module("api.middleware")
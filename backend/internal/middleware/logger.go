@@ -4,7 +4,6 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
 )
 
 // RequestLogger logs HTTP requests with structured logging
@@ -34,6 +33,10 @@ func RequestLogger() gin.HandlerFunc {
 			Dur("duration", duration).
 			Str("client_ip", clientIP)
 
+		if requestID := GetRequestID(c); requestID != "" {
+			logEvent = logEvent.Str("request_id", requestID)
+		}
+
 		// Add error if present
 		if len(c.Errors) > 0 {
 			logEvent = logEvent.Str("error", c.Errors.String())
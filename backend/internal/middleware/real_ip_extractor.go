@@ -8,7 +8,6 @@ import (
 	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/davbauer/knock-knock-portal/internal/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
 )
 
 // RealIPExtractor extracts the real client IP considering trusted proxies
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/davbauer/knock-knock-portal/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds accepted incoming request IDs so they cannot flood the logs
+const maxRequestIDLength = 128
+
+// RequestID returns a middleware that assigns every request an ID
+// An incoming X-Request-ID is kept only when the connection comes from a trusted proxy,
+// so a reverse proxy's ID shows up in both its logs and ours. The ID is echoed in the
+// response header, logged by RequestLogger and added to JSON error payloads.
+func (e *RealIPExtractor) RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := ""
+		if incoming := c.GetHeader(RequestIDHeader); incoming != "" && validRequestID(incoming) {
+			e.mu.RLock()
+			enabled := e.enabled
+			e.mu.RUnlock()
+			if enabled && e.isTrustedProxy(utils.ParseRemoteAddr(c.Request.RemoteAddr)) {
+				id = incoming
+			}
+		}
+		if id == "" {
+			id = newRequestID()
+		}
+
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, id: id}
+		c.Next()
+	}
+}
+
+// GetRequestID retrieves the request ID from context
+func GetRequestID(c *gin.Context) string {
+	return c.GetString("request_id")
+}
+
+// newRequestID returns a random 128-bit hex ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID allows the characters used by common ID formats (UUIDs, hex, base64url, Envoy/Traefik IDs)
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:+/=", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDWriter adds the request ID to JSON error bodies
+// Handlers render errors with a single c.JSON call, so the whole object arrives in one Write
+type requestIDWriter struct {
+	gin.ResponseWriter
+	id string
+}
+
+func (w *requestIDWriter) Write(data []byte) (int, error) {
+	if w.Status() < 400 || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(data)
+	}
+
+	trimmed := bytes.TrimRight(data, " \r\n")
+	if len(trimmed) < 2 || trimmed[0] != '{' || trimmed[len(trimmed)-1] != '}' || !json.Valid(trimmed) {
+		return w.ResponseWriter.Write(data)
+	}
+
+	field, _ := json.Marshal(w.id)
+	body := append([]byte{}, trimmed[:len(trimmed)-1]...)
+	if len(bytes.TrimSpace(body)) > 1 {
+		body = append(body, ',')
+	}
+	body = append(body, `"request_id":`...)
+	body = append(body, field...)
+	body = append(body, '}')
+
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		return 0, err
+	}
+	// Report the caller's length so the renderer does not treat the longer body as a short write
+	return len(data), nil
+}
@@ -0,0 +1,6 @@
+package portmapping
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["portmapping"] overrides its level
+var log = logging.Module("portmapping")
@@ -0,0 +1,327 @@
+package portmapping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// mapper is implemented by each port mapping protocol
+type mapper interface {
+	Name() string
+	AddMapping(ctx context.Context, protocol string, internalPort, externalPort int, lease time.Duration, description string) (int, error)
+	DeleteMapping(ctx context.Context, protocol string, internalPort, externalPort int) error
+}
+
+// mapping is a single router port forward requested for a proxy listener
+type mapping struct {
+	ServiceName  string
+	Protocol     string // tcp | udp
+	InternalPort int
+	ExternalPort int
+	LastRenewed  time.Time
+	LastError    string
+}
+
+// key identifies a mapping by protocol and listen port
+func (mp *mapping) key() string {
+	return fmt.Sprintf("%s/%d", mp.Protocol, mp.InternalPort)
+}
+
+// Manager requests and renews router port forwards for enabled proxy listeners
+type Manager struct {
+	configLoader *config.Loader
+	mu           sync.Mutex
+	cfg          config.PortMappingConfiguration
+	mapper       mapper
+	mappings     map[string]*mapping
+	stopRenew    chan struct{}
+	renewDone    chan struct{}
+	stopping     bool       // A stop is waiting for the renew loop to exit
+	closed       bool       // Stop was called; no new mappings are requested
+	idle         *sync.Cond // Signalled when a stop completes
+}
+
+// NewManager creates a new port mapping manager
+func NewManager(configLoader *config.Loader) *Manager {
+	m := &Manager{
+		configLoader: configLoader,
+		mappings:     make(map[string]*mapping),
+	}
+	m.idle = sync.NewCond(&m.mu)
+	return m
+}
+
+// Start discovers the gateway and maps all enabled proxy listen ports
+func (m *Manager) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.start()
+}
+
+// start must be called with mu held
+// A running renew loop and its mappings are removed first, so a late Start never leaks a loop
+func (m *Manager) start() error {
+	m.stop()
+	if m.closed {
+		return nil
+	}
+
+	cfg := m.configLoader.GetConfig()
+	m.cfg = cfg.PortMappingConfig
+
+	if !m.cfg.Enabled {
+		log.Debug().Msg("Port mapping disabled")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mp, err := m.discover(ctx)
+	if err != nil {
+		return fmt.Errorf("port mapping gateway discovery failed: %w", err)
+	}
+	m.mapper = mp
+
+	log.Info().
+		Str("protocol", mp.Name()).
+		Msg("Port mapping gateway discovered")
+
+	for _, service := range cfg.ProtectedServices {
+		if !service.Enabled || service.ProxyListenPortStart <= 0 {
+			continue
+		}
+		ports := []int{service.ProxyListenPortStart}
+		for _, listener := range service.AdditionalListeners {
+			ports = append(ports, listener.Port) // Listeners on the same port share one mapping
+		}
+		for _, port := range ports {
+			for _, protocol := range listenProtocols(&service) {
+				mp := &mapping{
+					ServiceName:  service.ServiceName,
+					Protocol:     protocol,
+					InternalPort: port,
+					ExternalPort: port,
+				}
+				m.mappings[mp.key()] = mp
+			}
+		}
+	}
+
+	m.renewAll()
+
+	m.stopRenew = make(chan struct{})
+	m.renewDone = make(chan struct{})
+	go m.renewLoop(m.stopRenew, m.renewDone, m.leaseDuration()/2)
+
+	return nil
+}
+
+// discover selects a mapper according to the configured protocol
+func (m *Manager) discover(ctx context.Context) (mapper, error) {
+	protocol := strings.ToLower(m.cfg.Protocol)
+
+	if protocol == "auto" || protocol == "natpmp" {
+		var gateway net.IP
+		var err error
+		if m.cfg.GatewayAddress != "" {
+			gateway = net.ParseIP(m.cfg.GatewayAddress)
+		} else {
+			gateway, err = detectDefaultGateway()
+		}
+
+		if err == nil {
+			natpmp := newNATPMPMapper(gateway)
+			if err = natpmp.Probe(ctx); err == nil {
+				return natpmp, nil
+			}
+		}
+
+		if protocol == "natpmp" {
+			return nil, err
+		}
+
+		log.Debug().
+			Err(err).
+			Msg("NAT-PMP unavailable, falling back to UPnP")
+	}
+
+	return discoverUPnP(ctx)
+}
+
+// renewLoop refreshes all mappings before their lease expires
+func (m *Manager) renewLoop(stop, done chan struct{}, interval time.Duration) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.mu.Lock()
+			m.renewAll()
+			m.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// renewAll (re)requests every mapping; must be called with mu held
+func (m *Manager) renewAll() {
+	lease := m.leaseDuration()
+
+	for _, mp := range m.mappings {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		external, err := m.mapper.AddMapping(ctx, mp.Protocol, mp.InternalPort, mp.ExternalPort, lease, m.cfg.Description)
+		cancel()
+
+		if err != nil {
+			mp.LastError = err.Error()
+			log.Warn().
+				Err(err).
+				Str("service", mp.ServiceName).
+				Str("protocol", mp.Protocol).
+				Int("port", mp.InternalPort).
+				Msg("Failed to request port mapping")
+			continue
+		}
+
+		if mp.LastRenewed.IsZero() || external != mp.ExternalPort {
+			log.Info().
+				Str("service", mp.ServiceName).
+				Str("protocol", mp.Protocol).
+				Int("internal_port", mp.InternalPort).
+				Int("external_port", external).
+				Msg("Port mapping established")
+		}
+
+		mp.ExternalPort = external
+		mp.LastRenewed = time.Now()
+		mp.LastError = ""
+	}
+}
+
+// stop halts renewal and removes all mappings; must be called with mu held
+// Concurrent callers wait for the stop in progress instead of closing the channel twice
+func (m *Manager) stop() {
+	for m.stopping {
+		m.idle.Wait()
+	}
+
+	if m.stopRenew != nil {
+		close(m.stopRenew)
+		done := m.renewDone
+		m.stopRenew = nil
+		m.renewDone = nil
+
+		// Release the lock so an in-progress renewal can finish
+		m.stopping = true
+		m.mu.Unlock()
+		<-done
+		m.mu.Lock()
+		m.stopping = false
+		m.idle.Broadcast()
+	}
+
+	if m.mapper != nil {
+		for _, mp := range m.mappings {
+			if mp.LastRenewed.IsZero() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := m.mapper.DeleteMapping(ctx, mp.Protocol, mp.InternalPort, mp.ExternalPort); err != nil {
+				log.Warn().
+					Err(err).
+					Str("protocol", mp.Protocol).
+					Int("port", mp.InternalPort).
+					Msg("Failed to remove port mapping")
+			}
+			cancel()
+		}
+	}
+
+	m.mapper = nil
+	m.mappings = make(map[string]*mapping)
+}
+
+// Reload re-applies mappings after a configuration change
+func (m *Manager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.start() // Removes the current mappings first
+}
+
+// Stop removes all mappings from the router
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	if m.mapper != nil {
+		log.Info().Msg("Removing port mappings")
+	}
+	m.stop()
+}
+
+// GetStats returns port mapping status
+func (m *Manager) GetStats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mappings := make([]map[string]interface{}, 0, len(m.mappings))
+	for _, mp := range m.mappings {
+		entry := map[string]interface{}{
+			"service_name":  mp.ServiceName,
+			"protocol":      mp.Protocol,
+			"internal_port": mp.InternalPort,
+			"external_port": mp.ExternalPort,
+			"active":        !mp.LastRenewed.IsZero() && mp.LastError == "",
+		}
+		if !mp.LastRenewed.IsZero() {
+			entry["last_renewed"] = mp.LastRenewed
+		}
+		if mp.LastError != "" {
+			entry["last_error"] = mp.LastError
+		}
+		mappings = append(mappings, entry)
+	}
+
+	stats := map[string]interface{}{
+		"enabled":  m.cfg.Enabled,
+		"mappings": mappings,
+	}
+	if m.mapper != nil {
+		stats["protocol"] = m.mapper.Name()
+	}
+	return stats
+}
+
+// leaseDuration returns the configured lease as a duration
+func (m *Manager) leaseDuration() time.Duration {
+	return time.Duration(m.cfg.LeaseDurationSeconds) * time.Second
+}
+
+// listenProtocols returns the transport protocols a service listens on
+func listenProtocols(service *config.ProtectedServiceConfig) []string {
+	if service.IsHTTPProtocol {
+		return []string{"tcp"}
+	}
+	switch strings.ToLower(service.TransportProtocol) {
+	case "udp":
+		return []string{"udp"}
+	case "both":
+		return []string{"tcp", "udp"}
+	default:
+		return []string{"tcp"}
+	}
+}
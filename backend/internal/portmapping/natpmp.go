@@ -0,0 +1,120 @@
+package portmapping
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	natpmpPort            = 5351
+	natpmpOpExternalAddr  = 0
+	natpmpOpMapUDP        = 1
+	natpmpOpMapTCP        = 2
+	natpmpResponseSize    = 16
+	natpmpMaxAttempts     = 4
+	natpmpInitialInterval = 250 * time.Millisecond
+)
+
+// natpmpMapper requests port mappings using NAT-PMP (RFC 6886)
+type natpmpMapper struct {
+	gateway net.IP
+}
+
+// newNATPMPMapper creates a NAT-PMP mapper for the given gateway
+func newNATPMPMapper(gateway net.IP) *natpmpMapper {
+	return &natpmpMapper{gateway: gateway}
+}
+
+// Name returns the mapper name for logging and status
+func (n *natpmpMapper) Name() string {
+	return "natpmp"
+}
+
+// Probe asks the gateway for its external address to confirm NAT-PMP support
+func (n *natpmpMapper) Probe(ctx context.Context) error {
+	_, err := n.exchange(ctx, []byte{0, natpmpOpExternalAddr}, 12)
+	return err
+}
+
+// AddMapping requests a mapping and returns the external port assigned by the gateway
+func (n *natpmpMapper) AddMapping(ctx context.Context, protocol string, internalPort, externalPort int, lease time.Duration, _ string) (int, error) {
+	return n.request(ctx, protocol, internalPort, externalPort, uint32(lease.Seconds()))
+}
+
+// DeleteMapping removes a mapping by requesting a zero lifetime
+func (n *natpmpMapper) DeleteMapping(ctx context.Context, protocol string, internalPort, _ int) error {
+	_, err := n.request(ctx, protocol, internalPort, 0, 0)
+	return err
+}
+
+// request sends a mapping request and returns the assigned external port
+func (n *natpmpMapper) request(ctx context.Context, protocol string, internalPort, externalPort int, lifetime uint32) (int, error) {
+	opcode := byte(natpmpOpMapTCP)
+	if protocol == "udp" {
+		opcode = natpmpOpMapUDP
+	}
+
+	req := make([]byte, 12)
+	req[0] = 0 // version
+	req[1] = opcode
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:12], lifetime)
+
+	resp, err := n.exchange(ctx, req, natpmpResponseSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+// exchange sends a request, retrying with exponential backoff as the RFC recommends
+func (n *natpmpMapper) exchange(ctx context.Context, req []byte, responseSize int) ([]byte, error) {
+	opcode := req[1]
+
+	conn, err := net.Dial("udp", net.JoinHostPort(n.gateway.String(), strconv.Itoa(natpmpPort)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to contact NAT-PMP gateway: %w", err)
+	}
+	defer conn.Close()
+
+	resp := make([]byte, responseSize)
+	interval := natpmpInitialInterval
+
+	for attempt := 0; attempt < natpmpMaxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if _, err := conn.Write(req); err != nil {
+			return nil, fmt.Errorf("failed to send NAT-PMP request: %w", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(interval))
+		nr, err := conn.Read(resp)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				interval *= 2
+				continue
+			}
+			return nil, fmt.Errorf("failed to read NAT-PMP response: %w", err)
+		}
+
+		if nr < responseSize || resp[1] != opcode+128 {
+			continue // Not a response to our request
+		}
+
+		if result := binary.BigEndian.Uint16(resp[2:4]); result != 0 {
+			return nil, fmt.Errorf("NAT-PMP gateway returned result code %d", result)
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("no NAT-PMP response from gateway %s", n.gateway)
+}
@@ -0,0 +1,248 @@
+package portmapping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddress       = "239.255.255.250:1900"
+	ssdpSearchTarget  = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	ssdpSearchTimeout = 3 * time.Second
+)
+
+// upnpMapper requests port mappings from a UPnP Internet Gateway Device
+type upnpMapper struct {
+	controlURL  string
+	serviceType string
+	localIP     string
+	httpClient  *http.Client
+}
+
+// upnpDevice mirrors the parts of the IGD device description we need
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+// discoverUPnP locates an IGD via SSDP and resolves its WAN connection control URL
+func discoverUPnP(ctx context.Context) (*upnpMapper, error) {
+	location, err := ssdpSearch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IGD location %q: %w", location, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch IGD description: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var root upnpRoot
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return nil, fmt.Errorf("failed to parse IGD description: %w", err)
+	}
+
+	service := findWANService(root.Device)
+	if service == nil {
+		return nil, fmt.Errorf("IGD at %s has no WANIPConnection or WANPPPConnection service", location)
+	}
+
+	base := location
+	if root.URLBase != "" {
+		base = root.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IGD base URL %q: %w", base, err)
+	}
+	controlURL, err := baseURL.Parse(service.ControlURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IGD control URL %q: %w", service.ControlURL, err)
+	}
+
+	// The router needs to know which LAN address to forward to
+	localIP, err := localAddressTowards(controlURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upnpMapper{
+		controlURL:  controlURL.String(),
+		serviceType: service.ServiceType,
+		localIP:     localIP,
+		httpClient:  client,
+	}, nil
+}
+
+// ssdpSearch multicasts an M-SEARCH and returns the first IGD location
+func ssdpSearch(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"ST: " + ssdpSearchTarget + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return "", fmt.Errorf("failed to send SSDP search: %w", err)
+	}
+
+	deadline := time.Now().Add(ssdpSearchTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP gateway responded to SSDP search: %w", err)
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// findWANService searches the device tree for a WAN connection service
+func findWANService(device upnpDevice) *upnpService {
+	for i := range device.Services {
+		serviceType := device.Services[i].ServiceType
+		if strings.Contains(serviceType, "WANIPConnection") || strings.Contains(serviceType, "WANPPPConnection") {
+			return &device.Services[i]
+		}
+	}
+	for _, child := range device.Devices {
+		if service := findWANService(child); service != nil {
+			return service
+		}
+	}
+	return nil
+}
+
+// localAddressTowards returns the local IP used to reach a host
+func localAddressTowards(hostPort string) (string, error) {
+	host := hostPort
+	if h, _, err := net.SplitHostPort(hostPort); err == nil {
+		host = h
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "1900"))
+	if err != nil {
+		return "", fmt.Errorf("failed to determine local address: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// Name returns the mapper name for logging and status
+func (u *upnpMapper) Name() string {
+	return "upnp"
+}
+
+// AddMapping requests a mapping; UPnP keeps the requested external port
+func (u *upnpMapper) AddMapping(ctx context.Context, protocol string, internalPort, externalPort int, lease time.Duration, description string) (int, error) {
+	args := [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", strings.ToUpper(protocol)},
+		{"NewInternalPort", strconv.Itoa(internalPort)},
+		{"NewInternalClient", u.localIP},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", description},
+		{"NewLeaseDuration", strconv.Itoa(int(lease.Seconds()))},
+	}
+	if err := u.soapCall(ctx, "AddPortMapping", args); err != nil {
+		return 0, err
+	}
+	return externalPort, nil
+}
+
+// DeleteMapping removes a mapping from the gateway
+func (u *upnpMapper) DeleteMapping(ctx context.Context, protocol string, _, externalPort int) error {
+	args := [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(externalPort)},
+		{"NewProtocol", strings.ToUpper(protocol)},
+	}
+	return u.soapCall(ctx, "DeletePortMapping", args)
+}
+
+// soapCall invokes an action on the WAN connection service
+func (u *upnpMapper) soapCall(ctx context.Context, action string, args [][2]string) error {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>`)
+	body.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	body.WriteString(`<u:` + action + ` xmlns:u="` + u.serviceType + `">`)
+	for _, arg := range args {
+		body.WriteString("<" + arg[0] + ">")
+		xml.EscapeText(&body, []byte(arg[1]))
+		body.WriteString("</" + arg[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.controlURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.serviceType+"#"+action+`"`)
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("UPnP %s request failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("UPnP %s failed with status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	return nil
+}
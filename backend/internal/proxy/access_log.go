@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+)
+
+// clfTimeFormat is the %t timestamp of the Common Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// accessLogResponseWriter captures the status and size of a response for the access log
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(code int) {
+	// Informational responses (103 Early Hints) precede the real status; 101 ends the exchange
+	if w.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush, Hijack and deadlines of the underlying writer
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAccessLog wraps handler so every request, including denied ones, produces an access log line
+func (p *HTTPProxy) withAccessLog(handler http.Handler) http.Handler {
+	if p.accessLog == nil {
+		return handler
+	}
+	combined := p.service.HTTPConfig.AccessLogFormat != "common"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &accessLogResponseWriter{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+
+		host := "-"
+		user := "-"
+		if clientIP, ok := parseIPFromAddr(r.RemoteAddr); ok {
+			host = clientIP.String()
+			if p.identity != nil {
+				if identity, ok := p.identity(clientIP); ok && identity.Username != "" {
+					user = clfField(identity.Username)
+				}
+			}
+		}
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK // Handler wrote nothing; net/http sends an empty 200
+		}
+		size := "-"
+		if recorder.bytes > 0 {
+			size = strconv.FormatInt(recorder.bytes, 10)
+		}
+
+		line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+			host, user, start.Format(clfTimeFormat),
+			clfField(r.Method), clfField(r.RequestURI), r.Proto, status, size)
+		if combined {
+			line += fmt.Sprintf(" \"%s\" \"%s\"", clfHeader(r.Referer()), clfHeader(r.UserAgent()))
+		}
+
+		if _, err := io.WriteString(p.accessLog, line+"\n"); err != nil {
+			p.log.Warn().Err(err).Str("service", p.service.ServiceName).Msg("Failed to write access log")
+		}
+	})
+}
+
+// clfField escapes quotes, backslashes and control characters so a client cannot forge log lines
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// clfHeader formats an optional request header value
+func clfHeader(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return clfField(s)
+}
+
+// accessLogFile returns the shared writer for an access log path, opening it on first use
+// Files stay open for the lifetime of the manager so services can be reloaded without reopening them
+func (m *Manager) accessLogFile(path string) (io.Writer, error) {
+	m.accessLogsMu.Lock()
+	defer m.accessLogsMu.Unlock()
+
+	if file, ok := m.accessLogs[path]; ok {
+		return file, nil
+	}
+
+	fc := m.configLoader.GetConfig().LoggingConfig.File
+	maxSizeMB := fc.MaxSizeMB
+	if maxSizeMB == 0 {
+		maxSizeMB = 100
+	}
+	file, err := logging.NewRotatingFile(path, maxSizeMB, fc.MaxBackups, fc.MaxAgeDays)
+	if err != nil {
+		return nil, err
+	}
+	m.accessLogs[path] = file
+	return file, nil
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+)
+
+const (
+	activityCheckInterval = 30 * time.Second
+	idleHookTimeout       = 60 * time.Second
+)
+
+// Event types published on the event bus
+const (
+	EventServiceIdle   = "service.idle"
+	EventServiceActive = "service.active"
+)
+
+// serviceActivity tracks when a running service last carried traffic
+type serviceActivity struct {
+	serviceName  string
+	trackedSince time.Time // Service first seen running; idle time counts from here until traffic is seen
+	lastActive   time.Time // Zero until traffic was seen
+	lastTotal    int64     // Connections, sessions and requests counted at the last check
+	active       int       // Open connections, sessions and requests at the last check
+	idleFired    bool      // The idle event of the current idle period went out
+}
+
+// idleFor returns how long the service has been without traffic at now
+func (a *serviceActivity) idleFor(now time.Time) time.Duration {
+	if a.active > 0 {
+		return 0
+	}
+	since := a.trackedSince
+	if a.lastActive.After(since) {
+		since = a.lastActive
+	}
+	return now.Sub(since)
+}
+
+// ServiceActivity is the last-activity state of a service for the admin API
+type ServiceActivity struct {
+	ServiceID         string     `json:"service_id"`
+	ServiceName       string     `json:"service_name"`
+	ActiveConnections int        `json:"active_connections"`
+	LastActivityAt    *time.Time `json:"last_activity_at"` // Nil when nothing connected since the portal started
+	IdleSeconds       int        `json:"idle_seconds"`
+	IdleEventSent     bool       `json:"idle_event_sent"`
+}
+
+// IdleEvent is the payload of EventServiceIdle and EventServiceActive events
+type IdleEvent struct {
+	ServiceID      string     `json:"service_id"`
+	ServiceName    string     `json:"service_name"`
+	IdleSeconds    int        `json:"idle_seconds"`
+	LastActivityAt *time.Time `json:"last_activity_at,omitempty"`
+}
+
+// SetEventBus installs the bus that receives service idle events
+// Must be called before Start
+func (m *Manager) SetEventBus(bus *events.Bus) {
+	m.bus = bus
+}
+
+// activityLoop follows the traffic of every running service, fires idle hooks and stops idle containers
+func (m *Manager) activityLoop(stop chan struct{}) {
+	ticker := time.NewTicker(activityCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.checkActivity(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// checkActivity updates the activity of every running service
+func (m *Manager) checkActivity(now time.Time) {
+	services := m.configLoader.GetConfig().ProtectedServices
+	for i := range services {
+		service := &services[i]
+		if len(m.serviceProxyKeys(service.ServiceID)) == 0 {
+			// Tracking starts over when the service runs again
+			m.activityMu.Lock()
+			delete(m.activity, service.ServiceID)
+			m.activityMu.Unlock()
+			continue
+		}
+		active, total := m.serviceTraffic(service.ServiceID)
+
+		m.activityMu.Lock()
+		a, ok := m.activity[service.ServiceID]
+		if !ok {
+			a = &serviceActivity{trackedSince: now, lastTotal: total}
+			m.activity[service.ServiceID] = a
+		}
+		resumed := false
+		a.serviceName = service.ServiceName
+		a.active = active
+		if active > 0 || total != a.lastTotal {
+			a.lastActive = now
+			a.lastTotal = total
+			resumed = a.idleFired
+			a.idleFired = false
+		}
+		idle := a.idleFor(now)
+		event := IdleEvent{ServiceID: service.ServiceID, ServiceName: service.ServiceName, IdleSeconds: int(idle.Seconds())}
+		if !a.lastActive.IsZero() {
+			lastActive := a.lastActive
+			event.LastActivityAt = &lastActive
+		}
+		fire := false
+		if hook := service.IdleHook; hook != nil && !a.idleFired && idle >= time.Duration(hook.AfterMinutes)*time.Minute {
+			a.idleFired = true
+			fire = true
+		}
+		m.activityMu.Unlock()
+
+		if resumed {
+			m.publish(EventServiceActive, event)
+		}
+		if fire {
+			m.fireIdleHook(service, event)
+		}
+
+		m.containersMu.Lock()
+		container := m.containers[service.ServiceID]
+		m.containersMu.Unlock()
+		container.checkIdle(idle)
+	}
+}
+
+// publish sends an event on the bus, if one is installed
+func (m *Manager) publish(eventType string, data interface{}) events.Event {
+	if m.bus == nil {
+		return events.Event{Type: eventType, Time: time.Now(), Data: data}
+	}
+	return m.bus.Publish(eventType, data)
+}
+
+// fireIdleHook publishes the idle event and runs the service's webhook and command
+func (m *Manager) fireIdleHook(service *config.ProtectedServiceConfig, event IdleEvent) {
+	hook := service.IdleHook
+	log.Info().
+		Str("service", service.ServiceName).
+		Int("idle_seconds", event.IdleSeconds).
+		Msg("Service is idle")
+
+	published := m.publish(EventServiceIdle, event)
+	if hook.WebhookURL == "" && len(hook.Command) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), idleHookTimeout)
+		defer cancel()
+
+		if hook.WebhookURL != "" {
+			if err := events.SendWebhook(ctx, hook.WebhookURL, published); err != nil {
+				log.Warn().Err(err).Str("service", service.ServiceName).Msg("Failed to deliver idle webhook")
+			}
+		}
+		if len(hook.Command) > 0 {
+			cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+			cmd.Env = append(os.Environ(),
+				"KNOCK_SERVICE_ID="+event.ServiceID,
+				"KNOCK_SERVICE_NAME="+event.ServiceName,
+				"KNOCK_IDLE_SECONDS="+strconv.Itoa(event.IdleSeconds),
+			)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				log.Warn().
+					Err(err).
+					Str("service", service.ServiceName).
+					Str("output", truncateOutput(output)).
+					Msg("Idle command failed")
+			}
+		}
+	}()
+}
+
+// truncateOutput shortens command output for the log
+func truncateOutput(output []byte) string {
+	const max = 512
+	if len(output) > max {
+		return fmt.Sprintf("%s... (%d bytes)", output[:max], len(output))
+	}
+	return string(output)
+}
+
+// serviceTraffic returns the open connections, sessions and requests of a service and a running total
+// that grows with every new one, so short flows between two checks still count as activity
+func (m *Manager) serviceTraffic(serviceID string) (active int, total int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.serviceProxyKeysLocked(serviceID) {
+		switch p := m.proxies[key].(type) {
+		case *TCPProxy:
+			active += int(atomic.LoadInt32(&p.activeConnCount))
+			p.mu.Lock()
+			total += p.connCount
+			p.mu.Unlock()
+		case *UDPProxy:
+			p.sessionsMu.RLock()
+			active += len(p.sessions)
+			p.sessionsMu.RUnlock()
+			p.mu.Lock()
+			total += p.packetCount
+			p.mu.Unlock()
+		case *HTTPProxy:
+			active += int(atomic.LoadInt32(&p.activeRequests))
+			p.mu.Lock()
+			total += p.requestCount
+			p.mu.Unlock()
+		}
+	}
+	return active, total
+}
+
+// ServiceActivity returns the last-activity state of a running service
+func (m *Manager) ServiceActivity(serviceID string) (ServiceActivity, bool) {
+	m.activityMu.Lock()
+	defer m.activityMu.Unlock()
+
+	a, ok := m.activity[serviceID]
+	if !ok {
+		return ServiceActivity{}, false
+	}
+	result := ServiceActivity{
+		ServiceID:         serviceID,
+		ServiceName:       a.serviceName,
+		ActiveConnections: a.active,
+		IdleSeconds:       int(a.idleFor(time.Now()).Seconds()),
+		IdleEventSent:     a.idleFired,
+	}
+	if !a.lastActive.IsZero() {
+		lastActive := a.lastActive
+		result.LastActivityAt = &lastActive
+	}
+	return result, true
+}
+
+// ServiceStatus reports whether a service has a running proxy and how many connections it currently holds
+func (m *Manager) ServiceStatus(serviceID string) (running bool, connections int) {
+	m.mu.RLock()
+	running = len(m.serviceProxyKeysLocked(serviceID)) > 0
+	m.mu.RUnlock()
+
+	connections, _ = m.serviceTraffic(serviceID)
+	return running, connections
+}
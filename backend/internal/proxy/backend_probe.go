@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backendProbeTimeout bounds a single reachability probe
+const backendProbeTimeout = 5 * time.Second
+
+// BackendProbe is the result of the last reachability check of a backend
+// TCP and HTTP backends are probed with a plain TCP connect; UDP backends cannot be probed without
+// speaking their protocol, so only their circuit breaker reflects failures
+type BackendProbe struct {
+	Address   string    `json:"address"`
+	Reachable bool      `json:"reachable"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// BackendHealth summarizes the backend of one running proxy
+type BackendHealth struct {
+	ServiceID         string                 `json:"service_id"`
+	ServiceName       string                 `json:"service_name"`
+	Protocol          string                 `json:"protocol"`
+	Listener          string                 `json:"listener,omitempty"` // Set for additional listeners of the service
+	Probe             *BackendProbe          `json:"probe,omitempty"`
+	CircuitBreaker    map[string]interface{} `json:"circuit_breaker"`
+	BackendResolution map[string]interface{} `json:"backend_resolution,omitempty"`
+}
+
+// probeTarget is a running proxy whose backend can be checked
+type probeTarget struct {
+	key      string
+	health   BackendHealth
+	breaker  *CircuitBreaker
+	resolver *backendResolver
+	probe    bool
+}
+
+// probeTargets returns the running proxies with a fixed backend
+func (m *Manager) probeTargets() []probeTarget {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	targets := make([]probeTarget, 0, len(m.proxies))
+	for key, proxy := range m.proxies {
+		var (
+			target   probeTarget
+			protocol string
+		)
+		switch p := proxy.(type) {
+		case *TCPProxy:
+			target, protocol = probeTarget{breaker: p.circuitBreaker, resolver: p.backend, probe: true}, "tcp"
+		case *UDPProxy:
+			target, protocol = probeTarget{breaker: p.circuitBreaker, resolver: p.backend}, "udp"
+		case *HTTPProxy:
+			target, protocol = probeTarget{breaker: p.circuitBreaker, resolver: p.backend, probe: true}, "http"
+		default:
+			continue
+		}
+		// SOCKS5 proxies dial whatever the client asks for
+		if target.resolver == nil {
+			continue
+		}
+
+		service := proxyService(proxy)
+		target.key = key
+		target.health = BackendHealth{
+			ServiceID:   service.ServiceID,
+			ServiceName: service.ServiceName,
+			Protocol:    protocol,
+			Listener:    service.Listener,
+		}
+		// Additional listeners share the backend; probing it once keeps the uptime history free of duplicates
+		target.probe = target.probe && service.Listener == ""
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// backendProbeLoop checks the reachability of every TCP and HTTP backend
+func (m *Manager) backendProbeLoop(stop chan struct{}, interval time.Duration) {
+	// First round right away so health is known shortly after start
+	m.probeBackends(stop)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.probeBackends(stop)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// probeBackends probes all backends concurrently and stores the results
+func (m *Manager) probeBackends(stop chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), backendProbeTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		results    = make(map[string]BackendProbe)
+		serviceIDs = make(map[string]string)
+	)
+	for _, target := range m.probeTargets() {
+		if !target.probe {
+			continue
+		}
+		serviceIDs[target.key] = target.health.ServiceID
+		wg.Add(1)
+		go func(target probeTarget) {
+			defer wg.Done()
+
+			start := time.Now()
+			conn, addr, err := target.resolver.dialTCP(ctx, backendProbeTimeout)
+			result := BackendProbe{
+				Address:   addr,
+				Reachable: err == nil,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+				CheckedAt: time.Now(),
+			}
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				conn.Close()
+			}
+
+			mu.Lock()
+			results[target.key] = result
+			mu.Unlock()
+		}(target)
+	}
+	wg.Wait()
+
+	select {
+	case <-stop:
+		return // Stopped mid-round; the results are not meaningful
+	default:
+	}
+
+	m.mu.Lock()
+	m.probes = results
+	m.mu.Unlock()
+
+	// Kept for GET /api/admin/backends/:id/uptime
+	for key, result := range results {
+		m.history.RecordProbe(serviceIDs[key], result.Reachable, result.LatencyMs, result.Error, result.CheckedAt)
+	}
+}
+
+// BackendHealth returns the last probe result, circuit breaker state and DNS resolution of every running backend
+func (m *Manager) BackendHealth() []BackendHealth {
+	targets := m.probeTargets()
+
+	m.mu.RLock()
+	probes := m.probes
+	m.mu.RUnlock()
+
+	health := make([]BackendHealth, 0, len(targets))
+	for _, target := range targets {
+		entry := target.health
+		if probe, ok := probes[target.key]; ok {
+			entry.Probe = &probe
+		}
+		entry.CircuitBreaker = target.breaker.GetStats()
+		entry.BackendResolution = target.resolver.stats()
+		health = append(health, entry)
+	}
+	return health
+}
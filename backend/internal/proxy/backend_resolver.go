@@ -0,0 +1,431 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// backendLookupTimeout bounds a single resolution of a backend hostname
+const backendLookupTimeout = 5 * time.Second
+
+// connectionAttemptDelay is how long a dial attempt runs alone before the next address is tried
+// in parallel (RFC 8305 section 5)
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// backendResolver caches the addresses of a service's backend hostname
+// Docker service names and similar hosts change IPs when containers are recreated; the manager
+// refreshes every resolver periodically and proxies force a refresh when dialing the cached address fails
+type backendResolver struct {
+	host      string
+	port      int
+	source    *backendSource // Local address to dial from; nil = chosen by the OS
+	waker     *backendWaker  // Wakes a sleeping backend when dialing fails; nil = off
+	container *lazyContainer // Docker container started on demand; nil = not managed
+
+	mu         sync.RWMutex
+	addrs      []netip.Addr
+	resolvedAt time.Time
+	lastErr    error
+	onChange   func() // Called after a refresh returned different addresses, e.g. to drop pooled connections
+
+	// Address family of the last successful TCP dial; tried first next time so the faster family stays preferred
+	dialedAddr   string
+	dialedFamily string // "ipv4" | "ipv6"; empty until a dial succeeded
+}
+
+// newBackendResolver creates a resolver; IP literals are never looked up
+func newBackendResolver(host string, port int, source *backendSource) *backendResolver {
+	r := &backendResolver{host: host, port: port, source: source}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		r.addrs = []netip.Addr{addr}
+		r.resolvedAt = time.Now()
+	}
+	return r
+}
+
+// newServiceBackendResolver creates the resolver of a service's fixed backend
+func newServiceBackendResolver(service *config.ProtectedServiceConfig) *backendResolver {
+	r := newBackendResolver(service.BackendTargetHost, service.BackendTargetPort, newBackendSource(service))
+	r.waker = newBackendWaker(service)
+	return r
+}
+
+// isStatic reports whether the backend host is an IP literal
+func (r *backendResolver) isStatic() bool {
+	_, err := netip.ParseAddr(r.host)
+	return err == nil
+}
+
+// address returns the host:port to dial, resolving the hostname first if nothing is cached yet
+// Prefers the family of the last successful TCP dial, otherwise the first address (IPv4 sorts first)
+// Falls back to the hostname, leaving the lookup to the dialer, if resolution fails
+func (r *backendResolver) address() string {
+	return r.dialOrder(false)[0]
+}
+
+// dialOrder returns the host:port candidates to dial, alternating address families and starting with
+// the family of the last successful dial (RFC 8305 section 4); without one, IPv6 goes first if preferIPv6
+func (r *backendResolver) dialOrder(preferIPv6 bool) []string {
+	r.mu.RLock()
+	addrs, family := r.addrs, r.dialedFamily
+	r.mu.RUnlock()
+
+	if len(addrs) == 0 {
+		r.refresh()
+		r.mu.RLock()
+		addrs, family = r.addrs, r.dialedFamily
+		r.mu.RUnlock()
+	}
+	if len(addrs) == 0 {
+		return []string{net.JoinHostPort(r.host, strconv.Itoa(r.port))}
+	}
+
+	var v4, v6 []netip.Addr
+	for _, addr := range addrs {
+		// A fixed source address can only reach its own family
+		if family := r.source.fixedFamily(); (family == 4 && addr.Is6()) || (family == 6 && addr.Is4()) {
+			continue
+		}
+		if addr.Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	first, second := v4, v6
+	if family == "ipv6" || (family == "" && preferIPv6) {
+		first, second = v6, v4
+	}
+
+	if len(first)+len(second) == 0 {
+		first = addrs // Let the dial fail with a clear error
+	}
+
+	order := make([]string, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			order = append(order, netip.AddrPortFrom(first[i], uint16(r.port)).String())
+		}
+		if i < len(second) {
+			order = append(order, netip.AddrPortFrom(second[i], uint16(r.port)).String())
+		}
+	}
+	return order
+}
+
+// refresh looks the hostname up again
+// Returns true if the addresses changed; a failed lookup keeps the previous addresses
+func (r *backendResolver) refresh() bool {
+	if r.isStatic() {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendLookupTimeout)
+	defer cancel()
+
+	resolved, err := net.DefaultResolver.LookupNetIP(ctx, "ip", r.host)
+	for i := range resolved {
+		resolved[i] = resolved[i].Unmap()
+	}
+	// DNS servers rotate the order; sorting keeps the dialed address stable between refreshes
+	slices.SortFunc(resolved, netip.Addr.Compare)
+
+	r.mu.Lock()
+	if err != nil {
+		r.lastErr = err
+		r.mu.Unlock()
+		log.Warn().
+			Err(err).
+			Str("backend_host", r.host).
+			Msg("Failed to resolve backend hostname, keeping previous addresses")
+		return false
+	}
+
+	changed := !sameAddrs(r.addrs, resolved)
+	previous := r.addrs
+	r.addrs = resolved
+	r.resolvedAt = time.Now()
+	r.lastErr = nil
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	if changed && len(previous) > 0 {
+		log.Info().
+			Str("backend_host", r.host).
+			Str("previous", fmt.Sprint(previous)).
+			Str("resolved", fmt.Sprint(resolved)).
+			Msg("Backend hostname resolves to new addresses")
+		if onChange != nil {
+			onChange()
+		}
+	}
+	return changed
+}
+
+// dialTCP connects to the cached backend addresses and re-resolves once if that fails,
+// retrying when the hostname now points elsewhere
+// Hostnames with several addresses are dialed Happy Eyeballs style (RFC 8305), IPv6 first until a family has won
+func (r *backendResolver) dialTCP(ctx context.Context, timeout time.Duration) (net.Conn, string, error) {
+	if _, err := r.ensureContainer(ctx); err != nil {
+		return nil, r.address(), err
+	}
+	if r.waker != nil {
+		// Don't make clients of a sleeping backend sit through the full timeout before it is woken
+		timeout = min(timeout, wakeProbeTimeout)
+	}
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		local, err := r.source.tcpAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{Timeout: timeout, LocalAddr: local}
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	conn, addr, err := dialParallel(ctx, dial, r.dialOrder(true))
+	if err != nil && ctx.Err() == nil && r.refresh() {
+		conn, addr, err = dialParallel(ctx, dial, r.dialOrder(true))
+	}
+	if err != nil && ctx.Err() == nil && r.container != nil {
+		conn, addr, err = r.dialContainer(ctx, dial)
+	}
+	if err != nil && ctx.Err() == nil && r.waker != nil {
+		conn, addr, err = r.waker.wakeAndDial(ctx, r.source, func(ctx context.Context) (net.Conn, string, error) {
+			return dialParallel(ctx, dial, r.dialOrder(true))
+		})
+	}
+	if err == nil {
+		r.recordDial(addr)
+		r.waker.markAlive()
+	}
+	return conn, addr, err
+}
+
+// ensureContainer starts the backend's container if it is managed and not known to run
+// Docker may assign a stopped container a new address on start, so the backend is resolved again
+func (r *backendResolver) ensureContainer(ctx context.Context) (bool, error) {
+	started, err := r.container.ensureRunning(ctx)
+	if started {
+		r.refresh()
+	}
+	return started, err
+}
+
+// dialContainer handles a failed dial to a managed container: it may have been stopped outside the
+// portal, or be running while the service inside still boots, so it is started and dialed until it answers
+func (r *backendResolver) dialContainer(ctx context.Context, dial func(ctx context.Context, addr string) (net.Conn, error)) (net.Conn, string, error) {
+	r.container.invalidate()
+	if _, err := r.ensureContainer(ctx); err != nil {
+		return nil, r.address(), err
+	}
+	return retryDial(ctx, r.container.startTimeout, time.Second, func(ctx context.Context) (net.Conn, string, error) {
+		r.refresh()
+		return dialParallel(ctx, dial, r.dialOrder(true))
+	}, nil)
+}
+
+// retryDial dials every interval until a connection succeeds or wait runs out
+// beforeAttempt, if set, runs before each attempt
+func retryDial(ctx context.Context, wait, interval time.Duration, dial func(ctx context.Context) (net.Conn, string, error), beforeAttempt func()) (net.Conn, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastAddr string
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			return nil, lastAddr, fmt.Errorf("backend did not answer within %s: %w", wait, lastErr)
+		case <-ticker.C:
+		}
+
+		if beforeAttempt != nil {
+			beforeAttempt()
+		}
+		conn, addr, err := dial(ctx)
+		if err == nil {
+			return conn, addr, nil
+		}
+		lastAddr, lastErr = addr, err
+	}
+}
+
+// recordDial remembers the address family that won a dial
+func (r *backendResolver) recordDial(addr string) {
+	family := "ipv4"
+	if addrPort, err := netip.ParseAddrPort(addr); err != nil {
+		return // Hostname fallback; the dialer picked the address
+	} else if addrPort.Addr().Is6() {
+		family = "ipv6"
+	}
+
+	r.mu.Lock()
+	r.dialedAddr, r.dialedFamily = addr, family
+	r.mu.Unlock()
+}
+
+// dialParallel starts a connection attempt to each address in turn, the next one after
+// connectionAttemptDelay or as soon as the previous attempt failed; the first connection wins
+// Returns the address of the winner, or of the first failure if all attempts fail
+func dialParallel(ctx context.Context, dial func(ctx context.Context, addr string) (net.Conn, error), addrs []string) (net.Conn, string, error) {
+	if len(addrs) == 1 {
+		conn, err := dial(ctx, addrs[0])
+		return conn, addrs[0], err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		addr string
+		err  error
+	}
+	results := make(chan attempt, len(addrs))
+	next, pending := 0, 0
+	startNext := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, addr)
+			results <- attempt{conn: conn, addr: addr, err: err}
+		}()
+	}
+
+	startNext()
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+
+	var failed *attempt
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Attempts still running are cancelled; close any that connected anyway
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, res.addr, nil
+			}
+			if failed == nil {
+				failed = &res
+			}
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(connectionAttemptDelay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				startNext()
+				timer.Reset(connectionAttemptDelay)
+			}
+		}
+	}
+	return nil, failed.addr, failed.err
+}
+
+// stats returns the resolved addresses for the admin API
+func (r *backendResolver) stats() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ips := make([]string, 0, len(r.addrs))
+	for _, addr := range r.addrs {
+		ips = append(ips, addr.String())
+	}
+
+	stats := map[string]interface{}{
+		"host":         r.host,
+		"resolved_ips": ips,
+	}
+	if !r.resolvedAt.IsZero() {
+		stats["resolved_at"] = r.resolvedAt
+		stats["age_seconds"] = int(time.Since(r.resolvedAt).Seconds())
+	}
+	if r.lastErr != nil {
+		stats["last_error"] = r.lastErr.Error()
+	}
+	if r.dialedFamily != "" {
+		stats["dialed_address"] = r.dialedAddr
+		stats["dialed_family"] = r.dialedFamily
+	}
+	if r.waker != nil {
+		stats["wake_on_lan"] = r.waker.stats()
+	}
+	if r.container != nil {
+		stats["container"] = r.container.stats()
+	}
+	return stats
+}
+
+// sameAddrs reports whether two address lists contain the same addresses in the same order
+func sameAddrs(a, b []netip.Addr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// backendResolveLoop re-resolves the backend hostnames of all running proxies
+func (m *Manager) backendResolveLoop(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, resolver := range m.backendResolvers() {
+				resolver.refresh()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// backendResolvers returns the resolvers of the running proxies that dial a hostname
+func (m *Manager) backendResolvers() []*backendResolver {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var resolvers []*backendResolver
+	for _, proxy := range m.proxies {
+		var resolver *backendResolver
+		switch p := proxy.(type) {
+		case *TCPProxy:
+			resolver = p.backend
+		case *UDPProxy:
+			resolver = p.backend
+		case *HTTPProxy:
+			resolver = p.backend
+		}
+		if resolver != nil && !resolver.isStatic() {
+			resolvers = append(resolvers, resolver)
+		}
+	}
+	return resolvers
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// backendSource is the local address that connections to a service's backend originate from
+type backendSource struct {
+	ip    netip.Addr // Fixed source address; invalid when an interface is used
+	iface string     // Interface whose address is used, looked up per dial so DHCP changes are picked up
+}
+
+// newBackendSource returns the configured source, or nil to let the OS choose
+func newBackendSource(service *config.ProtectedServiceConfig) *backendSource {
+	if service.BackendSourceInterface != "" {
+		return &backendSource{iface: service.BackendSourceInterface}
+	}
+	if ip, err := netip.ParseAddr(service.BackendSourceIP); err == nil {
+		return &backendSource{ip: ip.Unmap()}
+	}
+	return nil
+}
+
+// fixedFamily reports whether the source is a fixed IPv4 (4) or IPv6 (6) address, 0 otherwise
+func (s *backendSource) fixedFamily() int {
+	switch {
+	case s == nil || !s.ip.IsValid():
+		return 0
+	case s.ip.Is4():
+		return 4
+	default:
+		return 6
+	}
+}
+
+// localIP returns the source address for a target; an invalid target (a hostname) prefers IPv4
+func (s *backendSource) localIP(target netip.Addr) (netip.Addr, error) {
+	wantIPv6 := target.IsValid() && target.Is6()
+	if s.ip.IsValid() {
+		if s.ip.Is6() != wantIPv6 && target.IsValid() {
+			return netip.Addr{}, fmt.Errorf("backend_source_ip %s cannot reach %s", s.ip, target)
+		}
+		return s.ip, nil
+	}
+
+	iface, err := net.InterfaceByName(s.iface)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("backend_source_interface %s: %w", s.iface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("backend_source_interface %s: %w", s.iface, err)
+	}
+
+	// Global addresses first; a link-local IPv6 source only works for link-local targets
+	var fallback netip.Addr
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr().Unmap()
+		if ip.Is6() != wantIPv6 {
+			continue
+		}
+		if !ip.IsLinkLocalUnicast() {
+			return ip, nil
+		}
+		if !fallback.IsValid() {
+			fallback = ip
+		}
+	}
+	if fallback.IsValid() && target.IsLinkLocalUnicast() {
+		return fallback, nil
+	}
+
+	family := "IPv4"
+	if wantIPv6 {
+		family = "IPv6"
+	}
+	return netip.Addr{}, fmt.Errorf("backend_source_interface %s has no %s address", s.iface, family)
+}
+
+// tcpAddr returns the local address for dialing target (host:port); nil when no source is configured
+func (s *backendSource) tcpAddr(target string) (net.Addr, error) {
+	if s == nil {
+		return nil, nil
+	}
+	var targetIP netip.Addr
+	if addrPort, err := netip.ParseAddrPort(target); err == nil {
+		targetIP = addrPort.Addr()
+	}
+	ip, err := s.localIP(targetIP)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: ip.AsSlice()}, nil
+}
+
+// udpAddr returns the local address for a UDP session to target; nil when no source is configured
+func (s *backendSource) udpAddr(target *net.UDPAddr) (*net.UDPAddr, error) {
+	if s == nil {
+		return nil, nil
+	}
+	targetIP, _ := netip.AddrFromSlice(target.IP)
+	ip, err := s.localIP(targetIP.Unmap())
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: ip.AsSlice()}, nil
+}
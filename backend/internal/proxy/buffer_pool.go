@@ -2,41 +2,44 @@ package proxy
 
 import "sync"
 
-// Buffer pools for reuse across proxies
-var (
-	// tcpBufferPool reuses 32KB buffers for TCP proxying
-	tcpBufferPool = sync.Pool{
-		New: func() interface{} {
-			buf := make([]byte, 32768) // 32KB default
-			return &buf
-		},
-	}
-
-	// udpBufferPool reuses buffers for UDP packets
-	udpBufferPool = sync.Pool{
-		New: func() interface{} {
-			buf := make([]byte, 65507) // Max UDP packet size
-			return &buf
-		},
-	}
+// Default buffer sizes when neither the service nor proxy_server_config sets one
+const (
+	defaultTCPBufferSize = 32768 // 32KB
+	defaultUDPBufferSize = 65507 // Max UDP payload over IPv4
 )
 
-// getTCPBuffer retrieves a buffer from the TCP pool
-func getTCPBuffer() *[]byte {
-	return tcpBufferPool.Get().(*[]byte)
-}
+// bufferPools reuses buffers across proxies, one pool per size
+// Sizes come from configuration, so there are only a handful of pools
+var bufferPools sync.Map // int -> *sync.Pool
 
-// putTCPBuffer returns a buffer to the TCP pool
-func putTCPBuffer(buf *[]byte) {
-	tcpBufferPool.Put(buf)
+// getBuffer retrieves a buffer of the given size from its pool
+func getBuffer(size int) *[]byte {
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, size)
+				return &buf
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
 }
 
-// getUDPBuffer retrieves a buffer from the UDP pool
-func getUDPBuffer() *[]byte {
-	return udpBufferPool.Get().(*[]byte)
+// putBuffer returns a buffer to the pool of its size
+func putBuffer(buf *[]byte) {
+	if pool, ok := bufferPools.Load(len(*buf)); ok {
+		pool.(*sync.Pool).Put(buf)
+	}
 }
 
-// putUDPBuffer returns a buffer to the UDP pool
-func putUDPBuffer(buf *[]byte) {
-	udpBufferPool.Put(buf)
+// bufferSize picks the service override, then the global setting, then the default
+func bufferSize(service, global, fallback int) int {
+	if service > 0 {
+		return service
+	}
+	if global > 0 {
+		return global
+	}
+	return fallback
 }
@@ -5,7 +5,7 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/rs/zerolog/log"
+	"github.com/davbauer/knock-knock-portal/internal/config"
 )
 
 // CircuitState represents the state of a circuit breaker
@@ -68,6 +68,16 @@ func NewCircuitBreaker(serviceName string, maxFailures int32, timeout time.Durat
 	}
 }
 
+// newServiceCircuitBreaker creates a circuit breaker from a service's configuration
+// Unset values fall back to the NewCircuitBreaker defaults
+func newServiceCircuitBreaker(service *config.ProtectedServiceConfig) *CircuitBreaker {
+	cfg := service.CircuitBreaker
+	if cfg == nil {
+		cfg = &config.CircuitBreakerConfig{}
+	}
+	return NewCircuitBreaker(service.ServiceName, int32(cfg.MaxFailures), time.Duration(cfg.OpenTimeoutSeconds)*time.Second, int32(cfg.HalfOpenSuccesses))
+}
+
 // Allow checks if a request is allowed
 func (cb *CircuitBreaker) Allow() bool {
 	state := CircuitState(atomic.LoadInt32(&cb.state))
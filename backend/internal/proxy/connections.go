@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+)
+
+// ConnectionInfo describes a single proxied TCP connection or UDP session
+type ConnectionInfo struct {
+	ID              string    `json:"id"`
+	ServiceID       string    `json:"service_id"`
+	ServiceName     string    `json:"service_name"`
+	Protocol        string    `json:"protocol"`
+	ClientAddr      string    `json:"client_addr"` // ip:port, IPv6 in brackets
+	ClientIP        string    `json:"client_ip"`
+	ClientPort      int       `json:"client_port"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds int64     `json:"duration_seconds"`
+	PacketsReceived int64     `json:"packets_received"`
+	PacketsSent     int64     `json:"packets_sent"`
+	BytesReceived   int64     `json:"bytes_received"`
+	BytesSent       int64     `json:"bytes_sent"`
+}
+
+// newConnectionInfo describes a connection or session of a service without its traffic counters
+func newConnectionInfo(id string, service *config.ProtectedServiceConfig, protocol, clientAddr string, startedAt time.Time) ConnectionInfo {
+	info := ConnectionInfo{
+		ID:              id,
+		ServiceID:       service.ServiceID,
+		ServiceName:     service.ServiceName,
+		Protocol:        protocol,
+		ClientAddr:      clientAddr,
+		StartedAt:       startedAt,
+		DurationSeconds: int64(time.Since(startedAt).Seconds()),
+	}
+	if addrPort, err := netip.ParseAddrPort(clientAddr); err == nil {
+		info.ClientIP = addrPort.Addr().Unmap().String()
+		info.ClientPort = int(addrPort.Port())
+	}
+	return info
+}
+
+// ClientIdentity is the portal session a client IP belongs to
+type ClientIdentity struct {
+	SessionID string
+	UserID    string
+	Username  string
+	ExpiresAt time.Time
+}
+
+// IdentityResolver returns the portal session covering a client IP
+type IdentityResolver func(ip netip.Addr) (ClientIdentity, bool)
+
+// AccessPolicy decides whether the portal sessions covering a client IP may use a service
+// It returns false and a reason to deny an IP the allowlist admits through a session
+type AccessPolicy func(ip netip.Addr, serviceID string) (bool, string)
+
+// userLimitKey returns the key connections of ip are counted under for a per-user limit
+// Returns "" when there is no limit or the IP is not covered by a portal session
+func userLimitKey(resolver IdentityResolver, limit int, ip netip.Addr) string {
+	if resolver == nil || limit <= 0 {
+		return ""
+	}
+	identity, ok := resolver(ip)
+	if !ok {
+		return ""
+	}
+	if identity.UserID != "" {
+		return identity.UserID
+	}
+	return identity.SessionID
+}
+
+var connectionSeq uint64
+
+// nextConnectionID returns a process-unique connection ID such as "tcp-42"
+func nextConnectionID(protocol string) string {
+	return fmt.Sprintf("%s-%d", protocol, atomic.AddUint64(&connectionSeq, 1))
+}
+
+// historyWriter reports bytes written through it to the traffic history
+type historyWriter struct {
+	io.Writer
+	history   *stats.History
+	serviceID string
+	clientIP  string
+	toClient  bool
+}
+
+func (w *historyWriter) Write(data []byte) (int, error) {
+	n, err := w.Writer.Write(data)
+	if w.toClient {
+		w.history.RecordTraffic(w.serviceID, w.clientIP, 0, int64(n))
+	} else {
+		w.history.RecordTraffic(w.serviceID, w.clientIP, int64(n), 0)
+	}
+	return n, err
+}
+
+// historyReader reports bytes read through it to the traffic history
+type historyReader struct {
+	io.ReadCloser
+	history   *stats.History
+	serviceID string
+	clientIP  string
+	toClient  bool
+}
+
+func (r *historyReader) Read(data []byte) (int, error) {
+	n, err := r.ReadCloser.Read(data)
+	if r.toClient {
+		r.history.RecordTraffic(r.serviceID, r.clientIP, 0, int64(n))
+	} else {
+		r.history.RecordTraffic(r.serviceID, r.clientIP, int64(n), 0)
+	}
+	return n, err
+}
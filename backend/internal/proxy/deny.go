@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const (
+	blackholeHoldTime        = time.Minute // How long a blackholed connection is kept open
+	maxBlackholedConnections = 128         // Across all services; further denied connections are closed instead
+)
+
+// blackholed counts connections currently held open by the blackhole close mode
+var blackholed atomic.Int32
+
+// renderDenyText fills the placeholders of a deny page or banner
+func renderDenyText(text, clientIP, serviceName string) string {
+	return strings.NewReplacer("{client_ip}", clientIP, "{service}", serviceName).Replace(text)
+}
+
+// loadDenyPage returns the configured HTML deny page, reading it from disk if a file is set
+func loadDenyPage(deny *config.DenyResponseConfig) (string, error) {
+	if deny == nil {
+		return "", nil
+	}
+	if deny.HTMLPageFile == "" {
+		return deny.HTMLPage, nil
+	}
+
+	page, err := os.ReadFile(deny.HTMLPageFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read deny page: %w", err)
+	}
+	return string(page), nil
+}
+
+// rejectTCP closes a denied connection as configured, optionally sending the banner first
+// In blackhole mode it returns only once the client gives up, the hold time passes or ctx is cancelled
+func rejectTCP(ctx context.Context, conn net.Conn, deny *config.DenyResponseConfig, clientIP, serviceName string, showBanner bool) {
+	if deny == nil {
+		return
+	}
+
+	switch deny.TCPCloseMode {
+	case "reset":
+		// A zero linger makes Close send RST instead of FIN (and discard unsent data, so no banner)
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		return
+	case "blackhole":
+		if blackholed.Add(1) <= maxBlackholedConnections {
+			holdBlackhole(ctx, conn)
+		}
+		blackholed.Add(-1)
+		return
+	}
+
+	if showBanner && deny.TCPBanner != "" {
+		conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte(renderDenyText(deny.TCPBanner, clientIP, serviceName)))
+	}
+}
+
+// holdBlackhole accepts and discards whatever the client sends, so the port looks open but never answers
+func holdBlackhole(ctx context.Context, conn net.Conn) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetReadDeadline(time.Now().Add(blackholeHoldTime))
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// dockerClient talks to the Docker Engine API, just enough to start and stop containers
+// The endpoint comes from DOCKER_HOST like the docker CLI (unix:// or tcp://)
+type dockerClient struct {
+	http *http.Client
+	base string
+}
+
+// newDockerClient creates a client for DOCKER_HOST, defaulting to the local socket
+func newDockerClient() (*dockerClient, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %q: %w", host, err)
+	}
+
+	transport := &http.Transport{MaxIdleConns: 2, IdleConnTimeout: 30 * time.Second}
+	base := "http://docker"
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	case "tcp", "http":
+		base = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported DOCKER_HOST scheme %q (use unix:// or tcp://)", u.Scheme)
+	}
+	return &dockerClient{http: &http.Client{Transport: transport}, base: base}, nil
+}
+
+// containerState reports whether a container runs and its health check status ("" without a health check)
+func (d *dockerClient) containerState(ctx context.Context, name string) (bool, string, error) {
+	resp, err := d.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(name)+"/json")
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	var inspect struct {
+		State struct {
+			Running bool `json:"Running"`
+			Health  *struct {
+				Status string `json:"Status"`
+			} `json:"Health"`
+		} `json:"State"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return false, "", fmt.Errorf("failed to decode container %s: %w", name, err)
+	}
+	health := ""
+	if inspect.State.Health != nil {
+		health = inspect.State.Health.Status
+	}
+	return inspect.State.Running, health, nil
+}
+
+// startContainer starts a container; starting a running one is not an error
+func (d *dockerClient) startContainer(ctx context.Context, name string) error {
+	resp, err := d.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(name)+"/start")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// stopContainer stops a container, killing it after timeout; stopping a stopped one is not an error
+func (d *dockerClient) stopContainer(ctx context.Context, name string, timeout time.Duration) error {
+	path := "/containers/" + url.PathEscape(name) + "/stop?t=" + strconv.Itoa(int(timeout.Seconds()))
+	resp, err := d.do(ctx, http.MethodPost, path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a request and turns error statuses into errors carrying the daemon's message
+// 304 Not Modified (already started/stopped) counts as success
+func (d *dockerClient) do(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker API: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		return nil, fmt.Errorf("docker API %s %s: %s: %s", method, path, resp.Status, apiErr.Message)
+	}
+	return resp, nil
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package proxy
+
+import (
+	"net"
+	"syscall"
+)
+
+// dontFragmentSupported reports whether backend sockets can be set to never fragment
+const dontFragmentSupported = true
+
+// Path MTU discovery options (linux/in6.h)
+const (
+	ipv6MTUDiscover = 23
+	ipv6PMTUDiscDo  = 2
+)
+
+// setDontFragment sets the DF bit on all datagrams of a backend socket; datagrams over the path MTU fail with EMSGSIZE
+func setDontFragment(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	ipv6 := false
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6MTUDiscover, ipv6PMTUDiscDo)
+			return
+		}
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// dontFragmentSupported reports whether backend sockets can be set to never fragment
+const dontFragmentSupported = false
+
+// setDontFragment is unavailable; IP_MTU_DISCOVER is a Linux option
+func setDontFragment(conn *net.UDPConn) error {
+	return fmt.Errorf("dont_fragment is only supported on Linux")
+}
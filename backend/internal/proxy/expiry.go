@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// revalidateInterval is how often established flows are checked against the allowlist
+const revalidateInterval = 5 * time.Second
+
+// maxBannerPageBytes bounds how much of an HTML page is buffered to inject the expiry banner
+const maxBannerPageBytes = 4 * 1024 * 1024
+
+// expiryWarningKey carries the remaining session time from handleRequest to modifyResponse
+type expiryWarningKey struct{}
+
+// sessionExpiring reports whether a client's portal session ends within the warning period
+func (p *HTTPProxy) sessionExpiring(clientIP netip.Addr) (time.Duration, time.Time, bool) {
+	if p.expiryWarning <= 0 || p.identity == nil {
+		return 0, time.Time{}, false
+	}
+
+	identity, ok := p.identity(clientIP)
+	if !ok || identity.ExpiresAt.IsZero() {
+		return 0, time.Time{}, false
+	}
+
+	remaining := time.Until(identity.ExpiresAt)
+	if remaining <= 0 || remaining > p.expiryWarning {
+		return 0, time.Time{}, false
+	}
+	return remaining, identity.ExpiresAt, true
+}
+
+// injectExpiryBanner inserts a warning banner after the <body> tag of uncompressed HTML pages
+// Pages that are compressed, too large or have no body tag are passed through unchanged
+func injectExpiryBanner(resp *http.Response, remaining time.Duration) {
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return
+	}
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return
+	}
+
+	original := resp.Body
+	page, err := io.ReadAll(io.LimitReader(original, maxBannerPageBytes+1))
+	if err != nil || len(page) > maxBannerPageBytes {
+		// Hand back what was read followed by the rest of the stream
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(page), original), Closer: original}
+		return
+	}
+
+	lower := bytes.ToLower(page)
+	start := bytes.Index(lower, []byte("<body"))
+	end := -1
+	if start >= 0 {
+		end = bytes.IndexByte(page[start:], '>')
+	}
+	if end < 0 {
+		resp.Body = readCloser{Reader: bytes.NewReader(page), Closer: original}
+		return
+	}
+	insertAt := start + end + 1
+
+	minutes := int(remaining.Round(time.Minute).Minutes())
+	if minutes < 1 {
+		minutes = 1
+	}
+	banner := fmt.Sprintf(`<div style="position:fixed;top:0;left:0;right:0;z-index:2147483647;padding:8px;background:#b45309;color:#fff;font:14px sans-serif;text-align:center">`+
+		`Your portal session expires in about %d minute(s). Sign in to the portal again to keep access.</div>`, minutes)
+
+	modified := make([]byte, 0, len(page)+len(banner))
+	modified = append(modified, page[:insertAt]...)
+	modified = append(modified, banner...)
+	modified = append(modified, page[insertAt:]...)
+
+	resp.Body = readCloser{Reader: bytes.NewReader(modified), Closer: original}
+	resp.ContentLength = int64(len(modified))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(modified)))
+	resp.Header.Del("ETag")
+}
+
+// readCloser pairs a replacement reader with the original body's Close
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// revalidateLoop closes TCP connections and UDP sessions of clients that lost access
+// (session expired or ended, outside schedule) once they have been without access for grace.
+// New connections are refused as soon as access ends; only established flows get the grace period
+func (m *Manager) revalidateLoop(stop chan struct{}, grace time.Duration) {
+	ticker := time.NewTicker(revalidateInterval)
+	defer ticker.Stop()
+
+	deniedSince := make(map[string]time.Time) // proxy key + client IP -> first seen without access
+	for {
+		select {
+		case <-ticker.C:
+			m.revalidate(deniedSince, grace)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// revalidate checks every client with established flows and closes those past the grace period
+func (m *Manager) revalidate(deniedSince map[string]time.Time, grace time.Duration) {
+	m.mu.RLock()
+	proxies := make(map[string]Proxy, len(m.proxies))
+	for key, proxy := range m.proxies {
+		proxies[key] = proxy
+	}
+	m.mu.RUnlock()
+
+	now := time.Now()
+	stillDenied := make(map[string]bool)
+
+	for key, proxy := range proxies {
+		var (
+			serviceID string
+			clientIPs []string
+			terminate func(clientIP string) int
+		)
+		switch p := proxy.(type) {
+		case *TCPProxy:
+			serviceID, clientIPs, terminate = p.service.ServiceID, p.activeClientIPs(), p.TerminateSessionsByIP
+		case *UDPProxy:
+			serviceID, clientIPs, terminate = p.service.ServiceID, p.activeClientIPs(), p.TerminateSessionsByIP
+		default:
+			continue
+		}
+
+		for _, clientIP := range clientIPs {
+			ip, err := netip.ParseAddr(clientIP)
+			if err != nil {
+				continue
+			}
+			if allowed, _ := checkAccess(m.allowlistManager, m.access, ip.Unmap(), serviceID); allowed {
+				continue
+			}
+
+			deniedKey := key + "|" + clientIP
+			since, seen := deniedSince[deniedKey]
+			if !seen {
+				since = now
+				deniedSince[deniedKey] = now
+			}
+			if now.Sub(since) < grace {
+				stillDenied[deniedKey] = true
+				continue
+			}
+
+			if closed := terminate(clientIP); closed > 0 {
+				log.Info().
+					Str("service_id", serviceID).
+					Str("client_ip", clientIP).
+					Int("closed", closed).
+					Dur("grace_period", grace).
+					Msg("Closed connections after client access ended")
+			}
+		}
+	}
+
+	// Forget clients that regained access, disconnected or were just closed
+	for deniedKey := range deniedSince {
+		if !stillDenied[deniedKey] {
+			delete(deniedSince, deniedKey)
+		}
+	}
+}
+
+// activeClientIPs returns the client IPs with open connections
+func (p *TCPProxy) activeClientIPs() []string {
+	p.connectionsMu.RLock()
+	defer p.connectionsMu.RUnlock()
+
+	clientIPs := make([]string, 0, len(p.connections))
+	for clientIP, conns := range p.connections {
+		if len(conns) > 0 {
+			clientIPs = append(clientIPs, clientIP)
+		}
+	}
+	return clientIPs
+}
+
+// activeClientIPs returns the client IPs with open sessions
+func (p *UDPProxy) activeClientIPs() []string {
+	p.sessionsMu.RLock()
+	defer p.sessionsMu.RUnlock()
+
+	seen := make(map[string]bool, len(p.sessions))
+	clientIPs := make([]string, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		clientIP := session.clientAddr.IP.String()
+		if !seen[clientIP] {
+			seen[clientIP] = true
+			clientIPs = append(clientIPs, clientIP)
+		}
+	}
+	return clientIPs
+}
@@ -2,35 +2,69 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/auth"
 	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/httplimit"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
-	"github.com/rs/zerolog/log"
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/davbauer/knock-knock-portal/internal/upgrade"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 // HTTPProxy handles HTTP reverse proxying with IP filtering
 type HTTPProxy struct {
 	service          *config.ProtectedServiceConfig
+	log              logging.Logger
 	allowlistManager *ipallowlist.Manager
 	blocklistManager *ipblocklist.Manager
 	server           *http.Server
+	h3Server         *http3.Server
+	h3Conn           net.PacketConn
 	proxy            *httputil.ReverseProxy
 	ctx              context.Context
 	cancel           context.CancelFunc
 	wg               sync.WaitGroup
 	requestCount     int64
+	activeRequests   int32 // Requests being served, including upgraded connections
 	circuitBreaker   *CircuitBreaker
+	backend          *backendResolver
+	denyPage         string
+	history          *stats.History
+	identity         IdentityResolver
+	access           AccessPolicy
+	expiryWarning    time.Duration // Warn clients this long before their session expires (0 = off)
+	expiryBanner     bool          // Inject a banner into HTML pages while warning
+	limiter          *httplimit.Limiter
+	connRate         *auth.RateLimiter // Shared per-IP new-connection limit; nil = unlimited
+	accessLog        io.Writer         // Receives a Common/Combined Log Format line per request; nil = off
+	latency          *latencyStats     // Dial and upstream response histograms; nil = not recorded
 	mu               sync.Mutex
 }
 
+// gRPC status codes used for requests rejected by the proxy
+const (
+	grpcStatusInternal         = 13
+	grpcStatusPermissionDenied = 7
+	grpcStatusUnavailable      = 14
+)
+
 // NewHTTPProxy creates a new HTTP reverse proxy
 func NewHTTPProxy(service *config.ProtectedServiceConfig, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager) (*HTTPProxy, error) {
 	backendURL, err := url.Parse(fmt.Sprintf("http://%s:%d", service.BackendTargetHost, service.BackendTargetPort))
@@ -38,63 +72,305 @@ func NewHTTPProxy(service *config.ProtectedServiceConfig, allowlistManager *ipal
 		return nil, fmt.Errorf("invalid backend URL: %w", err)
 	}
 
+	denyPage, err := loadDenyPage(service.DenyResponse)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	hp := &HTTPProxy{
 		service:          service,
+		log:              logging.Module("proxy.http").Service(service.ServiceID),
 		allowlistManager: allowlistManager,
 		blocklistManager: blocklistManager,
 		ctx:              ctx,
 		cancel:           cancel,
 		proxy:            httputil.NewSingleHostReverseProxy(backendURL),
-		circuitBreaker:   NewCircuitBreaker(service.ServiceName, 5, 30*time.Second, 3),
+		circuitBreaker:   newServiceCircuitBreaker(service),
+		denyPage:         denyPage,
 	}
 
 	// Customize the reverse proxy
 	hp.proxy.ErrorHandler = hp.errorHandler
 	hp.proxy.ModifyResponse = hp.modifyResponse
 
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if service.HTTPConfig != nil && service.HTTPConfig.ResponseTimeoutSeconds > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(service.HTTPConfig.ResponseTimeoutSeconds) * time.Second
+	}
+	configurePool(transport, service.HTTPConfig)
+
+	// Dial the cached backend address, re-resolving on failure; pooled connections to a moved backend are dropped
+	hp.backend = newServiceBackendResolver(service)
+	hp.backend.onChange = transport.CloseIdleConnections
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, _, err := hp.backend.dialTCP(ctx, 30*time.Second)
+		if err == nil {
+			hp.latency.observeDial(time.Since(start))
+		}
+		return conn, err
+	}
+
+	// gRPC backends only speak HTTP/2; trailers are forwarded by the reverse proxy
+	if hp.h2c() {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	hp.proxy.Transport = &timedTransport{RoundTripper: transport, proxy: hp}
+
 	return hp, nil
 }
 
 // Start begins the HTTP proxy server
 func (p *HTTPProxy) Start() error {
-	listenAddr := fmt.Sprintf(":%d", p.service.ProxyListenPortStart)
+	listenAddr := net.JoinHostPort(p.service.ProxyListenAddress, strconv.Itoa(p.service.ProxyListenPortStart))
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", p.handleRequest)
+	handler := p.withAccessLog(mux)
 
 	p.server = &http.Server{
 		Addr:         listenAddr,
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	log.Info().
+	if p.h2c() {
+		p.server.Protocols = new(http.Protocols)
+		p.server.Protocols.SetHTTP1(true)
+		p.server.Protocols.SetUnencryptedHTTP2(true)
+
+		// gRPC streams are long-lived, so only bound the header read
+		p.server.ReadTimeout = 0
+		p.server.WriteTimeout = 0
+		p.server.ReadHeaderTimeout = 30 * time.Second
+	}
+
+	if p.connRate != nil {
+		p.server.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state != http.StateNew {
+				return
+			}
+			if clientIP, ok := parseIPFromAddr(conn.RemoteAddr().String()); ok && !allowNewConnection(p.connRate, clientIP.String(), p.service.ServiceName) {
+				conn.Close()
+			}
+		}
+	}
+
+	if p.limiter != nil {
+		if timeout := p.limiter.HeaderReadTimeout(); timeout > 0 {
+			p.server.ReadHeaderTimeout = timeout
+		}
+	}
+	if hc := p.service.HTTPConfig; hc != nil {
+		if hc.HeaderTimeoutSeconds > 0 {
+			p.server.ReadHeaderTimeout = time.Duration(hc.HeaderTimeoutSeconds) * time.Second
+		}
+		if hc.MaxRequestHeaderBytes > 0 {
+			p.server.MaxHeaderBytes = hc.MaxRequestHeaderBytes
+		}
+	}
+
+	p.log.Info().
 		Str("service_id", p.service.ServiceID).
 		Int("proxy_port", p.service.ProxyListenPortStart).
 		Str("backend", fmt.Sprintf("http://%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort)).
 		Msg("Starting HTTP proxy listener")
 
+	listener, err := upgrade.Listen(config.ListenNetwork("tcp", p.service.ProxyListenAddress), listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP listener on %s: %w", listenAddr, err)
+	}
+	if p.limiter != nil {
+		listener = p.limiter.Listener(listener)
+	}
+
+	if p.service.HTTPConfig != nil && p.service.HTTPConfig.EnableHTTP3 {
+		if err := p.startHTTP3(handler); err != nil {
+			p.log.Error().
+				Err(err).
+				Str("service", p.service.ServiceName).
+				Msg("Failed to start HTTP/3 listener, serving TCP only")
+		}
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if err := p.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			p.log.Error().Err(err).Msg("HTTP proxy server error")
+		}
+	}()
+
+	if hc := p.service.HTTPConfig; hc != nil && hc.PrewarmConnections > 0 {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.prewarm(hc.PrewarmConnections)
+		}()
+	}
+
+	return nil
+}
+
+// configurePool applies the service's backend connection pool settings to the transport
+func configurePool(transport *http.Transport, hc *config.HTTPProtocolConfig) {
+	if hc == nil {
+		return
+	}
+	if hc.MaxIdleConns > 0 {
+		transport.MaxIdleConns = hc.MaxIdleConns
+	}
+	if hc.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = hc.MaxIdleConnsPerHost
+	}
+	// Pre-warmed connections beyond the per-host idle limit would be closed right away
+	if hc.PrewarmConnections > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = hc.PrewarmConnections
+	}
+	if transport.MaxIdleConns > 0 && transport.MaxIdleConnsPerHost > transport.MaxIdleConns {
+		transport.MaxIdleConns = transport.MaxIdleConnsPerHost
+	}
+	if hc.IdleConnTimeoutSeconds > 0 {
+		transport.IdleConnTimeout = time.Duration(hc.IdleConnTimeoutSeconds) * time.Second
+	}
+	transport.DisableKeepAlives = hc.DisableKeepAlives
+}
+
+// prewarm opens backend connections ahead of the first client request by sending concurrent
+// HEAD / requests; the connections stay in the idle pool for the next requests
+func (p *HTTPProxy) prewarm(count int) {
+	ctx, cancel := context.WithTimeout(p.ctx, 30*time.Second)
+	defer cancel()
+
+	backendURL := fmt.Sprintf("http://%s/", net.JoinHostPort(p.service.BackendTargetHost, strconv.Itoa(p.service.BackendTargetPort)))
+
+	var (
+		wg     sync.WaitGroup
+		opened atomic.Int32
+	)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, backendURL, nil)
+			if err != nil {
+				return
+			}
+			resp, err := p.proxy.Transport.RoundTrip(req)
+			if err != nil {
+				p.log.Debug().
+					Err(err).
+					Str("service", p.service.ServiceName).
+					Msg("Failed to pre-warm backend connection")
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			opened.Add(1)
+		}()
+	}
+	wg.Wait()
+
+	p.log.Info().
+		Str("service", p.service.ServiceName).
+		Int32("opened", opened.Load()).
+		Int("requested", count).
+		Msg("Pre-warmed backend connections")
+}
+
+// startHTTP3 serves the handler over QUIC on the same port number
+// Handshakes from addresses that are blocked or not allowlisted are refused before any TLS work
+func (p *HTTPProxy) startHTTP3(handler http.Handler) error {
+	cert, err := tls.LoadX509KeyPair(p.service.HTTPConfig.TLSCertFile, p.service.HTTPConfig.TLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	listenAddr := net.JoinHostPort(p.service.ProxyListenAddress, strconv.Itoa(p.service.ProxyListenPortStart))
+	conn, err := upgrade.ListenUDP(config.ListenNetwork("udp", p.service.ProxyListenAddress), listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP/3 listener on %s: %w", listenAddr, err)
+	}
+
+	quicConfig := &quic.Config{}
+	quicConfig.GetConfigForClient = func(info *quic.ClientInfo) (*quic.Config, error) {
+		if err := p.checkQUICSource(info.RemoteAddr); err != nil {
+			return nil, err
+		}
+		return quicConfig, nil
+	}
+
+	p.h3Conn = conn
+	p.h3Server = &http3.Server{
+		Handler:     handler,
+		Port:        p.service.ProxyListenPortStart,
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13},
+		QUICConfig:  quicConfig,
+		IdleTimeout: 60 * time.Second,
+	}
+
+	p.log.Info().
+		Str("service_id", p.service.ServiceID).
+		Int("proxy_port", p.service.ProxyListenPortStart).
+		Msg("Starting HTTP/3 proxy listener")
+
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
-		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Error().Err(err).Msg("HTTP proxy server error")
+		if err := p.h3Server.Serve(conn); err != nil && err != http.ErrServerClosed {
+			p.log.Error().Err(err).Msg("HTTP/3 proxy server error")
 		}
 	}()
 
 	return nil
 }
 
+// checkQUICSource applies the blocklist and allowlist to the source of a QUIC handshake
+func (p *HTTPProxy) checkQUICSource(addr net.Addr) error {
+	clientIP, ok := parseIPFromAddr(addr.String())
+	if !ok {
+		return fmt.Errorf("invalid client address %s", addr)
+	}
+
+	if blocked, reason := p.blocklistManager.IsIPBlocked(net.ParseIP(clientIP.String())); blocked {
+		if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "blocked") {
+			p.log.Warn().
+				Str("client_ip", clientIP.String()).
+				Str("service", p.service.ServiceName).
+				Str("reason", reason).
+				Msg("QUIC handshake refused: IP is blocked")
+		}
+		return fmt.Errorf("client %s is blocked", clientIP)
+	}
+
+	if allowed, reason := checkAccess(p.allowlistManager, p.access, clientIP, p.service.ServiceID); !allowed {
+		if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "not_allowed") {
+			p.log.Warn().
+				Str("client_ip", clientIP.String()).
+				Str("service", p.service.ServiceName).
+				Str("reason", reason).
+				Msg("QUIC handshake refused: IP not in allowlist")
+		}
+		return fmt.Errorf("client %s is not allowed", clientIP)
+	}
+
+	return nil
+}
+
 // handleRequest processes incoming HTTP requests
 func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&p.activeRequests, 1)
+	defer atomic.AddInt32(&p.activeRequests, -1)
+
 	// Check if proxy is shutting down
 	select {
 	case <-p.ctx.Done():
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		p.writeError(w, r, http.StatusServiceUnavailable, "Service Unavailable")
 		return
 	default:
 	}
@@ -102,45 +378,82 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	// Extract client IP
 	clientIP, ok := parseIPFromAddr(r.RemoteAddr)
 	if !ok {
-		log.Warn().Str("addr", r.RemoteAddr).Msg("Failed to parse client IP")
-		http.Error(w, "Invalid client address", http.StatusBadRequest)
+		p.log.Warn().Str("addr", r.RemoteAddr).Msg("Failed to parse client IP")
+		p.writeError(w, r, http.StatusBadRequest, "Invalid client address")
 		return
 	}
 
 	// HIGHEST PRIORITY: Check IP blocklist first
 	if blocked, blockReason := p.blocklistManager.IsIPBlocked(net.ParseIP(clientIP.String())); blocked {
-		log.Warn().
-			Str("client_ip", clientIP.String()).
-			Str("service", p.service.ServiceName).
-			Str("path", r.URL.Path).
-			Str("reason", blockReason).
-			Msg("HTTP request denied: IP is blocked")
-		http.Error(w, "Access Denied", http.StatusForbidden)
+		if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "blocked") {
+			p.log.Warn().
+				Str("client_ip", clientIP.String()).
+				Str("service", p.service.ServiceName).
+				Str("path", r.URL.Path).
+				Str("reason", blockReason).
+				Msg("HTTP request denied: IP is blocked")
+		}
+		p.writeError(w, r, http.StatusForbidden, "Access Denied")
 		return
 	}
 
 	// Check IP allowlist
-	allowed, reason := p.allowlistManager.IsIPAllowed(clientIP)
+	allowed, reason := checkAccess(p.allowlistManager, p.access, clientIP, p.service.ServiceID)
 	if !allowed {
-		log.Warn().
-			Str("client_ip", clientIP.String()).
-			Str("service", p.service.ServiceName).
-			Str("path", r.URL.Path).
-			Str("reason", reason).
-			Msg("HTTP request denied: IP not in allowlist")
-		http.Error(w, "Access Denied", http.StatusForbidden)
+		if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "not_allowed") {
+			p.log.Warn().
+				Str("client_ip", clientIP.String()).
+				Str("service", p.service.ServiceName).
+				Str("path", r.URL.Path).
+				Str("reason", reason).
+				Msg("HTTP request denied: IP not in allowlist")
+		}
+		if p.denyPage != "" && !isGRPCRequest(r) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(renderDenyText(p.denyPage, clientIP.String(), p.service.ServiceName)))
+			return
+		}
+		p.writeError(w, r, http.StatusForbidden, "Access Denied")
 		return
 	}
 
+	// Bound the requests a single client can have in flight
+	if p.limiter != nil {
+		release, ok := p.limiter.Acquire(clientIP.String())
+		if !ok {
+			if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "in_flight_limit") {
+				p.log.Warn().
+					Str("client_ip", clientIP.String()).
+					Str("service", p.service.ServiceName).
+					Str("path", r.URL.Path).
+					Msg("HTTP request denied: too many in-flight requests from IP")
+			}
+			p.writeError(w, r, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+		defer release()
+
+		// Cut off request bodies trickled in slower than the minimum transfer rate
+		var notAfter time.Time
+		if p.server.ReadTimeout > 0 {
+			notAfter = time.Now().Add(p.server.ReadTimeout)
+		}
+		p.limiter.LimitBody(w, r, notAfter)
+	}
+
 	// Check circuit breaker
 	if !p.circuitBreaker.Allow() {
-		log.Warn().
-			Str("client_ip", clientIP.String()).
-			Str("service", p.service.ServiceName).
-			Str("path", r.URL.Path).
-			Str("circuit_state", p.circuitBreaker.GetState().String()).
-			Msg("HTTP request denied: circuit breaker is open")
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "circuit_open") {
+			p.log.Warn().
+				Str("client_ip", clientIP.String()).
+				Str("service", p.service.ServiceName).
+				Str("path", r.URL.Path).
+				Str("circuit_state", p.circuitBreaker.GetState().String()).
+				Msg("HTTP request denied: circuit breaker is open")
+		}
+		p.writeError(w, r, http.StatusServiceUnavailable, "Service Unavailable")
 		return
 	}
 
@@ -150,7 +463,12 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	reqID := p.requestCount
 	p.mu.Unlock()
 
-	log.Info().
+	p.history.RecordConnection(p.service.ServiceID, clientIP.String())
+	if p.history != nil && r.Body != nil && r.Body != http.NoBody {
+		r.Body = &historyReader{ReadCloser: r.Body, history: p.history, serviceID: p.service.ServiceID, clientIP: clientIP.String()}
+	}
+
+	p.log.Info().
 		Int64("req_id", reqID).
 		Str("client_ip", clientIP.String()).
 		Str("method", r.Method).
@@ -158,26 +476,163 @@ func (p *HTTPProxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 		Str("service", p.service.ServiceName).
 		Msg("Proxying HTTP request")
 
+	// Enforce the request body limit before contacting the backend
+	if limit := p.maxRequestBodyBytes(); limit > 0 {
+		if r.ContentLength > limit {
+			p.log.Warn().
+				Str("client_ip", clientIP.String()).
+				Str("service", p.service.ServiceName).
+				Int64("content_length", r.ContentLength).
+				Int64("limit", limit).
+				Msg("HTTP request denied: body too large")
+			p.writeError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	// Warn clients whose portal session is about to expire
+	if remaining, expiresAt, ok := p.sessionExpiring(clientIP); ok {
+		w.Header().Set("X-Session-Expires-In", strconv.Itoa(int(remaining.Seconds())))
+		w.Header().Set("X-Session-Expires-At", expiresAt.UTC().Format(time.RFC3339))
+		if p.expiryBanner && !isGRPCRequest(r) {
+			r = r.WithContext(context.WithValue(r.Context(), expiryWarningKey{}, remaining))
+		}
+	}
+
+	// Advertise the HTTP/3 listener to HTTP/1.1 and HTTP/2 clients
+	if p.h3Server != nil && r.ProtoMajor < 3 {
+		p.h3Server.SetQUICHeaders(w.Header())
+	}
+
 	// Proxy the request
 	p.proxy.ServeHTTP(w, r)
 }
 
 // errorHandler handles reverse proxy errors
 func (p *HTTPProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	// Oversized requests are the client's fault, not the backend's
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		p.log.Warn().
+			Str("service", p.service.ServiceName).
+			Str("path", r.URL.Path).
+			Int64("limit", maxBytesErr.Limit).
+			Msg("HTTP request denied: body too large")
+		p.writeError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+		return
+	}
+
+	// A client that went away says nothing about the backend, and nobody reads the response
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		p.log.Debug().
+			Str("service", p.service.ServiceName).
+			Str("path", r.URL.Path).
+			Msg("HTTP request canceled by client")
+		return
+	}
+
 	p.circuitBreaker.RecordFailure()
 
-	log.Error().
+	p.log.Error().
 		Err(err).
 		Str("service", p.service.ServiceName).
 		Str("path", r.URL.Path).
 		Str("circuit_state", p.circuitBreaker.GetState().String()).
 		Msg("HTTP proxy error")
 
-	http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		p.writeError(w, r, http.StatusGatewayTimeout, "Gateway Timeout")
+		return
+	}
+	p.writeError(w, r, http.StatusBadGateway, "Bad Gateway")
+}
+
+// maxRequestBodyBytes returns the request body limit (0 = unlimited)
+func (p *HTTPProxy) maxRequestBodyBytes() int64 {
+	if p.service.HTTPConfig == nil {
+		return 0
+	}
+	return p.service.HTTPConfig.MaxRequestBodyBytes
+}
+
+// errResponseTooLarge is returned when a backend response exceeds max_response_body_bytes
+var errResponseTooLarge = errors.New("backend response exceeds body size limit")
+
+// limitedBody fails reads once more than the allowed number of bytes have been read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(data []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(data)) > b.remaining+1 {
+		data = data[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(data)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, errResponseTooLarge
+	}
+	return n, err
+}
+
+// h2c reports whether the service speaks HTTP/2 cleartext (gRPC)
+func (p *HTTPProxy) h2c() bool {
+	return p.service.HTTPConfig != nil && p.service.HTTPConfig.EnableH2C
+}
+
+// isGRPCRequest reports whether a request is a gRPC call
+func isGRPCRequest(r *http.Request) bool {
+	return r.ProtoMajor >= 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// writeError rejects a request; gRPC clients ignore HTTP status codes, so they get a gRPC status instead
+func (p *HTTPProxy) writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if !isGRPCRequest(r) {
+		http.Error(w, message, status)
+		return
+	}
+
+	code := grpcStatusUnavailable
+	switch status {
+	case http.StatusForbidden:
+		code = grpcStatusPermissionDenied
+	case http.StatusBadRequest:
+		code = grpcStatusInternal
+	}
+
+	// Trailers-only response: the status travels in the headers
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
 }
 
 // modifyResponse allows modifying the backend response
 func (p *HTTPProxy) modifyResponse(resp *http.Response) error {
+	// Oversized responses are rejected up front when announced, otherwise cut off mid-stream
+	if hc := p.service.HTTPConfig; hc != nil && hc.MaxResponseBodyBytes > 0 {
+		if resp.ContentLength > hc.MaxResponseBodyBytes {
+			return errResponseTooLarge
+		}
+		resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: hc.MaxResponseBodyBytes}
+	}
+
+	if remaining, ok := resp.Request.Context().Value(expiryWarningKey{}).(time.Duration); ok {
+		injectExpiryBanner(resp, remaining)
+	}
+
+	if p.history != nil {
+		if clientIP, ok := parseIPFromAddr(resp.Request.RemoteAddr); ok {
+			resp.Body = &historyReader{ReadCloser: resp.Body, history: p.history, serviceID: p.service.ServiceID, clientIP: clientIP.String(), toClient: true}
+		}
+	}
+
 	// Record success for circuit breaker
 	if resp.StatusCode < 500 {
 		p.circuitBreaker.RecordSuccess()
@@ -190,18 +645,20 @@ func (p *HTTPProxy) modifyResponse(resp *http.Response) error {
 
 // Stop gracefully shuts down the HTTP proxy
 func (p *HTTPProxy) Stop() error {
-	log.Info().
+	p.log.Info().
 		Str("service", p.service.ServiceName).
 		Msg("Stopping HTTP proxy")
 
 	p.cancel()
 
+	p.stopHTTP3()
+
 	if p.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := p.server.Shutdown(ctx); err != nil {
-			log.Warn().
+			p.log.Warn().
 				Err(err).
 				Str("service", p.service.ServiceName).
 				Msg("HTTP proxy shutdown error")
@@ -211,32 +668,84 @@ func (p *HTTPProxy) Stop() error {
 
 	p.wg.Wait()
 
-	log.Info().
+	p.log.Info().
 		Str("service", p.service.ServiceName).
 		Msg("HTTP proxy stopped")
 
 	return nil
 }
 
+// stopHTTP3 closes the QUIC listener and its connections
+// QUIC connections cannot be handed over, so clients reconnect to the new process
+func (p *HTTPProxy) stopHTTP3() {
+	if p.h3Server == nil {
+		return
+	}
+	if err := p.h3Server.Close(); err != nil {
+		p.log.Warn().
+			Err(err).
+			Str("service", p.service.ServiceName).
+			Msg("HTTP/3 proxy shutdown error")
+	}
+	p.h3Conn.Close()
+}
+
+// Drain stops accepting connections and waits for in-flight requests before stopping
+// A zero timeout waits indefinitely
+func (p *HTTPProxy) Drain(timeout time.Duration) error {
+	p.stopHTTP3()
+
+	if p.server != nil {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		p.log.Info().
+			Str("service", p.service.ServiceName).
+			Msg("Draining HTTP proxy")
+
+		if err := p.server.Shutdown(ctx); err != nil {
+			p.log.Warn().
+				Err(err).
+				Str("service", p.service.ServiceName).
+				Msg("Drain timeout reached, closing remaining requests")
+			p.server.Close()
+		}
+	}
+
+	return p.Stop()
+}
+
 // GetStats returns statistics about the HTTP proxy
 func (p *HTTPProxy) GetStats() map[string]interface{} {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	return map[string]interface{}{
-		"total_requests":  p.requestCount,
-		"service_name":    p.service.ServiceName,
-		"listen_port":     p.service.ProxyListenPortStart,
-		"backend_addr":    fmt.Sprintf("http://%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort),
-		"circuit_breaker": p.circuitBreaker.GetStats(),
+	stats := map[string]interface{}{
+		"total_requests":     p.requestCount,
+		"service_name":       p.service.ServiceName,
+		"listen_port":        p.service.ProxyListenPortStart,
+		"backend_addr":       fmt.Sprintf("http://%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort),
+		"circuit_breaker":    p.circuitBreaker.GetStats(),
+		"backend_resolution": p.backend.stats(),
+	}
+	if p.service.ProxyListenAddress != "" {
+		stats["listen_address"] = p.service.ProxyListenAddress
+	}
+	if p.latency != nil {
+		stats["latency"] = p.latency.summary()
 	}
+	return stats
 }
 
 // TerminateConnectionsByIP is not supported for HTTP proxy (connections are short-lived)
 func (p *HTTPProxy) TerminateConnectionsByIP(clientIP string) int {
 	// HTTP connections are short-lived and managed by the HTTP server
 	// There's no persistent connection to terminate
-	log.Debug().
+	p.log.Debug().
 		Str("service", p.service.ServiceName).
 		Str("client_ip", clientIP).
 		Msg("HTTP proxy does not support connection termination (connections are short-lived)")
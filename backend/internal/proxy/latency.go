@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+)
+
+// latencyStats holds the timing histograms of one proxy
+// The manager keeps them per proxy key, so they survive reloads and restarts of the proxy
+type latencyStats struct {
+	duration *stats.Histogram // TCP connection or UDP session lifetime; nil for HTTP
+	dial     *stats.Histogram // Backend connect time; nil for UDP, not recorded for SOCKS5 whose targets vary
+	upstream *stats.Histogram // HTTP time to response headers; nil for TCP and UDP
+}
+
+// newLatencyStats creates the histograms that apply to a protocol
+func newLatencyStats(protocol string) *latencyStats {
+	l := &latencyStats{}
+	switch protocol {
+	case "tcp":
+		l.duration = stats.NewHistogram(stats.DurationBuckets)
+		l.dial = stats.NewHistogram(stats.LatencyBuckets)
+	case "udp":
+		l.duration = stats.NewHistogram(stats.DurationBuckets)
+	case "http":
+		l.dial = stats.NewHistogram(stats.LatencyBuckets)
+		l.upstream = stats.NewHistogram(stats.LatencyBuckets)
+	}
+	return l
+}
+
+// observeDuration records a finished connection or session; no-op when l is nil
+func (l *latencyStats) observeDuration(d time.Duration) {
+	if l != nil && l.duration != nil {
+		l.duration.Observe(d)
+	}
+}
+
+// observeDial records a successful backend connect; no-op when l is nil
+func (l *latencyStats) observeDial(d time.Duration) {
+	if l != nil && l.dial != nil {
+		l.dial.Observe(d)
+	}
+}
+
+// observeUpstream records an HTTP backend response time; no-op when l is nil
+func (l *latencyStats) observeUpstream(d time.Duration) {
+	if l != nil && l.upstream != nil {
+		l.upstream.Observe(d)
+	}
+}
+
+// summary returns the percentile summaries for GetStats
+func (l *latencyStats) summary() map[string]interface{} {
+	if l == nil {
+		return nil
+	}
+	summary := map[string]interface{}{}
+	if l.duration != nil {
+		summary["duration"] = l.duration.Summary()
+	}
+	if l.dial != nil {
+		summary["backend_dial"] = l.dial.Summary()
+	}
+	if l.upstream != nil {
+		summary["upstream_response"] = l.upstream.Summary()
+	}
+	return summary
+}
+
+// timedTransport measures how long the backend takes to send response headers
+type timedTransport struct {
+	http.RoundTripper
+	proxy *HTTPProxy
+}
+
+// RoundTrip forwards the request and records the upstream response time of successful round trips
+func (t *timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil {
+		t.proxy.latency.observeUpstream(time.Since(start))
+	}
+	return resp, err
+}
+
+// latencyFor returns the histograms of a proxy, creating them on first use
+func (m *Manager) latencyFor(key, protocol string) *latencyStats {
+	m.latencyMu.Lock()
+	defer m.latencyMu.Unlock()
+
+	l, ok := m.latency[key]
+	if !ok {
+		l = newLatencyStats(protocol)
+		m.latency[key] = l
+	}
+	return l
+}
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const (
+	defaultContainerStartTimeout = 60 * time.Second
+	containerPollInterval        = 500 * time.Millisecond
+	containerStopTimeout         = 10 * time.Second // Grace period before Docker kills the container
+)
+
+// lazyContainer starts a backend's Docker container on the first connection and stops it once idle
+// Shared by the TCP and UDP proxies of a service and kept across reloads, so idle time survives them
+type lazyContainer struct {
+	serviceID    string
+	serviceName  string
+	cfg          config.LazyContainerConfig
+	docker       *dockerClient
+	startTimeout time.Duration
+	idleStop     time.Duration // 0 = never stopped
+
+	mu        sync.Mutex   // Serializes starting and stopping
+	running   atomic.Bool  // Known to run; false until checked, so the first connection inspects the container
+	starting  atomic.Bool  // An asynchronous start is under way
+	startedAt atomic.Int64 // Unix nanoseconds the container was last seen starting or running
+	starts    atomic.Int64
+	stops     atomic.Int64
+	lastErr   atomic.Value // string
+}
+
+// newLazyContainer builds the container control of a service
+func newLazyContainer(service *config.ProtectedServiceConfig, docker *dockerClient) *lazyContainer {
+	c := &lazyContainer{
+		serviceID:    service.ServiceID,
+		serviceName:  service.ServiceName,
+		cfg:          *service.LazyContainer,
+		docker:       docker,
+		startTimeout: time.Duration(service.LazyContainer.StartTimeoutSeconds) * time.Second,
+		idleStop:     time.Duration(service.LazyContainer.IdleStopMinutes) * time.Minute,
+	}
+	if c.startTimeout <= 0 {
+		c.startTimeout = defaultContainerStartTimeout
+	}
+	return c
+}
+
+// ensureRunning starts the container unless it is known to run and waits until Docker reports it
+// running and, with a health check, healthy; started reports whether this call started it
+func (c *lazyContainer) ensureRunning(ctx context.Context) (started bool, err error) {
+	if c == nil || c.running.Load() {
+		return false, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running.Load() {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.startTimeout)
+	defer cancel()
+
+	running, health, err := c.docker.containerState(ctx, c.cfg.ContainerName)
+	if err != nil {
+		return false, c.fail(err)
+	}
+	if !running {
+		log.Info().
+			Str("service", c.serviceName).
+			Str("container", c.cfg.ContainerName).
+			Msg("Starting backend container for incoming connection")
+		if err := c.docker.startContainer(ctx, c.cfg.ContainerName); err != nil {
+			return false, c.fail(err)
+		}
+		c.starts.Add(1)
+		started = true
+	}
+
+	ticker := time.NewTicker(containerPollInterval)
+	defer ticker.Stop()
+	for !running || (health != "" && health != "healthy") {
+		select {
+		case <-ctx.Done():
+			return started, c.fail(ctx.Err())
+		case <-ticker.C:
+		}
+		if running, health, err = c.docker.containerState(ctx, c.cfg.ContainerName); err != nil {
+			return started, c.fail(err)
+		}
+	}
+
+	c.running.Store(true)
+	c.startedAt.Store(time.Now().UnixNano())
+	c.lastErr.Store("")
+	if started {
+		log.Info().
+			Str("service", c.serviceName).
+			Str("container", c.cfg.ContainerName).
+			Msg("Backend container is up")
+	}
+	return started, nil
+}
+
+// startAsync starts the container in the background; datagram services can't hold a client
+func (c *lazyContainer) startAsync(ctx context.Context, started func()) {
+	if !c.starting.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer c.starting.Store(false)
+		if ok, err := c.ensureRunning(ctx); err == nil && ok {
+			started()
+		}
+	}()
+}
+
+// invalidate forgets that the container runs, e.g. after it was stopped outside the portal
+func (c *lazyContainer) invalidate() {
+	c.running.Store(false)
+}
+
+// fail records and logs a start failure
+func (c *lazyContainer) fail(err error) error {
+	c.lastErr.Store(err.Error())
+	log.Error().
+		Err(err).
+		Str("service", c.serviceName).
+		Str("container", c.cfg.ContainerName).
+		Msg("Failed to start backend container")
+	return err
+}
+
+// checkIdle stops the container once the service has been idle for the idle period
+// A container started moments ago gets the full period, even if the connection that started it failed
+func (c *lazyContainer) checkIdle(idle time.Duration) {
+	if c == nil || c.idleStop <= 0 || !c.running.Load() {
+		return
+	}
+	if idle < c.idleStop || time.Since(time.Unix(0, c.startedAt.Load())) < c.idleStop {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), containerStopTimeout+10*time.Second)
+	defer cancel()
+	if err := c.docker.stopContainer(ctx, c.cfg.ContainerName, containerStopTimeout); err != nil {
+		c.lastErr.Store(err.Error())
+		log.Error().
+			Err(err).
+			Str("service", c.serviceName).
+			Str("container", c.cfg.ContainerName).
+			Msg("Failed to stop idle backend container")
+		return
+	}
+	c.running.Store(false)
+	c.stops.Add(1)
+
+	log.Info().
+		Str("service", c.serviceName).
+		Str("container", c.cfg.ContainerName).
+		Dur("idle", idle).
+		Msg("Stopped idle backend container")
+}
+
+// stats returns the container state for the admin API
+func (c *lazyContainer) stats() map[string]interface{} {
+	stats := map[string]interface{}{
+		"container": c.cfg.ContainerName,
+		"running":   c.running.Load(),
+		"starting":  c.starting.Load(),
+		"starts":    c.starts.Load(),
+		"stops":     c.stops.Load(),
+	}
+	if err, _ := c.lastErr.Load().(string); err != "" {
+		stats["last_error"] = err
+	}
+	return stats
+}
+
+// containerFor returns the container control of a service, reusing it while its settings are unchanged
+func (m *Manager) containerFor(service *config.ProtectedServiceConfig) *lazyContainer {
+	if service.LazyContainer == nil {
+		return nil
+	}
+
+	m.containersMu.Lock()
+	defer m.containersMu.Unlock()
+
+	if c, ok := m.containers[service.ServiceID]; ok && c.cfg == *service.LazyContainer {
+		return c
+	}
+	if m.docker == nil {
+		docker, err := newDockerClient()
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("service", service.ServiceName).
+				Msg("Docker API unavailable, backend container is not managed")
+			return nil
+		}
+		m.docker = docker
+	}
+	c := newLazyContainer(service, m.docker)
+	m.containers[service.ServiceID] = c
+	return c
+}
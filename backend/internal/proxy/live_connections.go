@@ -0,0 +1,79 @@
+package proxy
+
+import "sort"
+
+// Kinds of connection changes
+const (
+	ConnectionOpened  = "opened"
+	ConnectionUpdated = "updated" // Traffic counters changed
+	ConnectionClosed  = "closed"  // Carries the counters last seen
+)
+
+// ConnectionChange is a connection that was opened, moved traffic or closed between two polls of a ConnectionWatcher
+type ConnectionChange struct {
+	Type       string         `json:"type"`
+	Connection ConnectionInfo `json:"connection"`
+}
+
+// ConnectionWatcher reports the changes of all proxied connections since its previous poll
+type ConnectionWatcher struct {
+	manager *Manager
+	known   map[string]ConnectionInfo // Connection ID -> as last reported
+}
+
+// ListConnections lists the individual connections and sessions of all proxies, oldest first
+func (m *Manager) ListConnections() []ConnectionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	connections := []ConnectionInfo{}
+	for _, proxy := range m.proxies {
+		if udpProxy, ok := proxy.(*UDPProxy); ok {
+			connections = append(connections, udpProxy.ListConnections()...)
+		} else if tcpProxy, ok := proxy.(*TCPProxy); ok {
+			connections = append(connections, tcpProxy.ListConnections()...)
+		}
+	}
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].StartedAt.Before(connections[j].StartedAt)
+	})
+	return connections
+}
+
+// WatchConnections returns the current connections and a watcher for the changes from there on
+func (m *Manager) WatchConnections() (*ConnectionWatcher, []ConnectionInfo) {
+	connections := m.ListConnections()
+	known := make(map[string]ConnectionInfo, len(connections))
+	for _, conn := range connections {
+		known[conn.ID] = conn
+	}
+	return &ConnectionWatcher{manager: m, known: known}, connections
+}
+
+// Poll returns the connections opened, updated and closed since the previous poll
+func (w *ConnectionWatcher) Poll() []ConnectionChange {
+	changes := []ConnectionChange{}
+	current := make(map[string]ConnectionInfo, len(w.known))
+
+	for _, conn := range w.manager.ListConnections() {
+		current[conn.ID] = conn
+		previous, ok := w.known[conn.ID]
+		switch {
+		case !ok:
+			changes = append(changes, ConnectionChange{Type: ConnectionOpened, Connection: conn})
+		case conn.BytesReceived != previous.BytesReceived || conn.BytesSent != previous.BytesSent ||
+			conn.PacketsReceived != previous.PacketsReceived || conn.PacketsSent != previous.PacketsSent:
+			changes = append(changes, ConnectionChange{Type: ConnectionUpdated, Connection: conn})
+		}
+	}
+
+	for id, conn := range w.known {
+		if _, ok := current[id]; !ok {
+			changes = append(changes, ConnectionChange{Type: ConnectionClosed, Connection: conn})
+		}
+	}
+
+	w.known = current
+	return changes
+}
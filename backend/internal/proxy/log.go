@@ -0,0 +1,6 @@
+package proxy
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["proxy"] overrides its level
+var log = logging.Module("proxy")
@@ -1,14 +1,21 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/auth"
 	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/httplimit"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
-	"github.com/rs/zerolog/log"
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
 )
 
 // Proxy is the interface for all proxy types
@@ -25,21 +32,69 @@ type Manager struct {
 	allowlistManager *ipallowlist.Manager
 	blocklistManager *ipblocklist.Manager
 	proxies          map[string]Proxy
+	history          *stats.History
+	identity         IdentityResolver
+	access           AccessPolicy
 	mu               sync.RWMutex
 	stopStatsTicker  chan struct{}
+	connRate         *auth.RateLimiter       // Per-IP new-connection limit shared by all proxies; nil = unlimited
+	lifecycleMu      sync.Mutex              // Serializes reloads and single-service starts/stops
+	overrides        map[string]bool         // Service ID -> enabled, set at runtime over the configured Enabled flag
+	failures         []ProxyFailure          // Proxies of enabled services that could not be started
+	probes           map[string]BackendProbe // Proxy key -> last backend reachability check
+	accessLogsMu     sync.Mutex
+	accessLogs       map[string]*logging.RotatingFile // Path -> HTTP access log shared by the services writing to it
+	latencyMu        sync.Mutex
+	latency          map[string]*latencyStats // "service_id|protocol" -> timing histograms, kept across reloads
+	containersMu     sync.Mutex
+	containers       map[string]*lazyContainer // Service ID -> on-demand backend container, kept across reloads
+	docker           *dockerClient             // Created with the first managed container
+	bus              *events.Bus               // Receives service idle events; nil = none
+	activityMu       sync.Mutex
+	activity         map[string]*serviceActivity // Service ID -> traffic tracking of running services
+}
+
+// ProxyFailure describes a proxy that could not be started, e.g. because its port is in use
+type ProxyFailure struct {
+	ServiceID     string    `json:"service_id"`
+	ServiceName   string    `json:"service_name"`
+	Protocol      string    `json:"protocol"`
+	ListenPort    int       `json:"listen_port"`
+	ListenAddress string    `json:"listen_address,omitempty"` // Set for additional listeners of the service
+	Error         string    `json:"error"`
+	FailedAt      time.Time `json:"failed_at"`
 }
 
 // NewManager creates a new proxy manager
-func NewManager(configLoader *config.Loader, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager) *Manager {
+// Traffic is recorded in history when it is non-nil
+func NewManager(configLoader *config.Loader, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, history *stats.History) *Manager {
 	return &Manager{
 		configLoader:     configLoader,
 		allowlistManager: allowlistManager,
 		blocklistManager: blocklistManager,
 		proxies:          make(map[string]Proxy),
+		overrides:        make(map[string]bool),
+		accessLogs:       make(map[string]*logging.RotatingFile),
+		latency:          make(map[string]*latencyStats),
+		containers:       make(map[string]*lazyContainer),
+		activity:         make(map[string]*serviceActivity),
+		history:          history,
 		stopStatsTicker:  make(chan struct{}),
 	}
 }
 
+// SetIdentityResolver installs the lookup used to attribute connections to portal users
+// Must be called before Start
+func (m *Manager) SetIdentityResolver(resolver IdentityResolver) {
+	m.identity = resolver
+}
+
+// SetAccessPolicy installs the check that further limits IPs allowed through a portal session
+// Must be called before Start
+func (m *Manager) SetAccessPolicy(policy AccessPolicy) {
+	m.access = policy
+}
+
 // Start initializes and starts all enabled proxies
 func (m *Manager) Start() error {
 	cfg := m.configLoader.GetConfig()
@@ -48,131 +103,311 @@ func (m *Manager) Start() error {
 		Int("total_services", len(cfg.ProtectedServices)).
 		Msg("Starting proxy manager")
 
-	for i, service := range cfg.ProtectedServices {
-		if !service.Enabled {
-			log.Info().
-				Str("service", service.ServiceName).
-				Msg("Service disabled, skipping")
-			continue
+	m.mu.Lock()
+	m.failures = nil
+	m.probes = nil
+	m.mu.Unlock()
+
+	// One bucket per client IP across all proxies, so churn spread over services still counts
+	m.connRate = nil
+	if rl := cfg.ProxyServerConfig.ConnectionRateLimit; rl.MaxNewConnections > 0 {
+		window := time.Duration(rl.WindowSeconds) * time.Second
+		if window <= 0 {
+			window = 10 * time.Second
 		}
+		m.connRate = auth.NewWindowRateLimiter(rl.MaxNewConnections, window, 10000)
+		m.connRate.StartCleanup(time.Minute, m.stopStatsTicker)
+	}
 
-		// Validate service configuration
-		if err := m.validateService(&service); err != nil {
+	for i := range cfg.ProtectedServices {
+		m.startService(cfg, i)
+	}
+
+	// Dispatch kernel-redirected traffic to the TCP services by original destination port
+	if cfg.TransparentProxyConfig.Enabled {
+		transparent := NewTransparentProxy(&cfg.TransparentProxyConfig, m.tcpProxyForPort)
+		if err := transparent.Start(); err != nil {
 			log.Error().
 				Err(err).
-				Str("service", service.ServiceName).
-				Msg("Invalid service configuration, skipping")
-			continue
+				Msg("Failed to start transparent proxy")
+		} else {
+			m.mu.Lock()
+			m.proxies["transparent"] = transparent
+			m.mu.Unlock()
 		}
+	}
 
-		var proxy Proxy
-		var err error
+	m.mu.RLock()
+	activeCount := len(m.proxies)
+	failedCount := len(m.failures)
+	m.mu.RUnlock()
 
-		// Get connection limit from config
-		maxConnections := cfg.ProxyServerConfig.MaxConnectionsPerService
+	log.Info().
+		Int("active_proxies", activeCount).
+		Msg("Proxy manager started")
+	if failedCount > 0 {
+		log.Warn().
+			Int("failed_proxies", failedCount).
+			Msg("Some proxies failed to start; /api/health reports degraded")
+	}
 
-		// Create appropriate proxy type
-		if service.IsHTTPProtocol {
-			proxy, err = NewHTTPProxy(&cfg.ProtectedServices[i], m.allowlistManager, m.blocklistManager)
-			if err != nil {
-				log.Error().
-					Err(err).
-					Str("service", service.ServiceName).
-					Msg("Failed to create HTTP proxy")
-				continue
-			}
-		} else if service.TransportProtocol == "tcp" {
-			proxy = NewTCPProxy(&cfg.ProtectedServices[i], m.allowlistManager, m.blocklistManager, maxConnections)
-		} else if service.TransportProtocol == "udp" {
-			// Get UDP session timeout from config
-			sessionTimeout := time.Duration(cfg.ProxyServerConfig.UDPSessionTimeoutSeconds) * time.Second
-			proxy = NewUDPProxy(&cfg.ProtectedServices[i], m.allowlistManager, m.blocklistManager, sessionTimeout, maxConnections)
-		} else if service.TransportProtocol == "both" {
-			// Create both TCP and UDP proxies for the same service
-			sessionTimeout := time.Duration(cfg.ProxyServerConfig.UDPSessionTimeoutSeconds) * time.Second
-
-			// Start TCP proxy
-			tcpProxy := NewTCPProxy(&cfg.ProtectedServices[i], m.allowlistManager, m.blocklistManager, maxConnections)
-			if err := tcpProxy.Start(); err != nil {
-				log.Error().
-					Err(err).
-					Str("service", service.ServiceName).
-					Str("protocol", "tcp").
-					Msg("Failed to start TCP proxy")
-			} else {
-				m.mu.Lock()
-				m.proxies[service.ServiceID+"-tcp"] = tcpProxy
-				m.mu.Unlock()
-				log.Info().
-					Str("service", service.ServiceName).
-					Str("service_id", service.ServiceID).
-					Int("listen_port", service.ProxyListenPortStart).
-					Str("protocol", "tcp").
-					Msg("TCP proxy started successfully")
-			}
+	// Start periodic stats logging
+	go m.statsLogger()
 
-			// Start UDP proxy
-			udpProxy := NewUDPProxy(&cfg.ProtectedServices[i], m.allowlistManager, m.blocklistManager, sessionTimeout, maxConnections)
-			if err := udpProxy.Start(); err != nil {
-				log.Error().
-					Err(err).
-					Str("service", service.ServiceName).
-					Str("protocol", "udp").
-					Msg("Failed to start UDP proxy")
-			} else {
-				m.mu.Lock()
-				m.proxies[service.ServiceID+"-udp"] = udpProxy
-				m.mu.Unlock()
-				log.Info().
-					Str("service", service.ServiceName).
-					Str("service_id", service.ServiceID).
-					Int("listen_port", service.ProxyListenPortStart).
-					Str("protocol", "udp").
-					Msg("UDP proxy started successfully")
-			}
+	// Follow backend hostnames whose addresses change, e.g. recreated Docker containers
+	dnsRefresh := time.Duration(cfg.ProxyServerConfig.BackendDNSRefreshSeconds) * time.Second
+	if dnsRefresh <= 0 {
+		dnsRefresh = 30 * time.Second
+	}
+	go m.backendResolveLoop(m.stopStatsTicker, dnsRefresh)
 
-			continue // Skip the normal start logic below
+	// Probe backends so health reports dead backends before clients hit them
+	probeInterval := time.Duration(cfg.ProxyServerConfig.BackendProbeIntervalSeconds) * time.Second
+	if probeInterval <= 0 {
+		probeInterval = 30 * time.Second
+	}
+	go m.backendProbeLoop(m.stopStatsTicker, probeInterval)
+
+	// Track idle services for idle hooks and on-demand containers
+	go m.activityLoop(m.stopStatsTicker)
+
+	// Close established flows of clients whose access ended
+	if grace := m.configLoader.GetConfig().SessionConfig.ExpiryGracePeriodSeconds; grace != nil {
+		go m.revalidateLoop(m.stopStatsTicker, time.Duration(*grace)*time.Second)
+	}
+
+	return nil
+}
+
+// startService creates and starts the proxies of one configured service
+func (m *Manager) startService(cfg *config.ApplicationConfig, i int) {
+	service := cfg.ProtectedServices[i]
+
+	if !m.serviceEnabled(service) {
+		log.Info().
+			Str("service", service.ServiceName).
+			Msg("Service disabled, skipping")
+		return
+	}
+
+	// Firewall-mode services are enforced in the kernel, not proxied
+	if service.EnforcementMode == "firewall" {
+		log.Info().
+			Str("service", service.ServiceName).
+			Msg("Service uses firewall enforcement, skipping proxy")
+		return
+	}
+
+	// Validate service configuration
+	if err := m.validateService(&service); err != nil {
+		log.Error().
+			Err(err).
+			Str("service", service.ServiceName).
+			Msg("Invalid service configuration, skipping")
+		m.recordFailure(&service, serviceProtocol(&service), err)
+		return
+	}
+
+	m.startListener(cfg, &cfg.ProtectedServices[i], service.ServiceID)
+
+	// Additional listeners run the same service on further addresses or ports, each with its own proxies
+	for _, listener := range service.AdditionalListeners {
+		ls := service.ForListener(listener)
+		m.startListener(cfg, &ls, service.ServiceID+"@"+ls.Listener)
+	}
+}
+
+// startListener creates and starts the proxies of one listener of a service under the given proxy key
+func (m *Manager) startListener(cfg *config.ApplicationConfig, service *config.ProtectedServiceConfig, key string) {
+	var proxy Proxy
+	var err error
+
+	// Get connection limit from config
+	maxConnections := cfg.ProxyServerConfig.MaxConnectionsPerService
+
+	// Create appropriate proxy type
+	if service.IsHTTPProtocol {
+		proxy, err = NewHTTPProxy(service, m.allowlistManager, m.blocklistManager)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("service", service.ServiceName).
+				Msg("Failed to create HTTP proxy")
+			m.recordFailure(service, "http", err)
+			return
+		}
+	} else if service.TransportProtocol == "socks5" {
+		proxy = NewSOCKS5Proxy(service, m.allowlistManager, m.blocklistManager, maxConnections)
+	} else if service.TransportProtocol == "tcp" {
+		proxy = NewTCPProxy(service, m.allowlistManager, m.blocklistManager, maxConnections)
+	} else if service.TransportProtocol == "udp" {
+		// Get UDP session timeout from config
+		sessionTimeout := time.Duration(cfg.ProxyServerConfig.UDPSessionTimeoutSeconds) * time.Second
+		proxy = NewUDPProxy(service, m.allowlistManager, m.blocklistManager, sessionTimeout, maxConnections)
+	} else if service.TransportProtocol == "both" {
+		// Create both TCP and UDP proxies for the same service
+		sessionTimeout := time.Duration(cfg.ProxyServerConfig.UDPSessionTimeoutSeconds) * time.Second
+
+		// Start TCP proxy
+		tcpProxy := NewTCPProxy(service, m.allowlistManager, m.blocklistManager, maxConnections)
+		m.attach(tcpProxy)
+		if err := tcpProxy.Start(); err != nil {
+			log.Error().
+				Err(err).
+				Str("service", service.ServiceName).
+				Str("protocol", "tcp").
+				Msg("Failed to start TCP proxy")
+			m.recordFailure(service, "tcp", err)
 		} else {
-			log.Warn().
+			m.mu.Lock()
+			m.proxies[key+"-tcp"] = tcpProxy
+			m.mu.Unlock()
+			log.Info().
 				Str("service", service.ServiceName).
-				Str("protocol", service.TransportProtocol).
-				Msg("Unknown protocol, skipping")
-			continue
+				Str("service_id", service.ServiceID).
+				Str("listen_address", service.ProxyListenAddress).
+				Int("listen_port", service.ProxyListenPortStart).
+				Str("protocol", "tcp").
+				Msg("TCP proxy started successfully")
 		}
 
-		// Start the proxy
-		if err := proxy.Start(); err != nil {
+		// Start UDP proxy
+		udpProxy := NewUDPProxy(service, m.allowlistManager, m.blocklistManager, sessionTimeout, maxConnections)
+		m.attach(udpProxy)
+		if err := udpProxy.Start(); err != nil {
 			log.Error().
 				Err(err).
 				Str("service", service.ServiceName).
-				Msg("Failed to start proxy")
-			continue
+				Str("protocol", "udp").
+				Msg("Failed to start UDP proxy")
+			m.recordFailure(service, "udp", err)
+		} else {
+			m.mu.Lock()
+			m.proxies[key+"-udp"] = udpProxy
+			m.mu.Unlock()
+			log.Info().
+				Str("service", service.ServiceName).
+				Str("service_id", service.ServiceID).
+				Str("listen_address", service.ProxyListenAddress).
+				Int("listen_port", service.ProxyListenPortStart).
+				Str("protocol", "udp").
+				Msg("UDP proxy started successfully")
 		}
 
-		m.mu.Lock()
-		m.proxies[service.ServiceID] = proxy
-		m.mu.Unlock()
-
-		log.Info().
+		return // Skip the normal start logic below
+	} else {
+		log.Warn().
 			Str("service", service.ServiceName).
-			Str("service_id", service.ServiceID).
-			Int("listen_port", service.ProxyListenPortStart).
 			Str("protocol", service.TransportProtocol).
-			Bool("is_http", service.IsHTTPProtocol).
-			Msg("Proxy started successfully")
+			Msg("Unknown protocol, skipping")
+		return
 	}
 
-	m.mu.RLock()
-	activeCount := len(m.proxies)
-	m.mu.RUnlock()
+	m.attach(proxy)
+
+	// Start the proxy
+	if err := proxy.Start(); err != nil {
+		log.Error().
+			Err(err).
+			Str("service", service.ServiceName).
+			Msg("Failed to start proxy")
+		m.recordFailure(service, serviceProtocol(service), err)
+		return
+	}
+
+	m.mu.Lock()
+	m.proxies[key] = proxy
+	m.mu.Unlock()
 
 	log.Info().
-		Int("active_proxies", activeCount).
-		Msg("Proxy manager started")
+		Str("service", service.ServiceName).
+		Str("service_id", service.ServiceID).
+		Str("listen_address", service.ProxyListenAddress).
+		Int("listen_port", service.ProxyListenPortStart).
+		Str("protocol", service.TransportProtocol).
+		Bool("is_http", service.IsHTTPProtocol).
+		Msg("Proxy started successfully")
+}
 
-	// Start periodic stats logging
-	go m.statsLogger()
+// attach lets a proxy record its traffic history and latencies, resolve portal sessions and enforce the per-user connection limit
+func (m *Manager) attach(proxy Proxy) {
+	proxyCfg := m.configLoader.GetConfig().ProxyServerConfig
+	globalPerUser := proxyCfg.MaxConnectionsPerUser
+	perUser := func(service *config.ProtectedServiceConfig) int {
+		if service.MaxConnectionsPerUser > 0 {
+			return service.MaxConnectionsPerUser
+		}
+		return globalPerUser
+	}
 
+	switch p := proxy.(type) {
+	case *TCPProxy:
+		p.history = m.history
+		p.identity = m.identity
+		p.access = m.access
+		p.maxConnsPerUser = perUser(p.service)
+		p.connRate = m.connRate
+		p.latency = m.latencyFor(p.service.ServiceID+"|tcp", "tcp")
+		p.bufferSize = bufferSize(p.service.TCPBufferSizeBytes, proxyCfg.TCPBufferSizeBytes, defaultTCPBufferSize)
+		if p.backend != nil { // SOCKS5 proxies have no fixed backend
+			p.backend.container = m.containerFor(p.service)
+		}
+	case *UDPProxy:
+		p.history = m.history
+		p.identity = m.identity
+		p.access = m.access
+		p.maxUserSessions = perUser(p.service)
+		p.connRate = m.connRate
+		p.latency = m.latencyFor(p.service.ServiceID+"|udp", "udp")
+		p.bufferSize = bufferSize(p.service.UDPBufferSizeBytes, proxyCfg.UDPBufferSizeBytes, defaultUDPBufferSize)
+		if limit := p.datagramLimit; limit != nil && limit.maxSize >= p.bufferSize {
+			p.bufferSize = limit.maxSize + 1 // Leaves room to tell oversized datagrams apart
+		}
+		p.backend.container = m.containerFor(p.service)
+	case *HTTPProxy:
+		sessionCfg := m.configLoader.GetConfig().SessionConfig
+		p.history = m.history
+		p.identity = m.identity
+		p.access = m.access
+		p.expiryWarning = time.Duration(sessionCfg.ExpiryWarningSeconds) * time.Second
+		p.expiryBanner = sessionCfg.ExpiryWarningBanner
+		p.limiter = httplimit.NewLimiter(&proxyCfg.HTTPLimits)
+		p.connRate = m.connRate
+		p.latency = m.latencyFor(p.service.ServiceID+"|http", "http")
+		p.backend.container = m.containerFor(p.service)
+		if hc := p.service.HTTPConfig; hc != nil && hc.AccessLogPath != "" {
+			accessLog, err := m.accessLogFile(hc.AccessLogPath)
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("service", p.service.ServiceName).
+					Str("path", hc.AccessLogPath).
+					Msg("Failed to open access log, requests are not logged")
+			} else {
+				p.accessLog = accessLog
+			}
+		}
+	}
+}
+
+// tcpProxyForPort returns the running TCP proxy whose listen port range contains port
+func (m *Manager) tcpProxyForPort(port int) *TCPProxy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, proxy := range m.proxies {
+		tcpProxy, ok := proxy.(*TCPProxy)
+		if !ok {
+			continue
+		}
+		end := tcpProxy.service.ProxyListenPortEnd
+		if end < tcpProxy.service.ProxyListenPortStart {
+			end = tcpProxy.service.ProxyListenPortStart
+		}
+		if port >= tcpProxy.service.ProxyListenPortStart && port <= end {
+			return tcpProxy
+		}
+	}
 	return nil
 }
 
@@ -261,11 +496,75 @@ func (m *Manager) Stop() error {
 	m.proxies = make(map[string]Proxy)
 	m.mu.Unlock()
 
+	m.accessLogsMu.Lock()
+	for path, file := range m.accessLogs {
+		file.Close()
+		delete(m.accessLogs, path)
+	}
+	m.accessLogsMu.Unlock()
+
 	log.Info().Msg("Proxy manager stopped")
 
 	return nil
 }
 
+// drainer is implemented by proxies that can finish active connections before stopping
+type drainer interface {
+	Drain(timeout time.Duration) error
+}
+
+// Drain stops accepting new connections on all proxies and lets active ones finish
+// Used after a binary upgrade handed the listeners to the new process
+func (m *Manager) Drain(timeout time.Duration) error {
+	log.Info().
+		Dur("timeout", timeout).
+		Msg("Draining proxy manager")
+
+	select {
+	case <-m.stopStatsTicker:
+	default:
+		close(m.stopStatsTicker)
+	}
+
+	m.mu.Lock()
+	proxies := make(map[string]Proxy)
+	for k, v := range m.proxies {
+		proxies[k] = v
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for serviceID, proxy := range proxies {
+		wg.Add(1)
+		go func(sid string, p Proxy) {
+			defer wg.Done()
+
+			var err error
+			if d, ok := p.(drainer); ok {
+				err = d.Drain(timeout)
+			} else {
+				err = p.Stop()
+			}
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("service_id", sid).
+					Msg("Error draining proxy")
+			}
+		}(serviceID, proxy)
+	}
+
+	wg.Wait()
+
+	m.mu.Lock()
+	m.proxies = make(map[string]Proxy)
+	m.mu.Unlock()
+
+	log.Info().Msg("Proxy manager drained")
+
+	return nil
+}
+
 // TerminateSessionsByIP closes all active TCP/UDP sessions for a specific IP
 // This is called when a session is terminated and IPs need to be instantly disconnected
 func (m *Manager) TerminateSessionsByIP(clientIP string) int {
@@ -356,8 +655,51 @@ func (m *Manager) GetStatsByIP(clientIP string) map[string]interface{} {
 	return aggregated
 }
 
+// GetConnectionsByIP lists the individual connections and sessions of a client IP across all proxies
+func (m *Manager) GetConnectionsByIP(clientIP string) []ConnectionInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	connections := []ConnectionInfo{}
+	for _, proxy := range m.proxies {
+		if udpProxy, ok := proxy.(*UDPProxy); ok {
+			connections = append(connections, udpProxy.ListConnectionsByIP(clientIP)...)
+		} else if tcpProxy, ok := proxy.(*TCPProxy); ok {
+			connections = append(connections, tcpProxy.ListConnectionsByIP(clientIP)...)
+		}
+	}
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].StartedAt.Before(connections[j].StartedAt)
+	})
+	return connections
+}
+
+// TerminateConnection closes a single connection or session by ID
+// Returns false if no proxy has a connection with that ID
+func (m *Manager) TerminateConnection(connID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, proxy := range m.proxies {
+		if udpProxy, ok := proxy.(*UDPProxy); ok {
+			if udpProxy.TerminateConnection(connID) {
+				return true
+			}
+		} else if tcpProxy, ok := proxy.(*TCPProxy); ok {
+			if tcpProxy.TerminateConnection(connID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Reload stops all existing proxies and restarts them with new config
 func (m *Manager) Reload() error {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+
 	log.Info().Msg("Reloading proxy manager with new configuration")
 
 	// Stop all existing proxies
@@ -403,6 +745,15 @@ func (m *Manager) validateService(service *config.ProtectedServiceConfig) error
 		return fmt.Errorf("invalid proxy_listen_port_start: %d", service.ProxyListenPortStart)
 	}
 
+	if service.TransportProtocol != "tcp" && service.TransportProtocol != "udp" && service.TransportProtocol != "both" && service.TransportProtocol != "socks5" {
+		return fmt.Errorf("invalid transport_protocol: %s (must be tcp, udp, both, or socks5)", service.TransportProtocol)
+	}
+
+	// SOCKS5 services may only list allowed destinations instead of a fixed backend
+	if service.TransportProtocol == "socks5" && len(service.SOCKS5AllowedDestinations) > 0 {
+		return nil
+	}
+
 	if service.BackendTargetPort < 1 || service.BackendTargetPort > 65535 {
 		return fmt.Errorf("invalid backend_target_port: %d", service.BackendTargetPort)
 	}
@@ -411,10 +762,6 @@ func (m *Manager) validateService(service *config.ProtectedServiceConfig) error
 		return fmt.Errorf("backend_target_host is required")
 	}
 
-	if service.TransportProtocol != "tcp" && service.TransportProtocol != "udp" && service.TransportProtocol != "both" {
-		return fmt.Errorf("invalid transport_protocol: %s (must be tcp, udp, or both)", service.TransportProtocol)
-	}
-
 	return nil
 }
 
@@ -440,3 +787,320 @@ func (m *Manager) TerminateConnectionsByIP(clientIP string) error {
 
 	return nil
 }
+
+// TerminateServiceConnections closes every TCP connection and UDP session of one service
+// Returns the number closed and whether the service has a running proxy
+func (m *Manager) TerminateServiceConnections(serviceID string) (int, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found := false
+	totalTerminated := 0
+	for _, proxy := range m.proxies {
+		var (
+			service   *config.ProtectedServiceConfig
+			clientIPs []string
+		)
+		switch p := proxy.(type) {
+		case *TCPProxy:
+			service, clientIPs = p.service, p.activeClientIPs()
+		case *UDPProxy:
+			service, clientIPs = p.service, p.activeClientIPs()
+		case *HTTPProxy:
+			// Requests are short-lived; there is nothing to close
+			found = found || p.service.ServiceID == serviceID
+			continue
+		default:
+			continue
+		}
+		if service.ServiceID != serviceID {
+			continue
+		}
+
+		found = true
+		for _, clientIP := range clientIPs {
+			totalTerminated += proxy.TerminateConnectionsByIP(clientIP)
+		}
+	}
+
+	if found {
+		log.Info().
+			Str("service_id", serviceID).
+			Int("total_terminated", totalTerminated).
+			Msg("Terminated all connections of service")
+	}
+	return totalTerminated, found
+}
+
+// TerminateAnonymousConnections closes connections of clients not covered by a portal session
+// (permanently allowed ranges, dynamic DNS hosts, Tailscale, ...)
+func (m *Manager) TerminateAnonymousConnections() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	totalTerminated := 0
+	for _, proxy := range m.proxies {
+		var clientIPs []string
+		switch p := proxy.(type) {
+		case *TCPProxy:
+			clientIPs = p.activeClientIPs()
+		case *UDPProxy:
+			clientIPs = p.activeClientIPs()
+		default:
+			continue
+		}
+
+		for _, clientIP := range clientIPs {
+			ip, err := netip.ParseAddr(clientIP)
+			if err != nil {
+				continue
+			}
+			if m.identity != nil {
+				if _, ok := m.identity(ip.Unmap()); ok {
+					continue
+				}
+			}
+			totalTerminated += proxy.TerminateConnectionsByIP(clientIP)
+		}
+	}
+
+	log.Info().
+		Int("total_terminated", totalTerminated).
+		Msg("Terminated anonymous connections across all proxies")
+
+	return totalTerminated
+}
+
+// GetServiceStats returns the statistics of the running proxies of one service
+// A service with both TCP and UDP has one entry per protocol; nil if the service has no running proxy
+func (m *Manager) GetServiceStats(serviceID string) []map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stats []map[string]interface{}
+	for _, proxy := range m.proxies {
+		if service := proxyService(proxy); service != nil && service.ServiceID == serviceID {
+			stats = append(stats, proxy.GetStats())
+		}
+	}
+	return stats
+}
+
+// ResetCircuitBreaker closes the circuit breakers of a service so its backend is dialed again right away
+// Returns false if the service has no running proxy with a circuit breaker
+func (m *Manager) ResetCircuitBreaker(serviceID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found := false
+	for _, proxy := range m.proxies {
+		var breaker *CircuitBreaker
+		switch p := proxy.(type) {
+		case *TCPProxy:
+			if p.service.ServiceID == serviceID {
+				breaker = p.circuitBreaker
+			}
+		case *UDPProxy:
+			if p.service.ServiceID == serviceID {
+				breaker = p.circuitBreaker
+			}
+		case *HTTPProxy:
+			if p.service.ServiceID == serviceID {
+				breaker = p.circuitBreaker
+			}
+		}
+		if breaker != nil {
+			breaker.Reset()
+			found = true
+		}
+	}
+	return found
+}
+
+// proxyService returns the service a proxy serves, or nil for proxies not bound to one service
+func proxyService(proxy Proxy) *config.ProtectedServiceConfig {
+	switch p := proxy.(type) {
+	case *TCPProxy:
+		return p.service
+	case *UDPProxy:
+		return p.service
+	case *HTTPProxy:
+		return p.service
+	default:
+		return nil
+	}
+}
+
+// ErrServiceNotFound is returned for service IDs that are not configured
+var ErrServiceNotFound = errors.New("service not found")
+
+// ErrServiceNotProxied is returned for services that have no proxy to start or stop (firewall enforcement)
+var ErrServiceNotProxied = errors.New("service is not proxied")
+
+// serviceEnabled reports whether a service should run, honoring runtime overrides
+func (m *Manager) serviceEnabled(service config.ProtectedServiceConfig) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if enabled, ok := m.overrides[service.ServiceID]; ok {
+		return enabled
+	}
+	return service.Enabled
+}
+
+// SetServiceEnabled starts or stops the proxies of one service without touching the others
+// The override lasts until ClearServiceOverride and survives config reloads
+func (m *Manager) SetServiceEnabled(serviceID string, enabled bool) error {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+
+	cfg := m.configLoader.GetConfig()
+	index := -1
+	for i, service := range cfg.ProtectedServices {
+		if service.ServiceID == serviceID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return ErrServiceNotFound
+	}
+	if cfg.ProtectedServices[index].EnforcementMode == "firewall" {
+		return ErrServiceNotProxied
+	}
+
+	m.mu.Lock()
+	m.overrides[serviceID] = enabled
+	m.mu.Unlock()
+
+	if !enabled {
+		m.stopService(serviceID)
+		return nil
+	}
+
+	if len(m.serviceProxyKeys(serviceID)) > 0 {
+		return nil
+	}
+	m.mu.Lock()
+	m.clearFailuresLocked(serviceID)
+	m.mu.Unlock()
+	m.startService(cfg, index)
+	if len(m.serviceProxyKeys(serviceID)) == 0 {
+		return fmt.Errorf("service %s failed to start", serviceID)
+	}
+	return nil
+}
+
+// ServiceOverride returns the runtime enabled state of a service, if one is set
+func (m *Manager) ServiceOverride(serviceID string) (enabled bool, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	enabled, ok = m.overrides[serviceID]
+	return enabled, ok
+}
+
+// ClearServiceOverride makes a service follow its configured Enabled flag again from the next reload
+func (m *Manager) ClearServiceOverride(serviceID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.overrides, serviceID)
+}
+
+// stopService stops and removes the running proxies of one service
+func (m *Manager) stopService(serviceID string) {
+	m.mu.Lock()
+	proxies := make(map[string]Proxy)
+	for _, key := range m.serviceProxyKeysLocked(serviceID) {
+		proxies[key] = m.proxies[key]
+		delete(m.proxies, key)
+	}
+	m.clearFailuresLocked(serviceID)
+	m.mu.Unlock()
+
+	for key, proxy := range proxies {
+		if err := proxy.Stop(); err != nil {
+			log.Error().
+				Err(err).
+				Str("service_id", key).
+				Msg("Error stopping proxy")
+		}
+	}
+
+	if len(proxies) > 0 {
+		log.Info().
+			Str("service_id", serviceID).
+			Msg("Service stopped at runtime")
+	}
+}
+
+// serviceProxyKeys returns the keys of the running proxies of one service
+func (m *Manager) serviceProxyKeys(serviceID string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.serviceProxyKeysLocked(serviceID)
+}
+
+// serviceProxyKeysLocked is serviceProxyKeys for callers holding mu
+func (m *Manager) serviceProxyKeysLocked(serviceID string) []string {
+	var keys []string
+	for key, proxy := range m.proxies {
+		if service := proxyService(proxy); service != nil && service.ServiceID == serviceID {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// serviceProtocol names the proxy type a service runs, for failure reports
+func serviceProtocol(service *config.ProtectedServiceConfig) string {
+	if service.IsHTTPProtocol {
+		return "http"
+	}
+	return service.TransportProtocol
+}
+
+// recordFailure remembers a proxy that could not be started
+func (m *Manager) recordFailure(service *config.ProtectedServiceConfig, protocol string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures = append(m.failures, ProxyFailure{
+		ServiceID:     service.ServiceID,
+		ServiceName:   service.ServiceName,
+		Protocol:      protocol,
+		ListenAddress: service.Listener,
+		ListenPort:    service.ProxyListenPortStart,
+		Error:         err.Error(),
+		FailedAt:      time.Now(),
+	})
+}
+
+// clearFailuresLocked forgets the failures of one service; must be called with mu held
+func (m *Manager) clearFailuresLocked(serviceID string) {
+	kept := m.failures[:0]
+	for _, failure := range m.failures {
+		if failure.ServiceID != serviceID {
+			kept = append(kept, failure)
+		}
+	}
+	m.failures = kept
+}
+
+// Failures returns the proxies that failed to start since the last (re)start
+func (m *Manager) Failures() []ProxyFailure {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]ProxyFailure{}, m.failures...)
+}
+
+// ActiveProxyCount returns the number of running proxies
+func (m *Manager) ActiveProxyCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.proxies)
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+)
+
+// metricFamily describes one histogram in the Prometheus exposition
+type metricFamily struct {
+	name string
+	help string
+	pick func(l *latencyStats) *stats.Histogram
+}
+
+var metricFamilies = []metricFamily{
+	{
+		name: "knock_proxy_connection_duration_seconds",
+		help: "Lifetime of proxied TCP connections and active lifetime of UDP sessions.",
+		pick: func(l *latencyStats) *stats.Histogram { return l.duration },
+	},
+	{
+		name: "knock_proxy_backend_dial_seconds",
+		help: "Time to establish a connection to the backend.",
+		pick: func(l *latencyStats) *stats.Histogram { return l.dial },
+	},
+	{
+		name: "knock_proxy_upstream_response_seconds",
+		help: "Time until the HTTP backend sent the response headers.",
+		pick: func(l *latencyStats) *stats.Histogram { return l.upstream },
+	},
+}
+
+// metricSeries is the histograms of one running proxy with its labels
+type metricSeries struct {
+	labels  string
+	latency *latencyStats
+}
+
+// WriteMetrics writes the latency histograms of all running proxies in the Prometheus text format
+func (m *Manager) WriteMetrics(w io.Writer) error {
+	series := m.metricSeries()
+
+	for _, family := range metricFamilies {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", family.name, family.help, family.name)
+		for _, s := range series {
+			h := family.pick(s.latency)
+			if h == nil {
+				continue
+			}
+			snap := h.Snapshot()
+			for i, bound := range snap.Bounds {
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", family.name, s.labels, strconv.FormatFloat(bound, 'g', -1, 64), snap.Cumulative[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", family.name, s.labels, snap.Count)
+			fmt.Fprintf(w, "%s_sum{%s} %s\n", family.name, s.labels, strconv.FormatFloat(snap.Sum, 'g', -1, 64))
+			if _, err := fmt.Fprintf(w, "%s_count{%s} %d\n", family.name, s.labels, snap.Count); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// metricSeries returns the running proxies that record latencies, sorted by proxy key
+func (m *Manager) metricSeries() []metricSeries {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.proxies))
+	for key := range m.proxies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := make([]metricSeries, 0, len(keys))
+	seen := make(map[*latencyStats]bool) // The listeners of a service share their histograms
+	for _, key := range keys {
+		var (
+			latency  *latencyStats
+			protocol string
+		)
+		switch p := m.proxies[key].(type) {
+		case *TCPProxy:
+			latency, protocol = p.latency, "tcp"
+		case *UDPProxy:
+			latency, protocol = p.latency, "udp"
+		case *HTTPProxy:
+			latency, protocol = p.latency, "http"
+		}
+		if latency == nil || seen[latency] {
+			continue
+		}
+		seen[latency] = true
+
+		service := proxyService(m.proxies[key])
+		series = append(series, metricSeries{
+			labels: fmt.Sprintf(`service_id="%s",service_name="%s",protocol="%s"`,
+				escapeLabel(service.ServiceID), escapeLabel(service.ServiceName), protocol),
+			latency: latency,
+		})
+	}
+	return series
+}
+
+// labelEscaper escapes label values as required by the Prometheus text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
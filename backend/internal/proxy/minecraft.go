@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"time"
+	"unicode/utf16"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// Minecraft Java Edition protocol, just enough to explain a denial
+// https://minecraft.wiki/w/Java_Edition_protocol
+const (
+	minecraftHandshakeTimeout = 5 * time.Second
+	minecraftMaxPacket        = 32 * 1024 // Handshake, status and login start are tiny
+	minecraftStateStatus      = 1
+	minecraftLegacyPing       = 0xFE // First byte of the pre-1.7 server list ping
+	defaultMinecraftMessage   = "Log in to the portal to join this server"
+)
+
+var errMinecraftPacket = errors.New("malformed minecraft packet")
+
+// minecraftDenyMessage returns the text shown to a denied player
+func minecraftDenyMessage(deny *config.DenyResponseConfig, clientIP, serviceName string) string {
+	text := defaultMinecraftMessage
+	if deny != nil && deny.MOTD != "" {
+		text = deny.MOTD
+	} else if deny != nil && deny.TCPBanner != "" {
+		text = deny.TCPBanner
+	}
+	return renderDenyText(text, clientIP, serviceName)
+}
+
+// rejectMinecraft answers a denied client instead of silently closing: the server list shows message as
+// the MOTD and answers pings, a join attempt is refused with message as the disconnect reason
+func rejectMinecraft(conn net.Conn, message string) error {
+	conn.SetDeadline(time.Now().Add(minecraftHandshakeTimeout))
+	r := bufio.NewReader(conn)
+
+	first, err := r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if first[0] == minecraftLegacyPing {
+		return writeMinecraftLegacyStatus(conn, message)
+	}
+
+	// Handshake: protocol version, server address, port, next state
+	packetID, data, err := readMinecraftPacket(r)
+	if err != nil {
+		return err
+	}
+	if packetID != 0x00 {
+		return errMinecraftPacket
+	}
+	payload := bytes.NewReader(data)
+	if _, err := readVarInt(payload); err != nil {
+		return err
+	}
+	if _, err := readMinecraftString(payload); err != nil {
+		return err
+	}
+	var port uint16
+	if err := binary.Read(payload, binary.BigEndian, &port); err != nil {
+		return err
+	}
+	nextState, err := readVarInt(payload)
+	if err != nil {
+		return err
+	}
+
+	text, _ := json.Marshal(map[string]string{"text": message})
+	if nextState != minecraftStateStatus {
+		// Login (or transfer): Disconnect carries a JSON text component in the login state
+		return writeMinecraftPacket(conn, 0x00, minecraftString(string(text)))
+	}
+
+	// Status request, then an optional ping that must be echoed
+	if packetID, _, err := readMinecraftPacket(r); err != nil || packetID != 0x00 {
+		return errMinecraftPacket
+	}
+	status, _ := json.Marshal(map[string]interface{}{
+		// A protocol the client doesn't speak makes the list show the version name instead of ping bars
+		"version":     map[string]interface{}{"name": "Access denied", "protocol": -1},
+		"players":     map[string]int{"max": 0, "online": 0},
+		"description": json.RawMessage(text),
+	})
+	if err := writeMinecraftPacket(conn, 0x00, minecraftString(string(status))); err != nil {
+		return err
+	}
+
+	packetID, data, err = readMinecraftPacket(r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil // Client only wanted the status
+		}
+		return err
+	}
+	if packetID != 0x01 || len(data) != 8 {
+		return errMinecraftPacket
+	}
+	return writeMinecraftPacket(conn, 0x01, data)
+}
+
+// writeMinecraftLegacyStatus answers the pre-1.7 ping with a kick packet carrying the status fields
+func writeMinecraftLegacyStatus(conn net.Conn, message string) error {
+	fields := "§1\x00127\x00Access denied\x00" + message + "\x000\x000"
+	chars := utf16.Encode([]rune(fields))
+
+	var buf bytes.Buffer
+	buf.WriteByte(0xFF)
+	binary.Write(&buf, binary.BigEndian, uint16(len(chars)))
+	binary.Write(&buf, binary.BigEndian, chars)
+	_, err := conn.Write(buf.Bytes())
+	return err
+}
+
+// readMinecraftPacket reads one uncompressed packet and returns its ID and payload
+func readMinecraftPacket(r *bufio.Reader) (int32, []byte, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length < 1 || length > minecraftMaxPacket {
+		return 0, nil, errMinecraftPacket
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+
+	body := bytes.NewReader(packet)
+	packetID, err := readVarInt(body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return packetID, packet[len(packet)-body.Len():], nil
+}
+
+// writeMinecraftPacket writes one uncompressed packet
+func writeMinecraftPacket(w io.Writer, packetID int32, payload []byte) error {
+	body := append(appendVarInt(nil, packetID), payload...)
+	_, err := w.Write(append(appendVarInt(nil, int32(len(body))), body...))
+	return err
+}
+
+// readVarInt reads a protocol VarInt (little-endian groups of 7 bits, at most 5 bytes)
+func readVarInt(r io.ByteReader) (int32, error) {
+	var value uint32
+	for i := 0; i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return int32(value), nil
+		}
+	}
+	return 0, errMinecraftPacket
+}
+
+// appendVarInt appends a protocol VarInt
+func appendVarInt(buf []byte, value int32) []byte {
+	v := uint32(value)
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+// readMinecraftString reads a VarInt-prefixed UTF-8 string
+func readMinecraftString(r *bytes.Reader) (string, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || int(length) > r.Len() {
+		return "", errMinecraftPacket
+	}
+	s := make([]byte, length)
+	r.Read(s)
+	return string(s), nil
+}
+
+// minecraftString encodes a VarInt-prefixed UTF-8 string
+func minecraftString(s string) []byte {
+	return append(appendVarInt(nil, int32(len(s))), s...)
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const (
+	defaultPublicQueryPerMinute = 60
+	publicQueryLimiterEntries   = 10000
+)
+
+// publicQuery lets server-list queries through without an allowlist entry, so browsers still see the
+// server online while gameplay stays gated; each client IP gets a small packet budget
+type publicQuery struct {
+	prefixes packetPrefixes
+	limiter  *auth.RateLimiter
+
+	passed  atomic.Int64
+	limited atomic.Int64
+}
+
+// newPublicQuery builds the public query gate of a service; nil when public_query is not configured
+func newPublicQuery(service *config.ProtectedServiceConfig) *publicQuery {
+	pq := service.PublicQuery
+	if pq == nil {
+		return nil
+	}
+
+	perMinute := pq.MaxPacketsPerMinute
+	if perMinute <= 0 {
+		perMinute = defaultPublicQueryPerMinute
+	}
+	return &publicQuery{
+		prefixes: parsePacketPrefixes(pq.MatchHexPrefixes),
+		limiter:  auth.NewWindowRateLimiter(perMinute, time.Minute, publicQueryLimiterEntries),
+	}
+}
+
+// admit reports whether a packet from a client outside the allowlist may pass as a query
+func (q *publicQuery) admit(clientIP string, packet []byte) bool {
+	if q == nil || !q.prefixes.match(packet) {
+		return false
+	}
+	if !q.limiter.Allow(clientIP) {
+		q.limited.Add(1)
+		return false
+	}
+	q.passed.Add(1)
+	return true
+}
+
+// stats returns the public query counters for GetStats
+func (q *publicQuery) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"passed":  q.passed.Load(),
+		"limited": q.limited.Load(),
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// defaultKeepAlive is the keepalive idle time when the service does not set one
+const defaultKeepAlive = 30 * time.Second
+
+// applySocketOptions tunes a client or backend connection of a TCP service
+// Connections that are not plain TCP sockets are left alone; failures only cost the tuning, so they are logged
+func (p *TCPProxy) applySocketOptions(conn net.Conn, side string) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	opts := p.service.SocketOptions
+	if opts == nil {
+		opts = &config.SocketOptionsConfig{}
+	}
+
+	var errs []error
+	switch {
+	case opts.KeepAliveSeconds < 0:
+		errs = append(errs, tcpConn.SetKeepAlive(false))
+	case opts.KeepAliveSeconds == 0:
+		errs = append(errs, tcpConn.SetKeepAlive(true), tcpConn.SetKeepAlivePeriod(defaultKeepAlive))
+	default:
+		errs = append(errs, tcpConn.SetKeepAlive(true), tcpConn.SetKeepAlivePeriod(time.Duration(opts.KeepAliveSeconds)*time.Second))
+	}
+	if opts.NoDelay != nil {
+		errs = append(errs, tcpConn.SetNoDelay(*opts.NoDelay))
+	}
+	if opts.ReceiveBufferBytes > 0 {
+		errs = append(errs, tcpConn.SetReadBuffer(opts.ReceiveBufferBytes))
+	}
+	if opts.SendBufferBytes > 0 {
+		errs = append(errs, tcpConn.SetWriteBuffer(opts.SendBufferBytes))
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			p.log.Debug().
+				Err(err).
+				Str("service", p.service.ServiceName).
+				Str("side", side).
+				Msg("Failed to apply socket option")
+		}
+	}
+}
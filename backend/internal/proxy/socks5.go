@@ -0,0 +1,333 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+)
+
+// SOCKS5 protocol constants (RFC 1928)
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xFF
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded          = 0x00
+	socksReplyNotAllowed         = 0x02
+	socksReplyHostUnreachable    = 0x04
+	socksReplyConnectionRefused  = 0x05
+	socksReplyCommandUnsupported = 0x07
+	socksReplyAddressUnsupported = 0x08
+)
+
+const (
+	socksHandshakeTimeout         = 10 * time.Second
+	socksDestinationLookupTimeout = 5 * time.Second
+)
+
+// socksDestination is one allowed CONNECT target rule
+type socksDestination struct {
+	host     string       // Lower-case hostname, or "*.domain" suffix pattern; empty for IP rules
+	prefix   netip.Prefix // Valid for IP and CIDR rules
+	portLow  int
+	portHigh int
+}
+
+// NewSOCKS5Proxy creates a TCP proxy that speaks SOCKS5 to allowlisted clients
+// Access is granted by the allowlist (no SOCKS authentication); CONNECT targets are
+// restricted to the service's allowed destinations
+func NewSOCKS5Proxy(service *config.ProtectedServiceConfig, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, maxConnections int) *TCPProxy {
+	p := NewTCPProxy(service, allowlistManager, blocklistManager, maxConnections)
+	p.log = logging.Module("proxy.socks5").Service(service.ServiceID)
+
+	destinations := parseSOCKSDestinations(service)
+	source := newBackendSource(service)
+	p.backend = nil // Destinations are resolved per CONNECT request
+	p.dialBackend = func(clientConn net.Conn) (net.Conn, string, error) {
+		return socksHandshake(clientConn, destinations, source)
+	}
+	return p
+}
+
+// parseSOCKSDestinations builds the destination rules, defaulting to the fixed backend
+func parseSOCKSDestinations(service *config.ProtectedServiceConfig) []socksDestination {
+	entries := service.SOCKS5AllowedDestinations
+	if len(entries) == 0 && service.BackendTargetHost != "" {
+		entries = []string{net.JoinHostPort(service.BackendTargetHost, strconv.Itoa(service.BackendTargetPort))}
+	}
+
+	destinations := make([]socksDestination, 0, len(entries))
+	for _, entry := range entries {
+		destination, err := parseSOCKSDestination(entry)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("service", service.ServiceName).
+				Str("destination", entry).
+				Msg("Ignoring invalid SOCKS5 destination")
+			continue
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations
+}
+
+// parseSOCKSDestination parses host:port where host is a name, *.domain, IP or CIDR and port a number, range or *
+func parseSOCKSDestination(entry string) (socksDestination, error) {
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		return socksDestination{}, err
+	}
+
+	destination := socksDestination{portLow: 1, portHigh: 65535}
+	if port != "*" {
+		low, high, isRange := strings.Cut(port, "-")
+		if !isRange {
+			high = low
+		}
+		if destination.portLow, err = strconv.Atoi(low); err != nil {
+			return socksDestination{}, fmt.Errorf("invalid port '%s'", port)
+		}
+		if destination.portHigh, err = strconv.Atoi(high); err != nil {
+			return socksDestination{}, fmt.Errorf("invalid port '%s'", port)
+		}
+	}
+
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		destination.prefix = prefix.Masked()
+	} else if addr, err := netip.ParseAddr(host); err == nil {
+		destination.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	} else {
+		destination.host = strings.ToLower(strings.TrimSuffix(host, "."))
+	}
+	return destination, nil
+}
+
+// matchesPort reports whether a port is inside the rule's range
+func (d *socksDestination) matchesPort(port int) bool {
+	return port >= d.portLow && port <= d.portHigh
+}
+
+// matchesHost reports whether a requested hostname matches a hostname rule
+func (d *socksDestination) matchesHost(host string) bool {
+	if d.host == "" {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(d.host, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == d.host
+}
+
+// matchesAddr reports whether an address is inside an IP or CIDR rule
+func (d *socksDestination) matchesAddr(addr netip.Addr) bool {
+	return d.prefix.IsValid() && d.prefix.Contains(addr.Unmap())
+}
+
+// socksHandshake negotiates a CONNECT request and dials the permitted destination
+func socksHandshake(clientConn net.Conn, destinations []socksDestination, source *backendSource) (net.Conn, string, error) {
+	clientConn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	defer clientConn.SetDeadline(time.Time{})
+
+	// Method negotiation: access is granted by the allowlist, so only "no authentication" is offered
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(clientConn, header); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+	}
+	if header[0] != socksVersion {
+		return nil, "", fmt.Errorf("%w: unsupported SOCKS version %d", errClientHandshake, header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(clientConn, methods); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+	}
+	acceptable := false
+	for _, method := range methods {
+		if method == socksMethodNoAuth {
+			acceptable = true
+			break
+		}
+	}
+	if !acceptable {
+		clientConn.Write([]byte{socksVersion, socksMethodNoAcceptable})
+		return nil, "", fmt.Errorf("%w: client offered no acceptable authentication method", errClientHandshake)
+	}
+	if _, err := clientConn.Write([]byte{socksVersion, socksMethodNoAuth}); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(clientConn, request); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+	}
+	if request[0] != socksVersion {
+		return nil, "", fmt.Errorf("%w: unsupported SOCKS version %d", errClientHandshake, request[0])
+	}
+
+	var host string
+	var addr netip.Addr
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := 4
+		if request[3] == socksAddrIPv6 {
+			size = 16
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(clientConn, raw); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+		}
+		addr, _ = netip.AddrFromSlice(raw)
+		addr = addr.Unmap()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(clientConn, length); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+		}
+		raw := make([]byte, length[0])
+		if _, err := io.ReadFull(clientConn, raw); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+		}
+		host = strings.ToLower(strings.TrimSuffix(string(raw), "."))
+	default:
+		writeSocksReply(clientConn, socksReplyAddressUnsupported, nil)
+		return nil, "", fmt.Errorf("%w: unsupported address type %d", errClientHandshake, request[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(clientConn, portBytes); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", errClientHandshake, err)
+	}
+	port := int(binary.BigEndian.Uint16(portBytes))
+
+	if request[1] != socksCmdConnect {
+		writeSocksReply(clientConn, socksReplyCommandUnsupported, nil)
+		return nil, "", fmt.Errorf("%w: unsupported command %d", errClientHandshake, request[1])
+	}
+
+	target, ok := resolveSocksTarget(host, addr, port, destinations)
+	if !ok {
+		writeSocksReply(clientConn, socksReplyNotAllowed, nil)
+		requested := host
+		if requested == "" {
+			requested = addr.String()
+		}
+		return nil, "", fmt.Errorf("%w: destination %s not allowed", errClientHandshake, net.JoinHostPort(requested, strconv.Itoa(port)))
+	}
+
+	// With a source address the dialer only tries target addresses of its family
+	var backendConn net.Conn
+	localAddr, err := source.tcpAddr(target)
+	if err == nil {
+		dialer := &net.Dialer{Timeout: 10 * time.Second, LocalAddr: localAddr}
+		backendConn, err = dialer.Dial("tcp", target)
+	}
+	if err != nil {
+		reply := byte(socksReplyHostUnreachable)
+		if strings.Contains(err.Error(), "refused") {
+			reply = socksReplyConnectionRefused
+		}
+		writeSocksReply(clientConn, reply, nil)
+		return nil, target, err
+	}
+
+	if err := writeSocksReply(clientConn, socksReplySucceeded, backendConn.LocalAddr()); err != nil {
+		backendConn.Close()
+		return nil, target, fmt.Errorf("%w: %v", errClientHandshake, err)
+	}
+	return backendConn, target, nil
+}
+
+// resolveSocksTarget checks a requested destination against the rules and returns the address to dial
+// Hostnames not matched by a hostname rule are resolved and checked against IP rules; the resolved
+// address is dialed so the check cannot be bypassed by re-resolution
+func resolveSocksTarget(host string, addr netip.Addr, port int, destinations []socksDestination) (string, bool) {
+	portStr := strconv.Itoa(port)
+
+	if host == "" {
+		for i := range destinations {
+			if destinations[i].matchesPort(port) && destinations[i].matchesAddr(addr) {
+				return net.JoinHostPort(addr.String(), portStr), true
+			}
+		}
+		return "", false
+	}
+
+	// A hostname that is an IP literal is treated as an address
+	if literal, err := netip.ParseAddr(host); err == nil {
+		return resolveSocksTarget("", literal.Unmap(), port, destinations)
+	}
+
+	hasAddrRules := false
+	for i := range destinations {
+		if !destinations[i].matchesPort(port) {
+			continue
+		}
+		if destinations[i].matchesHost(host) {
+			return net.JoinHostPort(host, portStr), true
+		}
+		if destinations[i].prefix.IsValid() {
+			hasAddrRules = true
+		}
+	}
+	if !hasAddrRules {
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), socksDestinationLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", false
+	}
+	for _, resolved := range addrs {
+		for i := range destinations {
+			if destinations[i].matchesPort(port) && destinations[i].matchesAddr(resolved) {
+				return net.JoinHostPort(resolved.Unmap().String(), portStr), true
+			}
+		}
+	}
+	return "", false
+}
+
+// writeSocksReply sends a reply with the bound address (zero address when nil)
+func writeSocksReply(conn net.Conn, reply byte, bound net.Addr) error {
+	addr := netip.IPv4Unspecified()
+	port := 0
+	if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+		if parsed, ok := netip.AddrFromSlice(tcpAddr.IP); ok {
+			addr = parsed.Unmap()
+		}
+		port = tcpAddr.Port
+	}
+
+	response := []byte{socksVersion, reply, 0x00}
+	if addr.Is4() {
+		response = append(response, socksAddrIPv4)
+	} else {
+		response = append(response, socksAddrIPv6)
+	}
+	response = append(response, addr.AsSlice()...)
+	response = binary.BigEndian.AppendUint16(response, uint16(port))
+
+	_, err := conn.Write(response)
+	return err
+}
@@ -2,23 +2,31 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/auth"
 	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
-	"github.com/rs/zerolog/log"
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/davbauer/knock-knock-portal/internal/upgrade"
 )
 
 // tcpConnection tracks an active TCP connection
 type tcpConnection struct {
+	id                string
 	clientConn        net.Conn
 	clientIP          string
+	userKey           string // Per-user limit key; empty when not limited
+	startedAt         time.Time
 	cancel            context.CancelFunc
 	packetsFromClient int64 // Packets received from client
 	packetsToClient   int64 // Packets sent to client
@@ -29,6 +37,7 @@ type tcpConnection struct {
 // TCPProxy handles TCP connection proxying with IP filtering
 type TCPProxy struct {
 	service          *config.ProtectedServiceConfig
+	log              logging.Logger
 	allowlistManager *ipallowlist.Manager
 	blocklistManager *ipblocklist.Manager
 	listener         net.Listener
@@ -43,35 +52,73 @@ type TCPProxy struct {
 	connections      map[string][]*tcpConnection // clientIP -> list of connections
 	connectionsMu    sync.RWMutex
 	mu               sync.Mutex
+	dialBackend      backendDialer
+	backend          *backendResolver
+	history          *stats.History
+	identity         IdentityResolver
+	access           AccessPolicy
+	maxConnsPerUser  int // Maximum concurrent connections per portal user (0 = unlimited)
+	connRate         *auth.RateLimiter // Shared per-IP new-connection limit; nil = unlimited
+	latency          *latencyStats     // Connection duration and dial histograms; nil = not recorded
+	bufferSize       int               // Copy buffer per direction and connection
+	tls              *tcpTLS           // TLS on the client or the backend side; nil = plaintext
 }
 
+// backendDialer opens the backend connection for an accepted, allowed client connection
+// It returns the backend address for logging
+type backendDialer func(clientConn net.Conn) (net.Conn, string, error)
+
+// errClientHandshake marks dial failures caused by the client rather than the backend
+var errClientHandshake = errors.New("client handshake failed")
+
 // NewTCPProxy creates a new TCP proxy
 func NewTCPProxy(service *config.ProtectedServiceConfig, allowlistManager *ipallowlist.Manager, blocklistManager *ipblocklist.Manager, maxConnections int) *TCPProxy {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &TCPProxy{
+	p := &TCPProxy{
 		service:          service,
+		log:              logging.Module("proxy.tcp").Service(service.ServiceID),
 		allowlistManager: allowlistManager,
 		blocklistManager: blocklistManager,
 		ctx:              ctx,
 		cancel:           cancel,
 		maxConns:         int32(maxConnections),
-		circuitBreaker:   NewCircuitBreaker(service.ServiceName, 5, 30*time.Second, 3),
+		bufferSize:       defaultTCPBufferSize,
+		circuitBreaker:   newServiceCircuitBreaker(service),
 		connections:      make(map[string][]*tcpConnection),
+		backend:          newServiceBackendResolver(service),
+	}
+	p.dialBackend = p.dialFixedBackend
+	return p
+}
+
+// dialFixedBackend connects to the service's configured backend
+func (p *TCPProxy) dialFixedBackend(clientConn net.Conn) (net.Conn, string, error) {
+	start := time.Now()
+	conn, addr, err := p.backend.dialTCP(p.ctx, 10*time.Second)
+	if err == nil {
+		p.latency.observeDial(time.Since(start))
 	}
+	return conn, addr, err
 }
 
 // Start begins listening and proxying connections
 func (p *TCPProxy) Start() error {
-	listenAddr := fmt.Sprintf(":%d", p.service.ProxyListenPortStart)
+	listenAddr := net.JoinHostPort(p.service.ProxyListenAddress, strconv.Itoa(p.service.ProxyListenPortStart))
+
+	tlsConfig, err := newTCPTLS(p.service)
+	if err != nil {
+		return err
+	}
+	p.tls = tlsConfig
 
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := upgrade.Listen(config.ListenNetwork("tcp", p.service.ProxyListenAddress), listenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to start TCP listener on %s: %w", listenAddr, err)
 	}
 
 	p.listener = listener
 
-	log.Info().
+	p.log.Info().
 		Str("service_id", p.service.ServiceID).
 		Int("proxy_port", p.service.ProxyListenPortStart).
 		Str("backend", fmt.Sprintf("%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort)).
@@ -90,31 +137,40 @@ func (p *TCPProxy) acceptLoop() {
 	for {
 		conn, err := p.listener.Accept()
 		if err != nil {
+			// Listener closed for draining
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			select {
 			case <-p.ctx.Done():
 				return
 			default:
-				log.Error().Err(err).Msg("Failed to accept connection")
+				p.log.Error().Err(err).Msg("Failed to accept connection")
 				continue
 			}
 		}
 
-		// Check connection limit
-		currentConns := atomic.LoadInt32(&p.activeConnCount)
-		if currentConns >= p.maxConns {
-			log.Warn().
-				Int32("current", currentConns).
-				Int32("max", p.maxConns).
-				Str("service", p.service.ServiceName).
-				Msg("Maximum connections reached, rejecting new connection")
-			conn.Close()
-			continue
-		}
+		p.admit(conn)
+	}
+}
 
-		atomic.AddInt32(&p.activeConnCount, 1)
-		p.activeConns.Add(1)
-		go p.handleConnection(p.ctx, conn)
+// admit enforces the connection limit and hands an accepted connection to a handler goroutine
+func (p *TCPProxy) admit(conn net.Conn) {
+	// Check connection limit
+	currentConns := atomic.LoadInt32(&p.activeConnCount)
+	if currentConns >= p.maxConns {
+		p.log.Warn().
+			Int32("current", currentConns).
+			Int32("max", p.maxConns).
+			Str("service", p.service.ServiceName).
+			Msg("Maximum connections reached, rejecting new connection")
+		conn.Close()
+		return
 	}
+
+	atomic.AddInt32(&p.activeConnCount, 1)
+	p.activeConns.Add(1)
+	go p.handleConnection(p.ctx, conn)
 }
 
 // handleConnection handles a single TCP connection with context-aware cancellation
@@ -129,7 +185,7 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 	clientAddr := clientConn.RemoteAddr().(*net.TCPAddr)
 	clientIP, ok := parseIPFromAddr(clientAddr.IP.String())
 	if !ok {
-		log.Warn().
+		p.log.Warn().
 			Str("addr", clientAddr.IP.String()).
 			Msg("Failed to parse client IP")
 		return
@@ -139,39 +195,60 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 
 	// HIGHEST PRIORITY: Check IP blocklist first
 	if blocked, blockReason := p.blocklistManager.IsIPBlocked(net.ParseIP(clientIPStr)); blocked {
-		log.Warn().
-			Str("client_ip", clientIPStr).
-			Str("service", p.service.ServiceName).
-			Str("reason", blockReason).
-			Msg("Connection denied: IP is blocked")
+		if deniedLogs.Allow(clientIPStr, p.service.ServiceName, "blocked") {
+			p.log.Warn().
+				Str("client_ip", clientIPStr).
+				Str("service", p.service.ServiceName).
+				Str("reason", blockReason).
+				Msg("Connection denied: IP is blocked")
+		}
+		rejectTCP(ctx, clientConn, p.service.DenyResponse, clientIPStr, p.service.ServiceName, false)
 		return
 	}
 
 	// Check IP allowlist
-	allowed, reason := p.allowlistManager.IsIPAllowed(clientIP)
+	allowed, reason := checkAccess(p.allowlistManager, p.access, clientIP, p.service.ServiceID)
 	if !allowed {
-		log.Warn().
-			Str("client_ip", clientIPStr).
-			Str("service", p.service.ServiceName).
-			Str("reason", reason).
-			Msg("Connection denied: IP not in allowlist")
+		if deniedLogs.Allow(clientIPStr, p.service.ServiceName, "not_allowed") {
+			p.log.Warn().
+				Str("client_ip", clientIPStr).
+				Str("service", p.service.ServiceName).
+				Str("reason", reason).
+				Msg("Connection denied: IP not in allowlist")
+		}
+		if p.service.ProtocolHint == "minecraft" {
+			if err := rejectMinecraft(clientConn, minecraftDenyMessage(p.service.DenyResponse, clientIPStr, p.service.ServiceName)); err != nil {
+				p.log.Debug().
+					Err(err).
+					Str("client_ip", clientIPStr).
+					Str("service", p.service.ServiceName).
+					Msg("Minecraft client did not complete the handshake")
+			}
+			return
+		}
+		rejectTCP(ctx, clientConn, p.service.DenyResponse, clientIPStr, p.service.ServiceName, true)
 		return
 	}
 
-	// Set TCP keepalive for connection health monitoring
-	if tcpConn, ok := clientConn.(*net.TCPConn); ok {
-		tcpConn.SetKeepAlive(true)
-		tcpConn.SetKeepAlivePeriod(30 * time.Second)
+	// Shared per-IP new-connection rate limit
+	if !allowNewConnection(p.connRate, clientIPStr, p.service.ServiceName) {
+		return
 	}
 
+	// Keepalive for connection health monitoring, plus the service's socket tuning
+	p.applySocketOptions(clientConn, "client")
+
 	// Create connection-specific context for instant termination
 	connCtx, connCancel := context.WithCancel(ctx)
 	defer connCancel()
 
 	// Track this connection
 	conn := &tcpConnection{
+		id:                nextConnectionID("tcp"),
 		clientConn:        clientConn,
 		clientIP:          clientIPStr,
+		userKey:           userLimitKey(p.identity, p.maxConnsPerUser, clientIP),
+		startedAt:         time.Now(),
 		cancel:            connCancel,
 		packetsFromClient: 0,
 		packetsToClient:   0,
@@ -180,6 +257,20 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 	}
 
 	p.connectionsMu.Lock()
+	if conn.userKey != "" {
+		if userConns := p.countUserConnections(conn.userKey); userConns >= p.maxConnsPerUser {
+			p.connectionsMu.Unlock()
+			if deniedLogs.Allow(clientIPStr, p.service.ServiceName, "user_connection_limit") {
+				p.log.Warn().
+					Str("client_ip", clientIPStr).
+					Str("service", p.service.ServiceName).
+					Int("current", userConns).
+					Int("max", p.maxConnsPerUser).
+					Msg("Connection denied: per-user connection limit reached")
+			}
+			return
+		}
+	}
 	p.connections[clientIPStr] = append(p.connections[clientIPStr], conn)
 	p.connectionsMu.Unlock()
 
@@ -212,20 +303,40 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 
 	// Check circuit breaker
 	if !p.circuitBreaker.Allow() {
-		log.Warn().
+		if deniedLogs.Allow(clientIPStr, p.service.ServiceName, "circuit_open") {
+			p.log.Warn().
+				Str("client_ip", clientIPStr).
+				Str("service", p.service.ServiceName).
+				Str("circuit_state", p.circuitBreaker.GetState().String()).
+				Msg("Connection denied: circuit breaker is open")
+		}
+		return
+	}
+
+	// Terminate TLS from the client (tls mode client)
+	clientConn, err := p.tls.acceptClient(ctx, clientConn)
+	if err != nil {
+		p.log.Debug().
+			Err(err).
 			Str("client_ip", clientIPStr).
 			Str("service", p.service.ServiceName).
-			Str("circuit_state", p.circuitBreaker.GetState().String()).
-			Msg("Connection denied: circuit breaker is open")
+			Msg("Client TLS handshake failed")
 		return
 	}
 
 	// Connect to backend
-	backendAddr := net.JoinHostPort(p.service.BackendTargetHost, fmt.Sprintf("%d", p.service.BackendTargetPort))
-	backendConn, err := net.DialTimeout("tcp", backendAddr, 10*time.Second)
+	backendConn, backendAddr, err := p.dialBackend(clientConn)
 	if err != nil {
+		if errors.Is(err, errClientHandshake) {
+			p.log.Debug().
+				Err(err).
+				Str("client_ip", clientIPStr).
+				Str("service", p.service.ServiceName).
+				Msg("Client handshake failed")
+			return
+		}
 		p.circuitBreaker.RecordFailure()
-		log.Error().
+		p.log.Error().
 			Err(err).
 			Str("backend", backendAddr).
 			Str("circuit_state", p.circuitBreaker.GetState().String()).
@@ -233,17 +344,29 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 		return
 	}
 	defer backendConn.Close()
+	defer func() {
+		p.latency.observeDuration(time.Since(conn.startedAt))
+	}()
 
-	// Set TCP keepalive on backend connection
-	if tcpConn, ok := backendConn.(*net.TCPConn); ok {
-		tcpConn.SetKeepAlive(true)
-		tcpConn.SetKeepAlivePeriod(30 * time.Second)
+	// Same keepalive and tuning on the backend connection
+	p.applySocketOptions(backendConn, "backend")
+
+	// Speak TLS to the backend (tls mode backend)
+	backendConn, err = p.tls.wrapBackend(ctx, backendConn)
+	if err != nil {
+		p.circuitBreaker.RecordFailure()
+		p.log.Error().
+			Err(err).
+			Str("backend", backendAddr).
+			Str("circuit_state", p.circuitBreaker.GetState().String()).
+			Msg("Backend TLS handshake failed")
+		return
 	}
 
 	// Record success
 	p.circuitBreaker.RecordSuccess()
 
-	log.Info().
+	p.log.Info().
 		Str("client_ip", clientIPStr).
 		Str("service", p.service.ServiceName).
 		Str("backend", backendAddr).
@@ -255,9 +378,18 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 	connID := p.connCount
 	p.mu.Unlock()
 
+	p.history.RecordConnection(p.service.ServiceID, clientIPStr)
+
+	// Count traffic towards the history as it flows
+	var toBackend, toClient io.Writer = backendConn, clientConn
+	if p.history != nil {
+		toBackend = &historyWriter{Writer: backendConn, history: p.history, serviceID: p.service.ServiceID, clientIP: clientIPStr}
+		toClient = &historyWriter{Writer: clientConn, history: p.history, serviceID: p.service.ServiceID, clientIP: clientIPStr, toClient: true}
+	}
+
 	// Get buffers from pool
-	clientToBackendBuf := getTCPBuffer()
-	backendToClientBuf := getTCPBuffer()
+	clientToBackendBuf := getBuffer(p.bufferSize)
+	backendToClientBuf := getBuffer(p.bufferSize)
 
 	// Bidirectional copy with proper goroutine coordination
 	clientToBackendDone := make(chan error, 1)
@@ -265,13 +397,13 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 
 	// Client -> Backend copy
 	go func() {
-		_, err := copyWithStats(backendConn, clientConn, *clientToBackendBuf, &conn.bytesFromClient, &conn.packetsFromClient)
+		_, err := copyWithStats(toBackend, clientConn, *clientToBackendBuf, &conn.bytesFromClient, &conn.packetsFromClient)
 		clientToBackendDone <- err
 	}()
 
 	// Backend -> Client copy
 	go func() {
-		_, err := copyWithStats(clientConn, backendConn, *backendToClientBuf, &conn.bytesToClient, &conn.packetsToClient)
+		_, err := copyWithStats(toClient, backendConn, *backendToClientBuf, &conn.bytesToClient, &conn.packetsToClient)
 		backendToClientDone <- err
 	}()
 
@@ -296,14 +428,14 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 		backendConn.SetDeadline(time.Now())
 		<-clientToBackendDone
 		<-backendToClientDone
-		log.Info().
+		p.log.Info().
 			Int64("conn_id", connID).
 			Str("client_ip", clientIPStr).
 			Str("service", p.service.ServiceName).
 			Msg("TCP connection terminated instantly (session deleted)")
 		// Return buffers after goroutines complete
-		putTCPBuffer(clientToBackendBuf)
-		putTCPBuffer(backendToClientBuf)
+		putBuffer(clientToBackendBuf)
+		putBuffer(backendToClientBuf)
 		return
 	case <-ctx.Done():
 		// Context cancelled - graceful shutdown
@@ -311,32 +443,32 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 		backendConn.SetDeadline(time.Now())
 		<-clientToBackendDone
 		<-backendToClientDone
-		log.Debug().
+		p.log.Debug().
 			Int64("conn_id", connID).
 			Str("client_ip", clientIPStr).
 			Str("service", p.service.ServiceName).
 			Msg("TCP connection cancelled due to shutdown")
 		// Return buffers after goroutines complete
-		putTCPBuffer(clientToBackendBuf)
-		putTCPBuffer(backendToClientBuf)
+		putBuffer(clientToBackendBuf)
+		putBuffer(backendToClientBuf)
 		return
 	}
 
 	// Return buffers after goroutines complete
-	putTCPBuffer(clientToBackendBuf)
-	putTCPBuffer(backendToClientBuf)
+	putBuffer(clientToBackendBuf)
+	putBuffer(backendToClientBuf)
 
 	// Record circuit breaker result based on copy errors
 	if copyErr != nil && copyErr != io.EOF {
 		p.circuitBreaker.RecordFailure()
-		log.Debug().
+		p.log.Debug().
 			Err(copyErr).
 			Int64("conn_id", connID).
 			Str("client_ip", clientIPStr).
 			Str("service", p.service.ServiceName).
 			Msg("TCP connection closed with error")
 	} else {
-		log.Debug().
+		p.log.Debug().
 			Int64("conn_id", connID).
 			Str("client_ip", clientIPStr).
 			Str("service", p.service.ServiceName).
@@ -344,6 +476,20 @@ func (p *TCPProxy) handleConnection(ctx context.Context, clientConn net.Conn) {
 	}
 }
 
+// countUserConnections counts active connections under a per-user limit key
+// Caller must hold connectionsMu
+func (p *TCPProxy) countUserConnections(userKey string) int {
+	count := 0
+	for _, conns := range p.connections {
+		for _, conn := range conns {
+			if conn.userKey == userKey {
+				count++
+			}
+		}
+	}
+	return count
+}
+
 // TerminateSessionsByIP closes all TCP connections for a specific IP address
 func (p *TCPProxy) TerminateSessionsByIP(clientIP string) int {
 	p.connectionsMu.Lock()
@@ -374,7 +520,7 @@ func (p *TCPProxy) TerminateSessionsByIP(clientIP string) int {
 	// Remove all connections for this IP from tracking
 	delete(p.connections, clientIP)
 
-	log.Debug().
+	p.log.Debug().
 		Str("client_ip", clientIP).
 		Int("connections_terminated", terminated).
 		Str("service", p.service.ServiceName).
@@ -419,9 +565,73 @@ func (p *TCPProxy) GetStatsByIP(clientIP string) map[string]interface{} {
 	return stats
 }
 
+// ListConnectionsByIP returns the active connections from a specific client IP
+func (p *TCPProxy) ListConnectionsByIP(clientIP string) []ConnectionInfo {
+	p.connectionsMu.RLock()
+	defer p.connectionsMu.RUnlock()
+
+	conns := p.connections[clientIP]
+	infos := make([]ConnectionInfo, 0, len(conns))
+	for _, conn := range conns {
+		infos = append(infos, p.connectionInfo(conn))
+	}
+	return infos
+}
+
+// ListConnections returns the active connections of all client IPs
+func (p *TCPProxy) ListConnections() []ConnectionInfo {
+	p.connectionsMu.RLock()
+	defer p.connectionsMu.RUnlock()
+
+	infos := []ConnectionInfo{}
+	for _, conns := range p.connections {
+		for _, conn := range conns {
+			infos = append(infos, p.connectionInfo(conn))
+		}
+	}
+	return infos
+}
+
+// connectionInfo describes a tracked connection with its current counters
+func (p *TCPProxy) connectionInfo(conn *tcpConnection) ConnectionInfo {
+	info := newConnectionInfo(conn.id, p.service, "tcp", conn.clientConn.RemoteAddr().String(), conn.startedAt)
+	info.ClientIP = conn.clientIP
+	info.PacketsReceived = atomic.LoadInt64(&conn.packetsFromClient)
+	info.PacketsSent = atomic.LoadInt64(&conn.packetsToClient)
+	info.BytesReceived = atomic.LoadInt64(&conn.bytesFromClient)
+	info.BytesSent = atomic.LoadInt64(&conn.bytesToClient)
+	return info
+}
+
+// TerminateConnection closes a single connection by ID
+// Returns false if the proxy has no such connection
+func (p *TCPProxy) TerminateConnection(connID string) bool {
+	p.connectionsMu.RLock()
+	defer p.connectionsMu.RUnlock()
+
+	for _, conns := range p.connections {
+		for _, conn := range conns {
+			if conn.id != connID {
+				continue
+			}
+			// The handler removes the connection from tracking when it exits
+			conn.cancel()
+			conn.clientConn.Close()
+
+			p.log.Info().
+				Str("conn_id", connID).
+				Str("client_ip", conn.clientIP).
+				Str("service", p.service.ServiceName).
+				Msg("Terminated TCP connection")
+			return true
+		}
+	}
+	return false
+}
+
 // Stop gracefully shuts down the proxy
 func (p *TCPProxy) Stop() error {
-	log.Info().
+	p.log.Info().
 		Str("service", p.service.ServiceName).
 		Msg("Stopping TCP proxy")
 
@@ -443,11 +653,11 @@ func (p *TCPProxy) Stop() error {
 
 	select {
 	case <-done:
-		log.Info().
+		p.log.Info().
 			Str("service", p.service.ServiceName).
 			Msg("TCP proxy stopped gracefully")
 	case <-time.After(30 * time.Second):
-		log.Warn().
+		p.log.Warn().
 			Str("service", p.service.ServiceName).
 			Msg("TCP proxy stopped with timeout (some connections may have been terminated)")
 	}
@@ -455,6 +665,42 @@ func (p *TCPProxy) Stop() error {
 	return nil
 }
 
+// Drain stops accepting connections and waits for active ones to finish before stopping
+// A zero timeout waits indefinitely
+func (p *TCPProxy) Drain(timeout time.Duration) error {
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	p.wg.Wait()
+
+	p.log.Info().
+		Str("service", p.service.ServiceName).
+		Int32("active_connections", atomic.LoadInt32(&p.activeConnCount)).
+		Msg("Draining TCP proxy")
+
+	done := make(chan struct{})
+	go func() {
+		p.activeConns.Wait()
+		close(done)
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	select {
+	case <-done:
+	case <-expired:
+		p.log.Warn().
+			Str("service", p.service.ServiceName).
+			Int32("active_connections", atomic.LoadInt32(&p.activeConnCount)).
+			Msg("Drain timeout reached, closing remaining connections")
+	}
+
+	return p.Stop()
+}
+
 // GetStats returns proxy statistics
 func (p *TCPProxy) GetStats() map[string]interface{} {
 	// Collect client IPs with proper lock
@@ -480,6 +726,15 @@ func (p *TCPProxy) GetStats() map[string]interface{} {
 		"backend_addr":       fmt.Sprintf("%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort),
 		"circuit_breaker":    p.circuitBreaker.GetStats(),
 	}
+	if p.service.ProxyListenAddress != "" {
+		stats["listen_address"] = p.service.ProxyListenAddress
+	}
+	if p.backend != nil {
+		stats["backend_resolution"] = p.backend.stats()
+	}
+	if p.latency != nil {
+		stats["latency"] = p.latency.summary()
+	}
 
 	return stats
 }
@@ -508,7 +763,7 @@ func (p *TCPProxy) TerminateConnectionsByIP(clientIP string) int {
 	// Remove from active connections map
 	delete(p.connections, clientIP)
 
-	log.Info().
+	p.log.Info().
 		Str("service", p.service.ServiceName).
 		Str("client_ip", clientIP).
 		Int("terminated_count", terminated).
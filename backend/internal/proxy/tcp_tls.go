@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// tlsHandshakeTimeout bounds the TLS handshake with a client or backend
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tcpTLS wraps one side of a TCP service's connections in TLS (stunnel mode)
+type tcpTLS struct {
+	client  *tls.Config // Terminates TLS from clients; nil = clients speak plaintext
+	backend *tls.Config // Speaks TLS to the backend; nil = plaintext backend
+}
+
+// newTCPTLS loads the certificates of a service's tls settings; returns nil if the service has none
+func newTCPTLS(service *config.ProtectedServiceConfig) (*tcpTLS, error) {
+	cfg := service.TLS
+	if cfg == nil {
+		return nil, nil
+	}
+
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.MinVersion == "1.3" {
+		base.MinVersion = tls.VersionTLS13
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+		base.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.Mode == "client" {
+		if cfg.ClientCAFile != "" {
+			pool, err := loadCertPool(cfg.ClientCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("tls.client_ca_file: %w", err)
+			}
+			base.ClientCAs = pool
+			base.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return &tcpTLS{client: base}, nil
+	}
+
+	base.ServerName = cfg.ServerName
+	if base.ServerName == "" {
+		base.ServerName = service.BackendTargetHost
+	}
+	base.InsecureSkipVerify = cfg.InsecureSkipVerify
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.ca_file: %w", err)
+		}
+		base.RootCAs = pool
+	}
+	return &tcpTLS{backend: base}, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return pool, nil
+}
+
+// acceptClient completes the TLS handshake of a client connection; plaintext clients are returned as they are
+func (t *tcpTLS) acceptClient(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	if t == nil || t.client == nil {
+		return conn, nil
+	}
+	tlsConn := tls.Server(conn, t.client)
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// wrapBackend completes the TLS handshake with the backend over an established connection; plaintext backends are returned as they are
+func (t *tcpTLS) wrapBackend(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	if t == nil || t.backend == nil {
+		return conn, nil
+	}
+	tlsConn := tls.Client(conn, t.backend)
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
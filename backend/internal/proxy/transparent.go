@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/upgrade"
+)
+
+// TransparentProxy accepts TCP connections redirected by the kernel and dispatches them
+// to the TCP proxy of the service whose listen port matches the original destination port
+// Access checks, limits and statistics are those of the selected service's proxy
+type TransparentProxy struct {
+	cfg        config.TransparentProxyConfiguration
+	lookup     func(port int) *TCPProxy
+	listener   net.Listener
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	dispatched int64
+	unmatched  int64
+}
+
+// NewTransparentProxy creates a transparent listener; lookup returns the TCP proxy for a destination port
+func NewTransparentProxy(cfg *config.TransparentProxyConfiguration, lookup func(port int) *TCPProxy) *TransparentProxy {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TransparentProxy{
+		cfg:    *cfg,
+		lookup: lookup,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start begins accepting redirected connections
+func (p *TransparentProxy) Start() error {
+	listenAddr := net.JoinHostPort(p.cfg.ListenAddress, strconv.Itoa(p.cfg.ListenPort))
+
+	lc := &net.ListenConfig{}
+	if p.cfg.Mode == "tproxy" {
+		lc.Control = transparentControl
+	}
+
+	listener, err := upgrade.ListenConfig(lc, "tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start transparent listener on %s: %w", listenAddr, err)
+	}
+
+	p.listener = listener
+
+	log.Info().
+		Str("listen_addr", listenAddr).
+		Str("mode", p.cfg.Mode).
+		Msg("Starting transparent proxy listener")
+
+	p.wg.Add(1)
+	go p.acceptLoop()
+
+	return nil
+}
+
+// acceptLoop accepts redirected connections and hands them to the matching service proxy
+func (p *TransparentProxy) acceptLoop() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			select {
+			case <-p.ctx.Done():
+				return
+			default:
+				log.Error().Err(err).Msg("Failed to accept transparent connection")
+				continue
+			}
+		}
+
+		dst, err := p.originalDestination(conn)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("client", conn.RemoteAddr().String()).
+				Msg("Failed to determine original destination, closing connection")
+			conn.Close()
+			continue
+		}
+
+		target := p.lookup(int(dst.Port()))
+		if target == nil {
+			atomic.AddInt64(&p.unmatched, 1)
+			log.Debug().
+				Str("client", conn.RemoteAddr().String()).
+				Str("original_dst", dst.String()).
+				Msg("No TCP service for original destination port, closing connection")
+			conn.Close()
+			continue
+		}
+
+		atomic.AddInt64(&p.dispatched, 1)
+		target.admit(conn)
+	}
+}
+
+// originalDestination returns the address the client originally connected to
+// With TPROXY the socket is bound to it; with REDIRECT it is read from conntrack
+func (p *TransparentProxy) originalDestination(conn net.Conn) (netip.AddrPort, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("not a TCP connection")
+	}
+
+	if p.cfg.Mode == "redirect" {
+		return originalDst(tcpConn)
+	}
+
+	local, ok := tcpConn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("unexpected local address type")
+	}
+	return local.AddrPort(), nil
+}
+
+// Stop closes the listener; dispatched connections belong to their service proxies
+func (p *TransparentProxy) Stop() error {
+	log.Info().Msg("Stopping transparent proxy")
+
+	p.cancel()
+
+	if p.listener != nil {
+		p.listener.Close()
+	}
+
+	p.wg.Wait()
+	return nil
+}
+
+// GetStats returns transparent listener statistics
+func (p *TransparentProxy) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"service_name": "transparent",
+		"mode":         p.cfg.Mode,
+		"listen_port":  p.cfg.ListenPort,
+		"dispatched":   atomic.LoadInt64(&p.dispatched),
+		"unmatched":    atomic.LoadInt64(&p.unmatched),
+	}
+}
+
+// TerminateConnectionsByIP is a no-op; connections are tracked by the service proxies
+func (p *TransparentProxy) TerminateConnectionsByIP(clientIP string) int {
+	return 0
+}
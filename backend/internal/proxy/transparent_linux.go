@@ -0,0 +1,93 @@
+//go:build linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+)
+
+// Netfilter socket options (linux/netfilter_ipv4.h, linux/in6.h)
+const (
+	soOriginalDst   = 80
+	ipv6Transparent = 75
+)
+
+// transparentControl marks a listening socket as transparent so it accepts TPROXY'd connections
+// for non-local destinations (requires CAP_NET_ADMIN)
+func transparentControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if network == "tcp4" {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+			return
+		}
+		// Dual-stack sockets need both options to receive IPv4 and IPv6 traffic
+		if sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1); sockErr != nil {
+			return
+		}
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("failed to set IP_TRANSPARENT: %w", sockErr)
+	}
+	return nil
+}
+
+// originalDst reads the pre-REDIRECT destination of a connection from conntrack
+func originalDst(conn *net.TCPConn) (netip.AddrPort, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	isIPv6 := false
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && local.IP.To4() == nil {
+		isIPv6 = true
+	}
+
+	var dst netip.AddrPort
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if isIPv6 {
+			var sa syscall.RawSockaddrInet6
+			size := uint32(syscall.SizeofSockaddrInet6)
+			sockErr = getsockopt(int(fd), syscall.SOL_IPV6, soOriginalDst, unsafe.Pointer(&sa), &size)
+			if sockErr == nil {
+				port := uint16(sa.Port>>8) | uint16(sa.Port<<8)
+				dst = netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), port)
+			}
+			return
+		}
+
+		var sa syscall.RawSockaddrInet4
+		size := uint32(syscall.SizeofSockaddrInet4)
+		sockErr = getsockopt(int(fd), syscall.SOL_IP, soOriginalDst, unsafe.Pointer(&sa), &size)
+		if sockErr == nil {
+			port := uint16(sa.Port>>8) | uint16(sa.Port<<8)
+			dst = netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), port)
+		}
+	})
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if sockErr != nil {
+		return netip.AddrPort{}, fmt.Errorf("SO_ORIGINAL_DST failed: %w", sockErr)
+	}
+	return dst, nil
+}
+
+// getsockopt wraps the raw syscall for struct-valued options
+func getsockopt(fd, level, name int, value unsafe.Pointer, size *uint32) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(value), uintptr(unsafe.Pointer(size)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// transparentControl is unavailable; TPROXY is a Linux feature
+func transparentControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("transparent proxy mode is only supported on Linux")
+}
+
+// originalDst is unavailable; SO_ORIGINAL_DST is a Linux feature
+func originalDst(conn *net.TCPConn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, fmt.Errorf("transparent proxy mode is only supported on Linux")
+}
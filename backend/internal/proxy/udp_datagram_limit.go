@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// Actions for client datagrams over the size limit
+const (
+	oversizeDrop     = "drop"
+	oversizeTruncate = "truncate"
+	oversizeLog      = "log" // Forwarded in full
+)
+
+// datagramLimit enforces the datagram size limit and don't-fragment policy of a UDP service
+type datagramLimit struct {
+	maxSize      int    // 0 = no size limit
+	action       string // drop | truncate | log
+	dontFragment bool
+
+	oversized atomic.Int64 // Client datagrams over maxSize, whatever the action
+	tooBig    atomic.Int64 // Datagrams the kernel refused to send unfragmented
+}
+
+// newDatagramLimit builds the datagram limit of a service; nil when udp_datagram_limit is not configured
+func newDatagramLimit(service *config.ProtectedServiceConfig) *datagramLimit {
+	cfg := service.UDPDatagramLimit
+	if cfg == nil {
+		return nil
+	}
+
+	action := cfg.Oversize
+	if action == "" {
+		action = oversizeDrop
+	}
+	return &datagramLimit{
+		maxSize:      cfg.MaxSizeBytes,
+		action:       action,
+		dontFragment: cfg.DontFragment,
+	}
+}
+
+// apply returns how much of a client datagram of the given size to forward; ok is false when it is dropped
+// oversized reports whether the datagram exceeded the limit, so the caller can log it
+func (l *datagramLimit) apply(size int) (forward int, ok, oversized bool) {
+	if l == nil || l.maxSize == 0 || size <= l.maxSize {
+		return size, true, false
+	}
+	l.oversized.Add(1)
+	switch l.action {
+	case oversizeTruncate:
+		return l.maxSize, true, true
+	case oversizeLog:
+		return size, true, true
+	}
+	return 0, false, true
+}
+
+// rejectedTooBig reports whether a send failed because the datagram exceeds the path MTU with DF set
+// Such failures are the client's packet size, not the backend's health, and are only counted
+func (l *datagramLimit) rejectedTooBig(err error) bool {
+	if l == nil || !l.dontFragment || !errors.Is(err, syscall.EMSGSIZE) {
+		return false
+	}
+	l.tooBig.Add(1)
+	return true
+}
+
+// stats returns the datagram limit counters for GetStats
+func (l *datagramLimit) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"max_size_bytes":   l.maxSize,
+		"oversize":         l.action,
+		"dont_fragment":    l.dontFragment,
+		"oversized":        l.oversized.Load(),
+		"too_big_for_path": l.tooBig.Load(),
+	}
+}
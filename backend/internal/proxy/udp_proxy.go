@@ -2,21 +2,27 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/auth"
 	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
 	"github.com/davbauer/knock-knock-portal/internal/ipblocklist"
-	"github.com/rs/zerolog/log"
+	"github.com/davbauer/knock-knock-portal/internal/logging"
+	"github.com/davbauer/knock-knock-portal/internal/stats"
+	"github.com/davbauer/knock-knock-portal/internal/upgrade"
 )
 
 // UDPProxy handles UDP packet forwarding with IP filtering and session tracking
 type UDPProxy struct {
 	service          *config.ProtectedServiceConfig
+	log              logging.Logger
 	allowlistManager *ipallowlist.Manager
 	blocklistManager *ipblocklist.Manager
 	conn             *net.UDPConn
@@ -29,22 +35,44 @@ type UDPProxy struct {
 	maxSessions      int32 // Maximum allowed concurrent sessions
 	packetCount      int64
 	mu               sync.Mutex
+	handoverKey      string       // Key under which the socket and sessions survive binary upgrades
+	readGate         sync.RWMutex // Held for writing while sessions are frozen for an upgrade
+	frozen           int32
+	history          *stats.History
+	identity         IdentityResolver
+	access           AccessPolicy
+	maxUserSessions  int // Maximum concurrent sessions per portal user (0 = unlimited)
+	connRate         *auth.RateLimiter // Shared per-IP new-connection limit; nil = unlimited
+	latency          *latencyStats     // Session duration histogram; nil = not recorded
+	bufferSize       int               // Receive buffer; longer datagrams are truncated
+	relay            *udpRelay         // Re-broadcasts discovery packets on the backend network; nil = off
+	publicQuery      *publicQuery      // Server-list queries that bypass the allowlist; nil = off
+	datagramLimit    *datagramLimit    // Size limit and don't-fragment policy for datagrams to the backend; nil = off
+	sourcePorts      *sourcePorts      // Backend source port per client, kept across idle timeouts; nil = off
+	circuitBreaker   *CircuitBreaker
+	backend          *backendResolver
+	responseTimeout  time.Duration // A new session without a backend answer within this time is a failure; 0 = not checked
 }
 
 // udpSession represents a pseudo-connection for UDP traffic
 type udpSession struct {
+	id               string
 	clientAddr       *net.UDPAddr
+	userKey          string // Per-user limit key; empty when not limited
+	createdAt        time.Time
 	backendConn      *net.UDPConn
 	backendAddr      *net.UDPAddr // Expected backend address for validation
 	lastActivity     time.Time
-	spoofAttempts    int32  // Counter for spoof detection
-	maxSpoofAttempts int32  // Maximum allowed spoof attempts before termination
-	packetsReceived  int64  // Total packets received from client
-	packetsSent      int64  // Total packets sent to client
-	bytesReceived    int64  // Total bytes received from client
-	bytesSent        int64  // Total bytes sent to client
+	spoofAttempts    int32       // Counter for spoof detection
+	maxSpoofAttempts int32       // Maximum allowed spoof attempts before termination
+	packetsReceived  int64       // Total packets received from client
+	packetsSent      int64       // Total packets sent to client
+	bytesReceived    int64       // Total bytes received from client
+	bytesSent        int64       // Total bytes sent to client
+	quality          *udpQuality // Packet timing in both directions
 	ctx              context.Context
 	cancel           context.CancelFunc
+	done             chan struct{} // Closed when receiveFromBackend exits
 	mu               sync.Mutex
 }
 
@@ -53,6 +81,7 @@ func NewUDPProxy(service *config.ProtectedServiceConfig, allowlistManager *ipall
 	ctx, cancel := context.WithCancel(context.Background())
 	return &UDPProxy{
 		service:          service,
+		log:              logging.Module("proxy.udp").Service(service.ServiceID),
 		allowlistManager: allowlistManager,
 		blocklistManager: blocklistManager,
 		ctx:              ctx,
@@ -60,31 +89,65 @@ func NewUDPProxy(service *config.ProtectedServiceConfig, allowlistManager *ipall
 		sessions:         make(map[string]*udpSession),
 		sessionTimeout:   sessionTimeout,
 		maxSessions:      int32(maxSessions),
+		bufferSize:       defaultUDPBufferSize,
+		circuitBreaker:   newServiceCircuitBreaker(service),
+		backend:          newServiceBackendResolver(service),
+		responseTimeout:  udpResponseTimeout(service),
+		relay:            newUDPRelay(service),
+		publicQuery:      newPublicQuery(service),
+		datagramLimit:    newDatagramLimit(service),
+		sourcePorts:      newSourcePorts(service),
 	}
 }
 
+// udpResponseTimeout returns how long a new session may wait for the backend's first answer
+func udpResponseTimeout(service *config.ProtectedServiceConfig) time.Duration {
+	if service.CircuitBreaker == nil {
+		return 0
+	}
+	return time.Duration(service.CircuitBreaker.UDPResponseTimeoutSeconds) * time.Second
+}
+
 // Start begins listening and forwarding UDP packets
 func (p *UDPProxy) Start() error {
-	listenAddr := fmt.Sprintf(":%d", p.service.ProxyListenPortStart)
+	listenAddr := net.JoinHostPort(p.service.ProxyListenAddress, strconv.Itoa(p.service.ProxyListenPortStart))
+	network := config.ListenNetwork("udp", p.service.ProxyListenAddress)
 
-	addr, err := net.ResolveUDPAddr("udp", listenAddr)
-	if err != nil {
-		return fmt.Errorf("failed to resolve UDP address %s: %w", listenAddr, err)
-	}
-
-	conn, err := net.ListenUDP("udp", addr)
+	conn, err := upgrade.ListenUDP(network, listenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to start UDP listener on %s: %w", listenAddr, err)
 	}
 
 	p.conn = conn
+	p.handoverKey = upgrade.ListenerKey(network, listenAddr)
+
+	// Continue sessions handed over by the previous process
+	for _, inherited := range upgrade.InheritedUDPSessions(p.handoverKey) {
+		if err := p.adoptSession(inherited); err != nil {
+			p.log.Warn().
+				Err(err).
+				Str("client_addr", inherited.ClientAddr).
+				Str("service", p.service.ServiceName).
+				Msg("Failed to adopt inherited UDP session")
+		}
+	}
+	upgrade.RegisterUDPExporter(p.handoverKey, p)
 
-	log.Info().
+	p.log.Info().
 		Str("service_id", p.service.ServiceID).
 		Int("proxy_port", p.service.ProxyListenPortStart).
 		Str("backend", fmt.Sprintf("%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort)).
 		Msg("Starting UDP proxy listener")
 
+	if p.publicQuery != nil {
+		p.publicQuery.limiter.StartCleanup(time.Minute, p.ctx.Done())
+	}
+	if p.datagramLimit != nil && p.datagramLimit.dontFragment && !dontFragmentSupported {
+		p.log.Warn().
+			Str("service", p.service.ServiceName).
+			Msg("udp_datagram_limit.dont_fragment is only supported on Linux, datagrams may be fragmented")
+	}
+
 	p.wg.Add(2)
 	go p.receiveLoop()
 	go p.cleanupLoop()
@@ -96,86 +159,132 @@ func (p *UDPProxy) Start() error {
 func (p *UDPProxy) receiveLoop() {
 	defer p.wg.Done()
 
-	bufPtr := getUDPBuffer()
-	defer putUDPBuffer(bufPtr)
+	bufPtr := getBuffer(p.bufferSize)
+	defer putBuffer(bufPtr)
 	buffer := *bufPtr
 
 	for {
-		select {
-		case <-p.ctx.Done():
+		// The gate is taken for writing while sessions are frozen for an upgrade
+		p.readGate.RLock()
+		stop := p.receivePacket(buffer)
+		p.readGate.RUnlock()
+		if stop {
 			return
-		default:
 		}
+	}
+}
 
-		// Set read deadline to allow context cancellation
-		p.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+// receivePacket reads and forwards a single client packet
+// Returns true when the proxy is shutting down
+func (p *UDPProxy) receivePacket(buffer []byte) bool {
+	select {
+	case <-p.ctx.Done():
+		return true
+	default:
+	}
 
-		n, clientAddr, err := p.conn.ReadFromUDP(buffer)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
-			}
-			select {
-			case <-p.ctx.Done():
-				return
-			default:
-				log.Error().Err(err).Msg("Failed to read UDP packet")
-				continue
-			}
-		}
+	// Set read deadline to allow context cancellation
+	p.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 
-		// Extract client IP
-		clientIP, ok := parseIPFromAddr(clientAddr.IP.String())
-		if !ok {
-			log.Warn().
-				Str("addr", clientAddr.IP.String()).
-				Msg("Failed to parse client IP")
-			continue
+	n, clientAddr, err := p.conn.ReadFromUDP(buffer)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return false
+		}
+		select {
+		case <-p.ctx.Done():
+			return true
+		default:
+			p.log.Error().Err(err).Msg("Failed to read UDP packet")
+			return false
 		}
+	}
 
-		// HIGHEST PRIORITY: Check IP blocklist first
-		if blocked, blockReason := p.blocklistManager.IsIPBlocked(net.ParseIP(clientIP.String())); blocked {
-			log.Warn().
+	// Extract client IP
+	clientIP, ok := parseIPFromAddr(clientAddr.IP.String())
+	if !ok {
+		p.log.Warn().
+			Str("addr", clientAddr.IP.String()).
+			Msg("Failed to parse client IP")
+		return false
+	}
+
+	// HIGHEST PRIORITY: Check IP blocklist first
+	if blocked, blockReason := p.blocklistManager.IsIPBlocked(net.ParseIP(clientIP.String())); blocked {
+		if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "blocked") {
+			p.log.Warn().
 				Str("client_ip", clientIP.String()).
 				Str("service", p.service.ServiceName).
 				Str("reason", blockReason).
 				Msg("UDP packet denied: IP is blocked")
-			continue
 		}
+		p.rejectUDP(clientAddr, buffer[:n])
+		return false
+	}
 
-		// Check IP allowlist
-		allowed, reason := p.allowlistManager.IsIPAllowed(clientIP)
-		if !allowed {
-			log.Warn().
+	// Check IP allowlist
+	allowed, reason := checkAccess(p.allowlistManager, p.access, clientIP, p.service.ServiceID)
+	// Server-list queries may pass without an allowlist entry, gameplay packets may not
+	public := !allowed && p.publicQuery.admit(clientIP.String(), buffer[:n])
+	if !allowed && !public {
+		if deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "not_allowed") {
+			p.log.Warn().
 				Str("client_ip", clientIP.String()).
 				Str("service", p.service.ServiceName).
 				Str("reason", reason).
 				Msg("UDP packet denied: IP not in allowlist")
-			continue
 		}
+		p.rejectUDP(clientAddr, buffer[:n])
+		return false
+	}
 
-		// Track packet
-		p.mu.Lock()
-		p.packetCount++
-		p.mu.Unlock()
+	// Track packet
+	p.mu.Lock()
+	p.packetCount++
+	p.mu.Unlock()
 
-		// Get or create session
-		session, err := p.getOrCreateSession(clientAddr)
-		if err != nil {
-			log.Warn().
-				Err(err).
-				Str("client_addr", clientAddr.String()).
-				Str("service", p.service.ServiceName).
-				Msg("Failed to create UDP session (may have hit session limit)")
-			continue
-		}
+	// Datagrams over the service's size limit are dropped, cut to the limit or only logged
+	size, forward, oversized := p.datagramLimit.apply(n)
+	if oversized && deniedLogs.Allow(clientIP.String(), p.service.ServiceName, "oversized") {
+		p.log.Warn().
+			Str("client_ip", clientIP.String()).
+			Str("service", p.service.ServiceName).
+			Int("size", n).
+			Int("max_size", p.datagramLimit.maxSize).
+			Str("action", p.datagramLimit.action).
+			Msg("Oversized UDP datagram")
+	}
+	if !forward {
+		return false
+	}
+	n = size
 
-		// Forward packet to backend
-		// CRITICAL: Copy the data to avoid race condition since buffer is reused
+	// LAN discovery packets go out as broadcast or multicast instead of to the backend
+	if p.relay != nil && !public && p.relay.prefixes.match(buffer[:n]) {
 		packetData := make([]byte, n)
 		copy(packetData, buffer[:n])
-		go p.forwardToBackend(session, packetData)
+		p.relayDiscovery(clientAddr, packetData)
+		return false
+	}
+
+	// Get or create session
+	session, err := p.getOrCreateSession(clientAddr)
+	if err != nil {
+		p.log.Warn().
+			Err(err).
+			Str("client_addr", clientAddr.String()).
+			Str("service", p.service.ServiceName).
+			Msg("Failed to create UDP session (may have hit session limit)")
+		return false
 	}
+	session.quality.observeClient(time.Now())
+
+	// Forward packet to backend
+	// CRITICAL: Copy the data to avoid race condition since buffer is reused
+	packetData := make([]byte, n)
+	copy(packetData, buffer[:n])
+	go p.forwardToBackend(session, packetData)
+	return false
 }
 
 // getOrCreateSession retrieves existing session or creates new one
@@ -210,38 +319,105 @@ func (p *UDPProxy) getOrCreateSession(clientAddr *net.UDPAddr) (*udpSession, err
 		return nil, fmt.Errorf("too many sessions from IP %s (%d active)", clientIP, ipSessionCount)
 	}
 
+	// Shared per-IP new-connection rate limit
+	if !allowNewConnection(p.connRate, clientIP, p.service.ServiceName) {
+		return nil, fmt.Errorf("connection rate limit exceeded for IP %s", clientIP)
+	}
+
+	// Limit sessions per portal user across all of the user's IPs
+	userKey := p.userLimitKey(clientAddr)
+	if userKey != "" {
+		p.sessionsMu.RLock()
+		userSessionCount := 0
+		for _, s := range p.sessions {
+			if s.userKey == userKey {
+				userSessionCount++
+			}
+		}
+		p.sessionsMu.RUnlock()
+
+		if userSessionCount >= p.maxUserSessions {
+			return nil, fmt.Errorf("per-user session limit reached for IP %s (%d active)", clientIP, userSessionCount)
+		}
+	}
+
+	// Don't spend session slots on a backend that keeps failing
+	if !p.circuitBreaker.Allow() {
+		return nil, fmt.Errorf("circuit breaker is %s", p.circuitBreaker.GetState())
+	}
+
+	// A stopped backend container is started in the background; the client's retries get through once it runs
+	if c := p.backend.container; c != nil && !c.running.Load() {
+		c.startAsync(p.ctx, func() { p.backend.refresh() })
+		return nil, fmt.Errorf("backend container %s is starting", c.cfg.ContainerName)
+	}
+
 	// Create new session
-	backendAddress := fmt.Sprintf("%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort)
-	backendAddr, err := net.ResolveUDPAddr("udp", backendAddress)
+	backendAddr, err := net.ResolveUDPAddr("udp", p.backend.address())
 	if err != nil {
+		p.circuitBreaker.RecordFailure()
 		return nil, fmt.Errorf("failed to resolve backend address: %w", err)
 	}
 
-	backendConn, err := net.DialUDP("udp", nil, backendAddr)
+	localAddr, err := p.backend.source.udpAddr(backendAddr)
+	if err != nil {
+		p.circuitBreaker.RecordFailure()
+		return nil, fmt.Errorf("failed to pick source address: %w", err)
+	}
+
+	bindAddr, pinned := p.sourcePorts.localAddr(clientAddr, localAddr)
+	backendConn, err := net.DialUDP("udp", bindAddr, backendAddr)
+	if err != nil && pinned {
+		// Another socket took the client's port since its last session
+		p.log.Debug().
+			Err(err).
+			Str("client_addr", clientAddr.String()).
+			Int("source_port", bindAddr.Port).
+			Msg("Pinned UDP source port unavailable, using a new one")
+		backendConn, err = net.DialUDP("udp", localAddr, backendAddr)
+	}
 	if err != nil {
+		p.circuitBreaker.RecordFailure()
 		return nil, fmt.Errorf("failed to connect to backend: %w", err)
 	}
+	p.sourcePorts.remember(clientAddr, backendConn)
+	if p.datagramLimit != nil && p.datagramLimit.dontFragment && dontFragmentSupported {
+		if err := setDontFragment(backendConn); err != nil {
+			p.log.Warn().
+				Err(err).
+				Str("service", p.service.ServiceName).
+				Msg("Failed to set don't-fragment on backend socket")
+		}
+	}
+	p.backend.waker.nudge(p.backend.source)
 
 	sessionCtx, sessionCancel := context.WithCancel(p.ctx)
 	session = &udpSession{
+		id:                nextConnectionID("udp"),
 		clientAddr:        clientAddr,
+		userKey:           userKey,
+		createdAt:         time.Now(),
 		backendAddr:       backendAddr,
 		backendConn:       backendConn,
 		lastActivity:      time.Now(),
+		quality:           newUDPQuality(time.Now()),
 		maxSpoofAttempts:  3,
 		ctx:               sessionCtx,
 		cancel:            sessionCancel,
+		done:              make(chan struct{}),
 	}
 
 	p.sessionsMu.Lock()
 	p.sessions[key] = session
 	p.sessionsMu.Unlock()
 
-	log.Debug().
+	p.log.Debug().
 		Str("client_addr", clientAddr.String()).
 		Str("backend_addr", backendAddr.String()).
 		Msg("Created new UDP session")
 
+	p.history.RecordConnection(p.service.ServiceID, clientIP)
+
 	// Start goroutine to receive from backend
 	go p.receiveFromBackend(session)
 
@@ -259,7 +435,7 @@ func (p *UDPProxy) forwardToBackend(session *udpSession, data []byte) {
 	session.mu.Unlock()
 
 	if conn == nil {
-		log.Error().
+		p.log.Error().
 			Str("client_addr", session.clientAddr.String()).
 			Msg("Backend connection is nil, cannot forward packet")
 		return
@@ -267,9 +443,22 @@ func (p *UDPProxy) forwardToBackend(session *udpSession, data []byte) {
 
 	n, err := conn.Write(data)
 	if err != nil {
-		log.Error().
+		// Larger than the path MTU with don't-fragment set; dropped like an oversized datagram
+		if p.datagramLimit.rejectedTooBig(err) {
+			p.log.Debug().
+				Str("client_addr", session.clientAddr.String()).
+				Int("size", len(data)).
+				Msg("UDP datagram exceeds the path MTU, dropped")
+			return
+		}
+		// A closed session is not the backend's fault
+		if !errors.Is(err, net.ErrClosed) {
+			p.circuitBreaker.RecordFailure()
+		}
+		p.log.Error().
 			Err(err).
 			Str("client_addr", session.clientAddr.String()).
+			Str("circuit_state", p.circuitBreaker.GetState().String()).
 			Msg("Failed to forward UDP packet to backend")
 		return
 	}
@@ -277,14 +466,37 @@ func (p *UDPProxy) forwardToBackend(session *udpSession, data []byte) {
 	// Track stats atomically
 	atomic.AddInt64(&session.packetsReceived, 1)
 	atomic.AddInt64(&session.bytesReceived, int64(n))
+	p.history.RecordTraffic(p.service.ServiceID, session.clientAddr.IP.String(), int64(n), 0)
+}
+
+// observeSessionEnd records the active lifetime of a session, from creation to its last packet
+// Sessions frozen for a handover live on in the next process and are not recorded
+func (p *UDPProxy) observeSessionEnd(session *udpSession) {
+	if atomic.LoadInt32(&p.frozen) == 1 {
+		return
+	}
+	session.mu.Lock()
+	lifetime := session.lastActivity.Sub(session.createdAt)
+	session.mu.Unlock()
+	p.latency.observeDuration(lifetime)
 }
 
 // receiveFromBackend receives responses from the backend and forwards to client
 func (p *UDPProxy) receiveFromBackend(session *udpSession) {
-	bufPtr := getUDPBuffer()
-	defer putUDPBuffer(bufPtr)
+	session.mu.Lock()
+	done := session.done
+	session.mu.Unlock()
+	defer close(done)
+	defer p.observeSessionEnd(session)
+
+	bufPtr := getBuffer(p.bufferSize)
+	defer putBuffer(bufPtr)
 	buffer := *bufPtr
 
+	// Until the backend answers once, wait only responseTimeout before counting the silence as a failure
+	answered := false
+	silenceChecked := p.responseTimeout <= 0
+
 	for {
 		// Check session context first for immediate cancellation
 		select {
@@ -296,10 +508,15 @@ func (p *UDPProxy) receiveFromBackend(session *udpSession) {
 		session.mu.Lock()
 		conn := session.backendConn
 		expectedBackend := session.backendAddr
+		lastActivity := session.lastActivity
 		session.mu.Unlock()
 
 		// Set read deadline to allow context cancellation
-		conn.SetReadDeadline(time.Now().Add(p.sessionTimeout))
+		readTimeout := p.sessionTimeout
+		if !answered && !silenceChecked && p.responseTimeout < readTimeout {
+			readTimeout = p.responseTimeout
+		}
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
 
 		n, addr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
@@ -309,19 +526,53 @@ func (p *UDPProxy) receiveFromBackend(session *udpSession) {
 				case <-session.ctx.Done():
 					return
 				default:
-					// Session timed out naturally, will be cleaned up
-					return
 				}
+
+				if !answered && !silenceChecked {
+					silenceChecked = true
+					if atomic.LoadInt64(&session.packetsReceived) > 0 {
+						p.circuitBreaker.RecordFailure()
+						p.log.Warn().
+							Str("client_addr", session.clientAddr.String()).
+							Str("service", p.service.ServiceName).
+							Dur("response_timeout", p.responseTimeout).
+							Str("circuit_state", p.circuitBreaker.GetState().String()).
+							Msg("UDP backend did not answer a new session")
+					}
+					if time.Since(lastActivity) < p.sessionTimeout {
+						continue
+					}
+				}
+				// Session timed out naturally, will be cleaned up
+				return
 			}
 			// Check if cancelled
 			select {
 			case <-session.ctx.Done():
 				return
 			default:
-				log.Error().
+				// Connected UDP sockets report ICMP port/host unreachable here; the backend is down,
+				// so free the session slot instead of waiting for the session timeout
+				p.circuitBreaker.RecordFailure()
+				p.log.Error().
 					Err(err).
 					Str("client_addr", session.clientAddr.String()).
+					Str("circuit_state", p.circuitBreaker.GetState().String()).
 					Msg("Failed to read from backend")
+
+				session.cancel()
+				session.mu.Lock()
+				session.backendConn.Close()
+				session.mu.Unlock()
+
+				p.sessionsMu.Lock()
+				if p.sessions[session.clientAddr.String()] == session {
+					delete(p.sessions, session.clientAddr.String())
+				}
+				p.sessionsMu.Unlock()
+
+				// The backend may have moved; new sessions use the fresh address
+				p.backend.refresh()
 				return
 			}
 		}
@@ -331,7 +582,7 @@ func (p *UDPProxy) receiveFromBackend(session *udpSession) {
 			// Increment spoof counter atomically
 			attempts := atomic.AddInt32(&session.spoofAttempts, 1)
 
-			log.Warn().
+			p.log.Warn().
 				Str("expected_backend", expectedBackend.String()).
 				Str("actual_source", addr.String()).
 				Str("client_addr", session.clientAddr.String()).
@@ -341,7 +592,7 @@ func (p *UDPProxy) receiveFromBackend(session *udpSession) {
 
 			// Terminate session after max spoof attempts to prevent amplification
 			if attempts >= session.maxSpoofAttempts {
-				log.Error().
+				p.log.Error().
 					Str("client_addr", session.clientAddr.String()).
 					Str("service", p.service.ServiceName).
 					Int32("spoof_attempts", attempts).
@@ -370,6 +621,13 @@ func (p *UDPProxy) receiveFromBackend(session *udpSession) {
 		session.mu.Lock()
 		session.lastActivity = time.Now()
 		session.mu.Unlock()
+		session.quality.observeBackend(time.Now())
+
+		if !answered {
+			answered = true
+			p.backend.waker.markAlive()
+			p.circuitBreaker.RecordSuccess()
+		}
 
 		// Copy response data to avoid buffer reuse race
 		responseData := make([]byte, n)
@@ -378,7 +636,7 @@ func (p *UDPProxy) receiveFromBackend(session *udpSession) {
 		// Forward response to client
 		written, err := p.conn.WriteToUDP(responseData, session.clientAddr)
 		if err != nil {
-			log.Error().
+			p.log.Error().
 				Err(err).
 				Str("client_addr", session.clientAddr.String()).
 				Msg("Failed to forward UDP packet to client")
@@ -386,6 +644,7 @@ func (p *UDPProxy) receiveFromBackend(session *udpSession) {
 			// Track stats
 			atomic.AddInt64(&session.packetsSent, 1)
 			atomic.AddInt64(&session.bytesSent, int64(written))
+			p.history.RecordTraffic(p.service.ServiceID, session.clientAddr.IP.String(), 0, int64(written))
 		}
 	}
 }
@@ -402,7 +661,11 @@ func (p *UDPProxy) cleanupLoop() {
 		case <-p.ctx.Done():
 			return
 		case <-ticker.C:
-			p.cleanupExpiredSessions()
+			// Frozen sessions belong to the upgraded process
+			if atomic.LoadInt32(&p.frozen) == 0 {
+				p.cleanupExpiredSessions()
+				p.pruneSourcePorts()
+			}
 		}
 	}
 }
@@ -436,13 +699,14 @@ func (p *UDPProxy) cleanupExpiredSessions() {
 				session.cancel()
 			}
 			
-			// Close connection after cancelling context
+			// Close connection after cancelling context, keeping its port for the client's next session
 			session.mu.Lock()
+			p.sourcePorts.remember(session.clientAddr, session.backendConn)
 			session.backendConn.Close()
 			session.mu.Unlock()
 			
 			delete(p.sessions, key)
-			log.Debug().
+			p.log.Debug().
 				Str("client_addr", key).
 				Str("service", p.service.ServiceName).
 				Msg("Cleaned up expired UDP session")
@@ -450,7 +714,7 @@ func (p *UDPProxy) cleanupExpiredSessions() {
 	}
 	p.sessionsMu.Unlock()
 
-	log.Debug().
+	p.log.Debug().
 		Int("expired_count", len(expired)).
 		Str("service", p.service.ServiceName).
 		Msg("UDP session cleanup completed")
@@ -472,6 +736,7 @@ func (p *UDPProxy) TerminateSessionsByIP(ipAddr string) int {
 		}
 	}
 	p.sessionsMu.Unlock()
+	p.sourcePorts.forgetIP(ipAddr)
 	
 	// Second pass: cleanup sessions without holding lock
 	for _, session := range sessionsToTerminate {
@@ -487,7 +752,7 @@ func (p *UDPProxy) TerminateSessionsByIP(ipAddr string) int {
 	}
 
 	if terminated > 0 {
-		log.Info().
+		p.log.Info().
 			Str("ip_address", ipAddr).
 			Str("service", p.service.ServiceName).
 			Int("sessions_terminated", terminated).
@@ -513,10 +778,13 @@ func (p *UDPProxy) GetStatsByIP(clientIP string) map[string]interface{} {
 
 	sessionCount := 0
 	var totalPacketsRx, totalPacketsTx, totalBytesRx, totalBytesTx int64
+	var quality UDPQuality
+	now := time.Now()
 
 	for _, session := range p.sessions {
 		if session.clientAddr.IP.String() == clientIP {
 			sessionCount++
+			quality = quality.worst(session.quality.snapshot(now))
 			totalPacketsRx += atomic.LoadInt64(&session.packetsReceived)
 			totalPacketsTx += atomic.LoadInt64(&session.packetsSent)
 			totalBytesRx += atomic.LoadInt64(&session.bytesReceived)
@@ -529,17 +797,95 @@ func (p *UDPProxy) GetStatsByIP(clientIP string) map[string]interface{} {
 	stats["packets_sent"] = totalPacketsTx
 	stats["bytes_received"] = totalBytesRx
 	stats["bytes_sent"] = totalBytesTx
+	if sessionCount > 0 {
+		stats["quality"] = quality // Worst session of the IP
+	}
 
 	return stats
 }
 
+// ListConnectionsByIP returns the active sessions from a specific client IP
+func (p *UDPProxy) ListConnectionsByIP(clientIP string) []ConnectionInfo {
+	p.sessionsMu.RLock()
+	defer p.sessionsMu.RUnlock()
+
+	infos := []ConnectionInfo{}
+	for _, session := range p.sessions {
+		if session.clientAddr.IP.String() != clientIP {
+			continue
+		}
+		infos = append(infos, p.connectionInfo(session))
+	}
+	return infos
+}
+
+// ListConnections returns the active sessions of all client IPs
+func (p *UDPProxy) ListConnections() []ConnectionInfo {
+	p.sessionsMu.RLock()
+	defer p.sessionsMu.RUnlock()
+
+	infos := make([]ConnectionInfo, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		infos = append(infos, p.connectionInfo(session))
+	}
+	return infos
+}
+
+// connectionInfo describes a session with its current counters
+func (p *UDPProxy) connectionInfo(session *udpSession) ConnectionInfo {
+	info := newConnectionInfo(session.id, p.service, "udp", session.clientAddr.String(), session.createdAt)
+	info.PacketsReceived = atomic.LoadInt64(&session.packetsReceived)
+	info.PacketsSent = atomic.LoadInt64(&session.packetsSent)
+	info.BytesReceived = atomic.LoadInt64(&session.bytesReceived)
+	info.BytesSent = atomic.LoadInt64(&session.bytesSent)
+	return info
+}
+
+// TerminateConnection ends a single session by ID
+// Returns false if the proxy has no such session
+func (p *UDPProxy) TerminateConnection(connID string) bool {
+	var target *udpSession
+	p.sessionsMu.Lock()
+	for key, session := range p.sessions {
+		if session.id == connID {
+			target = session
+			delete(p.sessions, key)
+			break
+		}
+	}
+	p.sessionsMu.Unlock()
+
+	if target == nil {
+		return false
+	}
+
+	// Cancel context to stop receiveFromBackend goroutine, then close the backend socket
+	target.cancel()
+	target.mu.Lock()
+	target.backendConn.Close()
+	target.mu.Unlock()
+
+	p.log.Info().
+		Str("conn_id", connID).
+		Str("client_addr", target.clientAddr.String()).
+		Str("service", p.service.ServiceName).
+		Msg("Terminated UDP session")
+	return true
+}
+
 // Stop gracefully shuts down the proxy
 func (p *UDPProxy) Stop() error {
-	log.Info().
+	p.log.Info().
 		Str("service", p.service.ServiceName).
 		Msg("Stopping UDP proxy")
 
 	p.cancel()
+	upgrade.UnregisterUDPExporter(p.handoverKey, p)
+
+	// Let the receive loop observe the cancellation if sessions were handed over
+	if atomic.CompareAndSwapInt32(&p.frozen, 1, 0) {
+		p.readGate.Unlock()
+	}
 
 	if p.conn != nil {
 		p.conn.Close()
@@ -555,7 +901,7 @@ func (p *UDPProxy) Stop() error {
 
 	p.wg.Wait()
 
-	log.Info().
+	p.log.Info().
 		Str("service", p.service.ServiceName).
 		Msg("UDP proxy stopped")
 
@@ -568,24 +914,180 @@ func (p *UDPProxy) GetStats() map[string]interface{} {
 	packetCount := p.packetCount
 	p.mu.Unlock()
 
+	// Sessions are keyed by ip:port; report each client IP once, like the TCP proxy
 	p.sessionsMu.RLock()
 	sessionCount := len(p.sessions)
 	clientIPs := make([]string, 0, sessionCount)
-	for sessionKey := range p.sessions {
-		clientIPs = append(clientIPs, sessionKey)
+	seen := make(map[string]bool, sessionCount)
+	for _, session := range p.sessions {
+		ip := session.clientAddr.IP.String()
+		if !seen[ip] {
+			seen[ip] = true
+			clientIPs = append(clientIPs, ip)
+		}
+	}
+	p.sessionsMu.RUnlock()
+
+	stats := map[string]interface{}{
+		"total_packets":      packetCount,
+		"active_sessions":    sessionCount,
+		"client_ips":         clientIPs,
+		"max_sessions":       p.maxSessions,
+		"service_name":       p.service.ServiceName,
+		"listen_port":        p.service.ProxyListenPortStart,
+		"backend_addr":       fmt.Sprintf("%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort),
+		"session_timeout":    p.sessionTimeout.String(),
+		"circuit_breaker":    p.circuitBreaker.GetStats(),
+		"backend_resolution": p.backend.stats(),
+	}
+	if p.service.ProxyListenAddress != "" {
+		stats["listen_address"] = p.service.ProxyListenAddress
+	}
+	if p.latency != nil {
+		stats["latency"] = p.latency.summary()
+	}
+	if p.relay != nil {
+		stats["udp_relay"] = p.relay.stats()
+	}
+	if p.publicQuery != nil {
+		stats["public_query"] = p.publicQuery.stats()
+	}
+	if p.datagramLimit != nil {
+		stats["datagram_limit"] = p.datagramLimit.stats()
+	}
+	if p.sourcePorts != nil {
+		stats["pinned_source_ports"] = p.sourcePorts.count()
+	}
+	return stats
+}
+
+// FreezeSessions stops forwarding and exports all sessions for a binary upgrade
+// Packets arriving meanwhile queue in the socket buffers for the new process
+func (p *UDPProxy) FreezeSessions() []upgrade.UDPSession {
+	p.conn.SetReadDeadline(time.Now())
+	p.readGate.Lock()
+	atomic.StoreInt32(&p.frozen, 1)
+
+	p.sessionsMu.RLock()
+	sessions := make([]*udpSession, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		sessions = append(sessions, session)
+	}
+	p.sessionsMu.RUnlock()
+
+	exported := make([]upgrade.UDPSession, 0, len(sessions))
+	for _, session := range sessions {
+		session.mu.Lock()
+		conn := session.backendConn
+		cancel := session.cancel
+		done := session.done
+		session.mu.Unlock()
+
+		// Stop the backend reader so only the new process reads this socket
+		cancel()
+		conn.SetReadDeadline(time.Now())
+		<-done
+
+		file, err := conn.File()
+		if err != nil {
+			p.log.Warn().
+				Err(err).
+				Str("client_addr", session.clientAddr.String()).
+				Str("service", p.service.ServiceName).
+				Msg("Failed to export UDP session for upgrade")
+			continue
+		}
+
+		exported = append(exported, upgrade.UDPSession{
+			ListenerKey: p.handoverKey,
+			ClientAddr:  session.clientAddr.String(),
+			BackendAddr: session.backendAddr.String(),
+			File:        file,
+		})
+	}
+
+	return exported
+}
+
+// ResumeSessions restarts forwarding after a failed upgrade
+func (p *UDPProxy) ResumeSessions() {
+	if !atomic.CompareAndSwapInt32(&p.frozen, 1, 0) {
+		return
+	}
+
+	p.sessionsMu.RLock()
+	for _, session := range p.sessions {
+		sessionCtx, sessionCancel := context.WithCancel(p.ctx)
+		session.mu.Lock()
+		session.ctx = sessionCtx
+		session.cancel = sessionCancel
+		session.done = make(chan struct{})
+		session.mu.Unlock()
+
+		go p.receiveFromBackend(session)
 	}
 	p.sessionsMu.RUnlock()
 
-	return map[string]interface{}{
-		"total_packets":   packetCount,
-		"active_sessions": sessionCount,
-		"client_ips":      clientIPs,
-		"max_sessions":    p.maxSessions,
-		"service_name":    p.service.ServiceName,
-		"listen_port":     p.service.ProxyListenPortStart,
-		"backend_addr":    fmt.Sprintf("%s:%d", p.service.BackendTargetHost, p.service.BackendTargetPort),
-		"session_timeout": p.sessionTimeout.String(),
+	p.readGate.Unlock()
+}
+
+// adoptSession recreates a session handed over by the previous process
+func (p *UDPProxy) adoptSession(inherited upgrade.UDPSession) error {
+	fileConn, err := net.FileConn(inherited.File)
+	inherited.File.Close()
+	if err != nil {
+		return err
+	}
+
+	backendConn, ok := fileConn.(*net.UDPConn)
+	if !ok {
+		fileConn.Close()
+		return fmt.Errorf("inherited backend socket is not UDP")
+	}
+
+	clientAddr, err := net.ResolveUDPAddr("udp", inherited.ClientAddr)
+	if err != nil {
+		backendConn.Close()
+		return err
+	}
+	backendAddr, err := net.ResolveUDPAddr("udp", inherited.BackendAddr)
+	if err != nil {
+		backendConn.Close()
+		return err
+	}
+
+	sessionCtx, sessionCancel := context.WithCancel(p.ctx)
+	session := &udpSession{
+		id:               nextConnectionID("udp"),
+		clientAddr:       clientAddr,
+		userKey:          p.userLimitKey(clientAddr),
+		createdAt:        time.Now(),
+		backendAddr:      backendAddr,
+		backendConn:      backendConn,
+		lastActivity:     time.Now(),
+		quality:          newUDPQuality(time.Now()),
+		maxSpoofAttempts: 3,
+		ctx:              sessionCtx,
+		cancel:           sessionCancel,
+		done:             make(chan struct{}),
+	}
+
+	p.sessionsMu.Lock()
+	p.sessions[clientAddr.String()] = session
+	p.sessionsMu.Unlock()
+	p.sourcePorts.remember(clientAddr, backendConn)
+
+	go p.receiveFromBackend(session)
+	return nil
+}
+
+// userLimitKey returns the per-user limit key for a client address, or "" when not limited
+func (p *UDPProxy) userLimitKey(clientAddr *net.UDPAddr) string {
+	clientIP, ok := parseIPFromAddr(clientAddr.IP.String())
+	if !ok {
+		return ""
 	}
+	return userLimitKey(p.identity, p.maxUserSessions, clientIP)
 }
 
 // TerminateConnectionsByIP forcefully closes all active UDP sessions from a specific IP
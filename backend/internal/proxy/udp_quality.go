@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// jitterSilenceThreshold separates jitter from silence: longer gaps between packets count as silence only
+const jitterSilenceThreshold = time.Second
+
+// udpQuality tracks the packet timing of a UDP session in both directions, as seen by the portal
+// Jitter and gaps on the client side point at the client's network, on the backend side at the backend
+type udpQuality struct {
+	mu          sync.Mutex
+	createdAt   time.Time
+	client      flowTiming // Packets from the client
+	backend     flowTiming // Packets from the backend
+	awaitingAt  time.Time  // First client packet not yet followed by a backend packet; zero = none
+	responseAvg float64    // Smoothed time from a client packet to the backend's next packet, in nanoseconds
+	responses   int64
+}
+
+// flowTiming tracks the inter-arrival times of one direction of a session
+type flowTiming struct {
+	last   time.Time     // Arrival of the latest packet; zero = none yet
+	gap    time.Duration // Gap before the latest packet
+	jitter float64       // Smoothed inter-arrival variation in nanoseconds (RFC 3550 estimator)
+	maxGap time.Duration // Longest gap between two packets
+}
+
+// UDPFlowQuality describes the timing of one direction of UDP traffic
+type UDPFlowQuality struct {
+	JitterMs     float64 `json:"jitter_ms"`
+	MaxSilenceMs int64   `json:"max_silence_ms"` // Longest gap between two packets
+	SilentMs     int64   `json:"silent_ms"`      // Time since the latest packet (or since the session started)
+}
+
+// UDPQuality is the approximate timing quality of UDP sessions
+type UDPQuality struct {
+	Client     UDPFlowQuality `json:"client"`
+	Backend    UDPFlowQuality `json:"backend"`
+	ResponseMs float64        `json:"response_ms"` // Smoothed time the backend takes to answer a client packet; 0 = no answers yet
+}
+
+func newUDPQuality(createdAt time.Time) *udpQuality {
+	return &udpQuality{createdAt: createdAt}
+}
+
+// observeClient records a packet from the client
+func (q *udpQuality) observeClient(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.client.observe(now)
+	if q.awaitingAt.IsZero() {
+		q.awaitingAt = now
+	}
+}
+
+// observeBackend records a packet from the backend
+func (q *udpQuality) observeBackend(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.backend.observe(now)
+	if !q.awaitingAt.IsZero() {
+		response := float64(now.Sub(q.awaitingAt))
+		if q.responses == 0 {
+			q.responseAvg = response
+		} else {
+			q.responseAvg += (response - q.responseAvg) / 8
+		}
+		q.responses++
+		q.awaitingAt = time.Time{}
+	}
+}
+
+// observe records the arrival of a packet
+func (f *flowTiming) observe(now time.Time) {
+	if f.last.IsZero() {
+		f.last = now
+		return
+	}
+
+	gap := now.Sub(f.last)
+	f.last = now
+	if gap > f.maxGap {
+		f.maxGap = gap
+	}
+	if gap > jitterSilenceThreshold {
+		f.gap = 0 // The next gap starts a new run of traffic
+		return
+	}
+	if f.gap > 0 {
+		variation := gap - f.gap
+		if variation < 0 {
+			variation = -variation
+		}
+		f.jitter += (float64(variation) - f.jitter) / 16
+	}
+	f.gap = gap
+}
+
+// snapshot returns the current quality of the session
+func (q *udpQuality) snapshot(now time.Time) UDPQuality {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return UDPQuality{
+		Client:     q.client.quality(now, q.createdAt),
+		Backend:    q.backend.quality(now, q.createdAt),
+		ResponseMs: roundMs(q.responseAvg),
+	}
+}
+
+func (f *flowTiming) quality(now, createdAt time.Time) UDPFlowQuality {
+	since := f.last
+	if since.IsZero() {
+		since = createdAt
+	}
+	return UDPFlowQuality{
+		JitterMs:     roundMs(f.jitter),
+		MaxSilenceMs: f.maxGap.Milliseconds(),
+		SilentMs:     now.Sub(since).Milliseconds(),
+	}
+}
+
+// roundMs converts nanoseconds to milliseconds with one decimal
+func roundMs(ns float64) float64 {
+	return math.Round(ns/float64(time.Millisecond)*10) / 10
+}
+
+// worst merges the quality of another session, keeping the worse value of each metric
+func (q UDPQuality) worst(other UDPQuality) UDPQuality {
+	q.Client = q.Client.worst(other.Client)
+	q.Backend = q.Backend.worst(other.Backend)
+	q.ResponseMs = max(q.ResponseMs, other.ResponseMs)
+	return q
+}
+
+func (f UDPFlowQuality) worst(other UDPFlowQuality) UDPFlowQuality {
+	return UDPFlowQuality{
+		JitterMs:     max(f.JitterMs, other.JitterMs),
+		MaxSilenceMs: max(f.MaxSilenceMs, other.MaxSilenceMs),
+		SilentMs:     max(f.SilentMs, other.SilentMs),
+	}
+}
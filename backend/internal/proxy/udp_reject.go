@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	icmpQuotedPayload = 64  // Bytes of the denied datagram quoted after its UDP header
+	icmpPerSecond     = 100 // Port unreachable messages sent per second across all services
+)
+
+// icmpSender owns the raw sockets used to answer denied UDP packets with port unreachable
+// The sockets need CAP_NET_RAW; they are opened on first use and a failure is logged once
+type icmpSender struct {
+	once4, once6 sync.Once
+	conn4, conn6 *net.IPConn
+	limiter      *rate.Limiter
+}
+
+var errICMPUnavailable = errors.New("raw ICMP socket unavailable")
+
+var unreachable = &icmpSender{limiter: rate.NewLimiter(icmpPerSecond, icmpPerSecond)}
+
+// rejectUDP answers a denied packet as the service's deny_response.udp_mode says; drop (default) sends nothing
+func (p *UDPProxy) rejectUDP(clientAddr *net.UDPAddr, packet []byte) {
+	if deny := p.service.DenyResponse; deny == nil || deny.UDPMode != "unreachable" {
+		return
+	}
+	if !unreachable.limiter.Allow() {
+		return
+	}
+	if err := unreachable.send(p.conn, clientAddr, packet); err != nil {
+		if deniedLogs.Allow(clientAddr.IP.String(), p.service.ServiceName, "icmp_unreachable") {
+			p.log.Debug().
+				Err(err).
+				Str("client_ip", clientAddr.IP.String()).
+				Str("service", p.service.ServiceName).
+				Msg("Failed to send ICMP port unreachable")
+		}
+	}
+}
+
+// send emulates the kernel's reply to a datagram for a closed port: an ICMP error quoting the
+// datagram's IP and UDP headers, which makes the client's socket fail with "connection refused"
+func (s *icmpSender) send(conn *net.UDPConn, client *net.UDPAddr, packet []byte) error {
+	local := conn.LocalAddr().(*net.UDPAddr)
+	localIP := local.IP
+	if localIP.IsUnspecified() {
+		// Bound to all addresses: the address the client sent to is the one we would answer from
+		ip, err := routeSource(client)
+		if err != nil {
+			return err
+		}
+		localIP = ip
+	}
+
+	quoted := make([]byte, 8, 8+min(len(packet), icmpQuotedPayload))
+	binary.BigEndian.PutUint16(quoted[0:], uint16(client.Port))
+	binary.BigEndian.PutUint16(quoted[2:], uint16(local.Port))
+	binary.BigEndian.PutUint16(quoted[4:], uint16(8+len(packet)))
+	quoted = append(quoted, packet[:min(len(packet), icmpQuotedPayload)]...)
+
+	if clientIP4 := client.IP.To4(); clientIP4 != nil {
+		raw, err := s.socket(&s.once4, &s.conn4, "ip4:icmp")
+		if err != nil {
+			return err
+		}
+		header := make([]byte, 20)
+		header[0] = 0x45 // IPv4, 20 byte header
+		binary.BigEndian.PutUint16(header[2:], uint16(20+8+len(packet)))
+		header[8] = 64 // TTL
+		header[9] = 17 // UDP
+		copy(header[12:], clientIP4)
+		copy(header[16:], localIP.To4())
+		binary.BigEndian.PutUint16(header[10:], checksum(header))
+
+		msg := append([]byte{3, 3, 0, 0, 0, 0, 0, 0}, header...) // Destination unreachable, port unreachable
+		msg = append(msg, quoted...)
+		binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+		_, err = raw.WriteToIP(msg, &net.IPAddr{IP: clientIP4})
+		return err
+	}
+
+	raw, err := s.socket(&s.once6, &s.conn6, "ip6:ipv6-icmp")
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 40)
+	header[0] = 0x60 // IPv6
+	binary.BigEndian.PutUint16(header[4:], uint16(8+len(packet)))
+	header[6] = 17 // UDP
+	header[7] = 64 // Hop limit
+	copy(header[8:], client.IP.To16())
+	copy(header[24:], localIP.To16())
+
+	// The kernel computes ICMPv6 checksums on raw sockets, so it is left zero
+	msg := append([]byte{1, 4, 0, 0, 0, 0, 0, 0}, header...) // Destination unreachable, port unreachable
+	msg = append(msg, quoted...)
+	_, err = raw.WriteToIP(msg, &net.IPAddr{IP: client.IP, Zone: client.Zone})
+	return err
+}
+
+// socket opens a raw ICMP socket on first use; a failure sticks, so it is logged only once
+func (s *icmpSender) socket(once *sync.Once, conn **net.IPConn, network string) (*net.IPConn, error) {
+	once.Do(func() {
+		c, err := net.ListenIP(network, nil)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("network", network).
+				Msg("Cannot open raw ICMP socket (needs CAP_NET_RAW); denied UDP packets are dropped instead")
+			return
+		}
+		*conn = c
+	})
+	if *conn == nil {
+		return nil, errICMPUnavailable
+	}
+	return *conn, nil
+}
+
+// routeSource returns the local address the kernel would use to reach a client
+// Connecting a UDP socket sends nothing, it only picks the route
+func routeSource(client *net.UDPAddr) (net.IP, error) {
+	conn, err := net.DialUDP("udp", nil, client)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// checksum is the Internet checksum (RFC 1071)
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const (
+	defaultRelayReplyWindow = 2 * time.Second
+	maxRelaysInFlight       = 32 // Discovery packets being answered at once per service; more are dropped
+)
+
+// udpRelay re-sends LAN discovery packets as broadcast or multicast on the backend network
+// Discovery by broadcast cannot cross a unicast proxy; each matching packet goes out from a fresh socket,
+// and whatever hosts answer within the reply window are relayed back to the client
+type udpRelay struct {
+	target   *net.UDPAddr
+	prefixes packetPrefixes
+	window   time.Duration
+
+	inFlight atomic.Int32
+	relayed  atomic.Int64
+	replies  atomic.Int64
+	dropped  atomic.Int64
+}
+
+// newUDPRelay builds the relay of a service; nil when udp_relay is not configured
+// The config validator has checked the address and prefixes
+func newUDPRelay(service *config.ProtectedServiceConfig) *udpRelay {
+	rc := service.UDPRelay
+	if rc == nil {
+		return nil
+	}
+
+	port := rc.TargetPort
+	if port == 0 {
+		port = service.BackendTargetPort
+	}
+	r := &udpRelay{
+		target:   &net.UDPAddr{IP: net.ParseIP(rc.TargetAddress), Port: port},
+		prefixes: parsePacketPrefixes(rc.MatchHexPrefixes),
+		window:   time.Duration(rc.ReplyWindowSeconds) * time.Second,
+	}
+	if r.window <= 0 {
+		r.window = defaultRelayReplyWindow
+	}
+	return r
+}
+
+// packetPrefixes selects UDP packets by their leading bytes
+type packetPrefixes [][]byte
+
+// parsePacketPrefixes decodes hex prefixes; the config validator has rejected invalid ones
+func parsePacketPrefixes(hexPrefixes []string) packetPrefixes {
+	var prefixes packetPrefixes
+	for _, prefix := range hexPrefixes {
+		if b, err := hex.DecodeString(prefix); err == nil {
+			prefixes = append(prefixes, b)
+		}
+	}
+	return prefixes
+}
+
+// match reports whether a packet starts with one of the prefixes; without prefixes every packet matches
+func (pp packetPrefixes) match(packet []byte) bool {
+	if len(pp) == 0 {
+		return true
+	}
+	for _, prefix := range pp {
+		if bytes.HasPrefix(packet, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stats returns the relay counters for GetStats
+func (r *udpRelay) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"target":    r.target.String(),
+		"relayed":   r.relayed.Load(),
+		"replies":   r.replies.Load(),
+		"dropped":   r.dropped.Load(),
+		"in_flight": r.inFlight.Load(),
+	}
+}
+
+// relayDiscovery broadcasts an allowed client's discovery packet and relays the replies back
+// packet must not alias the receive buffer
+func (p *UDPProxy) relayDiscovery(clientAddr *net.UDPAddr, packet []byte) {
+	relay := p.relay
+	clientIP := clientAddr.IP.String()
+
+	if !allowNewConnection(p.connRate, clientIP, p.service.ServiceName) {
+		relay.dropped.Add(1)
+		return
+	}
+	if relay.inFlight.Add(1) > maxRelaysInFlight {
+		relay.inFlight.Add(-1)
+		relay.dropped.Add(1)
+		if deniedLogs.Allow(clientIP, p.service.ServiceName, "relay_busy") {
+			p.log.Warn().
+				Str("client_ip", clientIP).
+				Str("service", p.service.ServiceName).
+				Msg("Discovery packet dropped: too many relays in flight")
+		}
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer relay.inFlight.Add(-1)
+
+		localAddr, err := p.backend.source.udpAddr(relay.target)
+		if err != nil {
+			p.log.Error().Err(err).Str("service", p.service.ServiceName).Msg("Failed to pick relay source address")
+			return
+		}
+		// Go enables SO_BROADCAST on UDP sockets, so broadcast targets need nothing extra
+		conn, err := net.ListenUDP("udp", localAddr)
+		if err != nil {
+			p.log.Error().Err(err).Str("service", p.service.ServiceName).Msg("Failed to open discovery relay socket")
+			return
+		}
+		defer conn.Close()
+
+		if _, err := conn.WriteToUDP(packet, relay.target); err != nil {
+			p.log.Debug().
+				Err(err).
+				Str("target", relay.target.String()).
+				Str("service", p.service.ServiceName).
+				Msg("Failed to send discovery packet")
+			return
+		}
+		relay.relayed.Add(1)
+		p.history.RecordTraffic(p.service.ServiceID, clientIP, int64(len(packet)), 0)
+
+		p.log.Debug().
+			Str("client_ip", clientIP).
+			Str("target", relay.target.String()).
+			Str("service", p.service.ServiceName).
+			Msg("Relayed discovery packet")
+
+		deadline := time.Now().Add(relay.window)
+		bufPtr := getBuffer(p.bufferSize)
+		defer putBuffer(bufPtr)
+		buffer := *bufPtr
+
+		for {
+			select {
+			case <-p.ctx.Done():
+				return
+			default:
+			}
+			// Wake up at least every second so shutdown is not held up by a long window
+			conn.SetReadDeadline(minTime(deadline, time.Now().Add(time.Second)))
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() && time.Now().Before(deadline) {
+					continue
+				}
+				return
+			}
+
+			if _, err := p.conn.WriteToUDP(buffer[:n], clientAddr); err != nil {
+				return
+			}
+			relay.replies.Add(1)
+			p.history.RecordTraffic(p.service.ServiceID, clientIP, 0, int64(n))
+
+			p.log.Debug().
+				Str("client_ip", clientIP).
+				Str("responder", from.String()).
+				Str("service", p.service.ServiceName).
+				Msg("Relayed discovery reply")
+		}
+	}()
+}
+
+// minTime returns the earlier of two times
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// stickyPortIdle drops a pinned port no session used for this long, even while the client keeps access
+const stickyPortIdle = 24 * time.Hour
+
+// sourcePorts pins the backend source port of each client address, so a new session after an idle
+// timeout reaches the backend from the same port as before, like an entry in a NAT table
+type sourcePorts struct {
+	mu   sync.Mutex
+	pins map[string]sourcePortPin // Client ip:port -> pinned port
+}
+
+type sourcePortPin struct {
+	clientIP string
+	port     int
+	lastUsed time.Time
+}
+
+// newSourcePorts builds the port pinning of a service; nil when udp_sticky_source_port is off
+func newSourcePorts(service *config.ProtectedServiceConfig) *sourcePorts {
+	if !service.UDPStickySourcePort {
+		return nil
+	}
+	return &sourcePorts{pins: make(map[string]sourcePortPin)}
+}
+
+// localAddr returns the address to bind a client's backend socket to: local with the client's pinned port
+// ok is false when the client has no pinned port yet
+func (s *sourcePorts) localAddr(clientAddr *net.UDPAddr, local *net.UDPAddr) (*net.UDPAddr, bool) {
+	if s == nil {
+		return local, false
+	}
+	s.mu.Lock()
+	pin, ok := s.pins[clientAddr.String()]
+	s.mu.Unlock()
+	if !ok {
+		return local, false
+	}
+
+	pinned := &net.UDPAddr{Port: pin.port}
+	if local != nil {
+		pinned.IP, pinned.Zone = local.IP, local.Zone
+	}
+	return pinned, true
+}
+
+// remember pins the port a client's backend socket is bound to
+func (s *sourcePorts) remember(clientAddr *net.UDPAddr, conn *net.UDPConn) {
+	if s == nil {
+		return
+	}
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.pins[clientAddr.String()] = sourcePortPin{
+		clientIP: clientAddr.IP.String(),
+		port:     local.Port,
+		lastUsed: time.Now(),
+	}
+	s.mu.Unlock()
+}
+
+// forgetIP drops the pinned ports of a client IP whose sessions were terminated
+func (s *sourcePorts) forgetIP(clientIP string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, pin := range s.pins {
+		if pin.clientIP == clientIP {
+			delete(s.pins, key)
+		}
+	}
+}
+
+// prune drops the pinned ports of clients that lost access or have not had a session for a long time
+func (s *sourcePorts) prune(hasAccess func(clientIP string) bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, pin := range s.pins {
+		if now.Sub(pin.lastUsed) > stickyPortIdle || !hasAccess(pin.clientIP) {
+			delete(s.pins, key)
+		}
+	}
+}
+
+// count returns the number of pinned ports for GetStats
+func (s *sourcePorts) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pins)
+}
+
+// pruneSourcePorts drops the pinned ports of clients without access to the service
+func (p *UDPProxy) pruneSourcePorts() {
+	p.sourcePorts.prune(func(clientIP string) bool {
+		ip, ok := parseIPFromAddr(clientIP)
+		if !ok {
+			return false
+		}
+		allowed, _ := checkAccess(p.allowlistManager, p.access, ip, p.service.ServiceID)
+		return allowed
+	})
+}
@@ -3,23 +3,62 @@ package proxy
 import (
 	"net/netip"
 	"strings"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/auth"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/logsample"
+	"github.com/rs/zerolog"
 )
 
+// deniedLogs limits deny logs to one per client IP, service and reason per minute
+// A scanner hitting a UDP port would otherwise produce a log line per packet
+var deniedLogs = logsample.New("deny", time.Minute, zerolog.WarnLevel, log)
+
 // parseIPFromAddr extracts an IP address from a network address string
+// Accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port"
 func parseIPFromAddr(addr string) (netip.Addr, bool) {
 	// Remove port if present (e.g., "127.0.0.1:12345" -> "127.0.0.1")
-	host, _, err := strings.Cut(addr, ":")
-	if err {
-		host = addr
+	if addrPort, err := netip.ParseAddrPort(addr); err == nil {
+		return addrPort.Addr().Unmap(), true
 	}
 
 	// Remove brackets from IPv6 addresses (e.g., "[::1]" -> "::1")
-	host = strings.Trim(host, "[]")
+	host := strings.Trim(addr, "[]")
 
 	ip, parseErr := netip.ParseAddr(host)
 	if parseErr != nil {
 		return netip.Addr{}, false
 	}
 
-	return ip, true
+	return ip.Unmap(), true
+}
+
+// checkAccess checks the allowlist for a service; access through portal sessions is further
+// limited by the policy to allowed services and schedules, considering every session covering the IP
+func checkAccess(allowlistManager *ipallowlist.Manager, policy AccessPolicy, ip netip.Addr, serviceID string) (bool, string) {
+	allowed, reason := allowlistManager.IsIPAllowed(ip)
+	if !allowed || reason != string(ipallowlist.EntryTypeSession) || policy == nil {
+		return allowed, reason
+	}
+	if allowed, policyReason := policy(ip, serviceID); !allowed {
+		return false, policyReason
+	}
+	return true, reason
+}
+
+// allowNewConnection takes a token from the client's shared new-connection bucket
+// A nil limiter means no limit
+func allowNewConnection(limiter *auth.RateLimiter, clientIP, serviceName string) bool {
+	if limiter == nil || limiter.Allow(clientIP) {
+		return true
+	}
+
+	if deniedLogs.Allow(clientIP, serviceName, "rate_limit") {
+		log.Warn().
+			Str("client_ip", clientIP).
+			Str("service", serviceName).
+			Msg("Connection denied: new-connection rate limit exceeded")
+	}
+	return false
 }
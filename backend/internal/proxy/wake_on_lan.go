@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const (
+	defaultWakeWait      = 60 * time.Second
+	defaultWakePort      = 9
+	wakeProbeTimeout     = 3 * time.Second  // Per dial attempt while waiting; a sleeping host never answers
+	wakeRetryInterval    = 2 * time.Second  // Between dial attempts while waiting
+	wakeResendInterval   = 10 * time.Second // Magic packets get lost; resend while clients are waiting
+	defaultWakeBroadcast = "255.255.255.255"
+)
+
+// backendWaker wakes a sleeping backend with a Wake-on-LAN magic packet when it cannot be reached
+type backendWaker struct {
+	serviceName string
+	mac         net.HardwareAddr
+	target      *net.UDPAddr
+	wait        time.Duration
+
+	lastSent  atomic.Int64 // Unix nanoseconds of the last magic packet
+	lastAlive atomic.Int64 // Unix nanoseconds the backend was last reached
+	sent      atomic.Int64
+	wakeups   atomic.Int64
+	waiting   atomic.Int32
+}
+
+// newBackendWaker builds the waker of a service; nil when wake_on_lan is not configured
+// The config validator has checked the MAC and broadcast address
+func newBackendWaker(service *config.ProtectedServiceConfig) *backendWaker {
+	wc := service.WakeOnLAN
+	if wc == nil {
+		return nil
+	}
+	mac, err := net.ParseMAC(wc.MACAddress)
+	if err != nil {
+		return nil
+	}
+
+	broadcast := wc.BroadcastAddress
+	if broadcast == "" {
+		broadcast = defaultWakeBroadcast
+	}
+	port := wc.Port
+	if port == 0 {
+		port = defaultWakePort
+	}
+	w := &backendWaker{
+		serviceName: service.ServiceName,
+		mac:         mac,
+		target:      &net.UDPAddr{IP: net.ParseIP(broadcast), Port: port},
+		wait:        time.Duration(wc.WaitSeconds) * time.Second,
+	}
+	if w.wait <= 0 {
+		w.wait = defaultWakeWait
+	}
+	return w
+}
+
+// magicPacket returns six 0xFF bytes followed by the MAC sixteen times
+func (w *backendWaker) magicPacket() []byte {
+	packet := bytes.Repeat([]byte{0xFF}, 6)
+	for i := 0; i < 16; i++ {
+		packet = append(packet, w.mac...)
+	}
+	return packet
+}
+
+// send broadcasts a magic packet unless one went out within wakeResendInterval
+func (w *backendWaker) send(source *backendSource) {
+	now := time.Now().UnixNano()
+	last := w.lastSent.Load()
+	if now-last < int64(wakeResendInterval) || !w.lastSent.CompareAndSwap(last, now) {
+		return
+	}
+
+	localAddr, err := source.udpAddr(w.target)
+	if err != nil {
+		log.Error().Err(err).Str("service", w.serviceName).Msg("Failed to pick Wake-on-LAN source address")
+		return
+	}
+	conn, err := net.ListenUDP("udp4", localAddr)
+	if err != nil {
+		log.Error().Err(err).Str("service", w.serviceName).Msg("Failed to open Wake-on-LAN socket")
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(w.magicPacket(), w.target); err != nil {
+		log.Error().
+			Err(err).
+			Str("service", w.serviceName).
+			Str("target", w.target.String()).
+			Msg("Failed to send Wake-on-LAN packet")
+		return
+	}
+	w.sent.Add(1)
+
+	log.Info().
+		Str("service", w.serviceName).
+		Str("mac", w.mac.String()).
+		Str("target", w.target.String()).
+		Msg("Backend unreachable, sent Wake-on-LAN packet")
+}
+
+// markAlive records that the backend answered
+func (w *backendWaker) markAlive() {
+	if w != nil {
+		w.lastAlive.Store(time.Now().UnixNano())
+	}
+}
+
+// nudge wakes the backend of a datagram service that has not answered within the wait time
+// UDP cannot tell a sleeping host from a quiet one, so the packet goes out and clients retry as usual
+func (w *backendWaker) nudge(source *backendSource) {
+	if w != nil && time.Since(time.Unix(0, w.lastAlive.Load())) > w.wait {
+		w.send(source)
+	}
+}
+
+// wakeAndDial wakes the backend and dials until it answers or the wait time runs out
+// The client connection is held meanwhile
+func (w *backendWaker) wakeAndDial(ctx context.Context, source *backendSource, dial func(context.Context) (net.Conn, string, error)) (net.Conn, string, error) {
+	w.waiting.Add(1)
+	defer w.waiting.Add(-1)
+	w.send(source)
+
+	conn, addr, err := retryDial(ctx, w.wait, wakeRetryInterval, dial, func() { w.send(source) })
+	if err != nil {
+		return nil, addr, err
+	}
+	w.wakeups.Add(1)
+	log.Info().Str("service", w.serviceName).Str("backend", addr).Msg("Backend woke up")
+	return conn, addr, nil
+}
+
+// stats returns the waker counters for the admin API
+func (w *backendWaker) stats() map[string]interface{} {
+	stats := map[string]interface{}{
+		"mac":          w.mac.String(),
+		"target":       w.target.String(),
+		"packets_sent": w.sent.Load(),
+		"wakeups":      w.wakeups.Load(),
+		"waiting":      w.waiting.Load(),
+	}
+	if last := w.lastSent.Load(); last > 0 {
+		stats["last_sent_at"] = time.Unix(0, last)
+	}
+	return stats
+}
@@ -0,0 +1,162 @@
+// Package schedule evaluates the weekly access windows of portal users and services.
+//
+// A schedule is open while any of its windows matches the current time in the schedule's
+// timezone. Windows whose end time is earlier than their start time run past midnight and
+// belong to the day they start on.
+package schedule
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+	"github.com/davbauer/knock-knock-portal/internal/utils"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// locations caches loaded timezones; schedules are checked on the proxy hot path
+var locations sync.Map // map[string]*time.Location
+
+// IsOpen reports whether the schedule allows access at t
+// A nil schedule is always open
+func IsOpen(schedule *config.AccessScheduleConfig, t time.Time) bool {
+	if schedule == nil {
+		return true
+	}
+
+	t = t.In(location(schedule.Timezone))
+	minute := t.Hour()*60 + t.Minute()
+	yesterday := (t.Weekday() + 6) % 7
+
+	for _, window := range schedule.Windows {
+		start, ok := parseClock(window.StartTime)
+		if !ok {
+			continue
+		}
+		end, ok := parseClock(window.EndTime)
+		if !ok {
+			continue
+		}
+
+		if start < end {
+			if minute >= start && minute < end && onDay(window.Days, t.Weekday()) {
+				return true
+			}
+			continue
+		}
+
+		// Overnight window: the evening part is on a listed day, the morning part on the day after
+		if minute >= start && onDay(window.Days, t.Weekday()) {
+			return true
+		}
+		if minute < end && onDay(window.Days, yesterday) {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionPolicy returns the policy that limits portal sessions to their allowed services and to
+// the schedules of their user and of the requested service; the sessions covering the IP are looked up once
+func SessionPolicy(configLoader *config.Loader, sessionManager *session.Manager) func(ip netip.Addr, serviceID string) (bool, string) {
+	return func(ip netip.Addr, serviceID string) (bool, string) {
+		cfg := configLoader.GetConfig()
+		_, allowed, reason := SessionAccess(cfg, sessionManager.FindSessionsForIP(ip), utils.GetServiceByID(cfg, serviceID), time.Now())
+		return allowed, reason
+	}
+}
+
+// SessionAccess decides whether the sessions covering an IP may use a service at now
+// Several users can share an IP, so access is granted when any session qualifies: its allowed services
+// must include the service and its user's schedule must be open. The service's own schedule applies to
+// all of them. Returns the session that granted access, if any, and the reason when denied; without
+// sessions only the service schedule is checked
+func SessionAccess(cfg *config.ApplicationConfig, sessions []*session.Session, service *config.ProtectedServiceConfig, now time.Time) (*session.Session, bool, string) {
+	if service != nil && !IsOpen(service.Schedule, now) {
+		return nil, false, "service_outside_schedule"
+	}
+	if len(sessions) == 0 {
+		return nil, true, ""
+	}
+
+	reason := ""
+	for _, sess := range sessions {
+		if service != nil && !config.ServiceAllowed(sess.AllowedServiceIDs, service.ServiceID, service.Tags) {
+			if reason == "" {
+				reason = "service_not_allowed"
+			}
+			continue
+		}
+		if !userOpen(cfg, sess.UserID, now) {
+			reason = "user_outside_schedule"
+			continue
+		}
+		return sess, true, ""
+	}
+	return nil, false, reason
+}
+
+// userOpen reports whether the effective schedule of a portal user is open; unknown users have none
+func userOpen(cfg *config.ApplicationConfig, userID string, now time.Time) bool {
+	for i := range cfg.PortalUserAccounts {
+		user := &cfg.PortalUserAccounts[i]
+		if user.UserID == userID {
+			return IsOpen(config.EffectiveUser(cfg, user).Schedule, now)
+		}
+	}
+	return true
+}
+
+// location returns the named timezone, falling back to local time
+func location(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// parseClock converts HH:MM to minutes since midnight; "24:00" is the end of the day
+func parseClock(value string) (int, bool) {
+	if value == "24:00" {
+		return 24 * 60, true
+	}
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, false
+	}
+	return parsed.Hour()*60 + parsed.Minute(), true
+}
+
+// onDay reports whether day is listed; an empty list means every day
+func onDay(days []string, day time.Weekday) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, name := range days {
+		if weekday, ok := weekdays[strings.ToLower(name)]; ok && weekday == day {
+			return true
+		}
+	}
+	return false
+}
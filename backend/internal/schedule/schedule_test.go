@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+)
+
+func TestIsOpen(t *testing.T) {
+	schedule := &config.AccessScheduleConfig{
+		Timezone: "UTC",
+		Windows: []config.ScheduleWindow{
+			{Days: []string{"mon"}, StartTime: "09:00", EndTime: "17:00"},
+			{Days: []string{"fri"}, StartTime: "22:00", EndTime: "02:00"},
+		},
+	}
+	tests := []struct {
+		at   string
+		want bool
+	}{
+		{"2026-10-12T09:00:00Z", true},  // Monday, start
+		{"2026-10-12T17:00:00Z", false}, // Monday, end is exclusive
+		{"2026-10-13T10:00:00Z", false}, // Tuesday
+		{"2026-10-16T23:30:00Z", true},  // Friday evening
+		{"2026-10-17T01:59:00Z", true},  // Saturday morning, continues Friday's window
+		{"2026-10-18T01:00:00Z", false}, // Sunday morning
+	}
+	for _, tt := range tests {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := IsOpen(schedule, at); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.at, got, tt.want)
+		}
+	}
+	if !IsOpen(nil, time.Now()) {
+		t.Error("nil schedule closed")
+	}
+}
+
+func TestSessionAccess(t *testing.T) {
+	closed := &config.AccessScheduleConfig{Timezone: "UTC", Windows: []config.ScheduleWindow{{StartTime: "00:00", EndTime: "00:01"}}}
+	cfg := &config.ApplicationConfig{
+		PortalUserAccounts: []config.PortalUserAccount{
+			{UserID: "kid", Schedule: closed},
+			{UserID: "parent"},
+		},
+	}
+	service := &config.ProtectedServiceConfig{ServiceID: "minecraft"}
+	other := &config.ProtectedServiceConfig{ServiceID: "nas"}
+	now, _ := time.Parse(time.RFC3339, "2026-10-16T12:00:00Z")
+
+	kid := &session.Session{SessionID: "kid", UserID: "kid"}
+	restricted := &session.Session{SessionID: "restricted", UserID: "parent", AllowedServiceIDs: []string{"nas"}}
+	parent := &session.Session{SessionID: "parent", UserID: "parent"}
+
+	tests := []struct {
+		name     string
+		sessions []*session.Session
+		service  *config.ProtectedServiceConfig
+		granted  string
+		reason   string
+	}{
+		{"no sessions", nil, service, "", ""},
+		{"user outside schedule", []*session.Session{kid}, service, "", "user_outside_schedule"},
+		{"service not allowed", []*session.Session{restricted}, service, "", "service_not_allowed"},
+		{"restricted session first", []*session.Session{restricted, parent}, service, "parent", ""},
+		{"closed session first", []*session.Session{kid, parent}, service, "parent", ""},
+		{"any session grants", []*session.Session{kid, restricted}, other, "restricted", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			granted, allowed, reason := SessionAccess(cfg, tt.sessions, tt.service, now)
+			if allowed != (tt.reason == "") || reason != tt.reason {
+				t.Fatalf("got %v %q, want reason %q", allowed, reason, tt.reason)
+			}
+			if (granted == nil && tt.granted != "") || (granted != nil && granted.SessionID != tt.granted) {
+				t.Fatalf("granted %v, want %q", granted, tt.granted)
+			}
+		})
+	}
+
+	service.Schedule = closed
+	if _, allowed, reason := SessionAccess(cfg, []*session.Session{parent}, service, now); allowed || reason != "service_outside_schedule" {
+		t.Fatalf("service schedule ignored: %v %q", allowed, reason)
+	}
+}
@@ -0,0 +1,6 @@
+package session
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["session"] overrides its level
+var log = logging.Module("session")
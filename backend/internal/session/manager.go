@@ -7,14 +7,15 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/davbauer/knock-knock-portal/internal/config"
 	"github.com/google/uuid"
-	"github.com/rs/zerolog/log"
 )
 
 // Manager manages user sessions
 type Manager struct {
 	sessions          sync.Map // map[sessionID]*Session
-	sessionsByIP      sync.Map // map[string]*sync.Map (IP -> map[sessionID]bool for O(1) operations)
+	sessionsByIP      sync.Map // map[string]*sync.Map (IP or granted prefix -> map[sessionID]bool for O(1) operations)
+	grantLengths      sync.Map // map[grantLength]bool - prefix lengths indexed in sessionsByIP; never shrinks
 	sessionsByUserID  sync.Map // map[string]*sync.Map (userID -> map[sessionID]bool for O(1) operations)
 	defaultDuration   time.Duration
 	maxDuration       *time.Duration
@@ -24,6 +25,9 @@ type Manager struct {
 	stopChan          chan struct{}
 	maxSessions       int32 // Maximum allowed concurrent sessions (0 = unlimited)
 	currentSessions   int32 // Current active session count
+	ipv4GrantPrefix   int32 // Prefix length granted per IPv4 address (0 = exact)
+	ipv6GrantPrefix   int32 // Prefix length granted per IPv6 address (0 = exact)
+	serviceGroups     atomic.Pointer[map[string][]string]
 }
 
 // NewManager creates a new session manager
@@ -45,8 +49,35 @@ func NewManager(defaultDuration time.Duration, maxDuration *time.Duration, autoE
 	return m
 }
 
+// SetGrantPrefixes configures the prefix lengths granted to newly created sessions
+// 0 (or the full address length) grants only the exact client IP
+func (m *Manager) SetGrantPrefixes(ipv4Prefix, ipv6Prefix int) {
+	atomic.StoreInt32(&m.ipv4GrantPrefix, int32(ipv4Prefix))
+	atomic.StoreInt32(&m.ipv6GrantPrefix, int32(ipv6Prefix))
+}
+
+// SetServiceGroups configures the service groups expanded in the allowed services of new sessions
+// Existing sessions keep the services they were created with
+func (m *Manager) SetServiceGroups(groups map[string][]string) {
+	m.serviceGroups.Store(&groups)
+}
+
 // CreateSession creates a new session
-func (m *Manager) CreateSession(userID, username string, clientIP netip.Addr, allowedServiceIDs []string) (*Session, error) {
+// Service group names in allowedServiceIDs are expanded to their member service IDs
+// limits override the session length and cap the user's concurrent sessions (zero values = defaults)
+func (m *Manager) CreateSession(userID, username string, clientIP netip.Addr, allowedServiceIDs []string, limits Limits) (*Session, error) {
+	if groups := m.serviceGroups.Load(); groups != nil {
+		allowedServiceIDs = config.ExpandServiceGroups(*groups, allowedServiceIDs)
+	}
+
+	if limits.MaxSessions > 0 && m.countUserSessions(userID) >= limits.MaxSessions {
+		return nil, ErrUserSessionLimit
+	}
+	duration := m.defaultDuration
+	if limits.Duration > 0 {
+		duration = limits.Duration
+	}
+
 	// Check session limit if configured (0 = unlimited)
 	if m.maxSessions > 0 {
 		current := atomic.LoadInt32(&m.currentSessions)
@@ -73,16 +104,19 @@ func (m *Manager) CreateSession(userID, username string, clientIP netip.Addr, al
 		AllowedServiceIDs:        allowedServiceIDs,
 		CreatedAt:                now,
 		LastActivityAt:           now,
-		ExpiresAt:                now.Add(m.defaultDuration),
+		ExpiresAt:                now.Add(duration),
 		AutoExtendEnabled:        m.autoExtendEnabled,
 		MaximumDuration:          m.maxDuration,
+		IPv4GrantPrefix:          int(atomic.LoadInt32(&m.ipv4GrantPrefix)),
+		IPv6GrantPrefix:          int(atomic.LoadInt32(&m.ipv6GrantPrefix)),
+		Duration:                 limits.Duration,
 	}
 
 	// Store session
 	m.sessions.Store(sessionID, session)
 
 	// Index by IP
-	m.addToIPIndex(clientIP.String(), sessionID)
+	m.indexIP(session, clientIP)
 
 	// Index by user ID
 	m.addToUserIDIndex(userID, sessionID)
@@ -97,6 +131,29 @@ func (m *Manager) CreateSession(userID, username string, clientIP netip.Addr, al
 	return session, nil
 }
 
+// RestoreSession re-registers a session carried over from a previous process
+// Expired sessions are ignored; returns true if the session was restored
+func (m *Manager) RestoreSession(session *Session) bool {
+	if session == nil || session.IsExpired() {
+		return false
+	}
+	if _, exists := m.sessions.Load(session.SessionID); exists {
+		return false
+	}
+
+	if m.maxSessions > 0 {
+		atomic.AddInt32(&m.currentSessions, 1)
+	}
+
+	m.sessions.Store(session.SessionID, session)
+	for _, ip := range session.AuthenticatedIPAddresses {
+		m.indexIP(session, ip)
+	}
+	m.addToUserIDIndex(session.UserID, session.SessionID)
+
+	return true
+}
+
 // GetSessionByID retrieves a session by ID
 func (m *Manager) GetSessionByID(sessionID string) (*Session, error) {
 	value, ok := m.sessions.Load(sessionID)
@@ -137,6 +194,52 @@ func (m *Manager) GetSessionByIP(ip netip.Addr) (*Session, bool) {
 	return foundSession, foundSession != nil
 }
 
+// FindSessionForIP returns an active session covering the IP, including grant prefixes
+// Sessions of the exact IP are preferred; use FindSessionsForIP when several users may share the IP
+func (m *Manager) FindSessionForIP(ip netip.Addr) (*Session, bool) {
+	sessions := m.FindSessionsForIP(ip)
+	if len(sessions) == 0 {
+		return nil, false
+	}
+	return sessions[0], true
+}
+
+// FindSessionsForIP returns every active session covering the IP, those of the exact IP first
+// Several users behind one NAT address, or inside one granted prefix, each have their own session
+func (m *Manager) FindSessionsForIP(ip netip.Addr) []*Session {
+	var sessions []*Session
+	seen := make(map[string]bool)
+	collect := func(key string) {
+		value, ok := m.sessionsByIP.Load(key)
+		if !ok {
+			return
+		}
+		value.(*sync.Map).Range(func(id, _ interface{}) bool {
+			sessionID := id.(string)
+			if seen[sessionID] {
+				return true
+			}
+			seen[sessionID] = true
+			if session, err := m.GetSessionByID(sessionID); err == nil {
+				sessions = append(sessions, session)
+			}
+			return true
+		})
+	}
+
+	collect(ip.String())
+	m.grantLengths.Range(func(key, _ interface{}) bool {
+		length := key.(grantLength)
+		if length.ipv6 == ip.Is6() {
+			if prefix, err := ip.Prefix(length.bits); err == nil {
+				collect(prefix.String())
+			}
+		}
+		return true
+	})
+	return sessions
+}
+
 // RecordActivity records session activity and extends if configured
 func (m *Manager) RecordActivity(sessionID string) error {
 	value, ok := m.sessions.Load(sessionID)
@@ -150,7 +253,11 @@ func (m *Manager) RecordActivity(sessionID string) error {
 	}
 
 	if session.AutoExtendEnabled && session.CanExtend() {
-		session.ExtendSession(m.defaultDuration)
+		extension := m.defaultDuration
+		if session.Duration > 0 {
+			extension = session.Duration
+		}
+		session.ExtendSession(extension)
 	} else {
 		session.LastActivityAt = time.Now()
 	}
@@ -177,7 +284,7 @@ func (m *Manager) AddIPToSession(sessionID string, clientIP netip.Addr) error {
 		m.sessions.Store(sessionID, session)
 
 		// Add to IP index
-		m.addToIPIndex(clientIP.String(), sessionID)
+		m.indexIP(session, clientIP)
 
 		log.Info().
 			Str("session_id", sessionID).
@@ -210,7 +317,7 @@ func (m *Manager) TerminateSession(sessionID string) error {
 
 	// Remove from IP index for all authenticated IPs
 	for _, ip := range session.AuthenticatedIPAddresses {
-		m.removeFromIPIndex(ip.String(), sessionID)
+		m.unindexIP(session, ip)
 	}
 
 	m.removeFromUserIDIndex(session.UserID, sessionID)
@@ -224,6 +331,36 @@ func (m *Manager) TerminateSession(sessionID string) error {
 	return nil
 }
 
+// GetSessionsByUserID returns all sessions of a portal user
+func (m *Manager) GetSessionsByUserID(userID string) []*Session {
+	sessions := []*Session{}
+
+	value, ok := m.sessionsByUserID.Load(userID)
+	if !ok {
+		return sessions
+	}
+
+	value.(*sync.Map).Range(func(key, _ interface{}) bool {
+		if session, ok := m.sessions.Load(key); ok {
+			sessions = append(sessions, session.(*Session))
+		}
+		return true
+	})
+
+	return sessions
+}
+
+// countUserSessions returns the number of unexpired sessions of a portal user
+func (m *Manager) countUserSessions(userID string) int {
+	count := 0
+	for _, session := range m.GetSessionsByUserID(userID) {
+		if !session.IsExpired() {
+			count++
+		}
+	}
+	return count
+}
+
 // GetAllActiveSessions returns all active sessions
 func (m *Manager) GetAllActiveSessions() []*Session {
 	sessions := []*Session{}
@@ -288,6 +425,29 @@ func (m *Manager) Close() {
 
 // Helper methods for indexing
 
+// grantLength is a prefix length some session was granted for an address family
+type grantLength struct {
+	ipv6 bool
+	bits int
+}
+
+// indexIP indexes a session under an authenticated IP and, when wider, the prefix granted for it
+func (m *Manager) indexIP(session *Session, ip netip.Addr) {
+	m.addToIPIndex(ip.String(), session.SessionID)
+	if prefix := session.GrantPrefix(ip); prefix.Bits() < ip.BitLen() {
+		m.grantLengths.Store(grantLength{ipv6: ip.Is6(), bits: prefix.Bits()}, true)
+		m.addToIPIndex(prefix.String(), session.SessionID)
+	}
+}
+
+// unindexIP removes what indexIP added
+func (m *Manager) unindexIP(session *Session, ip netip.Addr) {
+	m.removeFromIPIndex(ip.String(), session.SessionID)
+	if prefix := session.GrantPrefix(ip); prefix.Bits() < ip.BitLen() {
+		m.removeFromIPIndex(prefix.String(), session.SessionID)
+	}
+}
+
 func (m *Manager) addToIPIndex(ip, sessionID string) {
 	// Create or load the session map for this IP
 	value, _ := m.sessionsByIP.LoadOrStore(ip, &sync.Map{})
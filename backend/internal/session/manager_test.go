@@ -0,0 +1,56 @@
+package session
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func sessionIDs(sessions []*Session) map[string]bool {
+	ids := make(map[string]bool, len(sessions))
+	for _, sess := range sessions {
+		ids[sess.SessionID] = true
+	}
+	return ids
+}
+
+func TestFindSessionsForIP(t *testing.T) {
+	m := NewManager(time.Hour, nil, false, time.Hour, 0)
+	defer m.Close()
+
+	shared := netip.MustParseAddr("198.51.100.7")
+	first, _ := m.CreateSession("alice", "alice", shared, []string{"minecraft"}, Limits{})
+	second, _ := m.CreateSession("bob", "bob", shared, nil, Limits{})
+
+	m.SetGrantPrefixes(0, 64)
+	wide, _ := m.CreateSession("carol", "carol", netip.MustParseAddr("2001:db8::1"), nil, Limits{})
+
+	tests := []struct {
+		name string
+		ip   string
+		want []string
+	}{
+		{"shared NAT address", "198.51.100.7", []string{first.SessionID, second.SessionID}},
+		{"inside granted prefix", "2001:db8::abcd", []string{wide.SessionID}},
+		{"outside granted prefix", "2001:db8:1::1", nil},
+		{"unknown address", "198.51.100.8", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sessionIDs(m.FindSessionsForIP(netip.MustParseAddr(tt.ip)))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d sessions, want %d", len(got), len(tt.want))
+			}
+			for _, id := range tt.want {
+				if !got[id] {
+					t.Fatalf("session %s missing", id)
+				}
+			}
+		})
+	}
+
+	m.TerminateSession(wide.SessionID)
+	if sessions := m.FindSessionsForIP(netip.MustParseAddr("2001:db8::abcd")); len(sessions) != 0 {
+		t.Fatalf("terminated session still found")
+	}
+}
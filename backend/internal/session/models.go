@@ -1,10 +1,45 @@
 package session
 
 import (
+	"errors"
 	"net/netip"
 	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+// Manual extension errors
+var (
+	ErrExtensionLimitReached  = errors.New("manual extension limit reached")
+	ErrExtensionTooSoon       = errors.New("session was extended too recently")
+	ErrMaximumDurationReached = errors.New("session has reached its maximum duration")
 )
 
+// ErrUserSessionLimit is returned when a user already has as many sessions as their max_sessions allows
+var ErrUserSessionLimit = errors.New("user session limit reached")
+
+// Limits are per-user settings applied when a session is created
+type Limits struct {
+	Duration    time.Duration // Session length and auto-extension step; 0 = manager default
+	MaxSessions int           // Concurrent sessions of the user; 0 = unlimited
+}
+
+// LimitsFor returns the session limits of a portal user; pass the user through config.EffectiveUser first
+// so the settings of its groups are included
+func LimitsFor(user *config.PortalUserAccount) Limits {
+	return Limits{
+		Duration:    time.Duration(user.SessionDurationSeconds) * time.Second,
+		MaxSessions: user.MaxSessions,
+	}
+}
+
+// ExtensionPolicy limits manual (user-requested) session extensions
+type ExtensionPolicy struct {
+	MaxExtensions      int           // 0 = unlimited
+	MinInterval        time.Duration // 0 = no minimum
+	AllowBeyondMaximum bool          // Manual extensions may push expiry past MaximumDuration
+}
+
 // Session represents an authenticated user session
 type Session struct {
 	SessionID                string
@@ -17,6 +52,11 @@ type Session struct {
 	ExpiresAt                time.Time
 	AutoExtendEnabled        bool
 	MaximumDuration          *time.Duration // nil = unlimited
+	IPv4GrantPrefix          int            // Prefix length granted per IPv4 address (0 or 32 = exact)
+	IPv6GrantPrefix          int            // Prefix length granted per IPv6 address (0 or 128 = exact)
+	ManualExtensions         int            // Number of user-requested extensions
+	LastManualExtensionAt    time.Time      // Zero = never manually extended
+	Duration                 time.Duration  // Per-user session length and auto-extension step; 0 = manager default
 }
 
 // IsExpired checks if the session is expired
@@ -24,21 +64,41 @@ func (s *Session) IsExpired() bool {
 	return time.Now().After(s.ExpiresAt)
 }
 
-// IsIPAllowed checks if an IP is in the authenticated list
+// IsIPAllowed checks if an IP is covered by the authenticated list
+// (exact match, or within the grant prefix of an authenticated IP)
 func (s *Session) IsIPAllowed(ip netip.Addr) bool {
 	for _, authenticatedIP := range s.AuthenticatedIPAddresses {
-		if authenticatedIP == ip {
+		if authenticatedIP == ip || s.GrantPrefix(authenticatedIP).Contains(ip) {
 			return true
 		}
 	}
 	return false
 }
 
+// GrantPrefix returns the network granted to the session for an authenticated IP
+// Returns a single-address prefix when no wider grant is configured
+func (s *Session) GrantPrefix(ip netip.Addr) netip.Prefix {
+	bits := ip.BitLen()
+	if ip.Is4() && s.IPv4GrantPrefix > 0 && s.IPv4GrantPrefix < bits {
+		bits = s.IPv4GrantPrefix
+	} else if ip.Is6() && s.IPv6GrantPrefix > 0 && s.IPv6GrantPrefix < bits {
+		bits = s.IPv6GrantPrefix
+	}
+
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.PrefixFrom(ip, ip.BitLen())
+	}
+	return prefix
+}
+
 // AddAllowedIP adds an IP to the authenticated list if not already present
 // Returns true if the IP was added, false if already present
 func (s *Session) AddAllowedIP(ip netip.Addr) bool {
-	if s.IsIPAllowed(ip) {
-		return false
+	for _, authenticatedIP := range s.AuthenticatedIPAddresses {
+		if authenticatedIP == ip {
+			return false
+		}
 	}
 	s.AuthenticatedIPAddresses = append(s.AuthenticatedIPAddresses, ip)
 	return true
@@ -68,6 +128,67 @@ func (s *Session) ExtendSession(duration time.Duration) {
 		}
 	}
 
-	s.ExpiresAt = newExpiry
+	// Never shorten a session (a manual extension may already reach past the maximum)
+	if newExpiry.After(s.ExpiresAt) {
+		s.ExpiresAt = newExpiry
+	}
 	s.LastActivityAt = time.Now()
 }
+
+// CheckManualExtension reports why a manual extension is not allowed right now (nil = allowed)
+func (s *Session) CheckManualExtension(policy ExtensionPolicy) error {
+	if policy.MaxExtensions > 0 && s.ManualExtensions >= policy.MaxExtensions {
+		return ErrExtensionLimitReached
+	}
+	if policy.MinInterval > 0 && !s.LastManualExtensionAt.IsZero() && time.Since(s.LastManualExtensionAt) < policy.MinInterval {
+		return ErrExtensionTooSoon
+	}
+	if s.MaximumDuration != nil && !policy.AllowBeyondMaximum {
+		maxExpiry := s.CreatedAt.Add(*s.MaximumDuration)
+		if !s.ExpiresAt.Before(maxExpiry) {
+			return ErrMaximumDurationReached
+		}
+	}
+	return nil
+}
+
+// ExtendManually applies a user-requested extension under the given policy
+func (s *Session) ExtendManually(duration time.Duration, policy ExtensionPolicy) error {
+	if err := s.CheckManualExtension(policy); err != nil {
+		return err
+	}
+
+	if policy.AllowBeyondMaximum {
+		// Never shorten a session that already runs longer than the extension
+		if expiresAt := time.Now().Add(duration); expiresAt.After(s.ExpiresAt) {
+			s.ExpiresAt = expiresAt
+		}
+		s.LastActivityAt = time.Now()
+	} else {
+		s.ExtendSession(duration)
+	}
+
+	s.ManualExtensions++
+	s.LastManualExtensionAt = time.Now()
+	return nil
+}
+
+// RemainingManualExtensions returns how many manual extensions are left (-1 = unlimited)
+func (s *Session) RemainingManualExtensions(policy ExtensionPolicy) int {
+	if policy.MaxExtensions <= 0 {
+		return -1
+	}
+	if remaining := policy.MaxExtensions - s.ManualExtensions; remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// NextManualExtensionAt returns the earliest time the interval policy allows another extension
+// Zero when no interval applies
+func (s *Session) NextManualExtensionAt(policy ExtensionPolicy) time.Time {
+	if policy.MinInterval <= 0 || s.LastManualExtensionAt.IsZero() {
+		return time.Time{}
+	}
+	return s.LastManualExtensionAt.Add(policy.MinInterval)
+}
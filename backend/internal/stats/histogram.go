@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Bucket upper bounds in seconds
+var (
+	// DurationBuckets suit connection and session lifetimes
+	DurationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 1800, 3600}
+	// LatencyBuckets suit dial and response times
+	LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// Histogram counts observed durations in fixed buckets
+// Safe for concurrent use; observing is lock-free so it can sit on the connection path
+type Histogram struct {
+	bounds []float64 // Upper bounds in seconds, ascending
+	counts []int64   // Per bucket, not cumulative; the last entry counts values above every bound
+	count  int64
+	sumNs  int64
+}
+
+// HistogramSnapshot is a point-in-time copy of a histogram
+type HistogramSnapshot struct {
+	Bounds     []float64 // Upper bounds in seconds
+	Cumulative []int64   // Observations <= the matching bound
+	Count      int64
+	Sum        float64 // Seconds
+}
+
+// NewHistogram creates a histogram with the given upper bounds in seconds
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]int64, len(bounds)+1),
+	}
+}
+
+// Observe records one duration
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && seconds > h.bounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sumNs, int64(d))
+}
+
+// Snapshot returns the cumulative bucket counts for exposition
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snap := HistogramSnapshot{
+		Bounds:     h.bounds,
+		Cumulative: make([]int64, len(h.bounds)),
+		Count:      atomic.LoadInt64(&h.count),
+		Sum:        time.Duration(atomic.LoadInt64(&h.sumNs)).Seconds(),
+	}
+	var running int64
+	for i := range h.bounds {
+		running += atomic.LoadInt64(&h.counts[i])
+		snap.Cumulative[i] = running
+	}
+	return snap
+}
+
+// Summary returns the count, average and estimated percentiles in milliseconds for the admin API
+func (h *Histogram) Summary() map[string]interface{} {
+	snap := h.Snapshot()
+	summary := map[string]interface{}{
+		"count": snap.Count,
+	}
+	if snap.Count == 0 {
+		return summary
+	}
+	summary["avg_ms"] = roundMs(snap.Sum / float64(snap.Count))
+	summary["p50_ms"] = roundMs(snap.quantile(0.5))
+	summary["p90_ms"] = roundMs(snap.quantile(0.9))
+	summary["p99_ms"] = roundMs(snap.quantile(0.99))
+	return summary
+}
+
+// quantile estimates the q-th quantile in seconds by interpolating linearly within its bucket
+// Values above the largest bound are reported as that bound
+func (s HistogramSnapshot) quantile(q float64) float64 {
+	rank := q * float64(s.Count)
+	lower := 0.0
+	var below int64
+	for i, bound := range s.Bounds {
+		if float64(s.Cumulative[i]) >= rank {
+			inBucket := s.Cumulative[i] - below
+			if inBucket == 0 {
+				return bound
+			}
+			return lower + (bound-lower)*(rank-float64(below))/float64(inBucket)
+		}
+		lower, below = bound, s.Cumulative[i]
+	}
+	return lower
+}
+
+// roundMs converts seconds to milliseconds with two decimals
+func roundMs(seconds float64) float64 {
+	return math.Round(seconds*1000*100) / 100
+}
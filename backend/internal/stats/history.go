@@ -0,0 +1,494 @@
+// Package stats keeps rolling traffic history for the admin dashboard.
+//
+// Proxies add bytes and connections to live counters; once a minute the counters are
+// folded into one-minute buckets per service and per client IP. Buckets are retained
+// for a configurable number of days and persisted to the data directory.
+// Backend health checks are kept alongside in hourly buckets with a list of incidents.
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+const (
+	bucketSize   = time.Minute
+	saveInterval = 5 * time.Minute
+	historyFile  = "stats_history.json"
+)
+
+// Series kinds
+const (
+	KindService = "service"
+	KindIP      = "ip"
+
+	// Per client IP within a service, keyed "service_id|ip"; used for top talkers
+	kindServiceIP = "service_ip"
+)
+
+// Bucket is the traffic of one series during one interval
+type Bucket struct {
+	Time        time.Time `json:"time"`
+	BytesIn     int64     `json:"bytes_in"`  // From clients
+	BytesOut    int64     `json:"bytes_out"` // To clients
+	Connections int64     `json:"connections"`
+}
+
+// counter accumulates traffic for one service and client IP between samples
+type counter struct {
+	bytesIn     int64
+	bytesOut    int64
+	connections int64
+}
+
+type counterKey struct {
+	serviceID string
+	clientIP  string
+}
+
+// pair returns the series key of a service and client IP
+func (k counterKey) pair() string {
+	return k.serviceID + "|" + k.clientIP
+}
+
+// Talker is the traffic of one client IP of a service
+type Talker struct {
+	IP          string `json:"ip"`
+	BytesIn     int64  `json:"bytes_in"`
+	BytesOut    int64  `json:"bytes_out"`
+	Connections int64  `json:"connections"`
+}
+
+// History records per-service and per-IP traffic in one-minute buckets
+type History struct {
+	counters  sync.Map     // counterKey -> *counter
+	countMu   sync.RWMutex // Held shared while recording so sample never deletes a counter in use
+	mu        sync.RWMutex
+	enabled   bool
+	retention time.Duration
+	path      string
+	series    map[string]map[string][]Bucket // kind -> key -> buckets, oldest first
+	dirty     bool
+
+	uptimeRetention time.Duration
+	uptimePath      string
+	uptime          map[string]*uptimeSeries // Service ID -> health history
+	uptimeDirty     bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewHistory creates a history store persisted in dataDir (empty = memory only)
+func NewHistory(cfg *config.StatsHistoryConfiguration, dataDir string) *History {
+	h := &History{
+		series: map[string]map[string][]Bucket{
+			KindService:   {},
+			KindIP:        {},
+			kindServiceIP: {},
+		},
+		uptime: make(map[string]*uptimeSeries),
+		stop:   make(chan struct{}),
+	}
+	if dataDir != "" {
+		h.path = filepath.Join(dataDir, historyFile)
+		h.uptimePath = filepath.Join(dataDir, uptimeFile)
+	}
+
+	h.Reload(cfg)
+	h.load()
+	h.loadUptime()
+	return h
+}
+
+// Reload applies a new configuration
+func (h *History) Reload(cfg *config.StatsHistoryConfiguration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.enabled = cfg.Enabled
+	h.retention = time.Duration(cfg.RetentionDays) * 24 * time.Hour
+	h.uptimeRetention = time.Duration(cfg.UptimeRetentionDays) * 24 * time.Hour
+	if h.uptimeRetention <= 0 {
+		h.uptimeRetention = defaultUptimeRetention
+	}
+}
+
+// Start begins sampling the live counters
+func (h *History) Start() {
+	h.wg.Add(1)
+	go h.run()
+}
+
+// Stop stops sampling and saves the history
+func (h *History) Stop() {
+	close(h.stop)
+	h.wg.Wait()
+
+	h.Flush()
+}
+
+// Flush folds the live counters into the history and saves it
+func (h *History) Flush() {
+	h.sample(time.Now())
+	h.save()
+	h.saveUptime()
+}
+
+// RecordTraffic adds transferred bytes for a service and client IP
+func (h *History) RecordTraffic(serviceID, clientIP string, bytesIn, bytesOut int64) {
+	if h == nil {
+		return
+	}
+	h.countMu.RLock()
+	defer h.countMu.RUnlock()
+
+	c := h.counter(serviceID, clientIP)
+	if bytesIn != 0 {
+		atomic.AddInt64(&c.bytesIn, bytesIn)
+	}
+	if bytesOut != 0 {
+		atomic.AddInt64(&c.bytesOut, bytesOut)
+	}
+}
+
+// RecordConnection counts a new connection, UDP session or HTTP request
+func (h *History) RecordConnection(serviceID, clientIP string) {
+	if h == nil {
+		return
+	}
+	h.countMu.RLock()
+	defer h.countMu.RUnlock()
+
+	atomic.AddInt64(&h.counter(serviceID, clientIP).connections, 1)
+}
+
+// counter returns the live counter for a service and client IP; must be called with countMu held shared
+func (h *History) counter(serviceID, clientIP string) *counter {
+	key := counterKey{serviceID: serviceID, clientIP: clientIP}
+	if c, ok := h.counters.Load(key); ok {
+		return c.(*counter)
+	}
+	c, _ := h.counters.LoadOrStore(key, &counter{})
+	return c.(*counter)
+}
+
+// run samples the counters at every minute boundary
+func (h *History) run() {
+	defer h.wg.Done()
+
+	lastSave := time.Now()
+	for {
+		next := time.Now().Truncate(bucketSize).Add(bucketSize)
+		select {
+		case <-h.stop:
+			return
+		case now := <-time.After(time.Until(next)):
+			h.sample(now)
+			if now.Sub(lastSave) >= saveInterval {
+				h.save()
+				h.saveUptime()
+				lastSave = now
+			}
+		}
+	}
+}
+
+// sample folds the live counters into the bucket of the minute that just ended
+func (h *History) sample(now time.Time) {
+	bucketTime := now.Add(-bucketSize / 2).Truncate(bucketSize)
+
+	services := make(map[string]*Bucket)
+	ips := make(map[string]*Bucket)
+	pairs := make(map[string]*Bucket)
+
+	// Recorders are held off while counters are drained, so an idle one can be deleted safely
+	h.countMu.Lock()
+	h.counters.Range(func(k, v interface{}) bool {
+		key := k.(counterKey)
+		c := v.(*counter)
+
+		in := atomic.SwapInt64(&c.bytesIn, 0)
+		out := atomic.SwapInt64(&c.bytesOut, 0)
+		conns := atomic.SwapInt64(&c.connections, 0)
+		if in == 0 && out == 0 && conns == 0 {
+			// Idle since the last sample
+			h.counters.Delete(key)
+			return true
+		}
+
+		for _, target := range []struct {
+			buckets map[string]*Bucket
+			key     string
+		}{{services, key.serviceID}, {ips, key.clientIP}, {pairs, key.pair()}} {
+			b, ok := target.buckets[target.key]
+			if !ok {
+				b = &Bucket{Time: bucketTime}
+				target.buckets[target.key] = b
+			}
+			b.BytesIn += in
+			b.BytesOut += out
+			b.Connections += conns
+		}
+		return true
+	})
+	h.countMu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.enabled {
+		h.append(KindService, services)
+		h.append(KindIP, ips)
+		h.append(kindServiceIP, pairs)
+	}
+	h.prune(now)
+	h.pruneUptime(now)
+}
+
+// append adds sampled buckets to their series; must be called with mu held
+func (h *History) append(kind string, buckets map[string]*Bucket) {
+	for key, b := range buckets {
+		series := h.series[kind][key]
+		if n := len(series); n > 0 && series[n-1].Time.Equal(b.Time) {
+			series[n-1].BytesIn += b.BytesIn
+			series[n-1].BytesOut += b.BytesOut
+			series[n-1].Connections += b.Connections
+		} else {
+			series = append(series, *b)
+		}
+		h.series[kind][key] = series
+		h.dirty = true
+	}
+}
+
+// prune drops buckets older than the retention; must be called with mu held
+func (h *History) prune(now time.Time) {
+	cutoff := now.Add(-h.retention)
+	for kind, byKey := range h.series {
+		for key, series := range byKey {
+			i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(cutoff) })
+			if i == 0 {
+				continue
+			}
+			if i == len(series) {
+				delete(h.series[kind], key)
+			} else {
+				h.series[kind][key] = append([]Bucket(nil), series[i:]...)
+			}
+			h.dirty = true
+		}
+	}
+}
+
+// Query returns the buckets of a series since the given time, merged into steps
+// Steps without traffic are included with zero values so graphs have a continuous axis
+func (h *History) Query(kind, key string, since time.Time, step time.Duration) []Bucket {
+	if step < bucketSize {
+		step = bucketSize
+	}
+	step = step.Truncate(bucketSize)
+	since = since.Truncate(step)
+	now := time.Now()
+
+	points := make([]Bucket, 0, int(now.Sub(since)/step)+1)
+	for t := since; !t.After(now); t = t.Add(step) {
+		points = append(points, Bucket{Time: t})
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, b := range h.series[kind][key] {
+		if b.Time.Before(since) {
+			continue
+		}
+		i := int(b.Time.Sub(since) / step)
+		if i >= len(points) {
+			break
+		}
+		points[i].BytesIn += b.BytesIn
+		points[i].BytesOut += b.BytesOut
+		points[i].Connections += b.Connections
+	}
+	return points
+}
+
+// TopTalkers returns the client IPs of a service with the most traffic since the given time
+// by is "bytes" (in + out), "bytes_in", "bytes_out" or "connections"; traffic of the
+// current, not yet sampled minute is included
+func (h *History) TopTalkers(serviceID string, since time.Time, by string, limit int) []Talker {
+	totals := make(map[string]*Talker)
+	talker := func(ip string) *Talker {
+		t, ok := totals[ip]
+		if !ok {
+			t = &Talker{IP: ip}
+			totals[ip] = t
+		}
+		return t
+	}
+
+	prefix := serviceID + "|"
+	h.mu.RLock()
+	for key, series := range h.series[kindServiceIP] {
+		ip, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		i := sort.Search(len(series), func(i int) bool { return !series[i].Time.Before(since) })
+		for _, b := range series[i:] {
+			t := talker(ip)
+			t.BytesIn += b.BytesIn
+			t.BytesOut += b.BytesOut
+			t.Connections += b.Connections
+		}
+	}
+	h.mu.RUnlock()
+
+	h.counters.Range(func(k, v interface{}) bool {
+		key := k.(counterKey)
+		if key.serviceID != serviceID {
+			return true
+		}
+		c := v.(*counter)
+		t := talker(key.clientIP)
+		t.BytesIn += atomic.LoadInt64(&c.bytesIn)
+		t.BytesOut += atomic.LoadInt64(&c.bytesOut)
+		t.Connections += atomic.LoadInt64(&c.connections)
+		return true
+	})
+
+	metric := func(t *Talker) int64 {
+		switch by {
+		case "bytes_in":
+			return t.BytesIn
+		case "bytes_out":
+			return t.BytesOut
+		case "connections":
+			return t.Connections
+		default:
+			return t.BytesIn + t.BytesOut
+		}
+	}
+
+	talkers := make([]Talker, 0, len(totals))
+	for _, t := range totals {
+		if metric(t) > 0 {
+			talkers = append(talkers, *t)
+		}
+	}
+	sort.Slice(talkers, func(i, j int) bool {
+		mi, mj := metric(&talkers[i]), metric(&talkers[j])
+		if mi != mj {
+			return mi > mj
+		}
+		return talkers[i].IP < talkers[j].IP
+	})
+	if limit > 0 && len(talkers) > limit {
+		talkers = talkers[:limit]
+	}
+	return talkers
+}
+
+// Keys returns the keys of all series of a kind
+func (h *History) Keys(kind string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	keys := make([]string, 0, len(h.series[kind]))
+	for key := range h.series[kind] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Enabled reports whether history is being recorded
+func (h *History) Enabled() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.enabled
+}
+
+// Retention returns how long buckets are kept
+func (h *History) Retention() time.Duration {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.retention
+}
+
+// load reads persisted history from disk
+func (h *History) load() {
+	if h.path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", h.path).Msg("Failed to read stats history")
+		}
+		return
+	}
+
+	var series map[string]map[string][]Bucket
+	if err := json.Unmarshal(data, &series); err != nil {
+		log.Warn().Err(err).Str("path", h.path).Msg("Failed to parse stats history, starting empty")
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, kind := range []string{KindService, KindIP, kindServiceIP} {
+		if series[kind] != nil {
+			h.series[kind] = series[kind]
+		}
+	}
+	h.prune(time.Now())
+	h.dirty = false
+}
+
+// save writes the history to disk if it changed
+func (h *History) save() {
+	if h.path == "" {
+		return
+	}
+
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(h.series)
+	h.dirty = false
+	h.mu.Unlock()
+
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode stats history")
+		return
+	}
+	if err := writeFileAtomic(h.path, data); err != nil {
+		log.Error().Err(err).Str("path", h.path).Msg("Failed to save stats history")
+	}
+}
+
+// writeFileAtomic replaces a file so readers never see a partial write
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
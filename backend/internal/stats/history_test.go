@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
+
+func TestSampleKeepsConcurrentTraffic(t *testing.T) {
+	h := NewHistory(&config.StatsHistoryConfiguration{Enabled: true, RetentionDays: 1}, "")
+	start := time.Now()
+
+	const writers, writes = 8, 20000
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				// Many keys, so counters are often idle when sampled
+				h.RecordTraffic("svc", "192.0.2."+strconv.Itoa((i*writes+j)%250), 1, 0)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for sampling := true; sampling; {
+		select {
+		case <-done:
+			sampling = false
+		default:
+		}
+		h.sample(start)
+	}
+
+	var total int64
+	for _, b := range h.Query(KindService, "svc", start.Add(-time.Hour), time.Hour) {
+		total += b.BytesIn
+	}
+	if total != writers*writes {
+		t.Fatalf("recorded %d bytes, want %d", total, writers*writes)
+	}
+}
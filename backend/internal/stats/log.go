@@ -0,0 +1,6 @@
+package stats
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["stats"] overrides its level
+var log = logging.Module("stats")
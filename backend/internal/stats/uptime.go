@@ -0,0 +1,250 @@
+package stats
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	uptimeBucketSize       = time.Hour
+	defaultUptimeRetention = 30 * 24 * time.Hour
+	maxIncidents           = 200 // Per service; the oldest are dropped first
+	uptimeFile             = "uptime_history.json"
+)
+
+// UptimeBucket is the health checks of one backend during one hour
+type UptimeBucket struct {
+	Time         time.Time `json:"time"`
+	Checks       int       `json:"checks"`
+	Up           int       `json:"up"`
+	LatencySumMs float64   `json:"latency_sum_ms"` // Of the successful checks
+}
+
+// Incident is a period in which a backend failed its health checks
+type Incident struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"` // Nil while the backend is still down
+	Error string     `json:"error"`         // Of the first failed check
+}
+
+// UptimePoint is one step of an uptime trend
+type UptimePoint struct {
+	Time         time.Time `json:"time"`
+	Checks       int       `json:"checks"`
+	Up           int       `json:"up"`
+	AvgLatencyMs float64   `json:"avg_latency_ms"` // 0 without successful checks
+}
+
+// UptimeReport summarizes the health checks of a backend since a point in time
+type UptimeReport struct {
+	Checks        int           `json:"checks"`
+	UptimePercent *float64      `json:"uptime_percent"` // Nil without checks
+	AvgLatencyMs  float64       `json:"avg_latency_ms"`
+	Incidents     []Incident    `json:"incidents"` // Newest first
+	Trend         []UptimePoint `json:"trend"`
+}
+
+// uptimeSeries is the persisted health history of one service
+type uptimeSeries struct {
+	Buckets   []UptimeBucket `json:"buckets"` // Oldest first
+	Incidents []Incident     `json:"incidents"`
+}
+
+// RecordProbe adds a backend health check result of a service
+func (h *History) RecordProbe(serviceID string, reachable bool, latencyMs float64, errMsg string, at time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.enabled {
+		return
+	}
+	series := h.uptime[serviceID]
+	if series == nil {
+		series = &uptimeSeries{}
+		h.uptime[serviceID] = series
+	}
+
+	bucketTime := at.Truncate(uptimeBucketSize)
+	if n := len(series.Buckets); n == 0 || !series.Buckets[n-1].Time.Equal(bucketTime) {
+		series.Buckets = append(series.Buckets, UptimeBucket{Time: bucketTime})
+	}
+	bucket := &series.Buckets[len(series.Buckets)-1]
+	bucket.Checks++
+	if reachable {
+		bucket.Up++
+		bucket.LatencySumMs += latencyMs
+	}
+
+	var open *Incident
+	if n := len(series.Incidents); n > 0 && series.Incidents[n-1].End == nil {
+		open = &series.Incidents[n-1]
+	}
+	switch {
+	case !reachable && open == nil:
+		series.Incidents = append(series.Incidents, Incident{Start: at, Error: errMsg})
+		if len(series.Incidents) > maxIncidents {
+			series.Incidents = append([]Incident(nil), series.Incidents[len(series.Incidents)-maxIncidents:]...)
+		}
+	case reachable && open != nil:
+		end := at
+		open.End = &end
+	}
+	h.uptimeDirty = true
+}
+
+// Uptime returns the availability, incidents and latency trend of a service since the given time
+// Trend steps without checks are included with zero values so graphs have a continuous axis
+func (h *History) Uptime(serviceID string, since time.Time, step time.Duration) UptimeReport {
+	if step < uptimeBucketSize {
+		step = uptimeBucketSize
+	}
+	step = step.Truncate(uptimeBucketSize)
+	since = since.Truncate(step)
+	now := time.Now()
+
+	report := UptimeReport{
+		Incidents: []Incident{},
+		Trend:     make([]UptimePoint, 0, int(now.Sub(since)/step)+1),
+	}
+	for t := since; !t.After(now); t = t.Add(step) {
+		report.Trend = append(report.Trend, UptimePoint{Time: t})
+	}
+	latencySums := make([]float64, len(report.Trend))
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	series := h.uptime[serviceID]
+	if series == nil {
+		return report
+	}
+
+	up, latencySum := 0, 0.0
+	for _, b := range series.Buckets {
+		if b.Time.Before(since) {
+			continue
+		}
+		i := int(b.Time.Sub(since) / step)
+		if i >= len(report.Trend) {
+			break
+		}
+		report.Trend[i].Checks += b.Checks
+		report.Trend[i].Up += b.Up
+		latencySums[i] += b.LatencySumMs
+		report.Checks += b.Checks
+		up += b.Up
+		latencySum += b.LatencySumMs
+	}
+	for i := range report.Trend {
+		if report.Trend[i].Up > 0 {
+			report.Trend[i].AvgLatencyMs = roundMs(latencySums[i] / float64(report.Trend[i].Up) / 1000)
+		}
+	}
+	if report.Checks > 0 {
+		// Truncated, so any failed check shows below 100
+		percent := float64(int(float64(up)/float64(report.Checks)*10000)) / 100
+		report.UptimePercent = &percent
+	}
+	if up > 0 {
+		report.AvgLatencyMs = roundMs(latencySum / float64(up) / 1000)
+	}
+
+	for i := len(series.Incidents) - 1; i >= 0; i-- {
+		incident := series.Incidents[i]
+		if incident.End != nil && incident.End.Before(since) {
+			break
+		}
+		report.Incidents = append(report.Incidents, incident)
+	}
+	return report
+}
+
+// UptimeRetention returns how long health check history is kept
+func (h *History) UptimeRetention() time.Duration {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.uptimeRetention
+}
+
+// pruneUptime drops buckets and finished incidents older than the retention; must be called with mu held
+func (h *History) pruneUptime(now time.Time) {
+	cutoff := now.Add(-h.uptimeRetention)
+	for serviceID, series := range h.uptime {
+		i := sort.Search(len(series.Buckets), func(i int) bool { return !series.Buckets[i].Time.Before(cutoff) })
+		j := sort.Search(len(series.Incidents), func(j int) bool {
+			end := series.Incidents[j].End
+			return end == nil || !end.Before(cutoff)
+		})
+		if i == 0 && j == 0 {
+			continue
+		}
+		if i == len(series.Buckets) && j == len(series.Incidents) {
+			delete(h.uptime, serviceID)
+		} else {
+			series.Buckets = append([]UptimeBucket(nil), series.Buckets[i:]...)
+			series.Incidents = append([]Incident(nil), series.Incidents[j:]...)
+		}
+		h.uptimeDirty = true
+	}
+}
+
+// loadUptime reads persisted health history from disk
+func (h *History) loadUptime() {
+	if h.uptimePath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(h.uptimePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("path", h.uptimePath).Msg("Failed to read uptime history")
+		}
+		return
+	}
+
+	var uptime map[string]*uptimeSeries
+	if err := json.Unmarshal(data, &uptime); err != nil {
+		log.Warn().Err(err).Str("path", h.uptimePath).Msg("Failed to parse uptime history, starting empty")
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for serviceID, series := range uptime {
+		if series != nil {
+			h.uptime[serviceID] = series
+		}
+	}
+	h.pruneUptime(time.Now())
+	h.uptimeDirty = false
+}
+
+// saveUptime writes the health history to disk if it changed
+func (h *History) saveUptime() {
+	if h.uptimePath == "" {
+		return
+	}
+
+	h.mu.Lock()
+	if !h.uptimeDirty {
+		h.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(h.uptime)
+	h.uptimeDirty = false
+	h.mu.Unlock()
+
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode uptime history")
+		return
+	}
+	if err := writeFileAtomic(h.uptimePath, data); err != nil {
+		log.Error().Err(err).Str("path", h.uptimePath).Msg("Failed to save uptime history")
+	}
+}
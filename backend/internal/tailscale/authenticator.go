@@ -0,0 +1,323 @@
+// Package tailscale grants portal access based on Tailscale identity.
+//
+// When a client connects from a tailnet address without an allowlist entry, the local
+// tailscaled is asked who owns the address. Mapped tailnet members get a portal session
+// for the mapped user, exactly as if they had knocked with a password.
+package tailscale
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/ipallowlist"
+	"github.com/davbauer/knock-knock-portal/internal/schedule"
+	"github.com/davbauer/knock-knock-portal/internal/session"
+)
+
+// minDeniedCache is how long a miss is remembered at least, even with cache_seconds 0, so a client
+// retrying in a loop cannot keep tailscaled busy
+const minDeniedCache = 5 * time.Second
+
+// whoisTimeout bounds a single lookup at tailscaled
+const whoisTimeout = 5 * time.Second
+
+// Tailscale assigns node addresses from these ranges
+var tailnetPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+}
+
+// Authenticator creates portal sessions for tailnet members
+type Authenticator struct {
+	configLoader     *config.Loader
+	sessionManager   *session.Manager
+	allowlistManager *ipallowlist.Manager
+	mu               sync.Mutex
+	cfg              config.TailscaleConfiguration
+	client           *client
+	denied           map[netip.Addr]time.Time // Cached misses until the given time
+	inflight         map[netip.Addr]*lookup   // Lookups in progress; callers for the same IP wait for the first
+	granted          int64
+	deniedCount      int64
+	lastError        string
+}
+
+// lookup is a whois in progress for one IP
+type lookup struct {
+	done    chan struct{}
+	granted bool // Valid once done is closed
+}
+
+// NewAuthenticator creates a Tailscale authenticator and registers it with the allowlist
+func NewAuthenticator(configLoader *config.Loader, sessionManager *session.Manager, allowlistManager *ipallowlist.Manager, cfg *config.TailscaleConfiguration) *Authenticator {
+	a := &Authenticator{
+		configLoader:     configLoader,
+		sessionManager:   sessionManager,
+		allowlistManager: allowlistManager,
+	}
+
+	a.Reload(cfg)
+	allowlistManager.SetAccessResolver(a.Authorize)
+	return a
+}
+
+// Reload applies a new configuration and forgets cached lookups
+func (a *Authenticator) Reload(cfg *config.TailscaleConfiguration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	wasEnabled := a.cfg.Enabled
+	a.cfg = *cfg
+	a.denied = make(map[netip.Addr]time.Time)
+	a.inflight = make(map[netip.Addr]*lookup)
+	a.client = nil
+
+	if cfg.Enabled {
+		a.client = newClient(cfg.SocketPath)
+		log.Info().
+			Str("socket", cfg.SocketPath).
+			Int("user_mappings", len(cfg.UserMappings)).
+			Int("group_mappings", len(cfg.GroupMappings)).
+			Msg("Tailscale identity access enabled")
+	} else if wasEnabled {
+		log.Info().Msg("Tailscale identity access disabled")
+	}
+}
+
+// Authorize creates a session for a tailnet address owned by a mapped identity
+// Returns true if the address was granted access
+// tailscaled is asked outside the lock, so a slow lookup only delays connections from the same IP
+func (a *Authenticator) Authorize(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !isTailnetAddr(ip) {
+		return false
+	}
+
+	a.mu.Lock()
+	client := a.client
+	if client == nil {
+		a.mu.Unlock()
+		return false
+	}
+	if until, ok := a.denied[ip]; ok && time.Now().Before(until) {
+		a.mu.Unlock()
+		return false
+	}
+
+	// Concurrent packets from one client wait for a single lookup, so only one session is created
+	if pending, ok := a.inflight[ip]; ok {
+		a.mu.Unlock()
+		<-pending.done
+		return pending.granted
+	}
+
+	// Another caller may have created a session before this one took the lock
+	if sess, ok := a.sessionManager.FindSessionForIP(ip); ok && !sess.IsExpired() {
+		a.mu.Unlock()
+		a.allowlistManager.AddSessionIP(sess.SessionID, ip, sess.ExpiresAt)
+		return true
+	}
+
+	pending := &lookup{done: make(chan struct{})}
+	a.inflight[ip] = pending
+	a.mu.Unlock()
+
+	pending.granted = a.authorize(client, ip)
+
+	a.mu.Lock()
+	if a.inflight[ip] == pending {
+		delete(a.inflight, ip)
+	}
+	a.mu.Unlock()
+	close(pending.done)
+	return pending.granted
+}
+
+// authorize asks tailscaled who owns an address and creates a session for a mapped identity
+func (a *Authenticator) authorize(client *client, ip netip.Addr) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), whoisTimeout)
+	defer cancel()
+
+	id, err := client.whois(ctx, ip)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// A reload while tailscaled was asked replaces the client and its caches; drop the stale answer
+	if a.client != client {
+		return false
+	}
+	if err != nil {
+		a.lastError = err.Error()
+		log.Warn().Err(err).Str("ip", ip.String()).Msg("Tailscale whois failed")
+		a.deny(ip)
+		return false
+	}
+	if id == nil {
+		a.deny(ip)
+		return false
+	}
+
+	user := a.mapUser(id)
+	if user == nil {
+		log.Info().
+			Str("ip", ip.String()).
+			Str("tailscale_login", id.UserProfile.LoginName).
+			Str("tailscale_node", id.Node.Name).
+			Msg("Tailnet member has no portal user mapping, access denied")
+		a.deny(ip)
+		return false
+	}
+
+	if !schedule.IsOpen(user.Schedule, time.Now()) {
+		log.Info().
+			Str("ip", ip.String()).
+			Str("username", user.Username).
+			Msg("Tailnet member is outside the user's schedule, access denied")
+		a.deny(ip)
+		return false
+	}
+
+	sess, err := a.sessionManager.CreateSession(user.UserID, user.Username, ip, user.AllowedServiceIDs, session.LimitsFor(user))
+	if err != nil {
+		a.lastError = err.Error()
+		log.Error().Err(err).Str("ip", ip.String()).Msg("Failed to create session for tailnet member")
+		return false
+	}
+
+	// Tailnet addresses are per node, so never widen the grant to a prefix
+	a.allowlistManager.AddSessionIP(sess.SessionID, ip, sess.ExpiresAt)
+	a.granted++
+
+	log.Info().
+		Str("ip", ip.String()).
+		Str("tailscale_login", id.UserProfile.LoginName).
+		Str("tailscale_node", id.Node.Name).
+		Str("username", user.Username).
+		Strs("groups", user.Groups).
+		Str("session_id", sess.SessionID).
+		Msg("Tailnet member authenticated via Tailscale identity")
+	return true
+}
+
+// mapUser finds the portal user for an identity: node tags first, then login name, then the default
+// When group mappings match, their user_groups replace the user's own groups and allowed_service_ids
+func (a *Authenticator) mapUser(id *identity) *config.PortalUserAccount {
+	userID := ""
+	for _, mapping := range a.cfg.UserMappings {
+		for _, tag := range id.Node.Tags {
+			if mapping.LoginName == tag {
+				userID = mapping.UserID
+				break
+			}
+		}
+		if userID != "" {
+			break
+		}
+	}
+	if userID == "" {
+		for _, mapping := range a.cfg.UserMappings {
+			if mapping.LoginName == id.UserProfile.LoginName {
+				userID = mapping.UserID
+				break
+			}
+		}
+	}
+	if userID == "" {
+		userID = a.cfg.DefaultUserID
+	}
+	if userID == "" {
+		return nil
+	}
+
+	cfg := a.configLoader.GetConfig()
+	for i := range cfg.PortalUserAccounts {
+		if cfg.PortalUserAccounts[i].UserID == userID {
+			user := cfg.PortalUserAccounts[i]
+			groups := a.mapGroups(id)
+			if len(groups) == 0 {
+				return config.EffectiveUser(cfg, &user)
+			}
+			user.Groups = groups
+			effective := config.EffectiveUser(cfg, &user)
+			effective.AllowedServiceIDs = mappedServices(cfg, groups, user.AllowedServiceIDs)
+			return effective
+		}
+	}
+	return nil
+}
+
+// mapGroups returns the user_groups of every group mapping matching the identity's node tags or login name
+// Mappings are evaluated in config order and repeated groups are dropped, so the result is deterministic
+func (a *Authenticator) mapGroups(id *identity) []string {
+	var groups []string
+	for _, mapping := range a.cfg.GroupMappings {
+		if mapping.LoginName != id.UserProfile.LoginName && !slices.Contains(id.Node.Tags, mapping.LoginName) {
+			continue
+		}
+		for _, group := range mapping.Groups {
+			if !slices.Contains(groups, group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+// mappedServices returns the services listed by the mapped groups, or the user's own when none of them lists any
+// A mapped group without allowed_service_ids must not widen the user to every service
+func mappedServices(cfg *config.ApplicationConfig, groups []string, own []string) []string {
+	var services []string
+	for _, name := range groups {
+		for i := range cfg.UserGroups {
+			if cfg.UserGroups[i].Name != name {
+				continue
+			}
+			for _, service := range cfg.UserGroups[i].AllowedServiceIDs {
+				if !slices.Contains(services, service) {
+					services = append(services, service)
+				}
+			}
+		}
+	}
+	if len(services) == 0 {
+		return slices.Clone(own)
+	}
+	return services
+}
+
+// deny remembers a miss for the configured cache duration, but at least minDeniedCache; must be called with mu held
+func (a *Authenticator) deny(ip netip.Addr) {
+	a.deniedCount++
+	a.denied[ip] = time.Now().Add(max(time.Duration(a.cfg.CacheSeconds)*time.Second, minDeniedCache))
+}
+
+// isTailnetAddr reports whether an address belongs to Tailscale's address ranges
+func isTailnetAddr(ip netip.Addr) bool {
+	for _, prefix := range tailnetPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStats returns authenticator statistics
+func (a *Authenticator) GetStats() map[string]interface{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"enabled":          a.cfg.Enabled,
+		"sessions_created": a.granted,
+		"denied":           a.deniedCount,
+	}
+	if a.lastError != "" {
+		stats["last_error"] = a.lastError
+	}
+	return stats
+}
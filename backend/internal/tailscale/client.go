@@ -0,0 +1,78 @@
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client talks to the local tailscaled LocalAPI over its unix socket
+type client struct {
+	httpClient *http.Client
+}
+
+// identity is the subset of a LocalAPI whois response the portal needs
+type identity struct {
+	Node struct {
+		Name string   `json:"Name"`
+		Tags []string `json:"Tags"`
+	} `json:"Node"`
+	UserProfile struct {
+		LoginName   string `json:"LoginName"`
+		DisplayName string `json:"DisplayName"`
+	} `json:"UserProfile"`
+}
+
+// newClient creates a LocalAPI client for the given socket path
+func newClient(socketPath string) *client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	return &client{
+		httpClient: &http.Client{Transport: transport, Timeout: 5 * time.Second},
+	}
+}
+
+// whois returns the tailnet identity owning an address
+func (c *client) whois(ctx context.Context, addr netip.Addr) (*identity, error) {
+	// The host name is required by the LocalAPI but never resolved
+	target := "http://local-tailscaled.sock/localapi/v0/whois?addr=" + url.QueryEscape(addr.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tailscale LocalAPI request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read whois response: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tailscale whois returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var id identity
+	if err := json.Unmarshal(body, &id); err != nil {
+		return nil, fmt.Errorf("failed to decode whois response: %w", err)
+	}
+	return &id, nil
+}
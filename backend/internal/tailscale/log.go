@@ -0,0 +1,6 @@
+package tailscale
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["tailscale"] overrides its level
+var log = logging.Module("tailscale")
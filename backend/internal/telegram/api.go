@@ -0,0 +1,155 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// apiBaseURL is the Telegram Bot API endpoint
+const apiBaseURL = "https://api.telegram.org"
+
+// client calls Telegram Bot API methods
+type client struct {
+	token      string
+	httpClient *http.Client
+}
+
+func newClient(token string) *client {
+	return &client{
+		token: token,
+		// Longer than the getUpdates long-poll timeout
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// apiResponse is the envelope of every Bot API response
+type apiResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+}
+
+type chat struct {
+	ID int64 `json:"id"`
+}
+
+type user struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type message struct {
+	MessageID int64 `json:"message_id"`
+	Chat      chat  `json:"chat"`
+}
+
+type callbackQuery struct {
+	ID      string   `json:"id"`
+	From    user     `json:"from"`
+	Message *message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type update struct {
+	UpdateID      int64          `json:"update_id"`
+	CallbackQuery *callbackQuery `json:"callback_query"`
+}
+
+type inlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type inlineKeyboardMarkup struct {
+	InlineKeyboard [][]inlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// call invokes a Bot API method and decodes its result into out (if non-nil)
+func (c *client) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/bot%s/%s", apiBaseURL, c.token, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The request URL contains the bot token; don't leak it into logs
+		return fmt.Errorf("telegram %s request failed", method)
+	}
+	defer resp.Body.Close()
+
+	var apiResp apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return fmt.Errorf("telegram %s: invalid response (status %d)", method, resp.StatusCode)
+	}
+	if !apiResp.OK {
+		return fmt.Errorf("telegram %s: %s", method, apiResp.Description)
+	}
+
+	if out != nil {
+		return json.Unmarshal(apiResp.Result, out)
+	}
+	return nil
+}
+
+// sendMessage posts a message, optionally with inline buttons
+func (c *client) sendMessage(ctx context.Context, chatID int64, text string, markup *inlineKeyboardMarkup) (*message, error) {
+	params := map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	}
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+
+	var msg message
+	if err := c.call(ctx, "sendMessage", params, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// SendMessage posts a plain text message to a chat, for notifications outside the approval bot
+func SendMessage(ctx context.Context, token string, chatID int64, text string) error {
+	_, err := newClient(token).sendMessage(ctx, chatID, text, nil)
+	return err
+}
+
+// editMessageText replaces a message's text and removes its buttons
+func (c *client) editMessageText(ctx context.Context, chatID, messageID int64, text string) error {
+	return c.call(ctx, "editMessageText", map[string]interface{}{
+		"chat_id":      chatID,
+		"message_id":   messageID,
+		"text":         text,
+		"reply_markup": inlineKeyboardMarkup{InlineKeyboard: [][]inlineKeyboardButton{}},
+	}, nil)
+}
+
+// answerCallbackQuery acknowledges a button press with a short toast
+func (c *client) answerCallbackQuery(ctx context.Context, callbackID, text string) error {
+	return c.call(ctx, "answerCallbackQuery", map[string]interface{}{
+		"callback_query_id": callbackID,
+		"text":              text,
+	}, nil)
+}
+
+// getUpdates long-polls for button presses after offset
+func (c *client) getUpdates(ctx context.Context, offset int64, timeoutSeconds int) ([]update, error) {
+	var updates []update
+	err := c.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         timeoutSeconds,
+		"allowed_updates": []string{"callback_query"},
+	}, &updates)
+	return updates, err
+}
@@ -0,0 +1,311 @@
+// Package telegram posts pending login approval requests to Telegram chats.
+//
+// Each request is sent with inline Approve/Deny buttons. Button presses reach the portal through
+// long-polling (getUpdates), so no public webhook endpoint is needed. Once a request is decided,
+// from Telegram, the admin UI, or by expiring, the posted messages are edited to show the outcome.
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davbauer/knock-knock-portal/internal/approval"
+	"github.com/davbauer/knock-knock-portal/internal/config"
+	"github.com/davbauer/knock-knock-portal/internal/events"
+	"github.com/davbauer/knock-knock-portal/internal/utils"
+)
+
+const (
+	pollTimeoutSeconds = 30
+	retryDelay         = 5 * time.Second
+	callbackApprove    = "approve:"
+	callbackDeny       = "deny:"
+)
+
+// sentMessage identifies a posted request message so it can be edited later
+type sentMessage struct {
+	chatID    int64
+	messageID int64
+}
+
+// Bot relays approval requests to Telegram and applies decisions made there
+type Bot struct {
+	configLoader    *config.Loader
+	approvalManager *approval.Manager
+
+	mu          sync.Mutex
+	cfg         config.TelegramApprovalConfig
+	client      *client
+	messages    map[string][]sentMessage // request ID -> posted messages
+	stopChan    chan struct{}
+	stoppedChan chan struct{}
+
+	unsubscribe func()
+	eventsDone  chan struct{}
+}
+
+// NewBot creates a Telegram bot and starts it if enabled
+func NewBot(configLoader *config.Loader, approvalManager *approval.Manager, bus *events.Bus) *Bot {
+	b := &Bot{
+		configLoader:    configLoader,
+		approvalManager: approvalManager,
+		messages:        make(map[string][]sentMessage),
+		eventsDone:      make(chan struct{}),
+	}
+
+	ch, unsubscribe := bus.Subscribe(32)
+	b.unsubscribe = unsubscribe
+	go b.handleEvents(ch)
+
+	b.Reload()
+	return b
+}
+
+// Reload applies the current configuration, restarting the polling loop
+func (b *Bot) Reload() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Avoid dropping an in-flight long poll when unrelated settings change
+	cfg := b.configLoader.GetConfig().ApprovalConfig.Telegram
+	if b.client != nil && cfg.Enabled && cfg.BotToken == b.cfg.BotToken && slices.Equal(cfg.ChatIDs, b.cfg.ChatIDs) {
+		return
+	}
+
+	b.stop()
+
+	b.cfg = cfg
+	b.client = nil
+	if !b.cfg.Enabled {
+		return
+	}
+
+	b.client = newClient(b.cfg.BotToken)
+	b.stopChan = make(chan struct{})
+	b.stoppedChan = make(chan struct{})
+	go b.poll(b.client, b.cfg.ChatIDs, b.stopChan, b.stoppedChan)
+
+	log.Info().Int("chats", len(b.cfg.ChatIDs)).Msg("Telegram approval bot started")
+}
+
+// Close stops polling and event handling
+func (b *Bot) Close() {
+	b.mu.Lock()
+	b.stop()
+	b.mu.Unlock()
+
+	b.unsubscribe()
+	<-b.eventsDone
+}
+
+// stop halts the polling loop; must be called with mu held
+func (b *Bot) stop() {
+	if b.stopChan == nil {
+		return
+	}
+
+	close(b.stopChan)
+	<-b.stoppedChan
+	b.stopChan = nil
+	b.stoppedChan = nil
+}
+
+// handleEvents posts new requests and updates messages of decided ones
+func (b *Bot) handleEvents(ch <-chan events.Event) {
+	defer close(b.eventsDone)
+
+	for event := range ch {
+		req, ok := event.Data.(approval.Request)
+		if !ok {
+			continue
+		}
+
+		switch event.Type {
+		case approval.EventRequested:
+			b.notify(req)
+		case approval.EventDecided:
+			b.finalize(req)
+		}
+	}
+}
+
+// notify posts a new request with Approve/Deny buttons to every configured chat
+func (b *Bot) notify(req approval.Request) {
+	b.mu.Lock()
+	cl := b.client
+	chatIDs := b.cfg.ChatIDs
+	b.mu.Unlock()
+	if cl == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	text := b.describe(req)
+	markup := &inlineKeyboardMarkup{
+		InlineKeyboard: [][]inlineKeyboardButton{{
+			{Text: "✅ Approve", CallbackData: callbackApprove + req.ID},
+			{Text: "❌ Deny", CallbackData: callbackDeny + req.ID},
+		}},
+	}
+
+	var sent []sentMessage
+	for _, chatID := range chatIDs {
+		msg, err := cl.sendMessage(ctx, chatID, text, markup)
+		if err != nil {
+			log.Warn().Err(err).Int64("chat_id", chatID).Str("request_id", req.ID).Msg("Failed to post approval request to Telegram")
+			continue
+		}
+		sent = append(sent, sentMessage{chatID: chatID, messageID: msg.MessageID})
+	}
+
+	b.mu.Lock()
+	b.messages[req.ID] = sent
+	b.mu.Unlock()
+}
+
+// finalize replaces the buttons of a decided request with its outcome
+func (b *Bot) finalize(req approval.Request) {
+	b.mu.Lock()
+	cl := b.client
+	sent := b.messages[req.ID]
+	delete(b.messages, req.ID)
+	b.mu.Unlock()
+	if cl == nil || len(sent) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	outcome := strings.ToUpper(string(req.Status))
+	if req.DecidedAt != nil {
+		outcome += " at " + req.DecidedAt.Format("15:04:05")
+	}
+	text := b.describe(req) + "\n\n" + outcome
+
+	for _, m := range sent {
+		if err := cl.editMessageText(ctx, m.chatID, m.messageID, text); err != nil {
+			log.Debug().Err(err).Int64("chat_id", m.chatID).Str("request_id", req.ID).Msg("Failed to update Telegram approval message")
+		}
+	}
+}
+
+// describe renders a request as message text
+func (b *Bot) describe(req approval.Request) string {
+	services := "all services"
+	if len(req.AllowedServiceIDs) > 0 {
+		services = strings.Join(utils.GetServiceNames(b.configLoader.GetConfig(), req.AllowedServiceIDs), ", ")
+	}
+
+	return fmt.Sprintf("Login request\nUser: %s\nIP: %s\nServices: %s\nExpires: %s",
+		req.Username, req.ClientIP, services, req.ExpiresAt.Format("15:04:05"))
+}
+
+// poll long-polls Telegram for button presses until stopped
+// chatIDs is fixed for the lifetime of the loop; Reload restarts it with new settings
+func (b *Bot) poll(cl *client, chatIDs []int64, stop, stopped chan struct{}) {
+	defer close(stopped)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	var offset int64
+	for {
+		updates, err := cl.getUpdates(ctx, offset, pollTimeoutSeconds)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warn().Err(err).Msg("Telegram polling failed")
+			select {
+			case <-time.After(retryDelay):
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.CallbackQuery != nil {
+				b.handleCallback(ctx, cl, chatIDs, u.CallbackQuery)
+			}
+		}
+	}
+}
+
+// handleCallback applies an Approve/Deny button press
+func (b *Bot) handleCallback(ctx context.Context, cl *client, chatIDs []int64, q *callbackQuery) {
+	if q.Message == nil || !isAllowedChat(chatIDs, q.Message.Chat.ID) {
+		log.Warn().Int64("telegram_user_id", q.From.ID).Msg("Telegram approval from unauthorized chat ignored")
+		b.answer(ctx, cl, q.ID, "Not authorized")
+		return
+	}
+
+	var (
+		req      *approval.Request
+		err      error
+		decision string
+	)
+	decidedBy := "telegram:" + q.From.Username
+	if q.From.Username == "" {
+		decidedBy = fmt.Sprintf("telegram:%d", q.From.ID)
+	}
+	switch {
+	case strings.HasPrefix(q.Data, callbackApprove):
+		decision = "approve"
+		req, err = b.approvalManager.Approve(strings.TrimPrefix(q.Data, callbackApprove), decidedBy)
+	case strings.HasPrefix(q.Data, callbackDeny):
+		decision = "deny"
+		req, err = b.approvalManager.Deny(strings.TrimPrefix(q.Data, callbackDeny), decidedBy)
+	default:
+		b.answer(ctx, cl, q.ID, "Unknown action")
+		return
+	}
+
+	switch {
+	case errors.Is(err, approval.ErrNotFound):
+		b.answer(ctx, cl, q.ID, "Request not found")
+	case errors.Is(err, approval.ErrNotPending):
+		b.answer(ctx, cl, q.ID, "Request is no longer pending")
+	case err != nil:
+		log.Error().Err(err).Msg("Failed to apply Telegram approval decision")
+		b.answer(ctx, cl, q.ID, "Failed to apply decision")
+	default:
+		log.Info().
+			Str("request_id", req.ID).
+			Str("decision", decision).
+			Int64("telegram_user_id", q.From.ID).
+			Str("telegram_username", q.From.Username).
+			Msg("Login request decided via Telegram")
+		b.answer(ctx, cl, q.ID, "Request "+string(req.Status))
+	}
+}
+
+// answer acknowledges a button press
+func (b *Bot) answer(ctx context.Context, cl *client, callbackID, text string) {
+	if err := cl.answerCallbackQuery(ctx, callbackID, text); err != nil {
+		log.Debug().Err(err).Msg("Failed to answer Telegram callback")
+	}
+}
+
+// isAllowedChat reports whether a chat may decide requests
+func isAllowedChat(chatIDs []int64, chatID int64) bool {
+	for _, id := range chatIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
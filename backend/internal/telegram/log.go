@@ -0,0 +1,6 @@
+package telegram
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["telegram"] overrides its level
+var log = logging.Module("telegram")
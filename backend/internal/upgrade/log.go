@@ -0,0 +1,6 @@
+package upgrade
+
+import "github.com/davbauer/knock-knock-portal/internal/logging"
+
+// log is the package logger; logging_config.levels["upgrade"] overrides its level
+var log = logging.Module("upgrade")
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package upgrade
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifySignal relays SIGUSR2, the upgrade trigger, to c
+func NotifySignal(c chan<- os.Signal) bool {
+	signal.Notify(c, syscall.SIGUSR2)
+	return true
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package upgrade
+
+import "os"
+
+// NotifySignal is a no-op on Windows, which has no upgrade signal
+func NotifySignal(c chan<- os.Signal) bool {
+	return false
+}
@@ -0,0 +1,400 @@
+// Package upgrade implements zero-downtime binary upgrades.
+//
+// On upgrade the running process fork-execs the (new) binary on disk and hands it
+// every registered listener socket, live UDP proxy sessions and opaque runtime state.
+// Once the child reports ready, the parent stops accepting, drains its remaining TCP
+// connections and exits. Existing connections are never dropped.
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// handoverEnv carries the handover description from parent to child
+const handoverEnv = "KNOCK_KNOCK_HANDOVER"
+
+// firstInheritedFD is the descriptor number of the first file after stdin, stdout and stderr
+const firstInheritedFD = 3
+
+// UDPSession describes a live UDP proxy session handed to the new process
+type UDPSession struct {
+	ListenerKey string   `json:"listener_key"`
+	ClientAddr  string   `json:"client_addr"`
+	BackendAddr string   `json:"backend_addr"`
+	FD          int      `json:"fd"`
+	File        *os.File `json:"-"` // Backend socket (parent: dup to pass; child: inherited)
+}
+
+// UDPSessionExporter is implemented by proxies that hand over live UDP sessions
+type UDPSessionExporter interface {
+	// FreezeSessions stops forwarding and returns the sessions with duplicated backend sockets
+	FreezeSessions() []UDPSession
+	// ResumeSessions restarts forwarding after a failed upgrade
+	ResumeSessions()
+}
+
+// handover is serialized into the child's environment
+type handover struct {
+	Listeners   map[string]int             `json:"listeners"` // key -> fd
+	UDPSessions []UDPSession               `json:"udp_sessions"`
+	State       map[string]json.RawMessage `json:"state"`
+	ReadyFD     int                        `json:"ready_fd"`
+}
+
+type upgrader struct {
+	mu sync.Mutex
+
+	// Inherited from the parent (child side)
+	inheritedListeners map[string]*os.File
+	inheritedSessions  map[string][]UDPSession
+	inheritedState     map[string]json.RawMessage
+	readyFile          *os.File
+
+	// Registered in this process (parent side of the next upgrade)
+	listeners     map[string]interface{ File() (*os.File, error) }
+	exporters     map[string]UDPSessionExporter
+	stateDumpers  map[string]func() interface{}
+	upgradeActive bool
+}
+
+var std = &upgrader{
+	inheritedListeners: make(map[string]*os.File),
+	inheritedSessions:  make(map[string][]UDPSession),
+	inheritedState:     make(map[string]json.RawMessage),
+	listeners:          make(map[string]interface{ File() (*os.File, error) }),
+	exporters:          make(map[string]UDPSessionExporter),
+	stateDumpers:       make(map[string]func() interface{}),
+}
+
+// Init reads the handover left by a parent process; call once at startup
+// Returns true when this process was started by an upgrade
+func Init() bool {
+	raw := os.Getenv(handoverEnv)
+	if raw == "" {
+		return false
+	}
+	os.Unsetenv(handoverEnv)
+
+	var h handover
+	if err := json.Unmarshal([]byte(raw), &h); err != nil {
+		log.Error().Err(err).Msg("Invalid upgrade handover, starting fresh")
+		return false
+	}
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	for key, fd := range h.Listeners {
+		std.inheritedListeners[key] = os.NewFile(uintptr(fd), key)
+	}
+	for _, session := range h.UDPSessions {
+		session.File = os.NewFile(uintptr(session.FD), "udp-session")
+		std.inheritedSessions[session.ListenerKey] = append(std.inheritedSessions[session.ListenerKey], session)
+	}
+	for key, value := range h.State {
+		std.inheritedState[key] = value
+	}
+	if h.ReadyFD > 0 {
+		std.readyFile = os.NewFile(uintptr(h.ReadyFD), "ready")
+	}
+
+	log.Info().
+		Int("listeners", len(h.Listeners)).
+		Int("udp_sessions", len(h.UDPSessions)).
+		Msg("Started from binary upgrade, inheriting sockets")
+	return true
+}
+
+// ListenerKey identifies a listener by network and address
+func ListenerKey(network, address string) string {
+	return network + "/" + address
+}
+
+// Listen returns an inherited TCP listener for the address or creates a new one
+func Listen(network, address string) (net.Listener, error) {
+	return ListenConfig(&net.ListenConfig{}, network, address)
+}
+
+// ListenConfig is like Listen but creates new listeners with the given config
+// Socket options set by the config survive the handover with the inherited descriptor
+func ListenConfig(lc *net.ListenConfig, network, address string) (net.Listener, error) {
+	key := ListenerKey(network, address)
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	var listener net.Listener
+	if file, ok := std.inheritedListeners[key]; ok {
+		delete(std.inheritedListeners, key)
+		inherited, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to adopt inherited listener %s: %w", key, err)
+		}
+		listener = inherited
+	} else {
+		created, err := lc.Listen(context.Background(), network, address)
+		if err != nil {
+			return nil, err
+		}
+		listener = created
+	}
+
+	if tracked, ok := listener.(interface{ File() (*os.File, error) }); ok {
+		std.listeners[key] = tracked
+	}
+	return listener, nil
+}
+
+// ListenUDP returns an inherited UDP socket for the address or creates a new one
+func ListenUDP(network, address string) (*net.UDPConn, error) {
+	key := ListenerKey(network, address)
+
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	var conn *net.UDPConn
+	if file, ok := std.inheritedListeners[key]; ok {
+		delete(std.inheritedListeners, key)
+		inherited, err := net.FilePacketConn(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to adopt inherited UDP socket %s: %w", key, err)
+		}
+		udpConn, ok := inherited.(*net.UDPConn)
+		if !ok {
+			inherited.Close()
+			return nil, fmt.Errorf("inherited socket %s is not a UDP socket", key)
+		}
+		conn = udpConn
+	} else {
+		addr, err := net.ResolveUDPAddr(network, address)
+		if err != nil {
+			return nil, err
+		}
+		created, err := net.ListenUDP(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = created
+	}
+
+	std.listeners[key] = conn
+	return conn, nil
+}
+
+// RegisterUDPExporter registers a proxy whose live sessions should survive an upgrade
+func RegisterUDPExporter(key string, exporter UDPSessionExporter) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.exporters[key] = exporter
+}
+
+// UnregisterUDPExporter removes an exporter if it is still the registered one
+func UnregisterUDPExporter(key string, exporter UDPSessionExporter) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	if std.exporters[key] == exporter {
+		delete(std.exporters, key)
+	}
+}
+
+// InheritedUDPSessions returns (once) the UDP sessions handed over for a listener
+func InheritedUDPSessions(key string) []UDPSession {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	sessions := std.inheritedSessions[key]
+	delete(std.inheritedSessions, key)
+	return sessions
+}
+
+// RegisterState registers a snapshot function whose JSON result is passed to the new process
+func RegisterState(name string, dump func() interface{}) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	std.stateDumpers[name] = dump
+}
+
+// InheritedState decodes (once) the runtime state handed over under name
+// Returns false if there was none
+func InheritedState(name string, v interface{}) (bool, error) {
+	std.mu.Lock()
+	raw, ok := std.inheritedState[name]
+	delete(std.inheritedState, name)
+	std.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Ready tells the parent process that this process has taken over
+// Inherited sockets nobody claimed are closed
+func Ready() {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	for key, file := range std.inheritedListeners {
+		log.Warn().Str("listener", key).Msg("Inherited listener no longer configured, closing")
+		file.Close()
+		delete(std.inheritedListeners, key)
+	}
+	for key, sessions := range std.inheritedSessions {
+		for _, session := range sessions {
+			session.File.Close()
+		}
+		delete(std.inheritedSessions, key)
+	}
+
+	if std.readyFile != nil {
+		std.readyFile.Write([]byte{1})
+		std.readyFile.Close()
+		std.readyFile = nil
+		log.Info().Msg("Signalled readiness to previous process")
+	}
+}
+
+// Upgrade starts the binary on disk with all registered sockets and waits until it is ready
+// On success the caller must stop accepting and drain; on error this process keeps serving
+func Upgrade(readyTimeout time.Duration) error {
+	std.mu.Lock()
+	if std.upgradeActive {
+		std.mu.Unlock()
+		return fmt.Errorf("upgrade already in progress")
+	}
+	std.upgradeActive = true
+	std.mu.Unlock()
+
+	defer func() {
+		std.mu.Lock()
+		std.upgradeActive = false
+		std.mu.Unlock()
+	}()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create readiness pipe: %w", err)
+	}
+	defer readyRead.Close()
+
+	std.mu.Lock()
+	exporters := make([]UDPSessionExporter, 0, len(std.exporters))
+	for _, exporter := range std.exporters {
+		exporters = append(exporters, exporter)
+	}
+	std.mu.Unlock()
+
+	h := handover{
+		Listeners: make(map[string]int),
+		State:     make(map[string]json.RawMessage),
+	}
+	files := []*os.File{readyWrite}
+	h.ReadyFD = firstInheritedFD
+
+	closeFiles := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	std.mu.Lock()
+	for key, listener := range std.listeners {
+		file, err := listener.File()
+		if err != nil {
+			// Listener was closed (e.g. service removed) - forget it
+			delete(std.listeners, key)
+			continue
+		}
+		h.Listeners[key] = firstInheritedFD + len(files)
+		files = append(files, file)
+	}
+	for name, dump := range std.stateDumpers {
+		data, err := json.Marshal(dump())
+		if err != nil {
+			std.mu.Unlock()
+			closeFiles()
+			return fmt.Errorf("failed to serialize %s state: %w", name, err)
+		}
+		h.State[name] = data
+	}
+	std.mu.Unlock()
+
+	// Freeze UDP forwarding last so the pause is as short as possible
+	resume := func() {
+		for _, exporter := range exporters {
+			exporter.ResumeSessions()
+		}
+	}
+	for _, exporter := range exporters {
+		for _, session := range exporter.FreezeSessions() {
+			session.FD = firstInheritedFD + len(files)
+			files = append(files, session.File)
+			h.UDPSessions = append(h.UDPSessions, session)
+		}
+	}
+
+	encoded, err := json.Marshal(h)
+	if err != nil {
+		closeFiles()
+		resume()
+		return fmt.Errorf("failed to encode handover: %w", err)
+	}
+
+	procFiles := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	procFiles = append(procFiles, files...)
+
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   append(os.Environ(), handoverEnv+"="+string(encoded)),
+		Files: procFiles,
+	})
+	// The child holds its own copies now
+	closeFiles()
+	if err != nil {
+		resume()
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	log.Info().
+		Int("pid", process.Pid).
+		Int("listeners", len(h.Listeners)).
+		Int("udp_sessions", len(h.UDPSessions)).
+		Msg("Started new process, waiting for readiness")
+
+	readyChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyRead.Read(buf)
+		readyChan <- err
+	}()
+
+	select {
+	case err := <-readyChan:
+		if err == nil {
+			log.Info().Int("pid", process.Pid).Msg("New process is ready, handing over")
+			process.Release()
+			return nil
+		}
+		err = fmt.Errorf("new process exited before becoming ready: %w", err)
+		process.Wait()
+		resume()
+		return err
+	case <-time.After(readyTimeout):
+		process.Kill()
+		process.Wait()
+		resume()
+		return fmt.Errorf("new process did not become ready within %s", readyTimeout)
+	}
+}
@@ -1,6 +1,11 @@
 package utils
 
-import "github.com/davbauer/knock-knock-portal/internal/config"
+import (
+	"slices"
+	"strings"
+
+	"github.com/davbauer/knock-knock-portal/internal/config"
+)
 
 // GetServiceNames returns service names for allowed service IDs
 // If allowedServiceIDs is empty, returns all enabled service names
@@ -17,11 +22,22 @@ func GetServiceNames(cfg *config.ApplicationConfig, allowedServiceIDs []string)
 	}
 
 	// Return specific service names (pre-allocate for efficiency)
+	allowedServiceIDs = config.ExpandServiceGroups(cfg.ServiceGroups, allowedServiceIDs)
 	names := make([]string, 0, len(allowedServiceIDs))
 	for _, allowedID := range allowedServiceIDs {
+		if tag, ok := strings.CutPrefix(allowedID, config.TagSelectorPrefix); ok {
+			for _, svc := range cfg.ProtectedServices {
+				if svc.Enabled && slices.Contains(svc.Tags, tag) && !slices.Contains(names, svc.ServiceName) {
+					names = append(names, svc.ServiceName)
+				}
+			}
+			continue
+		}
 		for _, svc := range cfg.ProtectedServices {
 			if svc.ServiceID == allowedID && svc.Enabled {
-				names = append(names, svc.ServiceName)
+				if !slices.Contains(names, svc.ServiceName) {
+					names = append(names, svc.ServiceName)
+				}
 				break
 			}
 		}
@@ -29,6 +45,19 @@ func GetServiceNames(cfg *config.ApplicationConfig, allowedServiceIDs []string)
 	return names
 }
 
+// GetAllowedServiceIDs returns the IDs of the enabled services an allowed_service_ids list grants,
+// resolving service groups and tag selectors; an empty list grants every enabled service
+func GetAllowedServiceIDs(cfg *config.ApplicationConfig, allowedServiceIDs []string) []string {
+	allowedServiceIDs = config.ExpandServiceGroups(cfg.ServiceGroups, allowedServiceIDs)
+	ids := make([]string, 0, len(cfg.ProtectedServices))
+	for _, svc := range cfg.ProtectedServices {
+		if svc.Enabled && config.ServiceAllowed(allowedServiceIDs, svc.ServiceID, svc.Tags) {
+			ids = append(ids, svc.ServiceID)
+		}
+	}
+	return ids
+}
+
 // GetServiceByID retrieves a service configuration by its ID
 // Returns nil if service not found
 func GetServiceByID(cfg *config.ApplicationConfig, serviceID string) *config.ProtectedServiceConfig {
@@ -0,0 +1,79 @@
+import { API_BASE_URL } from '$lib/config';
+
+// Admin access tokens are short-lived. The refresh token lives in an HttpOnly cookie scoped to
+// /api/admin, so scripts never see it; a new access token is fetched shortly before the old one expires.
+
+interface AdminTokens {
+	jwt_access_token: string;
+	token_expires_at: string;
+}
+
+// How long before expiry the access token is refreshed
+const REFRESH_MARGIN_MS = 60_000;
+
+export function storeAdminTokens(tokens: AdminTokens) {
+	localStorage.setItem('admin_token', tokens.jwt_access_token);
+	localStorage.setItem('admin_token_expires_at', tokens.token_expires_at);
+}
+
+export function clearAdminTokens() {
+	localStorage.removeItem('admin_token');
+	localStorage.removeItem('admin_token_expires_at');
+}
+
+// refreshAdminToken exchanges the refresh cookie for a new access token
+export async function refreshAdminToken(): Promise<boolean> {
+	try {
+		const response = await fetch(`${API_BASE_URL}/api/admin/refresh`, {
+			method: 'POST',
+			credentials: 'include'
+		});
+		if (!response.ok) {
+			return false;
+		}
+
+		const data = await response.json();
+		storeAdminTokens(data.data);
+		return true;
+	} catch (err) {
+		console.error('Failed to refresh admin token:', err);
+		return false;
+	}
+}
+
+// startAdminTokenRefresh keeps the access token fresh; onExpired runs when the session has ended
+// Returns a function that stops refreshing
+export function startAdminTokenRefresh(onExpired: () => void): () => void {
+	let timer: ReturnType<typeof setTimeout> | undefined;
+
+	const schedule = () => {
+		const expiresAt = new Date(localStorage.getItem('admin_token_expires_at') ?? 0).getTime();
+		const delay = Math.max(expiresAt - Date.now() - REFRESH_MARGIN_MS, 0);
+		timer = setTimeout(async () => {
+			if (await refreshAdminToken()) {
+				schedule();
+			} else {
+				clearAdminTokens();
+				onExpired();
+			}
+		}, delay);
+	};
+	schedule();
+
+	return () => clearTimeout(timer);
+}
+
+// logoutAdmin revokes the admin session on the server, then forgets the tokens
+export async function logoutAdmin() {
+	const token = localStorage.getItem('admin_token');
+	try {
+		await fetch(`${API_BASE_URL}/api/admin/logout`, {
+			method: 'POST',
+			credentials: 'include',
+			headers: token ? { Authorization: `Bearer ${token}` } : {}
+		});
+	} catch (err) {
+		console.error('Failed to log out:', err);
+	}
+	clearAdminTokens();
+}
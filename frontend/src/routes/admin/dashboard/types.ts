@@ -26,6 +26,44 @@ export interface Connection {
 	total_bytes_tx: number;
 	total_sessions: number;
 	services: ServiceStats[];
+	location?: Location | null;
+}
+
+// A single proxied connection or UDP session, as listed by /api/admin/connections/individual
+// and streamed by /api/admin/connections/live
+export interface LiveConnection {
+	id: string;
+	service_id: string;
+	service_name: string;
+	protocol: string;
+	client_addr: string;
+	client_ip: string;
+	client_port: number;
+	started_at: string;
+	duration_seconds: number;
+	packets_received: number;
+	packets_sent: number;
+	bytes_received: number;
+	bytes_sent: number;
+}
+
+export interface ConnectionChange {
+	type: 'opened' | 'updated' | 'closed';
+	connection: LiveConnection;
+}
+
+export interface LiveMessage {
+	type: 'snapshot' | 'changes' | 'keepalive';
+	connections?: LiveConnection[];
+	changes?: ConnectionChange[];
+}
+
+export interface Location {
+	country_code?: string;
+	country?: string;
+	city?: string;
+	asn?: number;
+	organization?: string;
 }
 
 export interface ServiceStats {
@@ -36,6 +74,20 @@ export interface ServiceStats {
 	bytes_received: number;
 	bytes_sent: number;
 	active_sessions: number;
+	quality?: UDPQuality; // UDP only
+}
+
+// Packet timing of the worst UDP session of an IP, measured at the portal
+export interface UDPQuality {
+	client: UDPFlowQuality;
+	backend: UDPFlowQuality;
+	response_ms: number;
+}
+
+export interface UDPFlowQuality {
+	jitter_ms: number;
+	max_silence_ms: number;
+	silent_ms: number;
 }
 
 export interface IPStats {
@@ -60,6 +112,7 @@ export interface Config {
 		blocked_ip_addresses: string[];
 		allowed_dynamic_dns_hostnames: string[];
 		permanently_allowed_ip_ranges: string[];
+		hairpin_lan_ranges?: string[];
 		dns_refresh_interval_seconds: number;
 	};
 	proxy_server_config: {
@@ -76,10 +129,17 @@ export interface Config {
 		trusted_proxy_ip_ranges: string[];
 		client_ip_header_priority: string[];
 	};
+	admin_accounts: AdminAccount[];
 	portal_user_accounts: PortalUser[];
 	protected_services: ProtectedService[];
 }
 
+export interface AdminAccount {
+	username: string;
+	bcrypt_hashed_password: string;
+	notes: string;
+}
+
 export interface PortalUser {
 	user_id: string;
 	username: string;
@@ -87,6 +147,7 @@ export interface PortalUser {
 	bcrypt_hashed_password: string;
 	allowed_service_ids: string[];
 	notes: string;
+	must_change_password?: boolean;
 }
 
 export interface ProtectedService {
@@ -94,6 +155,8 @@ export interface ProtectedService {
 	service_name: string;
 	proxy_listen_port_start: number;
 	proxy_listen_port_end: number;
+	proxy_listen_address?: string; // Empty = all addresses
+	additional_listeners?: ListenerConfig[];
 	backend_target_host: string;
 	backend_target_port: number;
 	transport_protocol: string;
@@ -103,6 +166,12 @@ export interface ProtectedService {
 	http_config: HTTPConfig | null;
 }
 
+// A further address and port a service accepts clients on
+export interface ListenerConfig {
+	address: string;
+	port: number;
+}
+
 export interface HTTPConfig {
 	inject_http_request_headers?: Record<string, string>;
 	override_http_request_headers?: Record<string, string>;